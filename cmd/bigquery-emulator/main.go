@@ -7,10 +7,11 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/goccy/bigquery-emulator/server"
-	"github.com/goccy/bigquery-emulator/types"
 	"github.com/jessevdk/go-flags"
 )
 
@@ -18,13 +19,44 @@ type option struct {
 	Project      string           `description:"specify the project name" long:"project"`
 	Dataset      string           `description:"specify the dataset name" long:"dataset"`
 	Host         string           `description:"specify the host" long:"host" default:"0.0.0.0"`
-	HTTPPort     uint16           `description:"specify the http port number. this port used by bigquery api" long:"port" default:"9050"`
-	GRPCPort     uint16           `description:"specify the grpc port number. this port used by bigquery storage api" long:"grpc-port" default:"9060"`
+	HTTPPort     uint16           `description:"specify the http port number. this port used by bigquery api. 0 means the port chosen by the OS" long:"port" default:"9050"`
+	GRPCPort     uint16           `description:"specify the grpc port number. this port used by bigquery storage api. 0 means the port chosen by the OS" long:"grpc-port" default:"9060"`
 	LogLevel     server.LogLevel  `description:"specify the log level (debug/info/warn/error)" long:"log-level" default:"error"`
 	LogFormat    server.LogFormat `description:"specify the log format (console/json)" long:"log-format" default:"console"`
 	Database     string           `description:"specify the database file if required. if not specified, it will be on memory" long:"database"`
 	DataFromYAML string           `description:"specify the path to the YAML file that contains the initial data" long:"data-from-yaml"`
+	DatasetsDir  string           `description:"specify the directory that contains the dataset directories of the table files to load on startup" long:"datasets-dir"`
+	Location     string           `description:"specify the default location of the datasets" long:"location" default:"US"`
 	Version      bool             `description:"print version" long:"version" short:"v"`
+
+	DatabaseJournalMode server.JournalMode     `description:"specify the journal mode of the database file (DELETE/TRUNCATE/PERSIST/MEMORY/WAL/OFF)" long:"database-journal-mode" default:"DELETE"`
+	DatabaseSynchronous server.SynchronousMode `description:"specify the synchronous level of the database file (OFF/NORMAL/FULL/EXTRA)" long:"database-synchronous" default:"FULL"`
+	DatabaseBusyTimeout time.Duration          `description:"specify the time to wait for the lock of the database file" long:"database-busy-timeout" default:"5s"`
+	DatabaseReadOnly    bool                   `description:"open the existing database file as read-only" long:"database-read-only"`
+	ShutdownGracePeriod time.Duration          `description:"specify the time to wait for the in-flight requests on shutdown" long:"shutdown-grace-period" default:"30s"`
+
+	DifferentialPrivacyPassthrough bool   `description:"run the queries with the differential privacy clause as the ordinary aggregations" long:"differential-privacy-passthrough"`
+	GRPCReflection                 bool   `description:"enable the grpc server reflection to discover the bigquery storage api services" long:"grpc-reflection"`
+	LogRedactQuery                 bool   `description:"replace the literal values of the sql written to the log with ?" long:"log-redact-query"`
+	GenerativeAIResponse           string `description:"specify the canned response of ML.GENERATE_TEXT, AI.GENERATE_TEXT and AI.GENERATE. {prompt} is replaced with the prompt" long:"generative-ai-response"`
+
+	MaxResultRows  int64 `description:"specify the maximum number of rows that a query can return. 0 means no limit" long:"max-result-rows" default:"0"`
+	MaxResultBytes int64 `description:"specify the maximum bytes of the result that a query can return. 0 means no limit" long:"max-result-bytes" default:"0"`
+
+	ReadOnly   bool   `description:"reject the requests that change the data or the metadata. queries and storage reads keep working" long:"read-only"`
+	RandomSeed *int64 `description:"specify the seed to make GENERATE_UUID and RAND return the same values for the same query" long:"random-seed"`
+
+	Config string `description:"specify the YAML file of the projects, the initial data and the server settings. the flags override the settings of the file" long:"config"`
+
+	AutoCreateProject    bool `description:"create the project and the dataset of --dataset on the first request referencing the unknown project. --project is optional with this flag" long:"auto-create-project"`
+	EnforceDatasetAccess bool `description:"check the queries with the access entries of the datasets. the user is given by the X-Bigquery-Emulator-User header" long:"enforce-dataset-access"`
+
+	InitSQL             string        `description:"specify the SQL file or the inline SQL to run in the project of --project after the initial data is loaded" long:"init-sql"`
+	InitTimeout         time.Duration `description:"specify the time limit of --init-sql. 0 means no limit" long:"init-timeout" default:"1m"`
+	InitContinueOnError bool          `description:"log the failed statement of --init-sql and run the next one instead of aborting the startup" long:"init-continue-on-error"`
+
+	// isSet reports whether the flag of the long name is specified in the command line.
+	isSet func(name string) bool
 }
 
 type exitCode int
@@ -67,35 +99,135 @@ func parseOpt() ([]string, option, error) {
 	var opt option
 	parser := flags.NewParser(&opt, flags.Default)
 	args, err := parser.Parse()
+	opt.isSet = func(name string) bool {
+		option := parser.FindOptionByLongName(name)
+		return option != nil && option.IsSet() && !option.IsSetDefault()
+	}
 	return args, opt, err
 }
 
+// mergeConfig merges the settings of the configuration file and the flags.
+// The flag specified in the command line overrides the setting of the file,
+// and the default value of the flag is used if the file doesn't have the setting either.
+// The boolean setting enabled by either of them is enabled.
+func mergeConfig(opt *option, cfg *server.Config) {
+	mergeString := func(name string, flagValue string, cfgValue *string) {
+		if opt.isSet(name) || *cfgValue == "" {
+			*cfgValue = flagValue
+		}
+	}
+	mergeString("project", opt.Project, &cfg.Project)
+	mergeString("dataset", opt.Dataset, &cfg.Dataset)
+	mergeString("location", opt.Location, &cfg.Location)
+	mergeString("datasets-dir", opt.DatasetsDir, &cfg.DatasetsDir)
+	if opt.DataFromYAML != "" {
+		cfg.DataFromYAML = append(cfg.DataFromYAML, opt.DataFromYAML)
+	}
+
+	c := cfg.Server
+	if !opt.isSet("host") && c.Host != "" {
+		opt.Host = c.Host
+	}
+	if !opt.isSet("port") && c.Port != 0 {
+		opt.HTTPPort = c.Port
+	}
+	if !opt.isSet("grpc-port") && c.GRPCPort != 0 {
+		opt.GRPCPort = c.GRPCPort
+	}
+	if !opt.isSet("log-level") && c.LogLevel != "" {
+		opt.LogLevel = c.LogLevel
+	}
+	if !opt.isSet("log-format") && c.LogFormat != "" {
+		opt.LogFormat = c.LogFormat
+	}
+	if !opt.isSet("database") && c.Database != "" {
+		opt.Database = c.Database
+	}
+	if !opt.isSet("shutdown-grace-period") && c.ShutdownGracePeriod != 0 {
+		opt.ShutdownGracePeriod = time.Duration(c.ShutdownGracePeriod)
+	}
+	if !opt.isSet("max-result-rows") && c.MaxResultRows != 0 {
+		opt.MaxResultRows = c.MaxResultRows
+	}
+	if !opt.isSet("max-result-bytes") && c.MaxResultBytes != 0 {
+		opt.MaxResultBytes = c.MaxResultBytes
+	}
+	if !opt.isSet("database-journal-mode") && c.DatabaseJournalMode != "" {
+		opt.DatabaseJournalMode = c.DatabaseJournalMode
+	}
+	if !opt.isSet("database-synchronous") && c.DatabaseSynchronous != "" {
+		opt.DatabaseSynchronous = c.DatabaseSynchronous
+	}
+	if !opt.isSet("database-busy-timeout") && c.DatabaseBusyTimeout != 0 {
+		opt.DatabaseBusyTimeout = time.Duration(c.DatabaseBusyTimeout)
+	}
+	if !opt.isSet("generative-ai-response") && c.GenerativeAIResponse != "" {
+		opt.GenerativeAIResponse = c.GenerativeAIResponse
+	}
+	if !opt.isSet("init-sql") && c.InitSQL != "" {
+		opt.InitSQL = c.InitSQL
+	}
+	if !opt.isSet("init-timeout") && c.InitTimeout != nil {
+		opt.InitTimeout = time.Duration(*c.InitTimeout)
+	}
+	if c.ReadOnly {
+		opt.ReadOnly = true
+	}
+	if c.AutoCreateProject {
+		opt.AutoCreateProject = true
+	}
+	if c.DatabaseReadOnly {
+		opt.DatabaseReadOnly = true
+	}
+	if c.DifferentialPrivacyPassthrough {
+		opt.DifferentialPrivacyPassthrough = true
+	}
+	if c.GRPCReflection {
+		opt.GRPCReflection = true
+	}
+	if c.LogRedactQuery {
+		opt.LogRedactQuery = true
+	}
+	if c.EnforceDatasetAccess {
+		opt.EnforceDatasetAccess = true
+	}
+	if c.InitContinueOnError {
+		opt.InitContinueOnError = true
+	}
+	if opt.RandomSeed == nil {
+		opt.RandomSeed = c.RandomSeed
+	}
+}
+
 func runServer(args []string, opt option) error {
 	if opt.Version {
 		fmt.Fprintf(os.Stdout, "version: %s (%s)\n", version, revision)
 		return nil
 	}
-	if opt.Project == "" {
-		return fmt.Errorf("the required flag --project was not specified")
+	cfg := &server.Config{}
+	if opt.Config != "" {
+		loaded, err := server.LoadConfig(opt.Config)
+		if err != nil {
+			return err
+		}
+		cfg = loaded
 	}
-	var db server.Storage
-	if opt.Database == "" {
-		db = server.TempStorage
-	} else {
-		db = server.Storage(fmt.Sprintf("file:%s?cache=shared", opt.Database))
+	mergeConfig(&opt, cfg)
+	if cfg.Project == "" && !opt.AutoCreateProject {
+		return fmt.Errorf("the required flag --project was not specified. specify --auto-create-project to create the project on the first request")
 	}
-	project := types.NewProject(opt.Project)
-	if opt.Dataset != "" {
-		project.Datasets = append(project.Datasets, types.NewDataset(opt.Dataset))
+	if cfg.DatasetsDir != "" && cfg.Project == "" {
+		return fmt.Errorf("--datasets-dir requires --project")
 	}
-	bqServer, err := server.New(db)
-	if err != nil {
-		return err
+	if opt.InitSQL != "" && cfg.Project == "" {
+		return fmt.Errorf("--init-sql requires --project")
 	}
-	if err := bqServer.SetProject(project.ID); err != nil {
+	db, err := storage(opt, cfg)
+	if err != nil {
 		return err
 	}
-	if err := bqServer.Load(server.StructSource(project)); err != nil {
+	bqServer, err := server.New(db)
+	if err != nil {
 		return err
 	}
 	if err := bqServer.SetLogLevel(opt.LogLevel); err != nil {
@@ -104,10 +236,39 @@ func runServer(args []string, opt option) error {
 	if err := bqServer.SetLogFormat(opt.LogFormat); err != nil {
 		return err
 	}
-	if opt.DataFromYAML != "" {
-		if err := bqServer.Load(server.YAMLSource(opt.DataFromYAML)); err != nil {
+	if opt.DatabaseReadOnly {
+		if err := bqServer.SetDefaultLocation(cfg.Location); err != nil {
 			return err
 		}
+	} else if err := bqServer.Load(server.ConfigSource(cfg)); err != nil {
+		return err
+	}
+	bqServer.SetShutdownGracePeriod(opt.ShutdownGracePeriod)
+	bqServer.SetDifferentialPrivacyPassthrough(opt.DifferentialPrivacyPassthrough)
+	bqServer.SetGRPCReflection(opt.GRPCReflection)
+	bqServer.SetLogQueryRedaction(opt.LogRedactQuery)
+	bqServer.SetGenerativeAIResponse(opt.GenerativeAIResponse)
+	bqServer.SetMaxResultRows(opt.MaxResultRows)
+	bqServer.SetMaxResultBytes(opt.MaxResultBytes)
+	bqServer.SetReadOnly(opt.ReadOnly)
+	bqServer.SetAutoCreateProject(opt.AutoCreateProject)
+	bqServer.SetAutoCreateDataset(cfg.Dataset)
+	bqServer.SetDatasetAccessEnforcement(opt.EnforceDatasetAccess)
+	if opt.RandomSeed != nil {
+		bqServer.SetRandomSeed(*opt.RandomSeed)
+	}
+	// the init SQL runs with the settings of the queries like --random-seed after the data of --data-from-yaml is loaded.
+	if opt.InitSQL != "" {
+		query, err := initSQL(opt.InitSQL)
+		if err != nil {
+			return err
+		}
+		if err := bqServer.Load(server.InitSQLSource(cfg.Project, query, &server.InitSQLOption{
+			Timeout:         opt.InitTimeout,
+			ContinueOnError: opt.InitContinueOnError,
+		})); err != nil {
+			return fmt.Errorf("failed to run --init-sql: %w", err)
+		}
 	}
 
 	ctx := context.Background()
@@ -124,12 +285,17 @@ func runServer(args []string, opt option) error {
 		}
 	}()
 
+	httpAddr := fmt.Sprintf("%s:%d", opt.Host, opt.HTTPPort)
+	grpcAddr := fmt.Sprintf("%s:%d", opt.Host, opt.GRPCPort)
+	if err := bqServer.Listen(httpAddr, grpcAddr); err != nil {
+		return err
+	}
+	// print the bound addresses because the port 0 is replaced with the port chosen by the OS.
+	fmt.Fprintf(os.Stdout, "[bigquery-emulator] REST server listening at %s\n", bqServer.HTTPAddr())
+	fmt.Fprintf(os.Stdout, "[bigquery-emulator] gRPC server listening at %s\n", bqServer.GRPCAddr())
+
 	done := make(chan error)
 	go func() {
-		httpAddr := fmt.Sprintf("%s:%d", opt.Host, opt.HTTPPort)
-		grpcAddr := fmt.Sprintf("%s:%d", opt.Host, opt.GRPCPort)
-		fmt.Fprintf(os.Stdout, "[bigquery-emulator] REST server listening at %s\n", httpAddr)
-		fmt.Fprintf(os.Stdout, "[bigquery-emulator] gRPC server listening at %s\n", grpcAddr)
 		done <- bqServer.Serve(ctx, httpAddr, grpcAddr)
 	}()
 
@@ -143,3 +309,55 @@ func runServer(args []string, opt option) error {
 
 	return nil
 }
+
+func storage(opt option, cfg *server.Config) (server.Storage, error) {
+	if opt.Database == "" {
+		if opt.DatabaseReadOnly {
+			return "", fmt.Errorf("--database-read-only requires --database")
+		}
+		return server.TempStorage, nil
+	}
+	if opt.DatabaseReadOnly {
+		if _, err := os.Stat(opt.Database); err != nil {
+			return "", fmt.Errorf("failed to open the read-only database: %w", err)
+		}
+		if len(cfg.DataFromYAML) != 0 {
+			return "", fmt.Errorf("--data-from-yaml cannot be used with --database-read-only")
+		}
+		if cfg.DatasetsDir != "" {
+			return "", fmt.Errorf("--datasets-dir cannot be used with --database-read-only")
+		}
+		if len(cfg.Projects) != 0 {
+			return "", fmt.Errorf("the projects of --config cannot be used with --database-read-only")
+		}
+	}
+	storageOpt := &server.FileStorageOption{
+		Synchronous: opt.DatabaseSynchronous,
+		BusyTimeout: opt.DatabaseBusyTimeout,
+		ReadOnly:    opt.DatabaseReadOnly,
+	}
+	if !opt.DatabaseReadOnly {
+		storageOpt.JournalMode = opt.DatabaseJournalMode
+	}
+	return server.FileStorage(opt.Database, storageOpt)
+}
+
+// initSQL returns the content of the file if value is the path to the file, otherwise value itself as the inline SQL.
+// value without the whitespace is the path because the SQL has the whitespace, so the missing file is reported as the error.
+func initSQL(value string) (string, error) {
+	info, err := os.Stat(value)
+	if err != nil {
+		if strings.ContainsAny(value, " \t\r\n") {
+			return value, nil
+		}
+		return "", fmt.Errorf("failed to read --init-sql: %w", err)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("failed to read --init-sql: %s is a directory", value)
+	}
+	content, err := os.ReadFile(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to read --init-sql: %w", err)
+	}
+	return string(content), nil
+}
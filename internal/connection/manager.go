@@ -4,8 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"reflect"
+	"unsafe"
 
 	"github.com/goccy/go-zetasqlite"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Manager struct {
@@ -41,6 +44,88 @@ func (t *Tx) Tx() *sql.Tx {
 	return t.tx
 }
 
+// SQLiteTx returns the transaction of SQLite that go-zetasqlite runs the statements of t in.
+// It is used to run the SQLite statements that ZetaSQL can't express, like the temporary triggers,
+// on the same connection and transaction as the statements of t.
+// go-zetasqlite doesn't expose the transaction, so it is read from the unexported field of ZetaSQLiteConn.
+func (t *Tx) SQLiteTx() (*sql.Tx, error) {
+	var sqliteTx *sql.Tx
+	if err := t.conn.Conn.Raw(func(c interface{}) error {
+		zetasqliteConn, ok := c.(*zetasqlite.ZetaSQLiteConn)
+		if !ok {
+			return fmt.Errorf("failed to get ZetaSQLiteConn from %T", c)
+		}
+		field := reflect.ValueOf(zetasqliteConn).Elem().FieldByName("tx")
+		if !field.IsValid() || field.Type() != reflect.TypeOf(sqliteTx) {
+			return fmt.Errorf("failed to get sqlite transaction from %T", c)
+		}
+		sqliteTx = *(**sql.Tx)(unsafe.Pointer(field.UnsafeAddr()))
+		if sqliteTx == nil {
+			return fmt.Errorf("sqlite transaction is not started")
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get sqlite transaction: %w", err)
+	}
+	return sqliteTx, nil
+}
+
+// RegisterFunction registers fn as the SQLite function of name on the SQLite connection that go-zetasqlite runs
+// the statements of t in. It replaces the function of the same name that go-zetasqlite registered, like zetasqlite_rand,
+// only on the connection, so the statements of the other connections keep calling the original one.
+// go-zetasqlite doesn't expose the connection, so it is read from the unexported field of ZetaSQLiteConn.
+func (t *Tx) RegisterFunction(name string, fn interface{}) error {
+	if err := t.conn.Conn.Raw(func(c interface{}) error {
+		zetasqliteConn, ok := c.(*zetasqlite.ZetaSQLiteConn)
+		if !ok {
+			return fmt.Errorf("failed to get ZetaSQLiteConn from %T", c)
+		}
+		field := reflect.ValueOf(zetasqliteConn).Elem().FieldByName("conn")
+		if !field.IsValid() || field.Type() != reflect.TypeOf((*sql.Conn)(nil)) {
+			return fmt.Errorf("failed to get sqlite connection from %T", c)
+		}
+		conn := *(**sql.Conn)(unsafe.Pointer(field.UnsafeAddr()))
+		return conn.Raw(func(c interface{}) error {
+			sqliteConn, ok := c.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("failed to get SQLiteConn from %T", c)
+			}
+			return sqliteConn.RegisterFunc(name, fn, false)
+		})
+	}); err != nil {
+		return fmt.Errorf("failed to register function %s: %w", name, err)
+	}
+	return nil
+}
+
+// statementSavepoint is the savepoint of SQLite that RunStatement rolls back to.
+// The nested savepoints of the same name are rolled back and released from the innermost one.
+const statementSavepoint = "bqemulator_statement"
+
+// RunStatement runs fn as the statement of t that is rolled back alone if fn returns the error,
+// so the failed statement leaves the tables as they were before it while the former statements of t are kept.
+// It is the savepoint of SQLite, because go-zetasqlite doesn't support the nested transactions.
+func (t *Tx) RunStatement(ctx context.Context, fn func() error) error {
+	sqliteTx, err := t.SQLiteTx()
+	if err != nil {
+		return err
+	}
+	if _, err := sqliteTx.ExecContext(ctx, "SAVEPOINT "+statementSavepoint); err != nil {
+		return fmt.Errorf("failed to start statement: %w", err)
+	}
+	if err := fn(); err != nil {
+		if _, rollbackErr := sqliteTx.ExecContext(ctx, "ROLLBACK TO "+statementSavepoint); rollbackErr != nil {
+			return fmt.Errorf("failed to roll back statement: %w: %v", err, rollbackErr)
+		}
+		_, _ = sqliteTx.ExecContext(ctx, "RELEASE "+statementSavepoint)
+		return err
+	}
+	if _, err := sqliteTx.ExecContext(ctx, "RELEASE "+statementSavepoint); err != nil {
+		return fmt.Errorf("failed to finish statement: %w", err)
+	}
+	return nil
+}
+
 func (t *Tx) RollbackIfNotCommitted() error {
 	if t.committed {
 		return nil
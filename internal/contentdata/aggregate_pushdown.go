@@ -0,0 +1,210 @@
+package contentdata
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/logger"
+)
+
+// pushdownComparisonOps are the comparison operators of the filters of the pushed down COUNT(*).
+var pushdownComparisonOps = map[string]string{
+	"=": "=", "!=": "!=", "<>": "!=", "<": "<", "<=": "<=", ">": ">", ">=": ">=",
+}
+
+// aggregatePushdown is the query like `SELECT COUNT(*) FROM table WHERE column = 1` that is counted
+// without the rewriters and the type probes of the query.
+type aggregatePushdown struct {
+	path []string
+	// alias is the alias of COUNT(*), or empty if it has no alias.
+	alias   string
+	filters []*pushdownFilter
+}
+
+// pushdownFilter is `column <op> literal`, `column IS NULL` or `column IS NOT NULL` of the WHERE clause.
+type pushdownFilter struct {
+	column string
+	op     string
+	// value is the literal of INT64 or BOOL, and empty for IS [NOT] NULL.
+	value string
+}
+
+// pushdownAggregates replaces COUNT(*) of the query like `SELECT COUNT(*) FROM table [WHERE filter]` with its value.
+// The rows are counted by go-zetasqlite in the transaction of tx, so the rows not committed yet by the previous statements
+// are counted too, and the views are expanded same as the other queries.
+// The query that can't be pushed down, or whose count fails, is returned as it is:
+//   - the query having the other clauses, the joins, the subqueries and the parameters.
+//   - the aggregates other than COUNT(*).
+//   - the filters other than IS [NOT] NULL and the comparisons with the INT64 and BOOL literals joined by AND.
+func (r *Repository) pushdownAggregates(ctx context.Context, tx *connection.Tx, query string) string {
+	pushdown := parseAggregatePushdown(query)
+	if pushdown == nil {
+		return query
+	}
+	table := "`" + strings.Join(pushdown.path, ".") + "`"
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s", table)
+	if len(pushdown.filters) != 0 {
+		filters := make([]string, 0, len(pushdown.filters))
+		for _, filter := range pushdown.filters {
+			if filter.value == "" {
+				filters = append(filters, fmt.Sprintf("`%s` %s", filter.column, filter.op))
+				continue
+			}
+			filters = append(filters, fmt.Sprintf("`%s` %s %s", filter.column, filter.op, filter.value))
+		}
+		countQuery += " WHERE " + strings.Join(filters, " AND ")
+	}
+	var count int64
+	if err := tx.Tx().QueryRowContext(ctx, countQuery).Scan(&count); err != nil {
+		logger.Logger(ctx).Debug("aggregate pushdown falls back", zap.String("table", table), zap.Error(err))
+		return query
+	}
+	logger.Logger(ctx).Debug("aggregate pushdown", zap.String("table", table))
+	column := strconv.FormatInt(count, 10)
+	if pushdown.alias != "" {
+		column += fmt.Sprintf(" AS `%s`", pushdown.alias)
+	}
+	return "SELECT " + column
+}
+
+// parseAggregatePushdown parses `SELECT COUNT(*) [[AS] alias] FROM path [[AS] alias] [WHERE filter AND ...]`.
+// It returns nil if query is not the form.
+func parseAggregatePushdown(query string) *aggregatePushdown {
+	tokens := statementTokens(query)
+	if len(tokens) == 0 || !tokens[0].isKeyword("SELECT") {
+		return nil
+	}
+	p := &statementParser{tokens: tokens, idx: 1}
+	alias, ok := p.countStar()
+	if !ok {
+		return nil
+	}
+	pushdown := &aggregatePushdown{alias: alias}
+	if !p.consumeKeywords("FROM") {
+		return nil
+	}
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil
+	}
+	pushdown.path = path
+	tableAlias := p.tableAlias(path[len(path)-1])
+	if p.consumeKeywords("WHERE") {
+		for {
+			filter := p.pushdownFilter(tableAlias)
+			if filter == nil {
+				return nil
+			}
+			pushdown.filters = append(pushdown.filters, filter)
+			if !p.consumeKeywords("AND") {
+				break
+			}
+		}
+	}
+	if !p.eof() {
+		return nil
+	}
+	return pushdown
+}
+
+// countStar parses `COUNT(*)` with the optional alias, and returns the alias.
+func (p *statementParser) countStar() (string, bool) {
+	if tk := p.next(); !tk.isKeyword("COUNT") || !p.consumeSymbol("(") || !p.consumeSymbol("*") || !p.consumeSymbol(")") {
+		return "", false
+	}
+	if p.consumeKeywords("AS") {
+		if tk := p.peek(); tk.kind != tokenWord && tk.kind != tokenQuotedIdent {
+			return "", false
+		}
+		return strings.Trim(p.next().text, "`"), true
+	}
+	if tk := p.peek(); tk.kind == tokenQuotedIdent || (tk.kind == tokenWord && !isReservedKeyword(tk)) {
+		return strings.Trim(p.next().text, "`"), true
+	}
+	return "", true
+}
+
+// pushdownFilter parses `column <op> literal` or `column IS [NOT] NULL` referring to the table of alias.
+func (p *statementParser) pushdownFilter(alias string) *pushdownFilter {
+	column, ok := unqualifiedColumn(p.pushdownColumn(), alias)
+	if !ok {
+		return nil
+	}
+	filter := &pushdownFilter{column: column}
+	if p.consumeKeywords("IS", "NOT", "NULL") {
+		filter.op = "IS NOT NULL"
+		return filter
+	}
+	if p.consumeKeywords("IS", "NULL") {
+		filter.op = "IS NULL"
+		return filter
+	}
+	tk := p.next()
+	op, exists := pushdownComparisonOps[tk.text]
+	if tk.kind != tokenSymbol || !exists {
+		return nil
+	}
+	filter.op = op
+	switch lit := p.next(); {
+	case lit.isKeyword("TRUE"), lit.isKeyword("FALSE"):
+		filter.value = strings.ToUpper(lit.text)
+	case lit.isSymbol("-") && p.peek().kind == tokenNumber:
+		v, err := strconv.ParseInt("-"+p.next().text, 0, 64)
+		if err != nil {
+			return nil
+		}
+		filter.value = strconv.FormatInt(v, 10)
+	case lit.kind == tokenNumber:
+		v, err := strconv.ParseInt(lit.text, 0, 64)
+		if err != nil {
+			return nil
+		}
+		filter.value = strconv.FormatInt(v, 10)
+	default:
+		return nil
+	}
+	return filter
+}
+
+// pushdownColumn parses the column name optionally qualified by the table like `t.column`.
+// It returns the name joined by dot, or empty if the next tokens are not the name.
+func (p *statementParser) pushdownColumn() string {
+	var names []string
+	for {
+		tk := p.peek()
+		if tk.kind == tokenQuotedIdent {
+			names = append(names, strings.Trim(tk.text, "`"))
+		} else if tk.kind == tokenWord && !isReservedKeyword(tk) {
+			names = append(names, tk.text)
+		} else {
+			return ""
+		}
+		p.next()
+		if !p.consumeSymbol(".") {
+			break
+		}
+	}
+	return strings.Join(names, ".")
+}
+
+// unqualifiedColumn returns the name of the column removing the qualifier that must be the alias of the table.
+func unqualifiedColumn(column, alias string) (string, bool) {
+	if column == "" {
+		return "", false
+	}
+	names := strings.Split(column, ".")
+	switch len(names) {
+	case 1:
+		return names[0], true
+	case 2:
+		if strings.EqualFold(names[0], alias) {
+			return names[1], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,73 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AlterTableStatement is the ALTER TABLE SET OPTIONS or ALTER TABLE RENAME TO statement.
+// go-zetasqlite doesn't support ALTER TABLE, so the emulator evaluates the statements with the metadata.
+type AlterTableStatement struct {
+	IfExists bool
+	// TablePath is the name of the table split by the dot. It has one to three elements.
+	TablePath []string
+	// Options are the options of SET OPTIONS. It is nil for RENAME TO.
+	Options []*Option
+	// RenameTo is the new name of the table of RENAME TO.
+	RenameTo string
+}
+
+// ParseAlterTableStatement parses query as ALTER TABLE SET OPTIONS or ALTER TABLE RENAME TO statement.
+// It returns nil without error when query is not ALTER TABLE statement,
+// and returns the error for the other actions of ALTER TABLE that the emulator doesn't support.
+func ParseAlterTableStatement(query string) (*AlterTableStatement, error) {
+	tokens := statementTokens(query)
+	if tokens == nil {
+		return nil, nil
+	}
+	p := &statementParser{tokens: tokens}
+	if !p.consumeKeywords("ALTER", "TABLE") {
+		return nil, nil
+	}
+	stmt := &AlterTableStatement{}
+	stmt.IfExists = p.consumeKeywords("IF", "EXISTS")
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil, err
+	}
+	if len(path) > 3 {
+		return nil, fmt.Errorf("invalid table name %s", strings.Join(path, "."))
+	}
+	stmt.TablePath = path
+	switch {
+	case p.consumeKeywords("SET", "OPTIONS"):
+		options, err := p.options()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Options = options
+		if stmt.Options == nil {
+			stmt.Options = []*Option{}
+		}
+	case p.consumeKeywords("RENAME", "TO"):
+		newPath, err := p.pathExpression()
+		if err != nil {
+			return nil, err
+		}
+		if len(newPath) != 1 {
+			return nil, fmt.Errorf("ALTER TABLE RENAME TO cannot move the table to another dataset: %s", strings.Join(newPath, "."))
+		}
+		stmt.RenameTo = newPath[0]
+	default:
+		return nil, fmt.Errorf("ALTER TABLE %s is not supported by the emulator", p.peek().text)
+	}
+	if !p.eof() {
+		return nil, fmt.Errorf("syntax error: unexpected %s", p.peek().text)
+	}
+	return stmt, nil
+}
+
+// isAlterTableStatement reports whether tokens are ALTER TABLE statement.
+func isAlterTableStatement(tokens []*token) bool {
+	return len(tokens) > 1 && tokens[0].isKeyword("ALTER") && tokens[1].isKeyword("TABLE")
+}
@@ -0,0 +1,218 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DDLStatement is the CREATE or DROP statement of TABLE, VIEW, MATERIALIZED VIEW, FUNCTION or PROCEDURE.
+// go-zetasqlite runs the statements, but it replaces the existing object by CREATE ... IF NOT EXISTS
+// and fails to drop the missing object by DROP ... IF EXISTS, so the emulator evaluates the guards beforehand.
+// go-zetasqlite doesn't support the materialized view, so the emulator creates and drops it as the view.
+// go-zetasqlite doesn't support CREATE TABLE LIKE and CREATE TABLE COPY either, so the emulator creates the table from the source table.
+type DDLStatement struct {
+	Drop        bool
+	OrReplace   bool
+	IfNotExists bool
+	IfExists    bool
+	Temp        bool
+	// ObjectType is TABLE, VIEW, MATERIALIZED VIEW, FUNCTION or PROCEDURE.
+	ObjectType string
+	// Path is the name of the object split by the dot. It has one to three elements.
+	Path []string
+	// Query is the query after AS of CREATE VIEW and CREATE MATERIALIZED VIEW.
+	Query string
+	// Like is the name of the source table of CREATE TABLE LIKE split by the dot.
+	Like []string
+	// Copy is the name of the source table of CREATE TABLE COPY split by the dot.
+	Copy []string
+	// Options are the options of CREATE TABLE LIKE or CREATE TABLE COPY.
+	Options []*Option
+	// Collations are the collations of the columns of CREATE TABLE specified by COLLATE, keyed by the column name.
+	Collations map[string]string
+	// DefaultCollation is the collation specified by DEFAULT COLLATE of CREATE TABLE.
+	DefaultCollation string
+}
+
+// ParseDDLStatement parses the header of CREATE or DROP statement of TABLE, VIEW, MATERIALIZED VIEW, FUNCTION or PROCEDURE.
+// It returns nil without error when query is not the statement, such as CREATE TABLE FUNCTION or CREATE SNAPSHOT TABLE.
+func ParseDDLStatement(query string) (*DDLStatement, error) {
+	tokens := statementTokens(query)
+	if tokens == nil || isTableFunctionStatement(tokens) {
+		return nil, nil
+	}
+	p := &statementParser{tokens: tokens}
+	stmt := &DDLStatement{}
+	switch {
+	case p.consumeKeywords("CREATE"):
+		stmt.OrReplace = p.consumeKeywords("OR", "REPLACE")
+		stmt.Temp = p.consumeKeywords("TEMP") || p.consumeKeywords("TEMPORARY")
+	case p.consumeKeywords("DROP"):
+		stmt.Drop = true
+	default:
+		return nil, nil
+	}
+	switch {
+	case p.consumeKeywords("TABLE"):
+		stmt.ObjectType = "TABLE"
+	case p.consumeKeywords("VIEW"):
+		stmt.ObjectType = "VIEW"
+	case p.consumeKeywords("MATERIALIZED", "VIEW"):
+		stmt.ObjectType = "MATERIALIZED VIEW"
+	case p.consumeKeywords("FUNCTION"), p.consumeKeywords("AGGREGATE", "FUNCTION"):
+		stmt.ObjectType = "FUNCTION"
+	case p.consumeKeywords("PROCEDURE"):
+		stmt.ObjectType = "PROCEDURE"
+	default:
+		return nil, nil
+	}
+	if stmt.Drop {
+		stmt.IfExists = p.consumeKeywords("IF", "EXISTS")
+	} else {
+		stmt.IfNotExists = p.consumeKeywords("IF", "NOT", "EXISTS")
+	}
+	if stmt.OrReplace && stmt.IfNotExists {
+		return nil, fmt.Errorf("CREATE OR REPLACE %s cannot be used with IF NOT EXISTS", stmt.ObjectType)
+	}
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil, err
+	}
+	if len(path) > 3 {
+		return nil, fmt.Errorf("invalid %s name %s", strings.ToLower(stmt.ObjectType), strings.Join(path, "."))
+	}
+	stmt.Path = path
+	if (stmt.ObjectType == "VIEW" || stmt.ObjectType == "MATERIALIZED VIEW") && !stmt.Drop {
+		for idx := p.idx; idx < len(tokens); idx++ {
+			if tokens[idx].depth == 0 && tokens[idx].isKeyword("AS") && idx+1 < len(tokens) {
+				stmt.Query = query[tokens[idx+1].start:tokens[len(tokens)-1].end]
+				break
+			}
+		}
+		if stmt.Query == "" {
+			return nil, fmt.Errorf("CREATE %s must have AS query", stmt.ObjectType)
+		}
+	}
+	if stmt.ObjectType == "TABLE" && !stmt.Drop && !stmt.Temp {
+		if err := p.parseTableSource(stmt); err != nil {
+			return nil, err
+		}
+		if stmt.Like == nil && stmt.Copy == nil {
+			p.parseCollations(stmt)
+		}
+	}
+	return stmt, nil
+}
+
+// parseCollations reads COLLATE of the columns and DEFAULT COLLATE of CREATE TABLE.
+// go-zetasqlite doesn't support them, so collationDefinitionEdits removes them from the statement.
+func (p *statementParser) parseCollations(stmt *DDLStatement) {
+	var (
+		tokens = p.tokens
+		column string
+		// angle is the nesting level of the type parameters like STRUCT<...>.
+		angle int
+	)
+	for idx := p.idx; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth == 0 && tk.isKeyword("AS") {
+			return
+		}
+		if tk.depth == 1 {
+			switch {
+			case tk.isSymbol("<"):
+				angle++
+			case tk.isSymbol(">"):
+				angle--
+			case angle == 0 && (tokens[idx-1].isSymbol(",") || tokens[idx-1].isSymbol("(") && tokens[idx-1].depth == 0):
+				column = strings.Trim(tk.text, "`")
+			}
+		}
+		if !tk.isKeyword("COLLATE") {
+			continue
+		}
+		spec, ok := stringLiteralValue(tokens[idx+1])
+		if !ok {
+			continue
+		}
+		switch {
+		case tk.depth == 0 && tokens[idx-1].isKeyword("DEFAULT"):
+			stmt.DefaultCollation = spec
+		case tk.depth == 1 && angle == 0 && column != "":
+			if stmt.Collations == nil {
+				stmt.Collations = map[string]string{}
+			}
+			stmt.Collations[column] = spec
+		}
+	}
+}
+
+// parseTableSource parses `LIKE source [OPTIONS(...)]` or `COPY source [OPTIONS(...)]` after the name of CREATE TABLE.
+func (p *statementParser) parseTableSource(stmt *DDLStatement) error {
+	var clause string
+	switch {
+	case p.consumeKeywords("LIKE"):
+		clause = "LIKE"
+	case p.consumeKeywords("COPY"):
+		clause = "COPY"
+	default:
+		return nil
+	}
+	source, err := p.pathExpression()
+	if err != nil {
+		return err
+	}
+	if len(source) > 3 {
+		return fmt.Errorf("invalid table name %s", strings.Join(source, "."))
+	}
+	if clause == "LIKE" {
+		stmt.Like = source
+	} else {
+		stmt.Copy = source
+	}
+	if p.consumeKeywords("OPTIONS") {
+		options, err := p.options()
+		if err != nil {
+			return err
+		}
+		stmt.Options = options
+	}
+	if !p.eof() {
+		return fmt.Errorf("CREATE TABLE %s with %s is not supported by the emulator", clause, p.peek().text)
+	}
+	return nil
+}
+
+// isTableSourceDDL reports whether tokens are CREATE TABLE LIKE or CREATE TABLE COPY statement.
+func isTableSourceDDL(tokens []*token) bool {
+	p := &statementParser{tokens: tokens}
+	if !p.consumeKeywords("CREATE") {
+		return false
+	}
+	p.consumeKeywords("OR", "REPLACE")
+	if !p.consumeKeywords("TABLE") {
+		return false
+	}
+	p.consumeKeywords("IF", "NOT", "EXISTS")
+	if _, err := p.pathExpression(); err != nil {
+		return false
+	}
+	return p.peek().isKeyword("LIKE") || p.peek().isKeyword("COPY")
+}
+
+// isGuardedDDL reports whether tokens are CREATE ... IF NOT EXISTS or DROP ... IF EXISTS statement.
+func isGuardedDDL(tokens []*token) bool {
+	if len(tokens) == 0 || !(tokens[0].isKeyword("CREATE") || tokens[0].isKeyword("DROP")) {
+		return false
+	}
+	for idx := 1; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.isSymbol("(") || tk.isKeyword("AS") || tk.isKeyword("OPTIONS") || tk.isKeyword("ON") {
+			return false
+		}
+		if tk.isKeyword("IF") && (tokens[idx+1].isKeyword("EXISTS") || tokens[idx+1].isKeyword("NOT")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,490 @@
+package contentdata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+)
+
+// notNullConstraintPattern matches the error of SQLite writing NULL to the NOT NULL column of the REQUIRED field.
+var notNullConstraintPattern = regexp.MustCompile(`NOT NULL constraint failed: \S+\.([^.\s]+)`)
+
+// requiredFieldError translates the NOT NULL constraint error of SQLite into the error message of BigQuery.
+func requiredFieldError(err error) error {
+	if err == nil {
+		return nil
+	}
+	matched := notNullConstraintPattern.FindStringSubmatch(err.Error())
+	if matched == nil {
+		return err
+	}
+	return fmt.Errorf("Required field %s cannot be null", strings.Trim(matched[1], "`\""))
+}
+
+// thenReturnClause is `THEN RETURN [WITH ACTION [AS alias]] select_list` of the DML statement.
+type thenReturnClause struct {
+	items string
+	// action is the name of the column of the action ( INSERT, UPDATE or DELETE ) if WITH ACTION is specified.
+	action string
+}
+
+// splitThenReturn returns the DML statement without THEN RETURN clause and the clause,
+// or nil clause if the statement has no THEN RETURN clause.
+func splitThenReturn(query string) (string, *thenReturnClause, error) {
+	tokens := statementTokens(query)
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		if tokens[idx].depth != 0 || !tokens[idx].isKeyword("THEN") || !tokens[idx+1].isKeyword("RETURN") {
+			continue
+		}
+		clause := &thenReturnClause{}
+		itemIdx := idx + 2
+		if itemIdx+1 < len(tokens) && tokens[itemIdx].isKeyword("WITH") && tokens[itemIdx+1].isKeyword("ACTION") {
+			clause.action = "ACTION"
+			itemIdx += 2
+			if itemIdx+1 < len(tokens) && tokens[itemIdx].isKeyword("AS") {
+				clause.action = strings.Trim(tokens[itemIdx+1].text, "`")
+				itemIdx += 2
+			}
+		}
+		if itemIdx >= len(tokens) {
+			return "", nil, fmt.Errorf("THEN RETURN requires the list of the returned columns")
+		}
+		clause.items = tokenText(query, tokens[itemIdx:])
+		return query[:tokens[idx].start], clause, nil
+	}
+	return query, nil, nil
+}
+
+const (
+	// returnedTablePrefix is the prefix of the table that receives the rows written by the DML statement with THEN RETURN clause.
+	// It is created in HiddenDatasetID and dropped after the rows are selected.
+	returnedTablePrefix = "bqemulator_returned_"
+	// returnedActionColumn is the column of the returned table that has the action ( INSERT, UPDATE or DELETE ) of the row.
+	returnedActionColumn = "bqemulator_action"
+)
+
+// writtenRows copies the rows written by the DML statement into the returned table with the temporary triggers of SQLite,
+// so THEN RETURN clause selects the values that were actually written after the statement ran,
+// including the results of the volatile functions like GENERATE_UUID or CURRENT_TIMESTAMP.
+// The triggers copy the new values of the inserted and updated rows and the old values of the deleted rows.
+type writtenRows struct {
+	sqliteTx *sql.Tx
+	// table is the name of the returned table for ZetaSQL.
+	table string
+	// name is the name that refers to the target table in the statement.
+	name     string
+	triggers []string
+}
+
+// captureWrittenRows creates the returned table and the triggers on the target table of the DML statement.
+// The caller must call drop of the returned value even if the statement fails.
+func (r *Repository) captureWrittenRows(ctx context.Context, tx *connection.Tx, projectID, datasetID, typ, statement string) (*writtenRows, error) {
+	p := &statementParser{tokens: tokenize(statement)}
+	p.next()
+	p.consumeKeywords("FROM")
+	p.consumeKeywords("INTO")
+	start := p.idx
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil, err
+	}
+	target := tokenText(statement, p.tokens[start:p.idx])
+	name := path[len(path)-1]
+	if typ != "INSERT" {
+		name = p.tableAlias(name)
+	}
+	fields, err := r.queryFields(ctx, tx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", target), nil)
+	if err != nil {
+		return nil, err
+	}
+	sqliteTx, err := tx.SQLiteTx()
+	if err != nil {
+		return nil, err
+	}
+	returnedPath := []string{projectID, HiddenDatasetID, HiddenTableID(returnedTablePrefix)}
+	written := &writtenRows{
+		sqliteTx: sqliteTx,
+		table:    fmt.Sprintf("`%s`", strings.Join(returnedPath, ".")),
+		name:     name,
+	}
+	columns := make([]string, 0, len(fields)+1)
+	sqliteColumns := make([]string, 0, len(fields)+1)
+	for _, field := range fields {
+		columns = append(columns, fmt.Sprintf("`%s` %s", field.Name, r.encodeSchemaField(field)))
+		sqliteColumns = append(sqliteColumns, quoteSQLiteIdentifier(field.Name))
+	}
+	columns = append(columns, fmt.Sprintf("`%s` STRING", returnedActionColumn))
+	sqliteColumns = append(sqliteColumns, quoteSQLiteIdentifier(returnedActionColumn))
+	if _, err := tx.Tx().ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (%s)", written.table, strings.Join(columns, ", "))); err != nil {
+		return nil, fmt.Errorf("failed to create the table of THEN RETURN: %w", err)
+	}
+
+	actions := []string{typ}
+	if typ == "MERGE" {
+		actions = []string{"INSERT", "UPDATE", "DELETE"}
+	}
+	for _, action := range actions {
+		timing, row := "AFTER", "NEW"
+		if action == "DELETE" {
+			timing, row = "BEFORE", "OLD"
+		}
+		values := make([]string, 0, len(fields)+1)
+		for _, field := range fields {
+			values = append(values, fmt.Sprintf("%s.%s", row, quoteSQLiteIdentifier(field.Name)))
+		}
+		values = append(values, fmt.Sprintf("'%s'", action))
+		trigger := quoteSQLiteIdentifier(fmt.Sprintf("%s_%s", returnedPath[len(returnedPath)-1], strings.ToLower(action)))
+		if _, err := sqliteTx.ExecContext(ctx, fmt.Sprintf(
+			"CREATE TEMP TRIGGER %s %s %s ON %s BEGIN INSERT INTO %s (%s) VALUES (%s); END",
+			trigger, timing, action,
+			quoteSQLiteIdentifier(sqliteTableName(projectID, datasetID, path)),
+			quoteSQLiteIdentifier(sqliteTableName(projectID, datasetID, returnedPath)),
+			strings.Join(sqliteColumns, ", "), strings.Join(values, ", "),
+		)); err != nil {
+			written.drop(ctx, tx)
+			return nil, fmt.Errorf("failed to create the trigger of THEN RETURN: %w", err)
+		}
+		written.triggers = append(written.triggers, trigger)
+	}
+	return written, nil
+}
+
+// dropTriggers stops copying the written rows.
+func (w *writtenRows) dropTriggers(ctx context.Context) {
+	for _, trigger := range w.triggers {
+		_, _ = w.sqliteTx.ExecContext(ctx, fmt.Sprintf("DROP TRIGGER IF EXISTS %s", trigger))
+	}
+	w.triggers = nil
+}
+
+// drop drops the triggers and the returned table.
+func (w *writtenRows) drop(ctx context.Context, tx *connection.Tx) {
+	w.dropTriggers(ctx)
+	_, _ = tx.Tx().ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", w.table))
+}
+
+// query returns the query that selects the items of THEN RETURN clause from the written rows.
+// The action column is excluded from the star of the items.
+func (w *writtenRows) query(clause *thenReturnClause) string {
+	tokens := tokenize(clause.items)
+	var edits []*edit
+	for idx, tk := range tokens {
+		if tk.depth != 0 || !tk.isSymbol("*") {
+			continue
+		}
+		if idx > 0 && !tokens[idx-1].isSymbol(",") && !tokens[idx-1].isSymbol(".") {
+			// multiplication
+			continue
+		}
+		except := fmt.Sprintf(" EXCEPT (`%s`)", returnedActionColumn)
+		if idx+2 < len(tokens) && tokens[idx+1].isKeyword("EXCEPT") && tokens[idx+2].isSymbol("(") {
+			edits = append(edits, &edit{start: tokens[idx+2].end, end: tokens[idx+2].end, replacement: fmt.Sprintf("`%s`, ", returnedActionColumn)})
+			continue
+		}
+		edits = append(edits, &edit{start: tk.end, end: tk.end, replacement: except})
+	}
+	items := applyEdits(clause.items, edits)
+	if clause.action != "" {
+		items += fmt.Sprintf(", `%s` AS `%s`", returnedActionColumn, clause.action)
+	}
+	return fmt.Sprintf("SELECT %s FROM %s AS `%s`", items, w.table, w.name)
+}
+
+// dmlTarget parses the target table `path [[AS] alias]` of the DML statement.
+// It returns the text of the path and the name that refers to the table in the statement.
+func (p *statementParser) dmlTarget(query string) (string, string, error) {
+	start := p.peek()
+	path, err := p.pathExpression()
+	if err != nil {
+		return "", "", err
+	}
+	target := query[start.start:p.tokens[p.idx-1].end]
+	return target, p.tableAlias(path[len(path)-1]), nil
+}
+
+// tableAlias parses the optional `[AS] alias` and returns the alias or name if it has no alias.
+func (p *statementParser) tableAlias(name string) string {
+	if p.consumeKeywords("AS") {
+		return strings.Trim(p.next().text, "`")
+	}
+	if tk := p.peek(); tk.kind == tokenQuotedIdent || (tk.kind == tokenWord && !isReservedKeyword(tk)) {
+		return strings.Trim(p.next().text, "`")
+	}
+	return name
+}
+
+// mergeClause is `WHEN [NOT] MATCHED [BY TARGET | BY SOURCE] [AND condition] THEN action` of the MERGE statement.
+type mergeClause struct {
+	// match is MATCHED, NOT MATCHED BY TARGET or NOT MATCHED BY SOURCE.
+	match     string
+	condition string
+	// action is INSERT, UPDATE or DELETE.
+	action string
+}
+
+// mergeStatistics returns the numbers of the rows inserted, updated and deleted by the MERGE statement.
+// Each pair of the target row and the source row joined by the merge condition takes the action of
+// the first WHEN clause it satisfies, so the probe query counts the actions before the statement runs.
+// The same query counts the target rows updated or deleted for more than one source row,
+// and the error is returned for them because go-zetasqlite applies the action for each source row instead.
+// It returns nil statistics without error if the statement can't be parsed.
+func (r *Repository) mergeStatistics(ctx context.Context, tx *connection.Tx, query string, values []interface{}) (*bigqueryv2.DmlStatistics, error) {
+	probe := mergeStatisticsProbe(query)
+	if probe == "" {
+		return nil, nil
+	}
+	var inserted, updated, deleted, duplicated int64
+	if err := tx.Tx().QueryRowContext(ctx, probe, values...).Scan(&inserted, &updated, &deleted, &duplicated); err != nil {
+		return nil, fmt.Errorf("failed to count the actions of MERGE: %w", err)
+	}
+	if duplicated > 0 {
+		return nil, errors.New(mergeCardinalityError)
+	}
+	return &bigqueryv2.DmlStatistics{
+		InsertedRowCount: inserted,
+		UpdatedRowCount:  updated,
+		DeletedRowCount:  deleted,
+	}, nil
+}
+
+// mergeStatement is the MERGE statement parsed for the probe queries.
+type mergeStatement struct {
+	// target and source are the text of the target table and the source table or subquery.
+	target, source string
+	// targetName and sourceName are the names that refer to the tables in the statement.
+	targetName, sourceName string
+	condition              string
+	clauses                []*mergeClause
+}
+
+// parseMergeStatement parses the MERGE statement or returns nil if the statement can't be parsed.
+func parseMergeStatement(query string) *mergeStatement {
+	tokens := statementTokens(query)
+	p := &statementParser{tokens: tokens}
+	if !p.consumeKeywords("MERGE") {
+		return nil
+	}
+	p.consumeKeywords("INTO")
+	stmt := &mergeStatement{}
+	var err error
+	stmt.target, stmt.targetName, err = p.dmlTarget(query)
+	if err != nil || !p.consumeKeywords("USING") {
+		return nil
+	}
+	if p.peek().isSymbol("(") {
+		closeIdx := skipParen(tokens, p.idx)
+		if closeIdx >= len(tokens) {
+			return nil
+		}
+		stmt.source = tokenText(query, tokens[p.idx:closeIdx+1])
+		p.idx = closeIdx + 1
+		stmt.sourceName = p.tableAlias("_merge_source")
+	} else {
+		stmt.source, stmt.sourceName, err = p.dmlTarget(query)
+		if err != nil {
+			return nil
+		}
+	}
+	if !p.consumeKeywords("ON") {
+		return nil
+	}
+
+	// the WHEN keywords of the clauses are the ones outside of the CASE expressions.
+	var (
+		whens     []int
+		caseDepth int
+	)
+	for idx := p.idx; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth != 0 {
+			continue
+		}
+		switch {
+		case tk.isKeyword("CASE"):
+			caseDepth++
+		case tk.isKeyword("END") && caseDepth > 0:
+			caseDepth--
+		case tk.isKeyword("WHEN") && caseDepth == 0:
+			whens = append(whens, idx)
+		}
+	}
+	if len(whens) == 0 {
+		return nil
+	}
+	stmt.condition = tokenText(query, tokens[p.idx:whens[0]])
+	for i, whenIdx := range whens {
+		end := len(tokens)
+		if i+1 < len(whens) {
+			end = whens[i+1]
+		}
+		clause := parseMergeClause(query, tokens[whenIdx+1:end])
+		if clause == nil {
+			return nil
+		}
+		stmt.clauses = append(stmt.clauses, clause)
+	}
+	return stmt
+}
+
+// mergeStatisticsProbe returns the query that counts the actions of the MERGE statement
+// and the target rows matched by more than one source row for UPDATE or DELETE, or empty string if the statement can't be parsed.
+func mergeStatisticsProbe(query string) string {
+	stmt := parseMergeStatement(query)
+	if stmt == nil {
+		return ""
+	}
+	targetExists := fmt.Sprintf("`%s`.`_merge_target` IS NOT NULL", stmt.targetName)
+	sourceExists := fmt.Sprintf("`%s`.`_merge_source` IS NOT NULL", stmt.sourceName)
+	var cases []string
+	for _, match := range []struct {
+		name      string
+		condition string
+	}{
+		{name: "MATCHED", condition: fmt.Sprintf("%s AND %s", targetExists, sourceExists)},
+		{name: "NOT MATCHED BY TARGET", condition: sourceExists},
+		{name: "NOT MATCHED BY SOURCE", condition: targetExists},
+	} {
+		if whenClauses := stmt.whenClauses(match.name); whenClauses != "" {
+			cases = append(cases, fmt.Sprintf("WHEN %s THEN CASE %s END", match.condition, whenClauses))
+		}
+	}
+	// the target rows are numbered to tell the rows having the same values apart.
+	return fmt.Sprintf(
+		"SELECT IFNULL(SUM(`_merge_inserted`), 0), IFNULL(SUM(`_merge_updated`), 0), IFNULL(SUM(`_merge_deleted`), 0), "+
+			"COUNTIF(`_merge_matched` > 1) "+
+			"FROM (SELECT COUNTIF(`_merge_action` = 'INSERT') AS `_merge_inserted`, "+
+			"COUNTIF(`_merge_action` = 'UPDATE') AS `_merge_updated`, COUNTIF(`_merge_action` = 'DELETE') AS `_merge_deleted`, "+
+			"COUNTIF(`_merge_matched` AND `_merge_action` IN ('UPDATE', 'DELETE')) AS `_merge_matched` "+
+			"FROM (SELECT `_merge_row`, CASE %s END AS `_merge_action`, %s AND %s AS `_merge_matched` "+
+			"FROM (SELECT TRUE AS `_merge_target`, ROW_NUMBER() OVER () AS `_merge_row`, * FROM %s) AS `%s` "+
+			"FULL OUTER JOIN (SELECT TRUE AS `_merge_source`, * FROM %s) AS `%s` ON %s) "+
+			"GROUP BY `_merge_row`)",
+		strings.Join(cases, " "), targetExists, sourceExists, stmt.target, stmt.targetName, stmt.source, stmt.sourceName, stmt.condition,
+	)
+}
+
+// whenClauses returns `WHEN condition THEN 'action' ...` of the clauses of match in the order of the statement.
+func (s *mergeStatement) whenClauses(match string) string {
+	var whenClauses []string
+	for _, clause := range s.clauses {
+		if clause.match != match {
+			continue
+		}
+		whenClauses = append(whenClauses, fmt.Sprintf("WHEN %s THEN '%s'", clause.condition, clause.action))
+	}
+	return strings.Join(whenClauses, " ")
+}
+
+// mergeCardinalityError is the error of BigQuery for the MERGE statement that updates or deletes
+// the target row matched by more than one source row.
+const mergeCardinalityError = "UPDATE/MERGE must match at most one source row for each target row"
+
+// parseMergeClause parses the tokens of the WHEN clause following WHEN keyword.
+func parseMergeClause(query string, tokens []*token) *mergeClause {
+	p := &statementParser{tokens: tokens}
+	clause := &mergeClause{condition: "TRUE"}
+	switch {
+	case p.consumeKeywords("MATCHED"):
+		clause.match = "MATCHED"
+	case p.consumeKeywords("NOT", "MATCHED", "BY", "SOURCE"):
+		clause.match = "NOT MATCHED BY SOURCE"
+	case p.consumeKeywords("NOT", "MATCHED", "BY", "TARGET"), p.consumeKeywords("NOT", "MATCHED"):
+		clause.match = "NOT MATCHED BY TARGET"
+	default:
+		return nil
+	}
+	thenIdx := -1
+	var caseDepth int
+	for idx := p.idx; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth != 0 {
+			continue
+		}
+		if tk.isKeyword("CASE") {
+			caseDepth++
+		} else if tk.isKeyword("END") && caseDepth > 0 {
+			caseDepth--
+		} else if tk.isKeyword("THEN") && caseDepth == 0 {
+			thenIdx = idx
+			break
+		}
+	}
+	if thenIdx < 0 || thenIdx+1 >= len(tokens) {
+		return nil
+	}
+	if p.consumeKeywords("AND") {
+		if p.idx >= thenIdx {
+			return nil
+		}
+		clause.condition = fmt.Sprintf("(%s)", tokenText(query, tokens[p.idx:thenIdx]))
+	} else if p.idx != thenIdx {
+		return nil
+	}
+	for _, action := range []string{"INSERT", "UPDATE", "DELETE"} {
+		if tokens[thenIdx+1].isKeyword(action) {
+			clause.action = action
+			return clause
+		}
+	}
+	return nil
+}
+
+// checkWrittenNumerics returns the overflow error if the INSERT, UPDATE or MERGE statement wrote the NUMERIC or BIGNUMERIC value
+// out of the range to the top-level column of the target table. go-zetasqlite doesn't check the range of the computed values,
+// e.g. `SET x = x * 10`, so the target table is validated after the statement writes it, and the statement is rolled back
+// by the caller for the error. The values written by the other statements are in the range, because they were validated too.
+// Nothing is checked if the target table can't be parsed.
+func (r *Repository) checkWrittenNumerics(ctx context.Context, tx *connection.Tx, typ, query string) error {
+	tokens := statementTokens(query)
+	if tokens == nil {
+		return nil
+	}
+	p := &statementParser{tokens: tokens}
+	if !p.consumeKeywords(typ) {
+		return nil
+	}
+	p.consumeKeywords("INTO")
+	target, _, err := p.dmlTarget(query)
+	if err != nil {
+		return nil
+	}
+	fields, err := r.queryFields(ctx, tx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", target), nil)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if field.Mode == "REPEATED" {
+			continue
+		}
+		var outOfRange string
+		switch field.Type {
+		case "NUMERIC":
+			outOfRange = fmt.Sprintf("ABS(`%s`) > NUMERIC '%s'", field.Name, maxNumeric.FloatString(numericScale))
+		case "BIGNUMERIC":
+			outOfRange = fmt.Sprintf(
+				"`%[1]s` > BIGNUMERIC '%[2]s' OR `%[1]s` < BIGNUMERIC '%[3]s'",
+				field.Name, maxBigNumeric.FloatString(bigNumericScale), minBigNumeric.FloatString(bigNumericScale),
+			)
+		default:
+			continue
+		}
+		var v string
+		if err := tx.Tx().QueryRowContext(
+			ctx, fmt.Sprintf("SELECT CAST(`%s` AS STRING) FROM %s WHERE %s LIMIT 1", field.Name, target, outOfRange),
+		).Scan(&v); err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return fmt.Errorf("failed to check the range of %s: %w", field.Name, err)
+		}
+		return CheckNumericRange(field.Type, v)
+	}
+	return nil
+}
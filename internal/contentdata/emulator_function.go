@@ -0,0 +1,94 @@
+package contentdata
+
+import (
+	"strings"
+
+	"github.com/goccy/go-zetasqlite"
+)
+
+// emulatorFunctions are the temporary functions that the rewritten queries call.
+// The function that calls another function of the list must be placed after it.
+var emulatorFunctions = []struct {
+	name       string
+	definition string
+}{
+	{name: editDistanceFunction, definition: editDistanceFunctionDefinition},
+	{name: timestampStringFunction, definition: timestampStringFunctionDefinition},
+	{name: formatNumberFunction, definition: formatNumberFunctionDefinition},
+	{name: parseNumberFunction, definition: parseNumberFunctionDefinition},
+	{name: rankingFunction, definition: rankingFunctionDefinition},
+	{name: timestampInZoneFunction, definition: timestampInZoneFunctionDefinition},
+	{name: stringInZoneFunction, definition: stringInZoneFunctionDefinition},
+	{name: timestampBucketFunction, definition: timestampBucketFunctionDefinition},
+	{name: dateBucketFunction, definition: dateBucketFunctionDefinition},
+	{name: dateMonthBucketFunction, definition: dateMonthBucketFunctionDefinition},
+	{name: datetimeMonthBucketFunction, definition: datetimeMonthBucketFunctionDefinition},
+	{name: arrayConcatFunction, definition: arrayConcatFunctionDefinition},
+	{name: jsonSetScriptFunction, definition: jsonSetScriptFunctionDefinition},
+	{name: jsonSetFunction, definition: jsonSetFunctionDefinition},
+	{name: jsonRemoveScriptFunction, definition: jsonRemoveScriptFunctionDefinition},
+	{name: jsonRemoveFunction, definition: jsonRemoveFunctionDefinition},
+	{name: jsonStripNullsScriptFunction, definition: jsonStripNullsScriptFunctionDefinition},
+	{name: jsonStripNullsFunction, definition: jsonStripNullsFunctionDefinition},
+	{name: jsonStringifyWideNumbersScriptFunction, definition: jsonStringifyWideNumbersScriptFunctionDefinition},
+	{name: toJSONFunction, definition: toJSONFunctionDefinition},
+	{name: normalizeAndCasefoldFunction, definition: normalizeAndCasefoldFunctionDefinition},
+	{name: bitAggregateFunction, definition: bitAggregateFunctionDefinition},
+}
+
+// isEmulatorFunction reports whether name is the temporary function of emulatorFunctions.
+func isEmulatorFunction(name string) bool {
+	for _, fn := range emulatorFunctions {
+		if strings.EqualFold(fn.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// withEmulatorFunctions returns query preceded by the definitions of the temporary functions the rewritten query calls,
+// including the functions that those functions call.
+func withEmulatorFunctions(query string) string {
+	called := map[string]struct{}{}
+	addCalled := func(text string) {
+		for _, tk := range tokenize(text) {
+			if tk.kind == tokenWord && isEmulatorFunction(tk.text) {
+				called[strings.ToLower(tk.text)] = struct{}{}
+			}
+		}
+	}
+	addCalled(query)
+	for i := len(emulatorFunctions) - 1; i >= 0; i-- {
+		if _, exists := called[emulatorFunctions[i].name]; exists {
+			addCalled(strings.TrimPrefix(emulatorFunctions[i].definition, "CREATE TEMP FUNCTION "+emulatorFunctions[i].name))
+		}
+	}
+	var definitions string
+	for _, fn := range emulatorFunctions {
+		if _, exists := called[fn.name]; exists {
+			definitions += fn.definition
+		}
+	}
+	return definitions + query
+}
+
+// removeEmulatorFunctions removes the temporary functions defined by withEmulatorFunctions from the changed catalog,
+// so the caller doesn't see them as the functions the statement created and dropped.
+func removeEmulatorFunctions(changed *zetasqlite.ChangedCatalog) {
+	if changed == nil || changed.Function == nil {
+		return
+	}
+	changed.Function.Added = withoutEmulatorFunctions(changed.Function.Added)
+	changed.Function.Deleted = withoutEmulatorFunctions(changed.Function.Deleted)
+}
+
+func withoutEmulatorFunctions(specs []*zetasqlite.FunctionSpec) []*zetasqlite.FunctionSpec {
+	ret := make([]*zetasqlite.FunctionSpec, 0, len(specs))
+	for _, spec := range specs {
+		if len(spec.NamePath) != 0 && isEmulatorFunction(spec.NamePath[len(spec.NamePath)-1]) {
+			continue
+		}
+		ret = append(ret, spec)
+	}
+	return ret
+}
@@ -0,0 +1,130 @@
+package contentdata
+
+import (
+	"context"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+)
+
+// typeProbe is the query that selects the expressions of a query block without the rows to resolve their types.
+// The query is head, the expressions joined by the commas and tail.
+type typeProbe struct {
+	head  string
+	tail  string
+	exprs []string
+}
+
+func (p *typeProbe) query() string {
+	return p.head + strings.Join(p.exprs, ", ") + p.tail
+}
+
+// blockKey identifies the query block of the probe. The probes of the same block differ only in their expressions.
+func (p *typeProbe) blockKey() string {
+	return p.head + "\x00" + p.tail
+}
+
+// expressionTypes resolves the types of the expressions that the rewriters of a query rewrite by their types.
+// The expressions of each query block are probed together once by prefetch, and the rewriters share the types.
+// The probe of the expressions not prefetched, like the ones changed by the preceding rewriter, runs on demand.
+type expressionTypes struct {
+	repo   *Repository
+	tx     *connection.Tx
+	values []interface{}
+	// types are the resolved types by blockKey and the expressions.
+	types map[string]map[string]*bigqueryv2.TableFieldSchema
+}
+
+func (r *Repository) newExpressionTypes(tx *connection.Tx, values []interface{}) *expressionTypes {
+	return &expressionTypes{
+		repo:   r,
+		tx:     tx,
+		values: values,
+		types:  map[string]map[string]*bigqueryv2.TableFieldSchema{},
+	}
+}
+
+// prefetch probes the expressions of probes by one query per query block.
+// The block whose merged probe fails is left to the probes of fields, so one invalid expression doesn't hide the others.
+func (t *expressionTypes) prefetch(ctx context.Context, probes []*typeProbe) {
+	var (
+		merged []*typeProbe
+		byKey  = map[string]*typeProbe{}
+		seen   = map[string]map[string]struct{}{}
+	)
+	for _, p := range probes {
+		if p == nil {
+			continue
+		}
+		key := p.blockKey()
+		block, exists := byKey[key]
+		if !exists {
+			block = &typeProbe{head: p.head, tail: p.tail}
+			byKey[key] = block
+			seen[key] = map[string]struct{}{}
+			merged = append(merged, block)
+		}
+		for _, expr := range p.exprs {
+			if _, exists := seen[key][expr]; exists {
+				continue
+			}
+			seen[key][expr] = struct{}{}
+			block.exprs = append(block.exprs, expr)
+		}
+	}
+	for _, block := range merged {
+		if t.resolved(block) {
+			continue
+		}
+		fields := t.repo.probeFields(ctx, t.tx, block.query(), t.values)
+		if len(fields) != len(block.exprs) {
+			continue
+		}
+		t.store(block, fields)
+	}
+}
+
+// fields returns the types of the expressions of p. It returns nil if the probe fails.
+func (t *expressionTypes) fields(ctx context.Context, p *typeProbe) []*bigqueryv2.TableFieldSchema {
+	if p == nil {
+		return nil
+	}
+	if t.resolved(p) {
+		types := t.types[p.blockKey()]
+		fields := make([]*bigqueryv2.TableFieldSchema, 0, len(p.exprs))
+		for _, expr := range p.exprs {
+			fields = append(fields, types[expr])
+		}
+		return fields
+	}
+	fields := t.repo.probeFields(ctx, t.tx, p.query(), t.values)
+	if len(fields) == len(p.exprs) {
+		t.store(p, fields)
+	}
+	return fields
+}
+
+func (t *expressionTypes) resolved(p *typeProbe) bool {
+	types, exists := t.types[p.blockKey()]
+	if !exists {
+		return false
+	}
+	for _, expr := range p.exprs {
+		if _, exists := types[expr]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *expressionTypes) store(p *typeProbe, fields []*bigqueryv2.TableFieldSchema) {
+	key := p.blockKey()
+	if t.types[key] == nil {
+		t.types[key] = map[string]*bigqueryv2.TableFieldSchema{}
+	}
+	for idx, expr := range p.exprs {
+		t.types[key][expr] = fields[idx]
+	}
+}
@@ -0,0 +1,64 @@
+package contentdata
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+)
+
+// externalDataVersionsTableName is the SQLite table of the versions of the data loaded into the external tables.
+// The version is written in the same transaction as the loaded rows, so it is rolled back with the rows.
+const externalDataVersionsTableName = "bqemulator_external_data_versions"
+
+// ExternalDataVersion returns the version of the data loaded into the external table by SetExternalDataVersion,
+// or empty if the data is not loaded yet.
+func (r *Repository) ExternalDataVersion(ctx context.Context, tx *connection.Tx, projectID, datasetID, tableID string) (string, error) {
+	sqliteTx, err := tx.SQLiteTx()
+	if err != nil {
+		return "", err
+	}
+	var exists int
+	if err := sqliteTx.QueryRowContext(
+		ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", externalDataVersionsTableName,
+	).Scan(&exists); err != nil {
+		return "", err
+	}
+	if exists == 0 {
+		return "", nil
+	}
+	var version string
+	if err := sqliteTx.QueryRowContext(
+		ctx,
+		fmt.Sprintf("SELECT version FROM %s WHERE table_name = ?", externalDataVersionsTableName),
+		r.tablePath(projectID, datasetID, tableID),
+	).Scan(&version); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return version, nil
+}
+
+// SetExternalDataVersion sets the version of the data loaded into the external table.
+func (r *Repository) SetExternalDataVersion(ctx context.Context, tx *connection.Tx, projectID, datasetID, tableID, version string) error {
+	sqliteTx, err := tx.SQLiteTx()
+	if err != nil {
+		return err
+	}
+	if _, err := sqliteTx.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (table_name TEXT PRIMARY KEY, version TEXT NOT NULL)", externalDataVersionsTableName,
+	)); err != nil {
+		return fmt.Errorf("failed to set the version of the external data: %w", err)
+	}
+	if _, err := sqliteTx.ExecContext(
+		ctx,
+		fmt.Sprintf("INSERT OR REPLACE INTO %s (table_name, version) VALUES (?, ?)", externalDataVersionsTableName),
+		r.tablePath(projectID, datasetID, tableID), version,
+	); err != nil {
+		return fmt.Errorf("failed to set the version of the external data: %w", err)
+	}
+	return nil
+}
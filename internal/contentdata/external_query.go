@@ -0,0 +1,116 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExternalQuery is the call of `EXTERNAL_QUERY(connection_id, external_sql [, options])` of the federated query.
+type ExternalQuery struct {
+	// ProjectID is empty if the connection id doesn't have the project.
+	ProjectID    string
+	Location     string
+	ConnectionID string
+	SQL          string
+}
+
+// FindExternalQuery returns the first EXTERNAL_QUERY call in query, or nil if query doesn't call it.
+// The connection id is `[project.]location.connection_id` or `projects/p/locations/l/connections/c`,
+// and the external SQL must be the string literal of the SELECT statement, which is all that BigQuery allows.
+// The external SQL is written in the dialect of the external database, so only its shape is validated.
+func FindExternalQuery(query string) (*ExternalQuery, error) {
+	tokens := tokenize(query)
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		if !tokens[idx].isKeyword("EXTERNAL_QUERY") || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		args := functionArgs(query, tokens, idx+1, skipParen(tokens, idx+1))
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("EXTERNAL_QUERY requires the connection id and the external SQL but got %d arguments", len(args))
+		}
+		connectionID, ok := externalQueryLiteral(args[0])
+		if !ok {
+			return nil, fmt.Errorf("the connection id of EXTERNAL_QUERY must be the string literal: %s", args[0])
+		}
+		externalQuery, err := parseExternalConnectionID(connectionID)
+		if err != nil {
+			return nil, err
+		}
+		sql, ok := externalQueryLiteral(args[1])
+		if !ok {
+			return nil, fmt.Errorf("the external SQL of EXTERNAL_QUERY must be the string literal: %s", args[1])
+		}
+		if err := validateExternalSQL(sql); err != nil {
+			return nil, err
+		}
+		externalQuery.SQL = sql
+		return externalQuery, nil
+	}
+	return nil, nil
+}
+
+func externalQueryLiteral(arg string) (string, bool) {
+	tokens := tokenize(arg)
+	if len(tokens) != 1 || tokens[0].kind != tokenString || tokens[0].isBytesLiteral() {
+		return "", false
+	}
+	return stringLiteralValue(tokens[0])
+}
+
+func parseExternalConnectionID(id string) (*ExternalQuery, error) {
+	if strings.HasPrefix(id, "projects/") {
+		parts := strings.Split(id, "/")
+		if len(parts) == 6 && parts[2] == "locations" && parts[4] == "connections" && parts[1] != "" && parts[3] != "" && parts[5] != "" {
+			return &ExternalQuery{ProjectID: parts[1], Location: strings.ToLower(parts[3]), ConnectionID: parts[5]}, nil
+		}
+		return nil, fmt.Errorf("Invalid connection id %q: expected projects/project/locations/location/connections/connection_id", id)
+	}
+	parts := strings.Split(id, ".")
+	for _, part := range parts {
+		if part == "" {
+			parts = nil
+			break
+		}
+	}
+	switch len(parts) {
+	case 2:
+		return &ExternalQuery{Location: strings.ToLower(parts[0]), ConnectionID: parts[1]}, nil
+	case 3:
+		return &ExternalQuery{ProjectID: parts[0], Location: strings.ToLower(parts[1]), ConnectionID: parts[2]}, nil
+	}
+	return nil, fmt.Errorf("Invalid connection id %q: expected [project.]location.connection_id", id)
+}
+
+// validateExternalSQL validates that sql is the single SELECT statement with the balanced parentheses.
+func validateExternalSQL(sql string) error {
+	tokens := tokenize(sql)
+	stmts := splitStatements(tokens)
+	if len(stmts) == 0 {
+		return fmt.Errorf("Invalid external query: the external SQL is empty")
+	}
+	if len(stmts) > 1 {
+		return fmt.Errorf("Invalid external query: the external SQL must be a single statement")
+	}
+	if first := stmts[0][0]; !first.isKeyword("SELECT") && !first.isKeyword("WITH") {
+		return fmt.Errorf("Invalid external query: only SELECT statements are supported but got %s", first.text)
+	}
+	var depth int
+	for _, tk := range tokens {
+		switch {
+		case tk.isSymbol("("):
+			depth++
+		case tk.isSymbol(")"):
+			depth--
+		}
+		if depth < 0 {
+			break
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("Invalid external query: the parentheses are not balanced in %s", sql)
+	}
+	return nil
+}
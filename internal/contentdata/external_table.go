@@ -0,0 +1,246 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExternalTableStatement is the CREATE EXTERNAL TABLE or DROP EXTERNAL TABLE statement,
+// or the CREATE TABLE statement with WITH CONNECTION clause that creates the BigLake table.
+// go-zetasqlite doesn't support them, so the emulator creates the tables with the metadata.
+type ExternalTableStatement struct {
+	Drop        bool
+	OrReplace   bool
+	IfNotExists bool
+	IfExists    bool
+	// External is false for CREATE TABLE with WITH CONNECTION clause.
+	External bool
+	// TablePath is the name of the table split by the dot. It has one to three elements.
+	TablePath []string
+	// Columns are the columns of the column list. It is empty if the list is omitted.
+	Columns []*TableColumn
+	// Connection is the connection of WITH CONNECTION clause. It is nil without the clause and for WITH CONNECTION DEFAULT.
+	Connection        *ConnectionReference
+	DefaultConnection bool
+	// HivePartitioned is true with WITH PARTITION COLUMNS clause, and PartitionColumns are its columns if they are listed.
+	HivePartitioned  bool
+	PartitionColumns []*TableColumn
+	// ClusterBy are the columns of CLUSTER BY clause of the BigLake table.
+	ClusterBy []string
+	Options   []*Option
+}
+
+// TableColumn is the column of the column list of the table DDL.
+type TableColumn struct {
+	Name string
+	// Type is the type like INT64 or STRUCT<a INT64>.
+	Type    string
+	NotNull bool
+	Options []*Option
+}
+
+// ConnectionReference is the name of the connection like `[project.]location.connection_id`.
+// ProjectID is empty if the name doesn't have the project.
+type ConnectionReference struct {
+	ProjectID    string
+	Location     string
+	ConnectionID string
+}
+
+// ParseExternalTableStatement parses query as CREATE EXTERNAL TABLE, DROP EXTERNAL TABLE,
+// or CREATE TABLE with WITH CONNECTION clause.
+// It returns nil without error when query is not the statement.
+func ParseExternalTableStatement(query string) (*ExternalTableStatement, error) {
+	tokens := statementTokens(query)
+	if tokens == nil || !isExternalTableStatement(tokens) {
+		return nil, nil
+	}
+	p := &statementParser{tokens: tokens}
+	stmt := &ExternalTableStatement{}
+	if p.consumeKeywords("DROP", "EXTERNAL", "TABLE") {
+		stmt.Drop = true
+		stmt.External = true
+		stmt.IfExists = p.consumeKeywords("IF", "EXISTS")
+	} else {
+		p.consumeKeywords("CREATE")
+		stmt.OrReplace = p.consumeKeywords("OR", "REPLACE")
+		stmt.External = p.consumeKeywords("EXTERNAL")
+		p.consumeKeywords("TABLE")
+		stmt.IfNotExists = p.consumeKeywords("IF", "NOT", "EXISTS")
+		if stmt.OrReplace && stmt.IfNotExists {
+			return nil, fmt.Errorf("CREATE OR REPLACE TABLE cannot be used with IF NOT EXISTS")
+		}
+	}
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil, err
+	}
+	if len(path) > 3 {
+		return nil, fmt.Errorf("invalid table name %s", strings.Join(path, "."))
+	}
+	stmt.TablePath = path
+	if !stmt.Drop {
+		if err := p.externalTableClauses(query, stmt); err != nil {
+			return nil, err
+		}
+	}
+	if !stmt.External && p.consumeKeywords("AS") {
+		return nil, fmt.Errorf("CREATE TABLE WITH CONNECTION AS query is not supported by the emulator")
+	}
+	if !p.eof() {
+		return nil, fmt.Errorf("syntax error: unexpected %s", p.peek().text)
+	}
+	return stmt, nil
+}
+
+// externalTableClauses parses the clauses after the table name of CREATE EXTERNAL TABLE
+// and CREATE TABLE with WITH CONNECTION clause.
+func (p *statementParser) externalTableClauses(query string, stmt *ExternalTableStatement) error {
+	if p.peek().isSymbol("(") {
+		columns, err := p.tableColumns(query)
+		if err != nil {
+			return err
+		}
+		stmt.Columns = columns
+	}
+	if !stmt.External && p.consumeKeywords("CLUSTER", "BY") {
+		for {
+			column := p.next()
+			if column.kind != tokenWord && column.kind != tokenQuotedIdent {
+				return fmt.Errorf("syntax error: expected column name but got %q", column.text)
+			}
+			stmt.ClusterBy = append(stmt.ClusterBy, strings.Trim(column.text, "`"))
+			if !p.consumeSymbol(",") {
+				break
+			}
+		}
+	}
+	if p.consumeKeywords("WITH", "CONNECTION") {
+		if p.consumeKeywords("DEFAULT") {
+			stmt.DefaultConnection = true
+		} else {
+			if p.peek().isKeyword("OPTIONS") || p.peek().isKeyword("WITH") {
+				return fmt.Errorf("syntax error: expected connection name after WITH CONNECTION but got %q", p.peek().text)
+			}
+			path, err := p.pathExpression()
+			if err != nil {
+				return fmt.Errorf("syntax error: expected connection name after WITH CONNECTION but got %q", p.peek().text)
+			}
+			ref, err := parseExternalConnectionID(strings.Join(path, "."))
+			if err != nil {
+				return err
+			}
+			stmt.Connection = &ConnectionReference{ProjectID: ref.ProjectID, Location: ref.Location, ConnectionID: ref.ConnectionID}
+		}
+	}
+	if stmt.External && p.consumeKeywords("WITH", "PARTITION", "COLUMNS") {
+		stmt.HivePartitioned = true
+		if p.peek().isSymbol("(") {
+			columns, err := p.tableColumns(query)
+			if err != nil {
+				return err
+			}
+			stmt.PartitionColumns = columns
+		}
+	}
+	if p.consumeKeywords("OPTIONS") {
+		options, err := p.options()
+		if err != nil {
+			return err
+		}
+		stmt.Options = options
+	}
+	return nil
+}
+
+// tableColumns parses `(name type [NOT NULL] [OPTIONS(...)], ...)` of the column list.
+func (p *statementParser) tableColumns(query string) ([]*TableColumn, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	var columns []*TableColumn
+	for {
+		name := p.next()
+		if name.kind != tokenWord && name.kind != tokenQuotedIdent {
+			return nil, fmt.Errorf("syntax error: expected column name but got %q", name.text)
+		}
+		var (
+			start = p.idx
+			angle int
+			paren int
+		)
+		for !p.eof() {
+			tk := p.peek()
+			if angle == 0 && paren == 0 && (tk.isSymbol(",") || tk.isSymbol(")") || tk.isKeyword("NOT") || tk.isKeyword("OPTIONS")) {
+				break
+			}
+			switch {
+			case tk.isSymbol("<"):
+				angle++
+			case tk.isSymbol(">"):
+				angle--
+			case tk.isSymbol("("):
+				paren++
+			case tk.isSymbol(")"):
+				paren--
+			}
+			p.next()
+		}
+		if p.idx == start {
+			return nil, fmt.Errorf("syntax error: expected type of column %s but got %q", name.text, p.peek().text)
+		}
+		column := &TableColumn{Name: strings.Trim(name.text, "`"), Type: tokenText(query, p.tokens[start:p.idx])}
+		column.NotNull = p.consumeKeywords("NOT", "NULL")
+		if p.consumeKeywords("OPTIONS") {
+			options, err := p.options()
+			if err != nil {
+				return nil, err
+			}
+			column.Options = options
+		}
+		columns = append(columns, column)
+		if p.consumeSymbol(")") {
+			return columns, nil
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isExternalTableStatement reports whether tokens are CREATE EXTERNAL TABLE, DROP EXTERNAL TABLE,
+// or CREATE TABLE with WITH CONNECTION clause before the query of AS.
+func isExternalTableStatement(tokens []*token) bool {
+	if len(tokens) < 3 {
+		return false
+	}
+	if tokens[0].isKeyword("DROP") {
+		return tokens[1].isKeyword("EXTERNAL") && tokens[2].isKeyword("TABLE")
+	}
+	if !tokens[0].isKeyword("CREATE") {
+		return false
+	}
+	idx := 1
+	if len(tokens) > 3 && tokens[1].isKeyword("OR") && tokens[2].isKeyword("REPLACE") {
+		idx = 3
+	}
+	if idx+1 < len(tokens) && tokens[idx].isKeyword("EXTERNAL") && tokens[idx+1].isKeyword("TABLE") {
+		return true
+	}
+	if idx >= len(tokens) || !tokens[idx].isKeyword("TABLE") {
+		return false
+	}
+	for ; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth != 0 {
+			continue
+		}
+		if tk.isKeyword("AS") {
+			return false
+		}
+		if tk.isKeyword("WITH") && tokens[idx+1].isKeyword("CONNECTION") {
+			return true
+		}
+	}
+	return false
+}
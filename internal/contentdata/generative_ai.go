@@ -0,0 +1,235 @@
+package contentdata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// generativeAIFunctions are the functions of the generative AI that RewriteGenerativeAIFunctions stubs.
+var generativeAIFunctions = map[string]struct{}{
+	"ML.GENERATE_TEXT": {}, "AI.GENERATE_TEXT": {}, "AI.GENERATE": {},
+}
+
+// IsGenerativeAIFunction reports whether the function like ML.GENERATE_TEXT can be stubbed by RewriteGenerativeAIFunctions.
+func IsGenerativeAIFunction(name string) bool {
+	_, exists := generativeAIFunctions[strings.ToUpper(name)]
+	return exists
+}
+
+// generateTextOptions are the options of ML.GENERATE_TEXT and AI.GENERATE_TEXT.
+// The numeric options have the range of the literal value, and the others are not validated except flatten_json_output.
+var generateTextOptions = map[string][]float64{
+	"temperature":               {0, 2},
+	"max_output_tokens":         {1, 8192},
+	"top_k":                     {1, 40},
+	"top_p":                     {0, 1},
+	"flatten_json_output":       nil,
+	"stop_sequences":            nil,
+	"ground_with_google_search": nil,
+	"safety_settings":           nil,
+	"request_type":              nil,
+}
+
+// aiGenerateArguments are the named arguments of AI.GENERATE following the prompt.
+var aiGenerateArguments = map[string]struct{}{
+	"connection_id": {}, "endpoint": {}, "model_params": {}, "output_schema": {}, "request_type": {},
+}
+
+// generativeAIInputAlias is the alias of the input rows of ML.GENERATE_TEXT and AI.GENERATE_TEXT.
+const generativeAIInputAlias = "bqemulator_generative_ai_input"
+
+// RewriteGenerativeAIFunctions replaces ML.GENERATE_TEXT, AI.GENERATE_TEXT and AI.GENERATE with the stubs
+// generating response instead of the inference, where `{prompt}` in response is replaced with the prompt.
+// The table functions return the input rows with the result columns of BigQuery: ML.GENERATE_TEXT returns
+// ml_generate_text_result ( the JSON of the response ) or ml_generate_text_llm_result with flatten_json_output,
+// and ml_generate_text_status, and AI.GENERATE_TEXT returns result, full_response and status.
+// AI.GENERATE returns STRUCT<result STRING, full_response JSON, status STRING>. The NULL prompt has the NULL result
+// and the error status. It also returns the paths of the models of the table functions to check that they exist.
+func RewriteGenerativeAIFunctions(query, response string) (string, [][]string, error) {
+	tokens := tokenize(query)
+	var (
+		edits  []*edit
+		models [][]string
+	)
+	for idx := 0; idx+3 < len(tokens); idx++ {
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		if !(tokens[idx].isKeyword("ML") || tokens[idx].isKeyword("AI")) || !tokens[idx+1].isSymbol(".") ||
+			tokens[idx+2].kind != tokenWord || !tokens[idx+3].isSymbol("(") {
+			continue
+		}
+		name := fmt.Sprintf("%s.%s", strings.ToUpper(tokens[idx].text), strings.ToUpper(tokens[idx+2].text))
+		if !IsGenerativeAIFunction(name) {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+3)
+		args := functionArgs(query, tokens, idx+3, closeIdx)
+		var (
+			replacement string
+			err         error
+		)
+		if name == "AI.GENERATE" {
+			replacement, err = aiGenerateExpr(args, response)
+		} else {
+			var tableModels [][]string
+			replacement, tableModels, err = generateTextTable(name, args, response)
+			models = append(models, tableModels...)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		edits = append(edits, &edit{start: tokens[idx].start, end: tokens[closeIdx].end, replacement: replacement})
+		idx = closeIdx
+	}
+	return applyEdits(query, edits), models, nil
+}
+
+// generateTextTable returns the subquery of `ML.GENERATE_TEXT(MODEL model, {TABLE table | (query)} [, STRUCT(options)])`
+// and the models referred by it and its input.
+func generateTextTable(name string, args []string, response string) (string, [][]string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", nil, fmt.Errorf("%s requires the model, the input and the optional options but got %d arguments", name, len(args))
+	}
+	p := &statementParser{tokens: tokenize(args[0])}
+	if !p.consumeKeywords("MODEL") {
+		return "", nil, fmt.Errorf("%s requires MODEL as the first argument but got %s", name, args[0])
+	}
+	model, err := p.pathExpression()
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if !p.eof() {
+		return "", nil, fmt.Errorf("%s: unexpected %s after the model", name, p.peek().text)
+	}
+	input, err := generativeAIInput(name, args[1])
+	if err != nil {
+		return "", nil, err
+	}
+	// the input query can call the functions too.
+	input, models, err := RewriteGenerativeAIFunctions(input, response)
+	if err != nil {
+		return "", nil, err
+	}
+	var flatten bool
+	if len(args) == 3 {
+		if flatten, err = generateTextFlatten(name, args[2]); err != nil {
+			return "", nil, err
+		}
+	}
+	prompt := fmt.Sprintf("%s.prompt", generativeAIInputAlias)
+	text, fullResponse, status := generativeAIColumns(prompt, response)
+	var columns string
+	switch {
+	case name == "AI.GENERATE_TEXT":
+		columns = fmt.Sprintf("%s AS result, %s AS full_response, %s AS status", text, fullResponse, status)
+	case flatten:
+		columns = fmt.Sprintf(
+			"%s AS ml_generate_text_llm_result, CAST(NULL AS STRING) AS ml_generate_text_rai_result, %s AS ml_generate_text_status",
+			text, status,
+		)
+	default:
+		columns = fmt.Sprintf("%s AS ml_generate_text_result, %s AS ml_generate_text_status", fullResponse, status)
+	}
+	return fmt.Sprintf("(SELECT %[1]s.*, %[2]s FROM (%[3]s) AS %[1]s)", generativeAIInputAlias, columns, input),
+		append([][]string{model}, models...), nil
+}
+
+// generativeAIInput returns the query of the input `TABLE table` or `(query)`, which has the prompt column.
+func generativeAIInput(name, arg string) (string, error) {
+	tokens := tokenize(arg)
+	if len(tokens) > 1 && tokens[0].isKeyword("TABLE") {
+		return fmt.Sprintf("SELECT * FROM %s", strings.TrimSpace(arg[tokens[1].start:])), nil
+	}
+	if len(tokens) > 2 && tokens[0].isSymbol("(") && skipParen(tokens, 0) == len(tokens)-1 &&
+		(tokens[1].isKeyword("SELECT") || tokens[1].isKeyword("WITH")) {
+		return strings.TrimSpace(arg[tokens[0].end:tokens[len(tokens)-1].start]), nil
+	}
+	return "", fmt.Errorf("%s requires TABLE or the query in the parentheses as the input but got %s", name, arg)
+}
+
+// generateTextFlatten validates the options `STRUCT(value AS name, ...)` and returns flatten_json_output.
+func generateTextFlatten(name, arg string) (bool, error) {
+	tokens := tokenize(arg)
+	if len(tokens) < 3 || !tokens[0].isKeyword("STRUCT") || !tokens[1].isSymbol("(") || skipParen(tokens, 1) != len(tokens)-1 {
+		return false, fmt.Errorf("%s requires STRUCT of the options but got %s", name, arg)
+	}
+	var flatten bool
+	for _, item := range functionArgs(arg, tokens, 1, len(tokens)-1) {
+		itemTokens := tokenize(item)
+		n := len(itemTokens)
+		if n < 3 || !itemTokens[n-2].isKeyword("AS") {
+			return false, fmt.Errorf("%s: the option must be `value AS name` but got %s", name, item)
+		}
+		option := strings.ToLower(strings.Trim(itemTokens[n-1].text, "`"))
+		value := strings.TrimSpace(item[:itemTokens[n-2].start])
+		bounds, exists := generateTextOptions[option]
+		if !exists {
+			return false, fmt.Errorf("%s: unknown option %s", name, option)
+		}
+		switch {
+		case option == "flatten_json_output":
+			switch strings.ToUpper(value) {
+			case "TRUE":
+				flatten = true
+			case "FALSE":
+				flatten = false
+			default:
+				return false, fmt.Errorf("%s: flatten_json_output must be TRUE or FALSE but got %s", name, value)
+			}
+		case bounds != nil:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				// the value isn't the literal.
+				continue
+			}
+			if f < bounds[0] || f > bounds[1] {
+				return false, fmt.Errorf("%s: %s must be in [%g, %g] but got %s", name, option, bounds[0], bounds[1], value)
+			}
+		}
+	}
+	return flatten, nil
+}
+
+// aiGenerateExpr returns the expression of `AI.GENERATE(prompt [, name => value ...])`.
+// The prompt is the STRING or the tuple of the parts like `('Describe ', name)`, which are concatenated.
+func aiGenerateExpr(args []string, response string) (string, error) {
+	if len(args) == 0 || strings.Contains(args[0], "=>") {
+		return "", fmt.Errorf("AI.GENERATE requires the prompt as the first argument")
+	}
+	for _, arg := range args[1:] {
+		named := strings.SplitN(arg, "=>", 2)
+		if len(named) != 2 {
+			return "", fmt.Errorf("AI.GENERATE requires the named arguments after the prompt but got %s", arg)
+		}
+		if _, exists := aiGenerateArguments[strings.ToLower(strings.TrimSpace(named[0]))]; !exists {
+			return "", fmt.Errorf("AI.GENERATE: unknown argument %s", strings.TrimSpace(named[0]))
+		}
+	}
+	prompt := args[0]
+	tokens := tokenize(prompt)
+	if len(tokens) > 2 && tokens[0].isSymbol("(") && skipParen(tokens, 0) == len(tokens)-1 {
+		parts := functionArgs(prompt, tokens, 0, len(tokens)-1)
+		if len(parts) > 1 {
+			for i, part := range parts {
+				parts[i] = fmt.Sprintf("CAST(%s AS STRING)", part)
+			}
+			prompt = fmt.Sprintf("CONCAT(%s)", strings.Join(parts, ", "))
+		}
+	}
+	text, fullResponse, status := generativeAIColumns(fmt.Sprintf("(%s)", prompt), response)
+	return fmt.Sprintf("STRUCT(%s AS result, %s AS full_response, %s AS status)", text, fullResponse, status), nil
+}
+
+// generativeAIColumns returns the expressions of the generated text, the JSON of the full response of Gemini
+// and the status of prompt.
+func generativeAIColumns(prompt, response string) (string, string, string) {
+	text := fmt.Sprintf("REPLACE(%s, '{prompt}', %s)", QuoteStringLiteral(response), prompt)
+	fullResponse := fmt.Sprintf(
+		`PARSE_JSON(CONCAT('{"candidates":[{"content":{"parts":[{"text":', TO_JSON_STRING(%s), '}],"role":"model"},"finish_reason":"STOP"}]}'))`,
+		text,
+	)
+	status := fmt.Sprintf("IF(%s IS NULL, 'The prompt is NULL', '')", prompt)
+	return text, fullResponse, status
+}
@@ -0,0 +1,603 @@
+package contentdata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
+// maxStructGroupingRewrites bounds the number of the STRUCT grouping keys rewritten in a query.
+const maxStructGroupingRewrites = 32
+
+// structGroupingBlock is the query block that groups the rows by GROUP BY or SELECT DISTINCT.
+// go-zetasqlite doesn't allow grouping by STRUCT, so the block groups by TO_JSON_STRING of the STRUCT key
+// and reads the STRUCT with ANY_VALUE. The JSON text of the values of the same STRUCT type is equal
+// if and only if their fields are equal, and NULL fields and NULL STRUCTs are grouped together as BigQuery does.
+type structGroupingBlock struct {
+	block *queryBlock
+	keys  []*groupingKey
+	// distinct is true if the block groups by SELECT DISTINCT.
+	distinct bool
+	// probe is the query that selects the keys from the FROM clause of the block to check their types.
+	probe *typeProbe
+}
+
+// groupingKey is the expression that the query block groups by.
+type groupingKey struct {
+	expr string
+	// item is the GROUP BY item of the key, or nil for SELECT DISTINCT.
+	item []*token
+	// selected is the index of the SELECT list item of the key for SELECT DISTINCT.
+	selected int
+}
+
+// structGroupingBlocks returns the query blocks of query that group by GROUP BY or SELECT DISTINCT.
+// The types of the keys are unknown until the probe runs, so they may be the scalar values.
+func structGroupingBlocks(query string) []*structGroupingBlock {
+	tokens := tokenize(query)
+	var blocks []*structGroupingBlock
+	for idx, tk := range tokens {
+		if !tk.isKeyword("SELECT") {
+			continue
+		}
+		block := findQueryBlock(tokens, idx)
+		if block == nil || block.start != idx {
+			continue
+		}
+		grouping := &structGroupingBlock{block: block}
+		if _, exists := block.clauses["GROUP"]; exists {
+			grouping.keys = block.groupByKeys(query)
+		} else if block.start+1 < block.end && tokens[block.start+1].isKeyword("DISTINCT") {
+			grouping.keys = block.distinctKeys(query)
+			grouping.distinct = true
+		}
+		if len(grouping.keys) == 0 {
+			continue
+		}
+		exprs := make([]string, 0, len(grouping.keys))
+		for _, key := range grouping.keys {
+			exprs = append(exprs, key.expr)
+		}
+		grouping.probe = block.typeProbe(query, tokens, exprs)
+		blocks = append(blocks, grouping)
+	}
+	return blocks
+}
+
+// edits returns the edits that group by TO_JSON_STRING of the first STRUCT key of the block
+// by fields, the types of the keys. The other STRUCT keys are rewritten after the query is rewritten by the edits.
+// It returns nil if the types are unknown or no key is STRUCT, and the error of BigQuery
+// if the STRUCT key has the field that can't be grouped.
+func (g *structGroupingBlock) edits(query string, fields []*bigqueryv2.TableFieldSchema) ([]*edit, error) {
+	if len(fields) != len(g.keys) {
+		return nil, nil
+	}
+	for idx, key := range g.keys {
+		field := fields[idx]
+		if field.Mode == "REPEATED" || (field.Type != "RECORD" && field.Type != "STRUCT") {
+			continue
+		}
+		if typ := ungroupableType(field); typ != "" {
+			if g.distinct {
+				return nil, fmt.Errorf("Column %s of type %s cannot be used in SELECT DISTINCT", key.expr, typ)
+			}
+			return nil, fmt.Errorf("Grouping by expressions of type %s is not allowed", typ)
+		}
+		replacement := fmt.Sprintf("TO_JSON_STRING(%s)", key.expr)
+		var edits []*edit
+		if g.distinct {
+			edits = g.block.distinctGroupByEdits(map[int]string{key.selected: replacement})
+		} else {
+			edits = []*edit{{start: key.item[0].start, end: key.item[len(key.item)-1].end, replacement: replacement}}
+		}
+		if len(edits) == 0 {
+			continue
+		}
+		return append(edits, g.block.anyValueEdits(query, key.expr)...), nil
+	}
+	return nil, nil
+}
+
+// queryBlock is the SELECT query block of the tokens.
+type queryBlock struct {
+	tokens []*token
+	// start is the index of the SELECT keyword and end is the index of the token next to the block.
+	start int
+	end   int
+	items []*selectItem
+	// clauses are the indexes of the clause keywords like FROM or GROUP at the depth of the block.
+	clauses map[string]int
+}
+
+// blockClauses are the clauses following the SELECT list in the order of the query syntax.
+var blockClauses = []string{"FROM", "WHERE", "GROUP", "HAVING", "QUALIFY", "WINDOW", "ORDER", "LIMIT"}
+
+// findQueryBlock returns the innermost query block that contains tokens[target].
+func findQueryBlock(tokens []*token, target int) *queryBlock {
+	for start := target; start >= 0; start-- {
+		if !tokens[start].isKeyword("SELECT") || tokens[start].depth > tokens[target].depth {
+			continue
+		}
+		end := queryBlockEnd(tokens, start)
+		if target >= end {
+			continue
+		}
+		block := &queryBlock{
+			tokens:  tokens,
+			start:   start,
+			end:     end,
+			items:   parseSelectList(tokens, start),
+			clauses: map[string]int{},
+		}
+		depth := tokens[start].depth
+		for idx := start + 1; idx < end; idx++ {
+			tk := tokens[idx]
+			if tk.depth != depth {
+				continue
+			}
+			for _, clause := range blockClauses {
+				if _, exists := block.clauses[clause]; !exists && tk.isKeyword(clause) {
+					block.clauses[clause] = idx
+				}
+			}
+		}
+		return block
+	}
+	return nil
+}
+
+func queryBlockEnd(tokens []*token, start int) int {
+	depth := tokens[start].depth
+	for idx := start + 1; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth < depth {
+			return idx
+		}
+		if tk.depth != depth {
+			continue
+		}
+		if tk.isSymbol(";") || tk.isKeyword("UNION") || tk.isKeyword("INTERSECT") {
+			return idx
+		}
+		if tk.isKeyword("EXCEPT") && idx+1 < len(tokens) && !tokens[idx+1].isSymbol("(") {
+			return idx
+		}
+	}
+	return len(tokens)
+}
+
+// clauseEnd returns the index of the token next to the clause starting at tokens[idx].
+func (b *queryBlock) clauseEnd(idx int) int {
+	end := b.end
+	for _, clauseIdx := range b.clauses {
+		if clauseIdx > idx && clauseIdx < end {
+			end = clauseIdx
+		}
+	}
+	return end
+}
+
+// groupingItems returns the items of the GROUP BY clause.
+func (b *queryBlock) groupingItems() [][]*token {
+	groupIdx, exists := b.clauses["GROUP"]
+	if !exists || groupIdx+2 >= b.end || !b.tokens[groupIdx+1].isKeyword("BY") {
+		return nil
+	}
+	var (
+		items [][]*token
+		cur   []*token
+		depth = b.tokens[groupIdx].depth
+	)
+	for idx := groupIdx + 2; idx < b.clauseEnd(groupIdx); idx++ {
+		tk := b.tokens[idx]
+		if tk.depth == depth && tk.isSymbol(",") {
+			items = append(items, cur)
+			cur = nil
+			continue
+		}
+		cur = append(cur, tk)
+	}
+	if len(cur) != 0 {
+		items = append(items, cur)
+	}
+	return items
+}
+
+// selectItemExpr returns the expression of the SELECT list item without its alias, and the alias.
+func selectItemExpr(query string, item *selectItem) (string, string) {
+	tokens := item.tokens
+	n := len(tokens)
+	if n >= 3 && tokens[n-2].isKeyword("AS") {
+		return tokenText(query, tokens[:n-2]), strings.Trim(tokens[n-1].text, "`")
+	}
+	if n >= 2 && isImplicitAlias(tokens[n-2], tokens[n-1]) {
+		return tokenText(query, tokens[:n-1]), strings.Trim(tokens[n-1].text, "`")
+	}
+	return tokenText(query, tokens), ""
+}
+
+// isImplicitAlias reports whether last is the alias without AS like `SELECT x y`.
+func isImplicitAlias(prev, last *token) bool {
+	if last.kind != tokenQuotedIdent && (last.kind != tokenWord || isReservedKeyword(last)) {
+		return false
+	}
+	if prev.isSymbol(")") || prev.isSymbol("]") || prev.kind == tokenQuotedIdent {
+		return true
+	}
+	return prev.kind == tokenWord && !isReservedKeyword(prev)
+}
+
+func isReservedKeyword(tk *token) bool {
+	for _, kw := range reservedKeywords {
+		if tk.isKeyword(kw) {
+			return true
+		}
+	}
+	for _, kw := range []string{"END", "NULL", "TRUE", "FALSE"} {
+		if tk.isKeyword(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+func tokenText(query string, tokens []*token) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+	return query[tokens[0].start:tokens[len(tokens)-1].end]
+}
+
+// groupByKeys returns the keys of the GROUP BY items.
+// The block grouping by ROLLUP, CUBE or GROUPING SETS has no key.
+func (b *queryBlock) groupByKeys(query string) []*groupingKey {
+	items := b.groupingItems()
+	if len(items) == 0 || items[0][0].isKeyword("ROLLUP") || items[0][0].isKeyword("CUBE") ||
+		items[0][0].isKeyword("GROUPING") {
+		return nil
+	}
+	var keys []*groupingKey
+	for _, item := range items {
+		expr := b.groupingKey(query, item)
+		if expr == "" || isLiteralExpr(tokenize(expr)) {
+			continue
+		}
+		keys = append(keys, &groupingKey{expr: expr, item: item, selected: -1})
+	}
+	return keys
+}
+
+// referencedItem returns the index of the SELECT list item that the GROUP BY item refers to
+// by the ordinal, the alias or the same expression.
+func (b *queryBlock) referencedItem(query string, item []*token) int {
+	if len(item) == 1 && item[0].kind == tokenNumber {
+		ordinal, err := strconv.Atoi(item[0].text)
+		if err != nil || ordinal < 1 || ordinal > len(b.items) {
+			return -1
+		}
+		return ordinal - 1
+	}
+	text := tokenText(query, item)
+	for idx, selectItem := range b.items {
+		expr, alias := selectItemExpr(query, selectItem)
+		if len(item) == 1 && alias != "" && strings.EqualFold(strings.Trim(item[0].text, "`"), alias) {
+			return idx
+		}
+		if sameExpr(expr, text) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// groupingKey returns the expression that the GROUP BY item groups by.
+func (b *queryBlock) groupingKey(query string, item []*token) string {
+	if idx := b.referencedItem(query, item); idx >= 0 {
+		if len(item) == 1 && (item[0].kind == tokenNumber || item[0].kind == tokenWord || item[0].kind == tokenQuotedIdent) {
+			if b.items[idx].isStar() {
+				return ""
+			}
+			expr, _ := selectItemExpr(query, b.items[idx])
+			return expr
+		}
+	}
+	return tokenText(query, item)
+}
+
+// distinctKeys returns the keys of the SELECT list items of SELECT DISTINCT.
+// The block that aggregates the rows or selects `*` has no key because it is not rewritten into GROUP BY.
+func (b *queryBlock) distinctKeys(query string) []*groupingKey {
+	var keys []*groupingKey
+	for idx, item := range b.items {
+		if item.isStar() || item.isAggregateOrAnalytic() {
+			return nil
+		}
+		expr, _ := selectItemExpr(query, item)
+		if isLiteralExpr(tokenize(expr)) {
+			continue
+		}
+		keys = append(keys, &groupingKey{expr: expr, selected: idx})
+	}
+	return keys
+}
+
+// isLiteralExpr reports whether tokens are a literal or a query parameter, which can't be STRUCT.
+func isLiteralExpr(tokens []*token) bool {
+	if len(tokens) != 1 {
+		return false
+	}
+	switch tokens[0].kind {
+	case tokenString, tokenNumber, tokenParam:
+		return true
+	}
+	return false
+}
+
+// distinctGroupByEdits replaces SELECT DISTINCT with GROUP BY of the SELECT list items.
+// keys are the grouping keys of the items by the index, and the other items are grouped by their ordinals.
+// The query block that groups or aggregates the rows or selects `*` is not rewritten.
+func (b *queryBlock) distinctGroupByEdits(keys map[int]string) []*edit {
+	distinct := b.tokens[b.start+1]
+	if !distinct.isKeyword("DISTINCT") {
+		return nil
+	}
+	if _, exists := b.clauses["GROUP"]; exists {
+		return nil
+	}
+	grouping := make([]string, 0, len(b.items))
+	for idx, item := range b.items {
+		if item.isStar() || item.isAggregateOrAnalytic() {
+			return nil
+		}
+		if key, exists := keys[idx]; exists {
+			grouping = append(grouping, key)
+		} else {
+			grouping = append(grouping, fmt.Sprint(idx+1))
+		}
+	}
+	// GROUP BY follows FROM and WHERE.
+	insertIdx := b.end
+	for _, clause := range []string{"HAVING", "QUALIFY", "WINDOW", "ORDER", "LIMIT"} {
+		if idx, exists := b.clauses[clause]; exists && idx < insertIdx {
+			insertIdx = idx
+		}
+	}
+	insertAt := b.tokens[b.end-1].end
+	if insertIdx < len(b.tokens) {
+		insertAt = b.tokens[insertIdx].start
+	}
+	return []*edit{
+		{start: distinct.start, end: distinct.end},
+		{start: insertAt, end: insertAt, replacement: fmt.Sprintf(" GROUP BY %s ", strings.Join(grouping, ", "))},
+	}
+}
+
+// anyValueEdits replaces the references to key in the SELECT list, HAVING, QUALIFY and ORDER BY clauses
+// with ANY_VALUE(key), except for the references in the arguments of the aggregate functions and in the subqueries.
+// The SELECT list item of key keeps its column name.
+func (b *queryBlock) anyValueEdits(query string, key string) []*edit {
+	keyTokens := tokenize(key)
+	if len(keyTokens) == 0 {
+		return nil
+	}
+	var edits []*edit
+	for _, item := range b.items {
+		expr, alias := selectItemExpr(query, item)
+		if alias == "" && sameExpr(expr, key) {
+			replacement := fmt.Sprintf("ANY_VALUE(%s)", key)
+			if name := implicitColumnName(keyTokens); name != "" {
+				replacement += fmt.Sprintf(" AS `%s`", name)
+			}
+			edits = append(edits, &edit{start: item.tokens[0].start, end: item.tokens[len(item.tokens)-1].end, replacement: replacement})
+			continue
+		}
+		edits = append(edits, b.referenceEdits(keyTokens, key, item.tokens)...)
+	}
+	for _, clause := range []string{"HAVING", "QUALIFY", "ORDER"} {
+		idx, exists := b.clauses[clause]
+		if !exists {
+			continue
+		}
+		edits = append(edits, b.referenceEdits(keyTokens, key, b.tokens[idx+1:b.clauseEnd(idx)])...)
+	}
+	return edits
+}
+
+func (b *queryBlock) referenceEdits(keyTokens []*token, key string, tokens []*token) []*edit {
+	var edits []*edit
+	for idx := 0; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.isSymbol("(") && idx+1 < len(tokens) && (tokens[idx+1].isKeyword("SELECT") || tokens[idx+1].isKeyword("WITH")) {
+			idx = skipParen(tokens, idx)
+			continue
+		}
+		if tk.kind == tokenWord && idx+1 < len(tokens) && tokens[idx+1].isSymbol("(") {
+			if _, exists := aggregateFuncNames[strings.ToUpper(tk.text)]; exists {
+				idx = skipParen(tokens, idx+1)
+				continue
+			}
+		}
+		if idx+len(keyTokens) > len(tokens) || !sameTokens(tokens[idx:idx+len(keyTokens)], keyTokens) {
+			continue
+		}
+		if idx > 0 && (tokens[idx-1].isSymbol(".") || tokens[idx-1].isKeyword("AS")) {
+			continue
+		}
+		last := tokens[idx+len(keyTokens)-1]
+		edits = append(edits, &edit{start: tk.start, end: last.end, replacement: fmt.Sprintf("ANY_VALUE(%s)", key)})
+		idx += len(keyTokens) - 1
+	}
+	return edits
+}
+
+// probe returns the query that selects key from the FROM clause of the query block.
+// The WITH clause of the statement is kept, so the FROM clause can refer to the common table expressions.
+// The FROM clause that unnests the arrays of the enclosing query blocks like ARRAY(SELECT ... FROM UNNEST(t.arr))
+// is joined to the FROM clauses of the enclosing blocks, so it can refer to their columns.
+func (b *queryBlock) probe(query string, tokens []*token, key string) string {
+	prefix, from := b.probeClauses(query, tokens)
+	if from == "" {
+		return fmt.Sprintf("%sSELECT %s", prefix, key)
+	}
+	return fmt.Sprintf("%sSELECT %s FROM %s LIMIT 0", prefix, key, from)
+}
+
+// typeProbe returns the probe of the types of exprs in the query block same as probe.
+// The probe selects no row even if the block has no FROM clause, so the expressions are not evaluated.
+func (b *queryBlock) typeProbe(query string, tokens []*token, exprs []string) *typeProbe {
+	prefix, from := b.probeClauses(query, tokens)
+	tail := " LIMIT 0"
+	if from != "" {
+		tail = fmt.Sprintf(" FROM %s LIMIT 0", from)
+	}
+	return &typeProbe{head: prefix + "SELECT ", tail: tail, exprs: exprs}
+}
+
+// probeClauses returns the WITH clause of the statement and the FROM clause of the probe query of the block.
+// The FROM clause is empty if the block has no FROM clause.
+func (b *queryBlock) probeClauses(query string, tokens []*token) (string, string) {
+	var prefix string
+	if len(tokens) != 0 && tokens[0].isKeyword("WITH") {
+		for _, tk := range tokens[1:] {
+			if tk.depth == 0 && tk.isKeyword("SELECT") {
+				prefix = query[:tk.start]
+				break
+			}
+		}
+	}
+	fromIdx, exists := b.clauses["FROM"]
+	if !exists {
+		return prefix, ""
+	}
+	end := b.end
+	for _, clause := range []string{"GROUP", "HAVING", "QUALIFY", "WINDOW", "ORDER", "LIMIT"} {
+		if idx, exists := b.clauses[clause]; exists && idx < end {
+			end = idx
+		}
+	}
+	from := tokenText(query, b.tokens[fromIdx+1:end])
+	if outer := b.correlatedFrom(query); len(outer) != 0 {
+		from = strings.Join(append(outer, from), ", ")
+	}
+	return prefix, from
+}
+
+// correlatedFrom returns the FROM items of the enclosing query blocks, from the outermost one,
+// when the query block is an expression subquery whose FROM clause has only UNNEST.
+// It returns nil for the query block in the FROM clause or the WITH clause of the enclosing block.
+func (b *queryBlock) correlatedFrom(query string) []string {
+	var (
+		items []string
+		cur   = b
+	)
+	for cur.unnestsOnly() {
+		start := cur.start
+		if start == 0 || !b.tokens[start-1].isSymbol("(") {
+			break
+		}
+		outer := findQueryBlock(b.tokens, start-1)
+		if outer == nil {
+			break
+		}
+		fromIdx, exists := outer.clauses["FROM"]
+		if !exists || (start > fromIdx && start < outer.clauseEnd(fromIdx)) {
+			break
+		}
+		items = append([]string{tokenText(query, b.tokens[fromIdx+1:outer.clauseEnd(fromIdx)])}, items...)
+		cur = outer
+	}
+	return items
+}
+
+// unnestsOnly reports whether the FROM clause of the query block has only UNNEST items.
+func (b *queryBlock) unnestsOnly() bool {
+	fromIdx, exists := b.clauses["FROM"]
+	if !exists {
+		return false
+	}
+	end := b.clauseEnd(fromIdx)
+	if fromIdx+1 >= end {
+		return false
+	}
+	depth := b.tokens[fromIdx].depth
+	expectItem := true
+	for idx := fromIdx + 1; idx < end; idx++ {
+		tk := b.tokens[idx]
+		if tk.depth != depth {
+			continue
+		}
+		switch {
+		case expectItem:
+			if !tk.isKeyword("UNNEST") {
+				return false
+			}
+			expectItem = false
+		case tk.isSymbol(","):
+			expectItem = true
+		case tk.isKeyword("JOIN"):
+			return false
+		}
+	}
+	return true
+}
+
+func implicitColumnName(tokens []*token) string {
+	for idx, tk := range tokens {
+		if idx%2 == 1 && !tk.isSymbol(".") {
+			return ""
+		}
+		if idx%2 == 0 && tk.kind != tokenWord && tk.kind != tokenQuotedIdent {
+			return ""
+		}
+	}
+	if len(tokens)%2 == 0 {
+		return ""
+	}
+	return strings.Trim(tokens[len(tokens)-1].text, "`")
+}
+
+func sameExpr(a, b string) bool {
+	return sameTokens(tokenize(a), tokenize(b))
+}
+
+func sameTokens(a, b []*token) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx].kind != b[idx].kind {
+			return false
+		}
+		if a[idx].kind == tokenWord {
+			if !strings.EqualFold(a[idx].text, b[idx].text) {
+				return false
+			}
+			continue
+		}
+		if a[idx].text != b[idx].text {
+			return false
+		}
+	}
+	return true
+}
+
+// ungroupableType returns the type in the field that BigQuery doesn't allow to group by.
+// A STRUCT is groupable if all of its fields are groupable.
+func ungroupableType(field *bigqueryv2.TableFieldSchema) string {
+	if field.Mode == "REPEATED" {
+		return "ARRAY"
+	}
+	switch field.Type {
+	case "JSON", "GEOGRAPHY":
+		return field.Type
+	case "RECORD", "STRUCT":
+		for _, f := range field.Fields {
+			if typ := ungroupableType(f); typ != "" {
+				return typ
+			}
+		}
+	}
+	return ""
+}
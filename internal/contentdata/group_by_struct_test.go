@@ -0,0 +1,81 @@
+package contentdata
+
+import (
+	"testing"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
+func TestStructGrouping(t *testing.T) {
+	structField := &bigqueryv2.TableFieldSchema{
+		Type:   "RECORD",
+		Fields: []*bigqueryv2.TableFieldSchema{{Name: "a", Type: "INTEGER"}},
+	}
+	intField := &bigqueryv2.TableFieldSchema{Type: "INTEGER"}
+	tests := []struct {
+		name        string
+		query       string
+		fields      []*bigqueryv2.TableFieldSchema
+		expected    string
+		expectedErr string
+	}{
+		{
+			name:     "group by struct column",
+			query:    "SELECT s, COUNT(*) FROM t GROUP BY s",
+			fields:   []*bigqueryv2.TableFieldSchema{structField},
+			expected: "SELECT ANY_VALUE(s) AS `s`, COUNT(*) FROM t GROUP BY TO_JSON_STRING(s)",
+		},
+		{
+			name:     "group by ordinal",
+			query:    "SELECT id, s FROM t GROUP BY 1, 2",
+			fields:   []*bigqueryv2.TableFieldSchema{intField, structField},
+			expected: "SELECT id, ANY_VALUE(s) AS `s` FROM t GROUP BY 1, TO_JSON_STRING(s)",
+		},
+		{
+			name:     "group by scalar",
+			query:    "SELECT id FROM t GROUP BY id",
+			fields:   []*bigqueryv2.TableFieldSchema{intField},
+			expected: "SELECT id FROM t GROUP BY id",
+		},
+		{
+			name:     "select distinct",
+			query:    "SELECT DISTINCT id, s FROM t",
+			fields:   []*bigqueryv2.TableFieldSchema{intField, structField},
+			expected: "SELECT  id, ANY_VALUE(s) AS `s` FROM t GROUP BY 1, TO_JSON_STRING(s) ",
+		},
+		{
+			name:  "struct of array",
+			query: "SELECT DISTINCT s FROM t",
+			fields: []*bigqueryv2.TableFieldSchema{{
+				Type:   "RECORD",
+				Fields: []*bigqueryv2.TableFieldSchema{{Name: "a", Type: "INTEGER", Mode: "REPEATED"}},
+			}},
+			expectedErr: "Column s of type ARRAY cannot be used in SELECT DISTINCT",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			blocks := structGroupingBlocks(test.query)
+			if len(blocks) != 1 {
+				t.Fatalf("expected one grouping block but got %d", len(blocks))
+			}
+			edits, err := blocks[0].edits(test.query, test.fields)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error %q but got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := applyEdits(test.query, edits); got != test.expected {
+				t.Fatalf("failed to rewrite query:\nexpected: %s\ngot:      %s", test.expected, got)
+			}
+		})
+	}
+	if blocks := structGroupingBlocks("SELECT id, COUNT(*) FROM t GROUP BY ROLLUP(id)"); len(blocks) != 0 {
+		t.Fatal("expected no grouping block for ROLLUP")
+	}
+}
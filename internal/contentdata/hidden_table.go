@@ -0,0 +1,19 @@
+package contentdata
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// HiddenDatasetID is the dataset of the tables that the emulator creates while a statement runs
+// and drops before the statement returns, like the table of the rows returned by THEN RETURN clause.
+// The dataset isn't registered in the metadata, so the tables are never listed with the datasets of the project.
+const HiddenDatasetID = "_bqemulator"
+
+var hiddenTableSeq int64
+
+// HiddenTableID returns the name of the table in HiddenDatasetID that starts with prefix.
+// The name is unique in the process, so the tables of the statements running at the same time never replace each other.
+func HiddenTableID(prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, atomic.AddInt64(&hiddenTableSeq, 1))
+}
@@ -0,0 +1,32 @@
+package contentdata
+
+// StripHints removes the hints `@{key=value, ...}` of the statements, the joins, the tables and the subqueries,
+// because go-zetasqlite doesn't accept the hints of BigQuery. The hints only affect the query plan,
+// so the result is the same without them. It returns the query without the hints and the removed hints in order.
+func StripHints(query string) (string, []string) {
+	tokens := tokenize(query)
+	var (
+		hints []string
+		edits []*edit
+	)
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		if tokens[idx].kind != tokenParam || tokens[idx].text != "@" || !tokens[idx+1].isSymbol("{") {
+			continue
+		}
+		closeIdx := idx + 1
+		for closeIdx < len(tokens) && !tokens[closeIdx].isSymbol("}") {
+			closeIdx++
+		}
+		if closeIdx == len(tokens) {
+			// the unterminated hint is reported by go-zetasqlite as the syntax error.
+			break
+		}
+		hints = append(hints, query[tokens[idx].start:tokens[closeIdx].end])
+		edits = append(edits, &edit{start: tokens[idx].start, end: tokens[closeIdx].end, replacement: " "})
+		idx = closeIdx
+	}
+	if len(edits) == 0 {
+		return query, nil
+	}
+	return applyEdits(query, edits), hints
+}
@@ -0,0 +1,162 @@
+package contentdata
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// rowWindow is LIMIT and OFFSET of the outermost ORDER BY that the emulator applies to the result rows.
+type rowWindow struct {
+	// limit is -1 if the query has only OFFSET.
+	limit  int64
+	offset int64
+}
+
+// skip reports whether the row at the index ( counted from the first row of the result ) is before OFFSET.
+func (w *rowWindow) skip(idx int64) bool {
+	return w != nil && idx < w.offset
+}
+
+// done reports whether the rows read so far already reached LIMIT.
+func (w *rowWindow) done(read int64) bool {
+	return w != nil && w.limit >= 0 && read >= w.limit
+}
+
+// orderedRowWindow removes LIMIT and OFFSET following ORDER BY of the outermost query, and returns them as the window of the result rows.
+// go-zetasqlite applies LIMIT and OFFSET by the outer query of the subquery having ORDER BY,
+// and SQLite doesn't always keep the order of the subquery ( e.g. over SELECT DISTINCT ), so the page of the rows may be wrong.
+// Reading the rows of the outermost ORDER BY in order and applying OFFSET and LIMIT to them
+// is the same as BigQuery: DISTINCT first, then ORDER BY, OFFSET and LIMIT.
+// The window is nil when the query doesn't end with ORDER BY ... LIMIT of the integer literals or the INT64 parameters,
+// and for the statements other than the query like CREATE TABLE AS SELECT and CREATE VIEW, whose rows are not returned.
+// The positional parameter of LIMIT and OFFSET is removed from the returned values.
+func orderedRowWindow(query string, values []interface{}) (string, []interface{}, *rowWindow, error) {
+	if !IsQueryStatement(query) {
+		return query, values, nil, nil
+	}
+	tokens := tokenize(query)
+	for len(tokens) > 0 && tokens[len(tokens)-1].isSymbol(";") {
+		tokens = tokens[:len(tokens)-1]
+	}
+	limitIdx := -1
+	for idx := len(tokens) - 1; idx >= 0; idx-- {
+		if tokens[idx].depth == 0 && tokens[idx].isKeyword("LIMIT") {
+			limitIdx = idx
+			break
+		}
+	}
+	if limitIdx < 1 {
+		return query, values, nil, nil
+	}
+	var ordered bool
+	for idx := limitIdx - 1; idx > 0; idx-- {
+		tk := tokens[idx]
+		if tk.depth != 0 {
+			continue
+		}
+		if tk.isKeyword("UNION") || tk.isKeyword("INTERSECT") || tk.isKeyword("EXCEPT") || tk.isKeyword("SELECT") {
+			break
+		}
+		if tk.isKeyword("BY") && tokens[idx-1].isKeyword("ORDER") {
+			ordered = true
+			break
+		}
+	}
+	if !ordered {
+		return query, values, nil, nil
+	}
+	window := &rowWindow{limit: -1}
+	removedParams := map[int]struct{}{}
+	idx := limitIdx + 1
+	for _, clause := range []string{"LIMIT", "OFFSET"} {
+		if clause == "OFFSET" {
+			if idx >= len(tokens) {
+				break
+			}
+			if !tokens[idx].isKeyword("OFFSET") {
+				return query, values, nil, nil
+			}
+			idx++
+		}
+		if idx >= len(tokens) {
+			return query, values, nil, nil
+		}
+		v, paramIdx, ok := windowValue(tokens, idx, values)
+		if !ok {
+			return query, values, nil, nil
+		}
+		if v < 0 {
+			return "", nil, nil, fmt.Errorf("%s expects a non-negative integer literal or parameter", clause)
+		}
+		if paramIdx >= 0 {
+			removedParams[paramIdx] = struct{}{}
+		}
+		if clause == "LIMIT" {
+			window.limit = v
+		} else {
+			window.offset = v
+		}
+		idx++
+	}
+	if idx != len(tokens) {
+		return query, values, nil, nil
+	}
+	if len(removedParams) != 0 {
+		remaining := make([]interface{}, 0, len(values))
+		for i, v := range values {
+			if _, exists := removedParams[i]; !exists {
+				remaining = append(remaining, v)
+			}
+		}
+		values = remaining
+	}
+	rewritten := applyEdits(query, []*edit{{start: tokens[limitIdx].start, end: tokens[idx-1].end}})
+	return rewritten, values, window, nil
+}
+
+// windowValue returns the value of the integer literal or the INT64 parameter at tokens[idx].
+// The index of the value in values is returned for the positional parameter, otherwise it is -1.
+func windowValue(tokens []*token, idx int, values []interface{}) (int64, int, bool) {
+	tk := tokens[idx]
+	switch tk.kind {
+	case tokenNumber:
+		v, err := strconv.ParseInt(tk.text, 10, 64)
+		return v, -1, err == nil
+	case tokenParam:
+		if tk.text == "?" {
+			var pos int
+			for _, prev := range tokens[:idx] {
+				if prev.kind == tokenParam && prev.text == "?" {
+					pos++
+				}
+			}
+			if pos >= len(values) {
+				return 0, -1, false
+			}
+			v, ok := windowParamValue(values[pos])
+			return v, pos, ok
+		}
+		for _, value := range values {
+			named, ok := value.(sql.NamedArg)
+			if !ok || "@"+named.Name != tk.text {
+				continue
+			}
+			v, ok := windowParamValue(named.Value)
+			return v, -1, ok
+		}
+	}
+	return 0, -1, false
+}
+
+// windowParamValue returns the integer of the query parameter value, which is passed as the string.
+func windowParamValue(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,211 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ModelStatementKind is the kind of the model statement.
+type ModelStatementKind int
+
+const (
+	// ModelCreate is `CREATE [OR REPLACE] MODEL [IF NOT EXISTS] name ... [OPTIONS(...)] [AS query]`.
+	ModelCreate ModelStatementKind = iota
+	// ModelAlter is `ALTER MODEL [IF EXISTS] name SET OPTIONS(...)`.
+	ModelAlter
+	// ModelDrop is `DROP MODEL [IF EXISTS] name`.
+	ModelDrop
+	// ModelExport is `EXPORT MODEL name [OPTIONS(...)]`.
+	ModelExport
+)
+
+// ModelStatement is the CREATE MODEL, ALTER MODEL, DROP MODEL or EXPORT MODEL statement.
+// The emulator doesn't train the model, the statement only changes the metadata of the model.
+type ModelStatement struct {
+	Kind        ModelStatementKind
+	OrReplace   bool
+	IfNotExists bool
+	IfExists    bool
+	// ModelPath is the name of the model split by the dot. It has one to three elements.
+	ModelPath []string
+	// Remote is true if the model is created with REMOTE WITH CONNECTION clause.
+	Remote  bool
+	Options []*Option
+	// Query is the training query of AS clause. It is empty if the model is created without the training data.
+	// The query of AS (training_data AS (...), custom_holiday AS (...)) is the training data.
+	Query string
+}
+
+// ParseModelStatement parses query as CREATE MODEL, ALTER MODEL, DROP MODEL or EXPORT MODEL statement.
+// It returns nil without error when query is not the statement.
+func ParseModelStatement(query string) (*ModelStatement, error) {
+	tokens := statementTokens(query)
+	if tokens == nil || !isModelStatement(tokens) {
+		return nil, nil
+	}
+	p := &statementParser{tokens: tokens}
+	stmt := &ModelStatement{}
+	switch {
+	case p.consumeKeywords("CREATE"):
+		stmt.OrReplace = p.consumeKeywords("OR", "REPLACE")
+		p.consumeKeywords("MODEL")
+		stmt.IfNotExists = p.consumeKeywords("IF", "NOT", "EXISTS")
+		if stmt.OrReplace && stmt.IfNotExists {
+			return nil, fmt.Errorf("CREATE OR REPLACE MODEL cannot be used with IF NOT EXISTS")
+		}
+	case p.consumeKeywords("ALTER", "MODEL"):
+		stmt.Kind = ModelAlter
+		stmt.IfExists = p.consumeKeywords("IF", "EXISTS")
+	case p.consumeKeywords("DROP", "MODEL"):
+		stmt.Kind = ModelDrop
+		stmt.IfExists = p.consumeKeywords("IF", "EXISTS")
+	case p.consumeKeywords("EXPORT", "MODEL"):
+		stmt.Kind = ModelExport
+	}
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil, err
+	}
+	if len(path) > 3 {
+		return nil, fmt.Errorf("invalid model name %s", strings.Join(path, "."))
+	}
+	stmt.ModelPath = path
+	switch stmt.Kind {
+	case ModelCreate:
+		if err := p.createModelClauses(query, stmt); err != nil {
+			return nil, err
+		}
+	case ModelAlter:
+		if !p.consumeKeywords("SET", "OPTIONS") {
+			return nil, fmt.Errorf("syntax error: expected SET OPTIONS but got %q", p.peek().text)
+		}
+		options, err := p.options()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Options = options
+	case ModelExport:
+		if p.consumeKeywords("OPTIONS") {
+			options, err := p.options()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Options = options
+		}
+	}
+	if !p.eof() {
+		return nil, fmt.Errorf("syntax error: unexpected %s", p.peek().text)
+	}
+	return stmt, nil
+}
+
+// createModelClauses parses the clauses after the model name of CREATE MODEL.
+// TRANSFORM, INPUT and OUTPUT only affect the training, so they are skipped.
+func (p *statementParser) createModelClauses(query string, stmt *ModelStatement) error {
+	if p.consumeKeywords("TRANSFORM") {
+		if err := p.skipParen(); err != nil {
+			return err
+		}
+	}
+	if p.consumeKeywords("INPUT") {
+		if err := p.skipParen(); err != nil {
+			return err
+		}
+		if !p.consumeKeywords("OUTPUT") {
+			return fmt.Errorf("syntax error: expected OUTPUT but got %q", p.peek().text)
+		}
+		if err := p.skipParen(); err != nil {
+			return err
+		}
+	}
+	if p.consumeKeywords("REMOTE", "WITH", "CONNECTION") {
+		stmt.Remote = true
+		if !p.consumeKeywords("DEFAULT") {
+			if _, err := p.pathExpression(); err != nil {
+				return err
+			}
+		}
+	}
+	if p.consumeKeywords("OPTIONS") {
+		options, err := p.options()
+		if err != nil {
+			return err
+		}
+		stmt.Options = options
+	}
+	if !p.consumeKeywords("AS") {
+		return nil
+	}
+	if p.eof() {
+		return fmt.Errorf("syntax error: expected query after AS")
+	}
+	start := p.idx
+	if p.peek().isSymbol("(") && p.idx+1 < len(p.tokens) && p.tokens[p.idx+1].kind == tokenWord && !p.tokens[p.idx+1].isKeyword("SELECT") && !p.tokens[p.idx+1].isKeyword("WITH") {
+		return p.trainingDataQuery(query, stmt)
+	}
+	stmt.Query = query[p.tokens[start].start:p.tokens[len(p.tokens)-1].end]
+	p.idx = len(p.tokens)
+	return nil
+}
+
+// trainingDataQuery parses `(training_data AS (query), custom_holiday AS (query))`.
+func (p *statementParser) trainingDataQuery(query string, stmt *ModelStatement) error {
+	if err := p.expectSymbol("("); err != nil {
+		return err
+	}
+	for {
+		name := p.next()
+		if name.kind != tokenWord || !p.consumeKeywords("AS") {
+			return fmt.Errorf("syntax error: expected training_data AS (query) but got %q", name.text)
+		}
+		if !p.peek().isSymbol("(") {
+			return fmt.Errorf("syntax error: expected ( but got %q", p.peek().text)
+		}
+		open := p.idx
+		if err := p.skipParen(); err != nil {
+			return err
+		}
+		if name.isKeyword("TRAINING_DATA") && p.idx-1 > open+1 {
+			stmt.Query = query[p.tokens[open+1].start:p.tokens[p.idx-2].end]
+		}
+		if p.consumeSymbol(")") {
+			return nil
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return err
+		}
+	}
+}
+
+// isModelStatement reports whether tokens is the statement of the model.
+func isModelStatement(tokens []*token) bool {
+	idx := 0
+	switch {
+	case len(tokens) > 1 && tokens[0].isKeyword("CREATE"):
+		idx = 1
+		if len(tokens) > 3 && tokens[1].isKeyword("OR") && tokens[2].isKeyword("REPLACE") {
+			idx = 3
+		}
+	case len(tokens) > 1 && (tokens[0].isKeyword("ALTER") || tokens[0].isKeyword("DROP") || tokens[0].isKeyword("EXPORT")):
+		idx = 1
+	default:
+		return false
+	}
+	return idx < len(tokens) && tokens[idx].isKeyword("MODEL")
+}
+
+// MLFunctionName returns the name of the first ML or AI function ( e.g. ML.EVALUATE or AI.GENERATE ) called in query.
+// It returns the empty string if query doesn't call ML and AI functions.
+func MLFunctionName(query string) string {
+	tokens := tokenize(query)
+	for idx := 0; idx+3 < len(tokens); idx++ {
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		if (tokens[idx].isKeyword("ML") || tokens[idx].isKeyword("AI")) && tokens[idx+1].isSymbol(".") &&
+			tokens[idx+2].kind == tokenWord && tokens[idx+3].isSymbol("(") {
+			return fmt.Sprintf("%s.%s", strings.ToUpper(tokens[idx].text), strings.ToUpper(tokens[idx+2].text))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package contentdata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
+// parameterTypeNames are the legacy names of the parameter types and their GoogleSQL names.
+var parameterTypeNames = map[string]string{
+	"INTEGER": "INT64",
+	"FLOAT":   "FLOAT64",
+	"BOOLEAN": "BOOL",
+	"RECORD":  "STRUCT",
+}
+
+// inlineCompositeParameters replaces the STRUCT and ARRAY query parameters in query with their literals,
+// and returns the query and the other parameters. go-zetasqlite infers the types of the parameters
+// from their Go values, so it can't bind the empty arrays, the NULL fields and the field types
+// of the structs declared by parameterType, and the field access like `@param.field` fails.
+// The positional parameters are replaced in order, so the rest of them keep their positions.
+func inlineCompositeParameters(query string, params []*bigqueryv2.QueryParameter) (string, []*bigqueryv2.QueryParameter, error) {
+	var composite bool
+	for _, param := range params {
+		if isCompositeParameter(param) {
+			composite = true
+			break
+		}
+	}
+	if !composite {
+		return query, params, nil
+	}
+	var (
+		positional []*bigqueryv2.QueryParameter
+		named      = map[string]*bigqueryv2.QueryParameter{}
+		rest       []*bigqueryv2.QueryParameter
+	)
+	for _, param := range params {
+		if param.Name == "" {
+			positional = append(positional, param)
+		} else {
+			named[strings.ToLower(param.Name)] = param
+		}
+		if !isCompositeParameter(param) {
+			rest = append(rest, param)
+		}
+	}
+	var (
+		edits []*edit
+		pos   int
+	)
+	for _, tk := range tokenize(query) {
+		if tk.kind != tokenParam || strings.HasPrefix(tk.text, "@@") {
+			continue
+		}
+		var param *bigqueryv2.QueryParameter
+		if tk.text == "?" {
+			if pos < len(positional) {
+				param = positional[pos]
+			}
+			pos++
+		} else {
+			param = named[strings.ToLower(strings.TrimPrefix(tk.text, "@"))]
+		}
+		if param == nil || !isCompositeParameter(param) {
+			continue
+		}
+		literal, err := parameterLiteral(param.ParameterType, param.ParameterValue)
+		if err != nil {
+			name := param.Name
+			if name == "" {
+				name = strconv.Itoa(pos)
+			}
+			return "", nil, fmt.Errorf("invalid query parameter %s: %w", name, err)
+		}
+		edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: "(" + literal + ")"})
+	}
+	return applyEdits(query, edits), rest, nil
+}
+
+// PositionalParameters returns the number of the positional parameters `?` in query.
+func PositionalParameters(query string) int {
+	var n int
+	for _, tk := range tokenize(query) {
+		if tk.kind == tokenParam && tk.text == "?" {
+			n++
+		}
+	}
+	return n
+}
+
+func isCompositeParameter(param *bigqueryv2.QueryParameter) bool {
+	if param.ParameterType == nil {
+		return false
+	}
+	switch parameterTypeName(param.ParameterType.Type) {
+	case "ARRAY", "STRUCT":
+		return true
+	}
+	return false
+}
+
+func parameterTypeName(typ string) string {
+	typ = strings.ToUpper(typ)
+	if name, exists := parameterTypeNames[typ]; exists {
+		return name
+	}
+	return typ
+}
+
+// parameterType returns the GoogleSQL type of the parameter type like `ARRAY<STRUCT<id INT64, name STRING>>`.
+func parameterType(typ *bigqueryv2.QueryParameterType) (string, error) {
+	if typ == nil {
+		return "", fmt.Errorf("parameter type is missing")
+	}
+	switch name := parameterTypeName(typ.Type); name {
+	case "ARRAY":
+		elem, err := parameterType(typ.ArrayType)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("ARRAY<%s>", elem), nil
+	case "STRUCT":
+		fields := make([]string, 0, len(typ.StructTypes))
+		for _, field := range typ.StructTypes {
+			fieldType, err := parameterType(field.Type)
+			if err != nil {
+				return "", err
+			}
+			if field.Name == "" {
+				fields = append(fields, fieldType)
+				continue
+			}
+			fields = append(fields, fmt.Sprintf("`%s` %s", field.Name, fieldType))
+		}
+		return fmt.Sprintf("STRUCT<%s>", strings.Join(fields, ", ")), nil
+	case "":
+		return "", fmt.Errorf("parameter type is missing")
+	default:
+		return name, nil
+	}
+}
+
+// parameterLiteral returns the literal of the parameter value of typ.
+// The missing value is NULL, and so is the empty value of the types other than STRING and BYTES.
+func parameterLiteral(typ *bigqueryv2.QueryParameterType, value *bigqueryv2.QueryParameterValue) (string, error) {
+	typeName, err := parameterType(typ)
+	if err != nil {
+		return "", err
+	}
+	null := fmt.Sprintf("CAST(NULL AS %s)", typeName)
+	if value == nil {
+		return null, nil
+	}
+	switch parameterTypeName(typ.Type) {
+	case "ARRAY":
+		elems := make([]string, 0, len(value.ArrayValues))
+		for _, v := range value.ArrayValues {
+			elem, err := parameterLiteral(typ.ArrayType, v)
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, elem)
+		}
+		return fmt.Sprintf("%s[%s]", typeName, strings.Join(elems, ", ")), nil
+	case "STRUCT":
+		if len(value.StructValues) == 0 {
+			return null, nil
+		}
+		fields := make([]string, 0, len(typ.StructTypes))
+		for _, field := range typ.StructTypes {
+			var fieldValue *bigqueryv2.QueryParameterValue
+			if v, exists := value.StructValues[field.Name]; exists {
+				fieldValue = &v
+			}
+			literal, err := parameterLiteral(field.Type, fieldValue)
+			if err != nil {
+				return "", err
+			}
+			fields = append(fields, literal)
+		}
+		return fmt.Sprintf("%s(%s)", typeName, strings.Join(fields, ", ")), nil
+	}
+	v := value.Value
+	switch typeName {
+	case "STRING":
+		return QuoteStringLiteral(v), nil
+	case "BYTES":
+		return fmt.Sprintf("FROM_BASE64(%s)", QuoteStringLiteral(v)), nil
+	}
+	if v == "" {
+		return null, nil
+	}
+	switch typeName {
+	case "INT64":
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return "", fmt.Errorf("invalid INT64 value %q", v)
+		}
+		return v, nil
+	case "BOOL":
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", fmt.Errorf("invalid BOOL value %q", v)
+		}
+		return strings.ToUpper(strconv.FormatBool(b)), nil
+	case "JSON":
+		return fmt.Sprintf("PARSE_JSON(%s)", QuoteStringLiteral(v)), nil
+	case "GEOGRAPHY":
+		return fmt.Sprintf("ST_GEOGFROMTEXT(%s)", QuoteStringLiteral(v)), nil
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", QuoteStringLiteral(v), typeName), nil
+}
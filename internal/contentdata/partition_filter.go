@@ -0,0 +1,175 @@
+package contentdata
+
+import "strings"
+
+// PartitionFilters returns the literal values compared with the columns by `column = literal` in query.
+// The values are used to skip reading the partitions that can't match, so only the comparisons
+// that are the top-level AND conjuncts of the WHERE clause of the query block scanning the table of the name path are used.
+// It returns nil if the table is scanned more than once or joined with the other tables,
+// with which the comparisons may not restrict the rows of the table.
+// A string literal is returned unquoted and the typed literal like DATE '2024-01-01' is returned as its string.
+func PartitionFilters(query string, table []string, columns []string) map[string]string {
+	tokens := tokenize(query)
+	scan := -1
+	var scans int
+	walkTableReferences(tokens, func(path []string, start, _ int) {
+		if !sameNamePath(path, table) {
+			return
+		}
+		scans++
+		for idx, tk := range tokens {
+			if tk.start == start {
+				scan = idx
+				break
+			}
+		}
+	})
+	if scans != 1 || scan < 0 {
+		return nil
+	}
+	block := findQueryBlock(tokens, scan)
+	if block == nil {
+		return nil
+	}
+	where, exists := block.clauses["WHERE"]
+	if !exists {
+		return nil
+	}
+	from := block.clauses["FROM"]
+	depth := tokens[from].depth
+	for idx := from + 1; idx < where; idx++ {
+		if tokens[idx].depth == depth && (tokens[idx].isSymbol(",") || tokens[idx].isKeyword("JOIN")) {
+			return nil
+		}
+	}
+	filters := map[string]string{}
+	conjunct := where + 1
+	end := block.clauseEnd(where)
+	for idx := where + 1; idx <= end; idx++ {
+		if idx < end && (tokens[idx].depth != depth || !tokens[idx].isKeyword("AND")) {
+			continue
+		}
+		if idx < end && isBetweenAnd(tokens, conjunct, idx) {
+			continue
+		}
+		if column, value, ok := partitionComparison(tokens[conjunct:idx], columns); ok {
+			filters[column] = value
+		}
+		conjunct = idx + 1
+	}
+	return filters
+}
+
+// isBetweenAnd reports whether AND at idx is the one of `x BETWEEN a AND b` in the conjunct starting at start.
+func isBetweenAnd(tokens []*token, start, idx int) bool {
+	for i := idx - 1; i >= start; i-- {
+		if tokens[i].depth != tokens[idx].depth {
+			continue
+		}
+		if tokens[i].isKeyword("BETWEEN") {
+			return true
+		}
+		if tokens[i].isKeyword("AND") {
+			return false
+		}
+	}
+	return false
+}
+
+// partitionComparison returns the column and the value if tokens are `column = literal` or `literal = column`.
+// The column can be qualified by the table name or the alias.
+func partitionComparison(tokens []*token, columns []string) (string, string, bool) {
+	eq := -1
+	for idx, tk := range tokens {
+		if tk.isSymbol("=") {
+			eq = idx
+			break
+		}
+	}
+	if eq <= 0 || eq+1 >= len(tokens) {
+		return "", "", false
+	}
+	left, right := tokens[:eq], tokens[eq+1:]
+	if column := partitionColumn(left[len(left)-1], columns); column != "" && partitionPathStart(left, len(left)-1) == 0 {
+		if v, n, ok := partitionLiteral(right); ok && n == len(right) {
+			return column, v, true
+		}
+		return "", "", false
+	}
+	if column := partitionColumn(right[len(right)-1], columns); column != "" && partitionPathStart(right, len(right)-1) == 0 {
+		if v, n, ok := partitionLiteral(left); ok && n == len(left) {
+			return column, v, true
+		}
+	}
+	return "", "", false
+}
+
+// sameNamePath reports whether the name paths are the same ignoring the backquotes.
+func sameNamePath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if strings.Trim(a[idx], "`") != strings.Trim(b[idx], "`") {
+			return false
+		}
+	}
+	return true
+}
+
+// partitionPathStart returns the index of the first name of the path expression that ends at tokens[end].
+func partitionPathStart(tokens []*token, end int) int {
+	start := end
+	for start >= 2 && tokens[start-1].isSymbol(".") && (tokens[start-2].kind == tokenWord || tokens[start-2].kind == tokenQuotedIdent) {
+		start -= 2
+	}
+	return start
+}
+
+// FiltersColumns reports whether the WHERE clause of query refers to one of the columns.
+func FiltersColumns(query string, columns []string) bool {
+	var inWhere bool
+	for _, tk := range tokenize(query) {
+		if tk.isKeyword("WHERE") {
+			inWhere = true
+			continue
+		}
+		if inWhere && partitionColumn(tk, columns) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func partitionColumn(tk *token, columns []string) string {
+	if tk.kind != tokenWord && tk.kind != tokenQuotedIdent {
+		return ""
+	}
+	name := strings.Trim(tk.text, "`")
+	for _, column := range columns {
+		if strings.EqualFold(name, column) {
+			return column
+		}
+	}
+	return ""
+}
+
+// partitionLiteral returns the value of the literal at the beginning of tokens and the number of its tokens.
+func partitionLiteral(tokens []*token) (string, int, bool) {
+	if len(tokens) == 0 {
+		return "", 0, false
+	}
+	tk, n := tokens[0], 1
+	if tk.kind == tokenWord && len(tokens) > 1 && tokens[1].kind == tokenString {
+		// the typed literal
+		tk, n = tokens[1], 2
+	}
+	switch tk.kind {
+	case tokenString:
+		v, ok := stringLiteralValue(tk)
+		return v, n, ok
+	case tokenNumber:
+		return tk.text, n, true
+	}
+	return "", 0, false
+}
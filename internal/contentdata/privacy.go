@@ -0,0 +1,105 @@
+package contentdata
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var contributionBoundsArgPattern = regexp.MustCompile(`(?is)^contribution_bounds_per_(group|row)\s*=>`)
+
+// anonymizationFunctions are the aggregate functions of SELECT WITH ANONYMIZATION and their ordinary aggregations.
+var anonymizationFunctions = map[string]string{
+	"ANON_COUNT":      "COUNT",
+	"ANON_SUM":        "SUM",
+	"ANON_AVG":        "AVG",
+	"ANON_VAR_POP":    "VAR_POP",
+	"ANON_STDDEV_POP": "STDDEV_POP",
+}
+
+// StripDifferentialPrivacy removes the privacy clause ( WITH DIFFERENTIAL_PRIVACY or WITH ANONYMIZATION ) and its OPTIONS
+// from SELECT, and rewrites the aggregate functions of the clause into the ordinary aggregations.
+// The contribution bounds and the CLAMPED BETWEEN clause are removed, so the result has no noise and no clamping.
+// It returns the name of the clause found in query, or an empty string if query has no privacy clause.
+func StripDifferentialPrivacy(query string) (string, string) {
+	tokens := tokenize(query)
+	var (
+		clause string
+		edits  []*edit
+	)
+	for idx := 0; idx+2 < len(tokens); idx++ {
+		if !tokens[idx].isKeyword("SELECT") || !tokens[idx+1].isKeyword("WITH") {
+			continue
+		}
+		name := tokens[idx+2]
+		if !name.isKeyword("DIFFERENTIAL_PRIVACY") && !name.isKeyword("ANONYMIZATION") {
+			continue
+		}
+		if clause == "" {
+			clause = strings.ToUpper(name.text)
+		}
+		end := idx + 2
+		if end+2 < len(tokens) && tokens[end+1].isKeyword("OPTIONS") && tokens[end+2].isSymbol("(") {
+			end = skipParen(tokens, end+2)
+		}
+		edits = append(edits, &edit{start: tokens[idx+1].start, end: tokens[end].end})
+		idx = end
+	}
+	if clause == "" {
+		return query, ""
+	}
+	return stripPrivacyArgs(applyEdits(query, edits)), clause
+}
+
+// stripPrivacyArgs rewrites the anonymization functions and removes the privacy specific arguments of the function calls.
+func stripPrivacyArgs(query string) string {
+	tokens := tokenize(query)
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name, changed := anonymizationFunctions[strings.ToUpper(tk.text)]
+		if !changed {
+			name = tk.text
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		stripped := make([]string, 0, len(args))
+		for _, arg := range args {
+			if contributionBoundsArgPattern.MatchString(arg) {
+				changed = true
+				continue
+			}
+			s := stripPrivacyArgs(stripClamped(arg))
+			if s != arg {
+				changed = true
+			}
+			stripped = append(stripped, s)
+		}
+		if !changed {
+			continue
+		}
+		edits = append(edits, &edit{
+			start:       tk.start,
+			end:         tokens[closeIdx].end,
+			replacement: fmt.Sprintf("%s(%s)", name, strings.Join(stripped, ", ")),
+		})
+		idx = closeIdx
+	}
+	return applyEdits(query, edits)
+}
+
+// stripClamped removes `CLAMPED BETWEEN lower AND upper` from the argument of the anonymization function.
+func stripClamped(arg string) string {
+	for _, tk := range tokenize(arg) {
+		if tk.depth == 0 && tk.isKeyword("CLAMPED") {
+			return strings.TrimSpace(arg[:tk.start])
+		}
+	}
+	return arg
+}
@@ -0,0 +1,95 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReferencesColumn reports whether query refers to the column of the name like `name` or `alias.name`.
+// The name in the string literals and the comments, the function of the name and the alias named name are not the references.
+func ReferencesColumn(query, name string) bool {
+	tokens := tokenize(query)
+	for idx, tk := range tokens {
+		if (tk.kind != tokenWord && tk.kind != tokenQuotedIdent) || !strings.EqualFold(strings.Trim(tk.text, "`"), name) {
+			continue
+		}
+		if idx+1 < len(tokens) && tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isKeyword("AS") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// ExceptStarColumn excludes the column from the star expansions `*` and `alias.*` of the query blocks that read
+// the table of the name path, so the pseudo-column added to the content of the table is not expanded by SELECT *.
+// The column is added to the existing EXCEPT list like `* EXCEPT (a)`.
+func ExceptStarColumn(query string, table []string, column string) string {
+	tokens := tokenize(query)
+	edits := map[int]*edit{}
+	walkTableReferences(tokens, func(path []string, start, end int) {
+		if !sameNamePath(path, table) {
+			return
+		}
+		scan, next := -1, len(tokens)
+		for idx, tk := range tokens {
+			if tk.start == start {
+				scan = idx
+			}
+			if tk.end == end {
+				next = idx + 1
+				break
+			}
+		}
+		if scan < 0 {
+			return
+		}
+		block := findQueryBlock(tokens, scan)
+		if block == nil {
+			return
+		}
+		alias := path[len(path)-1]
+		if hasTableAlias(tokens, next) {
+			if tokens[next].isKeyword("AS") {
+				next++
+			}
+			if next < len(tokens) {
+				alias = tokens[next].text
+			}
+		}
+		for _, item := range block.items {
+			if e := exceptStarColumnEdit(item, strings.Trim(alias, "`"), column); e != nil {
+				edits[e.start] = e
+			}
+		}
+	})
+	list := make([]*edit, 0, len(edits))
+	for _, e := range edits {
+		list = append(list, e)
+	}
+	return applyEdits(query, list)
+}
+
+// exceptStarColumnEdit returns the edit excluding the column from the star expansion of the item,
+// or nil if the item is not `*` or `alias.*`.
+func exceptStarColumnEdit(item *selectItem, alias, column string) *edit {
+	tokens := item.tokens
+	star := -1
+	switch {
+	case len(tokens) >= 1 && tokens[0].isSymbol("*"):
+		star = 0
+	case len(tokens) >= 3 && tokens[1].isSymbol(".") && tokens[2].isSymbol("*") && strings.EqualFold(strings.Trim(tokens[0].text, "`"), alias):
+		star = 2
+	}
+	if star < 0 {
+		return nil
+	}
+	if star+2 < len(tokens) && tokens[star+1].isKeyword("EXCEPT") && tokens[star+2].isSymbol("(") {
+		closeIdx := skipParen(tokens, star+2)
+		return &edit{start: tokens[closeIdx].start, end: tokens[closeIdx].start, replacement: fmt.Sprintf(", `%s`", column)}
+	}
+	return &edit{start: tokens[star].end, end: tokens[star].end, replacement: fmt.Sprintf(" EXCEPT (`%s`)", column)}
+}
@@ -0,0 +1,193 @@
+package contentdata
+
+import (
+	"strings"
+)
+
+// QueryPlanClauses is the clauses of the statement that make the stages of the simplified query plan.
+// The texts are the clauses as written in the statement with the whitespaces collapsed.
+type QueryPlanClauses struct {
+	// Target is the table changed by the DML statement, and TargetPath is its name path.
+	Target     string
+	TargetPath []string
+	// Filter is the condition of the WHERE clause.
+	Filter string
+	// Joins are the joins like `LEFT OUTER JOIN ON a.id = b.id` in the order of the statement.
+	// The condition of MERGE is the join of the target and the source.
+	Joins []string
+	// Aggregates are the GROUP BY clause, DISTINCT and the aggregate function calls of the SELECT list.
+	Aggregates []string
+	// Analytic reports whether the SELECT list has the analytic function calls.
+	Analytic bool
+	// OrderBy is the ORDER BY clause.
+	OrderBy string
+	// Limit is the LIMIT clause.
+	Limit string
+}
+
+var queryPlanClauseKeywords = []string{
+	"WHERE", "GROUP", "HAVING", "QUALIFY", "WINDOW", "ORDER", "LIMIT", "UNION", "INTERSECT",
+	"JOIN", "INNER", "LEFT", "RIGHT", "FULL", "CROSS", "ON", "USING", "SET", "WHEN",
+}
+
+var joinTypeKeywords = []string{"INNER", "LEFT", "RIGHT", "FULL", "OUTER", "CROSS"}
+
+// ParseQueryPlanClauses returns the clauses of the query or the DML statement at the top level of query.
+// The clauses in the subqueries and the WITH clause are not included.
+// It returns nil if query is a script or the other statement such as DDL.
+func ParseQueryPlanClauses(query string) *QueryPlanClauses {
+	tokens := statementTokens(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+	clauses := &QueryPlanClauses{}
+	start := -1
+	switch typ := DMLStatementType(query); {
+	case typ != "":
+		p := &statementParser{tokens: tokens}
+		p.next()
+		p.consumeKeywords("INTO")
+		p.consumeKeywords("FROM")
+		path, err := (&statementParser{tokens: tokens, idx: p.idx}).pathExpression()
+		if err != nil {
+			return nil
+		}
+		target, _, err := p.dmlTarget(query)
+		if err != nil {
+			return nil
+		}
+		clauses.Target = target
+		clauses.TargetPath = path
+		start = p.idx
+	case tokens[0].isKeyword("SELECT") || tokens[0].isKeyword("WITH") || tokens[0].isSymbol("("):
+	default:
+		return nil
+	}
+	for idx := start + 1; idx < len(tokens); idx++ {
+		if tokens[idx].depth == 0 && tokens[idx].isKeyword("SELECT") {
+			clauses.addSelectList(query, tokens, idx)
+			start = idx
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	for idx := start; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth != 0 {
+			continue
+		}
+		switch {
+		case tk.isKeyword("WHERE"):
+			end := queryPlanClauseEnd(tokens, idx+1)
+			clauses.Filter = queryPlanText(query, tokens[idx+1:end])
+			idx = end - 1
+		case tk.isKeyword("JOIN"):
+			typeStart := idx
+			for typeStart > 0 && isJoinTypeKeyword(tokens[typeStart-1]) {
+				typeStart--
+			}
+			join := strings.ToUpper(queryPlanText(query, tokens[typeStart:idx+1]))
+			if join == "JOIN" {
+				join = "INNER JOIN"
+			}
+			end := queryPlanClauseEnd(tokens, idx+1)
+			if end < len(tokens) && (tokens[end].isKeyword("ON") || tokens[end].isKeyword("USING")) {
+				condEnd := queryPlanClauseEnd(tokens, end+1)
+				join += " " + queryPlanText(query, tokens[end:condEnd])
+				end = condEnd
+			}
+			clauses.Joins = append(clauses.Joins, join)
+			idx = end - 1
+		case tk.isKeyword("ON") && clauses.Target != "" && DMLStatementType(query) == "MERGE":
+			end := queryPlanClauseEnd(tokens, idx+1)
+			clauses.Joins = append(clauses.Joins, "MERGE "+queryPlanText(query, tokens[idx:end]))
+			idx = end - 1
+		case tk.isKeyword("GROUP") && idx+1 < len(tokens) && tokens[idx+1].isKeyword("BY"):
+			end := queryPlanClauseEnd(tokens, idx+2)
+			clauses.Aggregates = append([]string{queryPlanText(query, tokens[idx:end])}, clauses.Aggregates...)
+			idx = end - 1
+		case tk.isKeyword("ORDER") && idx+1 < len(tokens) && tokens[idx+1].isKeyword("BY"):
+			end := queryPlanClauseEnd(tokens, idx+2)
+			clauses.OrderBy = queryPlanText(query, tokens[idx:end])
+			idx = end - 1
+		case tk.isKeyword("LIMIT"):
+			end := queryPlanClauseEnd(tokens, idx+1)
+			clauses.Limit = queryPlanText(query, tokens[idx:end])
+			idx = end - 1
+		}
+	}
+	return clauses
+}
+
+// addSelectList adds DISTINCT, the aggregate function calls and the analytic function calls of the SELECT list
+// which starts at tokens[idx].
+func (c *QueryPlanClauses) addSelectList(query string, tokens []*token, idx int) {
+	if idx+1 < len(tokens) && tokens[idx+1].isKeyword("DISTINCT") {
+		c.Aggregates = append(c.Aggregates, "DISTINCT")
+	}
+	for _, item := range parseSelectList(tokens, idx) {
+		if !item.isAggregateOrAnalytic() {
+			continue
+		}
+		analytic := false
+		for _, tk := range item.tokens {
+			if tk.depth == item.tokens[0].depth && tk.isKeyword("OVER") {
+				analytic = true
+				break
+			}
+		}
+		if analytic {
+			c.Analytic = true
+			continue
+		}
+		for i := 0; i+1 < len(item.tokens); i++ {
+			tk := item.tokens[i]
+			if tk.kind != tokenWord || !item.tokens[i+1].isSymbol("(") {
+				continue
+			}
+			if _, exists := aggregateFuncNames[strings.ToUpper(tk.text)]; !exists {
+				continue
+			}
+			end := skipParen(item.tokens, i+1)
+			c.Aggregates = append(c.Aggregates, queryPlanText(query, item.tokens[i:end+1]))
+			i = end
+		}
+	}
+}
+
+func queryPlanClauseEnd(tokens []*token, idx int) int {
+	for ; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth != 0 {
+			continue
+		}
+		if (tk.isKeyword("LEFT") || tk.isKeyword("RIGHT")) && idx+1 < len(tokens) && tokens[idx+1].isSymbol("(") {
+			// the function call.
+			continue
+		}
+		for _, kw := range queryPlanClauseKeywords {
+			if tk.isKeyword(kw) {
+				return idx
+			}
+		}
+		if tk.isKeyword("EXCEPT") && idx+1 < len(tokens) && !tokens[idx+1].isSymbol("(") {
+			return idx
+		}
+	}
+	return len(tokens)
+}
+
+func isJoinTypeKeyword(tk *token) bool {
+	for _, kw := range joinTypeKeywords {
+		if tk.isKeyword(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+func queryPlanText(query string, tokens []*token) string {
+	return strings.Join(strings.Fields(tokenText(query, tokens)), " ")
+}
@@ -0,0 +1,71 @@
+package contentdata
+
+import (
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"math/rand"
+
+	"github.com/google/uuid"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+)
+
+const (
+	// generateUUIDFunction and randFunction are the SQLite functions that go-zetasqlite calls for GENERATE_UUID and RAND.
+	generateUUIDFunction = "zetasqlite_generate_uuid"
+	randFunction         = "zetasqlite_rand"
+)
+
+// seedRandom overrides GENERATE_UUID and RAND on the connection of tx with the ones reading the random source
+// seeded by seed and query, until the returned function restores the ones reading crypto/rand.
+// The override is local to the connection, so the queries running on the other connections are not affected.
+func seedRandom(tx *connection.Tx, seed int64, query string) (func(), error) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(query))
+	//nolint:gosec
+	source := rand.New(rand.NewSource(seed ^ int64(h.Sum64())))
+	if err := registerRandomFunctions(tx, source); err != nil {
+		return nil, err
+	}
+	return func() {
+		_ = registerRandomFunctions(tx, crand.Reader)
+	}, nil
+}
+
+// registerRandomFunctions registers GENERATE_UUID and RAND reading source on the connection of tx.
+// They take the variadic arguments same as the functions of go-zetasqlite, so they replace them.
+func registerRandomFunctions(tx *connection.Tx, source io.Reader) error {
+	if err := tx.RegisterFunction(generateUUIDFunction, func(...interface{}) (string, error) {
+		id, err := uuid.NewRandomFromReader(source)
+		if err != nil {
+			return "", err
+		}
+		return encodeStringValue(id.String())
+	}); err != nil {
+		return err
+	}
+	return tx.RegisterFunction(randFunction, func(...interface{}) (float64, error) {
+		var b [8]byte
+		if _, err := io.ReadFull(source, b[:]); err != nil {
+			return 0, err
+		}
+		// the upper 53 bits are the mantissa of the value in [0, 1).
+		return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53), nil
+	})
+}
+
+// encodeStringValue encodes the STRING value v in the format that go-zetasqlite stores the values in SQLite.
+func encodeStringValue(v string) (string, error) {
+	b, err := json.Marshal(struct {
+		Header string `json:"header"`
+		Body   string `json:"body"`
+	}{Header: "string", Body: v})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
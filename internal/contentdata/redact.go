@@ -0,0 +1,17 @@
+package contentdata
+
+// RedactQuery replaces the string, bytes and number literals in query with `?`
+// so that the logged query doesn't contain the values.
+// The typed literal like DATE '2024-01-01' keeps its type name.
+func RedactQuery(query string) string {
+	var edits []*edit
+	for _, tk := range tokenize(query) {
+		if tk.kind == tokenString || tk.kind == tokenNumber {
+			edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: "?"})
+		}
+	}
+	if len(edits) == 0 {
+		return query
+	}
+	return applyEdits(query, edits)
+}
@@ -0,0 +1,122 @@
+package contentdata
+
+import "strings"
+
+// tableReferenceKeywords are the keywords followed by the table name.
+var tableReferenceKeywords = []string{"FROM", "JOIN", "INTO", "UPDATE", "DELETE", "MERGE", "USING", "TABLE", "VIEW", "COPY", "CLONE"}
+
+// TableReferences returns the name paths like [dataset table] or [project dataset table] of the tables referenced by query.
+// The names are collected from the positions where the table name can appear, so the result may contain
+// the names that are not tables ( e.g. the column reference in the select list following a comma ).
+// The caller is expected to resolve each name with the catalog.
+func TableReferences(query string) [][]string {
+	var refs [][]string
+	walkTableReferences(tokenize(query), func(path []string, _, _ int) {
+		refs = append(refs, path)
+	})
+	return refs
+}
+
+// TableNames returns the name paths of the tables referenced by query like TableReferences,
+// and the unqualified names like [table] following FROM, JOIN, INTO, UPDATE and so on, which the caller resolves with the default dataset.
+// The unqualified names referring to the common table expressions are not included.
+func TableNames(query string) [][]string {
+	tokens := tokenize(query)
+	refs := [][]string{}
+	walkTableReferences(tokens, func(path []string, _, _ int) {
+		refs = append(refs, path)
+	})
+	scopes := withScopes(tokens)
+	for idx := range tokens {
+		if !isUnqualifiedTableName(tokens, idx) {
+			continue
+		}
+		if _, entry := resolveWithEntry(scopes, tokens, idx); entry != nil {
+			continue
+		}
+		refs = append(refs, []string{strings.Trim(tokens[idx].text, "`")})
+	}
+	return refs
+}
+
+// ReplaceTableReferences replaces the table names referenced by query with the expression returned by replace.
+// The name is kept as is when replace returns false.
+func ReplaceTableReferences(query string, replace func(path []string) (string, bool, error)) (string, error) {
+	var (
+		edits []*edit
+		err   error
+	)
+	walkTableReferences(tokenize(query), func(path []string, start, end int) {
+		if err != nil {
+			return
+		}
+		expr, ok, replaceErr := replace(path)
+		if replaceErr != nil {
+			err = replaceErr
+			return
+		}
+		if ok {
+			edits = append(edits, &edit{start: start, end: end, replacement: expr})
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return applyEdits(query, edits), nil
+}
+
+// walkTableReferences calls fn with each name path that has two or more names and its position in the query.
+func walkTableReferences(tokens []*token, fn func(path []string, start, end int)) {
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if !tk.isSymbol(",") && !isTableReferenceKeyword(tk) {
+			continue
+		}
+		next := tokens[idx+1]
+		if next.kind != tokenWord && next.kind != tokenQuotedIdent {
+			continue
+		}
+		p := &statementParser{tokens: tokens, idx: idx + 1}
+		path, err := p.pathExpression()
+		if err != nil || len(path) < 2 {
+			continue
+		}
+		fn(path, next.start, tokens[p.idx-1].end)
+		idx = p.idx - 1
+	}
+}
+
+func isTableReferenceKeyword(tk *token) bool {
+	for _, kw := range tableReferenceKeywords {
+		if tk.isKeyword(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnqualifiedTableName reports whether the name at idx is the table or the table function without the dataset,
+// following the keyword of the table reference, INSERT, or the comma of the FROM clause.
+func isUnqualifiedTableName(tokens []*token, idx int) bool {
+	tk := tokens[idx]
+	if idx == 0 || (tk.kind != tokenWord && tk.kind != tokenQuotedIdent) || (tk.kind == tokenWord && isReservedKeyword(tk)) {
+		return false
+	}
+	if tk.kind == tokenQuotedIdent && strings.Contains(tk.text, ".") {
+		return false
+	}
+	if idx+1 < len(tokens) && tokens[idx+1].isSymbol(".") {
+		return false
+	}
+	prev := tokens[idx-1]
+	if prev.isKeyword("FROM") {
+		if idx >= 2 && tokens[idx-2].isKeyword("DISTINCT") {
+			// IS DISTINCT FROM
+			return false
+		}
+		if open := enclosingParen(tokens, idx); open > 0 && tokens[open-1].isKeyword("EXTRACT") {
+			return false
+		}
+	}
+	return isTableReferenceKeyword(prev) || prev.isKeyword("INSERT") || isCTETableReference(tokens, idx)
+}
@@ -3,8 +3,11 @@ package contentdata
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/goccy/go-zetasqlite"
@@ -19,6 +22,10 @@ import (
 
 type Repository struct {
 	db *sql.DB
+	// logRedaction redacts the literal values of the logged queries and omits the parameters and the rows.
+	logRedaction bool
+	// randomSeed seeds GENERATE_UUID and RAND if it is not nil.
+	randomSeed *int64
 }
 
 func NewRepository(db *sql.DB) *Repository {
@@ -27,6 +34,17 @@ func NewRepository(db *sql.DB) *Repository {
 	}
 }
 
+// SetLogRedaction sets whether the literal values of the logged queries are redacted.
+func (r *Repository) SetLogRedaction(enabled bool) {
+	r.logRedaction = enabled
+}
+
+// SetRandomSeed makes GENERATE_UUID and RAND return the same values every time the same query runs.
+// The values differ from row to row and from query to query, because the seed is mixed with the query.
+func (r *Repository) SetRandomSeed(seed int64) {
+	r.randomSeed = &seed
+}
+
 func (r *Repository) getConnection(ctx context.Context, projectID, datasetID string) (*sql.Conn, error) {
 	if projectID == "" {
 		return nil, fmt.Errorf("invalid projectID. projectID is empty")
@@ -91,7 +109,7 @@ func (r *Repository) CreateTable(ctx context.Context, tx *connection.Tx, table *
 	}
 	fields := make([]string, 0, len(table.Schema.Fields))
 	for _, field := range table.Schema.Fields {
-		fields = append(fields, fmt.Sprintf("`%s` %s", field.Name, r.encodeSchemaField(field)))
+		fields = append(fields, r.encodeColumn(field))
 	}
 	tablePath := r.tablePath(ref.ProjectId, ref.DatasetId, ref.TableId)
 	query := fmt.Sprintf("CREATE TABLE `%s` (%s)", tablePath, strings.Join(fields, ","))
@@ -101,6 +119,54 @@ func (r *Repository) CreateTable(ctx context.Context, tx *connection.Tx, table *
 	return nil
 }
 
+// RecreateTable replaces the table with the table of the schema of table and copies the rows into it.
+// The columns of the schema that the old table doesn't have are NULL.
+// It adds the columns instead of ALTER TABLE ADD COLUMN that go-zetasqlite doesn't support.
+func (r *Repository) RecreateTable(ctx context.Context, tx *connection.Tx, table *bigqueryv2.Table) error {
+	ref := table.TableReference
+	if ref == nil {
+		return fmt.Errorf("TableReference is nil")
+	}
+	tx.SetProjectAndDataset(ref.ProjectId, ref.DatasetId)
+	if err := tx.ContentRepoMode(); err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.MetadataRepoMode()
+	}()
+
+	tablePath := r.tablePath(ref.ProjectId, ref.DatasetId, ref.TableId)
+	tmpPath := r.tablePath(ref.ProjectId, ref.DatasetId, ref.TableId+"_bqemulator_recreate")
+	rows, err := tx.Tx().QueryContext(ctx, fmt.Sprintf("SELECT * FROM `%s` LIMIT 0", tablePath))
+	if err != nil {
+		return fmt.Errorf("failed to get columns of table %s: %w", ref.TableId, err)
+	}
+	oldColumns, err := rows.Columns()
+	_ = rows.Close()
+	if err != nil {
+		return fmt.Errorf("failed to get columns of table %s: %w", ref.TableId, err)
+	}
+	fields := make([]string, 0, len(table.Schema.Fields))
+	for _, field := range table.Schema.Fields {
+		fields = append(fields, r.encodeColumn(field))
+	}
+	columns := make([]string, 0, len(oldColumns))
+	for _, column := range oldColumns {
+		columns = append(columns, fmt.Sprintf("`%s`", column))
+	}
+	for _, query := range []string{
+		fmt.Sprintf("CREATE TABLE `%s` AS SELECT * FROM `%s`", tmpPath, tablePath),
+		fmt.Sprintf("CREATE OR REPLACE TABLE `%s` (%s)", tablePath, strings.Join(fields, ",")),
+		fmt.Sprintf("INSERT `%s` (%[3]s) SELECT %[3]s FROM `%[2]s`", tablePath, tmpPath, strings.Join(columns, ",")),
+		fmt.Sprintf("DROP TABLE `%s`", tmpPath),
+	} {
+		if _, err := tx.Tx().ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to recreate table %s: %w", ref.TableId, err)
+		}
+	}
+	return nil
+}
+
 func (r *Repository) CreateView(ctx context.Context, tx *connection.Tx, table *bigqueryv2.Table) error {
 	if err := tx.ContentRepoMode(); err != nil {
 		return err
@@ -112,18 +178,34 @@ func (r *Repository) CreateView(ctx context.Context, tx *connection.Tx, table *b
 	if ref == nil {
 		return fmt.Errorf("TableReference is nil")
 	}
-	viewDefinition := table.View
-	if viewDefinition == nil {
+	var viewQuery string
+	switch {
+	case table.View != nil:
+		viewQuery = table.View.Query
+	case table.MaterializedView != nil:
+		// the materialized view is always up to date as the ordinary view.
+		viewQuery = table.MaterializedView.Query
+	default:
 		return fmt.Errorf("ViewDefinition is nil")
 	}
 	tablePath := r.tablePath(ref.ProjectId, ref.DatasetId, ref.TableId)
-	query := fmt.Sprintf("CREATE VIEW `%s` AS (%s)", tablePath, viewDefinition.Query)
+	query := fmt.Sprintf("CREATE VIEW `%s` AS (%s)", tablePath, viewQuery)
 	if _, err := tx.Tx().ExecContext(ctx, query); err != nil {
 		return fmt.Errorf("failed to create view %s: %w", query, err)
 	}
 	return nil
 }
 
+// encodeColumn returns the column definition of the field. The REQUIRED field is NOT NULL,
+// so the table rejects NULL for it like BigQuery.
+func (r *Repository) encodeColumn(field *bigqueryv2.TableFieldSchema) string {
+	column := fmt.Sprintf("`%s` %s", field.Name, r.encodeSchemaField(field))
+	if strings.EqualFold(field.Mode, string(types.RequiredMode)) {
+		column += " NOT NULL"
+	}
+	return column
+}
+
 func (r *Repository) encodeSchemaField(field *bigqueryv2.TableFieldSchema) string {
 	var elem string
 	if field.Type == "RECORD" {
@@ -141,7 +223,22 @@ func (r *Repository) encodeSchemaField(field *bigqueryv2.TableFieldSchema) strin
 	return elem
 }
 
+// ResultLimit is the limit of the rows and the bytes of the query result. The zero value of each field means no limit.
+type ResultLimit struct {
+	Rows  int64
+	Bytes int64
+}
+
+// ErrResponseTooLarge is returned by QueryWithLimit when the result exceeds the limit.
+var ErrResponseTooLarge = errors.New("Response too large to return")
+
 func (r *Repository) Query(ctx context.Context, tx *connection.Tx, projectID, datasetID, query string, params []*bigqueryv2.QueryParameter) (*internaltypes.QueryResponse, error) {
+	return r.QueryWithLimit(ctx, tx, projectID, datasetID, query, params, ResultLimit{})
+}
+
+// QueryWithLimit runs the query same as Query, and stops reading the result with ErrResponseTooLarge
+// as soon as the rows read so far exceed the limit.
+func (r *Repository) QueryWithLimit(ctx context.Context, tx *connection.Tx, projectID, datasetID, query string, params []*bigqueryv2.QueryParameter, limit ResultLimit) (*internaltypes.QueryResponse, error) {
 	tx.SetProjectAndDataset(projectID, datasetID)
 	if err := tx.ContentRepoMode(); err != nil {
 		return nil, err
@@ -150,9 +247,16 @@ func (r *Repository) Query(ctx context.Context, tx *connection.Tx, projectID, da
 		_ = tx.MetadataRepoMode()
 	}()
 
+	query, params, err := inlineCompositeParameters(query, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) == 0 {
+		query = r.pushdownAggregates(ctx, tx, query)
+	}
 	values := []interface{}{}
 	for _, param := range params {
-		value, err := r.queryParameterValueToGoValue(param.ParameterValue)
+		value, err := r.queryParameterValueToGoValue(param.ParameterType, param.ParameterValue)
 		if err != nil {
 			return nil, err
 		}
@@ -162,16 +266,74 @@ func (r *Repository) Query(ctx context.Context, tx *connection.Tx, projectID, da
 			values = append(values, value)
 		}
 	}
-	fields := []*bigqueryv2.TableFieldSchema{}
-	logger.Logger(ctx).Info(
-		"",
-		zap.String("query", query),
-		zap.Any("values", values),
-	)
-	rows, err := tx.Tx().QueryContext(ctx, query, values...)
+	query = r.timestampStrings(ctx, tx, query, values)
+	query, err = rewriteQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	query, err = r.pivotOperators(ctx, tx, query, values)
+	if err != nil {
+		return nil, err
+	}
+	query, err = r.setOperationsByName(ctx, tx, query, values)
+	if err != nil {
+		return nil, err
+	}
+	types := r.newExpressionTypes(tx, values)
+	query = r.arraySubscripts(ctx, types, query)
+	types.prefetch(ctx, typedRewriteProbes(query))
+	query = r.structEquality(ctx, types, query)
+	query = r.numericArithmetic(ctx, types, query)
+	query = r.numericAverage(ctx, types, query)
+	query = r.numericRounding(ctx, types, query)
+	query = r.formatCalls(ctx, types, query)
+	query = r.bitwiseOperations(ctx, types, query)
+	query, err = r.structGroupings(ctx, types, query)
+	if err != nil {
+		return nil, err
+	}
+	if r.randomSeed != nil {
+		restore, err := seedRandom(tx, *r.randomSeed, query)
+		if err != nil {
+			return nil, err
+		}
+		defer restore()
+	}
+	if r.logRedaction {
+		logger.Logger(ctx).Info("", zap.String("query", RedactQuery(query)), zap.Int("values", len(values)))
+	} else {
+		logger.Logger(ctx).Info(
+			"",
+			zap.String("query", query),
+			zap.Any("values", values),
+		)
+	}
+	var response *internaltypes.QueryResponse
+	if typ := DMLStatementType(query); typ != "" {
+		response, err = r.execDML(ctx, tx, projectID, datasetID, typ, query, values, limit)
+	} else {
+		var window *rowWindow
+		query, values, window, err = orderedRowWindow(query, values)
+		if err != nil {
+			return nil, err
+		}
+		response, err = r.queryRows(ctx, tx, query, values, limit, window)
+	}
 	if err != nil {
 		return nil, err
 	}
+	removeEmulatorFunctions(response.ChangedCatalog)
+	return response, nil
+}
+
+// queryRows runs the query and reads the rows of the result.
+// The rows out of window are skipped if window is not nil.
+func (r *Repository) queryRows(ctx context.Context, tx *connection.Tx, query string, values []interface{}, limit ResultLimit, window *rowWindow) (*internaltypes.QueryResponse, error) {
+	fields := []*bigqueryv2.TableFieldSchema{}
+	rows, err := tx.Tx().QueryContext(ctx, withEmulatorFunctions(query), values...)
+	if err != nil {
+		return nil, arrayIndexError(err)
+	}
 	defer rows.Close()
 	changedCatalog, err := zetasqlite.ChangedCatalogFromRows(rows)
 	if err != nil {
@@ -200,12 +362,28 @@ func (r *Repository) Query(ctx context.Context, tx *connection.Tx, projectID, da
 		}
 		fields = append(fields, types.TableFieldSchemaFromZetaSQLType(colNames[i], zetasqlType))
 	}
+	// BigQuery returns the fields of the struct as the columns of the value table.
+	flattenValueTable := len(fields) == 1 && fields[0].Type == "RECORD" && fields[0].Mode != string(types.RepeatedMode) &&
+		isValueTableQuery(query)
+	if flattenValueTable {
+		fields = fields[0].Fields
+		colNames = make([]string, 0, len(fields))
+		for _, field := range fields {
+			colNames = append(colNames, field.Name)
+		}
+	}
 
 	var (
 		totalBytes int64
 		result     = [][]interface{}{}
+		rowIdx     int64
 	)
-	for rows.Next() {
+	for !window.done(int64(len(tableRows))) && rows.Next() {
+		if window.skip(rowIdx) {
+			rowIdx++
+			continue
+		}
+		rowIdx++
 		values := make([]interface{}, 0, len(columnTypes))
 		for i := 0; i < len(columnTypes); i++ {
 			var v interface{}
@@ -215,16 +393,30 @@ func (r *Repository) Query(ctx context.Context, tx *connection.Tx, projectID, da
 			return nil, fmt.Errorf("failed to scan row: %w", err)
 		}
 		if err := rows.Err(); err != nil {
-			return nil, fmt.Errorf("failed to execute query: %w", err)
+			return nil, fmt.Errorf("failed to execute query: %w", arrayIndexError(err))
+		}
+		rowValues := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			rowValues = append(rowValues, reflect.ValueOf(value).Elem().Interface())
 		}
-		cells := make([]*internaltypes.TableCell, 0, len(values))
-		resultValues := make([]interface{}, 0, len(values))
-		for idx, value := range values {
-			v := reflect.ValueOf(value).Elem().Interface()
+		if flattenValueTable {
+			rowValues, err = structFieldValues(rowValues[0], len(fields))
+			if err != nil {
+				return nil, err
+			}
+		}
+		cells := make([]*internaltypes.TableCell, 0, len(rowValues))
+		resultValues := make([]interface{}, 0, len(rowValues))
+		for idx, v := range rowValues {
 			if v == nil && fields[idx].Mode == string(types.RepeatedMode) {
 				// GoogleSQL for BigQuery translates a NULL array into an empty array in the query result
 				v = []interface{}{}
 			}
+			if s, ok := v.(string); ok {
+				if err := CheckNumericRange(fields[idx].Type, s); err != nil {
+					return nil, err
+				}
+			}
 			cell, err := r.convertValueToCell(v)
 			if err != nil {
 				return nil, fmt.Errorf("failed to convert value to cell: %w", err)
@@ -234,15 +426,31 @@ func (r *Repository) Query(ctx context.Context, tx *connection.Tx, projectID, da
 			totalBytes += cell.Bytes
 			resultValues = append(resultValues, v)
 		}
+		if limit.Rows > 0 && int64(len(tableRows)) >= limit.Rows {
+			return nil, fmt.Errorf(
+				"%w. Consider specifying a destination table in your job configuration: the result exceeds the limit of %d rows",
+				ErrResponseTooLarge, limit.Rows,
+			)
+		}
+		if limit.Bytes > 0 && totalBytes > limit.Bytes {
+			return nil, fmt.Errorf(
+				"%w. Consider specifying a destination table in your job configuration: the result exceeds the limit of %d bytes",
+				ErrResponseTooLarge, limit.Bytes,
+			)
+		}
 		result = append(result, resultValues)
 		tableRows = append(tableRows, &internaltypes.TableRow{
 			F: cells,
 		})
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to scan rows: %w", err)
+		return nil, fmt.Errorf("failed to scan rows: %w", arrayIndexError(err))
+	}
+	if r.logRedaction {
+		logger.Logger(ctx).Debug("query result", zap.Int("rows", len(tableRows)))
+	} else {
+		logger.Logger(ctx).Debug("query result", zap.Any("rows", result))
 	}
-	logger.Logger(ctx).Debug("query result", zap.Any("rows", result))
 	return &internaltypes.QueryResponse{
 		Schema: &bigqueryv2.TableSchema{
 			Fields: fields,
@@ -255,12 +463,367 @@ func (r *Repository) Query(ctx context.Context, tx *connection.Tx, projectID, da
 	}, nil
 }
 
-func (r *Repository) queryParameterValueToGoValue(value *bigqueryv2.QueryParameterValue) (interface{}, error) {
+// structGroupings rewrites the grouping by the STRUCT keys in query into the grouping by TO_JSON_STRING of the keys.
+// One key is rewritten at a time and the query blocks are found again in the rewritten query,
+// so the keys of the same block and of the nested blocks don't overlap their edits.
+// The keys of the query block are kept if the probe query of the block fails.
+func (r *Repository) structGroupings(ctx context.Context, types *expressionTypes, query string) (string, error) {
+	for i := 0; i < maxStructGroupingRewrites; i++ {
+		var edits []*edit
+		for _, block := range structGroupingBlocks(query) {
+			blockEdits, err := block.edits(query, types.fields(ctx, block.probe))
+			if err != nil {
+				return "", err
+			}
+			if len(blockEdits) != 0 {
+				edits = blockEdits
+				break
+			}
+		}
+		if len(edits) == 0 {
+			break
+		}
+		query = applyEdits(query, edits)
+	}
+	return query, nil
+}
+
+// structEquality rewrites the equality comparisons of STRUCT values in query into the comparisons of their fields.
+// The comparisons of the query block are kept if the probe query of the block fails.
+func (r *Repository) structEquality(ctx context.Context, types *expressionTypes, query string) string {
+	var edits []*edit
+	for _, block := range structEqualityBlocks(query) {
+		edits = append(edits, block.edits(types.fields(ctx, block.probe))...)
+	}
+	if len(edits) == 0 {
+		return query
+	}
+	return applyEdits(query, edits)
+}
+
+// timestampStrings casts the arguments of STRING converted into TIMESTAMP in query to STRING,
+// so that rewriteQuery rewrites only the conversions from STRING by their syntax.
+// Every argument of the query block is cast if the probe query of the block fails.
+func (r *Repository) timestampStrings(ctx context.Context, tx *connection.Tx, query string, values []interface{}) string {
+	var edits []*edit
+	for _, block := range timestampStringBlocks(query) {
+		var fields []*bigqueryv2.TableFieldSchema
+		if block.probe != "" {
+			fields = r.probeFields(ctx, tx, block.probe, values)
+		}
+		edits = append(edits, block.edits(fields)...)
+	}
+	if len(edits) == 0 {
+		return query
+	}
+	return applyEdits(query, edits)
+}
+
+// arraySubscripts rewrites the subscripts without accessor of ARRAY values in query into OFFSET.
+// The subscripts of the other values like JSON are kept as is.
+func (r *Repository) arraySubscripts(ctx context.Context, types *expressionTypes, query string) string {
+	blocks, outside := arraySubscripts(query)
+	var edits []*edit
+	for _, block := range blocks {
+		edits = append(edits, block.edits(types.fields(ctx, block.probe))...)
+	}
+	for _, subscript := range outside {
+		edits = append(edits, subscript.edits()...)
+	}
+	if len(edits) == 0 {
+		return query
+	}
+	return applyEdits(query, edits)
+}
+
+// numericArithmetic rounds the products and the quotients of NUMERIC and BIGNUMERIC values in query to the scale of their types.
+// The operations whose types can't be probed are kept as is.
+func (r *Repository) numericArithmetic(ctx context.Context, types *expressionTypes, query string) string {
+	q := parseNumericArithmetic(query)
+	if q == nil {
+		return query
+	}
+	for _, block := range q.blocks {
+		fields := types.fields(ctx, block.probe)
+		if len(fields) != len(block.operations) {
+			continue
+		}
+		for idx, operation := range block.operations {
+			if fields[idx].Mode == "REPEATED" {
+				continue
+			}
+			q.types[operation] = fields[idx].Type
+		}
+	}
+	edits := q.edits()
+	if len(edits) == 0 {
+		return query
+	}
+	return applyEdits(query, edits)
+}
+
+// numericAverage rewrites AVG of NUMERIC and BIGNUMERIC values in query into the exact division of SUM by COUNT.
+// The calls of the query block are kept if the probe query of the block fails.
+func (r *Repository) numericAverage(ctx context.Context, types *expressionTypes, query string) string {
+	var edits []*edit
+	for _, block := range numericAverageBlocks(query) {
+		edits = append(edits, block.edits(types.fields(ctx, block.probe))...)
+	}
+	if len(edits) == 0 {
+		return query
+	}
+	return applyEdits(query, edits)
+}
+
+// numericRounding rewrites ROUND, TRUNC, CEIL and FLOOR of NUMERIC and BIGNUMERIC values in query into the exact computation.
+// The calls of the query block are kept if the probe query of the block fails.
+func (r *Repository) numericRounding(ctx context.Context, types *expressionTypes, query string) string {
+	var edits []*edit
+	for _, block := range numericRoundingBlocks(query) {
+		edits = append(edits, block.edits(types.fields(ctx, block.probe))...)
+	}
+	if len(edits) == 0 {
+		return query
+	}
+	return applyEdits(query, edits)
+}
+
+// formatCalls rewrites FORMAT calls with the literal format string in query by the types of the arguments.
+// The calls of the query block are kept if the probe query of the block fails.
+func (r *Repository) formatCalls(ctx context.Context, types *expressionTypes, query string) string {
+	var edits []*edit
+	for _, block := range formatBlocks(query) {
+		var fields []*bigqueryv2.TableFieldSchema
+		if block.probe != nil {
+			fields = types.fields(ctx, block.probe)
+			if fields == nil {
+				continue
+			}
+		}
+		edits = append(edits, block.edits(fields)...)
+	}
+	if len(edits) == 0 {
+		return query
+	}
+	return applyEdits(query, edits)
+}
+
+// bitwiseOperations rewrites the bitwise operators and SAFE_NEGATE calls in query by the types of their operands.
+// The operations whose types can't be probed are kept as is.
+func (r *Repository) bitwiseOperations(ctx context.Context, types *expressionTypes, query string) string {
+	q := parseBitwiseQuery(query)
+	if q == nil {
+		return query
+	}
+	for _, block := range q.blocks {
+		fields := types.fields(ctx, block.probe)
+		if len(fields) != len(block.operations) {
+			continue
+		}
+		for idx, operation := range block.operations {
+			if fields[idx].Mode == "REPEATED" {
+				continue
+			}
+			q.types[operation] = fields[idx].Type
+		}
+	}
+	edits := q.edits()
+	if len(edits) == 0 {
+		return query
+	}
+	return applyEdits(query, edits)
+}
+
+// typedRewriteProbes returns the probes of the types that the typed rewriters need for query,
+// so they are resolved together by expressionTypes.prefetch.
+// The query without their syntactic patterns has no probe.
+func typedRewriteProbes(query string) []*typeProbe {
+	var probes []*typeProbe
+	for _, block := range structEqualityBlocks(query) {
+		probes = append(probes, block.probe)
+	}
+	if q := parseNumericArithmetic(query); q != nil {
+		for _, block := range q.blocks {
+			probes = append(probes, block.probe)
+		}
+	}
+	for _, block := range numericAverageBlocks(query) {
+		probes = append(probes, block.probe)
+	}
+	for _, block := range numericRoundingBlocks(query) {
+		probes = append(probes, block.probe)
+	}
+	for _, block := range formatBlocks(query) {
+		if block.probe != nil {
+			probes = append(probes, block.probe)
+		}
+	}
+	if q := parseBitwiseQuery(query); q != nil {
+		for _, block := range q.blocks {
+			probes = append(probes, block.probe)
+		}
+	}
+	for _, block := range structGroupingBlocks(query) {
+		probes = append(probes, block.probe)
+	}
+	return probes
+}
+
+// probeFields returns the schema of the columns selected by the probe query.
+// It returns nil if the probe query fails.
+func (r *Repository) probeFields(ctx context.Context, tx *connection.Tx, probe string, values []interface{}) []*bigqueryv2.TableFieldSchema {
+	fields, err := r.queryFields(ctx, tx, probe, values)
+	if err != nil {
+		return nil
+	}
+	return fields
+}
+
+// queryFields returns the schema of the columns selected by query.
+func (r *Repository) queryFields(ctx context.Context, tx *connection.Tx, query string, values []interface{}) ([]*bigqueryv2.TableFieldSchema, error) {
+	rows, err := tx.Tx().QueryContext(ctx, withEmulatorFunctions(query), values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]*bigqueryv2.TableFieldSchema, 0, len(columnTypes))
+	for _, columnType := range columnTypes {
+		typ, err := zetasqlite.UnmarshalDatabaseTypeName(columnType.DatabaseTypeName())
+		if err != nil {
+			return nil, err
+		}
+		zetasqlType, err := typ.ToZetaSQLType()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, types.TableFieldSchemaFromZetaSQLType(columnType.Name(), zetasqlType))
+	}
+	return fields, nil
+}
+
+// setOperationsByName rewrites the set operations matching the columns of the inputs by their names
+// ( BY NAME and CORRESPONDING ) into the set operations matching them by their positions.
+// The columns of the inputs are resolved by the probe queries, and the innermost set operations are rewritten first
+// so that the inputs of the outer ones can be probed.
+func (r *Repository) setOperationsByName(ctx context.Context, tx *connection.Tx, query string, values []interface{}) (string, error) {
+	for i := 0; i < maxSetOperationRewrites; i++ {
+		chain, err := byNameSetOperationChain(query)
+		if err != nil {
+			return "", err
+		}
+		if chain == nil {
+			return query, nil
+		}
+		inputColumns := make([][]string, len(chain.inputs))
+		for idx := range chain.inputs {
+			fields, err := r.queryFields(ctx, tx, chain.probe(query, idx), values)
+			if err != nil {
+				return "", err
+			}
+			for _, field := range fields {
+				inputColumns[idx] = append(inputColumns[idx], field.Name)
+			}
+		}
+		e, err := chain.edit(query, inputColumns)
+		if err != nil {
+			return "", err
+		}
+		query = applyEdits(query, []*edit{e})
+	}
+	return query, nil
+}
+
+// execDML executes the DML statement to get the number of affected rows that is lost by QueryContext.
+// The statistics of MERGE are read before the statement changes the tables,
+// and the rows of THEN RETURN clause are read from the rows copied while the statement writes them.
+// The error returned after the statement changed the tables is rolled back with the statement by the caller.
+func (r *Repository) execDML(ctx context.Context, tx *connection.Tx, projectID, datasetID, typ, query string, values []interface{}, limit ResultLimit) (*internaltypes.QueryResponse, error) {
+	query, thenReturn, err := splitThenReturn(query)
+	if err != nil {
+		return nil, err
+	}
+	returned := &internaltypes.QueryResponse{
+		Schema: &bigqueryv2.TableSchema{Fields: []*bigqueryv2.TableFieldSchema{}},
+		Rows:   []*internaltypes.TableRow{},
+	}
+	var mergeStats *bigqueryv2.DmlStatistics
+	if typ == "MERGE" {
+		mergeStats, err = r.mergeStatistics(ctx, tx, query, values)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var written *writtenRows
+	if thenReturn != nil {
+		written, err = r.captureWrittenRows(ctx, tx, projectID, datasetID, typ, query)
+		if err != nil {
+			return nil, err
+		}
+		defer written.drop(ctx, tx)
+	}
+	result, err := tx.Tx().ExecContext(ctx, withEmulatorFunctions(query), values...)
+	if err != nil {
+		return nil, requiredFieldError(arrayIndexError(err))
+	}
+	if typ != "DELETE" {
+		if err := r.checkWrittenNumerics(ctx, tx, typ, query); err != nil {
+			return nil, err
+		}
+	}
+	changedCatalog, err := zetasqlite.ChangedCatalogFromResult(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changed catalog: %w", err)
+	}
+	if written != nil {
+		written.dropTriggers(ctx)
+		returned, err = r.queryRows(ctx, tx, written.query(thenReturn), values, limit, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	dmlStats := &bigqueryv2.DmlStatistics{}
+	switch typ {
+	case "INSERT":
+		dmlStats.InsertedRowCount = affectedRows
+	case "UPDATE":
+		dmlStats.UpdatedRowCount = affectedRows
+	case "DELETE":
+		dmlStats.DeletedRowCount = affectedRows
+	case "MERGE":
+		if mergeStats != nil {
+			dmlStats = mergeStats
+		}
+	}
+	return &internaltypes.QueryResponse{
+		Schema:             returned.Schema,
+		Rows:               returned.Rows,
+		TotalRows:          returned.TotalRows,
+		TotalBytes:         returned.TotalBytes,
+		JobComplete:        true,
+		NumDmlAffectedRows: affectedRows,
+		DmlStats:           dmlStats,
+		ChangedCatalog:     changedCatalog,
+	}, nil
+}
+
+func (r *Repository) queryParameterValueToGoValue(typ *bigqueryv2.QueryParameterType, value *bigqueryv2.QueryParameterValue) (interface{}, error) {
 	switch {
 	case len(value.ArrayValues) != 0:
+		var elemType *bigqueryv2.QueryParameterType
+		if typ != nil {
+			elemType = typ.ArrayType
+		}
 		arr := make([]interface{}, 0, len(value.ArrayValues))
 		for _, v := range value.ArrayValues {
-			elem, err := r.queryParameterValueToGoValue(v)
+			elem, err := r.queryParameterValueToGoValue(elemType, v)
 			if err != nil {
 				return nil, err
 			}
@@ -268,9 +831,15 @@ func (r *Repository) queryParameterValueToGoValue(value *bigqueryv2.QueryParamet
 		}
 		return arr, nil
 	case len(value.StructValues) != 0:
+		fieldTypes := map[string]*bigqueryv2.QueryParameterType{}
+		if typ != nil {
+			for _, field := range typ.StructTypes {
+				fieldTypes[field.Name] = field.Type
+			}
+		}
 		st := make(map[string]interface{}, len(value.StructValues))
 		for k, v := range value.StructValues {
-			elem, err := r.queryParameterValueToGoValue(&v)
+			elem, err := r.queryParameterValueToGoValue(fieldTypes[k], &v)
 			if err != nil {
 				return nil, err
 			}
@@ -278,6 +847,14 @@ func (r *Repository) queryParameterValueToGoValue(value *bigqueryv2.QueryParamet
 		}
 		return st, nil
 	}
+	if typ != nil && (typ.Type == "FLOAT64" || typ.Type == "FLOAT") && value.Value != "" {
+		// the special values are passed as "NaN", "Infinity" and "-Infinity".
+		f, err := strconv.ParseFloat(value.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FLOAT64 value %q of the query parameter", value.Value)
+		}
+		return f, nil
+	}
 	return value.Value, nil
 }
 
@@ -291,6 +868,9 @@ func (r *Repository) convertValueToCell(value interface{}) (*internaltypes.Table
 	kind := rv.Type().Kind()
 	if kind != reflect.Slice && kind != reflect.Array {
 		v := fmt.Sprint(value)
+		if f, ok := value.(float64); ok {
+			v = formatFloat(f)
+		}
 		return &internaltypes.TableCell{V: v, Bytes: int64(len(v))}, nil
 	}
 	elemType := rv.Type().Elem()
@@ -332,6 +912,39 @@ func (r *Repository) convertValueToCell(value interface{}) (*internaltypes.Table
 	return &internaltypes.TableCell{V: cells, Bytes: totalBytes}, nil
 }
 
+// structFieldValues returns the values of the fields of the struct value.
+// All fields are NULL if the struct is NULL.
+func structFieldValues(value interface{}, numFields int) ([]interface{}, error) {
+	values := make([]interface{}, numFields)
+	if value == nil {
+		return values, nil
+	}
+	fields, ok := value.([]map[string]interface{})
+	if !ok || len(fields) != numFields {
+		return nil, fmt.Errorf("unexpected struct value %v", value)
+	}
+	for idx, field := range fields {
+		for _, v := range field {
+			values[idx] = v
+		}
+	}
+	return values, nil
+}
+
+// formatFloat formats the FLOAT64 value of the cell.
+// BigQuery returns NaN and the infinities as "NaN", "Infinity" and "-Infinity".
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return fmt.Sprint(f)
+}
+
 func (r *Repository) CreateOrReplaceTable(ctx context.Context, tx *connection.Tx, projectID, datasetID string, table *types.Table) error {
 	tx.SetProjectAndDataset(projectID, datasetID)
 	if err := tx.ContentRepoMode(); err != nil {
@@ -418,10 +1031,87 @@ func (r *Repository) AddTableData(ctx context.Context, tx *connection.Tx, projec
 		}
 
 		if _, err := stmt.ExecContext(ctx, values...); err != nil {
-			return err
+			return requiredFieldError(err)
+		}
+	}
+
+	return nil
+}
+
+// HasTableData reports whether the table has any rows.
+func (r *Repository) HasTableData(ctx context.Context, tx *connection.Tx, projectID, datasetID, tableID string) (bool, error) {
+	tx.SetProjectAndDataset(projectID, datasetID)
+	if err := tx.ContentRepoMode(); err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = tx.MetadataRepoMode()
+	}()
+
+	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM `%s`)", r.tablePath(projectID, datasetID, tableID))
+	var exists bool
+	if err := tx.Tx().QueryRowContext(ctx, query).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to get rows of table %s: %w", tableID, err)
+	}
+	return exists, nil
+}
+
+// CatalogObjectKind returns the kind of the object of the name path in the catalog of go-zetasqlite:
+// "table", "view" or "function", or empty if the catalog doesn't have it.
+// The path is completed by the default project and dataset. The catalog is read in the transaction of tx,
+// so the objects created by the former statements of the script are found, but the temporary ones are not.
+func (r *Repository) CatalogObjectKind(ctx context.Context, tx *connection.Tx, projectID, datasetID string, path []string) (string, error) {
+	sqliteTx, err := tx.SQLiteTx()
+	if err != nil {
+		return "", err
+	}
+	var kind string
+	if err := sqliteTx.QueryRowContext(
+		ctx, "SELECT kind FROM zetasqlite_catalog WHERE name = ?", sqliteTableName(projectID, datasetID, path),
+	).Scan(&kind); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to find %s in the catalog: %w", strings.Join(path, "."), err)
+	}
+	return kind, nil
+}
+
+// sqliteTableName returns the name of the SQLite table that go-zetasqlite creates for the path.
+// The path is completed by the default project and dataset same as go-zetasqlite.
+func sqliteTableName(projectID, datasetID string, path []string) string {
+	const maxNamePath = 3 // projectID and datasetID and tableID
+	if len(path) < maxNamePath {
+		var merged []string
+		for _, base := range []string{projectID, datasetID} {
+			if base == "" || path[0] == base || len(merged)+len(path) >= maxNamePath {
+				break
+			}
+			merged = append(merged, base)
 		}
+		path = append(merged, path...)
 	}
+	return strings.Join(path, "_")
+}
 
+func quoteSQLiteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// DeleteTableData deletes all rows of the table and keeps its schema.
+func (r *Repository) DeleteTableData(ctx context.Context, tx *connection.Tx, projectID, datasetID, tableID string) error {
+	tx.SetProjectAndDataset(projectID, datasetID)
+	if err := tx.ContentRepoMode(); err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.MetadataRepoMode()
+	}()
+
+	query := fmt.Sprintf("DELETE FROM `%s` WHERE TRUE", r.tablePath(projectID, datasetID, tableID))
+	if _, err := tx.Tx().ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to delete rows of table %s: %w", tableID, err)
+	}
 	return nil
 }
 
@@ -0,0 +1,690 @@
+package contentdata
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// queryRewriters are applied to the query in order.
+// Each rewriter returns the edits for the tokens of the query rewritten by the previous one.
+var queryRewriters = []func(query string, tokens []*token) ([]*edit, error){
+	withScopeEdits,
+	valueTableEdits,
+	groupByAndOrderByAllEdits,
+	windowEdits,
+	nullsOrderEdits,
+	numberFormatEdits,
+	timestampStringEdits,
+	unixTimeEdits,
+	bucketFunctionEdits,
+	dateArithmeticEdits,
+	dateDiffEdits,
+	truncEdits,
+	timeZoneEdits,
+	parseNumericEdits,
+	collationDefinitionEdits,
+	collateGroupingEdits,
+	collateEdits,
+	normalizeEdits,
+	stringFunctionEdits,
+	distinctCountEdits,
+	aggregateEdits,
+	analyticEdits,
+	containsSubstrEdits,
+	searchEdits,
+	regexpEdits,
+	editDistanceEdits,
+	jsonEdits,
+	generateArrayEdits,
+	arrayLimitEdits,
+}
+
+// rewriteQuery rewrites the syntax supported by BigQuery but not by go-zetasqlite
+// into an equivalent query that go-zetasqlite can evaluate.
+// The rewriters share the tokens of the query, and the query is tokenized again only after a rewriter changed it.
+func rewriteQuery(query string) (string, error) {
+	query, err := rewriteSafeCalls(query)
+	if err != nil {
+		return "", err
+	}
+	tokens := tokenize(query)
+	for _, rewriter := range queryRewriters {
+		edits, err := rewriter(query, tokens)
+		if err != nil {
+			return "", err
+		}
+		if len(edits) == 0 {
+			continue
+		}
+		rewritten := applyEdits(query, edits)
+		if rewritten == query {
+			continue
+		}
+		query = rewritten
+		tokens = tokenize(query)
+	}
+	return query, nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenQuotedIdent
+	tokenString
+	tokenNumber
+	tokenParam
+	tokenSymbol
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	start int
+	end   int
+	depth int
+}
+
+func (t *token) isKeyword(kw string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, kw)
+}
+
+func (t *token) isSymbol(sym string) bool {
+	return t.kind == tokenSymbol && t.text == sym
+}
+
+// isBytesLiteral reports whether the token is a bytes literal like b'...' or rb"...".
+func (t *token) isBytesLiteral() bool {
+	if t.kind != tokenString {
+		return false
+	}
+	prefix := t.text[:strings.IndexAny(t.text, `'"`)]
+	return strings.ContainsAny(prefix, "bB")
+}
+
+// tokenize splits query into tokens. Comments and whitespaces are dropped.
+// depth of each token is the nesting level of parentheses ( or brackets ) at the token.
+func tokenize(query string) []*token {
+	var (
+		tokens []*token
+		depth  int
+	)
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || (c == '-' && strings.HasPrefix(query[i:], "--")):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+		case c == '`':
+			end := scanQuoted(query, i, "`")
+			tokens = append(tokens, &token{kind: tokenQuotedIdent, text: query[i:end], start: i, end: end, depth: depth})
+			i = end
+		case c == '\'' || c == '"':
+			end := scanString(query, i)
+			tokens = append(tokens, &token{kind: tokenString, text: query[i:end], start: i, end: end, depth: depth})
+			i = end
+		case isStringPrefix(query, i):
+			start := i
+			for query[i] != '\'' && query[i] != '"' {
+				i++
+			}
+			end := scanString(query, i)
+			tokens = append(tokens, &token{kind: tokenString, text: query[start:end], start: start, end: end, depth: depth})
+			i = end
+		case isIdentChar(c) && !isDigit(c):
+			start := i
+			for i < len(query) && isIdentChar(query[i]) {
+				i++
+			}
+			tokens = append(tokens, &token{kind: tokenWord, text: query[start:i], start: start, end: i, depth: depth})
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			start := i
+			for i < len(query) && (isIdentChar(query[i]) || query[i] == '.' ||
+				((query[i] == '+' || query[i] == '-') && (query[i-1] == 'e' || query[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, &token{kind: tokenNumber, text: query[start:i], start: start, end: i, depth: depth})
+		case c == '@' || c == '?':
+			start := i
+			i++
+			for i < len(query) && (isIdentChar(query[i]) || query[i] == '@') {
+				i++
+			}
+			tokens = append(tokens, &token{kind: tokenParam, text: query[start:i], start: start, end: i, depth: depth})
+		default:
+			switch c {
+			case '(', '[':
+				tokens = append(tokens, &token{kind: tokenSymbol, text: string(c), start: i, end: i + 1, depth: depth})
+				depth++
+			case ')', ']':
+				if depth > 0 {
+					depth--
+				}
+				tokens = append(tokens, &token{kind: tokenSymbol, text: string(c), start: i, end: i + 1, depth: depth})
+			default:
+				tokens = append(tokens, &token{kind: tokenSymbol, text: string(c), start: i, end: i + 1, depth: depth})
+			}
+			i++
+		}
+	}
+	return tokens
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || isDigit(c) || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// isStringPrefix reports whether query[i:] starts with a prefixed string literal like r'...' or b"...".
+func isStringPrefix(query string, i int) bool {
+	if i > 0 && isIdentChar(query[i-1]) {
+		return false
+	}
+	for _, prefix := range []string{"rb", "br", "r", "b"} {
+		n := len(prefix)
+		if i+n < len(query) && strings.EqualFold(query[i:i+n], prefix) && (query[i+n] == '\'' || query[i+n] == '"') {
+			return true
+		}
+	}
+	return false
+}
+
+func scanString(query string, start int) int {
+	quote := query[start : start+1]
+	if strings.HasPrefix(query[start:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	return scanQuoted(query, start, quote)
+}
+
+func scanQuoted(query string, start int, quote string) int {
+	for i := start + len(quote); i < len(query); i++ {
+		if query[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(query[i:], quote) {
+			return i + len(quote)
+		}
+	}
+	return len(query)
+}
+
+type edit struct {
+	start       int
+	end         int
+	replacement string
+}
+
+func applyEdits(query string, edits []*edit) string {
+	if len(edits) == 0 {
+		return query
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var b strings.Builder
+	pos := 0
+	for _, e := range edits {
+		b.WriteString(query[pos:e.start])
+		b.WriteString(e.replacement)
+		pos = e.end
+	}
+	b.WriteString(query[pos:])
+	return b.String()
+}
+
+// selectItem is an expression in the SELECT list.
+type selectItem struct {
+	tokens []*token
+}
+
+// isStar reports whether the item is `*` or `expr.*` ( with optional EXCEPT/REPLACE modifiers ).
+func (item *selectItem) isStar() bool {
+	for idx, tk := range item.tokens {
+		if tk.depth != item.tokens[0].depth {
+			continue
+		}
+		if tk.isSymbol("*") && (idx == 0 || item.tokens[idx-1].isSymbol(".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// isConstant reports whether the item is a literal or a query parameter ( optionally aliased ).
+func (item *selectItem) isConstant() bool {
+	tokens := item.tokens
+	if n := len(tokens); n >= 2 && tokens[n-2].isKeyword("AS") {
+		tokens = tokens[:n-2]
+	}
+	if len(tokens) == 2 && tokens[0].isSymbol("-") && tokens[1].kind == tokenNumber {
+		return true
+	}
+	if len(tokens) != 1 {
+		return false
+	}
+	switch tokens[0].kind {
+	case tokenString, tokenNumber, tokenParam:
+		return true
+	}
+	return tokens[0].isKeyword("NULL") || tokens[0].isKeyword("TRUE") || tokens[0].isKeyword("FALSE")
+}
+
+var aggregateFuncNames = map[string]struct{}{
+	"ANY_VALUE": {}, "ARRAY_AGG": {}, "ARRAY_CONCAT_AGG": {}, "AVG": {}, "BIT_AND": {}, "BIT_OR": {}, "BIT_XOR": {},
+	"COUNT": {}, "COUNTIF": {}, "GROUPING": {}, "LOGICAL_AND": {}, "LOGICAL_OR": {}, "MAX": {}, "MAX_BY": {}, "MIN": {},
+	"MIN_BY": {}, "STRING_AGG": {}, "SUM": {}, "CORR": {}, "COVAR_POP": {}, "COVAR_SAMP": {}, "STDDEV": {},
+	"STDDEV_POP": {}, "STDDEV_SAMP": {}, "VARIANCE": {}, "VAR_POP": {}, "VAR_SAMP": {}, "APPROX_COUNT_DISTINCT": {},
+	"APPROX_QUANTILES": {}, "APPROX_TOP_COUNT": {}, "APPROX_TOP_SUM": {}, "HLL_COUNT.INIT": {}, "HLL_COUNT.MERGE": {},
+	"HLL_COUNT.MERGE_PARTIAL": {},
+}
+
+// isAggregateOrAnalytic reports whether the item contains an aggregate function call or an OVER clause
+// outside of its subqueries.
+func (item *selectItem) isAggregateOrAnalytic() bool {
+	for idx := 0; idx < len(item.tokens); idx++ {
+		tk := item.tokens[idx]
+		if tk.isSymbol("(") && idx+1 < len(item.tokens) &&
+			(item.tokens[idx+1].isKeyword("SELECT") || item.tokens[idx+1].isKeyword("WITH")) {
+			idx = skipParen(item.tokens, idx)
+			continue
+		}
+		if tk.isKeyword("OVER") {
+			return true
+		}
+		if tk.kind != tokenWord || idx+1 >= len(item.tokens) || !item.tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		if idx >= 2 && item.tokens[idx-1].isSymbol(".") && item.tokens[idx-2].kind == tokenWord {
+			name = strings.ToUpper(item.tokens[idx-2].text) + "." + name
+		}
+		if _, exists := aggregateFuncNames[name]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// skipParen returns the index of the parenthesis closing tokens[open].
+func skipParen(tokens []*token, open int) int {
+	for idx := open + 1; idx < len(tokens); idx++ {
+		if tokens[idx].depth == tokens[open].depth && (tokens[idx].isSymbol(")") || tokens[idx].isSymbol("]")) {
+			return idx
+		}
+	}
+	return len(tokens) - 1
+}
+
+var selectListTerminators = []string{
+	"FROM", "WHERE", "GROUP", "HAVING", "QUALIFY", "WINDOW", "ORDER", "LIMIT", "UNION", "INTERSECT",
+}
+
+func isSelectListTerminator(tokens []*token, idx int) bool {
+	tk := tokens[idx]
+	if tk.isSymbol(";") {
+		return true
+	}
+	for _, kw := range selectListTerminators {
+		if tk.isKeyword(kw) {
+			return true
+		}
+	}
+	// `EXCEPT (...)` is a modifier of `SELECT *`, `EXCEPT DISTINCT` is a set operation.
+	return tk.isKeyword("EXCEPT") && idx+1 < len(tokens) && !tokens[idx+1].isSymbol("(")
+}
+
+// parseSelectList returns the items of the SELECT list which starts at tokens[idx] ( the SELECT keyword ).
+func parseSelectList(tokens []*token, idx int) []*selectItem {
+	depth := tokens[idx].depth
+	idx++
+	for idx < len(tokens) {
+		tk := tokens[idx]
+		if tk.isKeyword("DISTINCT") || tk.isKeyword("ALL") {
+			idx++
+			continue
+		}
+		if tk.isKeyword("AS") && idx+1 < len(tokens) &&
+			(tokens[idx+1].isKeyword("STRUCT") || tokens[idx+1].isKeyword("VALUE")) {
+			idx += 2
+			continue
+		}
+		break
+	}
+	var (
+		items []*selectItem
+		cur   = &selectItem{}
+	)
+	for ; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth < depth || (tk.depth == depth && isSelectListTerminator(tokens, idx)) {
+			break
+		}
+		if tk.depth == depth && tk.isSymbol(",") {
+			items = append(items, cur)
+			cur = &selectItem{}
+			continue
+		}
+		cur.tokens = append(cur.tokens, tk)
+	}
+	if len(cur.tokens) != 0 {
+		items = append(items, cur)
+	}
+	return items
+}
+
+// groupByAndOrderByAllEdits replaces `GROUP BY ALL` and `ORDER BY ALL` with the ordinals of the SELECT list.
+// GROUP BY ALL groups by every SELECT list item that is neither an aggregation, an analytic function call nor a constant.
+// ORDER BY ALL orders by every SELECT list item from left to right.
+func groupByAndOrderByAllEdits(_ string, tokens []*token) ([]*edit, error) {
+	var (
+		edits       []*edit
+		selectLists = map[int][]*selectItem{}
+	)
+	for idx := 0; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.isKeyword("SELECT") {
+			selectLists[tk.depth] = parseSelectList(tokens, idx)
+			continue
+		}
+		if tk.isSymbol(")") {
+			// the subquery which has the SELECT list ended.
+			delete(selectLists, tk.depth+1)
+			continue
+		}
+		if !(tk.isKeyword("GROUP") || tk.isKeyword("ORDER")) || idx+2 >= len(tokens) {
+			continue
+		}
+		if !tokens[idx+1].isKeyword("BY") || !tokens[idx+2].isKeyword("ALL") {
+			continue
+		}
+		if idx+3 < len(tokens) && tokens[idx+3].isSymbol("(") {
+			// function call named ALL.
+			continue
+		}
+		items, exists := selectLists[tk.depth]
+		if !exists {
+			continue
+		}
+		clause := strings.ToUpper(tk.text)
+		for _, item := range items {
+			if item.isStar() {
+				return nil, fmt.Errorf("%s BY ALL cannot be used with SELECT *, specify the columns of the SELECT list explicitly", clause)
+			}
+		}
+		if clause == "GROUP" {
+			var keys []string
+			for i, item := range items {
+				if item.isAggregateOrAnalytic() || item.isConstant() {
+					continue
+				}
+				keys = append(keys, fmt.Sprint(i+1))
+			}
+			if len(keys) == 0 {
+				// there are no grouping keys, so the query aggregates all rows.
+				edits = append(edits, &edit{start: tk.start, end: tokens[idx+2].end})
+			} else {
+				edits = append(edits, &edit{start: tk.start, end: tokens[idx+2].end, replacement: "GROUP BY " + strings.Join(keys, ", ")})
+			}
+			idx += 2
+			continue
+		}
+		end := tokens[idx+2].end
+		var direction string
+		if idx+3 < len(tokens) && (tokens[idx+3].isKeyword("ASC") || tokens[idx+3].isKeyword("DESC")) {
+			direction = " " + strings.ToUpper(tokens[idx+3].text)
+			end = tokens[idx+3].end
+			idx++
+		}
+		keys := make([]string, 0, len(items))
+		for i := range items {
+			keys = append(keys, fmt.Sprint(i+1)+direction)
+		}
+		edits = append(edits, &edit{start: tk.start, end: end, replacement: "ORDER BY " + strings.Join(keys, ", ")})
+		idx += 2
+	}
+	return edits, nil
+}
+
+// IsQueryStatement reports whether query is a single query statement ( SELECT or WITH ... SELECT ), not DML, DDL or script.
+func IsQueryStatement(query string) bool {
+	tokens := tokenize(query)
+	for len(tokens) != 0 && tokens[len(tokens)-1].isSymbol(";") {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return false
+	}
+	for _, tk := range tokens {
+		if tk.isSymbol(";") {
+			return false
+		}
+	}
+	first := tokens[0]
+	if first.isSymbol("(") || first.isKeyword("SELECT") {
+		return true
+	}
+	if !first.isKeyword("WITH") {
+		return false
+	}
+	for _, tk := range tokens[1:] {
+		if tk.depth != 0 {
+			continue
+		}
+		switch {
+		case tk.isKeyword("SELECT"):
+			return true
+		case tk.isKeyword("INSERT"), tk.isKeyword("UPDATE"), tk.isKeyword("DELETE"), tk.isKeyword("MERGE"):
+			return false
+		}
+	}
+	return false
+}
+
+// DMLStatementType returns the type ( INSERT, UPDATE, DELETE or MERGE ) of query if it is a single DML statement,
+// which can follow the WITH clause. It returns an empty string for the other statements.
+func DMLStatementType(query string) string {
+	tokens := statementTokens(query)
+	if len(tokens) == 0 {
+		return ""
+	}
+	if tokens[0].isKeyword("WITH") {
+		with := ParseWithClause(query)
+		if with == nil {
+			return ""
+		}
+		tokens = with.main
+	}
+	for _, typ := range []string{"INSERT", "UPDATE", "DELETE", "MERGE"} {
+		if tokens[0].isKeyword(typ) {
+			return typ
+		}
+	}
+	return ""
+}
+
+// stringLiteralValue returns the value of the string ( or bytes ) literal token.
+func stringLiteralValue(tk *token) (string, bool) {
+	if tk.kind != tokenString {
+		return "", false
+	}
+	text := tk.text
+	var raw bool
+	for len(text) != 0 && text[0] != '\'' && text[0] != '"' {
+		if text[0] == 'r' || text[0] == 'R' {
+			raw = true
+		}
+		text = text[1:]
+	}
+	quote := text[:1]
+	if strings.HasPrefix(text, strings.Repeat(quote, 3)) && len(text) >= 6 {
+		quote = strings.Repeat(quote, 3)
+	}
+	if len(text) < 2*len(quote) || !strings.HasSuffix(text, quote) {
+		return "", false
+	}
+	body := text[len(quote) : len(text)-len(quote)]
+	if raw {
+		return body, true
+	}
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' {
+			b.WriteByte(body[i])
+			continue
+		}
+		i++
+		if i >= len(body) {
+			return "", false
+		}
+		switch c := body[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '?', '"', '\'', '`':
+			b.WriteByte(c)
+		case 'x', 'X':
+			if i+2 >= len(body) {
+				return "", false
+			}
+			v, err := strconv.ParseUint(body[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", false
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		case 'u', 'U':
+			n := 4
+			if c == 'U' {
+				n = 8
+			}
+			if i+n >= len(body) {
+				return "", false
+			}
+			v, err := strconv.ParseUint(body[i+1:i+1+n], 16, 32)
+			if err != nil {
+				return "", false
+			}
+			b.WriteRune(rune(v))
+			i += n
+		default:
+			if c < '0' || c > '7' || i+2 >= len(body) {
+				return "", false
+			}
+			v, err := strconv.ParseUint(body[i:i+3], 8, 8)
+			if err != nil {
+				return "", false
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		}
+	}
+	return b.String(), true
+}
+
+// QuoteStringLiteral returns the string literal that represents v.
+func QuoteStringLiteral(v string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range v {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// mutatingStatementKeywords are the first keywords of the statements that change the tables, the datasets or the routines.
+// CALL and EXECUTE IMMEDIATE are included because the procedure and the dynamic statement can change them.
+var mutatingStatementKeywords = []string{
+	"INSERT", "UPDATE", "DELETE", "MERGE", "TRUNCATE", "CREATE", "DROP", "ALTER", "UNDROP",
+	"LOAD", "CALL", "EXECUTE", "GRANT", "REVOKE",
+}
+
+// MutatingStatementType returns the first keyword of the first statement of query that changes the data or the metadata,
+// including the statements in the blocks of the script like `IF ... THEN INSERT ...`.
+// CREATE TEMP FUNCTION isn't the mutation because the function lives only while the query runs.
+// It returns an empty string if query only reads.
+func MutatingStatementType(query string) string {
+	tokens := tokenize(query)
+	var withStatement bool
+	for idx, tk := range tokens {
+		if idx > 0 {
+			prev := tokens[idx-1]
+			// the DML statement following the WITH clause like `WITH src AS (...) UPDATE ...`.
+			followsWith := withStatement && tk.depth == 0 && prev.isSymbol(")")
+			if !prev.isSymbol(";") && !prev.isKeyword("THEN") && !prev.isKeyword("ELSE") && !prev.isKeyword("DO") &&
+				!prev.isKeyword("BEGIN") && !prev.isKeyword("LOOP") && !prev.isKeyword("REPEAT") && !followsWith {
+				continue
+			}
+			// the comma between the common table expressions like `WITH a AS (...), b AS (...) DELETE ...`.
+			if followsWith && tk.isSymbol(",") {
+				continue
+			}
+		}
+		if tk.isKeyword("WITH") {
+			withStatement = true
+			continue
+		}
+		withStatement = false
+		for _, kw := range mutatingStatementKeywords {
+			if !tk.isKeyword(kw) {
+				continue
+			}
+			if kw == "CREATE" && isTempFunctionDefinition(tokens[idx+1:]) {
+				break
+			}
+			return kw
+		}
+	}
+	return ""
+}
+
+// isTempFunctionDefinition reports whether tokens following CREATE are `[OR REPLACE] {TEMP | TEMPORARY} [AGGREGATE | TABLE] FUNCTION`.
+func isTempFunctionDefinition(tokens []*token) bool {
+	p := &statementParser{tokens: tokens}
+	p.consumeKeywords("OR", "REPLACE")
+	if !p.consumeKeywords("TEMP") && !p.consumeKeywords("TEMPORARY") {
+		return false
+	}
+	p.consumeKeywords("AGGREGATE")
+	p.consumeKeywords("TABLE")
+	return p.consumeKeywords("FUNCTION")
+}
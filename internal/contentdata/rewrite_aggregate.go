@@ -0,0 +1,512 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// aggregateEdits rewrites the aggregate functions whose go-zetasqlite implementation diverges from BigQuery.
+//   - LOGICAL_AND, LOGICAL_OR, BIT_AND, BIT_OR and BIT_XOR return NULL when there are no non-NULL inputs.
+//   - BIT_OR and BIT_XOR combine the aggregated values bit by bit with bitAggregateFunction.
+//   - ANY_VALUE(x HAVING MAX y) and ANY_VALUE(x HAVING MIN y) return x of the row that has the maximum ( or minimum ) y.
+//   - COUNT(DISTINCT x) counts the distinct TO_JSON_STRING(x), so the composite values like (a, b)
+//     are compared field by field by the encoded text. distinctCountEdits has already rewritten the blocks it can.
+//   - STRING_AGG with the empty separator joins the values without the separator instead of the default comma.
+//   - ARRAY_CONCAT_AGG returns NULL when there are no non-NULL arrays.
+//   - APPROX_TOP_SUM returns all the values when the number of the distinct values is smaller than the requested number.
+//   - ARRAY_AGG with ORDER BY sorts the values by the query instead of go-zetasqlite, whose sort isn't stable
+//     and places the NULL keys inconsistently.
+//
+// The analytic function calls ( with OVER clause ) are evaluated by go-zetasqlite as is.
+func aggregateEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		switch name {
+		case "LOGICAL_AND", "LOGICAL_OR", "BIT_AND", "BIT_OR", "BIT_XOR", "ANY_VALUE", "COUNT",
+			"STRING_AGG", "ARRAY_AGG", "ARRAY_CONCAT_AGG", "APPROX_TOP_SUM":
+		default:
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx+1 < len(tokens) && tokens[closeIdx+1].isKeyword("OVER") {
+			continue
+		}
+		if closeIdx == idx+2 {
+			// no arguments.
+			continue
+		}
+		argText := strings.TrimSpace(query[tokens[idx+1].end:tokens[closeIdx].start])
+		argEdits, err := aggregateEdits(argText, tokenize(argText))
+		if err != nil {
+			return nil, err
+		}
+		argText = applyEdits(argText, argEdits)
+		expr, ok := aggregateExpr(name, argText)
+		if !ok {
+			continue
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+func aggregateExpr(name, args string) (string, bool) {
+	switch name {
+	case "ANY_VALUE":
+		return anyValueHavingExpr(args)
+	case "COUNT":
+		return countDistinctExpr(args)
+	case "STRING_AGG":
+		return stringAggExpr(args)
+	case "ARRAY_AGG":
+		return arrayAggExpr(args)
+	case "ARRAY_CONCAT_AGG":
+		return arrayConcatAggExpr(args)
+	case "APPROX_TOP_SUM":
+		return approxTopSumExpr(args)
+	}
+	var (
+		distinct string
+		value    = args
+	)
+	if tokens := tokenize(args); len(tokens) != 0 && tokens[0].isKeyword("DISTINCT") {
+		distinct = "DISTINCT "
+		value = strings.TrimSpace(args[tokens[0].end:])
+	}
+	var expr string
+	switch name {
+	case "LOGICAL_AND", "LOGICAL_OR", "BIT_AND":
+		expr = fmt.Sprintf("%s(%s)", name, args)
+	case "BIT_OR":
+		expr = fmt.Sprintf("%s(ARRAY_AGG(%s%s IGNORE NULLS), FALSE)", bitAggregateFunction, distinct, value)
+	case "BIT_XOR":
+		expr = fmt.Sprintf("%s(ARRAY_AGG(%s%s IGNORE NULLS), TRUE)", bitAggregateFunction, distinct, value)
+	}
+	return fmt.Sprintf("IF(COUNT(%s) = 0, NULL, %s)", args, expr), true
+}
+
+const bitAggregateFunction = "bqemulator_bit_aggregate"
+
+// bitAggregateFunctionDefinition combines the values of BIT_OR and BIT_XOR bit by bit.
+// The bit of the result is set if any value has the bit, or if the number of the values that have the bit is odd when parity is TRUE.
+const bitAggregateFunctionDefinition = "CREATE TEMP FUNCTION " + bitAggregateFunction + "(vs ARRAY<INT64>, parity BOOL) AS ((" +
+	"SELECT IFNULL(SUM(1 << bit), 0) FROM UNNEST(GENERATE_ARRAY(0, 63)) AS bit " +
+	"WHERE (SELECT IF(parity, MOD(COUNTIF((v >> bit) & 1 = 1), 2) = 1, LOGICAL_OR((v >> bit) & 1 = 1)) FROM UNNEST(vs) AS v)" +
+	"));\n"
+
+// anyValueHavingExpr rewrites ANY_VALUE(x HAVING MAX y) to ARRAY_AGG ordered by y.
+// The rows whose y is NULL are chosen only if all y are NULL.
+func anyValueHavingExpr(args string) (string, bool) {
+	tokens := tokenize(args)
+	for idx, tk := range tokens {
+		if tk.depth != 0 || !tk.isKeyword("HAVING") || idx+1 >= len(tokens) {
+			continue
+		}
+		var direction string
+		switch {
+		case tokens[idx+1].isKeyword("MAX"):
+			direction = "DESC"
+		case tokens[idx+1].isKeyword("MIN"):
+			direction = "ASC"
+		default:
+			return "", false
+		}
+		value := strings.TrimSpace(args[:tk.start])
+		having := strings.TrimSpace(args[tokens[idx+1].end:])
+		return fmt.Sprintf(
+			"ARRAY_AGG(STRUCT(%s AS v) ORDER BY (%s) IS NULL, (%s) %s LIMIT 1)[SAFE_OFFSET(0)].v",
+			value, having, having, direction,
+		), true
+	}
+	return "", false
+}
+
+// countDistinctExpr rewrites COUNT(DISTINCT x) to count the distinct TO_JSON_STRING of x,
+// so the composite values like (a, b) and the STRUCT values are compared field by field by the encoded text.
+// NULL ( encoded as 'null' ) is excluded as COUNT does, but the STRUCT that has NULL fields is counted.
+// x is evaluated once for each row.
+func countDistinctExpr(args string) (string, bool) {
+	tokens := tokenize(args)
+	if len(tokens) < 2 || !tokens[0].isKeyword("DISTINCT") {
+		return "", false
+	}
+	return fmt.Sprintf("COUNT(DISTINCT %s)", distinctValueExpr(strings.TrimSpace(args[tokens[0].end:]))), true
+}
+
+// distinctValueExpr returns the encoded value of x that COUNT(DISTINCT x) counts.
+func distinctValueExpr(value string) string {
+	return fmt.Sprintf("NULLIF(TO_JSON_STRING(%s), 'null')", value)
+}
+
+const (
+	distinctCountKeyColumn   = "__bqemulator_key_%d"
+	distinctCountValueColumn = "__bqemulator_value"
+)
+
+// distinctCountEdits rewrites the query block whose aggregations are COUNT(DISTINCT x) of the same x
+// into the aggregation over SELECT DISTINCT of the grouping keys and x.
+// go-zetasqlite keeps every distinct value of COUNT(DISTINCT x) in memory, while SELECT DISTINCT
+// is evaluated by SQLite, which spills the rows to the temporary storage. So the memory is bounded for
+// the large number of the distinct values. The other blocks are left to countDistinctExpr.
+func distinctCountEdits(query string, tokens []*token) ([]*edit, error) {
+	var (
+		edits []*edit
+		end   = -1
+	)
+	for idx, tk := range tokens {
+		if idx < end || !tk.isKeyword("SELECT") || !isTableQueryBlock(tokens, idx) {
+			continue
+		}
+		block := findQueryBlock(tokens, idx)
+		if block == nil || block.start != idx {
+			continue
+		}
+		replacement, ok := block.distinctCountQuery(query)
+		if !ok {
+			continue
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[block.end-1].end, replacement: replacement})
+		end = block.end
+	}
+	return edits, nil
+}
+
+// isTableQueryBlock reports whether the query block starting at tokens[idx] is the statement or the body of WITH entry,
+// which don't refer to the columns of the enclosing query.
+func isTableQueryBlock(tokens []*token, idx int) bool {
+	if tokens[idx].depth == 0 {
+		return true
+	}
+	open := idx - 1
+	return open >= 1 && tokens[open].isSymbol("(") && tokens[open-1].isKeyword("AS")
+}
+
+// distinctCountQuery returns the query of the block rewritten by distinctCountEdits.
+// The block is rewritten only if the other columns are referenced as the grouping keys outside of COUNT(DISTINCT x).
+func (b *queryBlock) distinctCountQuery(query string) (string, bool) {
+	fromIdx, exists := b.clauses["FROM"]
+	if !exists || b.tokens[b.start+1].isKeyword("DISTINCT") || b.tokens[b.start+1].isKeyword("AS") {
+		return "", false
+	}
+	if _, exists := b.clauses["QUALIFY"]; exists {
+		return "", false
+	}
+	if _, exists := b.clauses["WINDOW"]; exists {
+		return "", false
+	}
+	var keys []string
+	if items := b.groupingItems(); len(items) != 0 {
+		if items[0][0].isKeyword("ROLLUP") || items[0][0].isKeyword("CUBE") || items[0][0].isKeyword("GROUPING") {
+			return "", false
+		}
+		for _, item := range items {
+			key := b.groupingKey(query, item)
+			if key == "" {
+				return "", false
+			}
+			keys = append(keys, key)
+		}
+	} else if _, exists := b.clauses["GROUP"]; exists {
+		return "", false
+	}
+	var (
+		value   string
+		aliases = map[string]bool{}
+	)
+	// rewrite replaces COUNT(DISTINCT x) and the grouping keys in tokens. It fails when tokens refer to the other columns.
+	rewrite := func(text string, tokens []*token) (string, bool) {
+		var edits []*edit
+		for idx := 0; idx < len(tokens); idx++ {
+			tk := tokens[idx]
+			if tk.isSymbol("(") && idx+1 < len(tokens) && (tokens[idx+1].isKeyword("SELECT") || tokens[idx+1].isKeyword("WITH")) {
+				return "", false
+			}
+			if tk.isKeyword("OVER") {
+				return "", false
+			}
+			if tk.kind == tokenWord && idx+1 < len(tokens) && tokens[idx+1].isSymbol("(") {
+				if _, exists := aggregateFuncNames[strings.ToUpper(tk.text)]; !exists {
+					continue
+				}
+				closeIdx := skipParen(tokens, idx+1)
+				if !tk.isKeyword("COUNT") || closeIdx < idx+4 || !tokens[idx+2].isKeyword("DISTINCT") {
+					return "", false
+				}
+				arg := strings.TrimSpace(text[tokens[idx+2].end:tokens[closeIdx].start])
+				if value == "" {
+					value = arg
+				} else if !sameExpr(value, arg) {
+					return "", false
+				}
+				edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: fmt.Sprintf("COUNT(`%s`)", distinctCountValueColumn)})
+				idx = closeIdx
+				continue
+			}
+			if matched := matchingKey(tokens, idx, keys); matched >= 0 {
+				n := len(tokenize(keys[matched]))
+				edits = append(edits, &edit{start: tk.start, end: tokens[idx+n-1].end, replacement: fmt.Sprintf("`"+distinctCountKeyColumn+"`", matched+1)})
+				idx += n - 1
+				continue
+			}
+			if isColumnReference(tokens, idx) && !aliases[strings.ToLower(strings.Trim(tk.text, "`"))] {
+				return "", false
+			}
+		}
+		return applyEdits(text, edits), true
+	}
+	items := make([]string, 0, len(b.items))
+	for _, item := range b.items {
+		if item.isStar() {
+			return "", false
+		}
+		expr, alias := selectItemExpr(query, item)
+		rewritten, ok := rewrite(expr, tokenize(expr))
+		if !ok {
+			return "", false
+		}
+		if alias == "" {
+			alias = implicitColumnName(tokenize(expr))
+		}
+		if alias != "" {
+			aliases[strings.ToLower(alias)] = true
+			rewritten += fmt.Sprintf(" AS `%s`", alias)
+		}
+		items = append(items, rewritten)
+	}
+	if value == "" {
+		return "", false
+	}
+	fromEnd := b.end
+	for _, clause := range []string{"GROUP", "HAVING", "ORDER", "LIMIT"} {
+		if idx, exists := b.clauses[clause]; exists && idx < fromEnd {
+			fromEnd = idx
+		}
+	}
+	selected := make([]string, 0, len(keys)+1)
+	grouping := make([]string, 0, len(keys))
+	for i, key := range keys {
+		column := fmt.Sprintf(distinctCountKeyColumn, i+1)
+		selected = append(selected, fmt.Sprintf("%s AS `%s`", key, column))
+		grouping = append(grouping, fmt.Sprintf("`%s`", column))
+	}
+	selected = append(selected, fmt.Sprintf("%s AS `%s`", distinctValueExpr(value), distinctCountValueColumn))
+	rewritten := fmt.Sprintf(
+		"SELECT %s FROM (SELECT DISTINCT %s %s)",
+		strings.Join(items, ", "), strings.Join(selected, ", "), tokenText(query, b.tokens[fromIdx:fromEnd]),
+	)
+	if len(grouping) != 0 {
+		rewritten += " GROUP BY " + strings.Join(grouping, ", ")
+	}
+	for _, clause := range []string{"HAVING", "ORDER"} {
+		idx, exists := b.clauses[clause]
+		if !exists {
+			continue
+		}
+		clauseTokens := b.tokens[idx:b.clauseEnd(idx)]
+		text, ok := rewrite(tokenText(query, clauseTokens), tokenize(tokenText(query, clauseTokens)))
+		if !ok {
+			return "", false
+		}
+		rewritten += " " + text
+	}
+	if idx, exists := b.clauses["LIMIT"]; exists {
+		rewritten += " " + tokenText(query, b.tokens[idx:b.end])
+	}
+	return rewritten, true
+}
+
+// orderingKeywords are the keywords of ORDER BY clause that are not reserved.
+var orderingKeywords = []string{"ORDER", "ASC", "DESC", "NULLS", "FIRST", "LAST"}
+
+// matchingKey returns the index of the key whose tokens start at tokens[idx], or -1.
+func matchingKey(tokens []*token, idx int, keys []string) int {
+	if idx > 0 && tokens[idx-1].isSymbol(".") {
+		return -1
+	}
+	for i, key := range keys {
+		keyTokens := tokenize(key)
+		if idx+len(keyTokens) <= len(tokens) && sameTokens(tokens[idx:idx+len(keyTokens)], keyTokens) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isColumnReference reports whether tokens[idx] may refer to a column.
+// The keywords, the function names, the type names, the aliases and the fields are not.
+func isColumnReference(tokens []*token, idx int) bool {
+	tk := tokens[idx]
+	if tk.kind != tokenWord && tk.kind != tokenQuotedIdent {
+		return false
+	}
+	if tk.kind == tokenWord && isReservedKeyword(tk) {
+		return false
+	}
+	for _, kw := range orderingKeywords {
+		if tk.isKeyword(kw) {
+			return false
+		}
+	}
+	if idx > 0 && (tokens[idx-1].isSymbol(".") || tokens[idx-1].isKeyword("AS")) {
+		return false
+	}
+	if idx+1 < len(tokens) && (tokens[idx+1].isSymbol("(") || tokens[idx+1].kind == tokenString) {
+		return false
+	}
+	return true
+}
+
+// aggregateArgs splits the arguments of the aggregate function into DISTINCT, the arguments and
+// the modifiers following them ( IGNORE NULLS, ORDER BY and LIMIT ).
+func aggregateArgs(args string) (string, []string, string) {
+	tokens := tokenize(args)
+	end := len(tokens)
+	for idx, tk := range tokens {
+		if tk.depth == 0 && (tk.isKeyword("IGNORE") || tk.isKeyword("RESPECT") || tk.isKeyword("ORDER") || tk.isKeyword("LIMIT")) {
+			end = idx
+			break
+		}
+	}
+	var (
+		distinct string
+		start    int
+	)
+	if len(tokens) != 0 && tokens[0].isKeyword("DISTINCT") {
+		distinct = "DISTINCT "
+		start = 1
+	}
+	var values []string
+	for _, item := range pivotItems(tokens, start, end, 0) {
+		values = append(values, tokenText(args, item.tokens))
+	}
+	var modifiers string
+	if end < len(tokens) {
+		modifiers = " " + args[tokens[end].start:]
+	}
+	return distinct, values, modifiers
+}
+
+// stringAggExpr rewrites STRING_AGG with the empty separator to join the values by ARRAY_TO_STRING,
+// because go-zetasqlite replaces the empty separator with the default comma.
+func stringAggExpr(args string) (string, bool) {
+	distinct, values, modifiers := aggregateArgs(args)
+	if len(values) != 2 {
+		return "", false
+	}
+	separator := tokenize(values[1])
+	if len(separator) != 1 || separator[0].kind != tokenString {
+		return "", false
+	}
+	if v, _ := stringLiteralValue(separator[0]); v != "" {
+		return "", false
+	}
+	return fmt.Sprintf(
+		"IF(COUNT(%[2]s) = 0, NULL, ARRAY_TO_STRING(ARRAY_AGG(%[1]s%[2]s IGNORE NULLS%[3]s), %[4]s))",
+		distinct, values[0], modifiers, values[1],
+	), true
+}
+
+// arrayConcatAggExpr rewrites ARRAY_CONCAT_AGG(x) to return NULL when all x are NULL or there are no rows.
+// The NULL arrays are skipped by go-zetasqlite as BigQuery does.
+func arrayConcatAggExpr(args string) (string, bool) {
+	_, values, _ := aggregateArgs(args)
+	if len(values) != 1 {
+		return "", false
+	}
+	return fmt.Sprintf("IF(COUNTIF((%s) IS NOT NULL) = 0, NULL, ARRAY_CONCAT_AGG(%s))", values[0], args), true
+}
+
+// approxTopSumExpr rewrites APPROX_TOP_SUM(x, weight, number) to sum the weights of each x exactly.
+// go-zetasqlite fails when the number of the distinct x is smaller than number, while BigQuery returns all of them.
+// The sum of the NULL weights is NULL and it is ordered last.
+func approxTopSumExpr(args string) (string, bool) {
+	distinct, values, modifiers := aggregateArgs(args)
+	if distinct != "" || modifiers != "" || len(values) != 3 {
+		return "", false
+	}
+	return fmt.Sprintf(
+		"ARRAY(SELECT AS STRUCT `value`, SUM(`weight`) AS `sum` FROM UNNEST(ARRAY_AGG(STRUCT(%s AS `value`, %s AS `weight`))) "+
+			"GROUP BY `value` ORDER BY `sum` DESC LIMIT %s)",
+		values[0], values[1], values[2],
+	), true
+}
+
+// arrayAggExpr rewrites ARRAY_AGG(x ORDER BY keys LIMIT n) to aggregate x with the keys and sort them by the query.
+// The values of the same keys keep the order of the input rows, and NULLS FIRST and NULLS LAST are honored.
+// ARRAY_AGG with DISTINCT is evaluated by go-zetasqlite as is because its keys must be x.
+func arrayAggExpr(args string) (string, bool) {
+	distinct, values, modifiers := aggregateArgs(args)
+	if distinct != "" || len(values) != 1 {
+		return "", false
+	}
+	tokens := tokenize(modifiers)
+	var (
+		ignoreNulls bool
+		orderStart  = -1
+		limit       string
+	)
+	for idx, tk := range tokens {
+		if tk.depth != 0 {
+			continue
+		}
+		switch {
+		case tk.isKeyword("IGNORE"):
+			ignoreNulls = true
+		case tk.isKeyword("ORDER") && idx+1 < len(tokens) && tokens[idx+1].isKeyword("BY"):
+			orderStart = idx + 2
+		case tk.isKeyword("LIMIT"):
+			limit = strings.TrimSpace(modifiers[tk.start:])
+		}
+	}
+	if orderStart < 0 {
+		return "", false
+	}
+	orderEnd := len(tokens)
+	for idx := orderStart; idx < len(tokens); idx++ {
+		if tokens[idx].depth == 0 && tokens[idx].isKeyword("LIMIT") {
+			orderEnd = idx
+			break
+		}
+	}
+	fields := []string{fmt.Sprintf("%s AS `value`", values[0])}
+	var keys []string
+	for i, item := range pivotItems(tokens, orderStart, orderEnd, 0) {
+		end := len(item.tokens)
+		for end > 1 {
+			tk := item.tokens[end-1]
+			if !tk.isKeyword("ASC") && !tk.isKeyword("DESC") && !tk.isKeyword("NULLS") &&
+				!tk.isKeyword("FIRST") && !tk.isKeyword("LAST") {
+				break
+			}
+			end--
+		}
+		name := fmt.Sprintf("`key%d`", i)
+		fields = append(fields, fmt.Sprintf("%s AS %s", tokenText(modifiers, item.tokens[:end]), name))
+		key := "`row`." + name
+		if end < len(item.tokens) {
+			key += " " + tokenText(modifiers, item.tokens[end:])
+		}
+		keys = append(keys, key)
+	}
+	keys = append(keys, "`offset`")
+	var where string
+	if ignoreNulls {
+		where = " WHERE `row`.`value` IS NOT NULL"
+	}
+	if limit != "" {
+		limit = " " + limit
+	}
+	return fmt.Sprintf(
+		"IF(COUNT(*) = 0, NULL, ARRAY(SELECT `row`.`value` FROM UNNEST(ARRAY_AGG(STRUCT(%s))) AS `row` WITH OFFSET AS `offset`%s ORDER BY %s%s))",
+		strings.Join(fields, ", "), where, strings.Join(keys, ", "), limit,
+	), true
+}
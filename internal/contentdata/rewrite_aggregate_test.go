@@ -0,0 +1,43 @@
+package contentdata
+
+import "testing"
+
+func TestAggregateFunctions(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "logical and bit aggregates of no rows",
+			query:    "SELECT LOGICAL_AND(b), BIT_AND(x) FROM t",
+			expected: "SELECT IF(COUNT(b) = 0, NULL, LOGICAL_AND(b)), IF(COUNT(x) = 0, NULL, BIT_AND(x)) FROM t",
+		},
+		{
+			name:     "logical or",
+			query:    "SELECT LOGICAL_OR(b) FROM t",
+			expected: "SELECT IF(COUNT(b) = 0, NULL, LOGICAL_OR(b)) FROM t",
+		},
+		{
+			name:     "any value having max",
+			query:    "SELECT ANY_VALUE(a HAVING MAX b) FROM t",
+			expected: "SELECT ARRAY_AGG(STRUCT(a AS v) ORDER BY (b) IS NULL, (b) DESC LIMIT 1)[SAFE_OFFSET(0)].v FROM t",
+		},
+		{
+			name:     "any value having min",
+			query:    "SELECT ANY_VALUE(a HAVING MIN b) FROM t",
+			expected: "SELECT ARRAY_AGG(STRUCT(a AS v) ORDER BY (b) IS NULL, (b) ASC LIMIT 1)[SAFE_OFFSET(0)].v FROM t",
+		},
+	})
+}
+
+func TestDistinctCount(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "count distinct",
+			query:    "SELECT COUNT(DISTINCT a) FROM t",
+			expected: "SELECT COUNT(`__bqemulator_value`) FROM (SELECT DISTINCT NULLIF(TO_JSON_STRING(a), 'null') AS `__bqemulator_value` FROM t)",
+		},
+		{
+			name:     "analytic count distinct",
+			query:    "SELECT COUNT(DISTINCT a) OVER () FROM t",
+			expected: "SELECT COUNT(DISTINCT a) OVER () FROM t",
+		},
+	})
+}
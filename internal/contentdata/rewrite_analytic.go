@@ -0,0 +1,304 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rankingFunction is the temporary JavaScript function computing RANK, DENSE_RANK, PERCENT_RANK or CUME_DIST
+// of the row whose ORDER BY keys are key from the ordered keys of the rows in the partition.
+// The keys are the JSON strings, so the peers are the consecutive equal strings.
+const rankingFunction = "bqemulator_rank"
+
+const rankingFunctionDefinition = "CREATE TEMP FUNCTION " + rankingFunction +
+	"(keys ARRAY<STRING>, key STRING, name STRING) RETURNS FLOAT64 LANGUAGE js AS r\"\"\"\n" +
+	`var n = keys.length, first = -1, last = -1, dense = 0;
+for (var i = 0; i < n; i++) {
+  if (first < 0 && (i === 0 || keys[i] !== keys[i - 1])) {
+    dense++;
+  }
+  if (keys[i] === key) {
+    if (first < 0) {
+      first = i;
+    }
+    last = i;
+  }
+}
+if (first < 0) {
+  return null;
+}
+switch (name) {
+case "RANK":
+  return first + 1;
+case "DENSE_RANK":
+  return dense;
+case "PERCENT_RANK":
+  return n === 1 ? 0 : first / (n - 1);
+}
+return (last + 1) / n;
+` + "\"\"\";\n"
+
+const ntileBucketsError = "The N value (number of buckets) for the NTILE function must be positive"
+
+// analyticEdits rewrites the navigation and numbering functions whose go-zetasqlite implementation diverges from BigQuery.
+//   - RANK, DENSE_RANK, PERCENT_RANK and CUME_DIST compare only the last ORDER BY key as INT64 in go-zetasqlite,
+//     and CUME_DIST doesn't count the peers. They are computed by rankingFunction from the keys of the rows
+//     in the partition, which ARRAY_AGG window function collects in the order of the window.
+//   - NTILE is computed from ROW_NUMBER and the number of the rows, so the first buckets have one more row
+//     when the rows aren't divisible and the buckets beyond the number of the rows are empty.
+//   - LEAD and LAG return the default value only when the offset is beyond the partition, not when the value is NULL.
+//     With IGNORE NULLS they skip the NULL values.
+//
+// The ORDER BY keys of the rewritten calls place NULLs by NULLS FIRST and NULLS LAST
+// ( NULLS FIRST for ASC and NULLS LAST for DESC by default ), while go-zetasqlite places them first.
+// The named windows are inlined by windowEdits beforehand, and the calls with the window frame are evaluated by go-zetasqlite as is.
+func analyticEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		switch name {
+		case "RANK", "DENSE_RANK", "PERCENT_RANK", "CUME_DIST", "NTILE", "LEAD", "LAG":
+		default:
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx+2 >= len(tokens) || !tokens[closeIdx+1].isKeyword("OVER") || !tokens[closeIdx+2].isSymbol("(") {
+			continue
+		}
+		overEnd := skipParen(tokens, closeIdx+2)
+		if overEnd >= len(tokens) {
+			continue
+		}
+		window, ok := parseAnalyticWindow(query, tokens, closeIdx+3, overEnd)
+		if !ok {
+			continue
+		}
+		args := strings.TrimSpace(query[tokens[idx+1].end:tokens[closeIdx].start])
+		expr, ok := analyticExpr(name, args, window)
+		if !ok {
+			continue
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[overEnd].end, replacement: expr})
+		idx = overEnd
+	}
+	return edits, nil
+}
+
+// analyticWindow is the window specification of OVER clause.
+type analyticWindow struct {
+	partitions []string
+	keys       []*analyticKey
+}
+
+// analyticKey is the ORDER BY key of the window.
+type analyticKey struct {
+	expr      string
+	desc      bool
+	nullsLast bool
+}
+
+// parseAnalyticWindow parses PARTITION BY and ORDER BY of the window specification in tokens[start:end].
+// It reports false for the named window and the window frame.
+func parseAnalyticWindow(query string, tokens []*token, start, end int) (*analyticWindow, bool) {
+	window := &analyticWindow{}
+	depth := tokens[start-1].depth + 1
+	idx := start
+	if idx+1 < end && tokens[idx].isKeyword("PARTITION") && tokens[idx+1].isKeyword("BY") {
+		partitionEnd := end
+		for i := idx + 2; i < end; i++ {
+			if tokens[i].depth == depth && tokens[i].isKeyword("ORDER") {
+				partitionEnd = i
+				break
+			}
+		}
+		for _, item := range pivotItems(tokens, idx+2, partitionEnd, depth) {
+			window.partitions = append(window.partitions, tokenText(query, item.tokens))
+		}
+		idx = partitionEnd
+	}
+	if idx+1 < end && tokens[idx].isKeyword("ORDER") && tokens[idx+1].isKeyword("BY") {
+		for _, item := range pivotItems(tokens, idx+2, end, depth) {
+			for _, tk := range item.tokens {
+				if tk.depth == depth && (tk.isKeyword("ROWS") || tk.isKeyword("RANGE")) {
+					return nil, false
+				}
+			}
+			keyEnd := len(item.tokens)
+			for keyEnd > 1 {
+				tk := item.tokens[keyEnd-1]
+				if !tk.isKeyword("ASC") && !tk.isKeyword("DESC") && !tk.isKeyword("NULLS") &&
+					!tk.isKeyword("FIRST") && !tk.isKeyword("LAST") {
+					break
+				}
+				keyEnd--
+			}
+			key := &analyticKey{expr: tokenText(query, item.tokens[:keyEnd])}
+			nullsOrder := false
+			for _, tk := range item.tokens[keyEnd:] {
+				switch {
+				case tk.isKeyword("DESC"):
+					key.desc = true
+				case tk.isKeyword("FIRST"):
+					nullsOrder = true
+				case tk.isKeyword("LAST"):
+					key.nullsLast, nullsOrder = true, true
+				}
+			}
+			if !nullsOrder {
+				key.nullsLast = key.desc
+			}
+			window.keys = append(window.keys, key)
+		}
+		idx = end
+	}
+	return window, idx == end
+}
+
+// partitionBy returns PARTITION BY clause of the window or the empty string.
+func (w *analyticWindow) partitionBy() string {
+	if len(w.partitions) == 0 {
+		return ""
+	}
+	return "PARTITION BY " + strings.Join(w.partitions, ", ")
+}
+
+// over returns OVER clause of the window with the frame.
+// The NULL keys are ordered by the additional keys because go-zetasqlite always places NULLs first.
+func (w *analyticWindow) over(frame string) string {
+	clauses := []string{}
+	if partitionBy := w.partitionBy(); partitionBy != "" {
+		clauses = append(clauses, partitionBy)
+	}
+	if len(w.keys) != 0 {
+		keys := make([]string, 0, len(w.keys))
+		for _, key := range w.keys {
+			if key.nullsLast {
+				keys = append(keys, fmt.Sprintf("(%s) IS NULL", key.expr))
+			}
+			direction := "ASC"
+			if key.desc {
+				direction = "DESC"
+			}
+			keys = append(keys, fmt.Sprintf("%s %s", key.expr, direction))
+		}
+		clauses = append(clauses, "ORDER BY "+strings.Join(keys, ", "))
+	}
+	if frame != "" {
+		clauses = append(clauses, frame)
+	}
+	return fmt.Sprintf("OVER (%s)", strings.Join(clauses, " "))
+}
+
+// peerKeys returns the JSON string of the ORDER BY keys of the current row, so the peers have the same string.
+func (w *analyticWindow) peerKeys() string {
+	fields := make([]string, 0, len(w.keys))
+	for i, key := range w.keys {
+		fields = append(fields, fmt.Sprintf("%s AS `analytic_key%d`", key.expr, i))
+	}
+	return fmt.Sprintf("TO_JSON_STRING(STRUCT(%s))", strings.Join(fields, ", "))
+}
+
+// rankingExpr returns RANK, DENSE_RANK, PERCENT_RANK or CUME_DIST computed by rankingFunction
+// from the keys of the rows in the partition ordered by ARRAY_AGG window function.
+func (w *analyticWindow) rankingExpr(name string) string {
+	if len(w.keys) == 0 {
+		// every row is the peer of the current row.
+		switch name {
+		case "RANK", "DENSE_RANK":
+			return "1"
+		case "PERCENT_RANK":
+			return "CAST(0 AS FLOAT64)"
+		}
+		return "CAST(1 AS FLOAT64)"
+	}
+	expr := fmt.Sprintf(
+		"%s(ARRAY_AGG(%s) %s, %s, '%s')",
+		rankingFunction, w.peerKeys(), w.over("ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING"), w.peerKeys(), name,
+	)
+	if name == "RANK" || name == "DENSE_RANK" {
+		return fmt.Sprintf("CAST(%s AS INT64)", expr)
+	}
+	return expr
+}
+
+func analyticExpr(name, args string, window *analyticWindow) (string, bool) {
+	switch name {
+	case "RANK", "DENSE_RANK", "PERCENT_RANK", "CUME_DIST":
+		return window.rankingExpr(name), args == ""
+	case "NTILE":
+		return ntileExpr(args, window)
+	case "LEAD", "LAG":
+		return navigationExpr(name, args, window)
+	}
+	return "", false
+}
+
+// ntileExpr returns NTILE(n) computed by ROW_NUMBER and the number of the rows in the partition.
+// The first MOD(rows, n) buckets have DIV(rows, n) + 1 rows and the others have DIV(rows, n) rows.
+func ntileExpr(args string, window *analyticWindow) (string, bool) {
+	if args == "" {
+		return "", false
+	}
+	var (
+		n        = "(" + args + ")"
+		rowNum   = "ROW_NUMBER() " + window.over("")
+		rows     = fmt.Sprintf("COUNT(*) OVER (%s)", window.partitionBy())
+		quotient = fmt.Sprintf("DIV(%s, %s)", rows, n)
+		larger   = fmt.Sprintf("MOD(%s, %s) * (%s + 1)", rows, n, quotient)
+	)
+	return fmt.Sprintf(
+		"IF(%[1]s IS NULL OR %[1]s <= 0, ERROR('%[2]s'), CASE WHEN %[3]s <= %[4]s THEN DIV(%[3]s - 1, %[5]s + 1) + 1 "+
+			"ELSE MOD(%[6]s, %[1]s) + DIV(%[3]s - 1 - %[4]s, %[5]s) + 1 END)",
+		n, ntileBucketsError, rowNum, larger, quotient, rows,
+	), true
+}
+
+// navigationExpr returns LEAD or LAG that returns the default value only when the offset is beyond the partition.
+// With IGNORE NULLS, the value is looked up in the non-NULL values following ( or preceding ) the current row.
+func navigationExpr(name, args string, window *analyticWindow) (string, bool) {
+	_, values, modifiers := aggregateArgs(args)
+	if len(values) == 0 || len(values) > 3 {
+		return "", false
+	}
+	var (
+		value        = values[0]
+		offset       = "1"
+		defaultValue = "NULL"
+		ignoreNulls  = strings.Contains(strings.ToUpper(modifiers), "IGNORE")
+	)
+	if len(values) >= 2 {
+		offset = values[1]
+	}
+	if len(values) == 3 {
+		defaultValue = values[2]
+	}
+	if ignoreNulls {
+		frame, direction := "ROWS BETWEEN 1 FOLLOWING AND UNBOUNDED FOLLOWING", "ASC"
+		if name == "LAG" {
+			frame, direction = "ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING", "DESC"
+		}
+		return fmt.Sprintf(
+			"IFNULL(ARRAY(SELECT `row`.`value` FROM UNNEST(ARRAY_AGG(STRUCT(%s AS `value`)) %s) AS `row` WITH OFFSET AS `offset` "+
+				"WHERE `row`.`value` IS NOT NULL ORDER BY `offset` %s)[SAFE_OFFSET((%s) - 1)], %s)",
+			value, window.over(frame), direction, offset, defaultValue,
+		), true
+	}
+	var (
+		rowNum = "ROW_NUMBER() " + window.over("")
+		beyond = fmt.Sprintf("%s + (%s) > COUNT(*) OVER (%s)", rowNum, offset, window.partitionBy())
+	)
+	if name == "LAG" {
+		beyond = fmt.Sprintf("%s <= (%s)", rowNum, offset)
+	}
+	return fmt.Sprintf(
+		"IF(%s, %s, %s(%s, %s) %s)",
+		beyond, defaultValue, name, value, offset, window.over(""),
+	), true
+}
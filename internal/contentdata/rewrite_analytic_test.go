@@ -0,0 +1,32 @@
+package contentdata
+
+import "testing"
+
+func TestAnalyticFunctions(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:  "rank",
+			query: "SELECT RANK() OVER (ORDER BY a) FROM t",
+			expected: "SELECT CAST(bqemulator_rank(ARRAY_AGG(TO_JSON_STRING(STRUCT(a AS `analytic_key0`))) " +
+				"OVER (ORDER BY a ASC ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING), " +
+				"TO_JSON_STRING(STRUCT(a AS `analytic_key0`)), 'RANK') AS INT64) FROM t",
+		},
+		{
+			name:  "dense rank of descending order",
+			query: "SELECT DENSE_RANK() OVER (PARTITION BY p ORDER BY a DESC) FROM t",
+			expected: "SELECT CAST(bqemulator_rank(ARRAY_AGG(TO_JSON_STRING(STRUCT(a AS `analytic_key0`))) " +
+				"OVER (PARTITION BY p ORDER BY (a) IS NULL, a DESC ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING), " +
+				"TO_JSON_STRING(STRUCT(a AS `analytic_key0`)), 'DENSE_RANK') AS INT64) FROM t",
+		},
+		{
+			name:     "lag with default value",
+			query:    "SELECT LAG(a, 2, 0) OVER (ORDER BY b) FROM t",
+			expected: "SELECT IF(ROW_NUMBER() OVER (ORDER BY b ASC) <= (2), 0, LAG(a, 2) OVER (ORDER BY b ASC)) FROM t",
+		},
+		{
+			name:     "lead",
+			query:    "SELECT LEAD(a) OVER (ORDER BY b) FROM t",
+			expected: "SELECT IF(ROW_NUMBER() OVER (ORDER BY b ASC) + (1) > COUNT(*) OVER (), NULL, LEAD(a, 1) OVER (ORDER BY b ASC)) FROM t",
+		},
+	})
+}
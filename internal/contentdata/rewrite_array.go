@@ -0,0 +1,188 @@
+package contentdata
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
+var arrayIndexOutOfRangePattern = regexp.MustCompile(`(OFFSET|ORDINAL)\((-?\d+)\) is out of range`)
+
+// arrayAccessors are the explicit forms of the array subscript.
+var arrayAccessors = []string{"OFFSET", "SAFE_OFFSET", "ORDINAL", "SAFE_ORDINAL"}
+
+// reservedKeywords are the reserved keywords of GoogleSQL that can precede an array literal,
+// so `[` following them starts the array literal, not the subscript.
+var reservedKeywords = []string{
+	"ALL", "AND", "ANY", "ARRAY", "AS", "BETWEEN", "BY", "CASE", "DISTINCT", "ELSE", "EXCEPT", "EXISTS",
+	"FROM", "HAVING", "IF", "IN", "INTERSECT", "INTO", "IS", "LIKE", "LIMIT", "NOT", "ON", "OR", "RETURN",
+	"SELECT", "SET", "SOME", "THEN", "TO", "UNION", "UNNEST", "USING", "WHEN", "WHERE", "WITH",
+}
+
+// arraySubscriptBlock is the query block that has the subscripts without accessor like arr[i].
+// BigQuery evaluates arr[i] as arr[OFFSET(i)], while go-zetasqlite supports the subscript without accessor
+// only for JSON values, so the subscripts of ARRAY values are rewritten into OFFSET.
+// The types of the subscripted expressions are unknown until the probe runs.
+type arraySubscriptBlock struct {
+	subscripts []*arraySubscript
+	probe      *typeProbe
+}
+
+// arraySubscript is the subscript without accessor. openBracket and closeBracket are the tokens of `[` and `]`.
+type arraySubscript struct {
+	openBracket  *token
+	closeBracket *token
+}
+
+// arraySubscripts returns the subscripts without accessor of query grouped by the query blocks,
+// and the subscripts outside the query blocks like the ones of the SET clause of UPDATE.
+// The subscript of a string literal is the field access of JSON, so it is not returned.
+func arraySubscripts(query string) ([]*arraySubscriptBlock, []*arraySubscript) {
+	tokens := tokenize(query)
+	var (
+		blocks  []*arraySubscriptBlock
+		outside []*arraySubscript
+		byStart = map[int]*arraySubscriptBlock{}
+		exprs   = map[*arraySubscriptBlock][]string{}
+		queries = map[*arraySubscriptBlock]*queryBlock{}
+	)
+	for idx := 1; idx+1 < len(tokens); idx++ {
+		if !tokens[idx].isSymbol("[") || !isSubscriptOperand(tokens[idx-1]) {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx)
+		if closeIdx == idx+1 || !tokens[closeIdx].isSymbol("]") {
+			continue
+		}
+		if closeIdx == idx+2 && tokens[idx+1].kind == tokenString {
+			continue
+		}
+		if isArrayAccessor(tokens, idx+1, closeIdx) {
+			continue
+		}
+		subscript := &arraySubscript{openBracket: tokens[idx], closeBracket: tokens[closeIdx]}
+		block := findQueryBlock(tokens, idx)
+		if block == nil {
+			outside = append(outside, subscript)
+			continue
+		}
+		subscriptBlock, exists := byStart[block.start]
+		if !exists {
+			subscriptBlock = &arraySubscriptBlock{}
+			byStart[block.start] = subscriptBlock
+			queries[subscriptBlock] = block
+			blocks = append(blocks, subscriptBlock)
+		}
+		subscriptBlock.subscripts = append(subscriptBlock.subscripts, subscript)
+		exprs[subscriptBlock] = append(exprs[subscriptBlock], tokenText(query, tokens[subscriptOperandStart(tokens, idx):idx]))
+	}
+	for _, block := range blocks {
+		block.probe = queries[block].typeProbe(query, tokens, exprs[block])
+	}
+	return blocks, outside
+}
+
+// edits returns the edits that rewrite the subscripts by fields, the types of the subscripted expressions selected by the probe.
+// The subscripts whose types are unknown because the probe failed are rewritten as the subscripts of ARRAY values.
+func (b *arraySubscriptBlock) edits(fields []*bigqueryv2.TableFieldSchema) []*edit {
+	known := len(fields) == len(b.subscripts)
+	var edits []*edit
+	for idx, subscript := range b.subscripts {
+		if known && fields[idx].Mode != "REPEATED" {
+			continue
+		}
+		edits = append(edits, subscript.edits()...)
+	}
+	return edits
+}
+
+// edits returns the edits that rewrite arr[i] into arr[OFFSET(i)].
+func (s *arraySubscript) edits() []*edit {
+	return []*edit{
+		{start: s.openBracket.end, end: s.openBracket.end, replacement: "OFFSET("},
+		{start: s.closeBracket.start, end: s.closeBracket.start, replacement: ")"},
+	}
+}
+
+// subscriptOperandStart returns the index of the first token of the expression subscripted by `[` at tokens[open].
+// The expression is the chain of the names, the calls and the subscripts like t.arr, f(x).arr or arr[OFFSET(0)].arr.
+func subscriptOperandStart(tokens []*token, open int) int {
+	idx := open - 1
+	for {
+		if tk := tokens[idx]; tk.isSymbol(")") || tk.isSymbol("]") {
+			idx = matchingOpen(tokens, idx)
+			switch {
+			case tokens[idx].isSymbol("[") && idx > 0 && isSubscriptOperand(tokens[idx-1]):
+				idx--
+				continue
+			case tokens[idx].isSymbol("(") && idx > 0 && tokens[idx-1].kind == tokenWord &&
+				(isSubscriptOperand(tokens[idx-1]) || tokens[idx-1].isKeyword("ARRAY") || tokens[idx-1].isKeyword("IF")):
+				// the function call like f(x), ARRAY(SELECT ...) or IF(c, a, b).
+				idx--
+			}
+		}
+		if idx >= 2 && tokens[idx-1].isSymbol(".") {
+			idx -= 2
+			continue
+		}
+		return idx
+	}
+}
+
+// matchingOpen returns the index of `(` or `[` closed by tokens[closeIdx].
+func matchingOpen(tokens []*token, closeIdx int) int {
+	for idx := closeIdx - 1; idx >= 0; idx-- {
+		if tokens[idx].depth == tokens[closeIdx].depth && (tokens[idx].isSymbol("(") || tokens[idx].isSymbol("[")) {
+			return idx
+		}
+	}
+	return 0
+}
+
+// isSubscriptOperand reports whether tk can end the expression that is subscripted by the following `[`.
+func isSubscriptOperand(tk *token) bool {
+	switch tk.kind {
+	case tokenQuotedIdent, tokenParam:
+		return true
+	case tokenWord:
+		for _, kw := range reservedKeywords {
+			if tk.isKeyword(kw) {
+				return false
+			}
+		}
+		return true
+	}
+	return tk.isSymbol(")") || tk.isSymbol("]")
+}
+
+// isArrayAccessor reports whether tokens[start:end] is OFFSET(i), SAFE_OFFSET(i), ORDINAL(i) or SAFE_ORDINAL(i).
+func isArrayAccessor(tokens []*token, start, end int) bool {
+	if start+1 >= end || !tokens[start+1].isSymbol("(") || skipParen(tokens, start+1) != end-1 {
+		return false
+	}
+	for _, accessor := range arrayAccessors {
+		if tokens[start].isKeyword(accessor) {
+			return true
+		}
+	}
+	return false
+}
+
+// arrayIndexError translates the out of range error of go-zetasqlite into the error message of BigQuery.
+func arrayIndexError(err error) error {
+	if err == nil {
+		return nil
+	}
+	matched := arrayIndexOutOfRangePattern.FindStringSubmatch(err.Error())
+	if matched == nil {
+		return err
+	}
+	index := matched[2]
+	bound := "overflow"
+	if strings.HasPrefix(index, "-") || (strings.EqualFold(matched[1], "ORDINAL") && index == "0") {
+		bound = "underflow"
+	}
+	return fmt.Errorf("Array index %s is out of bounds (%s)", index, bound)
+}
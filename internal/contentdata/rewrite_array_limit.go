@@ -0,0 +1,186 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxArrayElements is the limit of the elements of the array built by GENERATE_ARRAY, GENERATE_TIMESTAMP_ARRAY,
+// ARRAY_CONCAT, ARRAY_AGG and ARRAY_CONCAT_AGG.
+const MaxArrayElements = 16000000
+
+// generateArrayError is the error of GENERATE_ARRAY and GENERATE_TIMESTAMP_ARRAY producing too many elements.
+var generateArrayError = fmt.Sprintf("ERROR('Cannot generate arrays with more than %d elements')", MaxArrayElements)
+
+// timestampArrayPartMicros are the microseconds of the date parts of GENERATE_TIMESTAMP_ARRAY.
+var timestampArrayPartMicros = map[string]int64{
+	"MICROSECOND": 1, "MILLISECOND": 1000, "SECOND": 1000000, "MINUTE": 60000000, "HOUR": 3600000000, "DAY": 86400000000,
+}
+
+// arrayLimitEdits guards the functions building the arrays with MaxArrayElements, which go-zetasqlite doesn't limit.
+// GENERATE_ARRAY and GENERATE_TIMESTAMP_ARRAY compute the number of the elements from the range and the step
+// before the array is allocated, so the range of billions of elements fails fast.
+// ARRAY_CONCAT concatenates the arguments pair by pair checking the sum of their lengths,
+// and ARRAY_AGG and ARRAY_CONCAT_AGG count the elements of the group by COUNT and SUM. ARRAY_AGG with DISTINCT or LIMIT and the analytic calls are not checked.
+// GENERATE_DATE_ARRAY never exceeds the limit because the range of DATE has about 3.6 million days.
+func arrayLimitEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx >= len(tokens) || !tokens[closeIdx].isSymbol(")") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		switch name {
+		case "GENERATE_ARRAY", "GENERATE_TIMESTAMP_ARRAY", "ARRAY_CONCAT", "ARRAY_AGG", "ARRAY_CONCAT_AGG":
+		default:
+			continue
+		}
+		if closeIdx+1 < len(tokens) && tokens[closeIdx+1].isKeyword("OVER") {
+			continue
+		}
+		// the nested calls like ARRAY_CONCAT(GENERATE_ARRAY(...)) are rewritten in the arguments.
+		inner, err := rewrittenArrayLimitArg(query[tokens[idx+1].end:tokens[closeIdx].start])
+		if err != nil {
+			return nil, err
+		}
+		innerTokens := tokenize("(" + inner + ")")
+		args := functionArgs("("+inner+")", innerTokens, 0, len(innerTokens)-1)
+		var condition string
+		switch name {
+		case "GENERATE_ARRAY":
+			condition = generateArrayCondition(args)
+		case "GENERATE_TIMESTAMP_ARRAY":
+			condition = generateTimestampArrayCondition(args)
+		case "ARRAY_CONCAT":
+			if len(args) > 1 {
+				edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: arrayConcatExpr(args)})
+				idx = closeIdx
+				continue
+			}
+		case "ARRAY_AGG", "ARRAY_CONCAT_AGG":
+			condition = arrayAggCondition(name, inner)
+		}
+		call := fmt.Sprintf("%s(%s)", tk.text, inner)
+		if condition != "" {
+			errorExpr := generateArrayError
+			if !strings.HasPrefix(name, "GENERATE_") {
+				errorExpr = fmt.Sprintf("ERROR('Array too large: %s produced more than %d elements')", name, MaxArrayElements)
+			}
+			call = fmt.Sprintf("IF(%s, %s, %s)", condition, errorExpr, call)
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: call})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+const arrayConcatFunction = "bqemulator_array_concat"
+
+// arrayConcatFunctionDefinition is ARRAY_CONCAT of two arrays failing when the result exceeds MaxArrayElements.
+// The function binds the arrays, so each argument is evaluated once.
+var arrayConcatFunctionDefinition = "CREATE TEMP FUNCTION " + arrayConcatFunction + "(a ANY TYPE, b ANY TYPE) AS (" + fmt.Sprintf(
+	"IF(IFNULL(ARRAY_LENGTH(a), 0) + IFNULL(ARRAY_LENGTH(b), 0) > %d, ERROR('Array too large: ARRAY_CONCAT produced more than %d elements'), ARRAY_CONCAT(a, b))",
+	MaxArrayElements, MaxArrayElements,
+) + ");\n"
+
+// arrayConcatExpr returns ARRAY_CONCAT of args as the nested calls of arrayConcatFunction.
+// The result is NULL if any argument is NULL same as ARRAY_CONCAT.
+func arrayConcatExpr(args []string) string {
+	expr := args[0]
+	for _, arg := range args[1:] {
+		expr = fmt.Sprintf("%s(%s, %s)", arrayConcatFunction, expr, arg)
+	}
+	return expr
+}
+
+// generateArrayCondition returns the condition that GENERATE_ARRAY(start, end [, step]) exceeds MaxArrayElements.
+// The number of the elements is floor((end - start) / step) + 1, which is computed in FLOAT64 to avoid the overflow.
+func generateArrayCondition(args []string) string {
+	if len(args) != 2 && len(args) != 3 {
+		return ""
+	}
+	step := "1"
+	if len(args) == 3 {
+		step = args[2]
+	}
+	return fmt.Sprintf(
+		"IFNULL(SAFE_DIVIDE(CAST(%s AS FLOAT64) - CAST(%s AS FLOAT64), CAST(%s AS FLOAT64)) >= %d, FALSE)",
+		args[1], args[0], step, MaxArrayElements,
+	)
+}
+
+// generateTimestampArrayCondition returns the condition that
+// GENERATE_TIMESTAMP_ARRAY(start, end, INTERVAL step part) exceeds MaxArrayElements.
+func generateTimestampArrayCondition(args []string) string {
+	if len(args) != 3 {
+		return ""
+	}
+	intervalTokens := tokenize(args[2])
+	n := len(intervalTokens)
+	if n < 3 || !intervalTokens[0].isKeyword("INTERVAL") || intervalTokens[n-1].kind != tokenWord {
+		return ""
+	}
+	micros, exists := timestampArrayPartMicros[strings.ToUpper(intervalTokens[n-1].text)]
+	if !exists {
+		return ""
+	}
+	step := tokenText(args[2], intervalTokens[1:n-1])
+	return fmt.Sprintf(
+		"IFNULL(SAFE_DIVIDE(CAST(TIMESTAMP_DIFF(%s, %s, MICROSECOND) AS FLOAT64), CAST(%s AS FLOAT64) * %d) >= %d, FALSE)",
+		args[1], args[0], step, micros, MaxArrayElements,
+	)
+}
+
+// arrayAggCondition returns the condition that the aggregate of the arguments args exceeds MaxArrayElements.
+// ARRAY_AGG counts the rows ( or the values that are not NULL with IGNORE NULLS ) and ARRAY_CONCAT_AGG sums the lengths.
+func arrayAggCondition(name, args string) string {
+	tokens := tokenize(args)
+	if len(tokens) == 0 || tokens[0].isKeyword("DISTINCT") {
+		return ""
+	}
+	depth := tokens[0].depth
+	end := len(tokens)
+	var ignoreNulls bool
+	for idx, tk := range tokens {
+		if tk.depth != depth {
+			continue
+		}
+		if tk.isKeyword("LIMIT") {
+			return ""
+		}
+		if end == len(tokens) && (tk.isKeyword("IGNORE") || tk.isKeyword("RESPECT") || tk.isKeyword("ORDER")) {
+			end = idx
+		}
+		if tk.isKeyword("IGNORE") {
+			ignoreNulls = true
+		}
+	}
+	if end == 0 {
+		return ""
+	}
+	expr := tokenText(args, tokens[:end])
+	switch {
+	case name == "ARRAY_CONCAT_AGG":
+		return fmt.Sprintf("IFNULL(SUM(ARRAY_LENGTH(%s)), 0) > %d", expr, MaxArrayElements)
+	case ignoreNulls:
+		return fmt.Sprintf("COUNT(%s) > %d", expr, MaxArrayElements)
+	}
+	return fmt.Sprintf("COUNT(1) > %d", MaxArrayElements)
+}
+
+// rewrittenArrayLimitArg rewrites the nested calls in the argument.
+func rewrittenArrayLimitArg(arg string) (string, error) {
+	edits, err := arrayLimitEdits(arg, tokenize(arg))
+	if err != nil {
+		return "", err
+	}
+	return applyEdits(arg, edits), nil
+}
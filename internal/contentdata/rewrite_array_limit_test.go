@@ -0,0 +1,29 @@
+package contentdata
+
+import "testing"
+
+func TestArrayLimit(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "array agg",
+			query:    "SELECT ARRAY_AGG(x) FROM t",
+			expected: "SELECT IF(COUNT(1) > 16000000, ERROR('Array too large: ARRAY_AGG produced more than 16000000 elements'), ARRAY_AGG(x)) FROM t",
+		},
+		{
+			name:  "array concat agg",
+			query: "SELECT ARRAY_CONCAT_AGG(a) FROM t",
+			expected: "SELECT IF(COUNTIF((a) IS NOT NULL) = 0, NULL, IF(IFNULL(SUM(ARRAY_LENGTH(a)), 0) > 16000000, " +
+				"ERROR('Array too large: ARRAY_CONCAT_AGG produced more than 16000000 elements'), ARRAY_CONCAT_AGG(a))) FROM t",
+		},
+		{
+			name:     "array concat",
+			query:    "SELECT ARRAY_CONCAT(a, b) FROM t",
+			expected: "SELECT bqemulator_array_concat(a, b) FROM t",
+		},
+		{
+			name:     "array subquery",
+			query:    "SELECT ARRAY(SELECT x FROM t)",
+			expected: "SELECT ARRAY(SELECT x FROM t)",
+		},
+	})
+}
@@ -0,0 +1,49 @@
+package contentdata
+
+import "testing"
+
+func TestArraySubscripts(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "subscript without accessor",
+			query:    "SELECT arr[1] FROM t",
+			expected: "SELECT arr[OFFSET(1)] FROM t",
+		},
+		{
+			name:     "subscript of the field of a call",
+			query:    "SELECT f(x).arr[i + 1] FROM t",
+			expected: "SELECT f(x).arr[OFFSET(i + 1)] FROM t",
+		},
+		{
+			name:     "json field access and explicit accessor",
+			query:    "SELECT j['a'], arr[OFFSET(0)] FROM t",
+			expected: "SELECT j['a'], arr[OFFSET(0)] FROM t",
+		},
+		{
+			name:     "subscript outside the query blocks",
+			query:    "UPDATE t SET x = arr[2] WHERE TRUE",
+			expected: "UPDATE t SET x = arr[OFFSET(2)] WHERE TRUE",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			blocks, outside := arraySubscripts(test.query)
+			var edits []*edit
+			for _, block := range blocks {
+				// the types are unknown without the probe, so the subscripts are rewritten as the ones of ARRAY values.
+				edits = append(edits, block.edits(nil)...)
+			}
+			for _, subscript := range outside {
+				edits = append(edits, subscript.edits()...)
+			}
+			if got := applyEdits(test.query, edits); got != test.expected {
+				t.Fatalf("failed to rewrite query:\nexpected: %s\ngot:      %s", test.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,490 @@
+package contentdata
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	bitwiseNegativeShiftError = "Bitwise shift by negative offset."
+	// bitwiseUnaryPrecedence is higher than the precedences of the binary operators.
+	bitwiseUnaryPrecedence = 7
+)
+
+// bitwiseLeftBoundaries are the keywords that end the left operand of the bitwise operator.
+var bitwiseLeftBoundaries = []string{
+	"SELECT", "DISTINCT", "ALL", "FROM", "WHERE", "HAVING", "QUALIFY", "BY", "LIMIT", "OFFSET",
+	"AND", "OR", "NOT", "IS", "IN", "LIKE", "BETWEEN", "AS", "ON", "USING", "SET", "RETURN",
+	"CASE", "WHEN", "THEN", "ELSE",
+}
+
+// bitwiseRightBoundaries are the keywords that end the right operand of the bitwise operator.
+var bitwiseRightBoundaries = []string{
+	"FROM", "WHERE", "GROUP", "HAVING", "QUALIFY", "WINDOW", "ORDER", "LIMIT", "OFFSET",
+	"AND", "OR", "NOT", "IS", "IN", "LIKE", "BETWEEN", "AS", "ASC", "DESC", "NULLS", "ON", "USING",
+	"JOIN", "INNER", "FULL", "CROSS", "UNION", "INTERSECT", "EXCEPT", "WHEN", "THEN", "ELSE", "END",
+}
+
+// bitwiseOperator is the operator at the depth of its operands. width is the number of its tokens like 2 of `<<`.
+type bitwiseOperator struct {
+	text       string
+	width      int
+	precedence int
+	binary     bool
+	comparison bool
+}
+
+// bitwiseOperation is the bitwise operator or the SAFE_NEGATE call.
+// go-zetasqlite evaluates the bitwise operators only for INT64, shifts INT64 to the right with the sign extension
+// and panics on the negative shift, and returns FLOAT64 from SAFE_NEGATE.
+type bitwiseOperation struct {
+	// op is &, |, ^, <<, >>, ~ or SAFE_NEGATE.
+	op string
+	// start and end are the index of the first token and the index next to the last token of the operation.
+	start int
+	end   int
+	// operands are the token ranges of the operands. The unary operator and SAFE_NEGATE have one operand.
+	operands [][2]int
+}
+
+// bitwiseBlock is the query block that has the bitwise operations.
+// The types of the operands are unknown until the probe runs.
+type bitwiseBlock struct {
+	probe      *typeProbe
+	operations []*bitwiseOperation
+}
+
+// bitwiseQuery is the query that has the bitwise operations.
+type bitwiseQuery struct {
+	query      string
+	tokens     []*token
+	operations []*bitwiseOperation
+	blocks     []*bitwiseBlock
+	// types are the types of the first operands selected by the probes.
+	types map[*bitwiseOperation]string
+}
+
+// parseBitwiseQuery returns the bitwise operations of query grouped by the query blocks.
+// It returns nil if query has no bitwise operations or the operands can't be determined.
+func parseBitwiseQuery(query string) *bitwiseQuery {
+	tokens := tokenize(query)
+	typeParams := typeParameterTokens(tokens)
+	operators := bitwiseOperators(tokens, typeParams)
+	if len(operators) == 0 {
+		return nil
+	}
+	operatorEnds := map[int]int{}
+	for idx, op := range operators {
+		operatorEnds[idx+op.width-1] = idx
+	}
+	q := &bitwiseQuery{query: query, tokens: tokens, types: map[*bitwiseOperation]string{}}
+	for idx := 0; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.isKeyword("SAFE_NEGATE") && idx+1 < len(tokens) && tokens[idx+1].isSymbol("(") && (idx == 0 || !tokens[idx-1].isSymbol(".")) {
+			closeIdx := skipParen(tokens, idx+1)
+			if closeIdx > idx+2 && closeIdx < len(tokens) {
+				q.operations = append(q.operations, &bitwiseOperation{
+					op:       "SAFE_NEGATE",
+					start:    idx,
+					end:      closeIdx + 1,
+					operands: [][2]int{{idx + 2, closeIdx}},
+				})
+			}
+			continue
+		}
+		op, exists := operators[idx]
+		if !exists {
+			continue
+		}
+		switch {
+		case op.text == "~":
+			end := bitwiseOperandEnd(tokens, idx+1, bitwiseUnaryPrecedence, operators, typeParams)
+			if end > idx+1 {
+				q.operations = append(q.operations, &bitwiseOperation{op: "~", start: idx, end: end, operands: [][2]int{{idx + 1, end}}})
+			}
+		case op.binary && (op.text == "&" || op.text == "|" || op.text == "^" || op.text == "<<" || op.text == ">>"):
+			start := bitwiseOperandStart(tokens, idx, op.precedence, operators, operatorEnds, typeParams)
+			end := bitwiseOperandEnd(tokens, idx+op.width, op.precedence, operators, typeParams)
+			if start < idx && end > idx+op.width {
+				q.operations = append(q.operations, &bitwiseOperation{
+					op:       op.text,
+					start:    start,
+					end:      end,
+					operands: [][2]int{{start, idx}, {idx + op.width, end}},
+				})
+			}
+		}
+		idx += op.width - 1
+	}
+	if len(q.operations) == 0 {
+		return nil
+	}
+	sort.SliceStable(q.operations, func(i, j int) bool {
+		a, b := q.operations[i], q.operations[j]
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		return a.end > b.end
+	})
+	for i, a := range q.operations {
+		for _, b := range q.operations[i+1:] {
+			if b.start < a.end && b.end > a.end {
+				// the operations must be nested or disjoint.
+				return nil
+			}
+		}
+	}
+
+	var (
+		byStart = map[int]*bitwiseBlock{}
+		keys    = map[*bitwiseBlock][]string{}
+		queries = map[*bitwiseBlock]*queryBlock{}
+	)
+	for _, operation := range q.operations {
+		block := findQueryBlock(tokens, operation.start)
+		if block == nil {
+			continue
+		}
+		bitwise, exists := byStart[block.start]
+		if !exists {
+			bitwise = &bitwiseBlock{}
+			byStart[block.start] = bitwise
+			queries[bitwise] = block
+			q.blocks = append(q.blocks, bitwise)
+		}
+		bitwise.operations = append(bitwise.operations, operation)
+		operand := operation.operands[0]
+		keys[bitwise] = append(keys[bitwise], tokenText(query, tokens[operand[0]:operand[1]]))
+	}
+	for _, block := range q.blocks {
+		// the probe doesn't evaluate the operations that go-zetasqlite fails on.
+		block.probe = queries[block].typeProbe(query, tokens, keys[block])
+	}
+	return q
+}
+
+// typeParameterTokens returns the indexes of the tokens in the type parameters like ARRAY<STRUCT<x INT64>>.
+func typeParameterTokens(tokens []*token) map[int]bool {
+	params := map[int]bool{}
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if !(tk.isKeyword("ARRAY") || tk.isKeyword("STRUCT") || tk.isKeyword("RANGE")) || !tokens[idx+1].isSymbol("<") {
+			continue
+		}
+		end := skipTypeParameters(tokens, idx+1, len(tokens))
+		for i := idx + 1; i < end; i++ {
+			params[i] = true
+		}
+		idx = end - 1
+	}
+	return params
+}
+
+// bitwiseOperators returns the operators and the comparisons of tokens by the index of their first tokens.
+// + and - are binary only if they follow an operand.
+func bitwiseOperators(tokens []*token, typeParams map[int]bool) map[int]*bitwiseOperator {
+	operators := map[int]*bitwiseOperator{}
+	for idx := 0; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenSymbol || typeParams[idx] {
+			continue
+		}
+		next := func(sym string) bool {
+			return idx+1 < len(tokens) && tokens[idx+1].isSymbol(sym) && tokens[idx+1].start == tk.end
+		}
+		afterOperand := idx > 0 && isBitwiseOperandEnd(tokens[idx-1])
+		var op *bitwiseOperator
+		switch tk.text {
+		case "<":
+			switch {
+			case next("<"):
+				op = &bitwiseOperator{text: "<<", width: 2, precedence: 4, binary: true}
+			case next("=") || next(">"):
+				op = &bitwiseOperator{width: 2, comparison: true}
+			default:
+				op = &bitwiseOperator{width: 1, comparison: true}
+			}
+		case ">":
+			switch {
+			case next(">"):
+				op = &bitwiseOperator{text: ">>", width: 2, precedence: 4, binary: true}
+			case next("="):
+				op = &bitwiseOperator{width: 2, comparison: true}
+			default:
+				op = &bitwiseOperator{width: 1, comparison: true}
+			}
+		case "=":
+			op = &bitwiseOperator{width: 1, comparison: true}
+		case "!":
+			if next("=") {
+				op = &bitwiseOperator{width: 2, comparison: true}
+			}
+		case "|":
+			if next("|") {
+				op = &bitwiseOperator{text: "||", width: 2, precedence: 6, binary: afterOperand}
+			} else {
+				op = &bitwiseOperator{text: "|", width: 1, precedence: 1, binary: true}
+			}
+		case "^":
+			op = &bitwiseOperator{text: "^", width: 1, precedence: 2, binary: true}
+		case "&":
+			op = &bitwiseOperator{text: "&", width: 1, precedence: 3, binary: true}
+		case "+", "-":
+			op = &bitwiseOperator{text: tk.text, width: 1, precedence: 5, binary: afterOperand}
+		case "*", "/":
+			op = &bitwiseOperator{text: tk.text, width: 1, precedence: 6, binary: afterOperand}
+		case "~":
+			op = &bitwiseOperator{text: "~", width: 1}
+		}
+		if op == nil {
+			continue
+		}
+		operators[idx] = op
+		idx += op.width - 1
+	}
+	return operators
+}
+
+func isBitwiseOperandEnd(tk *token) bool {
+	switch tk.kind {
+	case tokenWord:
+		return tk.isKeyword("END") || (!isKeywordIn(tk, bitwiseLeftBoundaries) && !isKeywordIn(tk, bitwiseRightBoundaries))
+	case tokenQuotedIdent, tokenString, tokenNumber, tokenParam:
+		return true
+	}
+	return tk.isSymbol(")") || tk.isSymbol("]")
+}
+
+// bitwiseOperandStart returns the index of the first token of the left operand of the binary operator at tokens[opIdx].
+// The left operand has the operators of the same or the higher precedence because the operators are left-associative.
+func bitwiseOperandStart(tokens []*token, opIdx, precedence int, operators map[int]*bitwiseOperator, operatorEnds map[int]int, typeParams map[int]bool) int {
+	depth := tokens[opIdx].depth
+	idx := opIdx - 1
+	for idx >= 0 {
+		tk := tokens[idx]
+		if tk.depth < depth {
+			break
+		}
+		if tk.depth > depth || typeParams[idx] {
+			idx--
+			continue
+		}
+		if tk.isKeyword("END") {
+			caseIdx := matchingCase(tokens, idx)
+			if caseIdx < 0 {
+				break
+			}
+			idx = caseIdx - 1
+			continue
+		}
+		if isOperandBoundary(tk, bitwiseLeftBoundaries) {
+			break
+		}
+		if start, exists := operatorEnds[idx]; exists {
+			op := operators[start]
+			if op.comparison || (op.binary && op.precedence < precedence) {
+				break
+			}
+			idx = start - 1
+			continue
+		}
+		idx--
+	}
+	return idx + 1
+}
+
+// bitwiseOperandEnd returns the index next to the last token of the right operand starting at tokens[start].
+func bitwiseOperandEnd(tokens []*token, start, precedence int, operators map[int]*bitwiseOperator, typeParams map[int]bool) int {
+	if start >= len(tokens) {
+		return start
+	}
+	depth := tokens[start].depth
+	idx := start
+	for idx < len(tokens) {
+		tk := tokens[idx]
+		if tk.depth < depth {
+			break
+		}
+		if tk.depth > depth || typeParams[idx] {
+			idx++
+			continue
+		}
+		if tk.isKeyword("CASE") {
+			idx = matchingEnd(tokens, idx) + 1
+			continue
+		}
+		if isOperandBoundary(tk, bitwiseRightBoundaries) {
+			break
+		}
+		if op, exists := operators[idx]; exists {
+			if op.comparison || (op.binary && op.precedence <= precedence) {
+				break
+			}
+			idx += op.width
+			continue
+		}
+		idx++
+	}
+	return idx
+}
+
+// matchingCase returns the index of CASE of END at tokens[endIdx] or -1 if it is not found.
+func matchingCase(tokens []*token, endIdx int) int {
+	level := 0
+	for idx := endIdx; idx >= 0; idx-- {
+		tk := tokens[idx]
+		if tk.depth != tokens[endIdx].depth {
+			continue
+		}
+		if tk.isKeyword("END") {
+			level++
+		} else if tk.isKeyword("CASE") {
+			level--
+			if level == 0 {
+				return idx
+			}
+		}
+	}
+	return -1
+}
+
+// matchingEnd returns the index of END of CASE at tokens[caseIdx].
+func matchingEnd(tokens []*token, caseIdx int) int {
+	level := 0
+	for idx := caseIdx; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth != tokens[caseIdx].depth {
+			continue
+		}
+		if tk.isKeyword("CASE") {
+			level++
+		} else if tk.isKeyword("END") {
+			level--
+			if level == 0 {
+				return idx
+			}
+		}
+	}
+	return len(tokens) - 1
+}
+
+// edits returns the edits that rewrite the outermost bitwise operations by the types of their operands.
+// The nested operations are rewritten in the operands of the outer ones.
+func (q *bitwiseQuery) edits() []*edit {
+	var (
+		edits []*edit
+		pos   int
+	)
+	for _, operation := range q.operations {
+		start := q.tokens[operation.start].start
+		if start < pos {
+			continue
+		}
+		end := q.tokens[operation.end-1].end
+		pos = end
+		if replacement := q.operationExpr(operation); replacement != q.query[start:end] {
+			edits = append(edits, &edit{start: start, end: end, replacement: replacement})
+		}
+	}
+	return edits
+}
+
+// text returns the source text of the tokens in [from, to) with the bitwise operations rewritten.
+func (q *bitwiseQuery) text(from, to int) string {
+	var b strings.Builder
+	pos := q.tokens[from].start
+	for _, operation := range q.operations {
+		if operation.start < from || operation.end > to || q.tokens[operation.start].start < pos {
+			continue
+		}
+		b.WriteString(q.query[pos:q.tokens[operation.start].start])
+		b.WriteString(q.operationExpr(operation))
+		pos = q.tokens[operation.end-1].end
+	}
+	b.WriteString(q.query[pos:q.tokens[to-1].end])
+	return b.String()
+}
+
+// operationExpr returns the expression that evaluates the operation as BigQuery does.
+// The operation whose operand type is unknown is kept except for the nested operations.
+func (q *bitwiseQuery) operationExpr(operation *bitwiseOperation) string {
+	operands := make([]string, 0, len(operation.operands))
+	for _, operand := range operation.operands {
+		operands = append(operands, q.text(operand[0], operand[1]))
+	}
+	typ := q.types[operation]
+	switch {
+	case operation.op == "SAFE_NEGATE" && typ == "INTEGER":
+		return fmt.Sprintf("IF((%[1]s) = -9223372036854775808, NULL, -(%[1]s))", operands[0])
+	case operation.op == "SAFE_NEGATE" && (typ == "FLOAT" || typ == "NUMERIC" || typ == "BIGNUMERIC"):
+		return fmt.Sprintf("(-(%s))", operands[0])
+	case operation.op == "<<" && typ == "INTEGER":
+		return fmt.Sprintf(
+			"IF((%[2]s) < 0, ERROR('%[3]s'), (%[1]s) << (%[2]s))",
+			operands[0], operands[1], bitwiseNegativeShiftError,
+		)
+	case operation.op == ">>" && typ == "INTEGER":
+		// INT64 is shifted without the sign extension.
+		return fmt.Sprintf(
+			"IF((%[2]s) < 0, ERROR('%[3]s'), IF((%[2]s) >= 64, 0, ((%[1]s) >> (%[2]s)) & ~(-1 << (64 - (%[2]s)))))",
+			operands[0], operands[1], bitwiseNegativeShiftError,
+		)
+	case typ == "BYTES":
+		return bytesBitwiseExpr(operation.op, operands)
+	}
+	var b strings.Builder
+	pos := q.tokens[operation.start].start
+	for idx, operand := range operation.operands {
+		b.WriteString(q.query[pos:q.tokens[operand[0]].start])
+		b.WriteString(operands[idx])
+		pos = q.tokens[operand[1]-1].end
+	}
+	b.WriteString(q.query[pos:q.tokens[operation.end-1].end])
+	return b.String()
+}
+
+// bytesBitwiseExpr returns the expression that evaluates the bitwise operator on BYTES byte by byte.
+// The binary operators require the operands of the same length, and the shifts keep the length of the value.
+func bytesBitwiseExpr(op string, operands []string) string {
+	codePoints := func(v string) string {
+		return fmt.Sprintf("UNNEST(TO_CODE_POINTS(%s)) AS bitwise_byte WITH OFFSET AS bitwise_offset", v)
+	}
+	switch op {
+	case "~":
+		return fmt.Sprintf(
+			"IF((%[1]s) IS NULL, NULL, CODE_POINTS_TO_BYTES(ARRAY(SELECT 255 - bitwise_byte FROM %[2]s ORDER BY bitwise_offset)))",
+			operands[0], codePoints(operands[0]),
+		)
+	case "<<", ">>":
+		sign := "+"
+		if op == ">>" {
+			sign = "-"
+		}
+		// each byte has the bits of the byte at the shifted offset and the rest of the bits of its neighbor.
+		byteAt := func(next string) string {
+			return fmt.Sprintf(
+				"IFNULL(TO_CODE_POINTS(%s)[SAFE_OFFSET(bitwise_offset %s DIV(%s, 8)%s)], 0)",
+				operands[0], sign, operands[1], next,
+			)
+		}
+		next := " + 1"
+		if op == ">>" {
+			next = " - 1"
+		}
+		inverse := map[string]string{"<<": ">>", ">>": "<<"}[op]
+		return fmt.Sprintf(
+			"CASE WHEN (%[1]s) IS NULL OR (%[2]s) IS NULL THEN NULL WHEN (%[2]s) < 0 THEN ERROR('%[3]s') "+
+				"ELSE CODE_POINTS_TO_BYTES(ARRAY(SELECT ((%[4]s %[5]s MOD(%[2]s, 8)) | (%[6]s %[7]s (8 - MOD(%[2]s, 8)))) & 255 "+
+				"FROM %[8]s ORDER BY bitwise_offset)) END",
+			operands[0], operands[1], bitwiseNegativeShiftError, byteAt(""), op, byteAt(next), inverse, codePoints(operands[0]),
+		)
+	}
+	return fmt.Sprintf(
+		"CASE WHEN (%[1]s) IS NULL OR (%[2]s) IS NULL THEN NULL WHEN LENGTH(%[1]s) != LENGTH(%[2]s) THEN ERROR(CONCAT("+
+			"'Bitwise binary operator for BYTES requires equal length of the inputs. Got ', CAST(LENGTH(%[1]s) AS STRING), "+
+			"' bytes on the left hand side and ', CAST(LENGTH(%[2]s) AS STRING), ' bytes on the right hand side.')) "+
+			"ELSE CODE_POINTS_TO_BYTES(ARRAY(SELECT bitwise_byte %[3]s TO_CODE_POINTS(%[2]s)[OFFSET(bitwise_offset)] FROM %[4]s ORDER BY bitwise_offset)) END",
+		operands[0], operands[1], op, codePoints(operands[0]),
+	)
+}
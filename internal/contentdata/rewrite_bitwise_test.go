@@ -0,0 +1,54 @@
+package contentdata
+
+import "testing"
+
+func TestBitwiseOperators(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		typ      string
+		expected string
+	}{
+		{
+			name:     "int64 operands",
+			query:    "SELECT a & b FROM t",
+			typ:      "INT64",
+			expected: "SELECT a & b FROM t",
+		},
+		{
+			name:  "bytes not",
+			query: "SELECT ~a FROM t",
+			typ:   "BYTES",
+			expected: "SELECT IF((a) IS NULL, NULL, CODE_POINTS_TO_BYTES(ARRAY(SELECT 255 - bitwise_byte " +
+				"FROM UNNEST(TO_CODE_POINTS(a)) AS bitwise_byte WITH OFFSET AS bitwise_offset ORDER BY bitwise_offset))) FROM t",
+		},
+		{
+			name:  "bytes and",
+			query: "SELECT a & b FROM t",
+			typ:   "BYTES",
+			expected: "SELECT CASE WHEN (a) IS NULL OR (b) IS NULL THEN NULL WHEN LENGTH(a) != LENGTH(b) THEN " +
+				"ERROR(CONCAT('Bitwise binary operator for BYTES requires equal length of the inputs. Got ', " +
+				"CAST(LENGTH(a) AS STRING), ' bytes on the left hand side and ', CAST(LENGTH(b) AS STRING), ' bytes on the right hand side.')) " +
+				"ELSE CODE_POINTS_TO_BYTES(ARRAY(SELECT bitwise_byte & TO_CODE_POINTS(b)[OFFSET(bitwise_offset)] " +
+				"FROM UNNEST(TO_CODE_POINTS(a)) AS bitwise_byte WITH OFFSET AS bitwise_offset ORDER BY bitwise_offset)) END FROM t",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			q := parseBitwiseQuery(test.query)
+			if q == nil {
+				t.Fatal("failed to parse the bitwise operations")
+			}
+			for _, operation := range q.operations {
+				q.types[operation] = test.typ
+			}
+			if got := applyEdits(test.query, q.edits()); got != test.expected {
+				t.Fatalf("failed to rewrite query:\nexpected: %s\ngot:      %s", test.expected, got)
+			}
+		})
+	}
+	if parseBitwiseQuery("SELECT 1") != nil {
+		t.Fatal("expected no bitwise operations")
+	}
+}
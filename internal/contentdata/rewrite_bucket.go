@@ -0,0 +1,187 @@
+package contentdata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultTimestampBucketOrigin = "TIMESTAMP '1950-01-01 00:00:00+00'"
+	defaultDatetimeBucketOrigin  = "DATETIME '1950-01-01 00:00:00'"
+	defaultDateBucketOrigin      = "DATE '1950-01-01'"
+)
+
+var bucketWidthMicros = map[string]int64{
+	"MICROSECOND": 1,
+	"MILLISECOND": 1000,
+	"SECOND":      1000 * 1000,
+	"MINUTE":      60 * 1000 * 1000,
+	"HOUR":        60 * 60 * 1000 * 1000,
+	"DAY":         24 * 60 * 60 * 1000 * 1000,
+	"WEEK":        7 * 24 * 60 * 60 * 1000 * 1000,
+}
+
+var bucketWidthMonths = map[string]int64{
+	"MONTH":   1,
+	"QUARTER": 3,
+	"YEAR":    12,
+}
+
+// bucketFunctionEdits replaces TIMESTAMP_BUCKET, DATETIME_BUCKET and DATE_BUCKET calls
+// with the equivalent date arithmetic.
+// The bucket containing the value starts at origin + N * bucket_width where N is the largest integer
+// that the start of the bucket doesn't exceed the value. N can be negative when the value precedes origin.
+func bucketFunctionEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		if name != "TIMESTAMP_BUCKET" && name != "DATETIME_BUCKET" && name != "DATE_BUCKET" {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		for i, arg := range args {
+			// rewrite the nested calls like TIMESTAMP_BUCKET(TIMESTAMP_BUCKET(...), ...).
+			argEdits, err := bucketFunctionEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = applyEdits(arg, argEdits)
+		}
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("%s: expected 2 or 3 arguments but got %d", name, len(args))
+		}
+		width, unit, err := parseBucketWidth(name, args[1])
+		if err != nil {
+			return nil, err
+		}
+		var origin string
+		if len(args) == 3 {
+			origin = args[2]
+		}
+		expr, err := bucketExpr(name, args[0], width, unit, origin)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+// functionArgs returns the source text of each argument of the function call
+// whose parentheses are tokens[openIdx] and tokens[closeIdx].
+func functionArgs(query string, tokens []*token, openIdx, closeIdx int) []string {
+	var (
+		args  []string
+		start = tokens[openIdx].end
+		depth = tokens[openIdx].depth + 1
+	)
+	for idx := openIdx + 1; idx < closeIdx; idx++ {
+		if tokens[idx].depth == depth && tokens[idx].isSymbol(",") {
+			args = append(args, strings.TrimSpace(query[start:tokens[idx].start]))
+			start = tokens[idx].end
+		}
+	}
+	if closeIdx > openIdx+1 {
+		args = append(args, strings.TrimSpace(query[start:tokens[closeIdx].start]))
+	}
+	return args
+}
+
+// parseBucketWidth parses `INTERVAL <value> <part>` and returns the value expression and the date part.
+func parseBucketWidth(name, arg string) (string, string, error) {
+	tokens := tokenize(arg)
+	if len(tokens) < 3 || !tokens[0].isKeyword("INTERVAL") || tokens[len(tokens)-1].kind != tokenWord {
+		return "", "", fmt.Errorf("%s: bucket width must be an INTERVAL with a single date part but got %s", name, arg)
+	}
+	value := strings.TrimSpace(arg[tokens[1].start:tokens[len(tokens)-2].end])
+	unit := strings.ToUpper(tokens[len(tokens)-1].text)
+	if v, err := strconv.ParseInt(value, 10, 64); err == nil && v <= 0 {
+		return "", "", fmt.Errorf("%s: bucket width must be positive but got %s", name, arg)
+	}
+	return value, unit, nil
+}
+
+const (
+	timestampBucketFunction     = "bqemulator_timestamp_bucket"
+	dateBucketFunction          = "bqemulator_date_bucket"
+	dateMonthBucketFunction     = "bqemulator_date_month_bucket"
+	datetimeMonthBucketFunction = "bqemulator_datetime_month_bucket"
+)
+
+// The bucket functions bind the value, the origin and the step of the bucket,
+// so each of them is evaluated once even though the arithmetic refers to them several times.
+var (
+	// timestampBucketFunctionDefinition returns the bucket start of the value in the microseconds since the epoch.
+	timestampBucketFunctionDefinition = "CREATE TEMP FUNCTION " + timestampBucketFunction +
+		"(value INT64, origin INT64, step INT64) AS (" +
+		fmt.Sprintf("TIMESTAMP_MICROS(origin + step * %s)", floorDivExpr("(value - origin)", "step")) + ");\n"
+	dateBucketFunctionDefinition = "CREATE TEMP FUNCTION " + dateBucketFunction +
+		"(value DATE, origin DATE, step INT64) AS (" +
+		fmt.Sprintf("DATE_ADD(origin, INTERVAL step * %s DAY)", floorDivExpr("DATE_DIFF(value, origin, DAY)", "step")) + ");\n"
+	dateMonthBucketFunctionDefinition = "CREATE TEMP FUNCTION " + dateMonthBucketFunction +
+		"(value DATE, origin DATE, step INT64) AS (" + monthBucketExpr("DATE") + ");\n"
+	datetimeMonthBucketFunctionDefinition = "CREATE TEMP FUNCTION " + datetimeMonthBucketFunction +
+		"(value DATETIME, origin DATETIME, step INT64) AS (" + monthBucketExpr("DATETIME") + ");\n"
+)
+
+func bucketExpr(name, value, width, unit, origin string) (string, error) {
+	micros, isMicros := bucketWidthMicros[unit]
+	months, isMonths := bucketWidthMonths[unit]
+	switch {
+	case name == "TIMESTAMP_BUCKET" && isMicros:
+		if origin == "" {
+			origin = defaultTimestampBucketOrigin
+		}
+		return fmt.Sprintf("%s(UNIX_MICROS(%s), UNIX_MICROS(%s), (%s) * %d)", timestampBucketFunction, value, origin, width, micros), nil
+	case name == "DATETIME_BUCKET" && isMicros:
+		if origin == "" {
+			origin = defaultDatetimeBucketOrigin
+		}
+		return fmt.Sprintf(
+			"DATETIME(%s(UNIX_MICROS(TIMESTAMP(%s, 'UTC')), UNIX_MICROS(TIMESTAMP(%s, 'UTC')), (%s) * %d), 'UTC')",
+			timestampBucketFunction, value, origin, width, micros,
+		), nil
+	case name == "DATETIME_BUCKET" && isMonths:
+		if origin == "" {
+			origin = defaultDatetimeBucketOrigin
+		}
+		return fmt.Sprintf("%s(%s, %s, (%s) * %d)", datetimeMonthBucketFunction, value, origin, width, months), nil
+	case name == "DATE_BUCKET" && (unit == "DAY" || unit == "WEEK"):
+		if origin == "" {
+			origin = defaultDateBucketOrigin
+		}
+		return fmt.Sprintf("%s(%s, %s, (%s) * %d)", dateBucketFunction, value, origin, width, micros/bucketWidthMicros["DAY"]), nil
+	case name == "DATE_BUCKET" && isMonths:
+		if origin == "" {
+			origin = defaultDateBucketOrigin
+		}
+		return fmt.Sprintf("%s(%s, %s, (%s) * %d)", dateMonthBucketFunction, value, origin, width, months), nil
+	}
+	return "", fmt.Errorf("%s: unsupported date part %s for bucket width", name, unit)
+}
+
+// monthBucketExpr returns the bucket start for the month based width of the arguments value, origin and step.
+// Since DATE_DIFF counts the month boundaries, the candidate is moved back by one bucket
+// when the day of origin is later than the day of value in the month.
+func monthBucketExpr(typ string) string {
+	n := floorDivExpr(fmt.Sprintf("%s_DIFF(value, origin, MONTH)", typ), "step")
+	candidate := func(n string) string {
+		return fmt.Sprintf("%s_ADD(origin, INTERVAL step * %s MONTH)", typ, n)
+	}
+	return fmt.Sprintf("IF(%s > value, %s, %s)", candidate(n), candidate(fmt.Sprintf("(%s - 1)", n)), candidate(n))
+}
+
+// floorDivExpr returns the expression for the integer division rounded towards negative infinity.
+func floorDivExpr(x, y string) string {
+	return fmt.Sprintf("(DIV(%[1]s, %[2]s) - IF(MOD(%[1]s, %[2]s) < 0, 1, 0))", x, y)
+}
@@ -0,0 +1,31 @@
+package contentdata
+
+import "testing"
+
+func TestBucketFunctions(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "date bucket with the default origin",
+			query:    "SELECT DATE_BUCKET(d, INTERVAL 2 DAY) FROM t",
+			expected: "SELECT bqemulator_date_bucket(d, DATE '1950-01-01', (2) * 1) FROM t",
+		},
+		{
+			name:     "timestamp bucket with origin",
+			query:    "SELECT TIMESTAMP_BUCKET(ts, INTERVAL 1 HOUR, TIMESTAMP '2024-01-01 00:30:00') FROM t",
+			expected: "SELECT bqemulator_timestamp_bucket(UNIX_MICROS(ts), UNIX_MICROS(TIMESTAMP '2024-01-01 00:30:00'), (1) * 3600000000) FROM t",
+		},
+		{
+			name:  "datetime bucket",
+			query: "SELECT DATETIME_BUCKET(dt, INTERVAL 15 MINUTE) FROM t",
+			expected: "SELECT DATETIME(bqemulator_timestamp_bucket(" +
+				"UNIX_MICROS(bqemulator_timestamp_in_zone(dt, 'UTC')), " +
+				"UNIX_MICROS(bqemulator_timestamp_in_zone(DATETIME '1950-01-01 00:00:00', 'UTC')), " +
+				"(15) * 60000000), 'UTC') FROM t",
+		},
+		{
+			name:        "missing bucket width",
+			query:       "SELECT DATE_BUCKET(d) FROM t",
+			expectedErr: "DATE_BUCKET: expected 2 or 3 arguments but got 1",
+		},
+	})
+}
@@ -0,0 +1,494 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+const caseInsensitiveCollation = "und:ci"
+
+var (
+	leftOperandBoundaries = []string{
+		"AND", "OR", "NOT", "WHERE", "ON", "HAVING", "QUALIFY", "WHEN", "THEN", "ELSE", "CASE", "SELECT", "BY", "RETURN", "SET",
+	}
+	rightOperandBoundaries = []string{
+		"AND", "OR", "THEN", "WHEN", "ELSE", "END", "FROM", "WHERE", "GROUP", "HAVING", "QUALIFY", "WINDOW", "ORDER",
+		"LIMIT", "UNION", "INTERSECT", "EXCEPT", "AS", "ASC", "DESC",
+	}
+	// orderingItemTerminators are the keywords that end the expression of an ORDER BY item.
+	orderingItemTerminators = []string{
+		"ASC", "DESC", "NULLS", "COLLATE", "LIMIT", "OFFSET", "ROWS", "RANGE", "UNION", "INTERSECT", "EXCEPT",
+		"SELECT", "FROM", "WHERE", "GROUP", "HAVING", "QUALIFY", "WINDOW", "ON",
+	}
+	// orderingClauseBoundaries are the keywords before ORDER BY clause.
+	orderingClauseBoundaries = []string{"SELECT", "FROM", "WHERE", "HAVING", "QUALIFY", "WINDOW", "ON", "SET", "LIMIT"}
+)
+
+// collateEdits applies the collation specified by COLLATE(value, collate_specification).
+// go-zetasqlite returns the value of COLLATE as is, so the collation is applied here.
+// For the comparisons, both operands are compared by NORMALIZE_AND_CASEFOLD when either operand has 'und:ci' collation,
+// so the canonically equivalent strings and the strings like 'ß' and 'ss' are equal as ICU collation does.
+// The ORDER BY item with 'und:ci' collation orders by NORMALIZE_AND_CASEFOLD of the value,
+// and collateGroupingEdits has already rewritten GROUP BY and SELECT DISTINCT.
+// The empty and 'binary' collations are the default collation, so COLLATE is removed.
+func collateEdits(query string, tokens []*token) ([]*edit, error) {
+	var (
+		edits    []*edit
+		consumed = map[int]struct{}{}
+	)
+	for idx := 0; idx < len(tokens); idx++ {
+		opEnd, isOp := comparisonOperatorEnd(tokens, idx)
+		if !isOp {
+			continue
+		}
+		leftStart := leftOperandStart(tokens, idx)
+		rightEnd := rightOperandEnd(tokens, opEnd+1)
+		if leftStart >= idx || rightEnd <= opEnd+1 {
+			idx = opEnd
+			continue
+		}
+		left, leftSpec := collateOperand(query, tokens, leftStart, idx)
+		right, rightSpec := collateOperand(query, tokens, opEnd+1, rightEnd)
+		if leftSpec != nil && rightSpec != nil && *leftSpec != *rightSpec {
+			return nil, fmt.Errorf("collation conflict: %q vs %q", *leftSpec, *rightSpec)
+		}
+		if !isCaseInsensitive(leftSpec) && !isCaseInsensitive(rightSpec) {
+			idx = opEnd
+			continue
+		}
+		edits = append(edits,
+			&edit{
+				start:       tokens[leftStart].start,
+				end:         tokens[idx-1].end,
+				replacement: fmt.Sprintf("NORMALIZE_AND_CASEFOLD(%s)", left),
+			},
+			&edit{
+				start:       tokens[opEnd+1].start,
+				end:         tokens[rightEnd-1].end,
+				replacement: fmt.Sprintf("NORMALIZE_AND_CASEFOLD(%s)", right),
+			},
+		)
+		for i := leftStart; i < rightEnd; i++ {
+			consumed[i] = struct{}{}
+		}
+		idx = opEnd
+	}
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		if _, exists := consumed[idx]; exists {
+			continue
+		}
+		closeIdx, spec, ok := collateCall(tokens, idx)
+		if !ok {
+			continue
+		}
+		var format string
+		switch {
+		case *spec == "" || strings.EqualFold(*spec, "binary"):
+			format = "(%s)"
+		case isCaseInsensitive(spec) && isOrderingItem(tokens, idx, closeIdx+1):
+			format = "NORMALIZE_AND_CASEFOLD(%s)"
+		default:
+			continue
+		}
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		edits = append(edits, &edit{
+			start:       tokens[idx].start,
+			end:         tokens[closeIdx].end,
+			replacement: fmt.Sprintf(format, args[0]),
+		})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+// isOrderingItem reports whether tokens[start:end] is the expression of an ORDER BY item.
+func isOrderingItem(tokens []*token, start, end int) bool {
+	if end < len(tokens) && tokens[end].depth == tokens[start].depth {
+		next := tokens[end]
+		if !next.isSymbol(",") && !next.isSymbol(")") && !isOperandBoundary(next, orderingItemTerminators) {
+			return false
+		}
+	}
+	depth := tokens[start].depth
+	for idx := start - 1; idx >= 0; idx-- {
+		tk := tokens[idx]
+		if tk.depth > depth {
+			continue
+		}
+		if tk.depth < depth {
+			return false
+		}
+		if tk.isKeyword("BY") {
+			return idx > 0 && tokens[idx-1].isKeyword("ORDER")
+		}
+		if idx == start-1 && !tk.isSymbol(",") {
+			return false
+		}
+		if isOperandBoundary(tk, orderingClauseBoundaries) && !tk.isSymbol(",") {
+			return false
+		}
+	}
+	return false
+}
+
+func isCaseInsensitive(spec *string) bool {
+	return spec != nil && strings.EqualFold(*spec, caseInsensitiveCollation)
+}
+
+// comparisonOperatorEnd reports whether tokens[idx] starts a comparison operator and returns the index of its last token.
+func comparisonOperatorEnd(tokens []*token, idx int) (int, bool) {
+	tk := tokens[idx]
+	if tk.isKeyword("LIKE") {
+		if idx > 0 && tokens[idx-1].isKeyword("NOT") {
+			return 0, false
+		}
+		return idx, true
+	}
+	next := func(sym string) bool {
+		return idx+1 < len(tokens) && tokens[idx+1].isSymbol(sym) && tokens[idx+1].start == tk.end
+	}
+	switch {
+	case tk.isSymbol("="):
+		if idx > 0 && (tokens[idx-1].isSymbol("<") || tokens[idx-1].isSymbol(">") || tokens[idx-1].isSymbol("!")) &&
+			tokens[idx-1].end == tk.start {
+			return 0, false
+		}
+		return idx, true
+	case tk.isSymbol("!"):
+		if next("=") {
+			return idx + 1, true
+		}
+	case tk.isSymbol("<"):
+		if next("=") || next(">") {
+			return idx + 1, true
+		}
+		if next("<") {
+			return 0, false
+		}
+		if idx > 0 && tokens[idx-1].isSymbol("<") && tokens[idx-1].end == tk.start {
+			return 0, false
+		}
+		return idx, true
+	case tk.isSymbol(">"):
+		if idx > 0 && (tokens[idx-1].isSymbol("<") || tokens[idx-1].isSymbol(">") || tokens[idx-1].isSymbol("-")) &&
+			tokens[idx-1].end == tk.start {
+			return 0, false
+		}
+		if next("=") {
+			return idx + 1, true
+		}
+		if next(">") {
+			return 0, false
+		}
+		return idx, true
+	}
+	return 0, false
+}
+
+func isOperandBoundary(tk *token, boundaries []string) bool {
+	if tk.isSymbol(",") || tk.isSymbol(";") {
+		return true
+	}
+	for _, kw := range boundaries {
+		if tk.isKeyword(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// leftOperandStart returns the index of the first token of the left operand of the operator at tokens[opIdx].
+func leftOperandStart(tokens []*token, opIdx int) int {
+	depth := tokens[opIdx].depth
+	idx := opIdx - 1
+	for ; idx >= 0; idx-- {
+		tk := tokens[idx]
+		if tk.depth < depth || (tk.depth == depth && isOperandBoundary(tk, leftOperandBoundaries)) {
+			break
+		}
+	}
+	return idx + 1
+}
+
+// rightOperandEnd returns the index next to the last token of the right operand starting at tokens[start].
+func rightOperandEnd(tokens []*token, start int) int {
+	if start >= len(tokens) {
+		return start
+	}
+	depth := tokens[start].depth
+	idx := start
+	for ; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth < depth || (tk.depth == depth && isOperandBoundary(tk, rightOperandBoundaries)) {
+			break
+		}
+	}
+	return idx
+}
+
+// collateCall reports whether tokens[idx] is `COLLATE(value, 'spec')` and returns the index of the closing parenthesis and the spec.
+func collateCall(tokens []*token, idx int) (int, *string, bool) {
+	if !tokens[idx].isKeyword("COLLATE") || idx+1 >= len(tokens) || !tokens[idx+1].isSymbol("(") {
+		return 0, nil, false
+	}
+	closeIdx := skipParen(tokens, idx+1)
+	specTk := tokens[closeIdx-1]
+	if specTk.kind != tokenString || closeIdx-2 <= idx+1 || !tokens[closeIdx-2].isSymbol(",") {
+		return 0, nil, false
+	}
+	spec := strings.Trim(specTk.text, `'"`)
+	return closeIdx, &spec, true
+}
+
+// collateOperand returns the source text of the operand in tokens[start:end] without COLLATE and its collation.
+func collateOperand(query string, tokens []*token, start, end int) (string, *string) {
+	text := query[tokens[start].start:tokens[end-1].end]
+	closeIdx, spec, ok := collateCall(tokens, start)
+	if !ok || closeIdx != end-1 {
+		return text, nil
+	}
+	args := functionArgs(query, tokens, start+1, closeIdx)
+	return args[0], spec
+}
+
+// collateGroupingEdits rewrites GROUP BY and SELECT DISTINCT of the values with 'und:ci' collation.
+// The rows are grouped by NORMALIZE_AND_CASEFOLD of the value, and the value is read by ANY_VALUE
+// same as the grouping by STRUCT, so one of the values of the group is returned like BigQuery.
+func collateGroupingEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx, tk := range tokens {
+		if !tk.isKeyword("SELECT") {
+			continue
+		}
+		block := findQueryBlock(tokens, idx)
+		if block == nil || block.start != idx {
+			continue
+		}
+		edits = append(edits, block.collateGroupingEdits(query)...)
+	}
+	return edits, nil
+}
+
+func (b *queryBlock) collateGroupingEdits(query string) []*edit {
+	var (
+		edits  []*edit
+		values []string
+	)
+	if items := b.groupingItems(); len(items) != 0 {
+		if items[0][0].isKeyword("ROLLUP") || items[0][0].isKeyword("CUBE") || items[0][0].isKeyword("GROUPING") {
+			return nil
+		}
+		for _, item := range items {
+			value, ok := caseInsensitiveValue(b.groupingKey(query, item))
+			if !ok {
+				continue
+			}
+			edits = append(edits, &edit{
+				start:       item[0].start,
+				end:         item[len(item)-1].end,
+				replacement: fmt.Sprintf("NORMALIZE_AND_CASEFOLD(%s)", value),
+			})
+			values = append(values, value)
+		}
+	} else {
+		keys := map[int]string{}
+		for idx, item := range b.items {
+			expr, _ := selectItemExpr(query, item)
+			if value, ok := caseInsensitiveValue(expr); ok {
+				keys[idx] = fmt.Sprintf("NORMALIZE_AND_CASEFOLD(%s)", value)
+				values = append(values, value)
+			}
+		}
+		if len(keys) != 0 {
+			edits = b.distinctGroupByEdits(keys)
+		}
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	for _, value := range values {
+		edits = append(edits, b.anyValueEdits(query, value)...)
+	}
+	return edits
+}
+
+// caseInsensitiveValue returns the value of expr if expr is COLLATE(value, 'und:ci').
+func caseInsensitiveValue(expr string) (string, bool) {
+	tokens := tokenize(expr)
+	if len(tokens) == 0 {
+		return "", false
+	}
+	closeIdx, spec, ok := collateCall(tokens, 0)
+	if !ok || closeIdx != len(tokens)-1 || !isCaseInsensitive(spec) {
+		return "", false
+	}
+	return functionArgs(expr, tokens, 1, closeIdx)[0], true
+}
+
+// collationDefinitionEdits removes the collations of the columns and DEFAULT COLLATE from CREATE TABLE statement,
+// because go-zetasqlite doesn't support them. ParseDDLStatement reads them for the table schema.
+func collationDefinitionEdits(query string, tokens []*token) ([]*edit, error) {
+	if !isCreateTableStatement(tokens) {
+		return nil, nil
+	}
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		if !tokens[idx].isKeyword("COLLATE") || tokens[idx+1].kind != tokenString {
+			continue
+		}
+		start := tokens[idx].start
+		if idx > 0 && tokens[idx-1].isKeyword("DEFAULT") {
+			start = tokens[idx-1].start
+		}
+		edits = append(edits, &edit{start: start, end: tokens[idx+1].end})
+		idx++
+	}
+	return edits, nil
+}
+
+// isCreateTableStatement reports whether tokens are CREATE [OR REPLACE] [TEMP] TABLE statement.
+func isCreateTableStatement(tokens []*token) bool {
+	p := &statementParser{tokens: tokens}
+	if !p.consumeKeywords("CREATE") {
+		return false
+	}
+	p.consumeKeywords("OR", "REPLACE")
+	_ = p.consumeKeywords("TEMP") || p.consumeKeywords("TEMPORARY")
+	return p.consumeKeywords("TABLE")
+}
+
+// ApplyColumnCollations applies 'und:ci' collation of the table columns to query.
+// columns are the names of the columns with the collation. The references to them are wrapped with COLLATE(column, 'und:ci')
+// where the collation takes effect: the operands of the comparisons, the items of ORDER BY and GROUP BY and the items of SELECT DISTINCT.
+// The columns are matched by the name, so the column of the same name of another table in the query is collated too.
+func ApplyColumnCollations(query string, columns []string) string {
+	if len(columns) == 0 {
+		return query
+	}
+	tokens := tokenize(query)
+	isColumn := func(refs []*token) bool {
+		name := implicitColumnName(refs)
+		if name == "" || (len(refs) == 1 && isReservedKeyword(refs[0])) {
+			return false
+		}
+		for _, column := range columns {
+			if strings.EqualFold(column, name) {
+				return true
+			}
+		}
+		return false
+	}
+	var edits []*edit
+	collate := func(refs []*token, alias string) {
+		replacement := fmt.Sprintf("COLLATE(%s, '%s')", tokenText(query, refs), caseInsensitiveCollation)
+		if alias != "" {
+			replacement += fmt.Sprintf(" AS `%s`", alias)
+		}
+		edits = append(edits, &edit{start: refs[0].start, end: refs[len(refs)-1].end, replacement: replacement})
+	}
+	for idx := 0; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if opEnd, isOp := comparisonOperatorEnd(tokens, idx); isOp {
+			start := leftOperandStart(tokens, idx)
+			if isAssignment(tokens, start) {
+				idx = opEnd
+				continue
+			}
+			if start < idx && isColumn(tokens[start:idx]) {
+				collate(tokens[start:idx], "")
+			}
+			if end := rightOperandEnd(tokens, opEnd+1); end > opEnd+1 && isColumn(tokens[opEnd+1:end]) {
+				collate(tokens[opEnd+1:end], "")
+			}
+			idx = opEnd
+			continue
+		}
+		switch {
+		case tk.isKeyword("BY") && idx > 0 && (tokens[idx-1].isKeyword("ORDER") || tokens[idx-1].isKeyword("GROUP")):
+			for _, item := range clauseItems(tokens, idx+1) {
+				if isColumn(item) {
+					collate(item, "")
+				}
+			}
+		case tk.isKeyword("DISTINCT") && idx > 0 && tokens[idx-1].isKeyword("SELECT"):
+			for _, item := range parseSelectList(tokens, idx-1) {
+				expr, alias := selectItemExpr(query, item)
+				refs := tokenize(expr)
+				if !isColumn(refs) {
+					continue
+				}
+				refs = item.tokens[:len(refs)]
+				if alias == "" {
+					collate(refs, implicitColumnName(refs))
+				} else {
+					collate(refs, "")
+				}
+			}
+		}
+	}
+	return applyEdits(query, edits)
+}
+
+// isAssignment reports whether the operand starting at tokens[start] is the column assigned by SET clause of UPDATE.
+func isAssignment(tokens []*token, start int) bool {
+	if start >= len(tokens) {
+		return false
+	}
+	depth := tokens[start].depth
+	for idx := start - 1; idx >= 0; idx-- {
+		tk := tokens[idx]
+		if tk.depth != depth {
+			if tk.depth < depth {
+				return false
+			}
+			continue
+		}
+		if tk.isKeyword("SET") {
+			return true
+		}
+		if !tk.isSymbol(",") && isOperandBoundary(tk, leftOperandBoundaries) {
+			return false
+		}
+	}
+	return false
+}
+
+// clauseItems returns the expressions of the items of ORDER BY or GROUP BY clause starting at tokens[start].
+// ASC, DESC and NULLS FIRST ( or LAST ) of the items are excluded.
+func clauseItems(tokens []*token, start int) [][]*token {
+	if start >= len(tokens) {
+		return nil
+	}
+	var (
+		items [][]*token
+		cur   []*token
+		ended bool
+		depth = tokens[start].depth
+	)
+	for idx := start; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth < depth {
+			break
+		}
+		if tk.depth == depth {
+			if tk.isSymbol(",") {
+				items = append(items, cur)
+				cur, ended = nil, false
+				continue
+			}
+			if tk.isSymbol(";") || isOperandBoundary(tk, []string{"HAVING", "QUALIFY", "WINDOW", "ORDER", "LIMIT", "OFFSET", "ROWS", "RANGE", "UNION", "INTERSECT", "EXCEPT"}) {
+				break
+			}
+			if isOperandBoundary(tk, []string{"ASC", "DESC", "NULLS", "COLLATE"}) {
+				ended = true
+			}
+		}
+		if !ended {
+			cur = append(cur, tk)
+		}
+	}
+	if len(cur) != 0 {
+		items = append(items, cur)
+	}
+	return items
+}
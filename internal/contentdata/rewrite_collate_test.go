@@ -0,0 +1,53 @@
+package contentdata
+
+import "testing"
+
+func TestCollate(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "case insensitive comparison",
+			query:    "SELECT COLLATE(a, 'und:ci') = b FROM t",
+			expected: "SELECT bqemulator_normalize_and_casefold(a, 'NFD', 'NFC') = bqemulator_normalize_and_casefold(b, 'NFD', 'NFC') FROM t",
+		},
+		{
+			name:     "case insensitive order",
+			query:    "SELECT a FROM t ORDER BY COLLATE(a, 'und:ci')",
+			expected: "SELECT a FROM t ORDER BY bqemulator_normalize_and_casefold(a, 'NFD', 'NFC')",
+		},
+		{
+			name:     "empty collation",
+			query:    "SELECT COLLATE(a, '') = b FROM t",
+			expected: "SELECT (a) = b FROM t",
+		},
+		{
+			name:        "collation conflict",
+			query:       "SELECT COLLATE(a, 'und:ci') = COLLATE(b, 'binary') FROM t",
+			expectedErr: `collation conflict: "und:ci" vs "binary"`,
+		},
+	})
+}
+
+func TestCollateGrouping(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "group by collated column",
+			query:    "SELECT COLLATE(a, 'und:ci') AS c FROM t GROUP BY c",
+			expected: "SELECT COLLATE(ANY_VALUE(a), 'und:ci') AS c FROM t GROUP BY bqemulator_normalize_and_casefold(a, 'NFD', 'NFC')",
+		},
+		{
+			name:     "distinct collated column",
+			query:    "SELECT DISTINCT COLLATE(a, 'und:ci') FROM t",
+			expected: "SELECT  COLLATE(ANY_VALUE(a), 'und:ci') FROM t GROUP BY bqemulator_normalize_and_casefold(a, 'NFD', 'NFC') ",
+		},
+	})
+}
+
+func TestCollationDefinition(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "column collation",
+			query:    "CREATE TABLE t (s STRING COLLATE 'und:ci')",
+			expected: "CREATE TABLE t (s STRING )",
+		},
+	})
+}
@@ -0,0 +1,211 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dateArithmeticParts are the date parts that DATE_ADD, DATETIME_ADD, TIMESTAMP_ADD, TIME_ADD and their _SUB functions accept.
+var dateArithmeticParts = map[string][]string{
+	"DATE":      {"DAY", "WEEK", "MONTH", "QUARTER", "YEAR"},
+	"DATETIME":  {"MICROSECOND", "MILLISECOND", "SECOND", "MINUTE", "HOUR", "DAY", "WEEK", "MONTH", "QUARTER", "YEAR"},
+	"TIMESTAMP": {"MICROSECOND", "MILLISECOND", "SECOND", "MINUTE", "HOUR", "DAY"},
+	"TIME":      {"MICROSECOND", "MILLISECOND", "SECOND", "MINUTE", "HOUR"},
+}
+
+// intervalParts are the date parts of the INTERVAL literal.
+var intervalParts = []string{
+	"YEAR", "QUARTER", "MONTH", "WEEK", "DAY", "HOUR", "MINUTE", "SECOND", "MILLISECOND", "MICROSECOND", "NANOSECOND",
+}
+
+// dateArithmeticEdits rewrites the date arithmetic that go-zetasqlite evaluates differently from BigQuery.
+//   - DATE_ADD, DATETIME_ADD, TIMESTAMP_ADD, TIME_ADD and their _SUB functions with the date part that BigQuery
+//     doesn't accept for the type are errors, like HOUR of DATE_ADD.
+//   - QUARTER of the functions is 3 MONTH, because go-zetasqlite adds a quarter regardless of the value
+//     and doesn't support QUARTER of DATE_SUB and DATETIME_SUB.
+//   - `+ INTERVAL n WEEK` and `+ INTERVAL n QUARTER` are 7 DAY and 3 MONTH that go-zetasqlite supports.
+//   - `x + INTERVAL n MONTH` and YEAR are the days to the same day of the month of DATE_ADD,
+//     so the day past the end of the month is the last day of the month like BigQuery.
+//     The left operand is rewritten only if it is a column, a parameter, a literal, a function call or parenthesized.
+func dateArithmeticEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if (tk.isSymbol("+") || tk.isSymbol("-")) && tokens[idx+1].isKeyword("INTERVAL") {
+			partIdx, ok := intervalPartIndex(tokens, idx+1)
+			if !ok {
+				continue
+			}
+			value := query[tokens[idx+2].start:tokens[partIdx-1].end]
+			part := strings.ToUpper(tokens[partIdx].text)
+			var normalized bool
+			switch part {
+			case "WEEK":
+				value, part, normalized = fmt.Sprintf("(%s) * 7", value), "DAY", true
+			case "QUARTER":
+				value, part, normalized = fmt.Sprintf("(%s) * 3", value), "MONTH", true
+			case "MONTH", "YEAR":
+			default:
+				continue
+			}
+			start, ok := dateOperandStart(tokens, idx)
+			if part == "DAY" || !ok {
+				if normalized {
+					edits = append(edits, &edit{
+						start:       tokens[idx+1].start,
+						end:         tokens[partIdx].end,
+						replacement: fmt.Sprintf("INTERVAL %s %s", value, part),
+					})
+				}
+				idx = partIdx
+				continue
+			}
+			// the operand replaces the edits in it, so it is rewritten again.
+			operandStart := tokens[start].start
+			for len(edits) > 0 && edits[len(edits)-1].end > operandStart {
+				edits = edits[:len(edits)-1]
+			}
+			operand := query[operandStart:tokens[idx-1].end]
+			operandEdits, err := dateArithmeticEdits(operand, tokenize(operand))
+			if err != nil {
+				return nil, err
+			}
+			operand = applyEdits(operand, operandEdits)
+			if tk.isSymbol("-") {
+				value = fmt.Sprintf("-(%s)", value)
+			}
+			date := fmt.Sprintf("CAST(%s AS DATE)", operand)
+			days := fmt.Sprintf("UNIX_DATE(DATE_ADD(%[1]s, INTERVAL %[2]s %[3]s)) - UNIX_DATE(%[1]s)", date, value, part)
+			edits = append(edits, &edit{
+				start:       operandStart,
+				end:         tokens[partIdx].end,
+				replacement: fmt.Sprintf("(%s + INTERVAL (%s) DAY)", operand, days),
+			})
+			idx = partIdx
+			continue
+		}
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		sep := strings.LastIndex(name, "_")
+		if sep < 0 || (name[sep+1:] != "ADD" && name[sep+1:] != "SUB") {
+			continue
+		}
+		parts, exists := dateArithmeticParts[name[:sep]]
+		if !exists {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) != 2 {
+			continue
+		}
+		intervalTokens := tokenize(args[1])
+		partIdx, ok := intervalPartIndex(intervalTokens, 0)
+		if !ok || partIdx != len(intervalTokens)-1 {
+			continue
+		}
+		part := strings.ToUpper(intervalTokens[partIdx].text)
+		if !isKeywordIn(intervalTokens[partIdx], parts) {
+			return nil, fmt.Errorf("%s does not support the %s date part", name, part)
+		}
+		if part != "QUARTER" {
+			continue
+		}
+		value := args[1][intervalTokens[1].start:intervalTokens[partIdx-1].end]
+		rewritten := []string{args[0], value}
+		for i, arg := range rewritten {
+			// rewrite the nested arithmetic like DATE_ADD(d + INTERVAL 1 MONTH, INTERVAL 1 QUARTER).
+			argEdits, err := dateArithmeticEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			rewritten[i] = applyEdits(arg, argEdits)
+		}
+		edits = append(edits, &edit{
+			start:       tk.start,
+			end:         tokens[closeIdx].end,
+			replacement: fmt.Sprintf("%s(%s, INTERVAL (%s) * 3 MONTH)", tk.text, rewritten[0], rewritten[1]),
+		})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+// intervalPartIndex returns the index of the date part of `INTERVAL value part` starting at tokens[intervalIdx].
+// The range like `INTERVAL '1-2' YEAR TO MONTH` is not matched.
+func intervalPartIndex(tokens []*token, intervalIdx int) (int, bool) {
+	if intervalIdx+2 >= len(tokens) || !tokens[intervalIdx].isKeyword("INTERVAL") {
+		return 0, false
+	}
+	depth := tokens[intervalIdx].depth
+	for idx := intervalIdx + 2; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.depth < depth {
+			return 0, false
+		}
+		if tk.depth > depth || tk.kind != tokenWord || !isKeywordIn(tk, intervalParts) {
+			continue
+		}
+		if idx+1 < len(tokens) && tokens[idx+1].isKeyword("TO") {
+			return 0, false
+		}
+		return idx, true
+	}
+	return 0, false
+}
+
+// dateOperandStart returns the index of the first token of the left operand of the operator at tokens[opIdx]
+// if the operand is a column, a parameter, a typed literal like DATE '2024-01-31', a function call or parenthesized,
+// and the operand isn't the operand of the other operator.
+func dateOperandStart(tokens []*token, opIdx int) (int, bool) {
+	if opIdx == 0 {
+		return 0, false
+	}
+	start := opIdx - 1
+	tk := tokens[start]
+	switch {
+	case tk.isSymbol(")"):
+		for start--; start >= 0 && !(tokens[start].depth == tk.depth && tokens[start].isSymbol("(")); start-- {
+		}
+		if start < 0 {
+			return 0, false
+		}
+		if start > 0 && isDateOperandName(tokens[start-1]) {
+			start--
+		}
+	case tk.kind == tokenString:
+		if start == 0 || !isKeywordIn(tokens[start-1], []string{"DATE", "DATETIME", "TIMESTAMP"}) {
+			return 0, false
+		}
+		start--
+	case tk.kind == tokenParam:
+	case isDateOperandName(tk):
+	default:
+		return 0, false
+	}
+	for start >= 2 && tokens[start-1].isSymbol(".") && isDateOperandName(tokens[start-2]) {
+		start -= 2
+	}
+	if start == 0 {
+		return start, true
+	}
+	prev := tokens[start-1]
+	if prev.isSymbol("(") || prev.isSymbol(",") || prev.isSymbol("=") || prev.isSymbol("<") || prev.isSymbol(">") ||
+		isKeywordIn(prev, bitwiseLeftBoundaries) {
+		return start, true
+	}
+	return 0, false
+}
+
+// isDateOperandName reports whether tk is the name of the column or the function.
+func isDateOperandName(tk *token) bool {
+	if tk.kind == tokenQuotedIdent {
+		return true
+	}
+	return tk.kind == tokenWord && !isKeywordIn(tk, bitwiseLeftBoundaries) && !tk.isKeyword("END") && !tk.isKeyword("INTERVAL")
+}
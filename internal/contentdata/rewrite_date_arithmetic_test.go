@@ -0,0 +1,34 @@
+package contentdata
+
+import "testing"
+
+func TestDateArithmetic(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "date sub of quarters",
+			query:    "SELECT DATE_SUB(d, INTERVAL 2 QUARTER) FROM t",
+			expected: "SELECT DATE_SUB(d, INTERVAL (2) * 3 MONTH) FROM t",
+		},
+		{
+			name:  "date plus month interval",
+			query: "SELECT DATE '2024-01-31' + INTERVAL 1 MONTH",
+			expected: "SELECT (DATE '2024-01-31' + INTERVAL (UNIX_DATE(DATE_ADD(CAST(DATE '2024-01-31' AS DATE), INTERVAL 1 MONTH)) - " +
+				"UNIX_DATE(CAST(DATE '2024-01-31' AS DATE))) DAY)",
+		},
+		{
+			name:     "date add of months",
+			query:    "SELECT DATE_ADD(d, INTERVAL 1 MONTH) FROM t",
+			expected: "SELECT DATE_ADD(d, INTERVAL 1 MONTH) FROM t",
+		},
+		{
+			name:        "date sub of hours",
+			query:       "SELECT DATE_SUB(d, INTERVAL 1 HOUR) FROM t",
+			expectedErr: "DATE_SUB does not support the HOUR date part",
+		},
+		{
+			name:        "timestamp sub of months",
+			query:       "SELECT TIMESTAMP_SUB(ts, INTERVAL 1 MONTH) FROM t",
+			expectedErr: "TIMESTAMP_SUB does not support the MONTH date part",
+		},
+	})
+}
@@ -0,0 +1,147 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unixEpochWeekday is the weekday of 1970-01-01 ( Thursday ) where Sunday is 0.
+const unixEpochWeekday = 4
+
+var weekdays = map[string]int{
+	"SUNDAY":    0,
+	"MONDAY":    1,
+	"TUESDAY":   2,
+	"WEDNESDAY": 3,
+	"THURSDAY":  4,
+	"FRIDAY":    5,
+	"SATURDAY":  6,
+}
+
+var diffUnitMicros = map[string]int64{
+	"MICROSECOND": 1,
+	"MILLISECOND": 1000,
+	"SECOND":      1000 * 1000,
+	"MINUTE":      60 * 1000 * 1000,
+	"HOUR":        60 * 60 * 1000 * 1000,
+	"DAY":         24 * 60 * 60 * 1000 * 1000,
+}
+
+// dateDiffEdits replaces DATE_DIFF, DATETIME_DIFF and TIMESTAMP_DIFF calls with the date arithmetic.
+//   - DATE_DIFF and DATETIME_DIFF count the part boundaries crossed between the values, not the elapsed time.
+//     WEEK starts on Sunday, WEEK(<WEEKDAY>) starts on the weekday and ISOWEEK starts on Monday.
+//   - TIMESTAMP_DIFF counts the whole parts elapsed between the values, truncated towards zero.
+//     So DAY is always 24 hours regardless of the daylight saving time.
+//
+// The calls with the part that BigQuery doesn't support are evaluated by go-zetasqlite as is.
+func dateDiffEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		if name != "DATE_DIFF" && name != "DATETIME_DIFF" && name != "TIMESTAMP_DIFF" {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) != 3 {
+			continue
+		}
+		for i, arg := range args[:2] {
+			// rewrite the nested calls like DATE_DIFF(DATE_ADD(d, INTERVAL DATE_DIFF(...) DAY), ...).
+			argEdits, err := dateDiffEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = applyEdits(arg, argEdits)
+		}
+		expr, ok := dateDiffExpr(name, args[0], args[1], args[2])
+		if !ok {
+			continue
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+func dateDiffExpr(name, a, b, partArg string) (string, bool) {
+	part, weekday, ok := parseDatePart(partArg)
+	if !ok {
+		return "", false
+	}
+	if name == "TIMESTAMP_DIFF" {
+		micros, ok := diffUnitMicros[part]
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("DIV(UNIX_MICROS(%s) - UNIX_MICROS(%s), %d)", a, b, micros), true
+	}
+	if name == "DATETIME_DIFF" && part != "DAY" {
+		if micros, ok := diffUnitMicros[part]; ok {
+			boundary := func(v string) string {
+				return floorDivExpr(fmt.Sprintf("UNIX_MICROS(TIMESTAMP(%s, 'UTC'))", v), fmt.Sprint(micros))
+			}
+			return fmt.Sprintf("(%s - %s)", boundary(a), boundary(b)), true
+		}
+	}
+	var boundary func(v string) string
+	switch part {
+	case "DAY":
+		boundary = func(v string) string {
+			return unixDateExpr(name, v)
+		}
+	case "WEEK", "ISOWEEK":
+		boundary = func(v string) string {
+			return floorDivExpr(fmt.Sprintf("(%s + %d)", unixDateExpr(name, v), unixEpochWeekday-weekday), "7")
+		}
+	case "MONTH":
+		boundary = func(v string) string {
+			return fmt.Sprintf("(EXTRACT(YEAR FROM %[1]s) * 12 + EXTRACT(MONTH FROM %[1]s))", v)
+		}
+	case "QUARTER":
+		boundary = func(v string) string {
+			return fmt.Sprintf("(EXTRACT(YEAR FROM %[1]s) * 4 + DIV(EXTRACT(MONTH FROM %[1]s) - 1, 3))", v)
+		}
+	case "YEAR", "ISOYEAR":
+		boundary = func(v string) string {
+			return fmt.Sprintf("EXTRACT(%s FROM %s)", part, v)
+		}
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("(%s - %s)", boundary(a), boundary(b)), true
+}
+
+// parseDatePart parses the date part like DAY, WEEK or WEEK(MONDAY).
+// The weekday is the start of the week for WEEK and ISOWEEK.
+func parseDatePart(arg string) (string, int, bool) {
+	tokens := tokenize(arg)
+	if len(tokens) == 0 || tokens[0].kind != tokenWord {
+		return "", 0, false
+	}
+	part := strings.ToUpper(tokens[0].text)
+	switch {
+	case len(tokens) == 1 && part == "ISOWEEK":
+		return part, weekdays["MONDAY"], true
+	case len(tokens) == 1:
+		return part, weekdays["SUNDAY"], true
+	case len(tokens) == 4 && part == "WEEK" && tokens[1].isSymbol("(") && tokens[3].isSymbol(")"):
+		weekday, ok := weekdays[strings.ToUpper(tokens[2].text)]
+		return part, weekday, ok
+	}
+	return "", 0, false
+}
+
+func unixDateExpr(name, v string) string {
+	if name == "DATETIME_DIFF" {
+		return fmt.Sprintf("UNIX_DATE(DATE(%s))", v)
+	}
+	return fmt.Sprintf("UNIX_DATE(%s)", v)
+}
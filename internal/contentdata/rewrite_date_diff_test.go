@@ -0,0 +1,39 @@
+package contentdata
+
+import "testing"
+
+func TestDateDiff(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "days",
+			query:    "SELECT DATE_DIFF(a, b, DAY) FROM t",
+			expected: "SELECT (UNIX_DATE(a) - UNIX_DATE(b)) FROM t",
+		},
+		{
+			name:     "months",
+			query:    "SELECT DATE_DIFF(a, b, MONTH) FROM t",
+			expected: "SELECT ((EXTRACT(YEAR FROM a) * 12 + EXTRACT(MONTH FROM a)) - (EXTRACT(YEAR FROM b) * 12 + EXTRACT(MONTH FROM b))) FROM t",
+		},
+		{
+			name:     "iso years",
+			query:    "SELECT DATE_DIFF(a, b, ISOYEAR) FROM t",
+			expected: "SELECT (EXTRACT(ISOYEAR FROM a) - EXTRACT(ISOYEAR FROM b)) FROM t",
+		},
+		{
+			name:  "weeks starting on monday",
+			query: "SELECT DATE_DIFF(a, b, WEEK(MONDAY)) FROM t",
+			expected: "SELECT ((DIV((UNIX_DATE(a) + 3), 7) - IF(MOD((UNIX_DATE(a) + 3), 7) < 0, 1, 0)) - " +
+				"(DIV((UNIX_DATE(b) + 3), 7) - IF(MOD((UNIX_DATE(b) + 3), 7) < 0, 1, 0))) FROM t",
+		},
+		{
+			name:     "datetime days",
+			query:    "SELECT DATETIME_DIFF(a, b, DAY) FROM t",
+			expected: "SELECT (UNIX_DATE(DATE(a)) - UNIX_DATE(DATE(b))) FROM t",
+		},
+		{
+			name:     "timestamp hours",
+			query:    "SELECT TIMESTAMP_DIFF(a, b, HOUR) FROM t",
+			expected: "SELECT DIV(UNIX_MICROS(a) - UNIX_MICROS(b), 3600000000) FROM t",
+		},
+	})
+}
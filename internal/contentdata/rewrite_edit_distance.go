@@ -0,0 +1,86 @@
+package contentdata
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// editDistanceFunction is the temporary JavaScript function computing the Levenshtein distance of the code points.
+// go-zetasqlite doesn't have EDIT_DISTANCE, so EDIT_DISTANCE calls are replaced with the calls of this function,
+// and the function is defined before the statement only while the statement runs.
+const editDistanceFunction = "bqemulator_edit_distance"
+
+// editDistanceFunctionDefinition stops computing the distance as soon as every edit path exceeds max_distance like BigQuery.
+const editDistanceFunctionDefinition = "CREATE TEMP FUNCTION " + editDistanceFunction +
+	"(a_points ARRAY<INT64>, b_points ARRAY<INT64>, max_distance INT64) RETURNS INT64 LANGUAGE js AS r\"\"\"\n" +
+	`if (max_distance !== null && max_distance < 0) {
+  throw new Error("EDIT_DISTANCE: max_distance must be non-negative");
+}
+var a = a_points || [];
+var b = b_points || [];
+var prev = [];
+for (var j = 0; j <= b.length; j++) {
+  prev.push(j);
+}
+for (var i = 1; i <= a.length; i++) {
+  var cur = [i];
+  var rowMin = i;
+  for (var j = 1; j <= b.length; j++) {
+    var cost = a[i - 1] == b[j - 1] ? 0 : 1;
+    cur.push(Math.min(prev[j] + 1, cur[j - 1] + 1, prev[j - 1] + cost));
+    rowMin = Math.min(rowMin, cur[j]);
+  }
+  if (max_distance !== null && rowMin >= max_distance) {
+    return max_distance;
+  }
+  prev = cur;
+}
+var d = prev[b.length];
+return max_distance !== null && d > max_distance ? max_distance : d;
+` + "\"\"\";\n"
+
+var maxDistanceArgPattern = regexp.MustCompile(`(?is)^max_distance\s*=>\s*(.+)$`)
+
+// editDistanceEdits replaces `EDIT_DISTANCE(a, b [, max_distance => n])` with the call of editDistanceFunction.
+// The distance of STRING counts the Unicode characters and the distance of BYTES counts the bytes,
+// so the values are passed as their code points. The result is NULL if either value is NULL.
+func editDistanceEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tk.isKeyword("EDIT_DISTANCE") || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("EDIT_DISTANCE expects 2 arguments and the optional max_distance argument but got %d arguments", len(args))
+		}
+		for i, arg := range args {
+			// rewrite the nested calls like EDIT_DISTANCE(EDIT_DISTANCE(...), ...).
+			argEdits, err := editDistanceEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = applyEdits(arg, argEdits)
+		}
+		maxDistance := "CAST(NULL AS INT64)"
+		if len(args) == 3 {
+			matched := maxDistanceArgPattern.FindStringSubmatch(args[2])
+			if matched == nil {
+				return nil, fmt.Errorf("EDIT_DISTANCE expects max_distance as the named argument: max_distance => %s", args[2])
+			}
+			maxDistance = fmt.Sprintf("CAST(%s AS INT64)", matched[1])
+		}
+		expr := fmt.Sprintf(
+			"IF((%[1]s) IS NULL OR (%[2]s) IS NULL, NULL, %[3]s(TO_CODE_POINTS(%[1]s), TO_CODE_POINTS(%[2]s), %[4]s))",
+			args[0], args[1], editDistanceFunction, maxDistance,
+		)
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
@@ -0,0 +1,28 @@
+package contentdata
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "edit distance",
+			query:    "SELECT EDIT_DISTANCE(a, b) FROM t",
+			expected: "SELECT IF((a) IS NULL OR (b) IS NULL, NULL, bqemulator_edit_distance(TO_CODE_POINTS(a), TO_CODE_POINTS(b), CAST(NULL AS INT64))) FROM t",
+		},
+		{
+			name:     "max distance",
+			query:    "SELECT EDIT_DISTANCE(a, b, max_distance => 2) FROM t",
+			expected: "SELECT IF((a) IS NULL OR (b) IS NULL, NULL, bqemulator_edit_distance(TO_CODE_POINTS(a), TO_CODE_POINTS(b), CAST(2 AS INT64))) FROM t",
+		},
+		{
+			name:        "positional max distance",
+			query:       "SELECT EDIT_DISTANCE(a, b, 2) FROM t",
+			expectedErr: "EDIT_DISTANCE expects max_distance as the named argument: max_distance => 2",
+		},
+		{
+			name:        "missing argument",
+			query:       "SELECT EDIT_DISTANCE(a) FROM t",
+			expectedErr: "EDIT_DISTANCE expects 2 arguments and the optional max_distance argument but got 1 arguments",
+		},
+	})
+}
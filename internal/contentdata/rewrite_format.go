@@ -0,0 +1,463 @@
+package contentdata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
+// formatBlock is the query block that has FORMAT calls with the literal format string.
+// go-zetasqlite supports only one flag for each specifier, ignores the width of %s, doesn't support
+// the positional arguments like %2$s and formats %t and %T of some types ( e.g. FLOAT64 and DATETIME )
+// differently from BigQuery. So the calls are rewritten into the concatenation of the formatted arguments
+// whose expressions are built from the types of the arguments.
+type formatBlock struct {
+	calls []*formatCall
+	// probe is the query that selects the arguments of the calls from the FROM clause of the block
+	// to check their types.
+	probe *typeProbe
+}
+
+// formatCall is `FORMAT(format, arg[, ...])`.
+type formatCall struct {
+	start  int
+	end    int
+	pieces []*formatPiece
+	args   []string
+}
+
+// formatPiece is the literal text or the specifier of the format string.
+type formatPiece struct {
+	text string
+	spec *formatSpec
+}
+
+// formatSpec is `%[position$][flags][width][.precision]specifier`.
+type formatSpec struct {
+	flags string
+	// width and precision are the number or `*` that takes the value from the argument.
+	width        string
+	precision    string
+	hasPrecision bool
+	verb         byte
+	// position is the 1-based position of the argument given by `%N$`. It is 0 for the next argument.
+	position int
+}
+
+// parseFormatString parses the format string of FORMAT.
+func parseFormatString(format string) ([]*formatPiece, error) {
+	var (
+		pieces []*formatPiece
+		text   strings.Builder
+	)
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			text.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i >= len(format) {
+			return nil, fmt.Errorf("invalid format string %q: it ends with %%", format)
+		}
+		if format[i] == '%' {
+			text.WriteByte('%')
+			continue
+		}
+		if text.Len() != 0 {
+			pieces = append(pieces, &formatPiece{text: text.String()})
+			text.Reset()
+		}
+		spec := &formatSpec{}
+		digits := func() string {
+			start := i
+			for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+				i++
+			}
+			return format[start:i]
+		}
+		if n := digits(); n != "" {
+			if i < len(format) && format[i] == '$' && n[0] != '0' {
+				spec.position, _ = strconv.Atoi(n)
+				i++
+			} else {
+				// the digits are the flag 0 and the width.
+				i -= len(n)
+			}
+		}
+		for i < len(format) && strings.IndexByte("-+ #0'", format[i]) >= 0 {
+			spec.flags += string(format[i])
+			i++
+		}
+		if i < len(format) && format[i] == '*' {
+			spec.width = "*"
+			i++
+		} else {
+			spec.width = digits()
+		}
+		if i < len(format) && format[i] == '.' {
+			spec.hasPrecision = true
+			i++
+			if i < len(format) && format[i] == '*' {
+				spec.precision = "*"
+				i++
+			} else {
+				spec.precision = digits()
+			}
+		}
+		if i >= len(format) || strings.IndexByte("dioxXfFeEgGsStTpP", format[i]) < 0 {
+			return nil, fmt.Errorf("invalid format string %q: unsupported specifier", format)
+		}
+		spec.verb = format[i]
+		if spec.position != 0 && (spec.width == "*" || spec.precision == "*") {
+			return nil, fmt.Errorf("invalid format string %q: * can't be used with the positional argument", format)
+		}
+		pieces = append(pieces, &formatPiece{spec: spec})
+	}
+	if text.Len() != 0 {
+		pieces = append(pieces, &formatPiece{text: text.String()})
+	}
+	return pieces, nil
+}
+
+// formatBlocks returns the query blocks of query that have FORMAT calls with the literal format string.
+func formatBlocks(query string) []*formatBlock {
+	tokens := tokenize(query)
+	var (
+		blocks  []*formatBlock
+		byStart = map[int]*formatBlock{}
+		args    = map[*formatBlock][]string{}
+		queries = map[*formatBlock]*queryBlock{}
+	)
+	for idx := 0; idx+2 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if !tk.isKeyword("FORMAT") || !tokens[idx+1].isSymbol("(") || (idx > 0 && tokens[idx-1].isSymbol(".")) {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx >= len(tokens) {
+			continue
+		}
+		callArgs := functionArgs(query, tokens, idx+1, closeIdx)
+		// the nested FORMAT calls in the arguments are kept as is.
+		formatToken := tokens[idx+2]
+		if len(callArgs) == 0 || formatToken.kind != tokenString || formatToken.isBytesLiteral() ||
+			!(idx+3 < len(tokens) && (tokens[idx+3].isSymbol(",") || idx+3 == closeIdx)) {
+			idx = closeIdx
+			continue
+		}
+		format, ok := stringLiteralValue(formatToken)
+		if !ok {
+			idx = closeIdx
+			continue
+		}
+		pieces, err := parseFormatString(format)
+		if err != nil {
+			idx = closeIdx
+			continue
+		}
+		block := findQueryBlock(tokens, idx)
+		if block == nil {
+			idx = closeIdx
+			continue
+		}
+		fb, exists := byStart[block.start]
+		if !exists {
+			fb = &formatBlock{}
+			byStart[block.start] = fb
+			queries[fb] = block
+			blocks = append(blocks, fb)
+		}
+		fb.calls = append(fb.calls, &formatCall{
+			start:  tk.start,
+			end:    tokens[closeIdx].end,
+			pieces: pieces,
+			args:   callArgs[1:],
+		})
+		args[fb] = append(args[fb], callArgs[1:]...)
+		idx = closeIdx
+	}
+	for _, block := range blocks {
+		// the probe is empty if the calls have no arguments.
+		if len(args[block]) == 0 {
+			continue
+		}
+		block.probe = queries[block].typeProbe(query, tokens, args[block])
+	}
+	return blocks
+}
+
+// edits returns the edits that rewrite the FORMAT calls by fields, the types of the arguments selected by the probe query.
+// The calls whose arguments don't match the specifiers are kept for go-zetasqlite to report the error.
+func (b *formatBlock) edits(fields []*bigqueryv2.TableFieldSchema) []*edit {
+	var numArgs int
+	for _, call := range b.calls {
+		numArgs += len(call.args)
+	}
+	if len(fields) != numArgs {
+		return nil
+	}
+	var edits []*edit
+	for _, call := range b.calls {
+		callFields := fields[:len(call.args)]
+		fields = fields[len(call.args):]
+		expr, ok := call.expr(callFields)
+		if !ok {
+			continue
+		}
+		edits = append(edits, &edit{start: call.start, end: call.end, replacement: expr})
+	}
+	return edits
+}
+
+// expr returns the concatenation of the literal texts and the formatted arguments.
+// It is NULL if an argument of the specifier other than %t and %T is NULL.
+func (c *formatCall) expr(fields []*bigqueryv2.TableFieldSchema) (string, bool) {
+	var (
+		parts []string
+		next  int
+	)
+	nextArg := func() (int, bool) {
+		if next >= len(c.args) {
+			return 0, false
+		}
+		next++
+		return next - 1, true
+	}
+	for _, piece := range c.pieces {
+		if piece.spec == nil {
+			parts = append(parts, QuoteStringLiteral(piece.text))
+			continue
+		}
+		spec := piece.spec
+		var width, precision string
+		if spec.width == "*" {
+			idx, ok := nextArg()
+			if !ok || fields[idx].Type != "INTEGER" || fields[idx].Mode == "REPEATED" {
+				return "", false
+			}
+			width = c.args[idx]
+		} else if spec.width != "" {
+			width = spec.width
+		}
+		if spec.precision == "*" {
+			idx, ok := nextArg()
+			if !ok || fields[idx].Type != "INTEGER" || fields[idx].Mode == "REPEATED" {
+				return "", false
+			}
+			precision = c.args[idx]
+		} else if spec.hasPrecision {
+			precision = spec.precision
+			if precision == "" {
+				precision = "0"
+			}
+		}
+		argIdx := spec.position - 1
+		if spec.position == 0 {
+			idx, ok := nextArg()
+			if !ok {
+				return "", false
+			}
+			argIdx = idx
+		} else if argIdx >= len(c.args) {
+			return "", false
+		}
+		value, ok := formatValueExpr(spec, c.args[argIdx], fields[argIdx], precision)
+		if !ok {
+			return "", false
+		}
+		parts = append(parts, padFormattedExpr(spec, value, width))
+	}
+	if len(parts) == 0 {
+		return "''", true
+	}
+	if len(parts) == 1 {
+		return "(" + parts[0] + ")", true
+	}
+	return fmt.Sprintf("CONCAT(%s)", strings.Join(parts, ", ")), true
+}
+
+// formatValueExpr returns the expression of arg formatted by the specifier without the width.
+func formatValueExpr(spec *formatSpec, arg string, field *bigqueryv2.TableFieldSchema, precision string) (string, bool) {
+	repeated := field.Mode == "REPEATED"
+	switch spec.verb {
+	case 'd', 'i':
+		if repeated || field.Type != "INTEGER" {
+			return "", false
+		}
+		v := fmt.Sprintf("CAST(%s AS STRING)", arg)
+		if strings.Contains(spec.flags, "'") {
+			v = fmt.Sprintf("FORMAT(%s, %s)", QuoteStringLiteral("%'d"), arg)
+		}
+		return signedFormatExpr(spec, arg, v), true
+	case 'o', 'x', 'X':
+		if repeated || field.Type != "INTEGER" {
+			return "", false
+		}
+		v := fmt.Sprintf("FORMAT('%%%c', %s)", spec.verb, arg)
+		if strings.Contains(spec.flags, "#") {
+			prefix := map[byte]string{'o': "0", 'x': "0x", 'X': "0X"}[spec.verb]
+			v = fmt.Sprintf("CONCAT('%s', %s)", prefix, v)
+		}
+		return v, true
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		if repeated {
+			return "", false
+		}
+		switch field.Type {
+		case "INTEGER", "FLOAT", "NUMERIC", "BIGNUMERIC":
+		default:
+			return "", false
+		}
+		if precision == "" {
+			precision = "6"
+		}
+		verb := strings.ToLower(string(spec.verb))
+		value := fmt.Sprintf("CAST(%s AS FLOAT64)", arg)
+		var v string
+		if _, err := strconv.Atoi(precision); err == nil {
+			v = fmt.Sprintf("FORMAT('%%.%s%s', %s)", precision, verb, value)
+		} else {
+			v = fmt.Sprintf("FORMAT(CONCAT('%%.', CAST(%s AS STRING), '%s'), %s)", precision, verb, value)
+		}
+		if field.Type == "FLOAT" {
+			v = fmt.Sprintf(
+				"IF(IS_NAN(%[1]s), 'nan', IF(IS_INF(%[1]s), IF(%[1]s > 0, 'inf', '-inf'), %[2]s))",
+				arg, v,
+			)
+		}
+		if spec.verb >= 'A' && spec.verb <= 'Z' {
+			v = fmt.Sprintf("UPPER(%s)", v)
+		}
+		return signedFormatExpr(spec, arg, v), true
+	case 's', 'S':
+		v := arg
+		if repeated || field.Type != "STRING" {
+			v = printableExpr(arg, field, false, 0)
+		}
+		if precision != "" {
+			v = fmt.Sprintf("SUBSTR(%s, 1, %s)", v, precision)
+		}
+		return v, true
+	case 't', 'T':
+		v := printableExpr(arg, field, spec.verb == 'T', 0)
+		if precision != "" {
+			v = fmt.Sprintf("SUBSTR(%s, 1, %s)", v, precision)
+		}
+		return v, true
+	case 'p', 'P':
+		if repeated || field.Type != "JSON" {
+			return "", false
+		}
+		return fmt.Sprintf("FORMAT('%%%c', %s)", spec.verb, arg), true
+	}
+	return "", false
+}
+
+// signedFormatExpr adds the sign of the + flag or the space flag to the formatted number v.
+func signedFormatExpr(spec *formatSpec, arg, v string) string {
+	switch {
+	case strings.Contains(spec.flags, "+"):
+		return fmt.Sprintf("CONCAT(IF(%s >= 0, '+', ''), %s)", arg, v)
+	case strings.Contains(spec.flags, " "):
+		return fmt.Sprintf("CONCAT(IF(%s >= 0, ' ', ''), %s)", arg, v)
+	}
+	return v
+}
+
+// padFormattedExpr pads the formatted value v to the width. It is padded with the spaces on the right by the - flag,
+// with the zeros after the sign by the 0 flag of the numeric specifiers, and with the spaces on the left otherwise.
+func padFormattedExpr(spec *formatSpec, v, width string) string {
+	if width == "" {
+		return v
+	}
+	padding := fmt.Sprintf("GREATEST(%s - CHAR_LENGTH(%s), 0)", width, v)
+	switch {
+	case strings.Contains(spec.flags, "-"):
+		return fmt.Sprintf("CONCAT(%s, REPEAT(' ', %s))", v, padding)
+	case strings.Contains(spec.flags, "0") && strings.IndexByte("dioxXfFeEgG", spec.verb) >= 0:
+		return fmt.Sprintf(
+			"IF(REGEXP_CONTAINS(%[1]s, r'^[+ -]'), CONCAT(SUBSTR(%[1]s, 1, 1), REPEAT('0', %[2]s), SUBSTR(%[1]s, 2)), CONCAT(REPEAT('0', %[2]s), %[1]s))",
+			v, padding,
+		)
+	}
+	return fmt.Sprintf("CONCAT(REPEAT(' ', %s), %s)", padding, v)
+}
+
+// printableExpr returns the expression of %t ( or %T if literal is true ) of arg.
+// %t is the human-readable form and %T is the SQL literal of the value. NULL is formatted as NULL.
+// The elements of ARRAY and the fields of STRUCT are formatted in the same way, so depth names
+// the aliases of the nested arrays.
+func printableExpr(arg string, field *bigqueryv2.TableFieldSchema, literal bool, depth int) string {
+	verb := "t"
+	if literal {
+		verb = "T"
+	}
+	if field.Mode == "REPEATED" {
+		elem := *field
+		elem.Mode = ""
+		name := fmt.Sprintf("__format_elem%d", depth)
+		offset := fmt.Sprintf("__format_offset%d", depth)
+		return fmt.Sprintf(
+			"IF(%[1]s IS NULL, 'NULL', CONCAT('[', ARRAY_TO_STRING(ARRAY(SELECT %[2]s FROM UNNEST(%[1]s) AS %[3]s WITH OFFSET AS %[4]s ORDER BY %[4]s), ', '), ']'))",
+			arg, printableExpr(name, &elem, literal, depth+1), name, offset,
+		)
+	}
+	switch field.Type {
+	case "RECORD", "STRUCT":
+		parts := make([]string, 0, len(field.Fields))
+		for _, f := range field.Fields {
+			if f.Name == "" {
+				// the anonymous field can't be referenced by the name.
+				return fmt.Sprintf("IFNULL(FORMAT('%%%s', %s), 'NULL')", verb, arg)
+			}
+			parts = append(parts, printableExpr(fmt.Sprintf("(%s).`%s`", arg, f.Name), f, literal, depth))
+		}
+		if len(parts) == 0 {
+			return fmt.Sprintf("IF(%s IS NULL, 'NULL', '()')", arg)
+		}
+		return fmt.Sprintf("IF(%s IS NULL, 'NULL', CONCAT('(', %s, ')'))", arg, strings.Join(parts, ", ', ', "))
+	}
+	var v string
+	switch field.Type {
+	case "STRING", "BYTES":
+		v = fmt.Sprintf("FORMAT('%%%s', %s)", verb, arg)
+	case "INTEGER", "BOOLEAN":
+		v = fmt.Sprintf("CAST(%s AS STRING)", arg)
+	case "FLOAT":
+		special := []string{"'nan'", "'inf'", "'-inf'"}
+		if literal {
+			special = []string{`'CAST("nan" AS FLOAT64)'`, `'CAST("inf" AS FLOAT64)'`, `'CAST("-inf" AS FLOAT64)'`}
+		}
+		v = fmt.Sprintf(
+			"CASE WHEN IS_NAN(%[1]s) THEN %[2]s WHEN IS_INF(%[1]s) THEN IF(%[1]s > 0, %[3]s, %[4]s) "+
+				"WHEN REGEXP_CONTAINS(CAST(%[1]s AS STRING), r'^-?[0-9]+$') THEN CONCAT(CAST(%[1]s AS STRING), '.0') "+
+				"ELSE CAST(%[1]s AS STRING) END",
+			arg, special[0], special[1], special[2],
+		)
+	case "NUMERIC", "BIGNUMERIC", "DATE", "INTERVAL":
+		v = fmt.Sprintf("CAST(%s AS STRING)", arg)
+	case "DATETIME":
+		v = fmt.Sprintf("FORMAT_DATETIME('%%Y-%%m-%%d %%H:%%M:%%E*S', %s)", arg)
+	case "TIME":
+		v = fmt.Sprintf("FORMAT_TIME('%%H:%%M:%%E*S', %s)", arg)
+	case "TIMESTAMP":
+		v = fmt.Sprintf("FORMAT_TIMESTAMP('%%Y-%%m-%%d %%H:%%M:%%E*S+00', %s, 'UTC')", arg)
+	case "JSON":
+		v = fmt.Sprintf("TO_JSON_STRING(%s)", arg)
+	default:
+		v = fmt.Sprintf("FORMAT('%%%s', %s)", verb, arg)
+	}
+	if literal {
+		switch field.Type {
+		case "NUMERIC", "BIGNUMERIC", "DATE", "DATETIME", "TIME", "TIMESTAMP":
+			v = fmt.Sprintf(`CONCAT('%s "', %s, '"')`, field.Type, v)
+		case "INTERVAL":
+			v = fmt.Sprintf(`CONCAT('INTERVAL "', %s, '" YEAR TO SECOND')`, v)
+		case "JSON":
+			v = fmt.Sprintf(`CONCAT("JSON '", %s, "'")`, v)
+		}
+	}
+	return fmt.Sprintf("IFNULL(%s, 'NULL')", v)
+}
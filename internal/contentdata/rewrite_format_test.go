@@ -0,0 +1,73 @@
+package contentdata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFormatString(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		expected    []*formatPiece
+		expectedErr string
+	}{
+		{
+			name:     "specifier",
+			format:   "%d",
+			expected: []*formatPiece{{spec: &formatSpec{verb: 'd'}}},
+		},
+		{
+			name:   "positional arguments",
+			format: "%2$s %1$s",
+			expected: []*formatPiece{
+				{spec: &formatSpec{verb: 's', position: 2}},
+				{text: " "},
+				{spec: &formatSpec{verb: 's', position: 1}},
+			},
+		},
+		{
+			name:     "flags, width and precision",
+			format:   "%-10.3f",
+			expected: []*formatPiece{{spec: &formatSpec{flags: "-", width: "10", precision: "3", hasPrecision: true, verb: 'f'}}},
+		},
+		{
+			name:     "escaped percent",
+			format:   "100%%",
+			expected: []*formatPiece{{text: "100%"}},
+		},
+		{
+			name:        "trailing percent",
+			format:      "%",
+			expectedErr: `invalid format string "%": it ends with %`,
+		},
+		{
+			name:        "unsupported specifier",
+			format:      "%q",
+			expectedErr: `invalid format string "%q": unsupported specifier`,
+		},
+		{
+			name:        "width from the argument with the positional argument",
+			format:      "%1$*d",
+			expectedErr: `invalid format string "%1$*d": * can't be used with the positional argument`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseFormatString(test.format)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error %q but got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Fatalf("failed to parse format string %q", test.format)
+			}
+		})
+	}
+}
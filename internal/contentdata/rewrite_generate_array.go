@@ -0,0 +1,61 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// generateArrayIndex is the alias of the index of the elements generated by generateArrayEdits.
+const generateArrayIndex = "bqemulator_generate_array_index"
+
+// generateArrayEdits rewrites GENERATE_ARRAY(start, end [, step]) into the array of `start + step * i`
+// for i from 0 to FLOOR((end - start) / step), because go-zetasqlite adds step repeatedly,
+// returns the empty array for the step of 0 and for the negative step of start = end.
+//   - The FLOAT64 elements don't accumulate the rounding errors, and end is included if the step reaches it.
+//   - The array is empty if the step goes away from end, and NULL if any argument is NULL.
+//   - The element type is the supertype of the arguments like BigQuery.
+//
+// The range exceeding MaxArrayElements fails before the indexes are generated.
+func generateArrayEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") || !strings.EqualFold(tk.text, "GENERATE_ARRAY") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx >= len(tokens) || !tokens[closeIdx].isSymbol(")") {
+			continue
+		}
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) != 2 && len(args) != 3 {
+			continue
+		}
+		for i, arg := range args {
+			// rewrite the nested calls like GENERATE_ARRAY(1, ARRAY_LENGTH(GENERATE_ARRAY(...))).
+			argEdits, err := generateArrayEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = fmt.Sprintf("(%s)", applyEdits(arg, argEdits))
+		}
+		start, end, step := args[0], args[1], "1"
+		if len(args) == 3 {
+			step = args[2]
+		}
+		expr := fmt.Sprintf(
+			"CASE WHEN %[1]s IS NULL OR %[2]s IS NULL OR %[3]s IS NULL THEN NULL "+
+				"WHEN %[3]s = 0 THEN ERROR('Sequence step cannot be 0.') "+
+				"WHEN %[5]s THEN %[6]s "+
+				"ELSE ARRAY(SELECT IF(FALSE, %[2]s, %[1]s + %[3]s * %[4]s) "+
+				"FROM UNNEST(GENERATE_ARRAY(0, CAST(FLOOR((%[2]s - %[1]s) / %[3]s) AS INT64))) AS %[4]s ORDER BY %[4]s) END",
+			start, end, step, generateArrayIndex, generateArrayCondition([]string{start, end, step}), generateArrayError,
+		)
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
@@ -0,0 +1,30 @@
+package contentdata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateArray(t *testing.T) {
+	got, err := rewriteQuery("SELECT GENERATE_ARRAY(1, 10, 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"SELECT CASE WHEN (1) IS NULL OR (10) IS NULL OR (2) IS NULL THEN NULL",
+		"WHEN (2) = 0 THEN ERROR('Sequence step cannot be 0.')",
+		"ERROR('Cannot generate arrays with more than 16000000 elements')",
+		"(1) + (2) * bqemulator_generate_array_index",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Fatalf("expected %q in the rewritten query: %s", expected, got)
+		}
+	}
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "generate date array",
+			query:    "SELECT GENERATE_DATE_ARRAY(d1, d2) FROM t",
+			expected: "SELECT GENERATE_DATE_ARRAY(d1, d2) FROM t",
+		},
+	})
+}
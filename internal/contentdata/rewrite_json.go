@@ -0,0 +1,302 @@
+package contentdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var wideNumberModeArgPattern = regexp.MustCompile(`(?is)^wide_number_mode\s*=>\s*(.+)$`)
+
+// jsonEdits rewrites the JSON functions that go-zetasqlite doesn't provide.
+//   - JSON_OBJECT and JSON_ARRAY are built from TO_JSON_STRING of each value.
+//     The keys of JSON_OBJECT are sorted like BigQuery and the first value is kept for the duplicated key.
+//   - PARSE_JSON with a string literal checks wide_number_mode for the numbers that can't be stored without loss of precision.
+//   - JSON_SET, JSON_REMOVE and JSON_STRIP_NULLS call the JavaScript functions of the emulator with the JSONPaths parsed here.
+//   - TO_JSON with stringify_wide_numbers encodes the wide numbers as the strings by the JavaScript function of the emulator.
+func jsonEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		switch name {
+		case "JSON_OBJECT", "JSON_ARRAY", "PARSE_JSON", "JSON_SET", "JSON_REMOVE", "JSON_STRIP_NULLS", "TO_JSON":
+		default:
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		for i, arg := range args {
+			argEdits, err := jsonEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = applyEdits(arg, argEdits)
+		}
+		var (
+			expr string
+			err  error
+		)
+		switch name {
+		case "JSON_OBJECT":
+			expr, err = jsonObjectExpr(args)
+		case "JSON_ARRAY":
+			expr = jsonArrayExpr(args)
+		case "PARSE_JSON":
+			var ok bool
+			expr, ok, err = parseJSONExpr(args)
+			if err == nil && !ok {
+				continue
+			}
+		case "JSON_SET":
+			expr, err = jsonSetExpr(args)
+		case "JSON_REMOVE":
+			expr, err = jsonRemoveExpr(args)
+		case "JSON_STRIP_NULLS":
+			expr, err = jsonStripNullsExpr(args)
+		case "TO_JSON":
+			expr, err = toJSONExpr(args)
+		}
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+// jsonValueExpr returns the expression that encodes value as the JSON text. SQL NULL is encoded as JSON null.
+func jsonValueExpr(value string) string {
+	return fmt.Sprintf("IF((%[1]s) IS NULL, 'null', TO_JSON_STRING(%[1]s))", value)
+}
+
+func jsonArrayExpr(args []string) string {
+	if len(args) == 0 {
+		return "PARSE_JSON('[]')"
+	}
+	parts := []string{"'['"}
+	for i, arg := range args {
+		if i > 0 {
+			parts = append(parts, "','")
+		}
+		parts = append(parts, jsonValueExpr(arg))
+	}
+	parts = append(parts, "']'")
+	return fmt.Sprintf("PARSE_JSON(CONCAT(%s))", strings.Join(parts, ", "))
+}
+
+// jsonObjectExpr rewrites JSON_OBJECT(key, value[, ...]) and JSON_OBJECT(keys, values).
+// The keys given as string literals are resolved here, and the other keys are resolved by the subquery.
+func jsonObjectExpr(args []string) (string, error) {
+	if len(args) == 0 {
+		return "PARSE_JSON('{}')", nil
+	}
+	if len(args) == 2 && isArrayExpr(args[0]) {
+		entries := fmt.Sprintf(
+			"SELECT k, %s AS v, o FROM UNNEST(%s) AS k WITH OFFSET o",
+			jsonValueExpr(fmt.Sprintf("(%s)[SAFE_OFFSET(o)]", args[1])), args[0],
+		)
+		return fmt.Sprintf(
+			"IF(ARRAY_LENGTH(%s) != ARRAY_LENGTH(%s), ERROR('JSON_OBJECT: The number of keys and values must match'), %s)",
+			args[0], args[1], jsonObjectSubquery(entries),
+		), nil
+	}
+	if len(args)%2 != 0 {
+		return "", fmt.Errorf("JSON_OBJECT: The number of keys and values must match")
+	}
+	type entry struct {
+		key   string
+		value string
+	}
+	var (
+		entries      []*entry
+		seen         = map[string]bool{}
+		literalKeys  = true
+		structFields = make([]string, 0, len(args)/2)
+	)
+	for i := 0; i < len(args); i += 2 {
+		key, value := args[i], jsonValueExpr(args[i+1])
+		structFields = append(structFields, fmt.Sprintf("STRUCT(%s AS k, %s AS v)", key, value))
+		keyTokens := tokenize(key)
+		if len(keyTokens) == 1 && keyTokens[0].isKeyword("NULL") {
+			return "", fmt.Errorf("JSON_OBJECT: A key cannot be NULL")
+		}
+		var (
+			literal string
+			ok      bool
+		)
+		if len(keyTokens) == 1 {
+			literal, ok = stringLiteralValue(keyTokens[0])
+		}
+		if !ok {
+			literalKeys = false
+			continue
+		}
+		if seen[literal] {
+			continue
+		}
+		seen[literal] = true
+		entries = append(entries, &entry{key: literal, value: value})
+	}
+	if !literalKeys {
+		return jsonObjectSubquery(fmt.Sprintf(
+			"SELECT k, v, o FROM UNNEST([%s]) WITH OFFSET o", strings.Join(structFields, ", "),
+		)), nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	parts := make([]string, 0, len(entries)*2+1)
+	for i, e := range entries {
+		encodedKey, err := json.Marshal(e.key)
+		if err != nil {
+			return "", err
+		}
+		prefix := ","
+		if i == 0 {
+			prefix = "{"
+		}
+		parts = append(parts, QuoteStringLiteral(prefix+string(encodedKey)+":"), e.value)
+	}
+	parts = append(parts, "'}'")
+	return fmt.Sprintf("PARSE_JSON(CONCAT(%s))", strings.Join(parts, ", ")), nil
+}
+
+// jsonObjectSubquery returns the subquery that builds the JSON object from entries,
+// entries is the query that returns the key k, the encoded value v and the position o of each member.
+func jsonObjectSubquery(entries string) string {
+	return fmt.Sprintf(
+		"(SELECT PARSE_JSON(CONCAT('{', IFNULL(STRING_AGG(CONCAT(TO_JSON_STRING(k), ':', v), ',' ORDER BY k), ''), '}')) "+
+			"FROM (SELECT IF(k IS NULL, ERROR('JSON_OBJECT: A key cannot be NULL'), k) AS k, ARRAY_AGG(v ORDER BY o LIMIT 1)[OFFSET(0)] AS v "+
+			"FROM (%s) GROUP BY k))",
+		entries,
+	)
+}
+
+func isArrayExpr(expr string) bool {
+	tokens := tokenize(expr)
+	if len(tokens) == 0 {
+		return false
+	}
+	return tokens[0].isSymbol("[") || tokens[0].isKeyword("ARRAY")
+}
+
+// parseJSONExpr applies wide_number_mode to PARSE_JSON whose input is a string literal.
+// go-zetasqlite keeps the input text as is, so the numbers are checked ( or rounded ) here.
+func parseJSONExpr(args []string) (string, bool, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return "", false, nil
+	}
+	inputTokens := tokenize(args[0])
+	if len(inputTokens) != 1 {
+		return "", false, nil
+	}
+	input, ok := stringLiteralValue(inputTokens[0])
+	if !ok || !json.Valid([]byte(input)) {
+		return "", false, nil
+	}
+	mode := "exact"
+	if len(args) == 2 {
+		arg := args[1]
+		if matched := wideNumberModeArgPattern.FindStringSubmatch(arg); matched != nil {
+			arg = strings.TrimSpace(matched[1])
+		}
+		modeTokens := tokenize(arg)
+		if len(modeTokens) != 1 {
+			return "", false, nil
+		}
+		m, ok := stringLiteralValue(modeTokens[0])
+		if !ok {
+			return "", false, nil
+		}
+		mode = strings.ToLower(m)
+	}
+	if mode != "exact" && mode != "round" {
+		return "", false, fmt.Errorf("PARSE_JSON: invalid wide_number_mode %q. wide_number_mode must be 'exact' or 'round'", mode)
+	}
+	output, err := roundWideNumbers(input, mode == "round")
+	if err != nil {
+		return "", false, err
+	}
+	if output == input {
+		return "", false, nil
+	}
+	return fmt.Sprintf("PARSE_JSON(%s)", QuoteStringLiteral(output)), true, nil
+}
+
+// roundWideNumbers finds the numbers of the JSON text that can't be stored as INT64, UINT64 or FLOAT64 without loss of precision.
+// They are rounded to FLOAT64 if round is true, otherwise an error is returned.
+func roundWideNumbers(doc string, round bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(doc); {
+		c := doc[i]
+		if c == '"' {
+			end := i + 1
+			for end < len(doc) && doc[end] != '"' {
+				if doc[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			b.WriteString(doc[i : end+1])
+			i = end + 1
+			continue
+		}
+		if c != '-' && !isDigit(c) {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		end := i + 1
+		for end < len(doc) && strings.IndexByte("+-.eE0123456789", doc[end]) >= 0 {
+			end++
+		}
+		num := doc[i:end]
+		rounded, exact, err := roundJSONNumber(num)
+		if err != nil {
+			return "", err
+		}
+		if !exact && !round {
+			return "", fmt.Errorf(
+				"PARSE_JSON: the number %s cannot be converted to JSON without loss of precision. use wide_number_mode=>'round' to round it",
+				num,
+			)
+		}
+		b.WriteString(rounded)
+		i = end
+	}
+	return b.String(), nil
+}
+
+// roundJSONNumber returns the number rounded to FLOAT64 and whether the number is stored without loss of precision.
+func roundJSONNumber(num string) (string, bool, error) {
+	if _, err := strconv.ParseInt(num, 10, 64); err == nil {
+		return num, true, nil
+	}
+	if _, err := strconv.ParseUint(num, 10, 64); err == nil {
+		return num, true, nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return "", false, fmt.Errorf("PARSE_JSON: the number %s is out of range", num)
+	}
+	rounded := strconv.FormatFloat(f, 'g', -1, 64)
+	original, ok := new(big.Rat).SetString(num)
+	if !ok {
+		return "", false, fmt.Errorf("PARSE_JSON: invalid number %s", num)
+	}
+	converted, _ := new(big.Rat).SetString(rounded)
+	if original.Cmp(converted) == 0 {
+		return num, true, nil
+	}
+	return rounded, false, nil
+}
@@ -0,0 +1,426 @@
+package contentdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jsonScriptHelpers are the JavaScript functions shared by the JSON functions below.
+// parseJSON keeps each number as its original text, so the numbers that JavaScript can't represent exactly
+// ( e.g. INT64 values outside of ±2^53 ) are written back without loss of precision by stringifyJSON.
+// stringifyJSON sorts the keys of the objects like the JSON values of BigQuery.
+const jsonScriptHelpers = `var NUMBER_PREFIX = "\u0000bqemulator_number:";
+function parseJSON(text) {
+  return JSON.parse(text.replace(/"(?:[^"\\]|\\.)*"|-?\d+(?:\.\d+)?(?:[eE][+-]?\d+)?/g, function (m) {
+    return m.charAt(0) === '"' ? m : JSON.stringify(NUMBER_PREFIX + m);
+  }));
+}
+function isNumberText(v) {
+  return typeof v === "string" && v.indexOf(NUMBER_PREFIX) === 0;
+}
+function isObject(v) {
+  return v !== null && typeof v === "object" && !Array.isArray(v);
+}
+function hasMember(v, key) {
+  return isObject(v) && Object.prototype.hasOwnProperty.call(v, key);
+}
+function child(v, step) {
+  if (typeof step === "number") {
+    return Array.isArray(v) && step < v.length ? v[step] : undefined;
+  }
+  return hasMember(v, step) ? v[step] : undefined;
+}
+function stringifyJSON(v) {
+  if (isNumberText(v)) {
+    return v.slice(NUMBER_PREFIX.length);
+  }
+  if (Array.isArray(v)) {
+    return "[" + v.map(stringifyJSON).join(",") + "]";
+  }
+  if (isObject(v)) {
+    return "{" + Object.keys(v).sort().map(function (k) {
+      return JSON.stringify(k) + ":" + stringifyJSON(v[k]);
+    }).join(",") + "}";
+  }
+  return JSON.stringify(v);
+}
+`
+
+// jsonSetScriptFunction sets each value at the path of paths.
+// paths is the JSON array of the parsed JSONPaths, each path is the array of the member names and the array indexes.
+// With create_if_missing, the missing members and JSON null are replaced with the objects or the arrays the path needs,
+// and the arrays are padded with JSON null. The path that doesn't match the type of the value is ignored like BigQuery.
+const jsonSetScriptFunction = "bqemulator_json_set_script"
+
+const jsonSetScriptFunctionDefinition = "CREATE TEMP FUNCTION " + jsonSetScriptFunction +
+	"(doc STRING, paths STRING, new_values ARRAY<STRING>, create_if_missing BOOL) RETURNS JSON LANGUAGE js AS r\"\"\"\n" +
+	jsonScriptHelpers +
+	`var holder = [parseJSON(doc)];
+var steps = JSON.parse(paths);
+for (var p = 0; p < steps.length; p++) {
+  var path = steps[p];
+  var container = holder;
+  var key = 0;
+  var found = true;
+  for (var i = 0; i < path.length; i++) {
+    var step = path[i];
+    var node = container[key];
+    if (node === null || node === undefined) {
+      if (!create_if_missing) {
+        found = false;
+        break;
+      }
+      node = typeof step === "number" ? [] : {};
+      container[key] = node;
+    }
+    if (typeof step === "number") {
+      if (!Array.isArray(node) || (step >= node.length && !create_if_missing)) {
+        found = false;
+        break;
+      }
+      while (node.length < step) {
+        node.push(null);
+      }
+    } else if (!isObject(node) || (!hasMember(node, step) && !create_if_missing)) {
+      found = false;
+      break;
+    }
+    container = node;
+    key = step;
+  }
+  if (found) {
+    container[key] = parseJSON(new_values[p]);
+  }
+}
+return stringifyJSON(holder[0]);
+` + "\"\"\";\n"
+
+const jsonSetFunction = "bqemulator_json_set"
+
+// jsonSetFunctionDefinition binds the JSON value, so the value is evaluated once. The result is NULL if the value is NULL.
+const jsonSetFunctionDefinition = "CREATE TEMP FUNCTION " + jsonSetFunction +
+	"(value JSON, paths STRING, new_values ARRAY<STRING>, create_if_missing BOOL) AS (" +
+	"IF(value IS NULL, NULL, " + jsonSetScriptFunction + "(TO_JSON_STRING(value), paths, new_values, IFNULL(create_if_missing, FALSE))));\n"
+
+// jsonRemoveScriptFunction removes the member or the array element at each path of paths.
+// The removed array element shifts the following elements, and the path that doesn't exist is ignored.
+const jsonRemoveScriptFunction = "bqemulator_json_remove_script"
+
+const jsonRemoveScriptFunctionDefinition = "CREATE TEMP FUNCTION " + jsonRemoveScriptFunction +
+	"(doc STRING, paths STRING) RETURNS JSON LANGUAGE js AS r\"\"\"\n" +
+	jsonScriptHelpers +
+	`var root = parseJSON(doc);
+var steps = JSON.parse(paths);
+for (var p = 0; p < steps.length; p++) {
+  var path = steps[p];
+  var node = root;
+  for (var i = 0; i < path.length - 1 && node !== undefined; i++) {
+    node = child(node, path[i]);
+  }
+  var last = path[path.length - 1];
+  if (child(node, last) === undefined) {
+    continue;
+  }
+  if (typeof last === "number") {
+    node.splice(last, 1);
+  } else {
+    delete node[last];
+  }
+}
+return stringifyJSON(root);
+` + "\"\"\";\n"
+
+const jsonRemoveFunction = "bqemulator_json_remove"
+
+const jsonRemoveFunctionDefinition = "CREATE TEMP FUNCTION " + jsonRemoveFunction +
+	"(value JSON, paths STRING) AS (" +
+	"IF(value IS NULL, NULL, " + jsonRemoveScriptFunction + "(TO_JSON_STRING(value), paths)));\n"
+
+// jsonStripNullsScriptFunction removes the members whose value is JSON null from the value at path.
+// include_arrays also removes JSON null from the arrays, and remove_empty removes the objects ( and the arrays with include_arrays )
+// that become empty. The value that becomes empty itself is replaced with JSON null.
+const jsonStripNullsScriptFunction = "bqemulator_json_strip_nulls_script"
+
+const jsonStripNullsScriptFunctionDefinition = "CREATE TEMP FUNCTION " + jsonStripNullsScriptFunction +
+	"(doc STRING, path STRING, include_arrays BOOL, remove_empty BOOL) RETURNS JSON LANGUAGE js AS r\"\"\"\n" +
+	jsonScriptHelpers +
+	`function strip(v) {
+  if (Array.isArray(v)) {
+    var items = [];
+    for (var i = 0; i < v.length; i++) {
+      var item = strip(v[i]);
+      if (item === null && include_arrays) {
+        continue;
+      }
+      items.push(item);
+    }
+    return items.length === 0 && include_arrays && remove_empty ? null : items;
+  }
+  if (isObject(v)) {
+    var members = {};
+    var n = 0;
+    for (var k in v) {
+      if (!hasMember(v, k)) {
+        continue;
+      }
+      var member = strip(v[k]);
+      if (member !== null) {
+        members[k] = member;
+        n++;
+      }
+    }
+    return n === 0 && remove_empty ? null : members;
+  }
+  return v;
+}
+var root = parseJSON(doc);
+var steps = JSON.parse(path);
+if (steps.length === 0) {
+  return stringifyJSON(strip(root));
+}
+var node = root;
+for (var i = 0; i < steps.length - 1 && node !== undefined; i++) {
+  node = child(node, steps[i]);
+}
+var last = steps[steps.length - 1];
+if (child(node, last) !== undefined) {
+  node[last] = strip(node[last]);
+}
+return stringifyJSON(root);
+` + "\"\"\";\n"
+
+const jsonStripNullsFunction = "bqemulator_json_strip_nulls"
+
+const jsonStripNullsFunctionDefinition = "CREATE TEMP FUNCTION " + jsonStripNullsFunction +
+	"(value JSON, path STRING, include_arrays BOOL, remove_empty BOOL) AS (" +
+	"IF(value IS NULL, NULL, " + jsonStripNullsScriptFunction +
+	"(TO_JSON_STRING(value), path, IFNULL(include_arrays, TRUE), IFNULL(remove_empty, FALSE))));\n"
+
+// jsonStringifyWideNumbersScriptFunction encodes the numbers that can't be converted to FLOAT64 without loss of precision as the JSON strings,
+// it's stringify_wide_numbers of TO_JSON which go-zetasqlite ignores.
+const jsonStringifyWideNumbersScriptFunction = "bqemulator_json_stringify_wide_numbers_script"
+
+const jsonStringifyWideNumbersScriptFunctionDefinition = "CREATE TEMP FUNCTION " + jsonStringifyWideNumbersScriptFunction +
+	"(doc STRING) RETURNS JSON LANGUAGE js AS r\"\"\"\n" +
+	jsonScriptHelpers +
+	`function widen(v) {
+  if (isNumberText(v)) {
+    var text = v.slice(NUMBER_PREFIX.length);
+    if (/[eE]/.test(text)) {
+      return v;
+    }
+    var digits = text.charAt(0) === "-" ? text.slice(1) : text;
+    return String(Math.abs(Number(text))) === digits ? v : text;
+  }
+  if (Array.isArray(v)) {
+    return v.map(widen);
+  }
+  if (isObject(v)) {
+    var members = {};
+    for (var k in v) {
+      if (hasMember(v, k)) {
+        members[k] = widen(v[k]);
+      }
+    }
+    return members;
+  }
+  return v;
+}
+return stringifyJSON(widen(parseJSON(doc)));
+` + "\"\"\";\n"
+
+const toJSONFunction = "bqemulator_to_json"
+
+const toJSONFunctionDefinition = "CREATE TEMP FUNCTION " + toJSONFunction +
+	"(value JSON, stringify_wide_numbers BOOL) AS (" +
+	"IF(value IS NULL OR NOT IFNULL(stringify_wide_numbers, FALSE), value, " +
+	jsonStringifyWideNumbersScriptFunction + "(TO_JSON_STRING(value))));\n"
+
+// jsonPathStepPattern matches a step of JSONPath: .name, ."name", [index], ['name'] or ["name"].
+var jsonPathStepPattern = regexp.MustCompile(`^(?:\.([A-Za-z0-9_]+)|\."((?:[^"\\]|\\.)*)"|\[\s*(\d+)\s*\]|\[\s*'((?:[^'\\]|\\.)*)'\s*\]|\[\s*"((?:[^"\\]|\\.)*)"\s*\])`)
+
+// splitNamedArgs separates the trailing named arguments ( name => value ) from the positional arguments.
+// The names are lower cased.
+func splitNamedArgs(fn string, args []string, names ...string) ([]string, map[string]string, error) {
+	named := map[string]string{}
+	for len(args) > 0 {
+		matched := namedArgPattern.FindStringSubmatch(args[len(args)-1])
+		if matched == nil {
+			break
+		}
+		name := strings.ToLower(matched[1])
+		valid := false
+		for _, n := range names {
+			if n == name {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, nil, fmt.Errorf("%s: unknown named argument %s", fn, matched[1])
+		}
+		named[name] = strings.TrimSpace(matched[2])
+		args = args[:len(args)-1]
+	}
+	return args, named, nil
+}
+
+// parseJSONPathArg parses the JSONPath given as the string literal into the member names and the array indexes.
+// The JSON functions rewritten by the emulator resolve the path in JavaScript, so the path must be known when the query is rewritten.
+func parseJSONPathArg(fn, arg string) ([]interface{}, error) {
+	tokens := tokenize(arg)
+	var (
+		path string
+		ok   bool
+	)
+	if len(tokens) == 1 {
+		path, ok = stringLiteralValue(tokens[0])
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: JSONPath must be a string literal in the emulator", fn)
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("%s: invalid JSONPath %q. JSONPath must start with '$'", fn, path)
+	}
+	steps := []interface{}{}
+	for rest := path[1:]; rest != ""; {
+		matched := jsonPathStepPattern.FindStringSubmatch(rest)
+		if matched == nil {
+			return nil, fmt.Errorf("%s: invalid JSONPath %q", fn, path)
+		}
+		switch {
+		case matched[1] != "":
+			steps = append(steps, matched[1])
+		case matched[3] != "":
+			index, err := strconv.ParseInt(matched[3], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid JSONPath %q: %w", fn, path, err)
+			}
+			steps = append(steps, index)
+		default:
+			name := matched[2] + matched[4] + matched[5]
+			steps = append(steps, strings.NewReplacer(`\"`, `"`, `\'`, `'`, `\\`, `\`).Replace(name))
+		}
+		rest = rest[len(matched[0]):]
+	}
+	return steps, nil
+}
+
+// jsonPathsLiteral returns the string literal of the JSON array of paths that the JavaScript functions take.
+func jsonPathsLiteral(paths interface{}) (string, error) {
+	encoded, err := json.Marshal(paths)
+	if err != nil {
+		return "", err
+	}
+	return QuoteStringLiteral(string(encoded)), nil
+}
+
+// jsonSetExpr rewrites JSON_SET(json, path, value[, path, value ...][, create_if_missing => bool]).
+func jsonSetExpr(args []string) (string, error) {
+	args, named, err := splitNamedArgs("JSON_SET", args, "create_if_missing")
+	if err != nil {
+		return "", err
+	}
+	if len(args) < 3 || len(args)%2 == 0 {
+		return "", fmt.Errorf("JSON_SET: The number of JSONPaths and values must match")
+	}
+	var (
+		paths  [][]interface{}
+		values []string
+	)
+	for i := 1; i < len(args); i += 2 {
+		path, err := parseJSONPathArg("JSON_SET", args[i])
+		if err != nil {
+			return "", err
+		}
+		paths = append(paths, path)
+		values = append(values, jsonValueExpr(args[i+1]))
+	}
+	pathsLiteral, err := jsonPathsLiteral(paths)
+	if err != nil {
+		return "", err
+	}
+	createIfMissing, exists := named["create_if_missing"]
+	if !exists {
+		createIfMissing = "TRUE"
+	}
+	return fmt.Sprintf(
+		"%s(%s, %s, [%s], %s)", jsonSetFunction, args[0], pathsLiteral, strings.Join(values, ", "), createIfMissing,
+	), nil
+}
+
+// jsonRemoveExpr rewrites JSON_REMOVE(json, path[, path ...]).
+func jsonRemoveExpr(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("JSON_REMOVE: at least one JSONPath is required")
+	}
+	var paths [][]interface{}
+	for _, arg := range args[1:] {
+		path, err := parseJSONPathArg("JSON_REMOVE", arg)
+		if err != nil {
+			return "", err
+		}
+		if len(path) == 0 {
+			return "", fmt.Errorf("JSON_REMOVE: The JSONPath cannot be '$'")
+		}
+		paths = append(paths, path)
+	}
+	pathsLiteral, err := jsonPathsLiteral(paths)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s(%s, %s)", jsonRemoveFunction, args[0], pathsLiteral), nil
+}
+
+// jsonStripNullsExpr rewrites JSON_STRIP_NULLS(json[, path][, include_arrays => bool][, remove_empty => bool]).
+func jsonStripNullsExpr(args []string) (string, error) {
+	args, named, err := splitNamedArgs("JSON_STRIP_NULLS", args, "include_arrays", "remove_empty")
+	if err != nil {
+		return "", err
+	}
+	if len(args) != 1 && len(args) != 2 {
+		return "", fmt.Errorf("JSON_STRIP_NULLS expects the JSON value and the optional JSONPath but got %d arguments", len(args))
+	}
+	path := []interface{}{}
+	if len(args) == 2 {
+		path, err = parseJSONPathArg("JSON_STRIP_NULLS", args[1])
+		if err != nil {
+			return "", err
+		}
+	}
+	pathLiteral, err := jsonPathsLiteral(path)
+	if err != nil {
+		return "", err
+	}
+	includeArrays, exists := named["include_arrays"]
+	if !exists {
+		includeArrays = "TRUE"
+	}
+	removeEmpty, exists := named["remove_empty"]
+	if !exists {
+		removeEmpty = "FALSE"
+	}
+	return fmt.Sprintf("%s(%s, %s, %s, %s)", jsonStripNullsFunction, args[0], pathLiteral, includeArrays, removeEmpty), nil
+}
+
+// toJSONExpr applies stringify_wide_numbers of TO_JSON.
+func toJSONExpr(args []string) (string, error) {
+	args, named, err := splitNamedArgs("TO_JSON", args, "stringify_wide_numbers")
+	if err != nil {
+		return "", err
+	}
+	stringify, exists := named["stringify_wide_numbers"]
+	if len(args) == 2 {
+		stringify, exists = args[1], true
+		args = args[:1]
+	}
+	if len(args) != 1 {
+		return "", fmt.Errorf("TO_JSON expects the value and the optional stringify_wide_numbers argument but got %d arguments", len(args))
+	}
+	if tokens := tokenize(stringify); !exists || len(tokens) == 1 && tokens[0].isKeyword("FALSE") {
+		return fmt.Sprintf("TO_JSON(%s)", args[0]), nil
+	}
+	return fmt.Sprintf("%s(TO_JSON(%s), %s)", toJSONFunction, args[0], stringify), nil
+}
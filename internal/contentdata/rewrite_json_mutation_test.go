@@ -0,0 +1,38 @@
+package contentdata
+
+import "testing"
+
+func TestJSONMutationFunctions(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "json set",
+			query:    "SELECT JSON_SET(j, '$.a', 1) FROM t",
+			expected: `SELECT bqemulator_json_set(j, '[["a"]]', [IF((1) IS NULL, 'null', TO_JSON_STRING(1))], TRUE) FROM t`,
+		},
+		{
+			name:     "json remove",
+			query:    "SELECT JSON_REMOVE(j, '$.a', '$.b') FROM t",
+			expected: `SELECT bqemulator_json_remove(j, '[["a"],["b"]]') FROM t`,
+		},
+		{
+			name:     "json strip nulls",
+			query:    "SELECT JSON_STRIP_NULLS(j) FROM t",
+			expected: "SELECT bqemulator_json_strip_nulls(j, '[]', TRUE, FALSE) FROM t",
+		},
+		{
+			name:     "to json stringifying wide numbers",
+			query:    "SELECT TO_JSON(x, stringify_wide_numbers => TRUE) FROM t",
+			expected: "SELECT bqemulator_to_json(TO_JSON(x), TRUE) FROM t",
+		},
+		{
+			name:        "invalid json path",
+			query:       "SELECT JSON_SET(j, 'a', 1) FROM t",
+			expectedErr: `JSON_SET: invalid JSONPath "a". JSONPath must start with '$'`,
+		},
+		{
+			name:        "json remove without paths",
+			query:       "SELECT JSON_REMOVE(j) FROM t",
+			expectedErr: "JSON_REMOVE: at least one JSONPath is required",
+		},
+	})
+}
@@ -0,0 +1,25 @@
+package contentdata
+
+import "testing"
+
+func TestJSONConstructors(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:  "json object",
+			query: "SELECT JSON_OBJECT('a', 1, 'b', 'x')",
+			expected: `SELECT PARSE_JSON(CONCAT('{"a":', IF((1) IS NULL, 'null', TO_JSON_STRING(1)), ',"b":', ` +
+				`IF(('x') IS NULL, 'null', TO_JSON_STRING('x')), '}'))`,
+		},
+		{
+			name:     "empty json object",
+			query:    "SELECT JSON_OBJECT()",
+			expected: "SELECT PARSE_JSON('{}')",
+		},
+		{
+			name:  "json array",
+			query: "SELECT JSON_ARRAY(1, 'a', NULL)",
+			expected: "SELECT PARSE_JSON(CONCAT('[', IF((1) IS NULL, 'null', TO_JSON_STRING(1)), ',', " +
+				"IF(('a') IS NULL, 'null', TO_JSON_STRING('a')), ',', IF((NULL) IS NULL, 'null', TO_JSON_STRING(NULL)), ']'))",
+		},
+	})
+}
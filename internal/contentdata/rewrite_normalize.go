@@ -0,0 +1,143 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+var normalizationForms = map[string]bool{
+	"NFC":  true,
+	"NFD":  true,
+	"NFKC": true,
+	"NFKD": true,
+}
+
+// caseFoldExceptions are the characters whose Unicode case folding differs from LOWER
+// after the canonical ( or compatibility ) decomposition.
+// The other characters of the full case folding like U+0130 or U+1F88 are folded by LOWER
+// and the mappings of their decomposed characters.
+var caseFoldExceptions = []struct {
+	from string
+	to   string
+}{
+	// the lowercase letters that fold to the other lowercase letters.
+	{from: "\u00b5", to: "\u03bc"}, // µ -> μ
+	{from: "\u017f", to: "s"},      // ſ -> s
+	{from: "\u0345", to: "\u03b9"}, // U+0345 ( combining ypogegrammeni ) -> ι
+	{from: "\u03c2", to: "\u03c3"}, // ς -> σ
+	{from: "\u03d0", to: "\u03b2"}, // ϐ -> β
+	{from: "\u03d1", to: "\u03b8"}, // ϑ -> θ
+	{from: "\u03d5", to: "\u03c6"}, // ϕ -> φ
+	{from: "\u03d6", to: "\u03c0"}, // ϖ -> π
+	{from: "\u03f0", to: "\u03ba"}, // ϰ -> κ
+	{from: "\u03f1", to: "\u03c1"}, // ϱ -> ρ
+	{from: "\u03f5", to: "\u03b5"}, // ϵ -> ε
+	{from: "\u1c80", to: "\u0432"}, // ᲀ -> в
+	{from: "\u1c81", to: "\u0434"}, // ᲁ -> д
+	{from: "\u1c82", to: "\u043e"}, // ᲂ -> о
+	{from: "\u1c83", to: "\u0441"}, // ᲃ -> с
+	{from: "\u1c84", to: "\u0442"}, // ᲄ -> т
+	{from: "\u1c85", to: "\u0442"}, // ᲅ -> т
+	{from: "\u1c86", to: "\u044a"}, // ᲆ -> ъ
+	{from: "\u1c87", to: "\u0463"}, // ᲇ -> ѣ
+	{from: "\u1c88", to: "\ua64b"}, // ᲈ -> ꙋ
+	{from: "\u1fbe", to: "\u03b9"}, // U+1FBE ( prosgegrammeni ) -> ι
+	// the characters that fold to multiple characters.
+	{from: "\u00df", to: "ss"},           // ß -> ss
+	{from: "\u0149", to: "\u02bcn"},      // ŉ -> ʼn
+	{from: "\u0587", to: "\u0565\u0582"}, // և -> եւ
+	{from: "\u1e9a", to: "a\u02be"},      // ẚ -> aʾ
+	{from: "\ufb00", to: "ff"},           // ﬀ -> ff
+	{from: "\ufb01", to: "fi"},           // ﬁ -> fi
+	{from: "\ufb02", to: "fl"},           // ﬂ -> fl
+	{from: "\ufb03", to: "ffi"},          // ﬃ -> ffi
+	{from: "\ufb04", to: "ffl"},          // ﬄ -> ffl
+	{from: "\ufb05", to: "st"},           // ﬅ -> st
+	{from: "\ufb06", to: "st"},           // ﬆ -> st
+	{from: "\ufb13", to: "\u0574\u0576"}, // ﬓ -> մն
+	{from: "\ufb14", to: "\u0574\u0565"}, // ﬔ -> մե
+	{from: "\ufb15", to: "\u0574\u056b"}, // ﬕ -> մի
+	{from: "\ufb16", to: "\u057e\u0576"}, // ﬖ -> վն
+	{from: "\ufb17", to: "\u0574\u056d"}, // ﬗ -> մխ
+}
+
+// normalizeAndCasefoldFunction is the temporary JavaScript function applying the Unicode full case folding.
+// The value is decomposed by decomposition, lowercased, folded by the mappings of caseFoldExceptions
+// and normalized to form.
+const normalizeAndCasefoldFunction = "bqemulator_normalize_and_casefold"
+
+var normalizeAndCasefoldFunctionDefinition = "CREATE TEMP FUNCTION " + normalizeAndCasefoldFunction +
+	"(value STRING, decomposition STRING, form STRING) RETURNS STRING LANGUAGE js AS r\"\"\"\n" +
+	"var foldings = " + caseFoldMappings() + ";\n" +
+	`if (value === null) {
+  return null;
+}
+var lower = value.normalize(decomposition).toLowerCase();
+var folded = "";
+for (var i = 0; i < lower.length; i++) {
+  var c = lower.charAt(i);
+  folded += Object.prototype.hasOwnProperty.call(foldings, c) ? foldings[c] : c;
+}
+return folded.normalize(form);
+` + "\"\"\";\n"
+
+// caseFoldMappings returns the JavaScript object literal of caseFoldExceptions.
+// The characters are escaped, so the definition doesn't depend on the encoding of the query.
+func caseFoldMappings() string {
+	escape := func(s string) string {
+		var b strings.Builder
+		for _, c := range s {
+			fmt.Fprintf(&b, "\\u%04x", c)
+		}
+		return b.String()
+	}
+	entries := make([]string, 0, len(caseFoldExceptions))
+	for _, e := range caseFoldExceptions {
+		entries = append(entries, fmt.Sprintf(`"%s": "%s"`, escape(e.from), escape(e.to)))
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// normalizeEdits rewrites NORMALIZE_AND_CASEFOLD to apply the Unicode full case folding.
+// go-zetasqlite lowercases the value instead of folding it, so 'Straße' and 'STRASSE' don't match.
+// The value is decomposed, folded and normalized to the form as the caseless matching of Unicode does.
+// The compatibility forms ( NFKC and NFKD ) decompose the value by NFKD before folding,
+// so the characters like U+3392 ( MHz ) are folded after the decomposition.
+func normalizeEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") || !tk.isKeyword("NORMALIZE_AND_CASEFOLD") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) != 1 && len(args) != 2 {
+			continue
+		}
+		argEdits, err := normalizeEdits(args[0], tokenize(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		value := applyEdits(args[0], argEdits)
+		form := "NFC"
+		if len(args) == 2 {
+			form = strings.ToUpper(args[1])
+		}
+		if !normalizationForms[form] {
+			// the invalid form is reported by go-zetasqlite.
+			continue
+		}
+		decomposition := "NFD"
+		if strings.HasPrefix(form, "NFK") {
+			decomposition = "NFKD"
+		}
+		expr := fmt.Sprintf("%s(%s, '%s', '%s')", normalizeAndCasefoldFunction, value, decomposition, form)
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
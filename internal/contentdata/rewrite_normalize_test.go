@@ -0,0 +1,23 @@
+package contentdata
+
+import "testing"
+
+func TestNormalizeAndCasefold(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "default normalization mode",
+			query:    "SELECT NORMALIZE_AND_CASEFOLD(s) FROM t",
+			expected: "SELECT bqemulator_normalize_and_casefold(s, 'NFD', 'NFC') FROM t",
+		},
+		{
+			name:     "nfkc",
+			query:    "SELECT NORMALIZE_AND_CASEFOLD(s, NFKC) FROM t",
+			expected: "SELECT bqemulator_normalize_and_casefold(s, 'NFKD', 'NFKC') FROM t",
+		},
+		{
+			name:     "nfd",
+			query:    "SELECT NORMALIZE_AND_CASEFOLD(s, NFD) FROM t",
+			expected: "SELECT bqemulator_normalize_and_casefold(s, 'NFD', 'NFD') FROM t",
+		},
+	})
+}
@@ -0,0 +1,105 @@
+package contentdata
+
+import "strings"
+
+// nullsOrderEdits rewrites NULLS FIRST and NULLS LAST of ORDER BY in the window specifications
+// and the aggregate functions, which go-zetasqlite ignores. It supports them only in ORDER BY of the queries.
+// The modifiers that are the default of BigQuery ( ASC NULLS FIRST and DESC NULLS LAST ) are removed,
+// and the others are replaced with the preceding key ordering the NULL values:
+// `x ASC NULLS LAST` is `(x) IS NULL, x ASC` and `x DESC NULLS FIRST` is `(x) IS NOT NULL, x DESC`.
+// COLLATE of the key is kept in the key and excluded from the NULL test.
+func nullsOrderEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		if !tokens[idx].isKeyword("ORDER") || !tokens[idx+1].isKeyword("BY") {
+			continue
+		}
+		depth := tokens[idx].depth
+		if depth == 0 || isQueryOrderBy(tokens, idx) {
+			continue
+		}
+		end := idx + 2
+		for end < len(tokens) && tokens[end].depth >= depth {
+			if tokens[end].depth == depth &&
+				(tokens[end].isKeyword("ROWS") || tokens[end].isKeyword("RANGE") || tokens[end].isKeyword("LIMIT")) {
+				break
+			}
+			end++
+		}
+		itemStart := idx + 2
+		for i := itemStart; i <= end; i++ {
+			if i < end && !(tokens[i].depth == depth && tokens[i].isSymbol(",")) {
+				continue
+			}
+			if i > itemStart {
+				e, err := nullsOrderItemEdit(query, tokens[itemStart:i], depth)
+				if err != nil {
+					return nil, err
+				}
+				if e != nil {
+					edits = append(edits, e)
+				}
+			}
+			itemStart = i + 1
+		}
+		idx = end - 1
+	}
+	return edits, nil
+}
+
+// isQueryOrderBy reports whether ORDER BY at idx is the clause of the query in the parentheses like `(SELECT ... ORDER BY x)`
+// rather than the clause of the window specification or the aggregate function.
+func isQueryOrderBy(tokens []*token, idx int) bool {
+	open := idx - 1
+	for open >= 0 && !(tokens[open].isSymbol("(") && tokens[open].depth == tokens[idx].depth-1) {
+		open--
+	}
+	if open < 0 || open+1 >= len(tokens) {
+		return true
+	}
+	first := tokens[open+1]
+	return first.isKeyword("SELECT") || first.isKeyword("WITH") || first.isSymbol("(")
+}
+
+// nullsOrderItemEdit returns the edit of the ORDER BY item, or nil if the item has nothing to rewrite.
+func nullsOrderItemEdit(query string, item []*token, depth int) (*edit, error) {
+	text := tokenText(query, item)
+	// rewrite ORDER BY of the nested windows and aggregate functions in the key.
+	nestedEdits, err := nullsOrderEdits(text, tokenize(text))
+	if err != nil {
+		return nil, err
+	}
+	n := len(item)
+	if n < 3 || !item[n-2].isKeyword("NULLS") || (!item[n-1].isKeyword("FIRST") && !item[n-1].isKeyword("LAST")) {
+		if len(nestedEdits) == 0 {
+			return nil, nil
+		}
+		return &edit{start: item[0].start, end: item[n-1].end, replacement: applyEdits(text, nestedEdits)}, nil
+	}
+	keyEnd := n - 2
+	descending := item[keyEnd-1].isKeyword("DESC")
+	exprEnd := keyEnd
+	if descending || item[keyEnd-1].isKeyword("ASC") {
+		exprEnd--
+	}
+	for i := 0; i < exprEnd; i++ {
+		if item[i].depth == depth && item[i].isKeyword("COLLATE") {
+			exprEnd = i
+			break
+		}
+	}
+	key := applyEdits(text, nestedEdits)
+	key = strings.TrimSpace(key[:len(key)-(item[n-1].end-item[keyEnd].start)])
+	expr := tokenText(query, item[:exprEnd])
+	nullsFirst := item[n-1].isKeyword("FIRST")
+	var replacement string
+	switch {
+	case nullsFirst != descending:
+		replacement = key
+	case descending:
+		replacement = "(" + expr + ") IS NOT NULL, " + key
+	default:
+		replacement = "(" + expr + ") IS NULL, " + key
+	}
+	return &edit{start: item[0].start, end: item[n-1].end, replacement: replacement}, nil
+}
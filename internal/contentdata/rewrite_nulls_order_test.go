@@ -0,0 +1,23 @@
+package contentdata
+
+import "testing"
+
+func TestNullsOrder(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "nulls last",
+			query:    "SELECT ROW_NUMBER() OVER (ORDER BY a NULLS LAST) FROM t",
+			expected: "SELECT ROW_NUMBER() OVER (ORDER BY (a) IS NULL, a) FROM t",
+		},
+		{
+			name:     "descending nulls first",
+			query:    "SELECT SUM(x) OVER (ORDER BY a DESC NULLS FIRST) FROM t",
+			expected: "SELECT SUM(x) OVER (ORDER BY (a) IS NOT NULL, a DESC) FROM t",
+		},
+		{
+			name:     "string agg",
+			query:    "SELECT STRING_AGG(s ORDER BY s DESC NULLS FIRST) FROM t",
+			expected: "SELECT STRING_AGG(s ORDER BY (s) IS NOT NULL, s DESC) FROM t",
+		},
+	})
+}
@@ -0,0 +1,529 @@
+package contentdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// formatNumberFunction and parseNumberFunction are the temporary JavaScript functions converting the number
+// to and from the string by the numeric format model.
+// They take the number as the decimal string, so NUMERIC and BIGNUMERIC are formatted without the rounding errors,
+// and return NULL if the value doesn't match the format.
+const (
+	formatNumberFunction = "bqemulator_format_number"
+	parseNumberFunction  = "bqemulator_parse_number"
+)
+
+// numberFormatFunctionHelpers are the functions shared by formatNumberFunction and parseNumberFunction.
+// parseDecimal returns the sign, the significant digits and the position of the decimal point of the decimal string.
+const numberFormatFunctionHelpers = `var repeat = function(c, n) {
+  var s = "";
+  while (s.length < n) {
+    s += c;
+  }
+  return s;
+};
+var parseDecimal = function(s) {
+  var m = /^\s*([+-])?(?:(inf|infinity)|(nan)|(\d*)(?:\.(\d*))?(?:[eE]([+-]?\d+))?)\s*$/i.exec(s);
+  if (m === null) {
+    return null;
+  }
+  if (m[2] || m[3]) {
+    return {negative: m[1] === "-", special: m[3] ? "nan" : "inf", digits: "", point: 0};
+  }
+  var intPart = m[4] || "", fracPart = m[5] || "";
+  if (intPart.length + fracPart.length === 0) {
+    return null;
+  }
+  var digits = intPart + fracPart;
+  var point = intPart.length + (m[6] ? parseInt(m[6], 10) : 0);
+  var lead = 0;
+  while (lead < digits.length && digits.charAt(lead) === "0") {
+    lead++;
+  }
+  digits = digits.substring(lead).replace(/0+$/, "");
+  if (digits === "") {
+    return {negative: false, special: "", digits: "", point: 0};
+  }
+  return {negative: m[1] === "-", special: "", digits: digits, point: point - lead};
+};
+var plainDecimal = function(n) {
+  if (n.special !== "") {
+    return (n.negative ? "-" : "") + n.special;
+  }
+  if (n.digits === "") {
+    return "0";
+  }
+  var digits = n.digits;
+  while (digits.length < n.point) {
+    digits += "0";
+  }
+  var integer = n.point > 0 ? digits.substring(0, n.point) : "0";
+  var fraction = n.point >= 0 ? digits.substring(n.point) : repeat("0", -n.point) + digits;
+  return (n.negative ? "-" : "") + integer + (fraction === "" ? "" : "." + fraction);
+};
+`
+
+// formatNumberFunctionDefinition rounds the number half away from zero to the fractional digits of the format.
+// The leading zeros of 9 are spaces and the leading sign and currency are placed just before the first digit.
+// The format without the sign element reserves a space for the sign of the positive number.
+// The output is filled with # if the integer part has more digits than the format.
+const formatNumberFunctionDefinition = "CREATE TEMP FUNCTION " + formatNumberFunction +
+	"(value STRING, spec STRING) RETURNS STRING LANGUAGE js AS r\"\"\"\n" +
+	`if (value === null || value === undefined) {
+  return null;
+}
+` + numberFormatFunctionHelpers + `var f = JSON.parse(spec);
+var increment = function(s) {
+  var out = "", carry = true;
+  for (var i = s.length - 1; i >= 0; i--) {
+    var d = s.charCodeAt(i) - 48 + (carry ? 1 : 0);
+    carry = d === 10;
+    out = String(carry ? 0 : d) + out;
+  }
+  return carry ? "1" + out : out;
+};
+var roundDecimal = function(n, scale) {
+  var keep = n.point + scale;
+  var kept = n.digits.substring(0, Math.max(keep, 0));
+  while (kept.length < keep) {
+    kept += "0";
+  }
+  if (keep >= 0 && n.digits.length > keep && n.digits.charAt(keep) >= "5") {
+    kept = increment(kept);
+  }
+  while (kept.length < scale) {
+    kept = "0" + kept;
+  }
+  return {integer: kept.substring(0, kept.length - scale).replace(/^0+/, ""), fraction: kept.substring(kept.length - scale)};
+};
+var decimalToHex = function(s) {
+  var hex = "";
+  while (s !== "") {
+    var rem = 0, q = "";
+    for (var i = 0; i < s.length; i++) {
+      var cur = rem * 10 + s.charCodeAt(i) - 48;
+      var d = Math.floor(cur / 16);
+      rem = cur % 16;
+      if (q !== "" || d !== 0) {
+        q += String(d);
+      }
+    }
+    hex = "0123456789ABCDEF".charAt(rem) + hex;
+    s = q;
+  }
+  return hex;
+};
+var signText = function(negative, leading) {
+  switch (f.sign) {
+  case "S":
+    return negative ? "-" : "+";
+  case "PR":
+    return negative ? (leading ? "<" : ">") : " ";
+  }
+  return negative ? "-" : " ";
+};
+var affix = function(elements, negative, leading) {
+  var s = "";
+  for (var i = 0; i < elements.length; i++) {
+    s += elements[i] === "sign" ? signText(negative, leading) : f.currency;
+  }
+  return s;
+};
+var formatFixed = function(n) {
+  var r, exponent = "";
+  if (f.exponent) {
+    var e = n.digits === "" ? 0 : n.point - 1;
+    r = roundDecimal({digits: n.digits, point: n.digits === "" ? 0 : 1}, f.fraction.length);
+    if (r.integer.length > 1) {
+      r = {integer: "1", fraction: repeat("0", f.fraction.length)};
+      e++;
+    }
+    r.integer = r.integer || "0";
+    exponent = "E" + (e < 0 ? "-" : "+") + (Math.abs(e) < 10 ? "0" : "") + Math.abs(e);
+  } else {
+    r = roundDecimal({digits: n.digits, point: n.point + f.scale}, f.fraction.length);
+  }
+  var integer = f.hex ? decimalToHex(r.integer) : r.integer;
+  var fraction = r.fraction;
+  var zero = integer === "" || integer === "0";
+  var negative = n.negative && !(zero && /^0*$/.test(fraction));
+  var overflow = integer.length > f.integer.replace(/,/g, "").length;
+  if (integer === "" && fraction === "") {
+    integer = "0";
+  }
+  var firstZero = f.integer.indexOf("0");
+  if (firstZero < 0) {
+    firstZero = f.integer.length;
+  }
+  var chars = [];
+  for (var i = f.integer.length - 1, j = integer.length - 1; i >= 0; i--) {
+    var p = f.integer.charAt(i);
+    if (p === ",") {
+      chars.unshift(p);
+    } else if (j >= 0) {
+      var c = integer.charAt(j--);
+      chars.unshift(p === "x" ? c.toLowerCase() : c);
+    } else {
+      chars.unshift(i >= firstZero ? "0" : " ");
+    }
+  }
+  var started = false;
+  for (var k = 0; k < chars.length; k++) {
+    if (chars[k] === ",") {
+      chars[k] = started ? "," : " ";
+    } else if (chars[k] !== " ") {
+      started = true;
+    }
+  }
+  var body = chars.join("");
+  var lead = body.length - body.replace(/^ +/, "").length;
+  if (f.fm) {
+    for (var l = fraction.length; l > 0 && fraction.charAt(l - 1) === "0" && f.fraction.charAt(l - 1) === "9"; l--) {
+      fraction = fraction.substring(0, l - 1);
+    }
+  }
+  var out = body.substring(0, lead) + affix(f.prefix, negative, true) + body.substring(lead) +
+    (f.point ? "." : "") + fraction + exponent + affix(f.suffix, negative, false);
+  if (overflow) {
+    out = repeat("#", out.length);
+  } else if (f.blank && zero) {
+    out = repeat(" ", out.length);
+  }
+  return f.fm ? out.replace(/^ +| +$/g, "") : out;
+};
+var n = parseDecimal(value);
+if (n === null) {
+  return null;
+}
+if (f.text !== "") {
+  if (f.text === "TME" && n.special === "") {
+    var digits = n.digits || "0";
+    var e = n.digits === "" ? 0 : n.point - 1;
+    return (n.negative ? "-" : "") + digits.charAt(0) + (digits.length > 1 ? "." + digits.substring(1) : "") +
+      "E" + (e < 0 ? "-" : "+") + (Math.abs(e) < 10 ? "0" : "") + Math.abs(e);
+  }
+  return plainDecimal(n);
+}
+if (n.special !== "") {
+  var text = plainDecimal(n);
+  return f.fm ? text : repeat(" ", formatFixed(parseDecimal("0")).length - text.length) + text;
+}
+if (f.hex && n.point < n.digits.length) {
+  return null;
+}
+return formatFixed(n);
+` + "\"\"\";\n"
+
+// parseNumberFunctionDefinition returns the decimal string of the formatted number.
+// The sign, the currency and the group separators are accepted anywhere the format can place them.
+const parseNumberFunctionDefinition = "CREATE TEMP FUNCTION " + parseNumberFunction +
+	"(value STRING, spec STRING) RETURNS STRING LANGUAGE js AS r\"\"\"\n" +
+	`if (value === null || value === undefined) {
+  return null;
+}
+` + numberFormatFunctionHelpers + `var f = JSON.parse(spec);
+var hexToDecimal = function(hex) {
+  var digits = [0];
+  for (var i = 0; i < hex.length; i++) {
+    var carry = parseInt(hex.charAt(i), 16);
+    for (var j = 0; j < digits.length; j++) {
+      var x = digits[j] * 16 + carry;
+      digits[j] = x % 10;
+      carry = Math.floor(x / 10);
+    }
+    while (carry > 0) {
+      digits.push(carry % 10);
+      carry = Math.floor(carry / 10);
+    }
+  }
+  return digits.reverse().join("");
+};
+var s = value.replace(/^\s+|\s+$/g, "");
+var negative = false;
+if (/^<.*>$/.test(s)) {
+  negative = true;
+  s = s.substring(1, s.length - 1);
+}
+if (f.currency !== "") {
+  s = s.split(f.currency).join("");
+}
+var m = /^([+-]?)(.*?)([+-]?)$/.exec(s.replace(/\s+/g, ""));
+if (m[1] !== "" && m[3] !== "" || negative && m[1] + m[3] !== "") {
+  return null;
+}
+negative = negative || m[1] === "-" || m[3] === "-";
+var body = m[2].replace(/,/g, "");
+if (body === "" || /^[+-]/.test(body)) {
+  return null;
+}
+if (f.hex && !/^[0-9A-Fa-f]+$/.test(body)) {
+  return null;
+}
+var n = parseDecimal(f.hex ? hexToDecimal(body) : body);
+if (n === null) {
+  return null;
+}
+n.point -= f.scale;
+n.negative = negative && (n.digits !== "" || n.special !== "");
+return plainDecimal(n);
+` + "\"\"\";\n"
+
+// numberFormat is the numeric format model of `CAST(x AS STRING FORMAT format)`.
+type numberFormat struct {
+	FM    bool `json:"fm"`
+	Blank bool `json:"blank"`
+	// Integer has the digits 0, 9, X or x and the group separator `,` before the decimal point.
+	// The digits after V are the integer digits too.
+	Integer string `json:"integer"`
+	// Fraction has the digits 0 or 9 after the decimal point.
+	Fraction string `json:"fraction"`
+	Point    bool   `json:"point"`
+	Exponent bool   `json:"exponent"`
+	// Scale is the number of the digits after V, which multiplies the number by 10^Scale.
+	Scale int  `json:"scale"`
+	Hex   bool `json:"hex"`
+	// Sign is S, MI, PR or empty for the default sign.
+	Sign     string `json:"sign"`
+	Currency string `json:"currency"`
+	// Prefix and Suffix are the elements "sign" and "currency" before and after the digits in order.
+	Prefix []string `json:"prefix"`
+	Suffix []string `json:"suffix"`
+	// Text is TM or TME for the text minimal format.
+	Text string `json:"text"`
+}
+
+// parseNumberFormat parses the numeric format model like `$999,999.00`, `S9.99EEEE` or `FMXXXX`.
+// It returns the error if format is not the numeric format, e.g. the format of DATE or BYTES.
+func parseNumberFormat(format string) (*numberFormat, error) {
+	f := &numberFormat{Prefix: []string{}, Suffix: []string{}}
+	var (
+		digits   bool
+		afterV   bool
+		finished bool
+		hasNine  bool
+	)
+	upper := strings.ToUpper(format)
+	for i := 0; i < len(upper); {
+		if finished {
+			return nil, fmt.Errorf("invalid numeric format %q: MI and PR must be at the end", format)
+		}
+		element := upper[i : i+1]
+		for _, e := range []string{"FM", "TM9", "TME", "TM", "EEEE", "MI", "PR"} {
+			if strings.HasPrefix(upper[i:], e) {
+				element = e
+				break
+			}
+		}
+		switch element {
+		case "0", "9":
+			if f.Exponent {
+				return nil, fmt.Errorf("invalid numeric format %q: digits after EEEE", format)
+			}
+			hasNine = hasNine || element == "9"
+			if f.Point {
+				f.Fraction += element
+			} else {
+				f.Integer += element
+			}
+			if afterV {
+				f.Scale++
+			}
+			digits = true
+		case "X":
+			f.Hex = true
+			f.Integer += format[i : i+1]
+			digits = true
+		case ".", "D":
+			if f.Point || afterV {
+				return nil, fmt.Errorf("invalid numeric format %q: unexpected decimal point", format)
+			}
+			f.Point = true
+		case ",", "G":
+			if f.Point || !digits || afterV {
+				return nil, fmt.Errorf("invalid numeric format %q: unexpected group separator", format)
+			}
+			f.Integer += ","
+		case "V":
+			if f.Point || afterV {
+				return nil, fmt.Errorf("invalid numeric format %q: unexpected V", format)
+			}
+			afterV = true
+		case "EEEE":
+			if !digits {
+				return nil, fmt.Errorf("invalid numeric format %q: EEEE must follow the digits", format)
+			}
+			f.Exponent = true
+		case "S", "MI", "PR":
+			if f.Sign != "" {
+				return nil, fmt.Errorf("invalid numeric format %q: multiple sign elements", format)
+			}
+			f.Sign = element
+			switch {
+			case element != "S":
+				if !digits {
+					return nil, fmt.Errorf("invalid numeric format %q: %s must be at the end", format, element)
+				}
+				f.Suffix = append(f.Suffix, "sign")
+				finished = true
+			case digits:
+				f.Suffix = append(f.Suffix, "sign")
+			default:
+				f.Prefix = append(f.Prefix, "sign")
+			}
+		case "$", "C", "L":
+			if f.Currency != "" {
+				return nil, fmt.Errorf("invalid numeric format %q: multiple currency elements", format)
+			}
+			f.Currency = "$"
+			if element == "C" {
+				f.Currency = "USD"
+			}
+			if digits {
+				f.Suffix = append(f.Suffix, "currency")
+			} else {
+				f.Prefix = append(f.Prefix, "currency")
+			}
+		case "B":
+			f.Blank = true
+		case "FM":
+			f.FM = true
+		case "TM", "TM9", "TME":
+			f.Text = strings.TrimSuffix(element, "9")
+		default:
+			return nil, fmt.Errorf("invalid numeric format %q: unexpected %q", format, format[i:i+1])
+		}
+		i += len(element)
+	}
+	switch {
+	case f.Text != "":
+		if digits || f.Point || f.Sign != "" || f.Currency != "" || f.Blank {
+			return nil, fmt.Errorf("invalid numeric format %q: %s can't be used with other elements", format, f.Text)
+		}
+		return f, nil
+	case !digits:
+		return nil, fmt.Errorf("invalid numeric format %q: no digits", format)
+	case f.Hex && (hasNine || f.Point || f.Exponent || afterV || strings.Contains(f.Integer, ",")):
+		return nil, fmt.Errorf("invalid numeric format %q: X can be used only with 0, FM and the sign", format)
+	case f.Exponent && (strings.Contains(f.Integer, ",") || afterV):
+		return nil, fmt.Errorf("invalid numeric format %q: EEEE can't be used with the group separator and V", format)
+	}
+	if f.Sign == "" || f.Sign == "PR" {
+		// the default sign and `<` of PR float just before the digits.
+		f.Prefix = append([]string{"sign"}, f.Prefix...)
+	}
+	return f, nil
+}
+
+// numberFormatTypes are the numeric types that the formatted string is cast into.
+var numberFormatTypes = map[string]string{
+	"INT64":      "INT64",
+	"INT":        "INT64",
+	"INTEGER":    "INT64",
+	"BIGINT":     "INT64",
+	"SMALLINT":   "INT64",
+	"TINYINT":    "INT64",
+	"BYTEINT":    "INT64",
+	"NUMERIC":    "NUMERIC",
+	"DECIMAL":    "NUMERIC",
+	"BIGNUMERIC": "BIGNUMERIC",
+	"BIGDECIMAL": "BIGNUMERIC",
+	"FLOAT64":    "FLOAT64",
+}
+
+// numberFormatEdits rewrites `CAST(x AS STRING FORMAT format)` of the numeric format model into formatNumberFunction,
+// and `CAST(x AS <numeric type> FORMAT format)` into parseNumberFunction,
+// because go-zetasqlite ignores the FORMAT clause of CAST.
+// The format must be the string literal, and the casts with the format of DATE, TIME or BYTES are kept as is.
+// SAFE_CAST returns NULL instead of the error for the value that doesn't match the format.
+func numberFormatEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") || (!tk.isKeyword("CAST") && !tk.isKeyword("SAFE_CAST")) {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx >= len(tokens) {
+			continue
+		}
+		expr, err := castNumberFormatExpr(query, tokens, strings.ToUpper(tk.text) == "SAFE_CAST", idx+1, closeIdx)
+		if err != nil {
+			return nil, err
+		}
+		if expr == "" {
+			continue
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+// castNumberFormatExpr returns the expression of the cast with the numeric format,
+// or empty string if the cast doesn't have the numeric format.
+func castNumberFormatExpr(query string, tokens []*token, safe bool, openIdx, closeIdx int) (string, error) {
+	depth := tokens[openIdx].depth + 1
+	asIdx := -1
+	for idx := openIdx + 1; idx < closeIdx; idx++ {
+		if tokens[idx].depth == depth && tokens[idx].isKeyword("AS") {
+			asIdx = idx
+			break
+		}
+	}
+	if asIdx < 0 || asIdx+4 != closeIdx || !tokens[asIdx+2].isKeyword("FORMAT") || tokens[asIdx+1].kind != tokenWord {
+		return "", nil
+	}
+	format, ok := stringLiteralValue(tokens[asIdx+3])
+	if !ok || tokens[asIdx+3].isBytesLiteral() {
+		return "", nil
+	}
+	typ := strings.ToUpper(tokens[asIdx+1].text)
+	numericType, isNumeric := numberFormatTypes[typ]
+	if typ != "STRING" && !isNumeric {
+		return "", nil
+	}
+	f, err := parseNumberFormat(format)
+	if err != nil {
+		if isNumeric {
+			return "", err
+		}
+		// the format of DATE, TIME or BYTES.
+		return "", nil
+	}
+	spec, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	value := query[tokens[openIdx].end:tokens[asIdx].start]
+	// rewrite the nested casts like CAST(CAST(s AS NUMERIC FORMAT '9.99') AS STRING FORMAT '0.0').
+	valueEdits, err := numberFormatEdits(value, tokenize(value))
+	if err != nil {
+		return "", err
+	}
+	value = fmt.Sprintf("(%s)", applyEdits(value, valueEdits))
+	if typ == "STRING" {
+		converted := fmt.Sprintf("%s(CAST(%s AS STRING), %s)", formatNumberFunction, value, QuoteStringLiteral(string(spec)))
+		invalid := fmt.Sprintf(
+			"ERROR(CONCAT(%s, CAST(%s AS STRING)))", QuoteStringLiteral(fmt.Sprintf("Invalid value for the numeric format '%s': ", format)), value,
+		)
+		if safe {
+			invalid = "CAST(NULL AS STRING)"
+		}
+		return fmt.Sprintf("IF(%[1]s IS NOT NULL AND %[2]s IS NULL, %[3]s, %[2]s)", value, converted, invalid), nil
+	}
+	parsed := fmt.Sprintf("%s(CAST(%s AS STRING), %s)", parseNumberFunction, value, QuoteStringLiteral(string(spec)))
+	converted := fmt.Sprintf("CAST(%s AS %s)", parsed, numericType)
+	if numericType == "INT64" {
+		converted = fmt.Sprintf("CAST(ROUND(CAST(%s AS BIGNUMERIC)) AS INT64)", parsed)
+	}
+	invalid := fmt.Sprintf(`ERROR(CONCAT("Invalid number: '", CAST(%s AS STRING), "'"))`, value)
+	if safe {
+		invalid = fmt.Sprintf("CAST(NULL AS %s)", numericType)
+	}
+	return fmt.Sprintf("IF(%[1]s IS NOT NULL AND %[2]s IS NULL, %[3]s, %[4]s)", value, parsed, invalid, converted), nil
+}
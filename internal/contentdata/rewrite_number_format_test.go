@@ -0,0 +1,35 @@
+package contentdata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNumberFormat(t *testing.T) {
+	got, err := rewriteQuery("SELECT CAST(x AS STRING FORMAT '999.99') FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`bqemulator_format_number(CAST((x ) AS STRING), '{"fm":false,"blank":false,"integer":"999","fraction":"99","point":true,`,
+		`ERROR(CONCAT('Invalid value for the numeric format \'999.99\': ', CAST((x ) AS STRING)))`,
+	} {
+		if !strings.Contains(got, expected) {
+			t.Fatalf("expected %q in the rewritten query: %s", expected, got)
+		}
+	}
+	got, err = rewriteQuery("SELECT CAST(s AS NUMERIC FORMAT '999.99') FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, `CAST(bqemulator_parse_number(CAST((s ) AS STRING), '{"fm":false,`) {
+		t.Fatalf("unexpected rewritten query: %s", got)
+	}
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "date format",
+			query:    "SELECT CAST(d AS STRING FORMAT 'YYYY-MM-DD') FROM t",
+			expected: "SELECT CAST(d AS STRING FORMAT 'YYYY-MM-DD') FROM t",
+		},
+	})
+}
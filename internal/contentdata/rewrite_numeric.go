@@ -0,0 +1,602 @@
+package contentdata
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
+const (
+	numericScale    = 9
+	bigNumericScale = 38
+)
+
+var (
+	// decimalStringPattern is the string accepted by PARSE_NUMERIC and PARSE_BIGNUMERIC.
+	// The sign can be the first or the last character and the whitespaces around the sign are ignored.
+	decimalStringPattern = regexp.MustCompile(`^\s*([+-]?)\s*(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?\s*([+-]?)\s*$`)
+
+	// maxNumeric is the maximum NUMERIC value 99999999999999999999999999999.999999999.
+	maxNumeric, _ = new(big.Rat).SetString("99999999999999999999999999999.999999999")
+	// maxBigNumeric is the maximum BIGNUMERIC value.
+	maxBigNumeric, _ = new(big.Rat).SetString("578960446186580977117854925043439539266.34992332820282019728792003956564819967")
+	// minBigNumeric is the minimum BIGNUMERIC value.
+	minBigNumeric, _ = new(big.Rat).SetString("-578960446186580977117854925043439539266.34992332820282019728792003956564819968")
+)
+
+// parseNumericEdits rewrites PARSE_NUMERIC and PARSE_BIGNUMERIC.
+// go-zetasqlite parses the string as big.Rat, so it rejects the whitespaces and the trailing sign that BigQuery accepts
+// and accepts the fraction like 1/3. The string literal is parsed here into the NUMERIC ( or BIGNUMERIC ) literal
+// rounded half away from zero to the scale, and the other arguments are normalized by the string functions.
+func parseNumericEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		if name != "PARSE_NUMERIC" && name != "PARSE_BIGNUMERIC" {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) != 1 {
+			continue
+		}
+		typ := strings.TrimPrefix(name, "PARSE_")
+		var expr string
+		if closeIdx == idx+3 && tokens[idx+2].kind == tokenString && !tokens[idx+2].isBytesLiteral() {
+			s, ok := stringLiteralValue(tokens[idx+2])
+			if !ok {
+				continue
+			}
+			v, err := parseDecimal(s, typ)
+			if err != nil {
+				return nil, err
+			}
+			expr = fmt.Sprintf("%s '%s'", typ, v)
+		} else {
+			expr = fmt.Sprintf(
+				`%s(CONCAT(IF(REGEXP_CONTAINS(%[2]s, r'^\s*-|-\s*$'), '-', ''), REGEXP_REPLACE(%[2]s, r'^\s*[+-]?\s*|\s*[+-]?\s*$', '')))`,
+				name, args[0],
+			)
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+// parseDecimal parses s by the rules of PARSE_NUMERIC ( or PARSE_BIGNUMERIC ) and returns the decimal string
+// rounded half away from zero to the scale of typ.
+func parseDecimal(s, typ string) (string, error) {
+	matches := decimalStringPattern.FindStringSubmatch(s)
+	if matches == nil || (matches[1] != "" && matches[4] != "") {
+		return "", fmt.Errorf("Invalid %s value: %q", typ, s)
+	}
+	r, ok := new(big.Rat).SetString(matches[2] + matches[3])
+	if !ok {
+		return "", fmt.Errorf("Invalid %s value: %q", typ, s)
+	}
+	if matches[1] == "-" || matches[4] == "-" {
+		r.Neg(r)
+	}
+	scale := numericScale
+	if typ == "BIGNUMERIC" {
+		scale = bigNumericScale
+	}
+	v := formatDecimal(r, scale)
+	if err := CheckNumericRange(typ, v); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+// formatDecimal formats r with at most scale fractional digits, rounded half away from zero.
+func formatDecimal(r *big.Rat, scale int) string {
+	// big.Rat.FloatString rounds the last digit half away from zero.
+	v := r.FloatString(scale)
+	if strings.Contains(v, ".") {
+		v = strings.TrimRight(strings.TrimRight(v, "0"), ".")
+	}
+	if v == "-0" {
+		return "0"
+	}
+	return v
+}
+
+// CheckNumericRange returns the overflow error if the decimal string v is out of the range of typ.
+// go-zetasqlite computes NUMERIC and BIGNUMERIC values as big.Rat without the range, so the results are checked
+// by the emulator.
+func CheckNumericRange(typ, v string) error {
+	if typ != "NUMERIC" && typ != "BIGNUMERIC" {
+		return nil
+	}
+	r, ok := new(big.Rat).SetString(v)
+	if !ok {
+		return nil
+	}
+	switch typ {
+	case "NUMERIC":
+		if new(big.Rat).Abs(r).Cmp(maxNumeric) > 0 {
+			return fmt.Errorf("numeric overflow: %s", v)
+		}
+	case "BIGNUMERIC":
+		if r.Cmp(maxBigNumeric) > 0 || r.Cmp(minBigNumeric) < 0 {
+			return fmt.Errorf("BIGNUMERIC overflow: %s", v)
+		}
+	}
+	return nil
+}
+
+// numericAverageBlock is the query block that has AVG calls.
+// go-zetasqlite computes AVG in FLOAT64, so AVG of NUMERIC and BIGNUMERIC values is rewritten into
+// the exact division of SUM by COUNT rounded to the scale of the type.
+type numericAverageBlock struct {
+	calls []*averageCall
+	// probe is the query that selects the arguments of the calls from the FROM clause of the block
+	// to check their types.
+	probe *typeProbe
+}
+
+// averageCall is `AVG([DISTINCT] arg) [OVER window]`.
+type averageCall struct {
+	start    int
+	end      int
+	arg      string
+	distinct bool
+	over     string
+}
+
+// numericAverageBlocks returns the query blocks of query that have AVG calls.
+// The types of the arguments are unknown until the probe runs, so they may not be NUMERIC or BIGNUMERIC.
+func numericAverageBlocks(query string) []*numericAverageBlock {
+	tokens := tokenize(query)
+	var (
+		blocks  []*numericAverageBlock
+		byStart = map[int]*numericAverageBlock{}
+		args    = map[*numericAverageBlock][]string{}
+		queries = map[*numericAverageBlock]*queryBlock{}
+	)
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if !tk.isKeyword("AVG") || !tokens[idx+1].isSymbol("(") || (idx > 0 && tokens[idx-1].isSymbol(".")) {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx >= len(tokens) || closeIdx == idx+2 {
+			continue
+		}
+		call := &averageCall{start: tk.start, end: tokens[closeIdx].end}
+		argStart := idx + 2
+		if tokens[argStart].isKeyword("DISTINCT") {
+			call.distinct = true
+			argStart++
+		}
+		if argStart >= closeIdx {
+			continue
+		}
+		call.arg = tokenText(query, tokens[argStart:closeIdx])
+		if closeIdx+2 < len(tokens) && tokens[closeIdx+1].isKeyword("OVER") {
+			overEnd := closeIdx + 2
+			if tokens[overEnd].isSymbol("(") {
+				overEnd = skipParen(tokens, overEnd)
+			}
+			if overEnd >= len(tokens) {
+				continue
+			}
+			call.over = tokenText(query, tokens[closeIdx+1:overEnd+1])
+			call.end = tokens[overEnd].end
+			closeIdx = overEnd
+		}
+		block := findQueryBlock(tokens, idx)
+		if block == nil {
+			continue
+		}
+		avgBlock, exists := byStart[block.start]
+		if !exists {
+			avgBlock = &numericAverageBlock{}
+			byStart[block.start] = avgBlock
+			queries[avgBlock] = block
+			blocks = append(blocks, avgBlock)
+		}
+		avgBlock.calls = append(avgBlock.calls, call)
+		args[avgBlock] = append(args[avgBlock], call.arg)
+		// the nested AVG calls in the argument are kept as is.
+		idx = closeIdx
+	}
+	for _, block := range blocks {
+		block.probe = queries[block].typeProbe(query, tokens, args[block])
+	}
+	return blocks
+}
+
+// edits returns the edits that rewrite the AVG calls whose arguments are NUMERIC or BIGNUMERIC
+// by fields, the types of the arguments selected by the probe query.
+func (b *numericAverageBlock) edits(fields []*bigqueryv2.TableFieldSchema) []*edit {
+	if len(fields) != len(b.calls) {
+		return nil
+	}
+	var edits []*edit
+	for idx, call := range b.calls {
+		field := fields[idx]
+		if field.Mode == "REPEATED" || (field.Type != "NUMERIC" && field.Type != "BIGNUMERIC") {
+			continue
+		}
+		var distinct, over string
+		if call.distinct {
+			distinct = "DISTINCT "
+		}
+		if call.over != "" {
+			over = " " + call.over
+		}
+		edits = append(edits, &edit{
+			start: call.start,
+			end:   call.end,
+			replacement: numericScaleExpr(
+				fmt.Sprintf("SUM(%[1]s%[2]s)%[3]s / NULLIF(COUNT(%[1]s%[2]s)%[3]s, 0)", distinct, call.arg, over),
+				field.Type,
+			),
+		})
+	}
+	return edits
+}
+
+const (
+	roundHalfAwayFromZero = "ROUND_HALF_AWAY_FROM_ZERO"
+	roundHalfEven         = "ROUND_HALF_EVEN"
+)
+
+// numericRoundingBlock is the query block that has ROUND, TRUNC, CEIL and FLOOR calls.
+// go-zetasqlite rounds the values in FLOAT64, ignores the digits of TRUNC and doesn't support the rounding mode of ROUND,
+// so the calls of NUMERIC and BIGNUMERIC values are rewritten into the exact computation on the decimal strings
+// and TRUNC of FLOAT64 values with the digits is rewritten by POW.
+type numericRoundingBlock struct {
+	calls []*roundingCall
+	// probe is the query that selects the arguments of the calls from the FROM clause of the block
+	// to check their types.
+	probe *typeProbe
+}
+
+// roundingCall is `ROUND(arg[, digits[, mode]])`, `TRUNC(arg[, digits])`, `CEIL(arg)` or `FLOOR(arg)`.
+type roundingCall struct {
+	start int
+	end   int
+	name  string
+	arg   string
+	// digits is the expression of the digits. It is empty if the digits are omitted.
+	digits string
+	mode   string
+}
+
+// numericRoundingBlocks returns the query blocks of query that have the rounding calls.
+// The types of the arguments are unknown until the probe runs, so they may not be NUMERIC or BIGNUMERIC.
+func numericRoundingBlocks(query string) []*numericRoundingBlock {
+	tokens := tokenize(query)
+	var (
+		blocks  []*numericRoundingBlock
+		byStart = map[int]*numericRoundingBlock{}
+		args    = map[*numericRoundingBlock][]string{}
+		queries = map[*numericRoundingBlock]*queryBlock{}
+	)
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") || (idx > 0 && tokens[idx-1].isSymbol(".")) {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		if name == "CEILING" {
+			name = "CEIL"
+		}
+		if name != "ROUND" && name != "TRUNC" && name != "CEIL" && name != "FLOOR" {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx >= len(tokens) {
+			continue
+		}
+		callArgs := functionArgs(query, tokens, idx+1, closeIdx)
+		maxArgs := map[string]int{"ROUND": 3, "TRUNC": 2, "CEIL": 1, "FLOOR": 1}[name]
+		if len(callArgs) == 0 || len(callArgs) > maxArgs {
+			continue
+		}
+		call := &roundingCall{start: tk.start, end: tokens[closeIdx].end, name: name, arg: callArgs[0]}
+		if len(callArgs) > 1 {
+			call.digits = callArgs[1]
+		}
+		if len(callArgs) > 2 {
+			modeTokens := tokenize(callArgs[2])
+			if len(modeTokens) != 1 {
+				continue
+			}
+			mode, ok := stringLiteralValue(modeTokens[0])
+			if !ok {
+				continue
+			}
+			call.mode = strings.ToUpper(mode)
+		}
+		block := findQueryBlock(tokens, idx)
+		if block == nil {
+			continue
+		}
+		roundingBlock, exists := byStart[block.start]
+		if !exists {
+			roundingBlock = &numericRoundingBlock{}
+			byStart[block.start] = roundingBlock
+			queries[roundingBlock] = block
+			blocks = append(blocks, roundingBlock)
+		}
+		roundingBlock.calls = append(roundingBlock.calls, call)
+		args[roundingBlock] = append(args[roundingBlock], call.arg)
+		// the nested rounding calls in the arguments are kept as is.
+		idx = closeIdx
+	}
+	for _, block := range blocks {
+		block.probe = queries[block].typeProbe(query, tokens, args[block])
+	}
+	return blocks
+}
+
+// edits returns the edits that rewrite the rounding calls by fields, the types of the arguments selected by the probe query.
+func (b *numericRoundingBlock) edits(fields []*bigqueryv2.TableFieldSchema) []*edit {
+	if len(fields) != len(b.calls) {
+		return nil
+	}
+	var edits []*edit
+	for idx, call := range b.calls {
+		field := fields[idx]
+		if field.Mode == "REPEATED" {
+			continue
+		}
+		var expr string
+		switch field.Type {
+		case "NUMERIC", "BIGNUMERIC":
+			v, ok := call.numericExpr(field.Type)
+			if !ok {
+				continue
+			}
+			expr = v
+		case "INTEGER", "FLOAT":
+			if call.name != "TRUNC" || call.digits == "" {
+				continue
+			}
+			expr = fmt.Sprintf("(TRUNC(%[1]s * POW(10, %[2]s)) / POW(10, %[2]s))", call.arg, call.digits)
+		default:
+			continue
+		}
+		edits = append(edits, &edit{start: call.start, end: call.end, replacement: expr})
+	}
+	return edits
+}
+
+// numericExpr returns the exact expression of the rounding call of the NUMERIC ( or BIGNUMERIC ) value.
+// The value is truncated to the digits on the decimal string, and the remainder decides whether the unit
+// of the digits is added to the truncated value.
+// It reports false if the digits are not the integer literal or the rounding mode is unknown.
+func (c *roundingCall) numericExpr(typ string) (string, bool) {
+	digits := 0
+	if c.digits != "" {
+		n, err := strconv.Atoi(strings.Join(strings.Fields(c.digits), ""))
+		if err != nil {
+			return "", false
+		}
+		digits = n
+	}
+	switch c.mode {
+	case "", roundHalfAwayFromZero, roundHalfEven:
+	default:
+		return "", false
+	}
+	scale, maxIntegerDigits := numericScale, 29
+	if typ == "BIGNUMERIC" {
+		scale, maxIntegerDigits = bigNumericScale, 38
+	}
+	if digits >= scale {
+		return fmt.Sprintf("CAST(%s AS %s)", c.arg, typ), true
+	}
+	if -digits >= maxIntegerDigits {
+		// the unit of the digits is out of the range of typ.
+		return "", false
+	}
+	value := fmt.Sprintf("CAST(%s AS STRING)", c.arg)
+	var truncated string
+	if digits > 0 {
+		truncated = fmt.Sprintf(`REGEXP_EXTRACT(%s, r'^-?\d+(?:\.\d{0,%d})?')`, value, digits)
+	} else {
+		truncated = fmt.Sprintf(`REGEXP_EXTRACT(%s, r'^-?\d+')`, value)
+		if digits < 0 {
+			truncated = fmt.Sprintf(`REGEXP_REPLACE(%s, r'\d{1,%d}$', '%s')`, truncated, -digits, strings.Repeat("0", -digits))
+		}
+	}
+	truncated = fmt.Sprintf("CAST(%s AS %s)", truncated, typ)
+	// unit is 10 to the power of -digits.
+	unit := fmt.Sprintf("%s '1%s'", typ, strings.Repeat("0", -digits))
+	if digits > 0 {
+		unit = fmt.Sprintf("%s '0.%s1'", typ, strings.Repeat("0", digits-1))
+	}
+	remainder := fmt.Sprintf("(%s - %s)", c.arg, truncated)
+	switch c.name {
+	case "TRUNC":
+		return truncated, true
+	case "FLOOR":
+		return fmt.Sprintf("IF(%[1]s < 0, %[2]s - %[3]s, %[2]s)", remainder, truncated, unit), true
+	case "CEIL":
+		return fmt.Sprintf("IF(%[1]s > 0, %[2]s + %[3]s, %[2]s)", remainder, truncated, unit), true
+	}
+	away := fmt.Sprintf("IF(%s < 0, %s - %s, %s + %s)", c.arg, truncated, unit, truncated, unit)
+	if c.mode != roundHalfEven {
+		return fmt.Sprintf("IF(ABS(%s) * 2 >= %s, %s, %s)", remainder, unit, away, truncated), true
+	}
+	// the last digit of the truncated value decides the rounding of the half.
+	var lastDigit string
+	switch {
+	case digits > 0:
+		lastDigit = fmt.Sprintf(`REGEXP_EXTRACT(%s, r'\.\d{%d}(\d)')`, value, digits-1)
+	case digits == 0:
+		lastDigit = fmt.Sprintf(`REGEXP_EXTRACT(%s, r'^-?\d*(\d)')`, value)
+	default:
+		lastDigit = fmt.Sprintf(`REGEXP_EXTRACT(%s, r'^-?\d*(\d)\d{%d}(?:\.|$)')`, value, -digits)
+	}
+	return fmt.Sprintf(
+		"IF(ABS(%[1]s) * 2 > %[2]s OR (ABS(%[1]s) * 2 = %[2]s AND IFNULL(%[3]s, '0') IN ('1', '3', '5', '7', '9')), %[4]s, %[5]s)",
+		remainder, unit, lastDigit, away, truncated,
+	), true
+}
+
+// numericArithmeticQuery is the query that has the multiplications and the divisions.
+// go-zetasqlite computes NUMERIC and BIGNUMERIC values as big.Rat, so the results keep all the fractional digits
+// like 1 / 3 * 3 = 1, while BigQuery rounds each product and quotient half away from zero to the scale of the type.
+type numericArithmeticQuery struct {
+	query      string
+	tokens     []*token
+	operations []*arithmeticOperation
+	blocks     []*numericArithmeticBlock
+	// types are the types of the results selected by the probes.
+	types map[*arithmeticOperation]string
+}
+
+// arithmeticOperation is `left * right`, `left / right`, SAFE_MULTIPLY or SAFE_DIVIDE.
+// start and end are the index of the first token and the index next to the last token of the operation.
+type arithmeticOperation struct {
+	start int
+	end   int
+}
+
+// numericArithmeticBlock is the query block that has the multiplications and the divisions.
+// The types of the results are unknown until the probe runs, so they may not be NUMERIC or BIGNUMERIC.
+type numericArithmeticBlock struct {
+	probe      *typeProbe
+	operations []*arithmeticOperation
+}
+
+// parseNumericArithmetic returns the multiplications and the divisions of query grouped by the query blocks.
+// It returns nil if query has no multiplications or divisions or the operands can't be determined.
+func parseNumericArithmetic(query string) *numericArithmeticQuery {
+	tokens := tokenize(query)
+	typeParams := typeParameterTokens(tokens)
+	operators := bitwiseOperators(tokens, typeParams)
+	operatorEnds := map[int]int{}
+	for idx, op := range operators {
+		operatorEnds[idx+op.width-1] = idx
+	}
+	q := &numericArithmeticQuery{query: query, tokens: tokens, types: map[*arithmeticOperation]string{}}
+	for idx := 0; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if (tk.isKeyword("SAFE_MULTIPLY") || tk.isKeyword("SAFE_DIVIDE")) && idx+1 < len(tokens) && tokens[idx+1].isSymbol("(") &&
+			(idx == 0 || !tokens[idx-1].isSymbol(".")) {
+			if closeIdx := skipParen(tokens, idx+1); closeIdx > idx+2 && closeIdx < len(tokens) {
+				q.operations = append(q.operations, &arithmeticOperation{start: idx, end: closeIdx + 1})
+			}
+			continue
+		}
+		op, exists := operators[idx]
+		if !exists || !op.binary || (op.text != "*" && op.text != "/") {
+			continue
+		}
+		start := bitwiseOperandStart(tokens, idx, op.precedence, operators, operatorEnds, typeParams)
+		end := bitwiseOperandEnd(tokens, idx+1, op.precedence, operators, typeParams)
+		if start < idx && end > idx+1 {
+			q.operations = append(q.operations, &arithmeticOperation{start: start, end: end})
+		}
+	}
+	if len(q.operations) == 0 {
+		return nil
+	}
+	sort.SliceStable(q.operations, func(i, j int) bool {
+		a, b := q.operations[i], q.operations[j]
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		return a.end > b.end
+	})
+	for i, a := range q.operations {
+		for _, b := range q.operations[i+1:] {
+			if b.start < a.end && b.end > a.end {
+				// the operations must be nested or disjoint.
+				return nil
+			}
+		}
+	}
+
+	var (
+		byStart = map[int]*numericArithmeticBlock{}
+		exprs   = map[*numericArithmeticBlock][]string{}
+		queries = map[*numericArithmeticBlock]*queryBlock{}
+	)
+	for _, operation := range q.operations {
+		block := findQueryBlock(tokens, operation.start)
+		if block == nil {
+			continue
+		}
+		arithmetic, exists := byStart[block.start]
+		if !exists {
+			arithmetic = &numericArithmeticBlock{}
+			byStart[block.start] = arithmetic
+			queries[arithmetic] = block
+			q.blocks = append(q.blocks, arithmetic)
+		}
+		arithmetic.operations = append(arithmetic.operations, operation)
+		exprs[arithmetic] = append(exprs[arithmetic], tokenText(query, tokens[operation.start:operation.end]))
+	}
+	for _, block := range q.blocks {
+		block.probe = queries[block].typeProbe(query, tokens, exprs[block])
+	}
+	return q
+}
+
+// edits returns the edits that rewrite the outermost operations by the types of their results.
+// The nested operations are rewritten in the outer ones.
+func (q *numericArithmeticQuery) edits() []*edit {
+	var (
+		edits []*edit
+		pos   int
+	)
+	for _, operation := range q.operations {
+		start := q.tokens[operation.start].start
+		if start < pos {
+			continue
+		}
+		end := q.tokens[operation.end-1].end
+		pos = end
+		if replacement := q.operationExpr(operation); replacement != q.query[start:end] {
+			edits = append(edits, &edit{start: start, end: end, replacement: replacement})
+		}
+	}
+	return edits
+}
+
+// operationExpr returns the expression of the operation whose NUMERIC ( or BIGNUMERIC ) result is rounded
+// to the scale of the type. The string of the value is rounded half away from zero to the scale by go-zetasqlite.
+func (q *numericArithmeticQuery) operationExpr(operation *arithmeticOperation) string {
+	var b strings.Builder
+	pos := q.tokens[operation.start].start
+	for _, nested := range q.operations {
+		if nested == operation || nested.start < operation.start || nested.end > operation.end || q.tokens[nested.start].start < pos {
+			continue
+		}
+		b.WriteString(q.query[pos:q.tokens[nested.start].start])
+		b.WriteString(q.operationExpr(nested))
+		pos = q.tokens[nested.end-1].end
+	}
+	b.WriteString(q.query[pos:q.tokens[operation.end-1].end])
+	typ := q.types[operation]
+	if typ != "NUMERIC" && typ != "BIGNUMERIC" {
+		return b.String()
+	}
+	return numericScaleExpr(b.String(), typ)
+}
+
+// numericScaleExpr returns the expression that rounds the NUMERIC ( or BIGNUMERIC ) value of expr
+// half away from zero to the scale of typ.
+func numericScaleExpr(expr, typ string) string {
+	return fmt.Sprintf("CAST(CAST(%s AS STRING) AS %s)", expr, typ)
+}
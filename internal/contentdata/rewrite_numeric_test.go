@@ -0,0 +1,87 @@
+package contentdata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNumeric(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "string literal",
+			query:    "SELECT PARSE_BIGNUMERIC(' - 1.5 ') FROM t",
+			expected: "SELECT BIGNUMERIC '-1.5' FROM t",
+		},
+		{
+			name:  "column",
+			query: "SELECT PARSE_NUMERIC(s) FROM t",
+			expected: `SELECT PARSE_NUMERIC(CONCAT(IF(REGEXP_CONTAINS(s, r'^\s*-|-\s*$'), '-', ''), ` +
+				`REGEXP_REPLACE(s, r'^\s*[+-]?\s*|\s*[+-]?\s*$', ''))) FROM t`,
+		},
+	})
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    string
+		expectedErr string
+	}{
+		{value: "1.5", expected: "1.5"},
+		{value: " - 1.5 ", expected: "-1.5"},
+		{value: "1.5-", expected: "-1.5"},
+		{value: "1e3", expected: "1000"},
+		{value: "0.0000000005", expected: "0.000000001"},
+		{value: "-1.5-", expectedErr: `Invalid NUMERIC value: "-1.5-"`},
+		{value: "abc", expectedErr: `Invalid NUMERIC value: "abc"`},
+		{value: "1e30", expectedErr: "numeric overflow"},
+	}
+	for _, test := range tests {
+		got, err := parseDecimal(test.value, "NUMERIC")
+		if test.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+				t.Fatalf("%q: expected error %q but got %v", test.value, test.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.expected {
+			t.Fatalf("%q: expected %s but got %s", test.value, test.expected, got)
+		}
+	}
+}
+
+func TestCheckNumericRange(t *testing.T) {
+	if err := CheckNumericRange("NUMERIC", "100000000000000000000000000000"); err == nil {
+		t.Fatal("expected the overflow of NUMERIC")
+	}
+	if err := CheckNumericRange("BIGNUMERIC", "100000000000000000000000000000"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckNumericRange("INT64", "100000000000000000000000000000"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNumericArithmetic(t *testing.T) {
+	query := "SELECT a * b, SAFE_DIVIDE(a, b) FROM t"
+	q := parseNumericArithmetic(query)
+	if q == nil {
+		t.Fatal("failed to parse the arithmetic operations")
+	}
+	if got := applyEdits(query, q.edits()); got != query {
+		t.Fatalf("expected the operations of the unknown types to be kept but got %s", got)
+	}
+	for _, operation := range q.operations {
+		q.types[operation] = "NUMERIC"
+	}
+	expected := "SELECT CAST(CAST(a * b AS STRING) AS NUMERIC), CAST(CAST(SAFE_DIVIDE(a, b) AS STRING) AS NUMERIC) FROM t"
+	if got := applyEdits(query, q.edits()); got != expected {
+		t.Fatalf("failed to rewrite query:\nexpected: %s\ngot:      %s", expected, got)
+	}
+	if parseNumericArithmetic("SELECT 1") != nil {
+		t.Fatal("expected no arithmetic operations")
+	}
+}
@@ -0,0 +1,380 @@
+package contentdata
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+)
+
+// maxPivotRewrites is the maximum number of the PIVOT operators in the query.
+const maxPivotRewrites = 32
+
+// pivotOperator is `from_item PIVOT(aggregate [AS alias], ... FOR input_column IN (value [AS alias], ...)) [[AS] alias]`.
+type pivotOperator struct {
+	// fromStart is the index of the first token of from_item, and pivot is the index of PIVOT.
+	fromStart int
+	pivot     int
+	// end is the index of the token next to the operator and its alias.
+	end   int
+	alias string
+	// aggregates are the aggregate function calls and their aliases.
+	aggregates []*pivotAggregate
+	input      string
+	// values are the pivot values, and names are the names of the values used in the output column names.
+	values []string
+	names  []string
+}
+
+type pivotAggregate struct {
+	expr  string
+	alias string
+}
+
+// pivotOperators rewrites the PIVOT operators that go-zetasqlite can't run into the aggregations grouped by
+// the columns of from_item that are not referenced by the aggregate functions and input_column.
+// BigQuery names the output columns by concatenating the alias of the aggregate function and the name of
+// the pivot value with `_`. The columns of from_item are resolved by the probe queries, and the first operator
+// is rewritten first so that from_item of the following ones can be probed.
+func (r *Repository) pivotOperators(ctx context.Context, tx *connection.Tx, query string, values []interface{}) (string, error) {
+	for i := 0; i < maxPivotRewrites; i++ {
+		tokens := tokenize(query)
+		op, err := firstPivotOperator(query, tokens)
+		if err != nil {
+			return "", err
+		}
+		if op == nil {
+			return query, nil
+		}
+		fromItem := tokenText(query, tokens[op.fromStart:op.pivot])
+		fields, err := r.queryFields(ctx, tx, fmt.Sprintf("%sSELECT * FROM %s LIMIT 0", withClausePrefix(query, tokens), fromItem), values)
+		if err != nil {
+			return "", err
+		}
+		columns := make([]string, 0, len(fields))
+		for _, field := range fields {
+			columns = append(columns, field.Name)
+		}
+		e, err := op.edit(query, tokens, columns)
+		if err != nil {
+			return "", err
+		}
+		query = applyEdits(query, []*edit{e})
+	}
+	return query, nil
+}
+
+// firstPivotOperator returns the first PIVOT operator of the query, or nil if the query has no PIVOT operators.
+// from_item of the first operator doesn't have the other operators.
+func firstPivotOperator(query string, tokens []*token) (*pivotOperator, error) {
+	for idx := 1; idx+1 < len(tokens); idx++ {
+		op, err := parsePivotOperator(query, tokens, idx)
+		if err != nil {
+			return nil, err
+		}
+		if op != nil {
+			return op, nil
+		}
+	}
+	return nil, nil
+}
+
+// parsePivotOperator parses the PIVOT operator at tokens[idx].
+// It returns nil if tokens[idx] is not the operator like the call of the function named pivot.
+func parsePivotOperator(query string, tokens []*token, idx int) (*pivotOperator, error) {
+	if !tokens[idx].isKeyword("PIVOT") || idx+1 >= len(tokens) || !tokens[idx+1].isSymbol("(") || tokens[idx-1].isSymbol(".") {
+		return nil, nil
+	}
+	open, close := idx+1, skipParen(tokens, idx+1)
+	depth := tokens[open].depth + 1
+	forIdx := -1
+	for i := open + 1; i < close; i++ {
+		if tokens[i].depth == depth && tokens[i].isKeyword("FOR") {
+			forIdx = i
+			break
+		}
+	}
+	if forIdx < 0 {
+		// not a PIVOT operator ( e.g. the call of the function named pivot ).
+		return nil, nil
+	}
+	inIdx := -1
+	for i := forIdx + 1; i < close; i++ {
+		if tokens[i].depth == depth && tokens[i].isKeyword("IN") {
+			inIdx = i
+			break
+		}
+	}
+	if inIdx < 0 {
+		return nil, fmt.Errorf("PIVOT requires the IN list of pivot values after FOR %s", tokenText(query, tokens[forIdx+1:close]))
+	}
+	if inIdx+1 >= close || !tokens[inIdx+1].isSymbol("(") {
+		return nil, fmt.Errorf("PIVOT values must be a static list of constants enclosed in parentheses but got IN %s", tokenText(query, tokens[inIdx+1:close]))
+	}
+	if forIdx+1 == inIdx {
+		return nil, fmt.Errorf("PIVOT requires the input column after FOR")
+	}
+	listOpen, listClose := inIdx+1, skipParen(tokens, inIdx+1)
+	if listOpen+1 < listClose && (tokens[listOpen+1].isKeyword("SELECT") || tokens[listOpen+1].isKeyword("WITH")) {
+		return nil, fmt.Errorf("IN subquery is not supported in PIVOT: pivot values must be a static list of constants. Use EXECUTE IMMEDIATE to build the list of pivot values dynamically")
+	}
+	op := &pivotOperator{
+		fromStart: pivotFromItemStart(tokens, idx),
+		pivot:     idx,
+		end:       close + 1,
+		input:     tokenText(query, tokens[forIdx+1:inIdx]),
+	}
+	if hasTableAlias(tokens, op.end) {
+		if tokens[op.end].isKeyword("AS") {
+			op.end++
+		}
+		if op.end < len(tokens) {
+			op.alias = tokens[op.end].text
+			op.end++
+		}
+	}
+	for _, item := range pivotItems(tokens, open+1, forIdx, depth) {
+		expr, alias := selectItemExpr(query, item)
+		op.aggregates = append(op.aggregates, &pivotAggregate{expr: expr, alias: alias})
+	}
+	if len(op.aggregates) == 0 {
+		return nil, fmt.Errorf("PIVOT requires at least one aggregate function")
+	}
+	values := pivotItems(tokens, listOpen+1, listClose, depth+1)
+	if len(values) == 0 {
+		return nil, fmt.Errorf("PIVOT requires at least one pivot value in the IN list")
+	}
+	for _, value := range values {
+		expr, alias := selectItemExpr(query, value)
+		exprTokens := tokenize(expr)
+		if !isPivotConstant(exprTokens) {
+			return nil, fmt.Errorf("PIVOT values must be constants but got %s: the IN list of PIVOT can't refer to columns or subqueries", expr)
+		}
+		if alias == "" {
+			alias = pivotValueName(exprTokens)
+		}
+		if alias == "" {
+			return nil, fmt.Errorf("PIVOT value %s requires an alias to name the output columns", expr)
+		}
+		op.values = append(op.values, expr)
+		op.names = append(op.names, alias)
+	}
+	columns := map[string]struct{}{}
+	for _, column := range op.columnNames() {
+		key := strings.ToLower(column)
+		if _, exists := columns[key]; exists {
+			return nil, fmt.Errorf("Duplicate column name %s in the output of PIVOT: use distinct aliases for the aggregate functions and the pivot values", column)
+		}
+		columns[key] = struct{}{}
+	}
+	return op, nil
+}
+
+// pivotFromItemStart returns the index of the first token of from_item of the PIVOT operator at tokens[idx],
+// that follows FROM, JOIN or the comma of the FROM clause.
+func pivotFromItemStart(tokens []*token, idx int) int {
+	depth := tokens[idx].depth
+	for i := idx - 1; i >= 0; i-- {
+		tk := tokens[i]
+		if tk.depth < depth {
+			return i + 1
+		}
+		if tk.depth == depth && (tk.isKeyword("FROM") || tk.isKeyword("JOIN") || tk.isSymbol(",")) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// columnNames returns the names of the output columns of the aggregations for each pivot value in order.
+func (op *pivotOperator) columnNames() []string {
+	var names []string
+	for _, name := range op.names {
+		for _, aggregate := range op.aggregates {
+			if aggregate.alias != "" {
+				names = append(names, aggregate.alias+"_"+name)
+			} else {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// edit returns the edit replacing the operator with the subquery aggregating from_item,
+// where columns are the columns of from_item.
+func (op *pivotOperator) edit(query string, tokens []*token, columns []string) (*edit, error) {
+	referenced := map[string]struct{}{}
+	exprs := []string{op.input}
+	for _, aggregate := range op.aggregates {
+		exprs = append(exprs, aggregate.expr)
+	}
+	for _, expr := range exprs {
+		exprTokens := tokenize(expr)
+		for i, tk := range exprTokens {
+			if tk.kind != tokenWord && tk.kind != tokenQuotedIdent {
+				continue
+			}
+			if i+1 < len(exprTokens) && exprTokens[i+1].isSymbol("(") {
+				continue
+			}
+			referenced[strings.ToLower(strings.Trim(tk.text, "`"))] = struct{}{}
+		}
+	}
+	var groups []string
+	for _, column := range columns {
+		if _, exists := referenced[strings.ToLower(column)]; !exists {
+			groups = append(groups, fmt.Sprintf("`%s`", column))
+		}
+	}
+	items := append([]string{}, groups...)
+	names := op.columnNames()
+	for _, value := range op.values {
+		cond := fmt.Sprintf("(%s) IS NOT DISTINCT FROM (%s)", op.input, value)
+		for _, aggregate := range op.aggregates {
+			expr, err := pivotAggregateExpr(aggregate.expr, cond)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, fmt.Sprintf("%s AS `%s`", expr, names[0]))
+			names = names[1:]
+		}
+	}
+	replacement := fmt.Sprintf("(SELECT %s FROM %s", strings.Join(items, ", "), tokenText(query, tokens[op.fromStart:op.pivot]))
+	if len(groups) != 0 {
+		replacement += " GROUP BY " + strings.Join(groups, ", ")
+	}
+	replacement += ")"
+	if op.alias != "" {
+		replacement += " AS " + op.alias
+	}
+	return &edit{start: tokens[op.fromStart].start, end: tokens[op.end-1].end, replacement: replacement}, nil
+}
+
+// pivotAggregateExpr returns the aggregate function call that aggregates the first argument only for the rows
+// satisfying cond, like `SUM(IF(cond, x, NULL))` for `SUM(x)` and `COUNT(IF(cond, 1, NULL))` for `COUNT(*)`.
+func pivotAggregateExpr(expr, cond string) (string, error) {
+	tokens := tokenize(expr)
+	open := -1
+	for idx, tk := range tokens {
+		if tk.depth == 0 && tk.isSymbol("(") {
+			open = idx
+			break
+		}
+	}
+	if open <= 0 || skipParen(tokens, open) != len(tokens)-1 {
+		return "", fmt.Errorf("PIVOT requires the aggregate function call but got %s", expr)
+	}
+	depth := tokens[open].depth + 1
+	start := open + 1
+	if start < len(tokens)-1 && tokens[start].isKeyword("DISTINCT") {
+		start++
+	}
+	end := start
+	for end < len(tokens)-1 {
+		tk := tokens[end]
+		if tk.depth == depth && (tk.isSymbol(",") || tk.isKeyword("ORDER") || tk.isKeyword("LIMIT") ||
+			tk.isKeyword("IGNORE") || tk.isKeyword("RESPECT") || tk.isKeyword("HAVING")) {
+			break
+		}
+		end++
+	}
+	if start == end {
+		return "", fmt.Errorf("PIVOT requires the aggregate function with an argument but got %s", expr)
+	}
+	arg := tokenText(expr, tokens[start:end])
+	if arg == "*" {
+		arg = "1"
+	}
+	return fmt.Sprintf("%sIF(%s, %s, NULL)%s", expr[:tokens[start].start], cond, arg, expr[tokens[end-1].end:]), nil
+}
+
+// pivotItems splits tokens[start:end] by the commas at depth.
+func pivotItems(tokens []*token, start, end, depth int) []*selectItem {
+	var (
+		items []*selectItem
+		cur   []*token
+	)
+	for idx := start; idx < end; idx++ {
+		if tokens[idx].depth == depth && tokens[idx].isSymbol(",") {
+			items = append(items, &selectItem{tokens: cur})
+			cur = nil
+			continue
+		}
+		cur = append(cur, tokens[idx])
+	}
+	if len(cur) != 0 {
+		items = append(items, &selectItem{tokens: cur})
+	}
+	return items
+}
+
+var pivotConstantKeywords = []string{
+	"NULL", "TRUE", "FALSE", "DATE", "DATETIME", "TIME", "TIMESTAMP", "NUMERIC", "BIGNUMERIC", "JSON", "INTERVAL", "AS",
+}
+
+// isPivotConstant reports whether tokens are a constant expression like a literal, a query parameter
+// or a function call of them.
+func isPivotConstant(tokens []*token) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	for idx, tk := range tokens {
+		switch tk.kind {
+		case tokenQuotedIdent:
+			return false
+		case tokenWord:
+			if tk.isKeyword("SELECT") || tk.isKeyword("WITH") {
+				return false
+			}
+			if idx+1 < len(tokens) && (tokens[idx+1].isSymbol("(") || tokens[idx+1].isSymbol(".")) {
+				// function name like CAST or SAFE.PARSE_DATE.
+				continue
+			}
+			if idx > 0 && (tokens[idx-1].isKeyword("AS") || tokens[idx-1].isSymbol(".") || tokens[idx-1].kind == tokenNumber) {
+				// type name of CAST or the date part of INTERVAL.
+				continue
+			}
+			var constant bool
+			for _, kw := range pivotConstantKeywords {
+				if tk.isKeyword(kw) {
+					constant = true
+					break
+				}
+			}
+			if !constant {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// pivotValueName returns the name of the pivot value used in the output column names
+// if the value has no alias, or empty string if the name is determined by the type of the value.
+func pivotValueName(tokens []*token) string {
+	switch {
+	case len(tokens) == 1 && tokens[0].kind == tokenString && !tokens[0].isBytesLiteral():
+		v, _ := stringLiteralValue(tokens[0])
+		return v
+	case len(tokens) == 1 && tokens[0].kind == tokenNumber && isIntegerLiteral(tokens[0].text):
+		return "_" + tokens[0].text
+	case len(tokens) == 2 && tokens[0].isSymbol("-") && tokens[1].kind == tokenNumber && isIntegerLiteral(tokens[1].text):
+		return "minus_" + tokens[1].text
+	case len(tokens) == 1 && tokens[0].isKeyword("NULL"):
+		return "NULL"
+	case len(tokens) == 1 && (tokens[0].isKeyword("TRUE") || tokens[0].isKeyword("FALSE")):
+		return strings.ToLower(tokens[0].text)
+	}
+	return ""
+}
+
+func isIntegerLiteral(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if !isDigit(text[i]) {
+			return false
+		}
+	}
+	return text != ""
+}
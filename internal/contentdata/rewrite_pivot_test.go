@@ -0,0 +1,70 @@
+package contentdata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPivotOperator(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		columns     []string
+		expected    string
+		expectedErr string
+	}{
+		{
+			name:    "pivot values with alias",
+			query:   "SELECT * FROM t PIVOT (SUM(x) FOR y IN ('a', 'b' AS bb))",
+			columns: []string{"a", "bb"},
+			expected: "SELECT * FROM (SELECT `k`, SUM(IF((y) IS NOT DISTINCT FROM ('a'), x, NULL)) AS `a`, " +
+				"SUM(IF((y) IS NOT DISTINCT FROM ('b'), x, NULL)) AS `bb` FROM t GROUP BY `k`)",
+		},
+		{
+			name:    "multiple aggregates",
+			query:   "SELECT * FROM t PIVOT (SUM(x) AS s, COUNT(*) AS c FOR y IN (1, 2)) AS p",
+			columns: []string{"s__1", "c__1", "s__2", "c__2"},
+			expected: "SELECT * FROM (SELECT `k`, SUM(IF((y) IS NOT DISTINCT FROM (1), x, NULL)) AS `s__1`, " +
+				"COUNT(IF((y) IS NOT DISTINCT FROM (1), 1, NULL)) AS `c__1`, SUM(IF((y) IS NOT DISTINCT FROM (2), x, NULL)) AS `s__2`, " +
+				"COUNT(IF((y) IS NOT DISTINCT FROM (2), 1, NULL)) AS `c__2` FROM t GROUP BY `k`) AS p",
+		},
+		{
+			name:        "not aggregate function",
+			query:       "SELECT * FROM t PIVOT (x FOR y IN ('a'))",
+			columns:     []string{"a"},
+			expectedErr: "PIVOT requires the aggregate function call but got x",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			tokens := tokenize(test.query)
+			op, err := firstPivotOperator(test.query, tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if op == nil {
+				t.Fatal("failed to find the PIVOT operator")
+			}
+			if got := op.columnNames(); !reflect.DeepEqual(got, test.columns) {
+				t.Fatalf("expected columns %v but got %v", test.columns, got)
+			}
+			e, err := op.edit(test.query, tokens, []string{"k", "y", "x"})
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error %q but got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := applyEdits(test.query, []*edit{e}); got != test.expected {
+				t.Fatalf("failed to rewrite query:\nexpected: %s\ngot:      %s", test.expected, got)
+			}
+		})
+	}
+	if op, err := firstPivotOperator("SELECT 1", tokenize("SELECT 1")); op != nil || err != nil {
+		t.Fatalf("expected no PIVOT operators but got %v, %v", op, err)
+	}
+}
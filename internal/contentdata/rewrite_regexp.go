@@ -0,0 +1,134 @@
+package contentdata
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// regexpEdits aligns the regular expression functions with BigQuery.
+//   - REGEXP_SUBSTR is the synonym for REGEXP_EXTRACT.
+//   - the position argument of REGEXP_EXTRACT and REGEXP_INSTR counts characters ( bytes for BYTES ) by SUBSTR.
+//   - the literal pattern of the extraction functions must be valid and must not have more than one capturing group.
+//   - the literal replacement of REGEXP_REPLACE supports \0 to \9 backreferences and a literal $.
+func regexpEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		switch name {
+		case "REGEXP_EXTRACT", "REGEXP_SUBSTR", "REGEXP_EXTRACT_ALL", "REGEXP_INSTR", "REGEXP_REPLACE", "REGEXP_CONTAINS":
+		default:
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		for i, arg := range args {
+			argEdits, err := regexpEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = applyEdits(arg, argEdits)
+		}
+		if len(args) >= 2 {
+			if err := validateRegexpLiteral(name, args[1]); err != nil {
+				return nil, err
+			}
+		}
+		var expr string
+		switch name {
+		case "REGEXP_EXTRACT", "REGEXP_SUBSTR":
+			if len(args) >= 3 {
+				args[0] = fmt.Sprintf("SUBSTR(%s, %s)", args[0], args[2])
+				args[2] = "1"
+			}
+			expr = fmt.Sprintf("REGEXP_EXTRACT(%s)", strings.Join(args, ", "))
+		case "REGEXP_INSTR":
+			if len(args) < 3 {
+				continue
+			}
+			position := args[2]
+			args[0] = fmt.Sprintf("SUBSTR(%s, %s)", args[0], position)
+			args[2] = "1"
+			instr := fmt.Sprintf("REGEXP_INSTR(%s)", strings.Join(args, ", "))
+			expr = fmt.Sprintf("IF(%[1]s = 0, 0, %[1]s + (%[2]s) - 1)", instr, position)
+		case "REGEXP_REPLACE":
+			if len(args) != 3 {
+				continue
+			}
+			replacement, ok := regexpReplacementLiteral(args[2])
+			if !ok {
+				continue
+			}
+			args[2] = replacement
+			expr = fmt.Sprintf("REGEXP_REPLACE(%s)", strings.Join(args, ", "))
+		default:
+			continue
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+// validateRegexpLiteral reports the invalid pattern when the pattern is a string literal.
+func validateRegexpLiteral(name, pattern string) error {
+	tokens := tokenize(pattern)
+	if len(tokens) != 1 {
+		return nil
+	}
+	v, ok := stringLiteralValue(tokens[0])
+	if !ok {
+		return nil
+	}
+	re, err := regexp.Compile(v)
+	if err != nil {
+		return fmt.Errorf("%s: cannot parse regular expression: %w", name, err)
+	}
+	switch name {
+	case "REGEXP_EXTRACT", "REGEXP_SUBSTR", "REGEXP_EXTRACT_ALL":
+		if re.NumSubexp() > 1 {
+			return fmt.Errorf("%s: regular expressions passed into extraction functions must not have more than 1 capturing group", name)
+		}
+	}
+	return nil
+}
+
+// regexpReplacementLiteral converts the BigQuery replacement string literal into the Go template
+// which go-zetasqlite passes to regexp.ReplaceAllString as is.
+func regexpReplacementLiteral(replacement string) (string, bool) {
+	tokens := tokenize(replacement)
+	if len(tokens) != 1 || tokens[0].isBytesLiteral() {
+		return "", false
+	}
+	v, ok := stringLiteralValue(tokens[0])
+	if !ok {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '$':
+			b.WriteString("$$")
+		case c == '\\' && i+1 < len(v) && isDigit(v[i+1]):
+			fmt.Fprintf(&b, "${%c}", v[i+1])
+			i++
+		case c == '\\' && i+2 < len(v) && v[i+1] == '\\' && !isDigit(v[i+2]) && v[i+2] != '\\':
+			// go-zetasqlite keeps the backslash followed by the other character as it is.
+			b.WriteByte('\\')
+			i++
+		case c == '\\' && i == len(v)-1:
+			// go-zetasqlite expects the character after the backslash.
+			b.WriteString(`\\`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return QuoteStringLiteral(b.String()), true
+}
@@ -0,0 +1,33 @@
+package contentdata
+
+import "testing"
+
+func TestRegexpFunctions(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "regexp extract with position",
+			query:    "SELECT REGEXP_EXTRACT(s, r'a(b)', 2, 1) FROM t",
+			expected: "SELECT REGEXP_EXTRACT(SUBSTR(s, 2), r'a(b)', 1, 1) FROM t",
+		},
+		{
+			name:     "regexp substr",
+			query:    "SELECT REGEXP_SUBSTR(s, 'a') FROM t",
+			expected: "SELECT REGEXP_EXTRACT(s, 'a') FROM t",
+		},
+		{
+			name:     "regexp instr with position",
+			query:    "SELECT REGEXP_INSTR(s, 'a', 1, 2, 1) FROM t",
+			expected: "SELECT IF(REGEXP_INSTR(SUBSTR(s, 1), 'a', 1, 2, 1) = 0, 0, REGEXP_INSTR(SUBSTR(s, 1), 'a', 1, 2, 1) + (1) - 1) FROM t",
+		},
+		{
+			name:     "regexp replace with backreference",
+			query:    `SELECT REGEXP_REPLACE(s, 'a', '\\1') FROM t`,
+			expected: "SELECT REGEXP_REPLACE(s, 'a', '${1}') FROM t",
+		},
+		{
+			name:     "regexp contains",
+			query:    `SELECT REGEXP_CONTAINS(s, r'\d') FROM t`,
+			expected: `SELECT REGEXP_CONTAINS(s, r'\d') FROM t`,
+		},
+	})
+}
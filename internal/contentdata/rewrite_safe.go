@@ -0,0 +1,99 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// safeRewrittenFuncNames are the functions rewritten by queryRewriters that BigQuery allows to call with SAFE. prefix.
+var safeRewrittenFuncNames = map[string]struct{}{
+	"TIMESTAMP_BUCKET": {}, "DATETIME_BUCKET": {}, "DATE_BUCKET": {}, "DATE_DIFF": {}, "DATETIME_DIFF": {},
+	"TIMESTAMP_DIFF": {}, "PARSE_NUMERIC": {}, "PARSE_BIGNUMERIC": {}, "NORMALIZE_AND_CASEFOLD": {},
+	"CONTAINS_SUBSTR": {}, "SEARCH": {}, "REGEXP_EXTRACT": {}, "REGEXP_SUBSTR": {}, "REGEXP_EXTRACT_ALL": {},
+	"REGEXP_INSTR": {}, "REGEXP_REPLACE": {}, "REGEXP_CONTAINS": {}, "JSON_OBJECT": {}, "JSON_ARRAY": {},
+	"PARSE_JSON": {}, "TIMESTAMP": {}, "STRING": {}, "TIMESTAMP_SECONDS": {}, "TIMESTAMP_MILLIS": {},
+	"TIMESTAMP_MICROS": {}, "DATE_FROM_UNIX_DATE": {}, "PARSE_TIMESTAMP": {}, "GENERATE_ARRAY": {},
+	"GENERATE_TIMESTAMP_ARRAY": {}, "ARRAY_CONCAT": {}, "DATE_TRUNC": {}, "DATETIME_TRUNC": {}, "TIMESTAMP_TRUNC": {},
+	"TIME_TRUNC": {},
+}
+
+// unsafeFuncNames are the functions and the operators taking parentheses that can't be called with SAFE. prefix.
+var unsafeFuncNames = map[string]struct{}{
+	"IFNULL": {}, "NULLIF": {}, "COALESCE": {}, "CAST": {}, "SAFE_CAST": {}, "EXTRACT": {}, "STRUCT": {},
+	"OFFSET": {}, "ORDINAL": {}, "SAFE_OFFSET": {}, "SAFE_ORDINAL": {}, "OVER": {}, "ERROR": {},
+}
+
+// rewriteSafeCalls rewrites the SAFE. calls of the functions that queryRewriters replace with the other expressions,
+// so that they return NULL instead of an error in the same way as the functions go-zetasqlite evaluates with SAFE. prefix.
+// The call is rewritten by queryRewriters without the prefix, and then each function call of the result is
+// prefixed by SAFE., CAST is replaced with SAFE_CAST, OFFSET and ORDINAL with SAFE_OFFSET and SAFE_ORDINAL,
+// and ERROR with NULL. Since the rewriters skip the calls prefixed by SAFE., the result isn't rewritten again.
+// If the call can't be rewritten ( e.g. the literal argument is invalid ), it is left to go-zetasqlite as is.
+//
+// BigQuery doesn't support SAFE. prefix for the aggregate and analytic functions, so they are reported as the error.
+func rewriteSafeCalls(query string) (string, error) {
+	tokens := tokenize(query)
+	var edits []*edit
+	for idx := 2; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") ||
+			!tokens[idx-1].isSymbol(".") || !tokens[idx-2].isKeyword("SAFE") {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		name := strings.ToUpper(tk.text)
+		if _, exists := aggregateFuncNames[name]; exists {
+			return "", fmt.Errorf("Function %s does not support SAFE error mode: SAFE. prefix can't be used with aggregate functions", name)
+		}
+		if closeIdx+1 < len(tokens) && tokens[closeIdx+1].isKeyword("OVER") {
+			return "", fmt.Errorf("Function %s does not support SAFE error mode: SAFE. prefix can't be used with analytic functions", name)
+		}
+		if _, exists := safeRewrittenFuncNames[name]; !exists {
+			continue
+		}
+		rewritten, err := rewriteQuery(query[tk.start:tokens[closeIdx].end])
+		if err != nil {
+			idx = closeIdx
+			continue
+		}
+		edits = append(edits, &edit{
+			start:       tokens[idx-2].start,
+			end:         tokens[closeIdx].end,
+			replacement: fmt.Sprintf("(%s)", safeExpr(rewritten)),
+		})
+		idx = closeIdx
+	}
+	return applyEdits(query, edits), nil
+}
+
+// safeExpr returns the expression that evaluates expr with SAFE error mode.
+func safeExpr(expr string) string {
+	tokens := tokenize(expr)
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") || (idx > 0 && tokens[idx-1].isSymbol(".")) {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		switch {
+		case name == "ERROR":
+			edits = append(edits, &edit{start: tk.start, end: tokens[skipParen(tokens, idx+1)].end, replacement: "NULL"})
+			idx = skipParen(tokens, idx+1)
+		case name == "CAST":
+			edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: "SAFE_CAST"})
+		case (name == "OFFSET" || name == "ORDINAL") && idx > 0 && tokens[idx-1].isSymbol("["):
+			edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: "SAFE_" + name})
+		case isReservedKeyword(tk), isEmulatorFunction(name):
+		default:
+			if _, exists := unsafeFuncNames[name]; exists {
+				continue
+			}
+			if _, exists := aggregateFuncNames[name]; exists {
+				continue
+			}
+			edits = append(edits, &edit{start: tk.start, end: tk.start, replacement: "SAFE."})
+		}
+	}
+	return applyEdits(expr, edits)
+}
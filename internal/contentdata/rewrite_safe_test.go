@@ -0,0 +1,29 @@
+package contentdata
+
+import "testing"
+
+func TestSafeCalls(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "safe function rewritten by the emulator",
+			query:    "SELECT SAFE.DATE_BUCKET(d, INTERVAL 2 DAY) FROM t",
+			expected: "SELECT (bqemulator_date_bucket(d, DATE '1950-01-01', (2) * 1)) FROM t",
+		},
+		{
+			name:  "safe calls in the rewritten expression",
+			query: "SELECT SAFE.TIMESTAMP_BUCKET(ts, INTERVAL 1 DAY) FROM t",
+			expected: "SELECT (bqemulator_timestamp_bucket(SAFE.UNIX_MICROS(ts), " +
+				"SAFE.UNIX_MICROS(TIMESTAMP '1950-01-01 00:00:00+00'), (1) * 86400000000)) FROM t",
+		},
+		{
+			name:     "safe function supported by go-zetasqlite",
+			query:    "SELECT SAFE.SUBSTR(s, 1) FROM t",
+			expected: "SELECT SAFE.SUBSTR(s, 1) FROM t",
+		},
+		{
+			name:        "safe aggregate function",
+			query:       "SELECT SAFE.SUM(x) FROM t",
+			expectedErr: "Function SUM does not support SAFE error mode: SAFE. prefix can't be used with aggregate functions",
+		},
+	})
+}
@@ -0,0 +1,332 @@
+package contentdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// jsonStringPattern matches the quoted string encoded by TO_JSON_STRING.
+	jsonStringPattern = `"(?:[^"\\]|\\.)*"`
+	// jsonLeafPattern matches the string or the other scalar value of the text encoded by TO_JSON_STRING.
+	// go-zetasqlite doesn't quote the temporal values, so any text other than the delimiters is a value.
+	jsonLeafPattern = jsonStringPattern + `|[^,\[\]{}"\s]+`
+)
+
+var jsonScopeArgPattern = regexp.MustCompile(`(?is)^json_scope\s*=>\s*(.+)$`)
+
+// containsSubstrEdits rewrites CONTAINS_SUBSTR that go-zetasqlite doesn't implement.
+// The expression is encoded by TO_JSON_STRING, so a STRUCT ( or a row ) and an ARRAY are searched field by field.
+// Each value is compared in its string form after NORMALIZE_AND_CASEFOLD with NFKC.
+// The temporal values are compared in the canonical form of CAST( e.g. 2008-12-25 15:30:00+00 ).
+//
+// The result is TRUE if any value contains the search value, NULL if no value contains it and any value is NULL,
+// otherwise FALSE.
+func containsSubstrEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") || !tk.isKeyword("CONTAINS_SUBSTR") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("CONTAINS_SUBSTR: invalid argument num %d", len(args))
+		}
+		for i, arg := range args {
+			argEdits, err := containsSubstrEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = applyEdits(arg, argEdits)
+		}
+		scope := "JSON_VALUES"
+		if len(args) == 3 {
+			arg := args[2]
+			if matched := jsonScopeArgPattern.FindStringSubmatch(arg); matched != nil {
+				arg = strings.TrimSpace(matched[1])
+			}
+			scopeTokens := tokenize(arg)
+			var ok bool
+			if len(scopeTokens) == 1 {
+				scope, ok = stringLiteralValue(scopeTokens[0])
+			}
+			scope = strings.ToUpper(scope)
+			if !ok || (scope != "JSON_VALUES" && scope != "JSON_KEYS" && scope != "JSON_KEYS_AND_VALUES") {
+				return nil, fmt.Errorf("CONTAINS_SUBSTR: json_scope must be 'JSON_VALUES', 'JSON_KEYS' or 'JSON_KEYS_AND_VALUES'")
+			}
+		}
+		edits = append(edits, &edit{
+			start:       tk.start,
+			end:         tokens[closeIdx].end,
+			replacement: containsSubstrExpr(args[0], args[1], scope),
+		})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+func containsSubstrExpr(expr, search, scope string) string {
+	encoded := fmt.Sprintf("TO_JSON_STRING(%s)", expr)
+	var values []string
+	if scope != "JSON_KEYS" {
+		values = append(values, fmt.Sprintf(
+			"ARRAY(SELECT %s FROM UNNEST(REGEXP_EXTRACT_ALL(%s, %s)) AS leaf)",
+			searchableValueExpr("leaf"), jsonWithoutKeysExpr(encoded), QuoteStringLiteral(jsonLeafPattern),
+		))
+	}
+	if scope != "JSON_VALUES" {
+		values = append(values, jsonKeysExpr(encoded))
+	}
+	return fmt.Sprintf(
+		"IF((%[1]s) IS NULL, NULL, (SELECT IF(LOGICAL_OR(STRPOS(NORMALIZE_AND_CASEFOLD(v, NFKC), NORMALIZE_AND_CASEFOLD(%[2]s, NFKC)) > 0), TRUE, IF(LOGICAL_OR(v IS NULL), NULL, FALSE)) FROM UNNEST(ARRAY_CONCAT(%[3]s)) AS v))",
+		expr, search, strings.Join(values, ", "),
+	)
+}
+
+// searchableValueExpr returns the string form of the value matched by jsonLeafPattern.
+func searchableValueExpr(leaf string) string {
+	return fmt.Sprintf(
+		"CASE WHEN %[1]s = 'null' THEN NULL WHEN STARTS_WITH(%[1]s, '\"') THEN JSON_VALUE(%[1]s, '$') "+
+			"ELSE REGEXP_REPLACE(REGEXP_REPLACE(%[1]s, %[2]s, '\\\\1 \\\\2+00'), %[3]s, '\\\\1 \\\\2') END",
+		leaf,
+		QuoteStringLiteral(`^(\d{4}-\d{2}-\d{2})T(\d{2}:\d{2}:\d{2}(?:\.\d+)?)Z$`),
+		QuoteStringLiteral(`^(\d{4}-\d{2}-\d{2})T(\d{2}:\d{2}:\d{2}(?:\.\d+)?)$`),
+	)
+}
+
+// jsonWithoutKeysExpr returns the expression that removes the keys of the objects from the text encoded by TO_JSON_STRING.
+func jsonWithoutKeysExpr(encoded string) string {
+	return fmt.Sprintf("REGEXP_REPLACE(%s, %s, '')", encoded, QuoteStringLiteral(jsonStringPattern+`\s*:`))
+}
+
+// jsonKeysExpr returns the expression of ARRAY<STRING> that has the keys of the objects in the text encoded by TO_JSON_STRING.
+func jsonKeysExpr(encoded string) string {
+	return fmt.Sprintf(
+		"ARRAY(SELECT JSON_VALUE(k, '$') FROM UNNEST(REGEXP_EXTRACT_ALL(%s, %s)) AS k)",
+		encoded, QuoteStringLiteral("("+jsonStringPattern+`)\s*:`),
+	)
+}
+
+var namedArgPattern = regexp.MustCompile(`(?is)^(\w+)\s*=>\s*(.+)$`)
+
+// logAnalyzerDelimiters are the default delimiters of LOG_ANALYZER.
+var logAnalyzerDelimiters = []string{
+	"[", "]", "<", ">", "(", ")", "{", "}", "|", "!", ";", ",", "'", "\"", "*", "&", "?", "+", "/", ":", "=", "@",
+	".", "-", "$", "%", "\\", "_", "\n", "\r", " ", "\t",
+	"%21", "%26", "%2526", "%3B", "%3b", "%7C", "%7c", "%20", "%2B", "%2b", "%3D", "%3d", "%2520",
+	"%5D", "%5d", "%5B", "%5b", "%3A", "%3a", "%0A", "%0a", "%2C", "%2c", "%28", "%29",
+}
+
+// searchEdits rewrites SEARCH(search_data, search_query) into the scan of the string values of search_data,
+// the emulator doesn't build the search index.
+// The string values are extracted from TO_JSON_STRING of search_data, so a STRUCT ( or a row ), an ARRAY and JSON are searched value by value.
+//   - LOG_ANALYZER ( default ) lowercases the text and splits it by the delimiters.
+//     SEARCH returns TRUE if every term of search_query is a token of search_data,
+//     and the terms enclosed in backticks must appear as the consecutive tokens of a value.
+//   - NO_OP_ANALYZER doesn't split the text, so search_query must equal a value.
+//
+// The backticks of the search_query that isn't a string literal are treated as the delimiters.
+func searchEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") || !tk.isKeyword("SEARCH") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) < 2 {
+			return nil, fmt.Errorf("SEARCH: invalid argument num %d", len(args))
+		}
+		for i, arg := range args {
+			argEdits, err := searchEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = applyEdits(arg, argEdits)
+		}
+		opts := map[string]string{
+			"json_scope": "JSON_VALUES",
+			"analyzer":   "LOG_ANALYZER",
+		}
+		for _, arg := range args[2:] {
+			matched := namedArgPattern.FindStringSubmatch(arg)
+			if matched == nil {
+				return nil, fmt.Errorf("SEARCH: the optional arguments must be named")
+			}
+			name := strings.ToLower(matched[1])
+			if _, exists := opts[name]; !exists && name != "analyzer_options" {
+				return nil, fmt.Errorf("SEARCH: unknown argument %s", matched[1])
+			}
+			valueTokens := tokenize(matched[2])
+			var (
+				value string
+				ok    bool
+			)
+			if len(valueTokens) == 1 {
+				value, ok = stringLiteralValue(valueTokens[0])
+			}
+			if !ok {
+				return nil, fmt.Errorf("SEARCH: %s must be a string literal", name)
+			}
+			opts[name] = value
+		}
+		expr, err := searchExpr(args[0], args[1], opts)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+func searchExpr(data, search string, opts map[string]string) (string, error) {
+	scope := strings.ToUpper(opts["json_scope"])
+	if scope != "JSON_VALUES" && scope != "JSON_KEYS" && scope != "JSON_KEYS_AND_VALUES" {
+		return "", fmt.Errorf("SEARCH: json_scope must be 'JSON_VALUES', 'JSON_KEYS' or 'JSON_KEYS_AND_VALUES'")
+	}
+	encoded := fmt.Sprintf("TO_JSON_STRING(%s)", data)
+	var values []string
+	if scope != "JSON_KEYS" {
+		values = append(values, fmt.Sprintf(
+			"ARRAY(SELECT JSON_VALUE(leaf, '$') FROM UNNEST(REGEXP_EXTRACT_ALL(%s, %s)) AS leaf)",
+			jsonWithoutKeysExpr(encoded), QuoteStringLiteral(jsonStringPattern),
+		))
+	}
+	if scope != "JSON_VALUES" {
+		values = append(values, jsonKeysExpr(encoded))
+	}
+	strs := fmt.Sprintf("UNNEST(ARRAY_CONCAT(%s)) AS s", strings.Join(values, ", "))
+
+	switch analyzer := strings.ToUpper(opts["analyzer"]); analyzer {
+	case "NO_OP_ANALYZER":
+		return fmt.Sprintf("IFNULL((SELECT LOGICAL_OR(s = %s) FROM %s), FALSE)", search, strs), nil
+	case "LOG_ANALYZER":
+	case "PATTERN_ANALYZER":
+		return "", fmt.Errorf("SEARCH: PATTERN_ANALYZER is not supported by the emulator")
+	default:
+		return "", fmt.Errorf("SEARCH: invalid analyzer %q. analyzer must be 'LOG_ANALYZER', 'NO_OP_ANALYZER' or 'PATTERN_ANALYZER'", analyzer)
+	}
+	delimiters, err := searchDelimiters(opts["analyzer_options"])
+	if err != nil {
+		return "", err
+	}
+	pattern := delimiterPattern(delimiters)
+	// each value is normalized into the tokens separated by a space and enclosed by spaces,
+	// so a term ( or the consecutive terms ) matches the whole tokens by STRPOS.
+	normalized := fmt.Sprintf(
+		"(SELECT CONCAT(' ', TRIM(REGEXP_REPLACE(LOWER(s), %s, ' ')), ' ') AS v FROM %s)",
+		QuoteStringLiteral(pattern), strs,
+	)
+	searchTokens := tokenize(search)
+	var (
+		literal string
+		ok      bool
+	)
+	if len(searchTokens) == 1 {
+		literal, ok = stringLiteralValue(searchTokens[0])
+	}
+	if !ok {
+		return fmt.Sprintf(
+			"IFNULL((SELECT LOGICAL_AND(IFNULL((SELECT LOGICAL_OR(STRPOS(v, CONCAT(' ', t, ' ')) > 0) FROM %s), FALSE)) "+
+				"FROM UNNEST(SPLIT(TRIM(REGEXP_REPLACE(LOWER(%s), %s, ' ')), ' ')) AS t WHERE t != ''), FALSE)",
+			normalized, search, QuoteStringLiteral(delimiterPattern(append(delimiters, "`"))),
+		), nil
+	}
+	terms, err := searchTerms(literal, regexp.MustCompile(pattern))
+	if err != nil {
+		return "", err
+	}
+	if len(terms) == 0 {
+		return "FALSE", nil
+	}
+	conds := make([]string, 0, len(terms))
+	for _, term := range terms {
+		conds = append(conds, fmt.Sprintf("LOGICAL_OR(STRPOS(v, %s) > 0)", QuoteStringLiteral(" "+term+" ")))
+	}
+	return fmt.Sprintf("IFNULL((SELECT %s FROM %s), FALSE)", strings.Join(conds, " AND "), normalized), nil
+}
+
+// searchDelimiters returns the delimiters of LOG_ANALYZER specified by analyzer_options.
+func searchDelimiters(analyzerOptions string) ([]string, error) {
+	if analyzerOptions == "" {
+		return logAnalyzerDelimiters, nil
+	}
+	var options struct {
+		Delimiters   []string    `json:"delimiters"`
+		TokenFilters interface{} `json:"token_filters"`
+	}
+	if err := json.Unmarshal([]byte(analyzerOptions), &options); err != nil {
+		return nil, fmt.Errorf("SEARCH: invalid analyzer_options: %w", err)
+	}
+	if options.TokenFilters != nil {
+		return nil, fmt.Errorf("SEARCH: token_filters of analyzer_options is not supported by the emulator")
+	}
+	if options.Delimiters == nil {
+		return logAnalyzerDelimiters, nil
+	}
+	return options.Delimiters, nil
+}
+
+// delimiterPattern returns the regular expression that matches the sequence of the delimiters.
+func delimiterPattern(delimiters []string) string {
+	var (
+		sorted = make([]string, 0, len(delimiters))
+		seen   = map[string]bool{}
+	)
+	for _, delimiter := range delimiters {
+		// the text is lowercased before it is split.
+		delimiter = strings.ToLower(delimiter)
+		if delimiter == "" || seen[delimiter] {
+			continue
+		}
+		seen[delimiter] = true
+		sorted = append(sorted, regexp.QuoteMeta(delimiter))
+	}
+	if len(sorted) == 0 {
+		// no delimiter splits the text, so the pattern must not match anything.
+		return `[^\x00-\x{10FFFF}]`
+	}
+	// the longer delimiter must be tried first, e.g. %2526 before %25.
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	return fmt.Sprintf("(?:%s)+", strings.Join(sorted, "|"))
+}
+
+// searchTerms splits search_query into the terms by LOG_ANALYZER.
+// The text enclosed in backticks is a phrase, its tokens are joined by a space.
+func searchTerms(search string, delimiter *regexp.Regexp) ([]string, error) {
+	parts := strings.Split(strings.ToLower(search), "`")
+	if len(parts)%2 == 0 {
+		return nil, fmt.Errorf("SEARCH: search_query has an unclosed backtick")
+	}
+	var terms []string
+	for i, part := range parts {
+		var tokens []string
+		for _, tk := range delimiter.Split(part, -1) {
+			if tk != "" {
+				tokens = append(tokens, tk)
+			}
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		if i%2 == 1 {
+			terms = append(terms, strings.Join(tokens, " "))
+			continue
+		}
+		terms = append(terms, tokens...)
+	}
+	return terms, nil
+}
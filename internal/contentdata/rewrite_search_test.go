@@ -0,0 +1,34 @@
+package contentdata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContainsSubstr(t *testing.T) {
+	got, err := rewriteQuery("SELECT CONTAINS_SUBSTR('abc', 'B')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"SELECT IF(('abc') IS NULL, NULL, (SELECT IF(LOGICAL_OR(",
+		"STRPOS(NORMALIZE_AND_CASEFOLD(v, NFKC), NORMALIZE_AND_CASEFOLD('B', NFKC)) > 0",
+		"TO_JSON_STRING('abc')",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Fatalf("expected %q in the rewritten query: %s", expected, got)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:  "no op analyzer",
+			query: "SELECT SEARCH(s, 'foo', analyzer => 'NO_OP_ANALYZER') FROM t",
+			expected: `SELECT IFNULL((SELECT LOGICAL_OR(s = 'foo') FROM UNNEST(ARRAY_CONCAT(ARRAY(SELECT JSON_VALUE(leaf, '$') ` +
+				`FROM UNNEST(REGEXP_EXTRACT_ALL(REGEXP_REPLACE(TO_JSON_STRING(s), '"(?:[^"\\\\]|\\\\.)*"\\s*:', ''), ` +
+				`'"(?:[^"\\\\]|\\\\.)*"')) AS leaf))) AS s), FALSE) FROM t`,
+		},
+	})
+}
@@ -0,0 +1,104 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stringFunctionEdits replaces SUBSTR, SUBSTRING, LPAD, RPAD and REPEAT calls with the expressions of LEFT, RIGHT and LENGTH,
+// because go-zetasqlite slices the bytes of STRING by the character positions in SUBSTR,
+// panics on the BYTES pattern longer than the padding and on the negative lengths.
+// LEFT, RIGHT and LENGTH count the characters of STRING and the bytes of BYTES,
+// so the expressions don't depend on the type of the value.
+//   - SUBSTR starts at the position counted from the end if the position is negative,
+//     and at the first character if the position is 0 or before the first character.
+//   - LPAD and RPAD repeat the pattern as many times as needed, and truncate the value longer than the return length.
+//   - The negative lengths and repetitions and the empty pattern are errors like BigQuery.
+func stringFunctionEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		switch name {
+		case "SUBSTR", "SUBSTRING", "LPAD", "RPAD", "REPEAT":
+		default:
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx >= len(tokens) || !tokens[closeIdx].isSymbol(")") {
+			continue
+		}
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) != 2 && !(len(args) == 3 && name != "REPEAT") {
+			continue
+		}
+		for i, arg := range args {
+			// rewrite the nested calls like SUBSTR(LPAD(s, 10, '0'), -3).
+			argEdits, err := stringFunctionEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = fmt.Sprintf("(%s)", applyEdits(arg, argEdits))
+		}
+		var expr string
+		switch name {
+		case "SUBSTR", "SUBSTRING":
+			expr = substrExpr(name, args)
+		case "LPAD", "RPAD":
+			expr = padExpr(name, args)
+		case "REPEAT":
+			expr = fmt.Sprintf("IF(%[2]s < 0, ERROR('REPEAT: repetitions cannot be negative'), REPEAT(%[1]s, %[2]s))", args[0], args[1])
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+// substrExpr returns the expression taking the characters of STRING or the bytes of BYTES after the 1-based position.
+func substrExpr(name string, args []string) string {
+	value, pos := args[0], args[1]
+	length := fmt.Sprintf("LENGTH(%s)", value)
+	start := fmt.Sprintf("GREATEST(0, LEAST(%[1]s, IF(%[2]s > 0, %[2]s - 1, IF(%[2]s < 0, %[1]s + %[2]s, 0))))", length, pos)
+	rest := fmt.Sprintf("RIGHT(%s, %s - %s)", value, length, start)
+	if len(args) == 2 {
+		return fmt.Sprintf("IF(%s IS NULL OR %s IS NULL, NULL, %s)", value, pos, rest)
+	}
+	return fmt.Sprintf(
+		"CASE WHEN %[1]s IS NULL OR %[2]s IS NULL OR %[3]s IS NULL THEN NULL "+
+			"WHEN %[3]s < 0 THEN ERROR('%[5]s: length cannot be negative') ELSE LEFT(%[4]s, %[3]s) END",
+		value, pos, args[2], rest, name,
+	)
+}
+
+// padExpr returns the expression padding the value to the return length by LPAD or RPAD.
+// go-zetasqlite pads with the spaces correctly, so the call without the pattern is kept.
+func padExpr(name string, args []string) string {
+	value, returnLength := args[0], args[1]
+	if len(args) == 2 {
+		return fmt.Sprintf(
+			"IF(%[2]s < 0, ERROR('%[3]s: return_length cannot be negative'), %[3]s(%[1]s, %[2]s))",
+			value, returnLength, name,
+		)
+	}
+	pattern := args[2]
+	remain := fmt.Sprintf("%s - LENGTH(%s)", returnLength, value)
+	padding := fmt.Sprintf("LEFT(REPEAT(%[1]s, DIV(%[2]s, LENGTH(%[1]s)) + 1), %[2]s)", pattern, remain)
+	padded := fmt.Sprintf("CONCAT(%s, %s)", padding, value)
+	if name == "RPAD" {
+		padded = fmt.Sprintf("CONCAT(%s, %s)", value, padding)
+	}
+	return fmt.Sprintf(
+		"CASE WHEN %[1]s IS NULL OR %[2]s IS NULL OR %[3]s IS NULL THEN NULL "+
+			"WHEN %[2]s < 0 THEN ERROR('%[5]s: return_length cannot be negative') "+
+			"WHEN LENGTH(%[3]s) = 0 THEN ERROR('%[5]s: pattern cannot be empty') "+
+			"WHEN LENGTH(%[1]s) >= %[2]s THEN LEFT(%[1]s, %[2]s) ELSE %[4]s END",
+		value, returnLength, pattern, padded, name,
+	)
+}
@@ -0,0 +1,24 @@
+package contentdata
+
+import "testing"
+
+func TestStringFunctions(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:  "substr with length",
+			query: "SELECT SUBSTR(s, 2, 3) FROM t",
+			expected: "SELECT CASE WHEN (s) IS NULL OR (2) IS NULL OR (3) IS NULL THEN NULL WHEN (3) < 0 THEN ERROR('SUBSTR: length cannot be negative') " +
+				"ELSE LEFT(RIGHT((s), LENGTH((s)) - GREATEST(0, LEAST(LENGTH((s)), IF((2) > 0, (2) - 1, IF((2) < 0, LENGTH((s)) + (2), 0))))), (3)) END FROM t",
+		},
+		{
+			name:     "repeat",
+			query:    "SELECT REPEAT(s, 3) FROM t",
+			expected: "SELECT IF((3) < 0, ERROR('REPEAT: repetitions cannot be negative'), REPEAT((s), (3))) FROM t",
+		},
+		{
+			name:     "rpad without pattern",
+			query:    "SELECT RPAD('ab', 5) FROM t",
+			expected: "SELECT IF((5) < 0, ERROR('RPAD: return_length cannot be negative'), RPAD(('ab'), (5))) FROM t",
+		},
+	})
+}
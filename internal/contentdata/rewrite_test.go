@@ -0,0 +1,71 @@
+package contentdata
+
+import (
+	"strings"
+	"testing"
+)
+
+type rewriteQueryTest struct {
+	name        string
+	query       string
+	expected    string
+	expectedErr string
+}
+
+// testRewriteQuery rewrites the query of each test and compares the rewritten query or the error.
+func testRewriteQuery(t *testing.T, tests []rewriteQueryTest) {
+	t.Helper()
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got, err := rewriteQuery(test.query)
+			if test.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+					t.Fatalf("expected error %q but got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.expected {
+				t.Fatalf("failed to rewrite query:\nexpected: %s\ngot:      %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestGroupByAndOrderByAll(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "query without rewrites",
+			query:    "SELECT * FROM t",
+			expected: "SELECT * FROM t",
+		},
+		{
+			name:     "group by all and order by all",
+			query:    "SELECT a, COUNT(*) FROM t GROUP BY ALL ORDER BY ALL",
+			expected: "SELECT a, COUNT(*) FROM t GROUP BY 1 ORDER BY 1, 2",
+		},
+		{
+			name:     "group by all skips aggregates and constants",
+			query:    "SELECT a, 1 AS one, b, SUM(x) FROM t GROUP BY ALL",
+			expected: "SELECT a, 1 AS one, b, SUM(x) FROM t GROUP BY 1, 3",
+		},
+		{
+			name:     "order by all descending",
+			query:    "SELECT a, b FROM t ORDER BY ALL DESC",
+			expected: "SELECT a, b FROM t ORDER BY 1 DESC, 2 DESC",
+		},
+		{
+			name:     "keywords in string literal",
+			query:    "SELECT 'GROUP BY ALL' AS s FROM t",
+			expected: "SELECT 'GROUP BY ALL' AS s FROM t",
+		},
+		{
+			name:     "keywords in comment",
+			query:    "SELECT a -- ORDER BY ALL\nFROM t",
+			expected: "SELECT a -- ORDER BY ALL\nFROM t",
+		},
+	})
+}
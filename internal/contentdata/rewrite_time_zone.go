@@ -0,0 +1,164 @@
+package contentdata
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	utcOffsetTimeZonePattern = regexp.MustCompile(`(?i)^(?:UTC|GMT)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+	etcGMTTimeZonePattern    = regexp.MustCompile(`(?i)^Etc/GMT([+-])(\d{1,2})$`)
+)
+
+// timeZoneArgIndexes are the positions of the time zone argument of the functions by the number of the arguments.
+var timeZoneArgIndexes = map[string]map[int]int{
+	"TIMESTAMP":        {2: 1},
+	"DATETIME":         {2: 1},
+	"DATE":             {2: 1},
+	"TIME":             {2: 1},
+	"STRING":           {2: 1},
+	"FORMAT_TIMESTAMP": {3: 2},
+	"PARSE_TIMESTAMP":  {3: 2},
+	"TIMESTAMP_TRUNC":  {3: 2},
+	"CURRENT_DATE":     {1: 0},
+	"CURRENT_DATETIME": {1: 0},
+	"CURRENT_TIME":     {1: 0},
+}
+
+// timeZoneEdits rewrites the time zone conversions that go-zetasqlite evaluates differently from BigQuery.
+//   - the time zone literals are normalized to the IANA time zone name or the `+HH:MM` offset,
+//     so that the offsets like `UTC+3` or `-8` and the `Etc/GMT+N` names are accepted.
+//     The abbreviations like `PST` aren't the time zone of BigQuery and are reported as the error.
+//   - TIMESTAMP(datetime, time_zone) of the nonexistent local time in the daylight saving time gap
+//     uses the offset before the transition, so `2024-03-10 02:30:00` in America/Los_Angeles is `10:30:00+00`.
+//     The ambiguous local time is the first occurrence in both go-zetasqlite and BigQuery.
+//   - STRING(timestamp, time_zone) is formatted with the offset of the time zone instead of `+00`.
+func timeZoneEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.isKeyword("AT") && idx+3 < len(tokens) && tokens[idx+1].isKeyword("TIME") && tokens[idx+2].isKeyword("ZONE") &&
+			tokens[idx+3].kind == tokenString && !tokens[idx+3].isBytesLiteral() {
+			edit, err := timeZoneLiteralEdit(tokens[idx+3])
+			if err != nil {
+				return nil, err
+			}
+			edits = append(edits, edit)
+			idx += 3
+			continue
+		}
+		if tk.kind != tokenWord || idx+1 >= len(tokens) || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		argIndexes, exists := timeZoneArgIndexes[name]
+		if !exists {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		zoneIdx, exists := argIndexes[len(args)]
+		if !exists {
+			continue
+		}
+		for i, arg := range args {
+			// rewrite the nested calls like DATETIME(TIMESTAMP(dt, 'UTC+3'), 'Asia/Tokyo').
+			argEdits, err := timeZoneEdits(arg, tokenize(arg))
+			if err != nil {
+				return nil, err
+			}
+			args[i] = applyEdits(arg, argEdits)
+		}
+		zoneTokens := tokenize(args[zoneIdx])
+		if len(zoneTokens) == 1 && zoneTokens[0].kind == tokenString && !zoneTokens[0].isBytesLiteral() {
+			v, _ := stringLiteralValue(zoneTokens[0])
+			zone, err := normalizeTimeZone(v)
+			switch {
+			case err == nil:
+				args[zoneIdx] = QuoteStringLiteral(zone)
+			case name == "DATETIME":
+				// DATETIME(date, time) takes the time literal as the second argument.
+			default:
+				return nil, err
+			}
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: timeZoneExpr(name, tk.text, args)})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+func timeZoneLiteralEdit(tk *token) (*edit, error) {
+	v, _ := stringLiteralValue(tk)
+	zone, err := normalizeTimeZone(v)
+	if err != nil {
+		return nil, err
+	}
+	return &edit{start: tk.start, end: tk.end, replacement: QuoteStringLiteral(zone)}, nil
+}
+
+const (
+	timestampInZoneFunction = "bqemulator_timestamp_in_zone"
+	stringInZoneFunction    = "bqemulator_string_in_zone"
+)
+
+// timestampInZoneFunctionDefinition is TIMESTAMP(value, zone) moved forward by the length of the gap
+// if the local time doesn't exist: the difference between the local time and the local time of
+// the converted timestamp is the length of the gap, otherwise zero.
+// The function binds the arguments, so each of them is evaluated once.
+const timestampInZoneFunctionDefinition = "CREATE TEMP FUNCTION " + timestampInZoneFunction + "(value ANY TYPE, zone STRING) AS (" +
+	"TIMESTAMP_ADD(TIMESTAMP(value, zone), INTERVAL IFNULL(" +
+	"UNIX_MICROS(TIMESTAMP(SAFE_CAST(value AS DATETIME), 'UTC')) - UNIX_MICROS(TIMESTAMP(DATETIME(TIMESTAMP(value, zone), zone), 'UTC')), 0" +
+	") MICROSECOND));\n"
+
+// stringInZoneFunctionDefinition is STRING(value, zone) formatted with the offset of the time zone instead of `+00`.
+const stringInZoneFunctionDefinition = "CREATE TEMP FUNCTION " + stringInZoneFunction + "(value TIMESTAMP, zone STRING) AS (" +
+	"CONCAT(REGEXP_REPLACE(STRING(value, zone), r'\\+00$', ''), REGEXP_REPLACE(FORMAT_TIMESTAMP('%Ez', value, zone), r':00$', '')));\n"
+
+func timeZoneExpr(name, funcName string, args []string) string {
+	switch {
+	case name == "TIMESTAMP" && len(args) == 2:
+		return fmt.Sprintf("%s(%s, %s)", timestampInZoneFunction, args[0], args[1])
+	case name == "STRING" && len(args) == 2:
+		return fmt.Sprintf("%s(%s, %s)", stringInZoneFunction, args[0], args[1])
+	}
+	return fmt.Sprintf("%s(%s)", funcName, strings.Join(args, ", "))
+}
+
+// normalizeTimeZone returns the time zone name that go-zetasqlite can load.
+// BigQuery accepts the IANA time zone names and the offsets from UTC like `+05:30`, `-8` or `UTC+3`.
+func normalizeTimeZone(zone string) (string, error) {
+	if strings.EqualFold(zone, "UTC") {
+		return "UTC", nil
+	}
+	if matched := utcOffsetTimeZonePattern.FindStringSubmatch(zone); matched != nil {
+		return timeZoneOffset(zone, matched[1], matched[2], matched[3])
+	}
+	if matched := etcGMTTimeZonePattern.FindStringSubmatch(zone); matched != nil {
+		// the sign of Etc/GMT+N is inverted, Etc/GMT+10 is 10 hours behind UTC.
+		sign := "-"
+		if matched[1] == "-" {
+			sign = "+"
+		}
+		return timeZoneOffset(zone, sign, matched[2], "")
+	}
+	if _, err := time.LoadLocation(zone); err != nil || zone == "" || strings.EqualFold(zone, "Local") {
+		return "", fmt.Errorf("Invalid time zone: %s", zone)
+	}
+	return zone, nil
+}
+
+func timeZoneOffset(zone, sign, hour, minute string) (string, error) {
+	h, _ := strconv.Atoi(hour)
+	m, _ := strconv.Atoi(minute)
+	if h > 14 || m > 59 {
+		return "", fmt.Errorf("Invalid time zone: %s", zone)
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, h, m), nil
+}
@@ -0,0 +1,23 @@
+package contentdata
+
+import "testing"
+
+func TestTimeZone(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "timestamp of datetime in time zone",
+			query:    "SELECT TIMESTAMP(dt, 'America/New_York') FROM t",
+			expected: "SELECT bqemulator_timestamp_in_zone(dt, 'America/New_York') FROM t",
+		},
+		{
+			name:     "datetime of timestamp in offset",
+			query:    "SELECT DATETIME(ts, '+09:00') FROM t",
+			expected: "SELECT DATETIME(ts, '+09:00') FROM t",
+		},
+		{
+			name:     "date of timestamp in time zone",
+			query:    "SELECT DATE(ts, 'America/New_York') FROM t",
+			expected: "SELECT DATE(ts, 'America/New_York') FROM t",
+		},
+	})
+}
@@ -0,0 +1,423 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
+// timestampStringFunction is the temporary JavaScript function normalizing the string converted into TIMESTAMP.
+// It returns `YYYY-MM-DD HH:MM:SS.FFFFFF` of the local time followed by ` zone` if the string has the time zone,
+// or NULL if the string is not the timestamp of BigQuery. The offsets and `Z` are applied to the local time
+// and returned as UTC, because go-zetasqlite adds the minutes of the negative offsets like `-03:30` to the hours.
+const timestampStringFunction = "bqemulator_timestamp_string"
+
+// timestampStringFunctionDefinition accepts `YYYY-[M]M-[D]D[( |T)[H]H:[M]M:[S]S[.F]][time_zone]` where F is up to 6 digits,
+// and time_zone is `Z`, `{+|-}H[H][[:]MM]` or the time zone name following a space.
+const timestampStringFunctionDefinition = "CREATE TEMP FUNCTION " + timestampStringFunction +
+	"(value STRING) RETURNS STRING LANGUAGE js AS r\"\"\"\n" +
+	`if (value === null || value === undefined) {
+  return null;
+}
+var m = /^\s*(\d{4})-(\d{1,2})-(\d{1,2})(?:(?:[Tt]|\s+)(\d{1,2}):(\d{1,2}):(\d{1,2})(?:\.(\d+))?)?(?:\s*([Zz])|\s*([+-])(\d{1,2})(?::?(\d{2}))?|\s+([A-Za-z_][A-Za-z0-9_+\-\/]*))?\s*$/.exec(value);
+if (m === null || (m[7] || "").length > 6) {
+  return null;
+}
+var year = +m[1], month = +m[2], day = +m[3];
+var hour = +(m[4] || 0), minute = +(m[5] || 0), second = +(m[6] || 0);
+var d = new Date(0);
+d.setUTCFullYear(year, month - 1, day);
+d.setUTCHours(hour, minute, second, 0);
+if (year < 1 || d.getUTCFullYear() !== year || d.getUTCMonth() !== month - 1 || d.getUTCDate() !== day ||
+    hour > 23 || minute > 59 || second > 59) {
+  return null;
+}
+var zone = m[12] || "";
+if (m[8]) {
+  zone = "UTC";
+}
+if (m[9]) {
+  var offsetHour = +m[10], offsetMinute = +(m[11] || 0);
+  if (offsetHour > 14 || offsetMinute > 59) {
+    return null;
+  }
+  var offset = (offsetHour * 60 + offsetMinute) * 60000;
+  d.setTime(d.getTime() + (m[9] === "+" ? -offset : offset));
+  if (d.getUTCFullYear() < 1 || d.getUTCFullYear() > 9999) {
+    return null;
+  }
+  zone = "UTC";
+}
+var pad = function(n, width) {
+  var s = String(n);
+  while (s.length < width) {
+    s = "0" + s;
+  }
+  return s;
+};
+var fraction = m[7] || "";
+while (fraction.length < 6) {
+  fraction += "0";
+}
+return pad(d.getUTCFullYear(), 4) + "-" + pad(d.getUTCMonth() + 1, 2) + "-" + pad(d.getUTCDate(), 2) + " " +
+  pad(d.getUTCHours(), 2) + ":" + pad(d.getUTCMinutes(), 2) + ":" + pad(d.getUTCSeconds(), 2) + "." + fraction +
+  (zone === "" ? "" : " " + zone);
+` + "\"\"\";\n"
+
+// timestampStringZonePattern is the time zone at the end of the string parsed by PARSE_TIMESTAMP with %z, %Ez or %Z.
+const timestampStringZonePattern = `(?:[Zz]|[+-]\d{1,2}(?::?\d{2})?|[A-Za-z_][A-Za-z0-9_+\-/]*)`
+
+// timestampStringValue, timestampStringNormalized, timestampStringLocal and timestampStringZone are the aliases of
+// the string converted into TIMESTAMP, the result of timestampStringFunction, its local time and its time zone
+// in the subquery of normalizedTimestampExpr.
+const (
+	timestampStringValue      = "bqemulator_timestamp_value"
+	timestampStringNormalized = "bqemulator_timestamp_normalized"
+	timestampStringLocal      = "bqemulator_timestamp_local"
+	timestampStringZone       = "bqemulator_timestamp_zone"
+)
+
+// timestampStringEdits rewrites the conversions from the string into TIMESTAMP that go-zetasqlite evaluates differently
+// from BigQuery: `CAST(x AS TIMESTAMP)`, `SAFE_CAST(x AS TIMESTAMP)`, `TIMESTAMP(x [, time_zone])`,
+// and `PARSE_TIMESTAMP(format, x [, time_zone])` of the format ending with %z, %Ez or %Z.
+// go-zetasqlite doesn't accept the single digit fields and the offsets like `-8:00`, treats the unknown
+// time zone names as UTC, and keeps more than 6 fractional digits. The string is normalized by timestampStringFunction
+// and converted by TIMESTAMP with the time zone of the string or time_zone, which is UTC by default.
+// CAST and TIMESTAMP are rewritten only if x is STRING by its syntax, and the others are left to go-zetasqlite.
+// Repository.timestampStrings casts the columns and the other expressions of STRING to STRING before the rewrite.
+func timestampStringEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx >= len(tokens) {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		var (
+			expr string
+			err  error
+		)
+		switch name {
+		case "CAST", "SAFE_CAST":
+			expr, err = castTimestampExpr(query, tokens, name, idx+1, closeIdx)
+		case "TIMESTAMP":
+			expr, err = timestampFunctionExpr(functionArgs(query, tokens, idx+1, closeIdx))
+		case "PARSE_TIMESTAMP":
+			expr, err = parseTimestampExpr(functionArgs(query, tokens, idx+1, closeIdx))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if expr == "" {
+			continue
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+// castTimestampExpr returns the expression of `CAST(x AS TIMESTAMP)`, or empty string if the cast is not into TIMESTAMP,
+// has the FORMAT clause or x is not STRING.
+func castTimestampExpr(query string, tokens []*token, name string, openIdx, closeIdx int) (string, error) {
+	asIdx := castTimestampAs(tokens, openIdx, closeIdx)
+	if asIdx < 0 || !isTimestampStringArg(tokens[openIdx+1:asIdx]) {
+		return "", nil
+	}
+	value, err := rewrittenTimestampArg(tokenText(query, tokens[openIdx+1:asIdx]))
+	if err != nil {
+		return "", err
+	}
+	invalid := fmt.Sprintf(`ERROR(CONCAT("Invalid timestamp: '", %s, "'"))`, timestampStringValue)
+	if name == "SAFE_CAST" {
+		invalid = "CAST(NULL AS TIMESTAMP)"
+	}
+	normalized := fmt.Sprintf("%s(%s)", timestampStringFunction, timestampStringValue)
+	return normalizedTimestampExpr(value, normalized, "'UTC'", invalid), nil
+}
+
+// castTimestampAs returns the index of AS of `CAST(x AS TIMESTAMP)` between tokens[openIdx] and tokens[closeIdx],
+// or -1 if the cast is not into TIMESTAMP or has the FORMAT clause.
+func castTimestampAs(tokens []*token, openIdx, closeIdx int) int {
+	depth := tokens[openIdx].depth + 1
+	asIdx := -1
+	for idx := openIdx + 1; idx < closeIdx; idx++ {
+		if tokens[idx].depth == depth && tokens[idx].isKeyword("AS") {
+			asIdx = idx
+		}
+	}
+	if asIdx < 0 || asIdx+2 != closeIdx || !tokens[asIdx+1].isKeyword("TIMESTAMP") {
+		return -1
+	}
+	return asIdx
+}
+
+// timestampFunctionExpr returns the expression of `TIMESTAMP(x [, time_zone])`, or empty string if x is not STRING.
+func timestampFunctionExpr(args []string) (string, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return "", nil
+	}
+	if !isTimestampStringArg(tokenize(args[0])) {
+		return "", nil
+	}
+	value, err := rewrittenTimestampArg(args[0])
+	if err != nil {
+		return "", err
+	}
+	zone := "'UTC'"
+	if len(args) == 2 {
+		if zone, err = timestampZoneArg(args[1]); err != nil {
+			return "", err
+		}
+	}
+	invalid := fmt.Sprintf(`ERROR(CONCAT("Invalid timestamp: '", %s, "'"))`, timestampStringValue)
+	normalized := fmt.Sprintf("%s(%s)", timestampStringFunction, timestampStringValue)
+	return normalizedTimestampExpr(value, normalized, zone, invalid), nil
+}
+
+// parseTimestampExpr returns the expression of `PARSE_TIMESTAMP(format, x [, time_zone])` if the format literal ends
+// with the time zone element, which go-zetasqlite doesn't parse except %Ez of `+HH:MM`.
+// The local time is parsed by PARSE_DATETIME without the time zone, and the time zone is appended to it.
+func parseTimestampExpr(args []string) (string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", nil
+	}
+	formatTokens := tokenize(args[0])
+	if len(formatTokens) != 1 || formatTokens[0].kind != tokenString || formatTokens[0].isBytesLiteral() {
+		return "", nil
+	}
+	format, _ := stringLiteralValue(formatTokens[0])
+	var localFormat string
+	for _, element := range []string{"%Ez", "%z", "%Z"} {
+		if strings.HasSuffix(format, element) && !strings.HasSuffix(format, "%"+element) {
+			localFormat = strings.TrimSuffix(format, element)
+			break
+		}
+	}
+	if localFormat == "" || (&selectItem{tokens: tokenize(args[1])}).isAggregateOrAnalytic() {
+		return "", nil
+	}
+	value, err := rewrittenTimestampArg(args[1])
+	if err != nil {
+		return "", err
+	}
+	zone := "'UTC'"
+	if len(args) == 3 {
+		if zone, err = timestampZoneArg(args[2]); err != nil {
+			return "", err
+		}
+	}
+	normalized := fmt.Sprintf(
+		"%[1]s(CONCAT(FORMAT_DATETIME('%%Y-%%m-%%d %%H:%%M:%%E6S', PARSE_DATETIME(%[2]s, REGEXP_REPLACE(%[3]s, r'\\s*%[4]s$', ''))), ' ', REGEXP_EXTRACT(%[3]s, r'(%[4]s)$')))",
+		timestampStringFunction, QuoteStringLiteral(localFormat), timestampStringValue, timestampStringZonePattern,
+	)
+	invalid := fmt.Sprintf(`ERROR(CONCAT('Failed to parse input string "', %s, '"'))`, timestampStringValue)
+	return normalizedTimestampExpr(value, normalized, zone, invalid), nil
+}
+
+// normalizedTimestampExpr returns the TIMESTAMP of the result of timestampStringFunction,
+// or invalid if the value isn't NULL but the result is NULL.
+// The value, the result and its parts are evaluated once in the nested subqueries,
+// where normalized and invalid refer to the value as timestampStringValue.
+func normalizedTimestampExpr(value, normalized, zone, invalid string) string {
+	return fmt.Sprintf(
+		"(SELECT IF(%[1]s IS NULL AND %[2]s IS NOT NULL, %[3]s, TIMESTAMP(%[4]s, IFNULL(%[5]s, %[6]s))) "+
+			"FROM (SELECT %[2]s, %[1]s, SUBSTR(%[1]s, 1, 26) AS %[4]s, NULLIF(SUBSTR(%[1]s, 28), '') AS %[5]s "+
+			"FROM (SELECT %[2]s, %[7]s AS %[1]s FROM (SELECT %[8]s AS %[2]s))))",
+		timestampStringNormalized, timestampStringValue, invalid, timestampStringLocal, timestampStringZone,
+		zone, normalized, value,
+	)
+}
+
+// timestampStringFuncNames are the functions returning STRING whose results are converted into TIMESTAMP.
+var timestampStringFuncNames = map[string]struct{}{
+	"CONCAT": {}, "FORMAT": {}, "STRING": {}, "SUBSTR": {}, "SUBSTRING": {}, "REPLACE": {}, "TRIM": {}, "LTRIM": {},
+	"RTRIM": {}, "LOWER": {}, "UPPER": {}, "LPAD": {}, "RPAD": {}, "JSON_VALUE": {}, "JSON_EXTRACT_SCALAR": {},
+	"REGEXP_EXTRACT": {}, "REGEXP_REPLACE": {}, "FORMAT_DATE": {}, "FORMAT_DATETIME": {}, "FORMAT_TIMESTAMP": {},
+}
+
+// timestampArgType reports whether the argument of CAST or TIMESTAMP is STRING, and whether its type is known
+// by its syntax: the literals, the typed literals, CAST and the functions of timestampStringFuncNames.
+func timestampArgType(tokens []*token) (isString bool, known bool) {
+	if len(tokens) == 0 {
+		return false, true
+	}
+	first, last := tokens[0], tokens[len(tokens)-1]
+	switch {
+	case len(tokens) == 1 && first.kind == tokenString:
+		return !first.isBytesLiteral(), true
+	case len(tokens) == 1 && (first.kind == tokenNumber || first.isKeyword("NULL") || first.isKeyword("TRUE") || first.isKeyword("FALSE")):
+		return false, true
+	case len(tokens) == 2 && first.kind == tokenWord && tokens[1].kind == tokenString:
+		// the typed literals like DATE '2023-01-01'.
+		return false, true
+	}
+	if first.kind != tokenWord || len(tokens) < 3 || !tokens[1].isSymbol("(") || skipParen(tokens, 1) != len(tokens)-1 || !last.isSymbol(")") {
+		return false, false
+	}
+	name := strings.ToUpper(first.text)
+	switch name {
+	case "CAST", "SAFE_CAST":
+		depth := tokens[1].depth + 1
+		for idx := len(tokens) - 2; idx > 1; idx-- {
+			if tokens[idx].depth == depth && tokens[idx].isKeyword("AS") {
+				return idx == len(tokens)-3 && tokens[idx+1].isKeyword("STRING"), true
+			}
+		}
+		return false, false
+	case "CURRENT_TIMESTAMP", "CURRENT_DATETIME", "CURRENT_DATE", "DATE", "DATETIME", "TIMESTAMP", "PARSE_DATE",
+		"PARSE_DATETIME", "PARSE_TIMESTAMP":
+		return false, true
+	}
+	if _, exists := timestampStringFuncNames[name]; exists {
+		return true, true
+	}
+	return false, false
+}
+
+// isTimestampStringArg reports whether the argument is STRING by its syntax and has no aggregate or analytic function
+// calls that can't be evaluated in the subquery of normalizedTimestampExpr.
+func isTimestampStringArg(tokens []*token) bool {
+	isString, _ := timestampArgType(tokens)
+	return isString && !(&selectItem{tokens: tokens}).isAggregateOrAnalytic()
+}
+
+// timestampStringBlock is the query block that has CAST(x AS TIMESTAMP) or TIMESTAMP(x [, time_zone]) calls
+// whose arguments are not typed by their syntax, like the columns and the query parameters.
+type timestampStringBlock struct {
+	args []*timestampStringArg
+	// probe is the query that selects the arguments from the FROM clause of the block to check their types.
+	// It is empty for the arguments out of the query blocks like the SET clause of UPDATE.
+	probe string
+}
+
+// timestampStringArg is the position of the argument in the query.
+type timestampStringArg struct {
+	start int
+	end   int
+	text  string
+}
+
+// timestampStringBlocks returns the query blocks of query that convert the arguments of unknown types into TIMESTAMP.
+func timestampStringBlocks(query string) []*timestampStringBlock {
+	tokens := tokenize(query)
+	var (
+		blocks  []*timestampStringBlock
+		byStart = map[int]*timestampStringBlock{}
+		queries = map[*timestampStringBlock]*queryBlock{}
+	)
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") && (idx < 2 || !tokens[idx-2].isKeyword("SAFE")) {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx >= len(tokens) {
+			continue
+		}
+		var argTokens []*token
+		switch strings.ToUpper(tk.text) {
+		case "CAST", "SAFE_CAST":
+			if asIdx := castTimestampAs(tokens, idx+1, closeIdx); asIdx > idx+2 {
+				argTokens = tokens[idx+2 : asIdx]
+			}
+		case "TIMESTAMP":
+			end := idx + 2
+			for end < closeIdx && !(tokens[end].depth == tk.depth+1 && tokens[end].isSymbol(",")) {
+				end++
+			}
+			argTokens = tokens[idx+2 : end]
+		}
+		if len(argTokens) == 0 || (&selectItem{tokens: argTokens}).isAggregateOrAnalytic() {
+			continue
+		}
+		if _, known := timestampArgType(argTokens); known {
+			continue
+		}
+		// the nested calls in the argument are found in the next iterations.
+		arg := &timestampStringArg{
+			start: argTokens[0].start,
+			end:   argTokens[len(argTokens)-1].end,
+			text:  tokenText(query, argTokens),
+		}
+		start := -1
+		block := findQueryBlock(tokens, idx)
+		if block != nil {
+			start = block.start
+		}
+		stringBlock, exists := byStart[start]
+		if !exists {
+			stringBlock = &timestampStringBlock{}
+			byStart[start] = stringBlock
+			queries[stringBlock] = block
+			blocks = append(blocks, stringBlock)
+		}
+		stringBlock.args = append(stringBlock.args, arg)
+	}
+	for _, block := range blocks {
+		if queries[block] == nil {
+			continue
+		}
+		texts := make([]string, 0, len(block.args))
+		for _, arg := range block.args {
+			texts = append(texts, arg.text)
+		}
+		block.probe = queries[block].probe(query, tokens, strings.Join(texts, ", "))
+	}
+	return blocks
+}
+
+// edits casts the arguments of STRING to STRING, so that timestampStringEdits rewrites their conversions.
+// Every argument is cast if fields is nil because the probe failed or the block has no probe,
+// since the conversions of the other types through their string representation give the same results.
+func (b *timestampStringBlock) edits(fields []*bigqueryv2.TableFieldSchema) []*edit {
+	if fields != nil && len(fields) != len(b.args) {
+		return nil
+	}
+	var edits []*edit
+	for idx, arg := range b.args {
+		if fields != nil && (fields[idx].Mode == "REPEATED" || fields[idx].Type != "STRING") {
+			continue
+		}
+		edits = append(edits,
+			&edit{start: arg.start, end: arg.start, replacement: "CAST("},
+			&edit{start: arg.end, end: arg.end, replacement: " AS STRING)"},
+		)
+	}
+	return edits
+}
+
+// rewrittenTimestampArg rewrites the nested conversions like TIMESTAMP(CAST(x AS TIMESTAMP)) in the argument.
+func rewrittenTimestampArg(arg string) (string, error) {
+	edits, err := timestampStringEdits(arg, tokenize(arg))
+	if err != nil {
+		return "", err
+	}
+	return applyEdits(arg, edits), nil
+}
+
+// timestampZoneArg normalizes the time zone literal in the same way as timeZoneEdits,
+// because the argument is not the direct argument of TIMESTAMP after the rewrite.
+func timestampZoneArg(arg string) (string, error) {
+	zoneTokens := tokenize(arg)
+	if len(zoneTokens) != 1 || zoneTokens[0].kind != tokenString || zoneTokens[0].isBytesLiteral() {
+		return arg, nil
+	}
+	v, _ := stringLiteralValue(zoneTokens[0])
+	zone, err := normalizeTimeZone(v)
+	if err != nil {
+		return "", err
+	}
+	return QuoteStringLiteral(zone), nil
+}
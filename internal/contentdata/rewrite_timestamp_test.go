@@ -0,0 +1,33 @@
+package contentdata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTimestampString(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "timestamp of column",
+			query:    "SELECT TIMESTAMP(s) FROM t",
+			expected: "SELECT TIMESTAMP(s) FROM t",
+		},
+		{
+			name:     "cast to timestamp",
+			query:    "SELECT CAST(s AS TIMESTAMP) FROM t",
+			expected: "SELECT CAST(s AS TIMESTAMP) FROM t",
+		},
+	})
+	got, err := rewriteQuery("SELECT TIMESTAMP('2024-01-01T00:00:00+09:00')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`ERROR(CONCAT("Invalid timestamp: '", bqemulator_timestamp_value, "'"))`,
+		"bqemulator_timestamp_string(bqemulator_timestamp_value) AS bqemulator_timestamp_normalized",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Fatalf("expected %q in the rewritten query: %s", expected, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// truncUnitMicros are the microseconds of the parts shorter than DAY.
+// HOUR is truncated in the time zone of TIMESTAMP_TRUNC because the time zone can have the offset of 30 minutes.
+var truncUnitMicros = map[string]int64{
+	"MICROSECOND": 1,
+	"MILLISECOND": 1000,
+	"SECOND":      1000 * 1000,
+	"MINUTE":      60 * 1000 * 1000,
+	"HOUR":        60 * 60 * 1000 * 1000,
+}
+
+// truncEdits replaces DATE_TRUNC, DATETIME_TRUNC, TIMESTAMP_TRUNC and TIME_TRUNC calls with the date arithmetic,
+// because go-zetasqlite truncates ISOWEEK, ISOYEAR, QUARTER, SECOND and MILLISECOND to the wrong values.
+//   - WEEK starts on Sunday, WEEK(<WEEKDAY>) starts on the weekday and ISOWEEK starts on Monday,
+//     so the week can start in the previous year.
+//   - ISOYEAR starts on Monday of the week that has January 4th.
+//   - TIMESTAMP_TRUNC truncates HOUR and the longer parts in the time zone argument ( UTC by default ),
+//     so DAY starts at the midnight of the local date even on the day of the daylight saving time.
+//
+// The calls with the part that the function doesn't support are evaluated by go-zetasqlite as is.
+func truncEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		switch name {
+		case "DATE_TRUNC", "DATETIME_TRUNC", "TIMESTAMP_TRUNC", "TIME_TRUNC":
+		default:
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) != 2 && !(len(args) == 3 && name == "TIMESTAMP_TRUNC") {
+			continue
+		}
+		// rewrite the nested calls like DATE_TRUNC(DATE_TRUNC(d, MONTH), WEEK).
+		argEdits, err := truncEdits(args[0], tokenize(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		value := applyEdits(args[0], argEdits)
+		part, weekday, ok := parseDatePart(args[1])
+		if !ok {
+			continue
+		}
+		zone := "'UTC'"
+		if len(args) == 3 {
+			zone = args[2]
+		}
+		expr, ok := truncExpr(name, value, part, weekday, zone)
+		if !ok {
+			continue
+		}
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
+
+func truncExpr(name, value, part string, weekday int, zone string) (string, bool) {
+	micros, subDay := truncUnitMicros[part]
+	switch name {
+	case "DATE_TRUNC":
+		return dateTruncExpr(value, part, weekday)
+	case "DATETIME_TRUNC":
+		if subDay {
+			return datetimeTruncMicrosExpr(value, micros), true
+		}
+		date, ok := dateTruncExpr(fmt.Sprintf("DATE(%s)", value), part, weekday)
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("DATETIME(%s)", date), true
+	case "TIME_TRUNC":
+		if !subDay {
+			return "", false
+		}
+		return fmt.Sprintf("TIME(%s)", datetimeTruncMicrosExpr(fmt.Sprintf("DATETIME(DATE '1970-01-01', %s)", value), micros)), true
+	case "TIMESTAMP_TRUNC":
+		if subDay && part != "HOUR" {
+			return fmt.Sprintf("TIMESTAMP_MICROS(%s * %d)", floorDivExpr(fmt.Sprintf("UNIX_MICROS(%s)", value), fmt.Sprint(micros)), micros), true
+		}
+		local := fmt.Sprintf("DATETIME(%s, %s)", value, zone)
+		if part == "HOUR" {
+			return fmt.Sprintf("TIMESTAMP(%s, %s)", datetimeTruncMicrosExpr(local, micros), zone), true
+		}
+		date, ok := dateTruncExpr(fmt.Sprintf("DATE(%s)", local), part, weekday)
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("TIMESTAMP(DATETIME(%s), %s)", date, zone), true
+	}
+	return "", false
+}
+
+// dateTruncExpr returns the expression truncating the DATE value to the part.
+func dateTruncExpr(value, part string, weekday int) (string, bool) {
+	switch part {
+	case "DAY":
+		return value, true
+	case "WEEK", "ISOWEEK":
+		return weekStartExpr(value, weekday), true
+	case "MONTH":
+		return fmt.Sprintf("DATE(EXTRACT(YEAR FROM %[1]s), EXTRACT(MONTH FROM %[1]s), 1)", value), true
+	case "QUARTER":
+		return fmt.Sprintf("DATE(EXTRACT(YEAR FROM %[1]s), DIV(EXTRACT(MONTH FROM %[1]s) - 1, 3) * 3 + 1, 1)", value), true
+	case "YEAR":
+		return fmt.Sprintf("DATE(EXTRACT(YEAR FROM %s), 1, 1)", value), true
+	case "ISOYEAR":
+		return weekStartExpr(fmt.Sprintf("DATE(EXTRACT(ISOYEAR FROM %s), 1, 4)", value), weekdays["MONDAY"]), true
+	}
+	return "", false
+}
+
+// weekStartExpr returns the first day of the week starting on weekday that has the DATE value.
+func weekStartExpr(value string, weekday int) string {
+	return fmt.Sprintf("DATE_SUB(%[1]s, INTERVAL MOD(EXTRACT(DAYOFWEEK FROM %[1]s) + %[2]d, 7) DAY)", value, 6-weekday)
+}
+
+// datetimeTruncMicrosExpr returns the expression truncating the DATETIME value to the multiple of micros.
+func datetimeTruncMicrosExpr(value string, micros int64) string {
+	return fmt.Sprintf(
+		"DATETIME(TIMESTAMP_MICROS(%s * %d), 'UTC')",
+		floorDivExpr(fmt.Sprintf("UNIX_MICROS(TIMESTAMP(%s, 'UTC'))", value), fmt.Sprint(micros)), micros,
+	)
+}
@@ -0,0 +1,28 @@
+package contentdata
+
+import "testing"
+
+func TestTruncFunctions(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "date trunc to quarter",
+			query:    "SELECT DATE_TRUNC(d, QUARTER) FROM t",
+			expected: "SELECT DATE(EXTRACT(YEAR FROM d), DIV(EXTRACT(MONTH FROM d) - 1, 3) * 3 + 1, 1) FROM t",
+		},
+		{
+			name:     "date trunc to week starting on monday",
+			query:    "SELECT DATE_TRUNC(d, WEEK(MONDAY)) FROM t",
+			expected: "SELECT DATE_SUB(d, INTERVAL MOD(EXTRACT(DAYOFWEEK FROM d) + 5, 7) DAY) FROM t",
+		},
+		{
+			name:     "datetime trunc to week starting on sunday",
+			query:    "SELECT DATETIME_TRUNC(dt, WEEK(SUNDAY)) FROM t",
+			expected: "SELECT DATETIME(DATE_SUB(DATE(dt), INTERVAL MOD(EXTRACT(DAYOFWEEK FROM DATE(dt)) + 6, 7) DAY)) FROM t",
+		},
+		{
+			name:     "timestamp trunc to day",
+			query:    "SELECT TIMESTAMP_TRUNC(ts, DAY) FROM t",
+			expected: "SELECT bqemulator_timestamp_in_zone(DATETIME(DATE(DATETIME(ts, 'UTC'))), 'UTC') FROM t",
+		},
+	})
+}
@@ -0,0 +1,70 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unixTimeRange is the range of the argument of the function converting the epoch value into TIMESTAMP or DATE.
+type unixTimeRange struct {
+	min, max int64
+	errMsg   string
+}
+
+const (
+	timestampRangeError = "Timestamp value is out of allowed range: from 0001-01-01 00:00:00.000000+00 to 9999-12-31 23:59:59.999999+00"
+	dateRangeError      = "Date value is out of allowed range: from 0001-01-01 to 9999-12-31"
+)
+
+// unixTimeRanges are the epoch values of 0001-01-01 00:00:00 UTC and 9999-12-31 23:59:59.999999 UTC in each unit.
+var unixTimeRanges = map[string]*unixTimeRange{
+	"TIMESTAMP_SECONDS":   {min: -62135596800, max: 253402300799, errMsg: timestampRangeError},
+	"TIMESTAMP_MILLIS":    {min: -62135596800000, max: 253402300799999, errMsg: timestampRangeError},
+	"TIMESTAMP_MICROS":    {min: -62135596800000000, max: 253402300799999999, errMsg: timestampRangeError},
+	"DATE_FROM_UNIX_DATE": {min: -719162, max: 2932896, errMsg: dateRangeError},
+}
+
+// unixTimeEdits adds the range check of BigQuery to TIMESTAMP_SECONDS, TIMESTAMP_MILLIS, TIMESTAMP_MICROS
+// and DATE_FROM_UNIX_DATE calls. go-zetasqlite converts the value out of the range into the invalid timestamp,
+// and TIMESTAMP_MILLIS overflows when the value is multiplied into microseconds.
+// DATE_FROM_UNIX_DATE is replaced with DATE_ADD because go-zetasqlite computes it in nanoseconds,
+// which overflows for the dates after 2262.
+func unixTimeEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord || !tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		name := strings.ToUpper(tk.text)
+		rng, exists := unixTimeRanges[name]
+		if !exists {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		args := functionArgs(query, tokens, idx+1, closeIdx)
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s: expected 1 argument but got %d", name, len(args))
+		}
+		// rewrite the nested calls like TIMESTAMP_SECONDS(UNIX_SECONDS(TIMESTAMP_MILLIS(...))).
+		argEdits, err := unixTimeEdits(args[0], tokenize(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		value := applyEdits(args[0], argEdits)
+		conversion := fmt.Sprintf("%s(%s)", name, value)
+		if name == "DATE_FROM_UNIX_DATE" {
+			conversion = fmt.Sprintf("DATE_ADD(DATE '1970-01-01', INTERVAL (%s) DAY)", value)
+		}
+		expr := fmt.Sprintf(
+			"IF((%[1]s) < %[2]d OR (%[1]s) > %[3]d, ERROR('%[4]s: %[5]s'), %[6]s)",
+			value, rng.min, rng.max, name, rng.errMsg, conversion,
+		)
+		edits = append(edits, &edit{start: tk.start, end: tokens[closeIdx].end, replacement: expr})
+		idx = closeIdx
+	}
+	return edits, nil
+}
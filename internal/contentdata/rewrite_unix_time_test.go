@@ -0,0 +1,31 @@
+package contentdata
+
+import "testing"
+
+func TestUnixTime(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:  "timestamp seconds",
+			query: "SELECT TIMESTAMP_SECONDS(x) FROM t",
+			expected: "SELECT IF((x) < -62135596800 OR (x) > 253402300799, ERROR('TIMESTAMP_SECONDS: Timestamp value is out of allowed range: " +
+				"from 0001-01-01 00:00:00.000000+00 to 9999-12-31 23:59:59.999999+00'), TIMESTAMP_SECONDS(x)) FROM t",
+		},
+		{
+			name:  "timestamp millis",
+			query: "SELECT TIMESTAMP_MILLIS(x) FROM t",
+			expected: "SELECT IF((x) < -62135596800000 OR (x) > 253402300799999, ERROR('TIMESTAMP_MILLIS: Timestamp value is out of allowed range: " +
+				"from 0001-01-01 00:00:00.000000+00 to 9999-12-31 23:59:59.999999+00'), TIMESTAMP_MILLIS(x)) FROM t",
+		},
+		{
+			name:  "date from unix date",
+			query: "SELECT DATE_FROM_UNIX_DATE(x) FROM t",
+			expected: "SELECT IF((x) < -719162 OR (x) > 2932896, ERROR('DATE_FROM_UNIX_DATE: Date value is out of allowed range: " +
+				"from 0001-01-01 to 9999-12-31'), DATE_ADD(DATE '1970-01-01', INTERVAL (x) DAY)) FROM t",
+		},
+		{
+			name:     "unix seconds",
+			query:    "SELECT UNIX_SECONDS(ts) FROM t",
+			expected: "SELECT UNIX_SECONDS(ts) FROM t",
+		},
+	})
+}
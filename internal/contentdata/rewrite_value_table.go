@@ -0,0 +1,53 @@
+package contentdata
+
+// valueTableKind returns STRUCT or VALUE if tokens[selectIdx] starts `SELECT [ALL | DISTINCT] AS STRUCT`
+// or `SELECT [ALL | DISTINCT] AS VALUE`, and the index of the STRUCT or VALUE keyword.
+func valueTableKind(tokens []*token, selectIdx int) (string, int) {
+	idx := selectIdx + 1
+	if idx < len(tokens) && (tokens[idx].isKeyword("ALL") || tokens[idx].isKeyword("DISTINCT")) {
+		idx++
+	}
+	if idx+1 >= len(tokens) || !tokens[idx].isKeyword("AS") {
+		return "", 0
+	}
+	switch {
+	case tokens[idx+1].isKeyword("STRUCT"):
+		return "STRUCT", idx + 1
+	case tokens[idx+1].isKeyword("VALUE"):
+		return "VALUE", idx + 1
+	}
+	return "", 0
+}
+
+// valueTableEdits removes AS STRUCT from the outermost SELECT of the statement.
+// BigQuery returns the fields of the struct as the columns of the result of the value table query,
+// which is the same as the result of the SELECT list itself. go-zetasqlite returns the struct as one column,
+// so only the value tables in the subqueries like ARRAY(SELECT AS STRUCT ...) are kept.
+// The outermost SELECT AS VALUE is flattened by the type of the value after the query runs.
+func valueTableEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx, tk := range tokens {
+		if tk.depth != 0 || !tk.isKeyword("SELECT") {
+			continue
+		}
+		kind, kindIdx := valueTableKind(tokens, idx)
+		if kind != "STRUCT" {
+			continue
+		}
+		edits = append(edits, &edit{start: tokens[kindIdx-1].start, end: tokens[kindIdx].end, replacement: ""})
+	}
+	return edits, nil
+}
+
+// isValueTableQuery reports whether the result of query is the value table by the outermost SELECT AS VALUE.
+func isValueTableQuery(query string) bool {
+	tokens := tokenize(query)
+	for idx, tk := range tokens {
+		if tk.depth != 0 || !tk.isKeyword("SELECT") {
+			continue
+		}
+		kind, _ := valueTableKind(tokens, idx)
+		return kind != ""
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package contentdata
+
+import "testing"
+
+func TestValueTable(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "select as struct",
+			query:    "SELECT AS STRUCT 1 AS a, 2 AS b",
+			expected: "SELECT  1 AS a, 2 AS b",
+		},
+		{
+			name:     "select as value",
+			query:    "SELECT AS VALUE STRUCT(1 AS a) FROM t",
+			expected: "SELECT AS VALUE STRUCT(1 AS a) FROM t",
+		},
+		{
+			name:     "select as struct in subquery",
+			query:    "SELECT * FROM (SELECT AS STRUCT 1 AS a)",
+			expected: "SELECT * FROM (SELECT AS STRUCT 1 AS a)",
+		},
+	})
+	if !isValueTableQuery("SELECT AS VALUE 1") {
+		t.Fatal("expected the value table query")
+	}
+	if isValueTableQuery("SELECT * FROM (SELECT AS VALUE 1)") {
+		t.Fatal("expected the query not to be the value table query")
+	}
+}
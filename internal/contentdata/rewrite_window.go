@@ -0,0 +1,149 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// windowEdits inlines the named windows of WINDOW clause into OVER clauses and removes WINDOW clause,
+// so the window functions in QUALIFY and ORDER BY, which needn't be in the SELECT list, refer to the windows
+// as the window functions in the SELECT list do, and the rewriters of the window functions see their specifications.
+//   - `OVER w` is `OVER (spec)` and `OVER (w ROWS ...)` is `OVER (spec ROWS ...)`.
+//   - The window defined from another window like `w2 AS (w1 ORDER BY x)` is expanded too.
+//
+// The named window is visible only in its SELECT, so the references in the subqueries are not replaced.
+func windowEdits(query string, tokens []*token) ([]*edit, error) {
+	var edits []*edit
+	for idx := 0; idx+3 < len(tokens); idx++ {
+		if !tokens[idx].isKeyword("WINDOW") || !isWindowDefinition(tokens, idx+1) {
+			continue
+		}
+		depth := tokens[idx].depth
+		windows := map[string]string{}
+		end := idx + 1
+		for isWindowDefinition(tokens, end) {
+			closeIdx := skipParen(tokens, end+2)
+			if closeIdx >= len(tokens) || !tokens[closeIdx].isSymbol(")") {
+				return edits, nil
+			}
+			spec, err := windowSpec(query, tokens, end+3, closeIdx, windows)
+			if err != nil {
+				return nil, err
+			}
+			windows[windowName(tokens[end])] = spec
+			end = closeIdx + 1
+			if end >= len(tokens) || !tokens[end].isSymbol(",") {
+				break
+			}
+			end++
+		}
+		edits = append(edits, &edit{start: tokens[idx].start, end: tokens[end-1].end, replacement: ""})
+		start := idx
+		for start > 0 && !(tokens[start].isKeyword("SELECT") && tokens[start].depth == depth) {
+			if tokens[start-1].depth < depth {
+				break
+			}
+			start--
+		}
+		stop := end
+		for stop < len(tokens) && tokens[stop].depth >= depth && !tokens[stop].isSymbol(";") && !isSetOperation(tokens, stop, depth) {
+			stop++
+		}
+		refEdits, err := windowReferenceEdits(query, tokens, start, idx, windows)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, refEdits...)
+		// ORDER BY after WINDOW clause can refer to the windows too.
+		refEdits, err = windowReferenceEdits(query, tokens, end, stop, windows)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, refEdits...)
+		idx = end - 1
+	}
+	return edits, nil
+}
+
+// isWindowDefinition reports whether tokens[idx] starts `name AS (`.
+func isWindowDefinition(tokens []*token, idx int) bool {
+	return idx+2 < len(tokens) &&
+		(tokens[idx].kind == tokenWord || tokens[idx].kind == tokenQuotedIdent) &&
+		tokens[idx+1].isKeyword("AS") && tokens[idx+2].isSymbol("(")
+}
+
+// isSetOperation reports whether tokens[idx] is UNION, INTERSECT or EXCEPT of the query at depth.
+func isSetOperation(tokens []*token, idx, depth int) bool {
+	tk := tokens[idx]
+	if tk.depth != depth {
+		return false
+	}
+	if tk.isKeyword("UNION") || tk.isKeyword("INTERSECT") {
+		return true
+	}
+	return tk.isKeyword("EXCEPT") && idx+1 < len(tokens) && !tokens[idx+1].isSymbol("(")
+}
+
+// windowName returns the name of the window, which is case insensitive.
+func windowName(tk *token) string {
+	return strings.ToLower(strings.Trim(tk.text, "`"))
+}
+
+// windowSpec returns the specification in tokens[start:end] with the referenced window expanded.
+func windowSpec(query string, tokens []*token, start, end int, windows map[string]string) (string, error) {
+	if start >= end {
+		return "", nil
+	}
+	first := tokens[start]
+	if (first.kind != tokenWord && first.kind != tokenQuotedIdent) ||
+		first.isKeyword("PARTITION") || first.isKeyword("ORDER") || first.isKeyword("ROWS") || first.isKeyword("RANGE") {
+		return tokenText(query, tokens[start:end]), nil
+	}
+	base, exists := windows[windowName(first)]
+	if !exists {
+		return "", fmt.Errorf("Unrecognized window alias %s", strings.Trim(first.text, "`"))
+	}
+	if start+1 == end {
+		return base, nil
+	}
+	return strings.TrimSpace(base + " " + tokenText(query, tokens[start+1:end])), nil
+}
+
+// windowReferenceEdits replaces the references to the named windows in tokens[start:end] outside the subqueries.
+func windowReferenceEdits(query string, tokens []*token, start, end int, windows map[string]string) ([]*edit, error) {
+	var edits []*edit
+	for idx := start; idx+1 < end; idx++ {
+		tk := tokens[idx]
+		if tk.isSymbol("(") && (tokens[idx+1].isKeyword("SELECT") || tokens[idx+1].isKeyword("WITH")) {
+			idx = skipParen(tokens, idx)
+			continue
+		}
+		if !tk.isKeyword("OVER") {
+			continue
+		}
+		next := tokens[idx+1]
+		if spec, exists := windows[windowName(next)]; exists && (next.kind == tokenWord || next.kind == tokenQuotedIdent) {
+			edits = append(edits, &edit{start: next.start, end: next.end, replacement: fmt.Sprintf("(%s)", spec)})
+			idx++
+			continue
+		}
+		if !next.isSymbol("(") {
+			continue
+		}
+		closeIdx := skipParen(tokens, idx+1)
+		if closeIdx >= end || idx+2 >= closeIdx {
+			continue
+		}
+		ref := tokens[idx+2]
+		if _, exists := windows[windowName(ref)]; !exists || (ref.kind != tokenWord && ref.kind != tokenQuotedIdent) {
+			continue
+		}
+		spec, err := windowSpec(query, tokens, idx+2, closeIdx, windows)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, &edit{start: next.end, end: tokens[closeIdx].start, replacement: spec})
+		idx = closeIdx
+	}
+	return edits, nil
+}
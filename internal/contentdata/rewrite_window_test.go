@@ -0,0 +1,28 @@
+package contentdata
+
+import "testing"
+
+func TestNamedWindow(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:     "named window",
+			query:    "SELECT SUM(x) OVER w FROM t WINDOW w AS (PARTITION BY a ORDER BY b)",
+			expected: "SELECT SUM(x) OVER (PARTITION BY a ORDER BY b) FROM t ",
+		},
+		{
+			name:     "named window with order",
+			query:    "SELECT SUM(x) OVER (w ORDER BY b) FROM t WINDOW w AS (PARTITION BY a)",
+			expected: "SELECT SUM(x) OVER (PARTITION BY a ORDER BY b) FROM t ",
+		},
+		{
+			name:     "window referencing window",
+			query:    "SELECT SUM(x) OVER w2 FROM t WINDOW w1 AS (PARTITION BY a), w2 AS (w1 ORDER BY b)",
+			expected: "SELECT SUM(x) OVER (PARTITION BY a ORDER BY b) FROM t ",
+		},
+		{
+			name:     "qualify",
+			query:    "SELECT a FROM t QUALIFY ROW_NUMBER() OVER w = 1 WINDOW w AS (ORDER BY b)",
+			expected: "SELECT a FROM t QUALIFY ROW_NUMBER() OVER (ORDER BY b) = 1 ",
+		},
+	})
+}
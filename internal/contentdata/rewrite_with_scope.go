@@ -0,0 +1,65 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// withScopeAlias is the prefix of the names given to the common table expressions shadowing the other ones.
+const withScopeAlias = "bqemulator_cte"
+
+// withScopeEdits renames the common table expressions whose names are used by another WITH clause of the statement,
+// like the WITH clause of a subquery shadowing the top-level one or the body of a table function in a query,
+// because go-zetasqlite identifies the expressions of the statement by their names.
+// The references are resolved by the lexical scope of BigQuery: a name refers to the innermost WITH clause
+// defining it before the reference, and the tables keep the original names as their aliases,
+// so the columns qualified by the names like `t.id` refer to them as before.
+func withScopeEdits(query string, tokens []*token) ([]*edit, error) {
+	scopes := withScopes(tokens)
+	if len(scopes) < 2 {
+		return nil, nil
+	}
+	var (
+		renamed = map[*withScopeEntry]string{}
+		// definitions are the new names at the indexes of the names of the entries.
+		definitions = map[int]string{}
+		defined     = map[string]bool{}
+	)
+	for _, scope := range scopes {
+		for _, entry := range scope.entries {
+			name := strings.ToLower(entry.name)
+			if defined[name] {
+				renamed[entry] = fmt.Sprintf("`%s%d_%s`", withScopeAlias, len(renamed)+1, entry.name)
+				definitions[entry.nameIdx] = renamed[entry]
+			}
+			defined[name] = true
+		}
+	}
+	if len(renamed) == 0 {
+		return nil, nil
+	}
+	var edits []*edit
+	for idx := 1; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord && tk.kind != tokenQuotedIdent {
+			continue
+		}
+		if name, exists := definitions[idx]; exists {
+			edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: name})
+			continue
+		}
+		if !defined[strings.ToLower(strings.Trim(tk.text, "`"))] || !isCTETableReference(tokens, idx) {
+			continue
+		}
+		_, entry := resolveWithEntry(scopes, tokens, idx)
+		name, exists := renamed[entry]
+		if !exists {
+			continue
+		}
+		if !hasTableAlias(tokens, idx+1) {
+			name += " AS " + tk.text
+		}
+		edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: name})
+	}
+	return edits, nil
+}
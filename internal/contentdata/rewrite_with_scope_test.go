@@ -0,0 +1,25 @@
+package contentdata
+
+import "testing"
+
+func TestWithScope(t *testing.T) {
+	testRewriteQuery(t, []rewriteQueryTest{
+		{
+			name:  "shadowed name in the with clause",
+			query: "WITH a AS (SELECT 1 AS x), b AS (WITH a AS (SELECT 2 AS x) SELECT x FROM a) SELECT * FROM a, b",
+			expected: "WITH a AS (SELECT 1 AS x), b AS (WITH `bqemulator_cte1_a` AS (SELECT 2 AS x) " +
+				"SELECT x FROM `bqemulator_cte1_a` AS a) SELECT * FROM a, b",
+		},
+		{
+			name:  "shadowed name in the subquery",
+			query: "WITH a AS (SELECT 1 AS x) SELECT * FROM (WITH a AS (SELECT 2 AS x) SELECT * FROM a), a",
+			expected: "WITH a AS (SELECT 1 AS x) SELECT * FROM (WITH `bqemulator_cte1_a` AS (SELECT 2 AS x) " +
+				"SELECT * FROM `bqemulator_cte1_a` AS a), a",
+		},
+		{
+			name:     "recursive",
+			query:    "WITH RECURSIVE a AS (SELECT 1 AS x UNION ALL SELECT x + 1 FROM a WHERE x < 3) SELECT * FROM a",
+			expected: "WITH RECURSIVE a AS (SELECT 1 AS x UNION ALL SELECT x + 1 FROM a WHERE x < 3) SELECT * FROM a",
+		},
+	})
+}
@@ -0,0 +1,105 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RowAccessPolicyStatement is the CREATE ROW ACCESS POLICY, DROP ROW ACCESS POLICY
+// or DROP ALL ROW ACCESS POLICIES statement.
+// The statement only changes the metadata of the table, and the emulator doesn't filter the rows by the policies.
+type RowAccessPolicyStatement struct {
+	Drop bool
+	// DropAll is true for DROP ALL ROW ACCESS POLICIES. Name is empty.
+	DropAll     bool
+	OrReplace   bool
+	IfNotExists bool
+	IfExists    bool
+	Name        string
+	// TablePath is the name of the table split by the dot. It has one to three elements.
+	TablePath []string
+	// Grantees are the members of GRANT TO like `user:alice@example.com`.
+	Grantees []string
+	// FilterPredicate is the expression of FILTER USING as written in the statement.
+	FilterPredicate string
+}
+
+// ParseRowAccessPolicyStatement parses query as the statement creating or dropping the row access policies.
+// It returns nil without error when query is not the statement.
+func ParseRowAccessPolicyStatement(query string) (*RowAccessPolicyStatement, error) {
+	tokens := statementTokens(query)
+	if tokens == nil {
+		return nil, nil
+	}
+	p := &statementParser{tokens: tokens}
+	stmt := &RowAccessPolicyStatement{}
+	switch {
+	case p.consumeKeywords("CREATE", "ROW", "ACCESS", "POLICY"):
+		stmt.IfNotExists = p.consumeKeywords("IF", "NOT", "EXISTS")
+	case p.consumeKeywords("CREATE", "OR", "REPLACE", "ROW", "ACCESS", "POLICY"):
+		stmt.OrReplace = true
+	case p.consumeKeywords("DROP", "ROW", "ACCESS", "POLICY"):
+		stmt.Drop = true
+		stmt.IfExists = p.consumeKeywords("IF", "EXISTS")
+	case p.consumeKeywords("DROP", "ALL", "ROW", "ACCESS", "POLICIES"):
+		stmt.Drop = true
+		stmt.DropAll = true
+	default:
+		return nil, nil
+	}
+	if !stmt.DropAll {
+		name := p.next()
+		switch name.kind {
+		case tokenWord:
+			stmt.Name = name.text
+		case tokenQuotedIdent:
+			stmt.Name = strings.Trim(name.text, "`")
+		default:
+			return nil, fmt.Errorf("syntax error: expected row access policy name but got %q", name.text)
+		}
+	}
+	if !p.consumeKeywords("ON") {
+		return nil, fmt.Errorf("syntax error: expected ON but got %q", p.peek().text)
+	}
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil, err
+	}
+	if len(path) > 3 {
+		return nil, fmt.Errorf("invalid table name %s", strings.Join(path, "."))
+	}
+	stmt.TablePath = path
+	if !stmt.Drop {
+		if p.consumeKeywords("GRANT", "TO") {
+			grantees, err := p.optionValues("(", ")")
+			if err != nil {
+				return nil, err
+			}
+			for _, grantee := range grantees {
+				v, ok := grantee.(string)
+				if !ok {
+					return nil, fmt.Errorf("grantee must be the string literal like \"user:alice@example.com\"")
+				}
+				stmt.Grantees = append(stmt.Grantees, v)
+			}
+		}
+		if !p.consumeKeywords("FILTER", "USING") {
+			return nil, fmt.Errorf("syntax error: expected FILTER USING but got %q", p.peek().text)
+		}
+		if !p.peek().isSymbol("(") {
+			return nil, fmt.Errorf("syntax error: expected ( but got %q", p.peek().text)
+		}
+		open := p.idx
+		if err := p.skipParen(); err != nil {
+			return nil, err
+		}
+		if p.idx-open < 3 {
+			return nil, fmt.Errorf("syntax error: FILTER USING requires the predicate")
+		}
+		stmt.FilterPredicate = query[tokens[open+1].start:tokens[p.idx-2].end]
+	}
+	if !p.eof() {
+		return nil, fmt.Errorf("syntax error: unexpected %s", p.peek().text)
+	}
+	return stmt, nil
+}
@@ -0,0 +1,600 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ScriptStatementKind is the kind of the statement in the script.
+type ScriptStatementKind int
+
+const (
+	// ScriptQuery is the statement that go-zetasqlite evaluates.
+	ScriptQuery ScriptStatementKind = iota
+	// ScriptDeclare is `DECLARE name[, ...] [type] [DEFAULT expr]`.
+	ScriptDeclare
+	// ScriptSet is `SET name = expr` or `SET (name[, ...]) = value`.
+	ScriptSet
+	// ScriptExecuteImmediate is `EXECUTE IMMEDIATE sql [INTO name[, ...]] [USING expr [AS name][, ...]]`.
+	ScriptExecuteImmediate
+)
+
+// ScriptStatement is the statement of the script.
+// go-zetasqlite doesn't support the procedural language, so the emulator evaluates the variables and the dynamic SQL,
+// and the other statements are passed to go-zetasqlite after the variables are bound.
+type ScriptStatement struct {
+	Kind  ScriptStatementKind
+	Query string
+	// Variables are the declared variables of DECLARE, the assigned variable of SET or the variables of INTO clause.
+	Variables []string
+	// Type is the type of DECLARE. It is empty if the type is inferred from the default value.
+	Type string
+	// Expr is the default value of DECLARE, the value of SET or the SQL string of EXECUTE IMMEDIATE.
+	Expr string
+	// Values are the expressions of `SET (name[, ...]) = (expr[, ...])`. They are empty if the value of SET
+	// is the subquery or the STRUCT expression in Expr.
+	Values []string
+	// ExprIsQuery reports whether Expr of SET of multiple variables is the query of the subquery like
+	// `SET (a, b) = (SELECT ...)`, which is stored without the parentheses.
+	ExprIsQuery bool
+	// Using are the arguments of USING clause of EXECUTE IMMEDIATE.
+	Using []*ScriptArgument
+}
+
+// ScriptArgument is the argument of USING clause. Name is empty for the positional parameter.
+type ScriptArgument struct {
+	Expr string
+	Name string
+}
+
+// ParseScript splits query into the statements if it uses DECLARE, SET, EXECUTE IMMEDIATE or the model statements.
+// It returns nil without error when query doesn't need the emulator to evaluate the script.
+// The procedural statements like IF, LOOP and BEGIN ... END are not supported.
+func ParseScript(query string) ([]*ScriptStatement, error) {
+	if !IsScript(query) {
+		return nil, nil
+	}
+	return ParseScriptStatements(query)
+}
+
+// ParseScriptStatements splits query into the statements of the script even if query doesn't need to be the script,
+// so the statements share the variables of DECLARE and the transaction.
+func ParseScriptStatements(query string) ([]*ScriptStatement, error) {
+	var stmts []*ScriptStatement
+	for _, tokens := range splitStatements(tokenize(query)) {
+		stmt, err := parseScriptStatement(query, tokens)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+// IsScript reports whether query has the statement that only the script can have ( DECLARE, SET or EXECUTE IMMEDIATE ).
+// The multiple statements with the model statement are the script too, because go-zetasqlite doesn't support the model,
+// and so are the ones with IF EXISTS or IF NOT EXISTS guard, CREATE TABLE LIKE or COPY, ALTER TABLE
+// or the external tables that the emulator evaluates statement by statement.
+// The query that refers to the system variables like @@row_count is evaluated as the script to bind their values.
+func IsScript(query string) bool {
+	tokens := tokenize(query)
+	for _, tk := range tokens {
+		if tk.kind == tokenParam && strings.HasPrefix(tk.text, "@@") {
+			return true
+		}
+	}
+	stmts := splitStatements(tokens)
+	for _, tokens := range stmts {
+		if len(stmts) > 1 && (isModelStatement(tokens) || isGuardedDDL(tokens) || isTableSourceDDL(tokens) || isAlterTableStatement(tokens) ||
+			isExternalTableStatement(tokens)) {
+			return true
+		}
+		first := tokens[0]
+		if first.isKeyword("DECLARE") || first.isKeyword("SET") {
+			return true
+		}
+		if len(tokens) > 1 && first.isKeyword("EXECUTE") && tokens[1].isKeyword("IMMEDIATE") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitStatements splits tokens by the semicolons. The empty statements are removed.
+func splitStatements(tokens []*token) [][]*token {
+	var (
+		stmts [][]*token
+		start int
+	)
+	for idx := 0; idx <= len(tokens); idx++ {
+		if idx < len(tokens) && !(tokens[idx].isSymbol(";") && tokens[idx].depth == 0) {
+			continue
+		}
+		if idx > start {
+			stmts = append(stmts, tokens[start:idx])
+		}
+		start = idx + 1
+	}
+	return stmts
+}
+
+func parseScriptStatement(query string, tokens []*token) (*ScriptStatement, error) {
+	text := func(start, end int) string {
+		return strings.TrimSpace(query[tokens[start].start:tokens[end-1].end])
+	}
+	stmt := &ScriptStatement{Query: text(0, len(tokens))}
+	p := &statementParser{tokens: tokens}
+	switch {
+	case p.consumeKeywords("DECLARE"):
+		stmt.Kind = ScriptDeclare
+		names, err := p.variableNames()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Variables = names
+		typeStart := p.idx
+		for !p.eof() && !p.peek().isKeyword("DEFAULT") {
+			p.next()
+		}
+		if p.idx > typeStart {
+			stmt.Type = text(typeStart, p.idx)
+		}
+		if p.consumeKeywords("DEFAULT") {
+			if p.eof() {
+				return nil, fmt.Errorf("syntax error: DEFAULT requires the value")
+			}
+			stmt.Expr = text(p.idx, len(tokens))
+		}
+		if stmt.Type == "" && stmt.Expr == "" {
+			return nil, fmt.Errorf("DECLARE %s requires the type or the default value", strings.Join(names, ", "))
+		}
+	case p.consumeKeywords("SET"):
+		stmt.Kind = ScriptSet
+		var names []string
+		switch {
+		case p.peek().kind == tokenParam:
+			name, err := p.systemVariableName()
+			if err != nil {
+				return nil, err
+			}
+			names = []string{name}
+		case p.consumeSymbol("("):
+			variables, err := p.variableNames()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			names = variables
+		default:
+			variables, err := p.variableNames()
+			if err != nil {
+				return nil, err
+			}
+			if len(variables) > 1 {
+				return nil, fmt.Errorf("syntax error: SET of multiple variables requires the parentheses like SET (%s) = ...", strings.Join(variables, ", "))
+			}
+			names = variables
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		if p.eof() {
+			return nil, fmt.Errorf("syntax error: SET requires the value")
+		}
+		stmt.Variables = names
+		stmt.Expr = text(p.idx, len(tokens))
+		if len(names) > 1 {
+			stmt.Values, stmt.ExprIsQuery = setValues(query, tokens[p.idx:])
+			if stmt.ExprIsQuery {
+				stmt.Expr = text(p.idx+1, len(tokens)-1)
+			}
+		}
+	case p.consumeKeywords("EXECUTE", "IMMEDIATE"):
+		stmt.Kind = ScriptExecuteImmediate
+		exprStart := p.idx
+		for !p.eof() && !(p.peek().depth == 0 && (p.peek().isKeyword("INTO") || p.peek().isKeyword("USING"))) {
+			p.next()
+		}
+		if p.idx == exprStart {
+			return nil, fmt.Errorf("syntax error: EXECUTE IMMEDIATE requires the SQL string")
+		}
+		stmt.Expr = text(exprStart, p.idx)
+		if p.consumeKeywords("INTO") {
+			names, err := p.variableNames()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Variables = names
+		}
+		if p.consumeKeywords("USING") {
+			args, err := p.usingArguments(query)
+			if err != nil {
+				return nil, err
+			}
+			stmt.Using = args
+		}
+		if !p.eof() {
+			return nil, fmt.Errorf("syntax error: unexpected %s", p.peek().text)
+		}
+	}
+	return stmt, nil
+}
+
+// setValues parses the value of SET of multiple variables. It returns the expressions of the tuple `(expr[, ...])`,
+// or reports whether the value is the subquery `(SELECT ...)`. The other values are the expressions of STRUCT.
+func setValues(query string, tokens []*token) ([]string, bool) {
+	last := len(tokens) - 1
+	if len(tokens) < 2 || !tokens[0].isSymbol("(") || skipParen(tokens, 0) != last {
+		return nil, false
+	}
+	if tokens[1].isKeyword("SELECT") || tokens[1].isKeyword("WITH") {
+		return nil, true
+	}
+	var (
+		values []string
+		start  = 1
+	)
+	for idx := 1; idx <= last; idx++ {
+		if idx < last && !(tokens[idx].depth == tokens[0].depth+1 && tokens[idx].isSymbol(",")) {
+			continue
+		}
+		if idx > start {
+			values = append(values, strings.TrimSpace(query[tokens[start].start:tokens[idx-1].end]))
+		}
+		start = idx + 1
+	}
+	if len(values) < 2 {
+		// `(expr)` is the STRUCT expression in the parentheses.
+		return nil, false
+	}
+	return values, false
+}
+
+// variableNames parses the comma separated names of the variables.
+func (p *statementParser) variableNames() ([]string, error) {
+	var names []string
+	for {
+		name := p.next()
+		if name.kind != tokenWord {
+			return nil, fmt.Errorf("syntax error: expected variable name but got %q", name.text)
+		}
+		names = append(names, name.text)
+		if !p.consumeSymbol(",") {
+			return names, nil
+		}
+	}
+}
+
+// systemVariableName parses the name of the system variable like @@time_zone or @@script.creation_time.
+func (p *statementParser) systemVariableName() (string, error) {
+	tk := p.next()
+	if tk.kind != tokenParam || !strings.HasPrefix(tk.text, "@@") || len(tk.text) == 2 {
+		return "", fmt.Errorf("syntax error: expected system variable name but got %q", tk.text)
+	}
+	name := tk.text
+	for p.peek().isSymbol(".") && p.idx+1 < len(p.tokens) && p.tokens[p.idx+1].kind == tokenWord {
+		p.next()
+		name += "." + p.next().text
+	}
+	return name, nil
+}
+
+// usingArguments parses `expr [AS name][, ...]` of USING clause.
+func (p *statementParser) usingArguments(query string) ([]*ScriptArgument, error) {
+	var args []*ScriptArgument
+	for {
+		if p.eof() || p.peek().isSymbol(",") {
+			return nil, fmt.Errorf("syntax error: USING requires the value")
+		}
+		start := p.idx
+		depth := p.peek().depth
+		for !p.eof() && !(p.peek().depth == depth && (p.peek().isSymbol(",") || p.peek().isKeyword("AS"))) {
+			p.next()
+		}
+		arg := &ScriptArgument{Expr: strings.TrimSpace(query[p.tokens[start].start:p.tokens[p.idx-1].end])}
+		if p.consumeKeywords("AS") {
+			name := p.next()
+			if name.kind != tokenWord {
+				return nil, fmt.Errorf("syntax error: expected parameter name but got %q", name.text)
+			}
+			arg.Name = name.text
+		}
+		args = append(args, arg)
+		if !p.consumeSymbol(",") {
+			return args, nil
+		}
+	}
+}
+
+// BindVariables replaces the references to the script variables in query with their values.
+// values is keyed by the lowercase name of the variable, and each value is the SQL expression.
+// The system variables are keyed by the name with @@ like @@script.creation_time.
+// Only the names in the expressions are the references to the variables: the names qualified by the dot,
+// the function names, the table names, the aliases, the column lists of CREATE TABLE, INSERT and JOIN USING
+// and the columns assigned by UPDATE SET are not.
+// columns are the lowercase names of the columns of the tables read by query. The columns, the aliases of the FROM items
+// and the columns of the derived tables take precedence over the variables of the same name as BigQuery does.
+func BindVariables(query string, values map[string]string, columns map[string]struct{}) string {
+	if len(values) == 0 {
+		return query
+	}
+	tokens := tokenize(query)
+	shadows := queryNames(tokens)
+	var edits []*edit
+	for idx, tk := range tokens {
+		if tk.kind == tokenParam && strings.HasPrefix(tk.text, "@@") {
+			p := &statementParser{tokens: tokens, idx: idx}
+			name, err := p.systemVariableName()
+			if err != nil {
+				continue
+			}
+			if value, exists := values[strings.ToLower(name)]; exists {
+				edits = append(edits, &edit{start: tk.start, end: tokens[p.idx-1].end, replacement: "(" + value + ")"})
+			}
+			continue
+		}
+		if tk.kind != tokenWord {
+			continue
+		}
+		name := strings.ToLower(tk.text)
+		value, exists := values[name]
+		if !exists {
+			continue
+		}
+		if _, isColumn := columns[name]; isColumn || !isVariableReference(tokens, idx) || shadows.shadowed(name, idx) {
+			continue
+		}
+		edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: "(" + value + ")"})
+	}
+	return applyEdits(query, edits)
+}
+
+// expressionKeywords are the unreserved keywords followed by the expression, so the name following them is not the alias.
+var expressionKeywords = []string{"INTERVAL", "OF", "OFFSET", "QUALIFY", "RANGE", "ROWS", "STRUCT", "VALUE", "ZONE"}
+
+// isVariableReference reports whether the name at idx is in the position of the expression.
+func isVariableReference(tokens []*token, idx int) bool {
+	if idx+1 < len(tokens) && (tokens[idx+1].isSymbol(".") || tokens[idx+1].isSymbol("(")) {
+		return false
+	}
+	if idx == 0 {
+		return true
+	}
+	prev := tokens[idx-1]
+	if prev.isSymbol(".") || prev.isKeyword("AS") || isUnqualifiedTableName(tokens, idx) || isAssignedColumn(tokens, idx) {
+		return false
+	}
+	if isImplicitAlias(prev, tokens[idx]) && !isKeywordIn(prev, expressionKeywords) {
+		return false
+	}
+	if open := enclosingParen(tokens, idx); open >= 0 && isNameList(tokens, open) {
+		return false
+	}
+	return true
+}
+
+// isAssignedColumn reports whether the name at idx is the column assigned by the SET clause of UPDATE or MERGE.
+func isAssignedColumn(tokens []*token, idx int) bool {
+	if idx+1 >= len(tokens) || !tokens[idx+1].isSymbol("=") {
+		return false
+	}
+	if tokens[idx-1].isKeyword("SET") {
+		return true
+	}
+	if !tokens[idx-1].isSymbol(",") {
+		return false
+	}
+	depth := tokens[idx].depth
+	for i := idx - 2; i >= 0 && tokens[i].depth >= depth; i-- {
+		if tokens[i].depth != depth || tokens[i].kind != tokenWord {
+			continue
+		}
+		if tokens[i].isKeyword("SET") {
+			return true
+		}
+		if isReservedKeyword(tokens[i]) {
+			return false
+		}
+	}
+	return false
+}
+
+// enclosingParen returns the index of the innermost parenthesis containing the token at idx, or -1.
+func enclosingParen(tokens []*token, idx int) int {
+	depth := tokens[idx].depth
+	for i := idx - 1; i >= 0; i-- {
+		if tokens[i].depth < depth {
+			if tokens[i].isSymbol("(") {
+				return i
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// isNameList reports whether the parentheses at open are the list of the names, not the expressions:
+// the column list of INSERT, the column definitions of CREATE TABLE, the parameters of CREATE FUNCTION
+// and CREATE PROCEDURE, and the columns of JOIN USING.
+func isNameList(tokens []*token, open int) bool {
+	if open == 0 {
+		return false
+	}
+	if tokens[open-1].isKeyword("USING") || tokens[open-1].isKeyword("INSERT") {
+		return true
+	}
+	if open >= 2 && tokens[open-1].isKeyword("EXCEPT") && tokens[open-2].isSymbol("*") {
+		// SELECT * EXCEPT (column, ...)
+		return true
+	}
+	start := namePathStart(tokens, open)
+	if start == open || start == 0 {
+		return false
+	}
+	switch prev := tokens[start-1]; {
+	case prev.isKeyword("INSERT"), prev.isKeyword("INTO"):
+		if open+1 < len(tokens) && (tokens[open+1].isKeyword("SELECT") || tokens[open+1].isKeyword("WITH") || tokens[open+1].isSymbol("(")) {
+			return false
+		}
+		return true
+	case prev.isKeyword("TABLE"), prev.isKeyword("FUNCTION"), prev.isKeyword("PROCEDURE"):
+		return tokens[0].isKeyword("CREATE")
+	}
+	return false
+}
+
+// namePathStart returns the index of the first name of the name path like `project.dataset.table` ending before end.
+// It returns end if the token before end is not a name.
+func namePathStart(tokens []*token, end int) int {
+	isName := func(tk *token) bool {
+		return tk.kind == tokenQuotedIdent || (tk.kind == tokenWord && !isReservedKeyword(tk))
+	}
+	if end == 0 || !isName(tokens[end-1]) {
+		return end
+	}
+	start := end - 1
+	for start >= 2 && (tokens[start-1].isSymbol(".") || tokens[start-1].isSymbol("-")) && isName(tokens[start-2]) {
+		start -= 2
+	}
+	return start
+}
+
+// nameShadows are the names defined by the query that take precedence over the variables.
+// Each name has the ranges of the tokens where the name doesn't shadow the variable,
+// like the SELECT list defining the alias, which can't refer to the alias itself.
+type nameShadows map[string][][2]int
+
+func (s nameShadows) shadowed(name string, idx int) bool {
+	for _, visible := range s[name] {
+		if idx < visible[0] || idx >= visible[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// queryNames returns the aliases of the FROM items and the SELECT lists, and the parameters of the routines defined by query.
+func queryNames(tokens []*token) nameShadows {
+	shadows := nameShadows{}
+	for idx := 1; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord && tk.kind != tokenQuotedIdent {
+			continue
+		}
+		name := strings.ToLower(strings.Trim(tk.text, "`"))
+		prev := tokens[idx-1]
+		if open := enclosingParen(tokens, idx); open >= 0 && isNameList(tokens, open) {
+			if start := namePathStart(tokens, open); start > 0 && (prev.isSymbol("(") || prev.isSymbol(",")) &&
+				(tokens[start-1].isKeyword("FUNCTION") || tokens[start-1].isKeyword("PROCEDURE")) {
+				// the parameter of the routine is visible in its body.
+				shadows[name] = append(shadows[name], [2]int{0, 0})
+			}
+			continue
+		}
+		if !prev.isKeyword("AS") && !(isImplicitAlias(prev, tk) && !isKeywordIn(prev, expressionKeywords)) {
+			continue
+		}
+		if idx+1 < len(tokens) && (tokens[idx+1].isSymbol("(") || tokens[idx+1].isSymbol(".")) {
+			// the name of the common table expression or the type of CAST.
+			continue
+		}
+		if isUnqualifiedTableName(tokens, idx) {
+			continue
+		}
+		block := findQueryBlock(tokens, idx)
+		if block == nil {
+			continue
+		}
+		listEnd := block.end
+		if from, exists := block.clauses["FROM"]; exists {
+			listEnd = from
+		}
+		if idx < listEnd {
+			// the alias of the SELECT list is the column of the derived table and the reference of ORDER BY.
+			shadows[name] = append(shadows[name], [2]int{block.start, listEnd})
+			continue
+		}
+		if isFromClauseAlias(tokens, idx) {
+			shadows[name] = append(shadows[name], [2]int{0, 0})
+		}
+	}
+	return shadows
+}
+
+// isFromClauseAlias reports whether the alias at idx is the one of the FROM item including UNNEST and WITH OFFSET.
+func isFromClauseAlias(tokens []*token, idx int) bool {
+	depth := tokens[idx].depth
+	for i := idx - 1; i >= 0 && tokens[i].depth >= depth; i-- {
+		tk := tokens[i]
+		if tk.depth != depth || tk.kind != tokenWord {
+			continue
+		}
+		if tk.isKeyword("FROM") || tk.isKeyword("JOIN") {
+			return true
+		}
+		if tk.isKeyword("AS") || tk.isKeyword("UNNEST") || tk.isKeyword("WITH") {
+			continue
+		}
+		if isReservedKeyword(tk) {
+			return false
+		}
+	}
+	return false
+}
+
+// BindParameters replaces the query parameters of the dynamic SQL with the values of USING clause.
+// positional are the values of the ? parameters in order, and named is keyed by the lowercase name of the @ parameters.
+func BindParameters(query string, positional []string, named map[string]string) (string, error) {
+	var (
+		edits []*edit
+		pos   int
+	)
+	for _, tk := range tokenize(query) {
+		if tk.kind != tokenParam || strings.HasPrefix(tk.text, "@@") {
+			continue
+		}
+		var value string
+		if tk.text == "?" {
+			if pos >= len(positional) {
+				return "", fmt.Errorf("the number of the positional parameters exceeds the arguments of USING clause")
+			}
+			value = positional[pos]
+			pos++
+		} else {
+			v, exists := named[strings.ToLower(strings.TrimPrefix(tk.text, "@"))]
+			if !exists {
+				return "", fmt.Errorf("query parameter %s is not found in USING clause", tk.text)
+			}
+			value = v
+		}
+		edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: "(" + value + ")"})
+	}
+	if pos != len(positional) {
+		return "", fmt.Errorf("the number of the positional parameters doesn't match the arguments of USING clause")
+	}
+	return applyEdits(query, edits), nil
+}
+
+// ApplyTimeZone passes timeZone to CURRENT_DATE, CURRENT_DATETIME and CURRENT_TIME without the time zone argument,
+// so they return the current time in the time zone of @@time_zone instead of UTC.
+func ApplyTimeZone(query, timeZone string) string {
+	tokens := tokenize(query)
+	var edits []*edit
+	for idx, tk := range tokens {
+		if !tk.isKeyword("CURRENT_DATE") && !tk.isKeyword("CURRENT_DATETIME") && !tk.isKeyword("CURRENT_TIME") {
+			continue
+		}
+		if idx > 0 && (tokens[idx-1].isSymbol(".") || tokens[idx-1].isKeyword("AS")) {
+			continue
+		}
+		switch {
+		case idx+2 < len(tokens) && tokens[idx+1].isSymbol("(") && tokens[idx+2].isSymbol(")"):
+			edits = append(edits, &edit{start: tokens[idx+1].start, end: tokens[idx+2].end, replacement: "(" + QuoteStringLiteral(timeZone) + ")"})
+		case idx+1 >= len(tokens) || !tokens[idx+1].isSymbol("("):
+			edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: tk.text + "(" + QuoteStringLiteral(timeZone) + ")"})
+		}
+	}
+	return applyEdits(query, edits)
+}
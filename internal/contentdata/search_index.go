@@ -0,0 +1,126 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SearchIndexStatement is the CREATE SEARCH INDEX or DROP SEARCH INDEX statement.
+// The emulator doesn't build the index, the statement only changes the metadata of the table
+// and SEARCH scans the table.
+type SearchIndexStatement struct {
+	Drop        bool
+	IfNotExists bool
+	IfExists    bool
+	Name        string
+	// TablePath is the name of the table split by the dot. It has one to three elements.
+	TablePath []string
+	// AllColumns is true if the index is created with ALL COLUMNS.
+	AllColumns bool
+	Columns    []string
+	Options    []*Option
+}
+
+// ParseSearchIndexStatement parses query as CREATE SEARCH INDEX or DROP SEARCH INDEX statement.
+// It returns nil without error when query is not the statement.
+func ParseSearchIndexStatement(query string) (*SearchIndexStatement, error) {
+	tokens := statementTokens(query)
+	if tokens == nil {
+		return nil, nil
+	}
+	p := &statementParser{tokens: tokens}
+	stmt := &SearchIndexStatement{}
+	switch {
+	case p.consumeKeywords("CREATE", "SEARCH", "INDEX"):
+		stmt.IfNotExists = p.consumeKeywords("IF", "NOT", "EXISTS")
+	case p.consumeKeywords("DROP", "SEARCH", "INDEX"):
+		stmt.Drop = true
+		stmt.IfExists = p.consumeKeywords("IF", "EXISTS")
+	default:
+		return nil, nil
+	}
+	name := p.next()
+	switch name.kind {
+	case tokenWord:
+		stmt.Name = name.text
+	case tokenQuotedIdent:
+		stmt.Name = strings.Trim(name.text, "`")
+	default:
+		return nil, fmt.Errorf("syntax error: expected index name but got %q", name.text)
+	}
+	if !p.consumeKeywords("ON") {
+		return nil, fmt.Errorf("syntax error: expected ON but got %q", p.peek().text)
+	}
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil, err
+	}
+	if len(path) > 3 {
+		return nil, fmt.Errorf("invalid table name %s", strings.Join(path, "."))
+	}
+	stmt.TablePath = path
+	if !stmt.Drop {
+		if err := p.searchIndexColumns(stmt); err != nil {
+			return nil, err
+		}
+		if p.consumeKeywords("OPTIONS") {
+			options, err := p.options()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Options = options
+		}
+	}
+	if !p.eof() {
+		return nil, fmt.Errorf("syntax error: unexpected %s", p.peek().text)
+	}
+	return stmt, nil
+}
+
+// searchIndexColumns parses `(ALL COLUMNS [WITH COLUMN OPTIONS(...)])` or `(column [WITH OPTIONS(...)], ...)`.
+// The column options only affect the index granularity, so they are ignored.
+func (p *statementParser) searchIndexColumns(stmt *SearchIndexStatement) error {
+	if err := p.expectSymbol("("); err != nil {
+		return err
+	}
+	if p.consumeKeywords("ALL", "COLUMNS") {
+		stmt.AllColumns = true
+		if p.consumeKeywords("WITH", "COLUMN", "OPTIONS") {
+			if err := p.skipParen(); err != nil {
+				return err
+			}
+		}
+		return p.expectSymbol(")")
+	}
+	for {
+		column := p.next()
+		switch column.kind {
+		case tokenWord:
+			stmt.Columns = append(stmt.Columns, column.text)
+		case tokenQuotedIdent:
+			stmt.Columns = append(stmt.Columns, strings.Trim(column.text, "`"))
+		default:
+			return fmt.Errorf("syntax error: expected column name but got %q", column.text)
+		}
+		if p.consumeKeywords("WITH", "OPTIONS") {
+			if err := p.skipParen(); err != nil {
+				return err
+			}
+		}
+		if p.consumeSymbol(")") {
+			return nil
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return err
+		}
+	}
+}
+
+// skipParen skips the tokens enclosed by the parentheses.
+func (p *statementParser) skipParen() error {
+	if !p.peek().isSymbol("(") {
+		return fmt.Errorf("syntax error: expected ( but got %q", p.peek().text)
+	}
+	p.idx = skipParen(p.tokens, p.idx) + 1
+	return nil
+}
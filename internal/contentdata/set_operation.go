@@ -0,0 +1,405 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxSetOperationRewrites is the maximum number of the set operations matching the columns by name in the query.
+const maxSetOperationRewrites = 32
+
+// setOperation is `[mode] {UNION | INTERSECT | EXCEPT} {ALL | DISTINCT} [BY NAME [ON (columns)] | CORRESPONDING [BY (columns)]]`.
+type setOperation struct {
+	// start is the index of the first token of the operation including the mode,
+	// and end is the index of the token next to the operation.
+	start int
+	end   int
+	// kind is the operation without the column matching like `UNION ALL`.
+	kind string
+	// mode is STRICT, INNER, FULL or LEFT of the operation matching the columns by name.
+	mode string
+	// byName is true if the columns of the inputs are matched by their names instead of their positions.
+	byName        bool
+	corresponding bool
+	// columns are the columns of the ON list of BY NAME or the BY list of CORRESPONDING.
+	columns []string
+	text    string
+}
+
+// setOperationChain is the sequence of the inputs combined by the same set operation.
+type setOperationChain struct {
+	tokens     []*token
+	inputs     []*setOperationInput
+	operations []*setOperation
+}
+
+// setOperationInput is the input query of the set operation in tokens[start:end].
+type setOperationInput struct {
+	start int
+	end   int
+}
+
+// byNameSetOperationChain returns the innermost chain of the set operations matching the columns by name in query,
+// or nil if query has no such set operations.
+// BigQuery supports only DISTINCT for INTERSECT and EXCEPT, so INTERSECT ALL and EXCEPT ALL are reported as the error.
+func byNameSetOperationChain(query string) (*setOperationChain, error) {
+	tokens := tokenize(query)
+	var (
+		found   *setOperationChain
+		visited = map[int]struct{}{}
+	)
+	for idx := range tokens {
+		if _, exists := visited[idx]; exists {
+			continue
+		}
+		op, err := parseSetOperation(query, tokens, idx)
+		if err != nil {
+			return nil, err
+		}
+		if op == nil {
+			continue
+		}
+		chain, err := parseSetOperationChain(query, tokens, op)
+		if err != nil {
+			return nil, err
+		}
+		if chain == nil {
+			continue
+		}
+		var byName bool
+		for _, op := range chain.operations {
+			for i := op.start; i < op.end; i++ {
+				visited[i] = struct{}{}
+			}
+			byName = byName || op.byName
+		}
+		if !byName {
+			continue
+		}
+		if err := chain.validateOperations(); err != nil {
+			return nil, err
+		}
+		if found == nil || tokens[chain.inputs[0].start].depth > tokens[found.inputs[0].start].depth {
+			found = chain
+		}
+	}
+	return found, nil
+}
+
+// parseSetOperation parses the set operation whose keyword is at tokens[idx].
+// It returns nil if tokens[idx] is not the keyword of the set operation.
+func parseSetOperation(query string, tokens []*token, idx int) (*setOperation, error) {
+	tk := tokens[idx]
+	if !tk.isKeyword("UNION") && !tk.isKeyword("INTERSECT") && !tk.isKeyword("EXCEPT") {
+		return nil, nil
+	}
+	if idx+1 >= len(tokens) || tokens[idx+1].isSymbol("(") {
+		// `SELECT * EXCEPT (column)`.
+		return nil, nil
+	}
+	quantifier := strings.ToUpper(tokens[idx+1].text)
+	if quantifier != "ALL" && quantifier != "DISTINCT" {
+		// go-zetasqlite reports the syntax error.
+		return nil, nil
+	}
+	op := &setOperation{start: idx, end: idx + 2, kind: strings.ToUpper(tk.text) + " " + quantifier}
+	if op.kind == "INTERSECT ALL" || op.kind == "EXCEPT ALL" {
+		return nil, fmt.Errorf("%s is not supported: use %s DISTINCT instead", op.kind, strings.ToUpper(tk.text))
+	}
+	switch {
+	case op.end+1 < len(tokens) && tokens[op.end].isKeyword("BY") && tokens[op.end+1].isKeyword("NAME"):
+		op.byName = true
+		op.end += 2
+		if op.end+1 < len(tokens) && tokens[op.end].isKeyword("ON") && tokens[op.end+1].isSymbol("(") {
+			op.columns, op.end = setOperationColumns(tokens, op.end+1)
+		}
+	case op.end < len(tokens) && tokens[op.end].isKeyword("CORRESPONDING"):
+		op.byName = true
+		op.corresponding = true
+		op.end++
+		if op.end+1 < len(tokens) && tokens[op.end].isKeyword("BY") && tokens[op.end+1].isSymbol("(") {
+			op.columns, op.end = setOperationColumns(tokens, op.end+1)
+		}
+	}
+	if op.byName {
+		switch {
+		case idx >= 2 && tokens[idx-1].isKeyword("OUTER") && (tokens[idx-2].isKeyword("FULL") || tokens[idx-2].isKeyword("LEFT")):
+			op.mode, op.start = strings.ToUpper(tokens[idx-2].text), idx-2
+		case idx >= 1 && (tokens[idx-1].isKeyword("FULL") || tokens[idx-1].isKeyword("LEFT") || tokens[idx-1].isKeyword("INNER")):
+			op.mode, op.start = strings.ToUpper(tokens[idx-1].text), idx-1
+		case idx >= 1 && op.corresponding && tokens[idx-1].isKeyword("STRICT"):
+			op.mode, op.start = "STRICT", idx-1
+		case op.corresponding:
+			op.mode = "INNER"
+		default:
+			op.mode = "STRICT"
+		}
+	}
+	op.text = strings.ToUpper(strings.Join(strings.Fields(tokenText(query, tokens[op.start:op.end])), " "))
+	return op, nil
+}
+
+// setOperationColumns returns the column names enclosed by the parentheses at tokens[open]
+// and the index of the token next to the closing parenthesis.
+func setOperationColumns(tokens []*token, open int) ([]string, int) {
+	closeIdx := skipParen(tokens, open)
+	var columns []string
+	for idx := open + 1; idx < closeIdx && idx < len(tokens); idx++ {
+		if tokens[idx].kind == tokenWord || tokens[idx].kind == tokenQuotedIdent {
+			columns = append(columns, strings.Trim(tokens[idx].text, "`"))
+		}
+	}
+	return columns, closeIdx + 1
+}
+
+// parseSetOperationChain returns the chain of the set operations starting with op.
+// It returns nil if the first input of the chain can't be found.
+func parseSetOperationChain(query string, tokens []*token, op *setOperation) (*setOperationChain, error) {
+	start := setOperationInputStart(tokens, op.start)
+	if start < 0 {
+		return nil, nil
+	}
+	depth := tokens[op.start].depth
+	chain := &setOperationChain{tokens: tokens, inputs: []*setOperationInput{{start: start, end: op.start}}}
+	for op != nil {
+		chain.operations = append(chain.operations, op)
+		var (
+			next *setOperation
+			end  = len(tokens)
+		)
+		for idx := op.end; idx < len(tokens); idx++ {
+			tk := tokens[idx]
+			if tk.depth < depth {
+				end = idx
+				break
+			}
+			if tk.depth != depth {
+				continue
+			}
+			if tk.isSymbol(";") || tk.isKeyword("ORDER") || tk.isKeyword("LIMIT") {
+				end = idx
+				break
+			}
+			nextOp, err := parseSetOperation(query, tokens, idx)
+			if err != nil {
+				return nil, err
+			}
+			if nextOp != nil {
+				next, end = nextOp, nextOp.start
+				break
+			}
+		}
+		if end <= op.end {
+			return nil, nil
+		}
+		chain.inputs = append(chain.inputs, &setOperationInput{start: op.end, end: end})
+		op = next
+	}
+	return chain, nil
+}
+
+// setOperationInputStart returns the index of the first token of the input query followed by tokens[end].
+// The input is the SELECT query or the parenthesized query.
+func setOperationInputStart(tokens []*token, end int) int {
+	if end == 0 {
+		return -1
+	}
+	depth := tokens[end].depth
+	for idx := end - 1; idx >= 0 && tokens[idx].depth >= depth; idx-- {
+		if tokens[idx].depth == depth && tokens[idx].isKeyword("SELECT") {
+			return idx
+		}
+	}
+	last := tokens[end-1]
+	if !last.isSymbol(")") || last.depth != depth {
+		return -1
+	}
+	for idx := end - 2; idx >= 0; idx-- {
+		if tokens[idx].depth == depth && tokens[idx].isSymbol("(") {
+			return idx
+		}
+	}
+	return -1
+}
+
+// validateOperations checks that the chain combines the inputs by the same set operation.
+func (c *setOperationChain) validateOperations() error {
+	first := c.operations[0]
+	for _, op := range c.operations[1:] {
+		if op.kind != first.kind || op.mode != first.mode || op.byName != first.byName || op.corresponding != first.corresponding ||
+			!strings.EqualFold(strings.Join(op.columns, ","), strings.Join(first.columns, ",")) {
+			return fmt.Errorf("Different set operations %s and %s can't be combined without parentheses", first.text, op.text)
+		}
+	}
+	return nil
+}
+
+// inputQuery returns the input query at idx without the enclosing parentheses.
+func (c *setOperationChain) inputQuery(query string, idx int) string {
+	input := c.inputs[idx]
+	if c.tokens[input.start].isSymbol("(") && skipParen(c.tokens, input.start) == input.end-1 {
+		return tokenText(query, c.tokens[input.start+1:input.end-1])
+	}
+	return tokenText(query, c.tokens[input.start:input.end])
+}
+
+// probe returns the query that selects the columns of the input at idx.
+// The WITH clauses of the statement and of the subquery that has the chain are kept,
+// so the input can refer to the common table expressions.
+func (c *setOperationChain) probe(query string, idx int) string {
+	tokens := c.tokens
+	first := c.inputs[0].start
+	depth := tokens[first].depth
+	scopeStart := 0
+	for i := first - 1; i >= 0; i-- {
+		if tokens[i].depth < depth {
+			scopeStart = i + 1
+			break
+		}
+	}
+	var localPrefix string
+	if tokens[scopeStart].isKeyword("WITH") {
+		localPrefix = query[tokens[scopeStart].start:tokens[first].start]
+	}
+	var prefix string
+	if scopeStart != 0 {
+		prefix = withClausePrefix(query, tokens)
+	}
+	return fmt.Sprintf("%sSELECT * FROM (%s%s) LIMIT 0", prefix, localPrefix, c.inputQuery(query, idx))
+}
+
+// withClausePrefix returns the WITH clause of the statement before its query, or empty string if the statement has no WITH clause.
+// The probe query of the input inside the statement starts with it, so the input can refer to the common table expressions.
+func withClausePrefix(query string, tokens []*token) string {
+	if len(tokens) == 0 || !tokens[0].isKeyword("WITH") {
+		return ""
+	}
+	for _, tk := range tokens[1:] {
+		if tk.depth == 0 && tk.isKeyword("SELECT") {
+			return query[:tk.start]
+		}
+	}
+	return ""
+}
+
+// outputColumns returns the columns of the result of the chain by inputColumns, the column names of the inputs.
+func (c *setOperationChain) outputColumns(inputColumns [][]string) ([]string, error) {
+	op := c.operations[0]
+	indexes := make([]map[string]struct{}, len(inputColumns))
+	for i, columns := range inputColumns {
+		indexes[i] = map[string]struct{}{}
+		for _, column := range columns {
+			if column == "" || strings.HasPrefix(column, "$") {
+				return nil, fmt.Errorf("%s requires the names of all columns but input %d has an anonymous column", op.text, i+1)
+			}
+			key := strings.ToLower(column)
+			if _, exists := indexes[i][key]; exists {
+				return nil, fmt.Errorf("Duplicate column name %s in input %d of %s", column, i+1, op.text)
+			}
+			indexes[i][key] = struct{}{}
+		}
+	}
+	has := func(i int, column string) bool {
+		_, exists := indexes[i][strings.ToLower(column)]
+		return exists
+	}
+	if len(op.columns) != 0 {
+		for _, column := range op.columns {
+			for i := range inputColumns {
+				switch {
+				case has(i, column):
+				case op.mode == "FULL":
+				case op.mode == "LEFT" && i != 0:
+				default:
+					return nil, fmt.Errorf("Column %s in the column list of %s is missing in input %d", column, op.text, i+1)
+				}
+			}
+		}
+		return op.columns, nil
+	}
+
+	var columns []string
+	switch op.mode {
+	case "STRICT":
+		for i := range inputColumns[1:] {
+			for _, column := range inputColumns[0] {
+				if !has(i+1, column) {
+					return nil, fmt.Errorf("Column %s is missing in input %d of %s: all inputs must have the same column names", column, i+2, op.text)
+				}
+			}
+			for _, column := range inputColumns[i+1] {
+				if !has(0, column) {
+					return nil, fmt.Errorf("Column %s is missing in input 1 of %s: all inputs must have the same column names", column, op.text)
+				}
+			}
+		}
+		columns = inputColumns[0]
+	case "INNER":
+		for _, column := range inputColumns[0] {
+			common := true
+			for i := range inputColumns {
+				common = common && has(i, column)
+			}
+			if common {
+				columns = append(columns, column)
+			}
+		}
+	case "LEFT":
+		columns = inputColumns[0]
+	case "FULL":
+		added := map[string]struct{}{}
+		for _, input := range inputColumns {
+			for _, column := range input {
+				if _, exists := added[strings.ToLower(column)]; exists {
+					continue
+				}
+				added[strings.ToLower(column)] = struct{}{}
+				columns = append(columns, column)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%s requires at least one column that is common to the inputs", op.text)
+	}
+	return columns, nil
+}
+
+// edit returns the edit that rewrites the chain into the set operation matching the columns by their positions.
+// Each input selects the output columns in the same order, and the missing columns are NULL.
+func (c *setOperationChain) edit(query string, inputColumns [][]string) (*edit, error) {
+	columns, err := c.outputColumns(inputColumns)
+	if err != nil {
+		return nil, err
+	}
+	var parts []string
+	for idx := range c.inputs {
+		names := map[string]string{}
+		for _, column := range inputColumns[idx] {
+			names[strings.ToLower(column)] = column
+		}
+		items := make([]string, 0, len(columns))
+		for _, column := range columns {
+			name, exists := names[strings.ToLower(column)]
+			switch {
+			case exists && idx == 0:
+				items = append(items, fmt.Sprintf("`%s` AS `%s`", name, column))
+			case exists:
+				items = append(items, fmt.Sprintf("`%s`", name))
+			case idx == 0:
+				items = append(items, fmt.Sprintf("NULL AS `%s`", column))
+			default:
+				items = append(items, "NULL")
+			}
+		}
+		parts = append(parts, fmt.Sprintf("SELECT %s FROM (%s)", strings.Join(items, ", "), c.inputQuery(query, idx)))
+		if idx < len(c.operations) {
+			parts = append(parts, c.operations[idx].kind)
+		}
+	}
+	last := c.inputs[len(c.inputs)-1]
+	return &edit{
+		start:       c.tokens[c.inputs[0].start].start,
+		end:         c.tokens[last.end-1].end,
+		replacement: strings.Join(parts, " "),
+	}, nil
+}
@@ -0,0 +1,364 @@
+package contentdata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SchemaStatement is the CREATE SCHEMA or DROP SCHEMA statement.
+// go-zetasqlite doesn't support the statements, so the emulator evaluates them with the metadata.
+type SchemaStatement struct {
+	Drop        bool
+	OrReplace   bool
+	IfNotExists bool
+	IfExists    bool
+	// Cascade drops the tables in the dataset. DROP SCHEMA without CASCADE fails when the dataset has tables.
+	Cascade bool
+	// ProjectID is empty when the schema name is not qualified by the project.
+	ProjectID string
+	DatasetID string
+	// DefaultCollation is specified by DEFAULT COLLATE clause.
+	DefaultCollation string
+	Options          []*Option
+}
+
+// Option is the name and the value of the OPTIONS clause.
+// Value is one of string, float64, bool, nil ( NULL ) and []interface{} ( ARRAY ).
+// The STRUCT or tuple value like ("key", "value") is []interface{} too, and the INTERVAL value like INTERVAL 4 HOUR is "4 HOUR".
+type Option struct {
+	Name  string
+	Value interface{}
+}
+
+// ParseSchemaStatement parses query as CREATE SCHEMA or DROP SCHEMA statement.
+// It returns nil without error when query is not the statement.
+func ParseSchemaStatement(query string) (*SchemaStatement, error) {
+	tokens := statementTokens(query)
+	if tokens == nil {
+		return nil, nil
+	}
+	p := &statementParser{tokens: tokens}
+	stmt := &SchemaStatement{}
+	switch {
+	case p.consumeKeywords("CREATE"):
+		if p.consumeKeywords("OR", "REPLACE") {
+			stmt.OrReplace = true
+		}
+		if !p.consumeKeywords("SCHEMA") {
+			return nil, nil
+		}
+		stmt.IfNotExists = p.consumeKeywords("IF", "NOT", "EXISTS")
+	case p.consumeKeywords("DROP"):
+		if !p.consumeKeywords("SCHEMA") {
+			return nil, nil
+		}
+		stmt.Drop = true
+		stmt.IfExists = p.consumeKeywords("IF", "EXISTS")
+	default:
+		return nil, nil
+	}
+	if stmt.OrReplace && stmt.IfNotExists {
+		return nil, fmt.Errorf("CREATE OR REPLACE SCHEMA cannot be used with IF NOT EXISTS")
+	}
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil, err
+	}
+	switch len(path) {
+	case 1:
+		stmt.DatasetID = path[0]
+	case 2:
+		stmt.ProjectID = path[0]
+		stmt.DatasetID = path[1]
+	default:
+		return nil, fmt.Errorf("invalid schema name %s", strings.Join(path, "."))
+	}
+	if stmt.Drop {
+		switch {
+		case p.consumeKeywords("CASCADE"):
+			stmt.Cascade = true
+		case p.consumeKeywords("RESTRICT"):
+		}
+	} else {
+		if p.consumeKeywords("DEFAULT", "COLLATE") {
+			tk := p.next()
+			collation, ok := stringLiteralValue(tk)
+			if !ok {
+				return nil, fmt.Errorf("DEFAULT COLLATE must be a string literal")
+			}
+			stmt.DefaultCollation = collation
+		}
+		if p.consumeKeywords("OPTIONS") {
+			options, err := p.options()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Options = options
+		}
+	}
+	if !p.eof() {
+		return nil, fmt.Errorf("syntax error: unexpected %s", p.peek().text)
+	}
+	return stmt, nil
+}
+
+// statementTokens returns the tokens of the single statement without the trailing semicolon.
+// It returns nil when query is empty or has multiple statements.
+func statementTokens(query string) []*token {
+	tokens := tokenize(query)
+	for len(tokens) != 0 && tokens[len(tokens)-1].isSymbol(";") {
+		tokens = tokens[:len(tokens)-1]
+	}
+	for _, tk := range tokens {
+		if tk.isSymbol(";") {
+			return nil
+		}
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	return tokens
+}
+
+type statementParser struct {
+	tokens []*token
+	idx    int
+}
+
+func (p *statementParser) eof() bool {
+	return p.idx >= len(p.tokens)
+}
+
+func (p *statementParser) peek() *token {
+	if p.eof() {
+		return &token{kind: tokenSymbol}
+	}
+	return p.tokens[p.idx]
+}
+
+func (p *statementParser) next() *token {
+	tk := p.peek()
+	p.idx++
+	return tk
+}
+
+// consumeKeywords consumes the keywords only if all of them follow.
+func (p *statementParser) consumeKeywords(kws ...string) bool {
+	if p.idx+len(kws) > len(p.tokens) {
+		return false
+	}
+	for i, kw := range kws {
+		if !p.tokens[p.idx+i].isKeyword(kw) {
+			return false
+		}
+	}
+	p.idx += len(kws)
+	return true
+}
+
+func (p *statementParser) consumeSymbol(sym string) bool {
+	if p.peek().isSymbol(sym) {
+		p.idx++
+		return true
+	}
+	return false
+}
+
+func (p *statementParser) expectSymbol(sym string) error {
+	if !p.consumeSymbol(sym) {
+		return fmt.Errorf("syntax error: expected %s but got %q", sym, p.peek().text)
+	}
+	return nil
+}
+
+// pathExpression parses the name like dataset, project.dataset, `project.dataset` or my-project.dataset.
+func (p *statementParser) pathExpression() ([]string, error) {
+	var (
+		b    strings.Builder
+		prev *token
+	)
+	for !p.eof() {
+		tk := p.peek()
+		if prev != nil && tk.start != prev.end {
+			break
+		}
+		if tk.kind == tokenQuotedIdent {
+			b.WriteString(strings.Trim(tk.text, "`"))
+		} else if tk.kind == tokenWord || tk.kind == tokenNumber || tk.isSymbol(".") || tk.isSymbol("-") {
+			b.WriteString(tk.text)
+		} else {
+			break
+		}
+		prev = p.next()
+	}
+	if prev == nil {
+		return nil, fmt.Errorf("syntax error: expected name but got %q", p.peek().text)
+	}
+	path := strings.Split(b.String(), ".")
+	for _, name := range path {
+		if name == "" {
+			return nil, fmt.Errorf("invalid name %s", b.String())
+		}
+	}
+	return path, nil
+}
+
+// options parses `(name = value, ...)` of the OPTIONS clause.
+func (p *statementParser) options() ([]*Option, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	var options []*Option
+	if p.consumeSymbol(")") {
+		return options, nil
+	}
+	for {
+		name := p.next()
+		if name.kind != tokenWord {
+			return nil, fmt.Errorf("syntax error: expected option name but got %q", name.text)
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		value, err := p.optionValue()
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for option %s: %w", name.text, err)
+		}
+		options = append(options, &Option{Name: strings.ToLower(name.text), Value: value})
+		if p.consumeSymbol(")") {
+			return options, nil
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// optionValue parses the literal value of the option.
+func (p *statementParser) optionValue() (interface{}, error) {
+	tk := p.peek()
+	switch {
+	case tk.kind == tokenString:
+		p.next()
+		v, ok := stringLiteralValue(tk)
+		if !ok {
+			return nil, fmt.Errorf("invalid string literal %s", tk.text)
+		}
+		return v, nil
+	case tk.kind == tokenNumber, tk.isSymbol("-"), tk.isSymbol("+"):
+		p.next()
+		sign := 1.0
+		if tk.kind == tokenSymbol {
+			if tk.text == "-" {
+				sign = -1
+			}
+			tk = p.next()
+		}
+		v, err := strconv.ParseFloat(tk.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number literal %s", tk.text)
+		}
+		return sign * v, nil
+	case tk.isKeyword("TRUE"), tk.isKeyword("FALSE"):
+		p.next()
+		return tk.isKeyword("TRUE"), nil
+	case tk.isKeyword("NULL"):
+		p.next()
+		return nil, nil
+	case tk.isKeyword("ARRAY"):
+		p.next()
+		if p.consumeSymbol("<") {
+			if err := p.skipTypeParameters(); err != nil {
+				return nil, err
+			}
+		}
+		return p.optionValue()
+	case tk.isKeyword("STRUCT"):
+		p.next()
+		if p.consumeSymbol("<") {
+			if err := p.skipTypeParameters(); err != nil {
+				return nil, err
+			}
+		}
+		return p.optionValues("(", ")")
+	case tk.isSymbol("["):
+		return p.optionValues("[", "]")
+	case tk.isSymbol("("):
+		return p.optionValues("(", ")")
+	case tk.isKeyword("HPARAM_RANGE"), tk.isKeyword("HPARAM_CANDIDATES"):
+		// the hyperparameter tuning values of CREATE MODEL.
+		p.next()
+		return p.optionValues("(", ")")
+	case tk.isKeyword("INTERVAL"):
+		// the interval of max_staleness of the external table.
+		p.next()
+		value := p.next()
+		if value.kind != tokenString && value.kind != tokenNumber {
+			return nil, fmt.Errorf("invalid interval literal %s", value.text)
+		}
+		text, _ := stringLiteralValue(value)
+		if value.kind == tokenNumber {
+			text = value.text
+		}
+		parts := []string{text}
+		for p.peek().kind == tokenWord && (p.peek().isKeyword("TO") || isKeywordIn(p.peek(), intervalParts)) {
+			parts = append(parts, strings.ToUpper(p.next().text))
+		}
+		if len(parts) == 1 {
+			return nil, fmt.Errorf("expected date part of interval but got %q", p.peek().text)
+		}
+		return strings.Join(parts, " "), nil
+	case tk.kind == tokenWord && p.idx+1 < len(p.tokens) && p.tokens[p.idx+1].kind == tokenString:
+		// the typed literal like TIMESTAMP '2024-01-01 00:00:00'.
+		p.next()
+		return p.optionValue()
+	}
+	return nil, fmt.Errorf("expected literal value but got %q", tk.text)
+}
+
+// optionValues parses the comma separated values enclosed by open and close.
+func (p *statementParser) optionValues(open, close string) ([]interface{}, error) {
+	if err := p.expectSymbol(open); err != nil {
+		return nil, err
+	}
+	values := []interface{}{}
+	if p.consumeSymbol(close) {
+		return values, nil
+	}
+	for {
+		v, err := p.optionValue()
+		if err != nil {
+			return nil, err
+		}
+		if p.consumeKeywords("AS") {
+			// the field name of the STRUCT.
+			p.next()
+		}
+		values = append(values, v)
+		if p.consumeSymbol(close) {
+			return values, nil
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// skipTypeParameters skips the type parameters like <STRING, STRING> after the opening angle bracket.
+func (p *statementParser) skipTypeParameters() error {
+	depth := 1
+	for !p.eof() {
+		tk := p.next()
+		switch {
+		case tk.isSymbol("<"):
+			depth++
+		case tk.isSymbol(">"):
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("syntax error: unterminated type parameters")
+}
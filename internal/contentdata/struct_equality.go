@@ -0,0 +1,312 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
+// comparisonLeftBoundaries are the keywords that end the left operand of the comparison.
+var comparisonLeftBoundaries = []string{
+	"AND", "OR", "NOT", "SELECT", "DISTINCT", "WHERE", "ON", "CASE", "WHEN", "THEN", "ELSE",
+}
+
+// comparisonRightBoundaries are the keywords that end the right operand of the comparison.
+var comparisonRightBoundaries = []string{
+	"AND", "OR", "WHEN", "THEN", "ELSE", "END", "AS", "FROM", "WHERE", "GROUP", "HAVING", "QUALIFY", "WINDOW",
+	"ORDER", "LIMIT", "JOIN", "INNER", "LEFT", "RIGHT", "FULL", "CROSS", "ON", "USING", "UNION", "INTERSECT", "EXCEPT",
+}
+
+// structEqualityBlock is the query block that has the equality comparisons whose operands may be STRUCT values.
+// go-zetasqlite compares STRUCT values by the field names, ignores the NULL semantics and fails on the NULL fields,
+// so the comparisons of STRUCT values are rewritten into the comparisons of their fields.
+type structEqualityBlock struct {
+	comparisons []*structComparison
+	// probe is the query that selects the operands of the comparisons from the FROM clause of the block
+	// to check their types.
+	probe *typeProbe
+}
+
+// structComparison is `left = right`, `left != right` or `left <> right`.
+type structComparison struct {
+	start  int
+	end    int
+	left   *comparisonOperand
+	right  *comparisonOperand
+	negate bool
+}
+
+// comparisonOperand is the operand of the comparison that can be a STRUCT value.
+type comparisonOperand struct {
+	text string
+	// path is true if the operand is the path expression like `t.s`.
+	path bool
+	// fields are the field expressions of the STRUCT constructor or the tuple like (a, b).
+	fields []string
+}
+
+// structEqualityBlocks returns the query blocks of query that compare the path expressions, the STRUCT constructors
+// or the tuples in the SELECT list, the FROM clause ( JOIN ... ON ) and the WHERE clause.
+// The types of the operands are unknown until the probe runs, so they may be the scalar values.
+func structEqualityBlocks(query string) []*structEqualityBlock {
+	tokens := tokenize(query)
+	var blocks []*structEqualityBlock
+	for idx, tk := range tokens {
+		if !tk.isKeyword("SELECT") {
+			continue
+		}
+		block := findQueryBlock(tokens, idx)
+		if block == nil || block.start != idx {
+			continue
+		}
+		end := block.end
+		for _, clause := range []string{"GROUP", "HAVING", "QUALIFY", "WINDOW", "ORDER", "LIMIT"} {
+			if clauseIdx, exists := block.clauses[clause]; exists && clauseIdx < end {
+				end = clauseIdx
+			}
+		}
+		var (
+			comparisons []*structComparison
+			operands    []string
+		)
+		for i := block.start + 1; i < end; i++ {
+			if tokens[i].isSymbol("(") && i+1 < end && (tokens[i+1].isKeyword("SELECT") || tokens[i+1].isKeyword("WITH")) {
+				i = skipParen(tokens, i)
+				continue
+			}
+			cmp := parseStructComparison(query, tokens, i, block.start, end)
+			if cmp == nil {
+				continue
+			}
+			comparisons = append(comparisons, cmp)
+			operands = append(operands, cmp.left.text, cmp.right.text)
+		}
+		if len(comparisons) == 0 {
+			continue
+		}
+		blocks = append(blocks, &structEqualityBlock{
+			comparisons: comparisons,
+			probe:       block.typeProbe(query, tokens, operands),
+		})
+	}
+	return blocks
+}
+
+// parseStructComparison parses the comparison whose operator is at tokens[idx].
+// It returns nil if tokens[idx] is not the equality operator or the operands can't be STRUCT values.
+func parseStructComparison(query string, tokens []*token, idx, start, end int) *structComparison {
+	tk := tokens[idx]
+	var (
+		opStart = idx
+		opEnd   = idx + 1
+		negate  bool
+	)
+	adjacent := func(a, b int) bool {
+		return a >= 0 && b < len(tokens) && tokens[a].end == tokens[b].start
+	}
+	switch {
+	case tk.isSymbol("="):
+		if adjacent(idx-1, idx) && (tokens[idx-1].isSymbol("<") || tokens[idx-1].isSymbol(">")) {
+			return nil
+		}
+		if adjacent(idx, idx+1) && tokens[idx+1].isSymbol(">") {
+			// the named argument like `name => value`.
+			return nil
+		}
+		if adjacent(idx-1, idx) && tokens[idx-1].isSymbol("!") {
+			opStart = idx - 1
+			negate = true
+		}
+	case tk.isSymbol("<") && adjacent(idx, idx+1) && tokens[idx+1].isSymbol(">"):
+		opEnd = idx + 2
+		negate = true
+	default:
+		return nil
+	}
+	depth := tk.depth
+	leftStart := opStart
+	for leftStart > start+1 {
+		prev := tokens[leftStart-1]
+		if prev.depth < depth || (prev.depth == depth && (prev.isSymbol(",") || isKeywordIn(prev, comparisonLeftBoundaries))) {
+			break
+		}
+		leftStart--
+	}
+	rightEnd := opEnd
+	for rightEnd < end {
+		next := tokens[rightEnd]
+		if next.isSymbol("<") && (tokens[rightEnd-1].isKeyword("STRUCT") || tokens[rightEnd-1].isKeyword("ARRAY")) {
+			// the commas of the type parameters like STRUCT<a INT64, b STRING> don't end the operand.
+			rightEnd = skipTypeParameters(tokens, rightEnd, end)
+			continue
+		}
+		if next.depth < depth || (next.depth == depth && (next.isSymbol(",") || next.isSymbol(";") || isKeywordIn(next, comparisonRightBoundaries))) {
+			break
+		}
+		rightEnd++
+	}
+	if leftStart == opStart || rightEnd == opEnd {
+		return nil
+	}
+	left := parseComparisonOperand(query, tokens[leftStart:opStart])
+	right := parseComparisonOperand(query, tokens[opEnd:rightEnd])
+	if left == nil || right == nil {
+		return nil
+	}
+	return &structComparison{
+		start:  tokens[leftStart].start,
+		end:    tokens[rightEnd-1].end,
+		left:   left,
+		right:  right,
+		negate: negate,
+	}
+}
+
+// parseComparisonOperand returns the operand if tokens is the path expression, the STRUCT constructor or the tuple.
+func parseComparisonOperand(query string, tokens []*token) *comparisonOperand {
+	if len(tokens) == 0 {
+		return nil
+	}
+	for _, tk := range tokens {
+		if tk.isKeyword("SELECT") || tk.isSymbol("=") || tk.isSymbol("!") {
+			return nil
+		}
+		if (tk.isSymbol("<") || tk.isSymbol(">")) && !tokens[0].isKeyword("STRUCT") {
+			return nil
+		}
+	}
+	text := tokenText(query, tokens)
+	if implicitColumnName(tokens) != "" && !(tokens[0].kind == tokenWord && isReservedKeyword(tokens[0])) {
+		return &comparisonOperand{text: text, path: true}
+	}
+	open := 0
+	if tokens[0].isKeyword("STRUCT") {
+		open = 1
+		if len(tokens) > 1 && tokens[1].isSymbol("<") {
+			open = skipTypeParameters(tokens, 1, len(tokens))
+		}
+	}
+	if open >= len(tokens) || !tokens[open].isSymbol("(") || skipParen(tokens, open) != len(tokens)-1 {
+		return nil
+	}
+	args := functionArgs(query, tokens, open, len(tokens)-1)
+	if open == 0 && len(args) < 2 {
+		// the parenthesized expression is not the tuple.
+		return nil
+	}
+	fields := make([]string, 0, len(args))
+	for _, arg := range args {
+		argTokens := tokenize(arg)
+		if n := len(argTokens); n >= 3 && argTokens[n-2].isKeyword("AS") {
+			arg = strings.TrimSpace(arg[:argTokens[n-2].start])
+		}
+		fields = append(fields, arg)
+	}
+	return &comparisonOperand{text: text, fields: fields}
+}
+
+// skipTypeParameters returns the index after the closing angle bracket of the type parameters at tokens[open].
+func skipTypeParameters(tokens []*token, open, end int) int {
+	level := 0
+	for idx := open; idx < end; idx++ {
+		if tokens[idx].isSymbol("<") {
+			level++
+		} else if tokens[idx].isSymbol(">") {
+			level--
+		}
+		if level == 0 {
+			return idx + 1
+		}
+	}
+	return end
+}
+
+func isKeywordIn(tk *token, keywords []string) bool {
+	for _, kw := range keywords {
+		if tk.isKeyword(kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// edits returns the edits that rewrite the comparisons of STRUCT values with the types of their operands.
+// fields are the columns of the probe, the types of the left and the right operands of each comparison.
+func (b *structEqualityBlock) edits(fields []*bigqueryv2.TableFieldSchema) []*edit {
+	if len(fields) != 2*len(b.comparisons) {
+		return nil
+	}
+	var edits []*edit
+	for idx, cmp := range b.comparisons {
+		left, right := fields[2*idx], fields[2*idx+1]
+		if !isStructField(left) || !isStructField(right) {
+			continue
+		}
+		expr, ok := structEqualityExpr(cmp.left, cmp.right, left, right)
+		if !ok {
+			continue
+		}
+		if cmp.negate {
+			expr = fmt.Sprintf("(NOT %s)", expr)
+		}
+		edits = append(edits, &edit{start: cmp.start, end: cmp.end, replacement: expr})
+	}
+	return edits
+}
+
+func isStructField(field *bigqueryv2.TableFieldSchema) bool {
+	return field.Mode != "REPEATED" && (field.Type == "RECORD" || field.Type == "STRUCT")
+}
+
+// structEqualityExpr returns the conjunction of the comparisons of the fields.
+// As BigQuery does, the result is FALSE if any fields are not equal, NULL if any fields are NULL and TRUE otherwise.
+// The fields are compared by the position, so the field names of the operands may differ.
+func structEqualityExpr(left, right *comparisonOperand, leftField, rightField *bigqueryv2.TableFieldSchema) (string, bool) {
+	if !isStructField(leftField) || !isStructField(rightField) {
+		if leftField.Mode == "REPEATED" || rightField.Mode == "REPEATED" {
+			// the equality of ARRAY is rejected by the analyzer.
+			return "", false
+		}
+		return fmt.Sprintf("(%s) = (%s)", left.text, right.text), true
+	}
+	if len(leftField.Fields) == 0 || len(leftField.Fields) != len(rightField.Fields) {
+		return "", false
+	}
+	conds := make([]string, 0, len(leftField.Fields))
+	for idx := range leftField.Fields {
+		l, ok := left.field(idx, leftField.Fields[idx])
+		if !ok {
+			return "", false
+		}
+		r, ok := right.field(idx, rightField.Fields[idx])
+		if !ok {
+			return "", false
+		}
+		cond, ok := structEqualityExpr(l, r, leftField.Fields[idx], rightField.Fields[idx])
+		if !ok {
+			return "", false
+		}
+		conds = append(conds, cond)
+	}
+	return fmt.Sprintf("(%s)", strings.Join(conds, " AND ")), true
+}
+
+// field returns the operand of the idx-th field of the STRUCT operand.
+// The field of the path expression is read by the name, so the anonymous field can't be read.
+func (o *comparisonOperand) field(idx int, field *bigqueryv2.TableFieldSchema) (*comparisonOperand, bool) {
+	if o.fields != nil {
+		if idx >= len(o.fields) {
+			return nil, false
+		}
+		text := o.fields[idx]
+		if operand := parseComparisonOperand(text, tokenize(text)); operand != nil {
+			return operand, true
+		}
+		return &comparisonOperand{text: text}, true
+	}
+	if !o.path || field.Name == "" {
+		return nil, false
+	}
+	return &comparisonOperand{text: fmt.Sprintf("%s.`%s`", o.text, field.Name), path: true}, true
+}
@@ -0,0 +1,463 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
+// maxTableFunctionDepth is the maximum depth of the table function calls in the bodies of the table functions.
+const maxTableFunctionDepth = 16
+
+// TableFunctionStatement is the CREATE TABLE FUNCTION or DROP TABLE FUNCTION statement.
+// go-zetasqlite doesn't evaluate the table-valued functions, so the emulator stores the function as the routine
+// and expands its calls in the FROM clauses into the subqueries.
+type TableFunctionStatement struct {
+	Drop        bool
+	OrReplace   bool
+	IfNotExists bool
+	IfExists    bool
+	// FunctionPath is the name of the function split by the dot. It has one to three elements.
+	FunctionPath []string
+	Arguments    []*TableFunctionArgument
+	// Returns are the columns of RETURNS TABLE<...>. It is empty if the clause is omitted.
+	Returns []*TableFunctionArgument
+	Query   string
+}
+
+// TableFunctionArgument is the argument of the table function or the column of its result.
+// Type is the type like INT64, ANY TYPE or TABLE<x INT64>.
+type TableFunctionArgument struct {
+	Name string
+	Type string
+}
+
+// IsTable reports whether the argument is the table.
+func (a *TableFunctionArgument) IsTable() bool {
+	return strings.HasPrefix(strings.ToUpper(a.Type), "TABLE")
+}
+
+// IsAnyType reports whether the argument is the templated argument of ANY TYPE.
+func (a *TableFunctionArgument) IsAnyType() bool {
+	return strings.EqualFold(strings.Join(strings.Fields(a.Type), " "), "ANY TYPE")
+}
+
+// TableFunction is the definition of the table function that its calls are expanded by.
+type TableFunction struct {
+	// Name is the full name of the function to detect the recursive calls.
+	Name      string
+	Arguments []*TableFunctionArgument
+	Returns   []*TableFunctionArgument
+	Query     string
+}
+
+// ParseTableFunctionStatement parses query as CREATE TABLE FUNCTION or DROP TABLE FUNCTION statement.
+// It returns nil without error when query is not the statement.
+func ParseTableFunctionStatement(query string) (*TableFunctionStatement, error) {
+	tokens := statementTokens(query)
+	if tokens == nil || !isTableFunctionStatement(tokens) {
+		return nil, nil
+	}
+	p := &statementParser{tokens: tokens}
+	stmt := &TableFunctionStatement{}
+	if p.consumeKeywords("DROP", "TABLE", "FUNCTION") {
+		stmt.Drop = true
+		stmt.IfExists = p.consumeKeywords("IF", "EXISTS")
+	} else {
+		p.consumeKeywords("CREATE")
+		stmt.OrReplace = p.consumeKeywords("OR", "REPLACE")
+		p.consumeKeywords("TABLE", "FUNCTION")
+		stmt.IfNotExists = p.consumeKeywords("IF", "NOT", "EXISTS")
+		if stmt.OrReplace && stmt.IfNotExists {
+			return nil, fmt.Errorf("CREATE OR REPLACE TABLE FUNCTION cannot be used with IF NOT EXISTS")
+		}
+	}
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil, err
+	}
+	if len(path) > 3 {
+		return nil, fmt.Errorf("invalid table function name %s", strings.Join(path, "."))
+	}
+	stmt.FunctionPath = path
+	if stmt.Drop {
+		if !p.eof() {
+			return nil, fmt.Errorf("syntax error: unexpected %s", p.peek().text)
+		}
+		return stmt, nil
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	if !p.consumeSymbol(")") {
+		args, err := p.tableFunctionArguments(query, ")")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Arguments = args
+	}
+	if p.consumeKeywords("RETURNS") {
+		if !p.consumeKeywords("TABLE") || !p.consumeSymbol("<") {
+			return nil, fmt.Errorf("syntax error: expected TABLE<...> after RETURNS but got %q", p.peek().text)
+		}
+		columns, err := p.tableFunctionArguments(query, ">")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Returns = columns
+	}
+	if p.consumeKeywords("OPTIONS") {
+		if _, err := p.options(); err != nil {
+			return nil, err
+		}
+	}
+	if !p.consumeKeywords("AS") {
+		return nil, fmt.Errorf("syntax error: expected AS but got %q", p.peek().text)
+	}
+	if p.eof() {
+		return nil, fmt.Errorf("syntax error: expected query after AS")
+	}
+	stmt.Query = query[p.peek().start:tokens[len(tokens)-1].end]
+	return stmt, nil
+}
+
+// isTableFunctionStatement reports whether tokens is the statement of the table function.
+func isTableFunctionStatement(tokens []*token) bool {
+	idx := 0
+	switch {
+	case len(tokens) > 1 && tokens[0].isKeyword("CREATE"):
+		idx = 1
+		if len(tokens) > 3 && tokens[1].isKeyword("OR") && tokens[2].isKeyword("REPLACE") {
+			idx = 3
+		}
+	case len(tokens) > 1 && tokens[0].isKeyword("DROP"):
+		idx = 1
+	default:
+		return false
+	}
+	return idx+1 < len(tokens) && tokens[idx].isKeyword("TABLE") && tokens[idx+1].isKeyword("FUNCTION")
+}
+
+// tableFunctionArguments parses `name type, ...` until the closing symbol.
+func (p *statementParser) tableFunctionArguments(query, closing string) ([]*TableFunctionArgument, error) {
+	var args []*TableFunctionArgument
+	for {
+		name := p.next()
+		if name.kind != tokenWord && name.kind != tokenQuotedIdent {
+			return nil, fmt.Errorf("syntax error: expected argument name but got %q", name.text)
+		}
+		typ, err := p.typeText(query)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, &TableFunctionArgument{Name: strings.Trim(name.text, "`"), Type: typ})
+		if p.consumeSymbol(closing) {
+			return args, nil
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// typeText returns the text of the type like INT64, NUMERIC(10, 2) or STRUCT<a INT64, b STRING>.
+// The type ends with the comma, the closing parenthesis or the closing angle bracket out of the type.
+func (p *statementParser) typeText(query string) (string, error) {
+	var (
+		start = p.idx
+		angle int
+		paren int
+	)
+	for !p.eof() {
+		tk := p.peek()
+		if angle == 0 && paren == 0 && (tk.isSymbol(",") || tk.isSymbol(")") || tk.isSymbol(">")) {
+			break
+		}
+		switch {
+		case tk.isSymbol("<"):
+			angle++
+		case tk.isSymbol(">"):
+			angle--
+		case tk.isSymbol("("):
+			paren++
+		case tk.isSymbol(")"):
+			paren--
+		}
+		p.next()
+	}
+	if p.idx == start {
+		return "", fmt.Errorf("syntax error: expected type but got %q", p.peek().text)
+	}
+	return tokenText(query, p.tokens[start:p.idx]), nil
+}
+
+// StandardSQLDataType parses the type like INT64, ARRAY<STRING> or STRUCT<a INT64> into the data type of the routine.
+func StandardSQLDataType(typ string) (*bigqueryv2.StandardSqlDataType, error) {
+	p := &statementParser{tokens: tokenize(typ)}
+	dataType, err := p.standardSQLDataType()
+	if err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		return nil, fmt.Errorf("invalid type %s", typ)
+	}
+	return dataType, nil
+}
+
+func (p *statementParser) standardSQLDataType() (*bigqueryv2.StandardSqlDataType, error) {
+	tk := p.next()
+	if tk.kind != tokenWord {
+		return nil, fmt.Errorf("syntax error: expected type but got %q", tk.text)
+	}
+	name := strings.ToUpper(tk.text)
+	switch name {
+	case "ARRAY", "RANGE":
+		if err := p.expectSymbol("<"); err != nil {
+			return nil, err
+		}
+		elem, err := p.standardSQLDataType()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(">"); err != nil {
+			return nil, err
+		}
+		if name == "RANGE" {
+			return &bigqueryv2.StandardSqlDataType{TypeKind: name, RangeElementType: elem}, nil
+		}
+		return &bigqueryv2.StandardSqlDataType{TypeKind: name, ArrayElementType: elem}, nil
+	case "STRUCT":
+		if err := p.expectSymbol("<"); err != nil {
+			return nil, err
+		}
+		structType := &bigqueryv2.StandardSqlStructType{}
+		for !p.consumeSymbol(">") {
+			if len(structType.Fields) != 0 {
+				if err := p.expectSymbol(","); err != nil {
+					return nil, err
+				}
+			}
+			field := &bigqueryv2.StandardSqlField{}
+			// the field name is omitted if the type follows the comma.
+			if next := p.idx + 1; next < len(p.tokens) && !p.tokens[next].isSymbol(",") && !p.tokens[next].isSymbol(">") &&
+				!p.tokens[next].isSymbol("<") && !p.tokens[next].isSymbol("(") {
+				field.Name = strings.Trim(p.next().text, "`")
+			}
+			fieldType, err := p.standardSQLDataType()
+			if err != nil {
+				return nil, err
+			}
+			field.Type = fieldType
+			structType.Fields = append(structType.Fields, field)
+		}
+		return &bigqueryv2.StandardSqlDataType{TypeKind: name, StructType: structType}, nil
+	}
+	// the type parameters like STRING(10) don't change the type kind.
+	if p.peek().isSymbol("(") {
+		if err := p.skipParen(); err != nil {
+			return nil, err
+		}
+	}
+	switch name {
+	case "INT", "INTEGER", "SMALLINT", "BIGINT", "TINYINT", "BYTEINT":
+		name = "INT64"
+	case "FLOAT":
+		name = "FLOAT64"
+	case "BOOLEAN":
+		name = "BOOL"
+	case "DECIMAL":
+		name = "NUMERIC"
+	case "BIGDECIMAL":
+		name = "BIGNUMERIC"
+	}
+	return &bigqueryv2.StandardSqlDataType{TypeKind: name}, nil
+}
+
+// StandardSQLTypeName returns the type name of the data type of the routine like ARRAY<STRUCT<a INT64>>.
+func StandardSQLTypeName(typ *bigqueryv2.StandardSqlDataType) string {
+	if typ == nil {
+		return ""
+	}
+	switch typ.TypeKind {
+	case "ARRAY":
+		return fmt.Sprintf("ARRAY<%s>", StandardSQLTypeName(typ.ArrayElementType))
+	case "RANGE":
+		return fmt.Sprintf("RANGE<%s>", StandardSQLTypeName(typ.RangeElementType))
+	case "STRUCT":
+		var fields []string
+		if typ.StructType != nil {
+			for _, field := range typ.StructType.Fields {
+				if field.Name == "" {
+					fields = append(fields, StandardSQLTypeName(field.Type))
+					continue
+				}
+				fields = append(fields, fmt.Sprintf("`%s` %s", field.Name, StandardSQLTypeName(field.Type)))
+			}
+		}
+		return fmt.Sprintf("STRUCT<%s>", strings.Join(fields, ", "))
+	}
+	return typ.TypeKind
+}
+
+// ExpandTableFunctions expands the calls of the table functions in the FROM clauses of query into the subqueries.
+// resolve returns the definition of the function by the path of the call, or nil if the path is not a table function.
+// The calls in the bodies of the functions are expanded too, and the recursive call is an error.
+func ExpandTableFunctions(query string, resolve func(path []string) (*TableFunction, error)) (string, error) {
+	return expandTableFunctions(query, resolve, nil)
+}
+
+func expandTableFunctions(query string, resolve func(path []string) (*TableFunction, error), stack []string) (string, error) {
+	tokens := tokenize(query)
+	var edits []*edit
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		if !isTableExpressionStart(tokens, idx) {
+			continue
+		}
+		p := &statementParser{tokens: tokens, idx: idx + 1}
+		path, err := p.pathExpression()
+		if err != nil || !p.peek().isSymbol("(") {
+			continue
+		}
+		openIdx := p.idx
+		closeIdx := skipParen(tokens, openIdx)
+		if closeIdx >= len(tokens) {
+			continue
+		}
+		fn, err := resolve(path)
+		if err != nil {
+			return "", err
+		}
+		if fn == nil {
+			continue
+		}
+		for _, name := range stack {
+			if strings.EqualFold(name, fn.Name) {
+				return "", fmt.Errorf("Table function %s is called recursively", fn.Name)
+			}
+		}
+		if len(stack) >= maxTableFunctionDepth {
+			return "", fmt.Errorf("Table function %s exceeds the maximum depth %d of the nested calls", fn.Name, maxTableFunctionDepth)
+		}
+		body, err := fn.expand(functionArgs(query, tokens, openIdx, closeIdx))
+		if err != nil {
+			return "", err
+		}
+		body, err = expandTableFunctions(body, resolve, append(append([]string{}, stack...), fn.Name))
+		if err != nil {
+			return "", err
+		}
+		replacement := fmt.Sprintf("(%s)", body)
+		if !hasTableAlias(tokens, closeIdx+1) {
+			replacement += fmt.Sprintf(" AS `%s`", path[len(path)-1])
+		}
+		edits = append(edits, &edit{start: tokens[idx+1].start, end: tokens[closeIdx].end, replacement: replacement})
+		idx = closeIdx
+	}
+	return applyEdits(query, edits), nil
+}
+
+// isTableExpressionStart reports whether the table expression of the FROM clause follows tokens[idx],
+// that is FROM, JOIN or the comma of the FROM clause.
+func isTableExpressionStart(tokens []*token, idx int) bool {
+	tk := tokens[idx]
+	if tk.isKeyword("FROM") || tk.isKeyword("JOIN") {
+		return true
+	}
+	if !tk.isSymbol(",") {
+		return false
+	}
+	block := findQueryBlock(tokens, idx)
+	if block == nil || tokens[block.start].depth != tk.depth {
+		return false
+	}
+	fromIdx, exists := block.clauses["FROM"]
+	if !exists || fromIdx > idx {
+		return false
+	}
+	for _, clause := range blockClauses {
+		if clauseIdx, exists := block.clauses[clause]; exists && clauseIdx > fromIdx && clauseIdx < idx {
+			return false
+		}
+	}
+	return true
+}
+
+// expand returns the body of the function whose arguments are replaced with args.
+// The scalar argument is replaced with the value cast to the type of the argument, and the table argument
+// is replaced with the subquery of the table.
+func (f *TableFunction) expand(args []string) (string, error) {
+	if len(args) != len(f.Arguments) {
+		return "", fmt.Errorf(
+			"No matching signature for table function %s: expected %d arguments but got %d",
+			f.Name, len(f.Arguments), len(args),
+		)
+	}
+	tokens := tokenize(f.Query)
+	var edits []*edit
+	for idx, tk := range tokens {
+		if tk.kind != tokenWord && tk.kind != tokenQuotedIdent {
+			continue
+		}
+		// the qualified column, the alias and the function call are not the arguments.
+		if idx > 0 && (tokens[idx-1].isSymbol(".") || tokens[idx-1].isKeyword("AS")) {
+			continue
+		}
+		if idx+1 < len(tokens) && tokens[idx+1].isSymbol("(") {
+			continue
+		}
+		argIdx := -1
+		for i, arg := range f.Arguments {
+			if strings.EqualFold(arg.Name, strings.Trim(tk.text, "`")) {
+				argIdx = i
+				break
+			}
+		}
+		if argIdx < 0 {
+			continue
+		}
+		arg, value := f.Arguments[argIdx], args[argIdx]
+		var replacement string
+		switch {
+		case arg.IsTable():
+			if idx+1 < len(tokens) && tokens[idx+1].isSymbol(".") {
+				// the argument qualifies the column.
+				continue
+			}
+			table, err := tableArgument(f.Name, arg, value)
+			if err != nil {
+				return "", err
+			}
+			replacement = table
+			if !hasTableAlias(tokens, idx+1) {
+				replacement += fmt.Sprintf(" AS `%s`", arg.Name)
+			}
+		case arg.IsAnyType():
+			replacement = fmt.Sprintf("(%s)", value)
+		default:
+			replacement = fmt.Sprintf("CAST((%s) AS %s)", value, arg.Type)
+		}
+		edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: replacement})
+	}
+	body := applyEdits(f.Query, edits)
+	if len(f.Returns) == 0 {
+		return body, nil
+	}
+	columns := make([]string, 0, len(f.Returns))
+	for _, column := range f.Returns {
+		columns = append(columns, fmt.Sprintf("CAST(`%[1]s` AS %[2]s) AS `%[1]s`", column.Name, column.Type))
+	}
+	return fmt.Sprintf("SELECT %s FROM (%s)", strings.Join(columns, ", "), body), nil
+}
+
+// tableArgument returns the subquery of the table argument given as `TABLE name` or the subquery.
+func tableArgument(name string, arg *TableFunctionArgument, value string) (string, error) {
+	tokens := tokenize(value)
+	switch {
+	case len(tokens) > 1 && tokens[0].isKeyword("TABLE"):
+		return fmt.Sprintf("(SELECT * FROM %s)", value[tokens[1].start:]), nil
+	case len(tokens) > 1 && tokens[0].isSymbol("(") && skipParen(tokens, 0) == len(tokens)-1:
+		return value, nil
+	}
+	return "", fmt.Errorf("Argument %s of table function %s must be TABLE name or a subquery but got %s", arg.Name, name, value)
+}
@@ -0,0 +1,355 @@
+package contentdata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nonDeterministicFunctions return the different value for each call.
+var nonDeterministicFunctions = map[string]bool{
+	"RAND":          true,
+	"GENERATE_UUID": true,
+}
+
+// WithClause is the top-level WITH clause of the query.
+// BigQuery evaluates a common table expression once per query, but go-zetasqlite leaves it to SQLite
+// that may evaluate the subquery for each reference. The emulator replaces the entries that must be
+// evaluated once with their results.
+type WithClause struct {
+	query   string
+	Entries []*WithEntry
+
+	// main is the tokens of the statement following the WITH clause.
+	main []*token
+	// tokens are the tokens of the whole statement, and main starts at mainStart of them.
+	tokens    []*token
+	mainStart int
+	scopes    []*withScope
+}
+
+// WithEntry is the common table expression of the WITH clause.
+type WithEntry struct {
+	Name string
+	// Query is the subquery of the entry. Replacing it changes the query built by WithClause.
+	Query string
+	// NonDeterministic is true if the subquery calls the function like RAND() or GENERATE_UUID().
+	NonDeterministic bool
+	// Uses is the number of the times the entry is referenced from the query,
+	// including the references through the other entries.
+	Uses int
+
+	start int
+	end   int
+}
+
+// ParseWithClause parses the WITH clause of the query statement or the DML statement.
+// It returns nil when query doesn't start with WITH or uses WITH RECURSIVE.
+func ParseWithClause(query string) *WithClause {
+	tokens := statementTokens(query)
+	if tokens == nil {
+		return nil
+	}
+	p := &statementParser{tokens: tokens}
+	if !p.consumeKeywords("WITH") || p.peek().isKeyword("RECURSIVE") {
+		return nil
+	}
+	var (
+		entries []*WithEntry
+		bodies  [][2]int
+	)
+	for {
+		name := p.next()
+		if name.kind != tokenWord && name.kind != tokenQuotedIdent {
+			return nil
+		}
+		if !p.consumeKeywords("AS") || !p.peek().isSymbol("(") {
+			return nil
+		}
+		open := p.idx
+		end := skipParen(tokens, open)
+		if end >= len(tokens) {
+			return nil
+		}
+		body := tokens[open+1 : end]
+		if len(body) == 0 {
+			return nil
+		}
+		entries = append(entries, &WithEntry{
+			Name:             strings.Trim(name.text, "`"),
+			Query:            query[body[0].start:body[len(body)-1].end],
+			NonDeterministic: hasNonDeterministicCall(body),
+			start:            body[0].start,
+			end:              body[len(body)-1].end,
+		})
+		bodies = append(bodies, [2]int{open + 1, end})
+		p.idx = end + 1
+		if !p.consumeSymbol(",") {
+			break
+		}
+	}
+	if p.eof() {
+		return nil
+	}
+	scopes := withScopes(tokens)
+	for i := len(entries) - 1; i >= 0; i-- {
+		uses := tableReferences(tokens, scopes, p.idx, len(tokens), entries[i].Name)
+		for j := i + 1; j < len(entries); j++ {
+			uses += tableReferences(tokens, scopes, bodies[j][0], bodies[j][1], entries[i].Name) * entries[j].Uses
+		}
+		entries[i].Uses = uses
+	}
+	return &WithClause{query: query, Entries: entries, main: tokens[p.idx:], tokens: tokens, mainStart: p.idx, scopes: scopes}
+}
+
+// IsDML reports whether the WITH clause precedes INSERT, UPDATE, DELETE or MERGE rather than the query.
+func (w *WithClause) IsDML() bool {
+	first := w.main[0]
+	return first.isKeyword("INSERT") || first.isKeyword("UPDATE") || first.isKeyword("DELETE") || first.isKeyword("MERGE")
+}
+
+// cteJoinKeywords are the keywords that can follow the table in the FROM clause and are not reserved.
+var cteJoinKeywords = []string{"JOIN", "INNER", "LEFT", "RIGHT", "FULL", "CROSS", "GROUP", "ORDER", "WINDOW", "QUALIFY", "FOR"}
+
+// DMLStatement returns the DML statement without the WITH clause, where the tables referring to the entries
+// in FROM, JOIN and USING of MERGE are replaced with the subqueries of the entries, because go-zetasqlite
+// accepts WITH only before the query. The subqueries keep the names of the entries as their aliases,
+// so the columns qualified with the names like `src.id` refer to them too.
+// The names shadowed by the WITH clause of a subquery refer to the entries of the subquery and are kept.
+func (w *WithClause) DMLStatement() string {
+	entries := map[string]*WithEntry{}
+	for _, entry := range w.Entries {
+		entries[strings.ToLower(entry.Name)] = entry
+	}
+	tokens := w.main
+	var edits []*edit
+	for idx := 1; idx < len(tokens); idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord && tk.kind != tokenQuotedIdent {
+			continue
+		}
+		entry, exists := entries[strings.ToLower(strings.Trim(tk.text, "`"))]
+		if !exists || !isCTETableReference(tokens, idx) {
+			continue
+		}
+		if scope, _ := resolveWithEntry(w.scopes, w.tokens, w.mainStart+idx); scope == nil || scope.withIdx != 0 {
+			continue
+		}
+		if idx == 2 && tokens[0].isKeyword("DELETE") {
+			// the target table of `DELETE FROM target`.
+			continue
+		}
+		replacement := fmt.Sprintf("(%s)", entry.Query)
+		if !hasTableAlias(tokens, idx+1) {
+			replacement += " AS " + tk.text
+		}
+		edits = append(edits, &edit{start: tk.start, end: tk.end, replacement: replacement})
+	}
+	start := tokens[0].start
+	statement := w.query[start:]
+	for _, e := range edits {
+		e.start -= start
+		e.end -= start
+	}
+	return applyEdits(statement, edits)
+}
+
+// isCTETableReference reports whether the name at idx is the table of FROM, JOIN or USING.
+// The name following the comma refers to the table if the comma is in the FROM clause.
+func isCTETableReference(tokens []*token, idx int) bool {
+	if idx+1 < len(tokens) && (tokens[idx+1].isSymbol(".") || tokens[idx+1].isSymbol("(")) {
+		return false
+	}
+	prev := tokens[idx-1]
+	if prev.isKeyword("FROM") || prev.isKeyword("JOIN") || prev.isKeyword("USING") {
+		return true
+	}
+	if !prev.isSymbol(",") {
+		return false
+	}
+	depth := tokens[idx].depth
+	for i := idx - 2; i >= 0 && tokens[i].depth >= depth; i-- {
+		if tokens[i].depth != depth || tokens[i].kind != tokenWord {
+			continue
+		}
+		if tokens[i].isKeyword("FROM") {
+			return true
+		}
+		if isReservedKeyword(tokens[i]) || tokens[i].isKeyword("JOIN") {
+			return false
+		}
+	}
+	return false
+}
+
+// hasTableAlias reports whether the table expression is followed by `[AS] alias` at idx.
+func hasTableAlias(tokens []*token, idx int) bool {
+	if idx >= len(tokens) {
+		return false
+	}
+	next := tokens[idx]
+	if next.isKeyword("AS") || next.kind == tokenQuotedIdent {
+		return true
+	}
+	if next.kind != tokenWord || isReservedKeyword(next) {
+		return false
+	}
+	for _, kw := range cteJoinKeywords {
+		if next.isKeyword(kw) {
+			return false
+		}
+	}
+	return true
+}
+
+// EntryQuery returns the query that evaluates the entry at idx with the preceding entries.
+func (w *WithClause) EntryQuery(idx int) string {
+	if idx == 0 {
+		return w.Entries[0].Query
+	}
+	defs := make([]string, 0, idx)
+	for _, entry := range w.Entries[:idx] {
+		defs = append(defs, fmt.Sprintf("`%s` AS (%s)", entry.Name, entry.Query))
+	}
+	query := w.Entries[idx].Query
+	if tokens := tokenize(query); len(tokens) != 0 && tokens[0].isKeyword("WITH") {
+		query = fmt.Sprintf("SELECT * FROM (%s)", query)
+	}
+	return fmt.Sprintf("WITH %s %s", strings.Join(defs, ", "), query)
+}
+
+// String returns the query with the current subqueries of the entries.
+func (w *WithClause) String() string {
+	edits := make([]*edit, 0, len(w.Entries))
+	for _, entry := range w.Entries {
+		edits = append(edits, &edit{start: entry.start, end: entry.end, replacement: entry.Query})
+	}
+	return applyEdits(w.query, edits)
+}
+
+func hasNonDeterministicCall(tokens []*token) bool {
+	for idx, tk := range tokens {
+		if tk.kind != tokenWord || !nonDeterministicFunctions[strings.ToUpper(tk.text)] {
+			continue
+		}
+		if idx > 0 && tokens[idx-1].isSymbol(".") {
+			continue
+		}
+		if idx+1 < len(tokens) && tokens[idx+1].isSymbol("(") {
+			return true
+		}
+	}
+	return false
+}
+
+// tableReferences counts the names in tokens[start:end] that refer to the common table expression
+// of the top-level WITH clause. The part of the path, the alias, the function name and the names shadowed
+// by the WITH clause of a subquery are not the references.
+func tableReferences(tokens []*token, scopes []*withScope, start, end int, name string) int {
+	var refs int
+	for idx := start; idx < end; idx++ {
+		tk := tokens[idx]
+		if tk.kind != tokenWord && tk.kind != tokenQuotedIdent {
+			continue
+		}
+		if !strings.EqualFold(strings.Trim(tk.text, "`"), name) {
+			continue
+		}
+		if idx > 0 && (tokens[idx-1].isSymbol(".") || tokens[idx-1].isKeyword("AS")) {
+			continue
+		}
+		if idx+1 < len(tokens) && (tokens[idx+1].isSymbol(".") || tokens[idx+1].isSymbol("(")) {
+			continue
+		}
+		if scope, _ := resolveWithEntry(scopes, tokens, idx); scope != nil && scope.withIdx != 0 {
+			continue
+		}
+		refs++
+	}
+	return refs
+}
+
+// withScope is the WITH clause of the query or a subquery and the range of the tokens where its entries are visible.
+type withScope struct {
+	withIdx   int
+	recursive bool
+	entries   []*withScopeEntry
+	// end is the index of the token following the query of the WITH clause.
+	end int
+}
+
+// withScopeEntry is the name and the parentheses of the body of the common table expression.
+type withScopeEntry struct {
+	name    string
+	nameIdx int
+	open    int
+	close   int
+}
+
+// withScopes returns the WITH clauses in tokens including the ones of the subqueries in the order of the positions.
+func withScopes(tokens []*token) []*withScope {
+	var scopes []*withScope
+	for idx, tk := range tokens {
+		if !tk.isKeyword("WITH") {
+			continue
+		}
+		scope := &withScope{withIdx: idx, end: len(tokens)}
+		next := idx + 1
+		if next < len(tokens) && tokens[next].isKeyword("RECURSIVE") {
+			scope.recursive = true
+			next++
+		}
+		for next+2 < len(tokens) && (tokens[next].kind == tokenWord || tokens[next].kind == tokenQuotedIdent) &&
+			tokens[next+1].isKeyword("AS") && tokens[next+2].isSymbol("(") {
+			closeIdx := skipParen(tokens, next+2)
+			scope.entries = append(scope.entries, &withScopeEntry{
+				name:    strings.Trim(tokens[next].text, "`"),
+				nameIdx: next,
+				open:    next + 2,
+				close:   closeIdx,
+			})
+			next = closeIdx + 1
+			if next >= len(tokens) || !tokens[next].isSymbol(",") {
+				break
+			}
+			next++
+		}
+		if len(scope.entries) == 0 {
+			// WITH OFFSET, WITH ACTION and so on.
+			continue
+		}
+		for k := next; k < len(tokens); k++ {
+			if tokens[k].depth < tk.depth || (tokens[k].depth == tk.depth && tokens[k].isSymbol(";")) {
+				scope.end = k
+				break
+			}
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+// resolveWithEntry returns the innermost WITH clause and its entry that the name at idx refers to,
+// or nil if the name refers to the table. The entry is visible in the bodies of the following entries
+// and the query of the clause, and in its own body if the clause is RECURSIVE.
+func resolveWithEntry(scopes []*withScope, tokens []*token, idx int) (*withScope, *withScopeEntry) {
+	name := strings.Trim(tokens[idx].text, "`")
+	var (
+		resolved      *withScope
+		resolvedEntry *withScopeEntry
+	)
+	for _, scope := range scopes {
+		// the scopes are in order, so the later scope containing idx is nested in the former one.
+		if idx <= scope.withIdx || idx >= scope.end {
+			continue
+		}
+		for _, entry := range scope.entries {
+			if !strings.EqualFold(entry.name, name) {
+				continue
+			}
+			if idx > entry.close || (scope.recursive && idx > entry.open) {
+				resolved, resolvedEntry = scope, entry
+			}
+		}
+	}
+	return resolved, resolvedEntry
+}
@@ -0,0 +1,90 @@
+package contentdata
+
+// WrittenObject is the table, the view, the routine, the model or the dataset that the statement changes.
+type WrittenObject struct {
+	// Path is the name of the object split by the dot. It has one to three elements.
+	Path []string
+	// Schema reports whether Path is the name of the dataset of CREATE, ALTER or DROP SCHEMA.
+	Schema bool
+}
+
+// ParseWrittenObject parses the object changed by the DML statement or CREATE, ALTER, DROP and TRUNCATE statement.
+// It returns nil when query is not the statement, the statement creates the temporary object of the session
+// or the object can't be parsed.
+func ParseWrittenObject(query string) *WrittenObject {
+	tokens := statementTokens(query)
+	if tokens == nil {
+		return nil
+	}
+	if tokens[0].isKeyword("WITH") {
+		with := ParseWithClause(query)
+		if with == nil || !with.IsDML() {
+			return nil
+		}
+		tokens = with.main
+	}
+	p := &statementParser{tokens: tokens}
+	switch {
+	case p.consumeKeywords("INSERT"), p.consumeKeywords("MERGE"):
+		p.consumeKeywords("INTO")
+	case p.consumeKeywords("DELETE"):
+		p.consumeKeywords("FROM")
+	case p.consumeKeywords("UPDATE"), p.consumeKeywords("TRUNCATE", "TABLE"):
+	case p.consumeKeywords("CREATE"), p.consumeKeywords("ALTER"), p.consumeKeywords("DROP"):
+		return p.writtenDefinition()
+	default:
+		return nil
+	}
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil
+	}
+	return &WrittenObject{Path: path}
+}
+
+// writtenDefinition parses the object following CREATE, ALTER or DROP.
+func (p *statementParser) writtenDefinition() *WrittenObject {
+	p.consumeKeywords("OR", "REPLACE")
+	if p.consumeKeywords("TEMP") || p.consumeKeywords("TEMPORARY") {
+		// the temporary objects don't belong to the datasets.
+		return nil
+	}
+	object := &WrittenObject{}
+	switch {
+	case p.consumeKeywords("SEARCH", "INDEX"), p.consumeKeywords("VECTOR", "INDEX"), p.consumeKeywords("ROW", "ACCESS", "POLICY"):
+		// `name ON table` changes the table.
+		p.consumeKeywords("IF", "NOT", "EXISTS")
+		p.consumeKeywords("IF", "EXISTS")
+		if _, err := p.pathExpression(); err != nil {
+			return nil
+		}
+		if !p.consumeKeywords("ON") {
+			return nil
+		}
+	case p.consumeKeywords("ALL", "ROW", "ACCESS", "POLICIES"):
+		if !p.consumeKeywords("ON") {
+			return nil
+		}
+	case p.consumeKeywords("SCHEMA"):
+		object.Schema = true
+	default:
+		for _, kw := range []string{"EXTERNAL", "MATERIALIZED", "SNAPSHOT", "AGGREGATE"} {
+			p.consumeKeywords(kw)
+		}
+		switch {
+		case p.consumeKeywords("TABLE"):
+			p.consumeKeywords("FUNCTION")
+		case p.consumeKeywords("VIEW"), p.consumeKeywords("FUNCTION"), p.consumeKeywords("PROCEDURE"), p.consumeKeywords("MODEL"):
+		default:
+			return nil
+		}
+	}
+	p.consumeKeywords("IF", "NOT", "EXISTS")
+	p.consumeKeywords("IF", "EXISTS")
+	path, err := p.pathExpression()
+	if err != nil {
+		return nil
+	}
+	object.Path = path
+	return object
+}
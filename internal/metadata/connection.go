@@ -0,0 +1,87 @@
+package metadata
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/goccy/go-json"
+	bigqueryconnection "google.golang.org/api/bigqueryconnection/v1"
+)
+
+// Connection is the connection resource of the BigQuery Connection API, which EXTERNAL_QUERY refers to.
+// Location is lowercase like `us` or `asia-northeast1`.
+type Connection struct {
+	ID        string
+	ProjectID string
+	Location  string
+	metadata  map[string]interface{}
+	repo      *Repository
+}
+
+func (c *Connection) Insert(ctx context.Context, tx *sql.Tx) error {
+	return c.repo.AddConnection(ctx, tx, c)
+}
+
+func (c *Connection) Delete(ctx context.Context, tx *sql.Tx) error {
+	return c.repo.DeleteConnection(ctx, tx, c)
+}
+
+// Name returns the resource name like `projects/p/locations/us/connections/c`.
+func (c *Connection) Name() string {
+	return fmt.Sprintf("projects/%s/locations/%s/connections/%s", c.ProjectID, c.Location, c.ID)
+}
+
+func (c *Connection) Content() (*bigqueryconnection.Connection, error) {
+	encoded, err := json.Marshal(c.metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	var v bigqueryconnection.Connection
+	if err := json.Unmarshal(encoded, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata to connection: %w", err)
+	}
+	v.Name = c.Name()
+	return &v, nil
+}
+
+// SetContent replaces the metadata of the connection with content.
+func (c *Connection) SetContent(ctx context.Context, tx *sql.Tx, content *bigqueryconnection.Connection) error {
+	metadata, err := connectionMetadata(content)
+	if err != nil {
+		return err
+	}
+	c.metadata = metadata
+	return c.repo.UpdateConnection(ctx, tx, c)
+}
+
+// NewConnectionWithContent creates the connection whose metadata is content.
+func NewConnectionWithContent(repo *Repository, projectID, location, connectionID string, content *bigqueryconnection.Connection) (*Connection, error) {
+	metadata, err := connectionMetadata(content)
+	if err != nil {
+		return nil, err
+	}
+	return NewConnection(repo, projectID, location, connectionID, metadata), nil
+}
+
+func connectionMetadata(content *bigqueryconnection.Connection) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode connection: %w", err)
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(encoded, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode connection to metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+func NewConnection(repo *Repository, projectID, location, connectionID string, metadata map[string]interface{}) *Connection {
+	return &Connection{
+		ID:        connectionID,
+		ProjectID: projectID,
+		Location:  location,
+		metadata:  metadata,
+		repo:      repo,
+	}
+}
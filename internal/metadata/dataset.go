@@ -11,6 +11,8 @@ import (
 )
 
 var ErrDuplicatedTable = errors.New("table is already created")
+var ErrDuplicatedModel = errors.New("model is already created")
+var ErrDuplicatedRoutine = errors.New("routine is already created")
 
 type Dataset struct {
 	ID         string
@@ -61,6 +63,9 @@ func (d *Dataset) Content() *bigqueryv2.Dataset {
 }
 
 func (d *Dataset) UpdateContentIfExists(newContent *bigqueryv2.Dataset) {
+	if newContent.Access != nil {
+		d.content.Access = newContent.Access
+	}
 	if newContent.Description != "" {
 		d.content.Description = newContent.Description
 	}
@@ -108,14 +113,35 @@ func (d *Dataset) Delete(ctx context.Context, tx *sql.Tx) error {
 	return d.repo.DeleteDataset(ctx, tx, d)
 }
 
+func (d *Dataset) AddModel(ctx context.Context, tx *sql.Tx, model *Model) error {
+	d.mu.Lock()
+	if _, exists := d.modelMap[model.ID]; exists {
+		d.mu.Unlock()
+		return fmt.Errorf("model %s: %w", model.ID, ErrDuplicatedModel)
+	}
+	if err := model.Insert(ctx, tx); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	d.models = append(d.models, model)
+	d.modelMap[model.ID] = model
+	d.mu.Unlock()
+
+	if err := d.repo.UpdateDataset(ctx, tx, d); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (d *Dataset) DeleteModel(ctx context.Context, tx *sql.Tx, id string) error {
 	d.mu.Lock()
-	defer d.mu.Unlock()
 	model, exists := d.modelMap[id]
 	if !exists {
+		d.mu.Unlock()
 		return fmt.Errorf("model '%s' is not found in dataset '%s'", id, d.ID)
 	}
 	if err := model.Delete(ctx, tx); err != nil {
+		d.mu.Unlock()
 		return err
 	}
 	newModels := make([]*Model, 0, len(d.models))
@@ -127,6 +153,57 @@ func (d *Dataset) DeleteModel(ctx context.Context, tx *sql.Tx, id string) error
 	}
 	d.models = newModels
 	delete(d.modelMap, id)
+	d.mu.Unlock()
+
+	// UpdateDataset reads the ids with the read lock.
+	if err := d.repo.UpdateDataset(ctx, tx, d); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *Dataset) AddRoutine(ctx context.Context, tx *sql.Tx, routine *Routine) error {
+	d.mu.Lock()
+	if _, exists := d.routineMap[routine.ID]; exists {
+		d.mu.Unlock()
+		return fmt.Errorf("routine %s: %w", routine.ID, ErrDuplicatedRoutine)
+	}
+	if err := routine.Insert(ctx, tx); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	d.routines = append(d.routines, routine)
+	d.routineMap[routine.ID] = routine
+	d.mu.Unlock()
+
+	if err := d.repo.UpdateDataset(ctx, tx, d); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *Dataset) DeleteRoutine(ctx context.Context, tx *sql.Tx, id string) error {
+	d.mu.Lock()
+	routine, exists := d.routineMap[id]
+	if !exists {
+		d.mu.Unlock()
+		return fmt.Errorf("routine '%s' is not found in dataset '%s'", id, d.ID)
+	}
+	if err := routine.Delete(ctx, tx); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	newRoutines := make([]*Routine, 0, len(d.routines))
+	for _, routine := range d.routines {
+		if routine.ID == id {
+			continue
+		}
+		newRoutines = append(newRoutines, routine)
+	}
+	d.routines = newRoutines
+	delete(d.routineMap, id)
+	d.mu.Unlock()
+
 	if err := d.repo.UpdateDataset(ctx, tx, d); err != nil {
 		return err
 	}
@@ -153,6 +230,34 @@ func (d *Dataset) AddTable(ctx context.Context, tx *sql.Tx, table *Table) error
 	return nil
 }
 
+func (d *Dataset) DeleteTable(ctx context.Context, tx *sql.Tx, id string) error {
+	d.mu.Lock()
+	table, exists := d.tableMap[id]
+	if !exists {
+		d.mu.Unlock()
+		return fmt.Errorf("table '%s' is not found in dataset '%s'", id, d.ID)
+	}
+	if err := table.Delete(ctx, tx); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	newTables := make([]*Table, 0, len(d.tables))
+	for _, table := range d.tables {
+		if table.ID == id {
+			continue
+		}
+		newTables = append(newTables, table)
+	}
+	d.tables = newTables
+	delete(d.tableMap, id)
+	d.mu.Unlock()
+
+	if err := d.repo.UpdateDataset(ctx, tx, d); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (d *Dataset) Table(id string) *Table {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
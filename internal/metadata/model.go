@@ -3,6 +3,10 @@ package metadata
 import (
 	"context"
 	"database/sql"
+	"fmt"
+
+	"github.com/goccy/go-json"
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
 )
 
 type Model struct {
@@ -21,6 +25,56 @@ func (m *Model) Delete(ctx context.Context, tx *sql.Tx) error {
 	return m.repo.DeleteModel(ctx, tx, m)
 }
 
+func (m *Model) Content() (*bigqueryv2.Model, error) {
+	encoded, err := json.Marshal(m.metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	var v bigqueryv2.Model
+	if err := json.Unmarshal(encoded, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata to model: %w", err)
+	}
+	if v.ModelReference == nil {
+		v.ModelReference = &bigqueryv2.ModelReference{
+			ProjectId: m.ProjectID,
+			DatasetId: m.DatasetID,
+			ModelId:   m.ID,
+		}
+	}
+	return &v, nil
+}
+
+// SetContent replaces the metadata of the model with content.
+func (m *Model) SetContent(ctx context.Context, tx *sql.Tx, content *bigqueryv2.Model) error {
+	metadata, err := modelMetadata(content)
+	if err != nil {
+		return err
+	}
+	m.metadata = metadata
+	return m.repo.UpdateModel(ctx, tx, m)
+}
+
+// NewModelWithContent creates the model whose metadata is content.
+func NewModelWithContent(repo *Repository, projectID, datasetID, modelID string, content *bigqueryv2.Model) (*Model, error) {
+	metadata, err := modelMetadata(content)
+	if err != nil {
+		return nil, err
+	}
+	return NewModel(repo, projectID, datasetID, modelID, metadata), nil
+}
+
+func modelMetadata(content *bigqueryv2.Model) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode model: %w", err)
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(encoded, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode model to metadata: %w", err)
+	}
+	return metadata, nil
+}
+
 func NewModel(repo *Repository, projectID, datasetID, modelID string, metadata map[string]interface{}) *Model {
 	return &Model{
 		ID:        modelID,
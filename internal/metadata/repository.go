@@ -64,9 +64,33 @@ CREATE TABLE IF NOT EXISTS routines (
   datasetID STRING NOT NULL,
   metadata  STRING,
   PRIMARY KEY (projectID, datasetID, id)
+)`,
+	`
+CREATE TABLE IF NOT EXISTS connections (
+  id        STRING NOT NULL,
+  projectID STRING NOT NULL,
+  location  STRING NOT NULL,
+  metadata  STRING,
+  PRIMARY KEY (projectID, location, id)
+)`,
+	`
+CREATE TABLE IF NOT EXISTS schema_version (
+  id      STRING NOT NULL,
+  version INT64 NOT NULL,
+  PRIMARY KEY (id)
 )`,
 }
 
+// schemaVersion is the version of the metadata tables.
+// Increment it and add the DDL to migrations when the metadata tables are changed.
+const schemaVersion int64 = 1
+
+// migrations[v] upgrades the metadata tables from the version v to v+1.
+var migrations = map[int64][]string{}
+
+// metadataTables are the tables that have the metadata column encoded as JSON.
+var metadataTables = []string{"jobs", "datasets", "tables", "models", "routines", "connections"}
+
 type Repository struct {
 	db *sql.DB
 }
@@ -77,16 +101,95 @@ func NewRepository(db *sql.DB) (*Repository, error) {
 		return nil, err
 	}
 	defer tx.Commit()
+	ctx := context.Background()
 	for _, ddl := range schemata {
-		if _, err := tx.ExecContext(context.Background(), ddl); err != nil {
+		if _, err := tx.ExecContext(ctx, ddl); err != nil {
 			return nil, err
 		}
 	}
+	if err := migrate(ctx, tx); err != nil {
+		return nil, err
+	}
+	if err := verify(ctx, tx); err != nil {
+		return nil, err
+	}
 	return &Repository{
 		db: db,
 	}, nil
 }
 
+// migrate upgrades the metadata tables created by the older version to the current schema version.
+func migrate(ctx context.Context, tx *sql.Tx) error {
+	var version int64
+	if err := tx.QueryRowContext(ctx, "SELECT version FROM schema_version WHERE id = 'metadata'").Scan(&version); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get schema version: %w", err)
+		}
+		if _, err := tx.ExecContext(
+			ctx,
+			"INSERT schema_version (id, version) VALUES ('metadata', @version)",
+			sql.Named("version", schemaVersion),
+		); err != nil {
+			return fmt.Errorf("failed to set schema version: %w", err)
+		}
+		return nil
+	}
+	if version == schemaVersion {
+		return nil
+	}
+	if version > schemaVersion {
+		return fmt.Errorf(
+			"the database was created by the newer version of bigquery-emulator: schema version %d is not supported (current %d)",
+			version, schemaVersion,
+		)
+	}
+	for ; version < schemaVersion; version++ {
+		for _, ddl := range migrations[version] {
+			if _, err := tx.ExecContext(ctx, ddl); err != nil {
+				return fmt.Errorf("failed to migrate schema version from %d: %w", version, err)
+			}
+		}
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		"UPDATE schema_version SET version = @version WHERE id = 'metadata'",
+		sql.Named("version", schemaVersion),
+	); err != nil {
+		return fmt.Errorf("failed to update schema version: %w", err)
+	}
+	return nil
+}
+
+// verify detects the corrupted metadata before serving it.
+func verify(ctx context.Context, tx *sql.Tx) error {
+	for _, table := range metadataTables {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT id, metadata FROM %s", table))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", table, err)
+		}
+		for rows.Next() {
+			var (
+				id       string
+				metadata sql.NullString
+			)
+			if err := rows.Scan(&id, &metadata); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to read %s: %w", table, err)
+			}
+			if metadata.Valid && !json.Valid([]byte(metadata.String)) {
+				rows.Close()
+				return fmt.Errorf("metadata of %s in %s is corrupted", id, table)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read %s: %w", table, err)
+		}
+		rows.Close()
+	}
+	return nil
+}
+
 func (r *Repository) getConnection(ctx context.Context) (*sql.Conn, error) {
 	conn, err := r.db.Conn(ctx)
 	if err != nil {
@@ -130,7 +233,15 @@ func (r *Repository) DatasetFromData(projectID string, data *types.Dataset) *Dat
 	for _, routine := range data.Routines {
 		routines = append(routines, r.RoutineFromData(projectID, data.ID, routine))
 	}
-	return NewDataset(r, projectID, data.ID, nil, tables, models, routines)
+	var content *bigqueryv2.Dataset
+	if data.Location != "" || len(data.Labels) != 0 || data.DefaultTableExpirationMs != 0 {
+		content = &bigqueryv2.Dataset{
+			Location:                 data.Location,
+			Labels:                   data.Labels,
+			DefaultTableExpirationMs: data.DefaultTableExpirationMs,
+		}
+	}
+	return NewDataset(r, projectID, data.ID, content, tables, models, routines)
 }
 
 func (r *Repository) JobFromData(projectID string, data *types.Job) *Job {
@@ -868,6 +979,107 @@ func (r *Repository) DeleteRoutine(ctx context.Context, tx *sql.Tx, routine *Rou
 	return nil
 }
 
+// FindConnection returns the connection of the location, or nil if it doesn't exist.
+func (r *Repository) FindConnection(ctx context.Context, tx *sql.Tx, projectID, location, connectionID string) (*Connection, error) {
+	connections, err := r.findConnections(
+		ctx, tx,
+		"SELECT id, metadata FROM connections WHERE projectID = @projectID AND location = @location AND id = @id",
+		projectID, location,
+		sql.Named("id", connectionID),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(connections) != 1 {
+		return nil, nil
+	}
+	return connections[0], nil
+}
+
+// FindConnections returns the connections of the location ordered by the id.
+func (r *Repository) FindConnections(ctx context.Context, tx *sql.Tx, projectID, location string) ([]*Connection, error) {
+	return r.findConnections(
+		ctx, tx,
+		"SELECT id, metadata FROM connections WHERE projectID = @projectID AND location = @location ORDER BY id",
+		projectID, location,
+	)
+}
+
+func (r *Repository) findConnections(ctx context.Context, tx *sql.Tx, query, projectID, location string, args ...interface{}) ([]*Connection, error) {
+	args = append([]interface{}{sql.Named("projectID", projectID), sql.Named("location", location)}, args...)
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	connections := []*Connection{}
+	for rows.Next() {
+		var (
+			connectionID string
+			metadata     string
+		)
+		if err := rows.Scan(&connectionID, &metadata); err != nil {
+			return nil, err
+		}
+		var content map[string]interface{}
+		if err := json.Unmarshal([]byte(metadata), &content); err != nil {
+			return nil, err
+		}
+		connections = append(
+			connections,
+			NewConnection(r, projectID, location, connectionID, content),
+		)
+	}
+	return connections, rows.Err()
+}
+
+func (r *Repository) AddConnection(ctx context.Context, tx *sql.Tx, connection *Connection) error {
+	metadata, err := json.Marshal(connection.metadata)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"INSERT connections (id, projectID, location, metadata) VALUES (@id, @projectID, @location, @metadata)",
+		sql.Named("id", connection.ID),
+		sql.Named("projectID", connection.ProjectID),
+		sql.Named("location", connection.Location),
+		sql.Named("metadata", string(metadata)),
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) UpdateConnection(ctx context.Context, tx *sql.Tx, connection *Connection) error {
+	metadata, err := json.Marshal(connection.metadata)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"UPDATE connections SET metadata = @metadata WHERE projectID = @projectID AND location = @location AND id = @id",
+		sql.Named("id", connection.ID),
+		sql.Named("projectID", connection.ProjectID),
+		sql.Named("location", connection.Location),
+		sql.Named("metadata", string(metadata)),
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) DeleteConnection(ctx context.Context, tx *sql.Tx, connection *Connection) error {
+	if _, err := tx.Exec(
+		"DELETE FROM connections WHERE projectID = @projectID AND location = @location AND id = @id",
+		sql.Named("id", connection.ID),
+		sql.Named("projectID", connection.ProjectID),
+		sql.Named("location", connection.Location),
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (r *Repository) convertToStrings(v []interface{}) []string {
 	ret := make([]string, 0, len(v))
 	for _, vv := range v {
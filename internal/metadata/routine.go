@@ -1,5 +1,14 @@
 package metadata
 
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/goccy/go-json"
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
 type Routine struct {
 	ID        string
 	ProjectID string
@@ -8,6 +17,46 @@ type Routine struct {
 	repo      *Repository
 }
 
+func (r *Routine) Insert(ctx context.Context, tx *sql.Tx) error {
+	return r.repo.AddRoutine(ctx, tx, r)
+}
+
+func (r *Routine) Delete(ctx context.Context, tx *sql.Tx) error {
+	return r.repo.DeleteRoutine(ctx, tx, r)
+}
+
+func (r *Routine) Content() (*bigqueryv2.Routine, error) {
+	encoded, err := json.Marshal(r.metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	var v bigqueryv2.Routine
+	if err := json.Unmarshal(encoded, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata to routine: %w", err)
+	}
+	if v.RoutineReference == nil {
+		v.RoutineReference = &bigqueryv2.RoutineReference{
+			ProjectId: r.ProjectID,
+			DatasetId: r.DatasetID,
+			RoutineId: r.ID,
+		}
+	}
+	return &v, nil
+}
+
+// NewRoutineWithContent creates the routine whose metadata is content.
+func NewRoutineWithContent(repo *Repository, projectID, datasetID, routineID string, content *bigqueryv2.Routine) (*Routine, error) {
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode routine: %w", err)
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(encoded, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode routine to metadata: %w", err)
+	}
+	return NewRoutine(repo, projectID, datasetID, routineID, metadata), nil
+}
+
 func NewRoutine(repo *Repository, projectID, datasetID, routineID string, metadata map[string]interface{}) *Routine {
 	return &Routine{
 		ID:        routineID,
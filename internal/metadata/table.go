@@ -21,6 +21,26 @@ func (t *Table) Update(ctx context.Context, tx *sql.Tx, metadata map[string]inte
 	return t.repo.UpdateTable(ctx, tx, t)
 }
 
+// UpdateContent replaces the table resource of the metadata with content.
+// The metadata that is not the field of bigqueryv2.Table like the search index and the row access policies is kept.
+func (t *Table) UpdateContent(ctx context.Context, tx *sql.Tx, content *bigqueryv2.Table) error {
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("failed to encode table: %w", err)
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(encoded, &metadata); err != nil {
+		return fmt.Errorf("failed to decode table to metadata: %w", err)
+	}
+	for _, key := range []string{searchIndexKey, rowAccessPoliciesKey} {
+		if v, exists := t.metadata[key]; exists {
+			metadata[key] = v
+		}
+	}
+	t.metadata = metadata
+	return t.repo.UpdateTable(ctx, tx, t)
+}
+
 func (t *Table) Insert(ctx context.Context, tx *sql.Tx) error {
 	return t.repo.AddTable(ctx, tx, t)
 }
@@ -50,3 +70,93 @@ func NewTable(repo *Repository, projectID, datasetID, tableID string, metadata m
 		repo:      repo,
 	}
 }
+
+// searchIndexKey is the key of the table metadata that stores the search index.
+// It is not the field of bigqueryv2.Table, so it is not included in Content.
+const searchIndexKey = "searchIndex"
+
+// SearchIndex is the search index created by CREATE SEARCH INDEX.
+// BigQuery allows one search index per table.
+type SearchIndex struct {
+	Name string `json:"name"`
+	// Columns is empty if the index is created with ALL COLUMNS.
+	Columns         []string `json:"columns,omitempty"`
+	Analyzer        string   `json:"analyzer"`
+	AnalyzerOptions string   `json:"analyzerOptions,omitempty"`
+	DataTypes       []string `json:"dataTypes,omitempty"`
+	CreationTime    int64    `json:"creationTime"`
+}
+
+// SearchIndex returns the search index of the table, or nil if the table has no search index.
+func (t *Table) SearchIndex() (*SearchIndex, error) {
+	v, exists := t.metadata[searchIndexKey]
+	if !exists || v == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode search index: %w", err)
+	}
+	var index SearchIndex
+	if err := json.Unmarshal(encoded, &index); err != nil {
+		return nil, fmt.Errorf("failed to decode search index: %w", err)
+	}
+	return &index, nil
+}
+
+// SetSearchIndex stores the search index to the table metadata. The search index is removed if index is nil.
+func (t *Table) SetSearchIndex(ctx context.Context, tx *sql.Tx, index *SearchIndex) error {
+	if index == nil {
+		delete(t.metadata, searchIndexKey)
+	} else {
+		if t.metadata == nil {
+			t.metadata = map[string]interface{}{}
+		}
+		t.metadata[searchIndexKey] = index
+	}
+	return t.repo.UpdateTable(ctx, tx, t)
+}
+
+// rowAccessPoliciesKey is the key of the table metadata that stores the row access policies.
+// It is not the field of bigqueryv2.Table, so it is not included in Content.
+const rowAccessPoliciesKey = "rowAccessPolicies"
+
+// RowAccessPolicy is the row access policy created by CREATE ROW ACCESS POLICY.
+type RowAccessPolicy struct {
+	Name            string `json:"name"`
+	FilterPredicate string `json:"filterPredicate"`
+	// Grantees are the members like `user:alice@example.com` and `allAuthenticatedUsers`.
+	Grantees         []string `json:"grantees,omitempty"`
+	CreationTime     int64    `json:"creationTime"`
+	LastModifiedTime int64    `json:"lastModifiedTime"`
+}
+
+// RowAccessPolicies returns the row access policies of the table in the order of creation.
+func (t *Table) RowAccessPolicies() ([]*RowAccessPolicy, error) {
+	v, exists := t.metadata[rowAccessPoliciesKey]
+	if !exists || v == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode row access policies: %w", err)
+	}
+	var policies []*RowAccessPolicy
+	if err := json.Unmarshal(encoded, &policies); err != nil {
+		return nil, fmt.Errorf("failed to decode row access policies: %w", err)
+	}
+	return policies, nil
+}
+
+// SetRowAccessPolicies stores the row access policies to the table metadata. They are removed if policies is empty.
+func (t *Table) SetRowAccessPolicies(ctx context.Context, tx *sql.Tx, policies []*RowAccessPolicy) error {
+	if len(policies) == 0 {
+		delete(t.metadata, rowAccessPoliciesKey)
+	} else {
+		if t.metadata == nil {
+			t.metadata = map[string]interface{}{}
+		}
+		t.metadata[rowAccessPoliciesKey] = policies
+	}
+	return t.repo.UpdateTable(ctx, tx, t)
+}
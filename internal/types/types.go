@@ -2,6 +2,7 @@ package types
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/apache/arrow/go/v10/arrow/array"
@@ -16,18 +17,25 @@ type (
 		Schema       *bigqueryv2.TableSchema  `json:"schema"`
 		Rows         []*TableRow              `json:"rows"`
 		TotalRows    uint64                   `json:"totalRows,string"`
+		PageToken    string                   `json:"pageToken,omitempty"`
 		JobComplete  bool                     `json:"jobComplete"`
 		TotalBytes   uint64                   `json:"-"`
 	}
 
 	QueryResponse struct {
-		JobReference   *bigqueryv2.JobReference   `json:"jobReference"`
+		JobReference   *bigqueryv2.JobReference   `json:"jobReference,omitempty"`
+		QueryId        string                     `json:"queryId,omitempty"`
 		Schema         *bigqueryv2.TableSchema    `json:"schema"`
 		Rows           []*TableRow                `json:"rows"`
 		TotalRows      uint64                     `json:"totalRows,string"`
+		PageToken      string                     `json:"pageToken,omitempty"`
 		JobComplete    bool                       `json:"jobComplete"`
 		TotalBytes     int64                      `json:"-"`
 		ChangedCatalog *zetasqlite.ChangedCatalog `json:"-"`
+
+		// NumDmlAffectedRows and DmlStats are reported for the DML statement.
+		NumDmlAffectedRows int64                     `json:"numDmlAffectedRows,omitempty,string"`
+		DmlStats           *bigqueryv2.DmlStatistics `json:"dmlStats,omitempty"`
 	}
 
 	TableDataList struct {
@@ -184,27 +192,107 @@ func (c *TableCell) AppendValueToARROWBuilder(builder array.Builder) error {
 	}
 }
 
+// Format formats the temporal values of rows as the response of the API, including the values in RECORD and REPEATED fields.
+// TIMESTAMP is the microseconds since the epoch if useInt64Timestamp is true, otherwise the seconds in the decimal.
+// DATE, TIME and DATETIME are `YYYY-MM-DD`, `HH:MM:SS[.ffffff]` and `YYYY-MM-DDTHH:MM:SS[.ffffff]`,
+// where the fraction has 6 digits and is omitted if it is zero.
 func Format(schema *bigqueryv2.TableSchema, rows []*TableRow, useInt64Timestamp bool) []*TableRow {
-	if !useInt64Timestamp {
-		return rows
-	}
 	formattedRows := make([]*TableRow, 0, len(rows))
 	for _, row := range rows {
-		cells := make([]*TableCell, 0, len(row.F))
-		for colIdx, cell := range row.F {
-			if schema.Fields[colIdx].Type == "TIMESTAMP" && cell.V != nil {
-				t, _ := zetasqlite.TimeFromTimestampValue(cell.V.(string))
-				microsec := t.UnixNano() / int64(time.Microsecond)
-				cells = append(cells, &TableCell{
-					V: fmt.Sprint(microsec),
-				})
-			} else {
-				cells = append(cells, cell)
-			}
-		}
 		formattedRows = append(formattedRows, &TableRow{
-			F: cells,
+			F: formatCells(schema.Fields, row.F, useInt64Timestamp),
 		})
 	}
 	return formattedRows
 }
+
+func formatCells(fields []*bigqueryv2.TableFieldSchema, cells []*TableCell, useInt64Timestamp bool) []*TableCell {
+	formattedCells := make([]*TableCell, 0, len(cells))
+	for colIdx, cell := range cells {
+		if colIdx >= len(fields) {
+			formattedCells = append(formattedCells, cell)
+			continue
+		}
+		formattedCells = append(formattedCells, formatCell(fields[colIdx], cell, useInt64Timestamp))
+	}
+	return formattedCells
+}
+
+func formatCell(field *bigqueryv2.TableFieldSchema, cell *TableCell, useInt64Timestamp bool) *TableCell {
+	if cell == nil || cell.V == nil {
+		return cell
+	}
+	if elems, ok := cell.V.([]*TableCell); ok && types.Mode(field.Mode) == types.RepeatedMode {
+		elemField := &bigqueryv2.TableFieldSchema{Name: field.Name, Type: field.Type, Fields: field.Fields}
+		formattedElems := make([]*TableCell, 0, len(elems))
+		for _, elem := range elems {
+			formattedElems = append(formattedElems, formatCell(elemField, elem, useInt64Timestamp))
+		}
+		return &TableCell{V: formattedElems, Bytes: cell.Bytes, Name: cell.Name}
+	}
+	var formatted string
+	switch v := cell.V.(type) {
+	case TableRow:
+		return &TableCell{V: TableRow{F: formatCells(field.Fields, v.F, useInt64Timestamp)}, Bytes: cell.Bytes, Name: cell.Name}
+	case string:
+		formatted = formatTemporalValue(types.Type(field.Type).FieldType(), v, useInt64Timestamp)
+	default:
+		return cell
+	}
+	return &TableCell{V: formatted, Bytes: cell.Bytes, Name: cell.Name}
+}
+
+// formatTemporalValue formats the value of the temporal type, or returns v as is for the other types.
+// go-zetasqlite returns TIMESTAMP as the seconds in the decimal, but as RFC 3339 in STRUCT,
+// and the fraction of TIME and DATETIME without the trailing zeros.
+func formatTemporalValue(typ types.FieldType, v string, useInt64Timestamp bool) string {
+	switch typ {
+	case types.FieldTimestamp:
+		t, err := zetasqlite.TimeFromTimestampValue(v)
+		if err != nil {
+			if t, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				return v
+			}
+		}
+		return formatTimestamp(t, useInt64Timestamp)
+	case types.FieldDatetime:
+		t, err := time.Parse("2006-01-02T15:04:05.999999999", strings.Replace(v, " ", "T", 1))
+		if err != nil {
+			return v
+		}
+		return t.Format("2006-01-02T15:04:05") + formatFraction(t)
+	case types.FieldTime:
+		t, err := time.Parse("15:04:05.999999999", v)
+		if err != nil {
+			return v
+		}
+		return t.Format("15:04:05") + formatFraction(t)
+	}
+	return v
+}
+
+// formatFraction returns the fraction of the seconds of t in 6 digits like `.500000`, or empty string if it is zero.
+func formatFraction(t time.Time) string {
+	micros := t.Nanosecond() / int(time.Microsecond)
+	if micros == 0 {
+		return ""
+	}
+	return fmt.Sprintf(".%06d", micros)
+}
+
+// formatTimestamp formats t in microseconds precision.
+// go-zetasqlite returns the fraction of the value without the leading zeros ( e.g. 1.5 for 1.000005 seconds ),
+// and the value before 1970 with the negative fraction, so the seconds are formatted from the microseconds.
+// UnixMicro is used instead of UnixNano because the nanoseconds overflow before 1678 and after 2262.
+func formatTimestamp(t time.Time, useInt64Timestamp bool) string {
+	micros := t.UnixMicro()
+	if useInt64Timestamp {
+		return fmt.Sprint(micros)
+	}
+	var sign string
+	if micros < 0 {
+		sign = "-"
+		micros = -micros
+	}
+	return fmt.Sprintf("%s%d.%06d", sign, micros/int64(time.Second/time.Microsecond), micros%int64(time.Second/time.Microsecond))
+}
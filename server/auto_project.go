@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+	"github.com/goccy/bigquery-emulator/types"
+)
+
+// SetAutoCreateProject sets whether the server creates the project on the first request referencing the unknown project,
+// instead of returning the notFound error. It makes the server usable without loading the projects in advance.
+// The project is not created in the read-only mode.
+// The project loaded by Load after it is created is merged into it in the same way as the project restored from the database file.
+func (s *Server) SetAutoCreateProject(enabled bool) {
+	s.autoCreateProject = enabled
+}
+
+// SetAutoCreateDataset sets the dataset created in the project that SetAutoCreateProject creates.
+// No dataset is created if id is empty.
+func (s *Server) SetAutoCreateDataset(id string) {
+	s.autoCreateDataset = id
+}
+
+// createProjectOnRequest creates the project of id and the dataset of SetAutoCreateDataset in the default location.
+// It returns nil if the server doesn't create the project on the request.
+func (s *Server) createProjectOnRequest(ctx context.Context, id string) (*metadata.Project, error) {
+	if !s.autoCreateProject || s.readOnly {
+		return nil, nil
+	}
+	project := types.NewProject(id)
+	if s.autoCreateDataset != "" {
+		project.Datasets = append(project.Datasets, types.NewDataset(s.autoCreateDataset))
+	}
+	if err := s.addProject(ctx, project); err != nil {
+		return nil, fmt.Errorf("failed to create project %s: %w", id, err)
+	}
+	s.logger.Info(fmt.Sprintf("created project %s on the first request", id))
+	return s.metaRepo.FindProject(ctx, id)
+}
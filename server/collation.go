@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+	"github.com/goccy/bigquery-emulator/types"
+)
+
+// applyCollations sets the collations of CREATE TABLE statement to the schema of the table.
+// DEFAULT COLLATE is the collation of the STRING columns without COLLATE.
+func applyCollations(table *bigqueryv2.Table, stmt *contentdata.DDLStatement) {
+	table.DefaultCollation = stmt.DefaultCollation
+	for _, field := range table.Schema.Fields {
+		for name, spec := range stmt.Collations {
+			if strings.EqualFold(name, field.Name) {
+				field.Collation = spec
+			}
+		}
+		if field.Collation == "" && field.Type == string(types.STRING) {
+			field.Collation = stmt.DefaultCollation
+		}
+	}
+}
+
+// applyColumnCollations applies 'und:ci' collation of the columns of the tables referenced by query.
+// The collation of the STRING column is its own collation or the default collation of the table.
+// The fields of RECORD columns are not collated.
+func (s *Server) applyColumnCollations(ctx context.Context, project *metadata.Project, datasetID, query string) (string, error) {
+	var columns []string
+	for _, path := range contentdata.TableNames(query) {
+		dataset, tableID, err := s.resolveTableName(ctx, project, datasetID, path)
+		if err != nil {
+			return "", err
+		}
+		if dataset == nil {
+			continue
+		}
+		table := dataset.Table(tableID)
+		if table == nil {
+			continue
+		}
+		content, err := table.Content()
+		if err != nil {
+			return "", err
+		}
+		if content.Schema == nil {
+			continue
+		}
+		for _, field := range content.Schema.Fields {
+			collation := field.Collation
+			if collation == "" && field.Type == string(types.STRING) {
+				collation = content.DefaultCollation
+			}
+			if strings.EqualFold(collation, "und:ci") {
+				columns = append(columns, field.Name)
+			}
+		}
+	}
+	return contentdata.ApplyColumnCollations(query, columns), nil
+}
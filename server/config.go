@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/goccy/go-yaml"
+
+	"github.com/goccy/bigquery-emulator/types"
+)
+
+// Config is the configuration file of the emulator.
+// It declares the default project, the data loaded on startup and the server settings in one file,
+// instead of the command line flags and the separate data file.
+// The relative paths in the file are resolved against the directory of the file.
+type Config struct {
+	// Project is the default project, and Dataset is the dataset created in it.
+	Project string `yaml:"project"`
+	Dataset string `yaml:"dataset"`
+	// Location is the location of the datasets created without location.
+	Location string `yaml:"location"`
+	// DataFromYAML are the YAML files of the initial data in the same format as YAMLSource.
+	DataFromYAML []string `yaml:"dataFromYAML"`
+	// DatasetsDir is the directory of the table files loaded as DirectorySource of the default project.
+	DatasetsDir string           `yaml:"datasetsDir"`
+	Projects    []*types.Project `yaml:"projects"`
+	Server      ServerConfig     `yaml:"server"`
+}
+
+// ServerConfig is the settings of the server process in the configuration file.
+// The zero value of each setting means that the file doesn't specify it.
+type ServerConfig struct {
+	Host                string    `yaml:"host"`
+	Port                uint16    `yaml:"port"`
+	GRPCPort            uint16    `yaml:"grpcPort"`
+	LogLevel            LogLevel  `yaml:"logLevel"`
+	LogFormat           LogFormat `yaml:"logFormat"`
+	Database            string    `yaml:"database"`
+	ShutdownGracePeriod Duration  `yaml:"shutdownGracePeriod"`
+	MaxResultRows       int64     `yaml:"maxResultRows" validate:"gte=0"`
+	MaxResultBytes      int64     `yaml:"maxResultBytes" validate:"gte=0"`
+	ReadOnly            bool      `yaml:"readOnly"`
+	RandomSeed          *int64    `yaml:"randomSeed"`
+	// AutoCreateProject creates the unknown project and Dataset in it on the first request referencing the project.
+	AutoCreateProject bool `yaml:"autoCreateProject"`
+
+	DatabaseJournalMode JournalMode     `yaml:"databaseJournalMode"`
+	DatabaseSynchronous SynchronousMode `yaml:"databaseSynchronous"`
+	DatabaseBusyTimeout Duration        `yaml:"databaseBusyTimeout"`
+	DatabaseReadOnly    bool            `yaml:"databaseReadOnly"`
+
+	DifferentialPrivacyPassthrough bool   `yaml:"differentialPrivacyPassthrough"`
+	GRPCReflection                 bool   `yaml:"grpcReflection"`
+	LogRedactQuery                 bool   `yaml:"logRedactQuery"`
+	GenerativeAIResponse           string `yaml:"generativeAIResponse"`
+	EnforceDatasetAccess           bool   `yaml:"enforceDatasetAccess"`
+
+	// InitSQL is the path to the SQL file or the inline SQL run after the data is loaded.
+	InitSQL string `yaml:"initSQL"`
+	// InitTimeout is nil if the file doesn't specify it, because 0 means no limit.
+	InitTimeout         *Duration `yaml:"initTimeout"`
+	InitContinueOnError bool      `yaml:"initContinueOnError"`
+}
+
+// Duration is time.Duration written as the string like 30s in the configuration file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(b []byte) error {
+	var s string
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads the configuration file of path.
+// The unknown keys and the invalid values are reported with the position in the file.
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	validate := validator.New()
+	types.RegisterTypeValidation(validate)
+	dec := yaml.NewDecoder(
+		bytes.NewBuffer(content),
+		yaml.Validator(validate),
+		yaml.Strict(),
+	)
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid config file %s:\n%s", path, yaml.FormatError(err, false, true))
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	cfg.resolvePaths(filepath.Dir(path))
+	return &cfg, nil
+}
+
+func (c *Config) validate() error {
+	if c.Location != "" {
+		if _, ok := normalizeLocation(c.Location); !ok {
+			return fmt.Errorf("invalid location: %s", c.Location)
+		}
+	}
+	if c.Dataset != "" && c.Project == "" && !c.Server.AutoCreateProject {
+		return fmt.Errorf("dataset %s requires project or autoCreateProject", c.Dataset)
+	}
+	if c.DatasetsDir != "" && c.Project == "" {
+		return fmt.Errorf("datasetsDir requires project")
+	}
+	switch c.Server.LogLevel {
+	case "", LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError, LogLevelFatal:
+	default:
+		return fmt.Errorf("invalid log level: %s", c.Server.LogLevel)
+	}
+	switch c.Server.LogFormat {
+	case "", LogFormatConsole, LogFormatJSON:
+	default:
+		return fmt.Errorf("invalid log format: %s", c.Server.LogFormat)
+	}
+	for _, project := range c.Projects {
+		for _, dataset := range project.Datasets {
+			if dataset.Location == "" {
+				continue
+			}
+			if _, ok := normalizeLocation(dataset.Location); !ok {
+				return fmt.Errorf("invalid location %s of dataset %s.%s", dataset.Location, project.ID, dataset.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// resolvePaths makes the relative paths of the configuration file relative to dir.
+func (c *Config) resolvePaths(dir string) {
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+	for i, path := range c.DataFromYAML {
+		c.DataFromYAML[i] = resolve(path)
+	}
+	c.DatasetsDir = resolve(c.DatasetsDir)
+	c.Server.Database = resolve(c.Server.Database)
+	// the inline SQL has the whitespace.
+	if !strings.ContainsAny(c.Server.InitSQL, " \t\r\n") {
+		c.Server.InitSQL = resolve(c.Server.InitSQL)
+	}
+}
+
+// ConfigSource loads the data declared in the configuration file: the default project and dataset,
+// the projects, the YAML files and the datasets directory, and sets the default location.
+// The settings of Server are applied by the caller because they are the options of the process like the listening ports.
+func ConfigSource(cfg *Config) Source {
+	return func(s *Server) error {
+		if cfg.Location != "" {
+			if err := s.SetDefaultLocation(cfg.Location); err != nil {
+				return err
+			}
+		}
+		if cfg.Project != "" {
+			if err := s.SetProject(cfg.Project); err != nil {
+				return err
+			}
+			project := types.NewProject(cfg.Project)
+			if cfg.Dataset != "" {
+				project.Datasets = append(project.Datasets, types.NewDataset(cfg.Dataset))
+			}
+			if err := s.addProjects(context.Background(), []*types.Project{project}); err != nil {
+				return err
+			}
+		}
+		if err := s.addProjects(context.Background(), cfg.Projects); err != nil {
+			return err
+		}
+		for _, path := range cfg.DataFromYAML {
+			if err := YAMLSource(path)(s); err != nil {
+				return fmt.Errorf("failed to load %s: %w", path, err)
+			}
+		}
+		if cfg.DatasetsDir != "" {
+			if err := DirectorySource(cfg.Project, cfg.DatasetsDir)(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
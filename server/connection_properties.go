@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+)
+
+// connectionProperties are the connectionProperties of jobs.query and the query job.
+type connectionProperties struct {
+	// timeZone is the default time zone of CURRENT_DATE, CURRENT_DATETIME and CURRENT_TIME, and the initial @@time_zone.
+	timeZone string
+	// datasetProjectID is the project of the datasets referenced without the project.
+	datasetProjectID string
+	// queryLabel is the initial @@query_label.
+	queryLabel string
+	// serviceAccount and sessionID are accepted and kept in the job configuration,
+	// but the emulator doesn't impersonate the service account or have the sessions.
+	serviceAccount string
+	sessionID      string
+}
+
+// parseConnectionProperties returns the connection properties, or the invalid error for the unknown key,
+// the duplicated key or the invalid time zone.
+func parseConnectionProperties(props []*bigqueryv2.ConnectionProperty) (*connectionProperties, error) {
+	ret := &connectionProperties{}
+	seen := map[string]struct{}{}
+	for _, prop := range props {
+		if prop == nil {
+			continue
+		}
+		if _, exists := seen[prop.Key]; exists {
+			return nil, errInvalid(fmt.Sprintf("Duplicate connection property: %s", prop.Key))
+		}
+		seen[prop.Key] = struct{}{}
+		switch prop.Key {
+		case "time_zone":
+			if _, err := time.LoadLocation(prop.Value); err != nil || prop.Value == "" {
+				return nil, errInvalid(fmt.Sprintf("Invalid time zone: %s", prop.Value))
+			}
+			ret.timeZone = prop.Value
+		case "dataset_project_id":
+			ret.datasetProjectID = prop.Value
+		case "query_label":
+			if _, err := parseQueryLabel(prop.Value); err != nil {
+				return nil, errInvalid(err.Error())
+			}
+			ret.queryLabel = prop.Value
+		case "service_account":
+			ret.serviceAccount = prop.Value
+		case "session_id":
+			ret.sessionID = prop.Value
+		default:
+			return nil, errInvalid(fmt.Sprintf("Invalid connection property: %s", prop.Key))
+		}
+	}
+	return ret, nil
+}
+
+// datasetProject returns the project of the datasets referenced without the project by the query of project.
+// It is the project of dataset_project_id unless the default dataset specifies the project.
+func (s *Server) datasetProject(ctx context.Context, project *metadata.Project, props *connectionProperties, defaultDataset *bigqueryv2.DatasetReference) (*metadata.Project, error) {
+	if props.datasetProjectID == "" || props.datasetProjectID == project.ID {
+		return project, nil
+	}
+	if defaultDataset != nil && defaultDataset.ProjectId != "" {
+		return project, nil
+	}
+	datasetProject, err := s.metaRepo.FindProject(ctx, props.datasetProjectID)
+	if err != nil {
+		return nil, err
+	}
+	if datasetProject == nil {
+		return nil, errNotFound(fmt.Sprintf("Not found: Project %s", props.datasetProjectID))
+	}
+	return datasetProject, nil
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gorilla/mux"
+	bigqueryconnection "google.golang.org/api/bigqueryconnection/v1"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+)
+
+// The endpoints of the connection resources of the BigQuery Connection API.
+const (
+	connectionsAPIEndpoint = "/v1/projects/{projectsId}/locations/{locationsId}/connections"
+	connectionAPIEndpoint  = "/v1/projects/{projectsId}/locations/{locationsId}/connections/{connectionsId}"
+)
+
+func registerConnectionHandlers(r *mux.Router) {
+	r.Handle(connectionsAPIEndpoint, &connectionsCreateHandler{}).Methods("POST")
+	r.Handle(connectionsAPIEndpoint, &connectionsListHandler{}).Methods("GET")
+	r.Handle(connectionAPIEndpoint, &connectionsGetHandler{}).Methods("GET")
+	r.Handle(connectionAPIEndpoint, &connectionsPatchHandler{}).Methods("PATCH")
+	r.Handle(connectionAPIEndpoint, &connectionsDeleteHandler{}).Methods("DELETE")
+}
+
+type connectionsCreateHandler struct{}
+type connectionsListHandler struct{}
+type connectionsGetHandler struct{}
+type connectionsPatchHandler struct{}
+type connectionsDeleteHandler struct{}
+
+// connectionRequest is the request of the connection resource. connectionID is empty for the collection.
+type connectionRequest struct {
+	server       *Server
+	project      *metadata.Project
+	location     string
+	connectionID string
+}
+
+func newConnectionRequest(r *http.Request) *connectionRequest {
+	ctx := r.Context()
+	params := mux.Vars(r)
+	return &connectionRequest{
+		server:       serverFromContext(ctx),
+		project:      projectFromContext(ctx),
+		location:     strings.ToLower(params["locationsId"]),
+		connectionID: params["connectionsId"],
+	}
+}
+
+func (r *connectionRequest) name() string {
+	return fmt.Sprintf("%s.%s.%s", r.project.ID, r.location, r.connectionID)
+}
+
+// withTx runs f in the transaction of the project and commits it if f succeeds.
+func (r *connectionRequest) withTx(ctx context.Context, f func(tx *connection.Tx) error) error {
+	conn, err := r.server.connMgr.Connection(ctx, r.project.ID, "")
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackIfNotCommitted()
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// find returns the connection resource of the request, or the notFound error.
+func (r *connectionRequest) find(ctx context.Context, tx *connection.Tx) (*metadata.Connection, error) {
+	conn, err := r.server.metaRepo.FindConnection(ctx, tx.Tx(), r.project.ID, r.location, r.connectionID)
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		return nil, errNotFound(fmt.Sprintf("Not found: Connection %s", r.name()))
+	}
+	return conn, nil
+}
+
+func connectionErrorResponse(ctx context.Context, w http.ResponseWriter, err error) {
+	serverErr := errInternalError(err.Error())
+	errors.As(err, &serverErr)
+	errorResponse(ctx, w, serverErr)
+}
+
+// connectionContent returns the resource returned to the client. The credential isn't returned like BigQuery.
+func connectionContent(conn *metadata.Connection) (*bigqueryconnection.Connection, error) {
+	content, err := conn.Content()
+	if err != nil {
+		return nil, err
+	}
+	if content.CloudSql != nil {
+		content.CloudSql.Credential = nil
+	}
+	return content, nil
+}
+
+func (h *connectionsCreateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := newConnectionRequest(r)
+	var content bigqueryconnection.Connection
+	if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+		errorResponse(ctx, w, errInvalid(err.Error()))
+		return
+	}
+	req.connectionID = r.URL.Query().Get("connectionId")
+	if req.connectionID == "" {
+		req.connectionID = randomID()
+	}
+	var res *bigqueryconnection.Connection
+	if err := req.withTx(ctx, func(tx *connection.Tx) error {
+		found, err := req.server.metaRepo.FindConnection(ctx, tx.Tx(), req.project.ID, req.location, req.connectionID)
+		if err != nil {
+			return err
+		}
+		if found != nil {
+			return errDuplicate(fmt.Sprintf("Already Exists: Connection %s", req.name()))
+		}
+		now := time.Now().UnixMilli()
+		content.CreationTime = now
+		content.LastModifiedTime = now
+		content.HasCredential = content.CloudSql != nil && content.CloudSql.Credential != nil
+		conn, err := metadata.NewConnectionWithContent(req.server.metaRepo, req.project.ID, req.location, req.connectionID, &content)
+		if err != nil {
+			return err
+		}
+		if err := conn.Insert(ctx, tx.Tx()); err != nil {
+			return err
+		}
+		res, err = connectionContent(conn)
+		return err
+	}); err != nil {
+		connectionErrorResponse(ctx, w, err)
+		return
+	}
+	encodeResponse(ctx, w, res)
+}
+
+// ServeHTTP lists the connections of the location. pageToken is the offset of the next page.
+func (h *connectionsListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := newConnectionRequest(r)
+	query := r.URL.Query()
+	var offset, pageSize int
+	if token := query.Get("pageToken"); token != "" {
+		v, err := strconv.Atoi(token)
+		if err != nil || v < 0 {
+			errorResponse(ctx, w, errInvalid(fmt.Sprintf("Invalid page token %q", token)))
+			return
+		}
+		offset = v
+	}
+	if size := query.Get("pageSize"); size != "" {
+		v, err := strconv.Atoi(size)
+		if err != nil || v < 0 {
+			errorResponse(ctx, w, errInvalid(fmt.Sprintf("Invalid page size %q", size)))
+			return
+		}
+		pageSize = v
+	}
+	res := &bigqueryconnection.ListConnectionsResponse{Connections: []*bigqueryconnection.Connection{}}
+	if err := req.withTx(ctx, func(tx *connection.Tx) error {
+		conns, err := req.server.metaRepo.FindConnections(ctx, tx.Tx(), req.project.ID, req.location)
+		if err != nil {
+			return err
+		}
+		if offset > len(conns) {
+			offset = len(conns)
+		}
+		conns = conns[offset:]
+		if pageSize > 0 && len(conns) > pageSize {
+			conns = conns[:pageSize]
+			res.NextPageToken = strconv.Itoa(offset + pageSize)
+		}
+		for _, conn := range conns {
+			content, err := connectionContent(conn)
+			if err != nil {
+				return err
+			}
+			res.Connections = append(res.Connections, content)
+		}
+		return nil
+	}); err != nil {
+		connectionErrorResponse(ctx, w, err)
+		return
+	}
+	encodeResponse(ctx, w, res)
+}
+
+func (h *connectionsGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := newConnectionRequest(r)
+	var res *bigqueryconnection.Connection
+	if err := req.withTx(ctx, func(tx *connection.Tx) error {
+		conn, err := req.find(ctx, tx)
+		if err != nil {
+			return err
+		}
+		res, err = connectionContent(conn)
+		return err
+	}); err != nil {
+		connectionErrorResponse(ctx, w, err)
+		return
+	}
+	encodeResponse(ctx, w, res)
+}
+
+// ServeHTTP updates the fields of updateMask ( e.g. `friendlyName,description,cloudSql` ).
+// All the fields of the request are updated if updateMask is empty.
+func (h *connectionsPatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := newConnectionRequest(r)
+	var patch bigqueryconnection.Connection
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		errorResponse(ctx, w, errInvalid(err.Error()))
+		return
+	}
+	var res *bigqueryconnection.Connection
+	if err := req.withTx(ctx, func(tx *connection.Tx) error {
+		conn, err := req.find(ctx, tx)
+		if err != nil {
+			return err
+		}
+		content, err := conn.Content()
+		if err != nil {
+			return err
+		}
+		mask := r.URL.Query().Get("updateMask")
+		if mask == "" {
+			mask = "friendlyName,description,cloudSql"
+		}
+		for _, field := range strings.Split(mask, ",") {
+			switch strings.TrimSpace(field) {
+			case "friendlyName", "friendly_name":
+				content.FriendlyName = patch.FriendlyName
+			case "description":
+				content.Description = patch.Description
+			case "cloudSql", "cloud_sql":
+				content.CloudSql = patch.CloudSql
+			case "cloudSql.credential", "cloud_sql.credential":
+				if content.CloudSql == nil {
+					content.CloudSql = &bigqueryconnection.CloudSqlProperties{}
+				}
+				if patch.CloudSql != nil {
+					content.CloudSql.Credential = patch.CloudSql.Credential
+				}
+			default:
+				return errInvalid(fmt.Sprintf("Invalid update mask field: %s", field))
+			}
+		}
+		content.HasCredential = content.CloudSql != nil && content.CloudSql.Credential != nil
+		content.LastModifiedTime = time.Now().UnixMilli()
+		if err := conn.SetContent(ctx, tx.Tx(), content); err != nil {
+			return err
+		}
+		res, err = connectionContent(conn)
+		return err
+	}); err != nil {
+		connectionErrorResponse(ctx, w, err)
+		return
+	}
+	encodeResponse(ctx, w, res)
+}
+
+func (h *connectionsDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	req := newConnectionRequest(r)
+	if err := req.withTx(ctx, func(tx *connection.Tx) error {
+		conn, err := req.find(ctx, tx)
+		if err != nil {
+			return err
+		}
+		return conn.Delete(ctx, tx.Tx())
+	}); err != nil {
+		connectionErrorResponse(ctx, w, err)
+		return
+	}
+	encodeResponse(ctx, w, struct{}{})
+}
+
+// checkExternalQuery reports the error of EXTERNAL_QUERY in query. The emulator has no external data source,
+// so the query referring to the existing connection fails with the unsupported error,
+// and the one referring to the unknown connection fails with the notFound error.
+func (s *Server) checkExternalQuery(ctx context.Context, tx *connection.Tx, project *metadata.Project, query string) error {
+	externalQuery, err := contentdata.FindExternalQuery(query)
+	if err != nil {
+		return errInvalidQuery(err.Error())
+	}
+	if externalQuery == nil {
+		return nil
+	}
+	projectID := externalQuery.ProjectID
+	if projectID == "" {
+		projectID = project.ID
+	}
+	name := fmt.Sprintf("%s.%s.%s", projectID, externalQuery.Location, externalQuery.ConnectionID)
+	conn, err := s.metaRepo.FindConnection(ctx, tx.Tx(), projectID, externalQuery.Location, externalQuery.ConnectionID)
+	if err != nil {
+		return err
+	}
+	if conn == nil {
+		return errNotFound(fmt.Sprintf("Not found: Connection %s", name))
+	}
+	return errNotImplemented(fmt.Sprintf(
+		"Unsupported feature: EXTERNAL_QUERY to the connection %s is not supported by the emulator because it has no external data source",
+		name,
+	))
+}
@@ -3,17 +3,23 @@ package server
 import (
 	"context"
 
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
 	"github.com/goccy/bigquery-emulator/internal/metadata"
 )
 
 type (
-	serverKey  struct{}
-	projectKey struct{}
-	datasetKey struct{}
-	jobKey     struct{}
-	tableKey   struct{}
-	modelKey   struct{}
-	routineKey struct{}
+	serverKey               struct{}
+	projectKey              struct{}
+	datasetKey              struct{}
+	jobKey                  struct{}
+	tableKey                struct{}
+	modelKey                struct{}
+	routineKey              struct{}
+	queryJobKey             struct{}
+	resultLimitKey          struct{}
+	userKey                 struct{}
+	connectionPropertiesKey struct{}
+	catalogDefinitionsKey   struct{}
 )
 
 func withServer(ctx context.Context, server *Server) context.Context {
@@ -71,3 +77,59 @@ func withRoutine(ctx context.Context, routine *metadata.Routine) context.Context
 func routineFromContext(ctx context.Context) *metadata.Routine {
 	return ctx.Value(routineKey{}).(*metadata.Routine)
 }
+
+// withQueryJobID sets the id of the query job that the script system variables like @@script.job_id refer to.
+func withQueryJobID(ctx context.Context, jobID string) context.Context {
+	return context.WithValue(ctx, queryJobKey{}, jobID)
+}
+
+func queryJobIDFromContext(ctx context.Context) string {
+	jobID, _ := ctx.Value(queryJobKey{}).(string)
+	return jobID
+}
+
+// withResultLimit sets the limit of the result returned by the query.
+// The query whose result is written to the destination table runs without the limit.
+func withResultLimit(ctx context.Context, limit contentdata.ResultLimit) context.Context {
+	return context.WithValue(ctx, resultLimitKey{}, limit)
+}
+
+func resultLimitFromContext(ctx context.Context) contentdata.ResultLimit {
+	limit, _ := ctx.Value(resultLimitKey{}).(contentdata.ResultLimit)
+	return limit
+}
+
+// withUser sets the email of the user calling the API given by UserHeader.
+func withUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func userFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// withConnectionProperties sets the connection properties of the query.
+func withConnectionProperties(ctx context.Context, props *connectionProperties) context.Context {
+	return context.WithValue(ctx, connectionPropertiesKey{}, props)
+}
+
+// connectionPropertiesFromContext returns the connection properties of the query, or the empty properties.
+func connectionPropertiesFromContext(ctx context.Context) *connectionProperties {
+	props, _ := ctx.Value(connectionPropertiesKey{}).(*connectionProperties)
+	if props == nil {
+		return &connectionProperties{}
+	}
+	return props
+}
+
+// withCatalogDefinitions sets the new definitions of the objects created by the DDL statements of the request.
+func withCatalogDefinitions(ctx context.Context) context.Context {
+	return context.WithValue(ctx, catalogDefinitionsKey{}, newCatalogDefinitions())
+}
+
+// catalogDefinitionsFromContext returns the definitions of the request, or nil outside of the request.
+func catalogDefinitionsFromContext(ctx context.Context) *catalogDefinitions {
+	defs, _ := ctx.Value(catalogDefinitionsKey{}).(*catalogDefinitions)
+	return defs
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-zetasqlite"
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	internaltypes "github.com/goccy/bigquery-emulator/internal/types"
+)
+
+// materializeWithClause evaluates the common table expressions into the tables of contentdata.HiddenDatasetID,
+// and replaces their subqueries with the queries reading the tables.
+// The expressions of the query statement are evaluated if they call the non-deterministic function and are referenced
+// more than once, so every reference sees the same rows as BigQuery evaluates the expression once per query.
+// All the expressions of the DML statement are evaluated before the statement changes the tables,
+// so they see the tables as of the start of the statement however many times they are referenced,
+// for example from both USING and SET of MERGE.
+// The positional parameters in the evaluated expressions are bound to the queries of the expressions,
+// and the rest of them are returned for the statement.
+// The expressions are evaluated in the same transaction, so the rows are consistent with the tables read by the statement.
+// It returns the query and its parameters, and drop removing the tables after the statement runs.
+func (s *Server) materializeWithClause(ctx context.Context, tx *connection.Tx, projectID, datasetID, query string, params []*bigqueryv2.QueryParameter) (string, []*bigqueryv2.QueryParameter, func(), error) {
+	noop := func() {}
+	with := contentdata.ParseWithClause(query)
+	if with == nil {
+		return query, params, noop, nil
+	}
+	isDML := with.IsDML()
+	materialize := make([]bool, len(with.Entries))
+	var materialized bool
+	for idx, entry := range with.Entries {
+		if isDML {
+			materialize[idx] = entry.Uses != 0
+		} else {
+			materialize[idx] = entry.NonDeterministic && entry.Uses >= 2
+		}
+		materialized = materialized || materialize[idx]
+	}
+	if !materialized && !isDML {
+		return query, params, noop, nil
+	}
+	var named, positional []*bigqueryv2.QueryParameter
+	for _, param := range params {
+		if param.Name == "" {
+			positional = append(positional, param)
+		} else {
+			named = append(named, param)
+		}
+	}
+	// entryParams are the positional parameters of each entry in order.
+	entryParams := make([][]*bigqueryv2.QueryParameter, len(with.Entries))
+	for idx, entry := range with.Entries {
+		n := contentdata.PositionalParameters(entry.Query)
+		if n > len(positional) {
+			return "", nil, nil, errInvalidQuery("the number of the positional parameters doesn't match the query")
+		}
+		entryParams[idx], positional = positional[:n], positional[n:]
+	}
+
+	var tableIDs []string
+	drop := func() {
+		if len(tableIDs) != 0 {
+			_ = s.contentRepo.DeleteTables(ctx, tx, projectID, contentdata.HiddenDatasetID, tableIDs)
+		}
+	}
+	for idx, entry := range with.Entries {
+		if !materialize[idx] {
+			continue
+		}
+		queryParams := append([]*bigqueryv2.QueryParameter{}, named...)
+		for j := 0; j <= idx; j++ {
+			if j == idx || !materialize[j] {
+				queryParams = append(queryParams, entryParams[j]...)
+			}
+		}
+		tableID := contentdata.HiddenTableID(materializedTablePrefix)
+		table := fmt.Sprintf("%s.%s.%s", projectID, contentdata.HiddenDatasetID, tableID)
+		if _, err := s.contentRepo.Query(
+			ctx, tx, projectID, datasetID,
+			fmt.Sprintf("CREATE TABLE `%s` AS %s", table, with.EntryQuery(idx)),
+			queryParams,
+		); err != nil {
+			drop()
+			return "", nil, nil, fmt.Errorf("failed to materialize %s: %w", entry.Name, err)
+		}
+		tableIDs = append(tableIDs, tableID)
+		entry.Query = fmt.Sprintf("SELECT * FROM `%s`", table)
+	}
+	if isDML {
+		// the entries not referenced by the statement are dropped with the WITH clause.
+		return with.DMLStatement(), append(named, positional...), drop, nil
+	}
+	rest := named
+	for idx := range with.Entries {
+		if !materialize[idx] {
+			rest = append(rest, entryParams[idx]...)
+		}
+	}
+	return with.String(), append(rest, positional...), drop, nil
+}
+
+// materializedTablePrefix is the prefix of the tables of contentdata.HiddenDatasetID
+// that have the rows of the common table expressions evaluated by materializeWithClause.
+const materializedTablePrefix = "bqemulator_with_"
+
+// fieldType returns the GoogleSQL type of the field in the query result.
+func fieldType(field *bigqueryv2.TableFieldSchema) string {
+	var typ string
+	switch field.Type {
+	case "INTEGER":
+		typ = "INT64"
+	case "FLOAT":
+		typ = "FLOAT64"
+	case "BOOLEAN":
+		typ = "BOOL"
+	case "RECORD", "STRUCT":
+		fields := make([]string, 0, len(field.Fields))
+		for _, f := range field.Fields {
+			fields = append(fields, fmt.Sprintf("`%s` %s", f.Name, fieldType(f)))
+		}
+		typ = fmt.Sprintf("STRUCT<%s>", strings.Join(fields, ", "))
+	default:
+		typ = field.Type
+	}
+	if field.Mode == "REPEATED" {
+		return fmt.Sprintf("ARRAY<%s>", typ)
+	}
+	return typ
+}
+
+// valueLiteral converts the value of the query result into the SQL expression of the value.
+func valueLiteral(field *bigqueryv2.TableFieldSchema, cell *internaltypes.TableCell) (string, error) {
+	typ := fieldType(field)
+	if cell.V == nil {
+		return fmt.Sprintf("CAST(NULL AS %s)", typ), nil
+	}
+	if field.Mode == "REPEATED" {
+		cells, ok := cell.V.([]*internaltypes.TableCell)
+		if !ok {
+			return "", fmt.Errorf("unexpected value %v of %s", cell.V, typ)
+		}
+		elem := &bigqueryv2.TableFieldSchema{Name: field.Name, Type: field.Type, Fields: field.Fields}
+		elems := make([]string, 0, len(cells))
+		for _, c := range cells {
+			v, err := valueLiteral(elem, c)
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, v)
+		}
+		return fmt.Sprintf("%s[%s]", typ, strings.Join(elems, ", ")), nil
+	}
+	if field.Type == "RECORD" || field.Type == "STRUCT" {
+		row, ok := cell.V.(internaltypes.TableRow)
+		if !ok || len(row.F) != len(field.Fields) {
+			return "", fmt.Errorf("unexpected value %v of %s", cell.V, typ)
+		}
+		values := make([]string, 0, len(row.F))
+		for idx, c := range row.F {
+			v, err := valueLiteral(field.Fields[idx], c)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, v)
+		}
+		return fmt.Sprintf("%s(%s)", typ, strings.Join(values, ", ")), nil
+	}
+	v, ok := cell.V.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected value %v of %s", cell.V, typ)
+	}
+	switch typ {
+	case "BYTES":
+		return fmt.Sprintf("FROM_BASE64(%s)", contentdata.QuoteStringLiteral(v)), nil
+	case "JSON":
+		return fmt.Sprintf("PARSE_JSON(%s)", contentdata.QuoteStringLiteral(v)), nil
+	case "GEOGRAPHY":
+		return fmt.Sprintf("ST_GEOGFROMTEXT(%s)", contentdata.QuoteStringLiteral(v)), nil
+	case "TIMESTAMP":
+		t, err := zetasqlite.TimeFromTimestampValue(v)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("TIMESTAMP_MICROS(%d)", t.UnixMicro()), nil
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", contentdata.QuoteStringLiteral(v), typ), nil
+}
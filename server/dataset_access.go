@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+	grpcmetadata "google.golang.org/grpc/metadata"
+
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+)
+
+// UserHeader is the header of the email of the user calling the API.
+// The emulator doesn't authenticate the requests, so the user evaluated by the dataset access is given by this header.
+const UserHeader = "X-Bigquery-Emulator-User"
+
+// maxAuthorizedViewDepth is the limit of the nested views and table functions checked by the dataset access.
+const maxAuthorizedViewDepth = 16
+
+// SetDatasetAccessEnforcement sets whether the queries are checked by the access entries of the datasets they read.
+// A table is readable if the dataset has no access entry, if the user of UserHeader is granted a role by the entry
+// ( userByEmail, domain, specialGroup allAuthenticatedUsers or iamMember ), or if the table is read through
+// the authorized view, the view in the authorized dataset or the authorized routine of the entry.
+// The roles are not distinguished and the groups of groupByEmail and the project special groups never match,
+// because the emulator doesn't have the IAM policies.
+func (s *Server) SetDatasetAccessEnforcement(enabled bool) {
+	s.datasetAccessEnforcement = enabled
+}
+
+func withUserMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if user := r.Header.Get(UserHeader); user != "" {
+				ctx = withUser(ctx, user)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// withGRPCUser sets the user of UserHeader in the metadata of the gRPC request to ctx.
+func withGRPCUser(ctx context.Context) context.Context {
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if users := md.Get(UserHeader); len(users) != 0 && users[0] != "" {
+		return withUser(ctx, users[0])
+	}
+	return ctx
+}
+
+// accessPath is the view or the routine that the query reading the table is defined by.
+// Both are nil for the tables that the query of the request reads directly.
+type accessPath struct {
+	view    *bigqueryv2.TableReference
+	routine *bigqueryv2.RoutineReference
+}
+
+// checkDatasetAccess returns the accessDenied error if the user of ctx can't read a table referenced by query.
+// The unqualified names are resolved with the default dataset of the query.
+// The tables read by the views and the table functions are checked with the access of the view or the routine.
+func (s *Server) checkDatasetAccess(ctx context.Context, project *metadata.Project, datasetID, query string) error {
+	if !s.datasetAccessEnforcement {
+		return nil
+	}
+	return s.checkQueryAccess(ctx, project, datasetID, query, &accessPath{}, 0)
+}
+
+// checkTableDataAccess returns the accessDenied error if the user of ctx can't read the rows of the table
+// directly by tabledata.list or the Storage Read API.
+func (s *Server) checkTableDataAccess(ctx context.Context, dataset *metadata.Dataset, tableID string) error {
+	if !s.datasetAccessEnforcement || canReadDataset(ctx, dataset, &accessPath{}) {
+		return nil
+	}
+	return errAccessDenied(fmt.Sprintf(
+		"Access Denied: Table %[1]s:%[2]s.%[3]s: Permission bigquery.tables.getData denied on table %[1]s:%[2]s.%[3]s (or it may not exist).",
+		dataset.ProjectID, dataset.ID, tableID,
+	))
+}
+
+func (s *Server) checkQueryAccess(ctx context.Context, project *metadata.Project, datasetID, query string, path *accessPath, depth int) error {
+	if depth > maxAuthorizedViewDepth {
+		return errInvalidQuery(fmt.Sprintf(
+			"Access Denied: the views and the table functions are nested more than %d levels, so the emulator can't check the access of the tables they read",
+			maxAuthorizedViewDepth,
+		))
+	}
+	for _, namePath := range contentdata.TableNames(query) {
+		dataset, id, err := s.resolveTableName(ctx, project, datasetID, namePath)
+		if err != nil {
+			return err
+		}
+		if dataset == nil {
+			continue
+		}
+		if table := dataset.Table(id); table != nil {
+			if !canReadDataset(ctx, dataset, path) {
+				return errAccessDenied(fmt.Sprintf(
+					"Access Denied: Table %[1]s:%[2]s.%[3]s: User does not have permission to query table %[1]s:%[2]s.%[3]s, or perhaps it does not exist.",
+					dataset.ProjectID, dataset.ID, id,
+				))
+			}
+			if err := s.checkViewAccess(ctx, dataset, table, depth); err != nil {
+				return err
+			}
+			continue
+		}
+		if routine := dataset.Routine(id); routine != nil {
+			if !canReadDataset(ctx, dataset, path) {
+				return errAccessDenied(fmt.Sprintf(
+					"Access Denied: Routine %[1]s:%[2]s.%[3]s: User does not have permission to invoke routine %[1]s.%[2]s.%[3]s.",
+					dataset.ProjectID, dataset.ID, id,
+				))
+			}
+			if err := s.checkRoutineAccess(ctx, dataset, routine, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveTableName returns the dataset and the id of the table or the routine of the name path.
+// The unqualified name is resolved with the default dataset, and the dataset is nil if it is not found.
+func (s *Server) resolveTableName(ctx context.Context, project *metadata.Project, datasetID string, path []string) (*metadata.Dataset, string, error) {
+	if len(path) == 1 {
+		if datasetID == "" {
+			return nil, "", nil
+		}
+		return project.Dataset(datasetID), path[0], nil
+	}
+	dataset, err := s.referencedDataset(ctx, project, path)
+	if err != nil || dataset == nil {
+		return nil, "", err
+	}
+	id := path[1]
+	if len(path) >= 3 && path[0] == dataset.ProjectID && path[1] == dataset.ID {
+		id = path[2]
+	}
+	return dataset, id, nil
+}
+
+// checkViewAccess checks the tables read by the view as the tables read through the view.
+// The unqualified names of the view query are resolved with the dataset of the view.
+func (s *Server) checkViewAccess(ctx context.Context, dataset *metadata.Dataset, table *metadata.Table, depth int) error {
+	content, err := table.Content()
+	if err != nil {
+		return err
+	}
+	var viewQuery string
+	switch {
+	case content.View != nil:
+		viewQuery = content.View.Query
+	case content.MaterializedView != nil:
+		viewQuery = content.MaterializedView.Query
+	}
+	if viewQuery == "" {
+		return nil
+	}
+	project, err := s.metaRepo.FindProject(ctx, dataset.ProjectID)
+	if err != nil {
+		return err
+	}
+	return s.checkQueryAccess(ctx, project, dataset.ID, viewQuery, &accessPath{
+		view: &bigqueryv2.TableReference{ProjectId: dataset.ProjectID, DatasetId: dataset.ID, TableId: table.ID},
+	}, depth+1)
+}
+
+// checkRoutineAccess checks the tables read by the table function as the tables read through the routine.
+func (s *Server) checkRoutineAccess(ctx context.Context, dataset *metadata.Dataset, routine *metadata.Routine, depth int) error {
+	content, err := routine.Content()
+	if err != nil {
+		return err
+	}
+	if content.RoutineType != string(contentdata.TableValuedFunctionType) {
+		return nil
+	}
+	project, err := s.metaRepo.FindProject(ctx, dataset.ProjectID)
+	if err != nil {
+		return err
+	}
+	return s.checkQueryAccess(ctx, project, dataset.ID, content.DefinitionBody, &accessPath{
+		routine: &bigqueryv2.RoutineReference{ProjectId: dataset.ProjectID, DatasetId: dataset.ID, RoutineId: routine.ID},
+	}, depth+1)
+}
+
+// canReadDataset reports whether the access entries of dataset allow the user of ctx or path to read the tables.
+func canReadDataset(ctx context.Context, dataset *metadata.Dataset, path *accessPath) bool {
+	content := dataset.Content()
+	if content == nil || len(content.Access) == 0 {
+		return true
+	}
+	user := userFromContext(ctx)
+	for _, access := range content.Access {
+		if path.view != nil && isAuthorizedView(access, path.view) {
+			return true
+		}
+		if path.routine != nil && isAuthorizedRoutine(access, path.routine) {
+			return true
+		}
+		if user != "" && access.Role != "" && isGrantedUser(access, user) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAuthorizedView(access *bigqueryv2.DatasetAccess, view *bigqueryv2.TableReference) bool {
+	if ref := access.View; ref != nil {
+		return ref.ProjectId == view.ProjectId && ref.DatasetId == view.DatasetId && ref.TableId == view.TableId
+	}
+	return isAuthorizedDataset(access, view.ProjectId, view.DatasetId, "VIEWS")
+}
+
+func isAuthorizedRoutine(access *bigqueryv2.DatasetAccess, routine *bigqueryv2.RoutineReference) bool {
+	if ref := access.Routine; ref != nil {
+		return ref.ProjectId == routine.ProjectId && ref.DatasetId == routine.DatasetId && ref.RoutineId == routine.RoutineId
+	}
+	return isAuthorizedDataset(access, routine.ProjectId, routine.DatasetId, "ROUTINES")
+}
+
+// isAuthorizedDataset reports whether the entry authorizes the objects of targetType in the dataset.
+// The entry without targetTypes authorizes the views.
+func isAuthorizedDataset(access *bigqueryv2.DatasetAccess, projectID, datasetID, targetType string) bool {
+	entry := access.Dataset
+	if entry == nil || entry.Dataset == nil || entry.Dataset.ProjectId != projectID || entry.Dataset.DatasetId != datasetID {
+		return false
+	}
+	if len(entry.TargetTypes) == 0 {
+		return targetType == "VIEWS"
+	}
+	for _, typ := range entry.TargetTypes {
+		if strings.EqualFold(typ, targetType) {
+			return true
+		}
+	}
+	return false
+}
+
+func isGrantedUser(access *bigqueryv2.DatasetAccess, user string) bool {
+	switch {
+	case access.UserByEmail != "":
+		return strings.EqualFold(access.UserByEmail, user)
+	case access.Domain != "":
+		return strings.HasSuffix(strings.ToLower(user), "@"+strings.ToLower(access.Domain))
+	case access.SpecialGroup != "":
+		return access.SpecialGroup == "allAuthenticatedUsers"
+	case access.IamMember != "":
+		return access.IamMember == "allAuthenticatedUsers" || access.IamMember == "allUsers" ||
+			strings.EqualFold(access.IamMember, "user:"+user)
+	}
+	return false
+}
@@ -0,0 +1,562 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+)
+
+// execDDLStatement evaluates IF NOT EXISTS and IF EXISTS of stmt and checks the type of the existing object
+// before go-zetasqlite runs the statement.
+// It reports whether the statement is done by the emulator: the guarded statement that has nothing to do,
+// the statement of the materialized view, or CREATE TABLE LIKE or COPY.
+func (s *Server) execDDLStatement(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, stmt *contentdata.DDLStatement) (bool, error) {
+	if stmt.Temp {
+		// the temporary object lives only in the script and isn't in the catalog, so go-zetasqlite evaluates its guards.
+		return false, nil
+	}
+	defaultDatasetID := datasetID
+	path := stmt.Path
+	objectID := path[len(path)-1]
+	objectProject := project
+	if len(path) == 3 && path[0] != project.ID {
+		p, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), path[0])
+		if err != nil {
+			return false, err
+		}
+		if p == nil {
+			return false, fmt.Errorf("project %s is not found", path[0])
+		}
+		objectProject = p
+	}
+	if len(path) >= 2 {
+		datasetID = path[len(path)-2]
+	}
+	var dataset *metadata.Dataset
+	if datasetID != "" {
+		dataset = objectProject.Dataset(datasetID)
+	}
+	name := fmt.Sprintf("%s:%s.%s", objectProject.ID, datasetID, objectID)
+
+	var (
+		exists bool
+		table  *metadata.Table
+		// objectType is the type of the existing object. It is empty when the metadata doesn't have the object.
+		objectType string
+	)
+	switch stmt.ObjectType {
+	case "FUNCTION":
+		var err error
+		exists, err = s.functionExists(ctx, tx, project.ID, datasetID, path)
+		if err != nil {
+			return false, err
+		}
+	case "PROCEDURE":
+		// go-zetasqlite doesn't create the procedure, so only the routine of the metadata can exist.
+		if dataset != nil {
+			if routine := dataset.Routine(objectID); routine != nil {
+				content, err := routine.Content()
+				if err != nil {
+					return false, err
+				}
+				exists = content.RoutineType == string(contentdata.ProcedureType)
+			}
+		}
+	default:
+		if dataset != nil {
+			table = dataset.Table(objectID)
+		}
+		if table != nil {
+			content, err := table.Content()
+			if err != nil {
+				return false, err
+			}
+			exists = true
+			objectType = ddlObjectType(content)
+		} else if stmt.ObjectType != "MATERIALIZED VIEW" {
+			// the table created by the former statement of the script isn't in the metadata yet.
+			var err error
+			exists, err = s.tableExists(ctx, tx, project.ID, datasetID, path)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if stmt.Drop {
+		if !exists {
+			if stmt.IfExists {
+				return true, nil
+			}
+			if stmt.ObjectType == "MATERIALIZED VIEW" {
+				return false, errNotFound(fmt.Sprintf("Not found: Table %s", name))
+			}
+			return false, nil
+		}
+		if objectType != "" && objectType != stmt.ObjectType {
+			return false, fmt.Errorf("%s is a %s, not a %s", name, strings.ToLower(objectType), strings.ToLower(stmt.ObjectType))
+		}
+		if stmt.ObjectType == "MATERIALIZED VIEW" {
+			if err := s.dropView(ctx, tx, objectProject.ID, datasetID, objectID); err != nil {
+				return false, err
+			}
+			return true, table.Delete(ctx, tx.Tx())
+		}
+		return false, nil
+	}
+	if exists {
+		if objectType != "" && objectType != stmt.ObjectType {
+			return false, errDuplicate(fmt.Sprintf("Already Exists: %s is a %s, not a %s", name, strings.ToLower(objectType), strings.ToLower(stmt.ObjectType)))
+		}
+		if stmt.IfNotExists {
+			return true, nil
+		}
+	}
+	if stmt.Like != nil || stmt.Copy != nil {
+		if exists && !stmt.OrReplace {
+			return false, errDuplicate(fmt.Sprintf("Already Exists: Table %s", name))
+		}
+		if dataset == nil {
+			return false, errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", objectProject.ID, datasetID))
+		}
+		if exists && table == nil {
+			// the table created by the former statement of the script.
+			if err := s.contentRepo.DeleteTables(ctx, tx, objectProject.ID, datasetID, []string{objectID}); err != nil {
+				return false, err
+			}
+		}
+		return true, s.createTableFromSource(ctx, tx, project, defaultDatasetID, objectProject, dataset, table, objectID, stmt)
+	}
+	if stmt.ObjectType != "MATERIALIZED VIEW" {
+		return false, nil
+	}
+	if exists && !stmt.OrReplace {
+		return false, errDuplicate(fmt.Sprintf("Already Exists: Table %s", name))
+	}
+	if dataset == nil {
+		return false, errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", objectProject.ID, datasetID))
+	}
+	return true, s.createMaterializedView(ctx, tx, objectProject, dataset, table, objectID, stmt.Query)
+}
+
+// catalogDefinitions are the definitions of the tables and the views created by the DDL statements of a request
+// that go-zetasqlite doesn't keep, until syncCatalog adds the metadata of them.
+// They live in the context of the request, so the definitions of the failed request are discarded with it.
+type catalogDefinitions struct {
+	mu sync.Mutex
+	// viewQueries are the queries of the views created by CREATE VIEW keyed by `project.dataset.view`.
+	viewQueries map[string]string
+	// tableCollations are the CREATE TABLE statements with the collations keyed by `project.dataset.table`.
+	tableCollations map[string]*contentdata.DDLStatement
+}
+
+func newCatalogDefinitions() *catalogDefinitions {
+	return &catalogDefinitions{
+		viewQueries:     map[string]string{},
+		tableCollations: map[string]*contentdata.DDLStatement{},
+	}
+}
+
+// add keeps the definition of the object created by stmt that succeeded.
+// projectID and datasetID are the default project and dataset of the statement.
+func (d *catalogDefinitions) add(projectID, datasetID string, stmt *contentdata.DDLStatement) {
+	if d == nil || stmt.Temp || stmt.Drop {
+		return
+	}
+	path := stmt.Path
+	if len(path) == 3 {
+		projectID = path[0]
+	}
+	if len(path) >= 2 {
+		datasetID = path[len(path)-2]
+	}
+	name := fmt.Sprintf("%s.%s.%s", projectID, datasetID, path[len(path)-1])
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case stmt.ObjectType == "VIEW":
+		// go-zetasqlite keeps only the translated query of the view, so the metadata of the view
+		// created by the statement takes the query from here to check the dataset access of the tables it reads.
+		d.viewQueries[name] = stmt.Query
+	case stmt.ObjectType == "TABLE" && (len(stmt.Collations) != 0 || stmt.DefaultCollation != ""):
+		// go-zetasqlite doesn't keep the collations, so the metadata of the table takes them from here.
+		d.tableCollations[name] = stmt
+	}
+}
+
+// take returns the query of the view and the CREATE TABLE statement with the collations of the object of name,
+// and forgets them.
+func (d *catalogDefinitions) take(name string) (string, *contentdata.DDLStatement) {
+	if d == nil {
+		return "", nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	query, stmt := d.viewQueries[name], d.tableCollations[name]
+	delete(d.viewQueries, name)
+	delete(d.tableCollations, name)
+	return query, stmt
+}
+
+// ddlObjectType returns the object type of DDL for the table: TABLE, VIEW or MATERIALIZED VIEW.
+// The external tables and the snapshots are the tables.
+func ddlObjectType(content *bigqueryv2.Table) string {
+	switch content.Type {
+	case string(ViewTableType):
+		return "VIEW"
+	case string(MaterializedViewTableType):
+		return "MATERIALIZED VIEW"
+	}
+	return "TABLE"
+}
+
+// tableExists reports whether the catalog of go-zetasqlite has the table or the view of path.
+func (s *Server) tableExists(ctx context.Context, tx *connection.Tx, projectID, datasetID string, path []string) (bool, error) {
+	kind, err := s.contentRepo.CatalogObjectKind(ctx, tx, projectID, datasetID, path)
+	if err != nil {
+		return false, err
+	}
+	return kind == "table" || kind == "view", nil
+}
+
+// functionExists reports whether the catalog of go-zetasqlite has the function of path.
+func (s *Server) functionExists(ctx context.Context, tx *connection.Tx, projectID, datasetID string, path []string) (bool, error) {
+	kind, err := s.contentRepo.CatalogObjectKind(ctx, tx, projectID, datasetID, path)
+	if err != nil {
+		return false, err
+	}
+	return kind == "function", nil
+}
+
+func (s *Server) dropView(ctx context.Context, tx *connection.Tx, projectID, datasetID, tableID string) error {
+	query := fmt.Sprintf("DROP VIEW `%s.%s.%s`", projectID, datasetID, tableID)
+	if _, err := s.contentRepo.Query(ctx, tx, projectID, datasetID, query, nil); err != nil {
+		return fmt.Errorf("failed to drop view %s: %w", tableID, err)
+	}
+	return nil
+}
+
+// createMaterializedView creates the materialized view as the view that is always up to date, like tables.insert does.
+// The materialized view that already exists is replaced.
+func (s *Server) createMaterializedView(ctx context.Context, tx *connection.Tx, project *metadata.Project, dataset *metadata.Dataset, existing *metadata.Table, tableID, query string) error {
+	table := &bigqueryv2.Table{
+		TableReference: &bigqueryv2.TableReference{
+			ProjectId: project.ID,
+			DatasetId: dataset.ID,
+			TableId:   tableID,
+		},
+		MaterializedView: &bigqueryv2.MaterializedViewDefinition{Query: query},
+	}
+	if existing == nil {
+		if _, serverErr := createTableMetadata(ctx, tx, s, project, dataset, table); serverErr != nil {
+			return serverErr
+		}
+		return s.contentRepo.CreateView(ctx, tx, table)
+	}
+	if err := s.dropView(ctx, tx, project.ID, dataset.ID, tableID); err != nil {
+		return err
+	}
+	content, err := existing.Content()
+	if err != nil {
+		return err
+	}
+	content.MaterializedView = table.MaterializedView
+	content.Schema = nil
+	content.LastModifiedTime = uint64(time.Now().Unix())
+	if err := existing.UpdateContent(ctx, tx.Tx(), content); err != nil {
+		return err
+	}
+	return s.contentRepo.CreateView(ctx, tx, table)
+}
+
+// createTableFromSource creates the table of CREATE TABLE LIKE or CREATE TABLE COPY.
+// LIKE copies the schema ( with the column descriptions ), the partitioning, the clustering and the options of the source table,
+// and COPY copies its rows too. The options of the statement override the ones of the source table.
+// The existing table is replaced by OR REPLACE.
+func (s *Server) createTableFromSource(ctx context.Context, tx *connection.Tx, project *metadata.Project, defaultDatasetID string, targetProject *metadata.Project, dataset *metadata.Dataset, existing *metadata.Table, tableID string, stmt *contentdata.DDLStatement) error {
+	clause, sourcePath := "LIKE", stmt.Like
+	if stmt.Copy != nil {
+		clause, sourcePath = "COPY", stmt.Copy
+	}
+	sourceProject, sourceDataset, source, err := s.findTableByPath(ctx, tx, project, defaultDatasetID, sourcePath)
+	if err != nil {
+		return err
+	}
+	if source == nil {
+		return errNotFound(fmt.Sprintf("Not found: Table %s:%s.%s", sourceProject.ID, sourceDataset.ID, sourcePath[len(sourcePath)-1]))
+	}
+	sourceContent, err := source.Content()
+	if err != nil {
+		return err
+	}
+	sourceName := fmt.Sprintf("%s:%s.%s", source.ProjectID, source.DatasetID, source.ID)
+	switch sourceContent.Type {
+	case string(ViewTableType), string(MaterializedViewTableType):
+		return errInvalid(fmt.Sprintf("CREATE TABLE %s requires a table, but %s is a %s", clause, sourceName, strings.ToLower(ddlObjectType(sourceContent))))
+	case string(ExternalTableType):
+		if stmt.Copy != nil {
+			return errInvalid(fmt.Sprintf("CREATE TABLE COPY cannot copy the external table %s", sourceName))
+		}
+	}
+	if sourceContent.Schema == nil {
+		return errInvalid(fmt.Sprintf("CREATE TABLE %s requires the schema of the table %s", clause, sourceName))
+	}
+	table := &bigqueryv2.Table{
+		TableReference: &bigqueryv2.TableReference{
+			ProjectId: targetProject.ID,
+			DatasetId: dataset.ID,
+			TableId:   tableID,
+		},
+		Schema:                  sourceContent.Schema,
+		TimePartitioning:        sourceContent.TimePartitioning,
+		RangePartitioning:       sourceContent.RangePartitioning,
+		Clustering:              sourceContent.Clustering,
+		RequirePartitionFilter:  sourceContent.RequirePartitionFilter,
+		Description:             sourceContent.Description,
+		FriendlyName:            sourceContent.FriendlyName,
+		Labels:                  sourceContent.Labels,
+		EncryptionConfiguration: sourceContent.EncryptionConfiguration,
+		DefaultCollation:        sourceContent.DefaultCollation,
+	}
+	for _, opt := range stmt.Options {
+		if err := setTableOption(table, opt); err != nil {
+			return err
+		}
+	}
+	if existing != nil {
+		if err := dataset.DeleteTable(ctx, tx.Tx(), tableID); err != nil {
+			return err
+		}
+		if err := s.contentRepo.DeleteTables(ctx, tx, targetProject.ID, dataset.ID, []string{tableID}); err != nil {
+			return err
+		}
+	}
+	if _, serverErr := createTableMetadata(ctx, tx, s, targetProject, dataset, table); serverErr != nil {
+		return serverErr
+	}
+	if err := s.contentRepo.CreateTable(ctx, tx, table); err != nil {
+		return err
+	}
+	if stmt.Copy == nil {
+		return nil
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO `%s.%s.%s` SELECT * FROM `%s.%s.%s`",
+		targetProject.ID, dataset.ID, tableID,
+		source.ProjectID, source.DatasetID, source.ID,
+	)
+	if _, err := s.contentRepo.Query(ctx, tx, project.ID, defaultDatasetID, query, nil); err != nil {
+		return fmt.Errorf("failed to copy the rows of %s: %w", sourceName, err)
+	}
+	return nil
+}
+
+// findTableByPath finds the table of the name split by the dot.
+// The name without the project is in project, and the name without the dataset is in the default dataset.
+// The table is nil if the dataset doesn't have the table.
+func (s *Server) findTableByPath(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, path []string) (*metadata.Project, *metadata.Dataset, *metadata.Table, error) {
+	if len(path) == 3 && path[0] != project.ID {
+		p, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), path[0])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if p == nil {
+			return nil, nil, nil, errNotFound(fmt.Sprintf("Not found: Project %s", path[0]))
+		}
+		project = p
+	}
+	tableID := path[len(path)-1]
+	if len(path) >= 2 {
+		datasetID = path[len(path)-2]
+	}
+	if datasetID == "" {
+		return nil, nil, nil, fmt.Errorf("Table %q must be qualified with a dataset (e.g. dataset.table)", tableID)
+	}
+	dataset := project.Dataset(datasetID)
+	if dataset == nil {
+		return nil, nil, nil, errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", project.ID, datasetID))
+	}
+	return project, dataset, dataset.Table(tableID), nil
+}
+
+// setTableOption sets the option of the table DDL. The NULL value clears the option.
+func setTableOption(table *bigqueryv2.Table, opt *contentdata.Option) error {
+	switch opt.Name {
+	case "description":
+		if opt.Value == nil {
+			table.Description = ""
+			return nil
+		}
+		v, err := stringOption(opt)
+		if err != nil {
+			return err
+		}
+		table.Description = v
+	case "friendly_name":
+		if opt.Value == nil {
+			table.FriendlyName = ""
+			return nil
+		}
+		v, err := stringOption(opt)
+		if err != nil {
+			return err
+		}
+		table.FriendlyName = v
+	case "labels":
+		if opt.Value == nil {
+			table.Labels = nil
+			return nil
+		}
+		labels, err := labelsOption(opt)
+		if err != nil {
+			return err
+		}
+		table.Labels = labels
+	case "expiration_timestamp":
+		if opt.Value == nil {
+			table.ExpirationTime = 0
+			return nil
+		}
+		v, err := stringOption(opt)
+		if err != nil {
+			return fmt.Errorf("expiration_timestamp option must be TIMESTAMP")
+		}
+		t, err := parseTimestampOption(v)
+		if err != nil {
+			return err
+		}
+		table.ExpirationTime = t.UnixMilli()
+	case "kms_key_name":
+		if opt.Value == nil {
+			table.EncryptionConfiguration = nil
+			return nil
+		}
+		v, err := stringOption(opt)
+		if err != nil {
+			return err
+		}
+		table.EncryptionConfiguration = &bigqueryv2.EncryptionConfiguration{KmsKeyName: v}
+	case "partition_expiration_days":
+		if table.TimePartitioning == nil {
+			return fmt.Errorf("partition_expiration_days option requires the time partitioned table")
+		}
+		if opt.Value == nil {
+			table.TimePartitioning.ExpirationMs = 0
+			return nil
+		}
+		ms, err := daysOptionToMillis(opt)
+		if err != nil {
+			return err
+		}
+		table.TimePartitioning.ExpirationMs = ms
+	case "require_partition_filter":
+		if opt.Value == nil {
+			table.RequirePartitionFilter = false
+			return nil
+		}
+		v, ok := opt.Value.(bool)
+		if !ok {
+			return fmt.Errorf("require_partition_filter option must be BOOL")
+		}
+		table.RequirePartitionFilter = v
+	default:
+		return fmt.Errorf("unsupported table option: %s", opt.Name)
+	}
+	return nil
+}
+
+// execAlterTableStatement updates the options of the table by ALTER TABLE SET OPTIONS,
+// or renames the table by ALTER TABLE RENAME TO.
+func (s *Server) execAlterTableStatement(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, stmt *contentdata.AlterTableStatement) error {
+	tableProject, dataset, table, err := s.findTableByPath(ctx, tx, project, datasetID, stmt.TablePath)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s:%s.%s", tableProject.ID, dataset.ID, stmt.TablePath[len(stmt.TablePath)-1])
+	if table == nil {
+		if stmt.IfExists {
+			return nil
+		}
+		return errNotFound(fmt.Sprintf("Not found: Table %s", name))
+	}
+	content, err := table.Content()
+	if err != nil {
+		return err
+	}
+	if objectType := ddlObjectType(content); objectType != "TABLE" {
+		return errInvalid(fmt.Sprintf("%s is a %s. Use ALTER %s instead of ALTER TABLE", name, strings.ToLower(objectType), objectType))
+	}
+	if stmt.RenameTo != "" {
+		return s.renameTable(ctx, tx, tableProject, dataset, table, content, stmt.RenameTo)
+	}
+	for _, opt := range stmt.Options {
+		if err := setTableOption(content, opt); err != nil {
+			return err
+		}
+	}
+	content.LastModifiedTime = uint64(time.Now().Unix())
+	return table.UpdateContent(ctx, tx.Tx(), content)
+}
+
+// renameTable moves the metadata and the rows of table to the new table in the same dataset.
+// The views referring to the table by the old name fail to find the table as BigQuery does.
+func (s *Server) renameTable(ctx context.Context, tx *connection.Tx, project *metadata.Project, dataset *metadata.Dataset, table *metadata.Table, content *bigqueryv2.Table, newID string) error {
+	if newID == table.ID {
+		return nil
+	}
+	exists := dataset.Table(newID) != nil
+	if !exists {
+		var err error
+		exists, err = s.tableExists(ctx, tx, project.ID, dataset.ID, []string{project.ID, dataset.ID, newID})
+		if err != nil {
+			return err
+		}
+	}
+	if exists {
+		return errDuplicate(fmt.Sprintf("Already Exists: Table %s:%s.%s", project.ID, dataset.ID, newID))
+	}
+	if content.ExternalDataConfiguration != nil {
+		return errInvalid(fmt.Sprintf("ALTER TABLE RENAME TO is not supported for the external table %s:%s.%s", project.ID, dataset.ID, table.ID))
+	}
+	index, err := table.SearchIndex()
+	if err != nil {
+		return err
+	}
+	if err := dataset.DeleteTable(ctx, tx.Tx(), table.ID); err != nil {
+		return err
+	}
+	content.TableReference = &bigqueryv2.TableReference{
+		ProjectId: project.ID,
+		DatasetId: dataset.ID,
+		TableId:   newID,
+	}
+	if _, serverErr := createTableMetadata(ctx, tx, s, project, dataset, content); serverErr != nil {
+		return serverErr
+	}
+	if err := s.contentRepo.CreateTable(ctx, tx, content); err != nil {
+		return err
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO `%s.%s.%s` SELECT * FROM `%s.%s.%s`",
+		project.ID, dataset.ID, newID,
+		project.ID, dataset.ID, table.ID,
+	)
+	if _, err := s.contentRepo.Query(ctx, tx, project.ID, dataset.ID, query, nil); err != nil {
+		return fmt.Errorf("failed to move the rows of %s: %w", table.ID, err)
+	}
+	if err := s.contentRepo.DeleteTables(ctx, tx, project.ID, dataset.ID, []string{table.ID}); err != nil {
+		return err
+	}
+	if index != nil {
+		return dataset.Table(newID).SetSearchIndex(ctx, tx.Tx(), index)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	internaltypes "github.com/goccy/bigquery-emulator/internal/types"
+	"github.com/goccy/bigquery-emulator/types"
+)
+
+const (
+	createIfNeededDisposition = "CREATE_IF_NEEDED"
+	createNeverDisposition    = "CREATE_NEVER"
+	writeTruncateDisposition  = "WRITE_TRUNCATE"
+	writeAppendDisposition    = "WRITE_APPEND"
+	writeEmptyDisposition     = "WRITE_EMPTY"
+)
+
+// validateQueryDestination validates the destination options of the query job before the query runs.
+// allowLargeResults is the option of legacy SQL and requires the destination table, and it is ignored by standard SQL.
+func validateQueryDestination(query *bigqueryv2.JobConfigurationQuery) error {
+	switch query.CreateDisposition {
+	case "", createIfNeededDisposition, createNeverDisposition:
+	default:
+		return errInvalid(fmt.Sprintf("Invalid value for createDisposition: %s", query.CreateDisposition))
+	}
+	switch query.WriteDisposition {
+	case "", writeTruncateDisposition, writeAppendDisposition, writeEmptyDisposition:
+	default:
+		return errInvalid(fmt.Sprintf("Invalid value for writeDisposition: %s", query.WriteDisposition))
+	}
+	if query.AllowLargeResults && query.UseLegacySql != nil && *query.UseLegacySql && query.DestinationTable == nil {
+		return errInvalid("allowLargeResults requires destinationTable to be set")
+	}
+	return nil
+}
+
+// writeQueryDestinationTable writes the result of the query job to its destination table.
+// The table is created with the schema of the result if it doesn't exist and createDisposition is CREATE_IF_NEEDED,
+// otherwise the result must fit the schema of the existing table.
+// writeDisposition is WRITE_EMPTY by default same as BigQuery.
+// schemaUpdateOptions add the columns of the result to the existing table and relax its REQUIRED fields in the same transaction.
+func (h *jobsInsertHandler) writeQueryDestinationTable(ctx context.Context, tx *connection.Tx, r *jobsInsertRequest, query *bigqueryv2.JobConfigurationQuery, response *internaltypes.QueryResponse) error {
+	tableRef := query.DestinationTable
+	project := r.project
+	if tableRef.ProjectId != "" && tableRef.ProjectId != project.ID {
+		p, err := r.server.metaRepo.FindProjectWithConn(ctx, tx.Tx(), tableRef.ProjectId)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return errNotFound(fmt.Sprintf("Not found: Project %s", tableRef.ProjectId))
+		}
+		project = p
+	}
+	tableID, decorator := splitPartitionDecorator(tableRef.TableId)
+	if err := validateSchemaUpdateOptions(query.SchemaUpdateOptions, query.WriteDisposition, decorator); err != nil {
+		return err
+	}
+	tableName := fmt.Sprintf("%s:%s.%s", project.ID, tableRef.DatasetId, tableID)
+	dataset := project.Dataset(tableRef.DatasetId)
+	if dataset == nil {
+		return errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", project.ID, tableRef.DatasetId))
+	}
+	tableDef, err := h.tableDefFromQueryResponse(tableID, response)
+	if err != nil {
+		return err
+	}
+	table := dataset.Table(tableID)
+	if table == nil {
+		if query.CreateDisposition == createNeverDisposition {
+			return errNotFound(fmt.Sprintf("Not found: Table %s", tableName))
+		}
+		newTable := tableDef.ToBigqueryV2(project.ID, dataset.ID)
+		newTable.TimePartitioning = query.TimePartitioning
+		newTable.RangePartitioning = query.RangePartitioning
+		newTable.Clustering = query.Clustering
+		if err := validateDestinationPartitioning(newTable); err != nil {
+			return err
+		}
+		if _, serverErr := createTableMetadata(ctx, tx, r.server, project, dataset, newTable); serverErr != nil {
+			return serverErr
+		}
+		if err := r.server.contentRepo.CreateTable(ctx, tx, newTable); err != nil {
+			return fmt.Errorf("failed to create table: %w", err)
+		}
+		return r.server.contentRepo.AddTableData(ctx, tx, project.ID, dataset.ID, tableDef)
+	}
+
+	content, err := table.Content()
+	if err != nil {
+		return err
+	}
+	if content.Type == string(ViewTableType) || content.Type == string(MaterializedViewTableType) {
+		return errInvalid(fmt.Sprintf("Cannot write query results to %s: destination table is a view", tableName))
+	}
+	if len(query.SchemaUpdateOptions) == 0 {
+		if err := validateDestinationSchema(tableName, content.Schema, response.Schema); err != nil {
+			return err
+		}
+	} else if content, err = r.server.updateDestinationSchema(ctx, tx, project, dataset, table, content, response.Schema, query.SchemaUpdateOptions); err != nil {
+		return err
+	}
+	if err := validateDestinationPartitionSpec(content, query); err != nil {
+		return err
+	}
+	switch query.WriteDisposition {
+	case writeTruncateDisposition:
+		if err := r.server.truncateDestination(ctx, tx, project, dataset, table, content, decorator); err != nil {
+			return err
+		}
+	case writeAppendDisposition:
+	default:
+		hasData, err := r.server.contentRepo.HasTableData(ctx, tx, project.ID, dataset.ID, table.ID)
+		if err != nil {
+			return err
+		}
+		if hasData {
+			return errDuplicate(fmt.Sprintf("Already Exists: Table %s", tableName))
+		}
+	}
+	return r.server.contentRepo.AddTableData(ctx, tx, project.ID, dataset.ID, tableDef)
+}
+
+// validateDestinationSchema checks that every column of the query result exists in the destination table with the same type.
+func validateDestinationSchema(tableName string, tableSchema, resultSchema *bigqueryv2.TableSchema) error {
+	fields := map[string]*bigqueryv2.TableFieldSchema{}
+	if tableSchema != nil {
+		for _, field := range tableSchema.Fields {
+			fields[strings.ToLower(field.Name)] = field
+		}
+	}
+	if resultSchema == nil {
+		return nil
+	}
+	for _, field := range resultSchema.Fields {
+		tableField, exists := fields[strings.ToLower(field.Name)]
+		if !exists {
+			return errInvalid(fmt.Sprintf("Provided Schema does not match Table %s. Cannot add fields (field: %s)", tableName, field.Name))
+		}
+		if types.Type(tableField.Type).ZetaSQLTypeKind() != types.Type(field.Type).ZetaSQLTypeKind() {
+			return errInvalid(fmt.Sprintf(
+				"Provided Schema does not match Table %s. Field %s has changed type from %s to %s",
+				tableName, tableField.Name, tableField.Type, field.Type,
+			))
+		}
+		if isRepeatedField(tableField) != isRepeatedField(field) {
+			return errInvalid(fmt.Sprintf(
+				"Provided Schema does not match Table %s. Field %s has changed mode from %s to %s",
+				tableName, tableField.Name, fieldMode(tableField), fieldMode(field),
+			))
+		}
+	}
+	return nil
+}
+
+// validateDestinationPartitioning checks that the partitioning column of the new destination table is in the query result.
+func validateDestinationPartitioning(table *bigqueryv2.Table) error {
+	var columns []string
+	if table.TimePartitioning != nil && table.TimePartitioning.Field != "" {
+		columns = append(columns, table.TimePartitioning.Field)
+	}
+	if table.RangePartitioning != nil {
+		columns = append(columns, table.RangePartitioning.Field)
+	}
+	for _, column := range columns {
+		var found bool
+		for _, field := range table.Schema.Fields {
+			if strings.EqualFold(field.Name, column) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errInvalid(fmt.Sprintf("The field specified for partitioning cannot be found in the schema: %s", column))
+		}
+	}
+	return nil
+}
+
+// validateDestinationPartitionSpec checks that the partitioning of the query job matches the existing destination table.
+func validateDestinationPartitionSpec(table *bigqueryv2.Table, query *bigqueryv2.JobConfigurationQuery) error {
+	if query.TimePartitioning == nil && query.RangePartitioning == nil {
+		return nil
+	}
+	expected, actual := partitioningSpec(table.TimePartitioning, table.RangePartitioning), partitioningSpec(query.TimePartitioning, query.RangePartitioning)
+	if expected != actual {
+		return errInvalid(fmt.Sprintf(
+			"Incompatible table partitioning specification. Expects partitioning specification %s, but input partitioning specification is %s",
+			expected, actual,
+		))
+	}
+	return nil
+}
+
+func partitioningSpec(timePartitioning *bigqueryv2.TimePartitioning, rangePartitioning *bigqueryv2.RangePartitioning) string {
+	switch {
+	case timePartitioning != nil:
+		field := timePartitioning.Field
+		if field == "" {
+			field = "_PARTITIONTIME"
+		}
+		return fmt.Sprintf("interval(type:%s,field:%s)", strings.ToLower(timePartitioning.Type), field)
+	case rangePartitioning != nil:
+		return fmt.Sprintf("range(field:%s)", rangePartitioning.Field)
+	}
+	return "none"
+}
+
+func isRepeatedField(field *bigqueryv2.TableFieldSchema) bool {
+	return strings.EqualFold(field.Mode, string(types.RepeatedMode))
+}
+
+func fieldMode(field *bigqueryv2.TableFieldSchema) string {
+	if field.Mode == "" {
+		return string(types.NullableMode)
+	}
+	return strings.ToUpper(field.Mode)
+}
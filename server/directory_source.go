@@ -0,0 +1,329 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+	"github.com/goccy/bigquery-emulator/types"
+)
+
+// directorySourceFormats maps the extension of the file in the datasets directory to the source format of the table.
+var directorySourceFormats = map[string]string{
+	".csv":     externalSourceFormatCSV,
+	".json":    externalSourceFormatJSON,
+	".jsonl":   externalSourceFormatJSON,
+	".ndjson":  externalSourceFormatJSON,
+	".parquet": externalSourceFormatParquet,
+}
+
+type directoryTable struct {
+	id     string
+	path   string
+	format string
+}
+
+type directoryDataset struct {
+	projectID string
+	id        string
+	tables    []*directoryTable
+}
+
+// DirectorySource loads the datasets from the files under dir.
+// Each subdirectory of dir is a dataset of projectID and each file in it is a table named after the file
+// without the extension. A subdirectory that has only directories is a project, and its subdirectories are the datasets
+// of the project ( e.g. dir/bigquery-public-data/samples/shakespeare.csv ).
+// The format of the table is inferred from the extension: .csv ( the first line is the header ),
+// .json, .jsonl or .ndjson ( newline-delimited JSON ) and .parquet.
+// The tables are the external tables that read the files each time a query references them,
+// so the large files aren't read on startup except for detecting the schema from them.
+// The datasets of the other projects than projectID are shared, so they are read-only.
+func DirectorySource(projectID, dir string) Source {
+	return func(s *Server) error {
+		datasets, err := readDatasetsDirectory(projectID, dir)
+		if err != nil {
+			return err
+		}
+		ctx := context.Background()
+		projectDatasets := map[string][]*directoryDataset{}
+		var projectIDs []string
+		for _, dataset := range datasets {
+			if _, exists := projectDatasets[dataset.projectID]; !exists {
+				projectIDs = append(projectIDs, dataset.projectID)
+			}
+			projectDatasets[dataset.projectID] = append(projectDatasets[dataset.projectID], dataset)
+		}
+		for _, id := range projectIDs {
+			project := types.NewProject(id)
+			for _, dataset := range projectDatasets[id] {
+				project.Datasets = append(project.Datasets, types.NewDataset(dataset.id))
+			}
+			if err := s.addProject(ctx, project); err != nil {
+				return err
+			}
+			if err := s.addDirectoryTables(ctx, id, projectDatasets[id]); err != nil {
+				return err
+			}
+			if id != projectID {
+				for _, dataset := range projectDatasets[id] {
+					s.addSharedDataset(id, dataset.id)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+func readDatasetsDirectory(projectID, dir string) ([]*directoryDataset, error) {
+	entries, err := readVisibleDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var datasets []*directoryDataset
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory: the datasets directory must have only the dataset or project directories", path)
+		}
+		children, err := readVisibleDir(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(children) == 0 || !allDirectories(children) {
+			dataset, err := readDatasetDirectory(projectID, entry.Name(), path)
+			if err != nil {
+				return nil, err
+			}
+			datasets = append(datasets, dataset)
+			continue
+		}
+		for _, child := range children {
+			dataset, err := readDatasetDirectory(entry.Name(), child.Name(), filepath.Join(path, child.Name()))
+			if err != nil {
+				return nil, err
+			}
+			datasets = append(datasets, dataset)
+		}
+	}
+	return datasets, nil
+}
+
+func readDatasetDirectory(projectID, datasetID, dir string) (*directoryDataset, error) {
+	entries, err := readVisibleDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dataset := &directoryDataset{projectID: projectID, id: datasetID}
+	tableMap := map[string]*directoryTable{}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			return nil, fmt.Errorf("%s is a directory: the dataset directory must have only the table files", path)
+		}
+		ext := filepath.Ext(entry.Name())
+		format, ok := directorySourceFormats[strings.ToLower(ext)]
+		if !ok {
+			return nil, fmt.Errorf("the format of %s is unknown: the extension must be .csv, .json, .jsonl, .ndjson or .parquet", path)
+		}
+		id := strings.TrimSuffix(entry.Name(), ext)
+		if found, exists := tableMap[id]; exists {
+			return nil, fmt.Errorf("table %s.%s.%s is defined by both %s and %s", projectID, datasetID, id, found.path, path)
+		}
+		table := &directoryTable{id: id, path: path, format: format}
+		tableMap[id] = table
+		dataset.tables = append(dataset.tables, table)
+	}
+	return dataset, nil
+}
+
+// readVisibleDir reads the entries of dir except the hidden files like .DS_Store or .gitkeep.
+func readVisibleDir(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]os.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		visible = append(visible, entry)
+	}
+	sort.Slice(visible, func(i, j int) bool { return visible[i].Name() < visible[j].Name() })
+	return visible, nil
+}
+
+func allDirectories(entries []os.DirEntry) bool {
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// addDirectoryTables adds the tables of datasets as the external tables of the project.
+// The table that already exists ( e.g. restored from the database file ) is replaced.
+func (s *Server) addDirectoryTables(ctx context.Context, projectID string, datasets []*directoryDataset) error {
+	conn, err := s.connMgr.Connection(ctx, projectID, "")
+	if err != nil {
+		return err
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackIfNotCommitted()
+	project, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), projectID)
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return fmt.Errorf("project %s is not found", projectID)
+	}
+	for _, d := range datasets {
+		dataset := project.Dataset(d.id)
+		if dataset == nil {
+			return fmt.Errorf("dataset %s.%s is not found", projectID, d.id)
+		}
+		for _, t := range d.tables {
+			table, tableMetadata, err := newDirectoryTable(projectID, d.id, t)
+			if err != nil {
+				return err
+			}
+			if found := dataset.Table(t.id); found != nil {
+				if err := found.UpdateContent(ctx, tx.Tx(), table); err != nil {
+					return err
+				}
+			} else if err := dataset.AddTable(
+				ctx,
+				tx.Tx(),
+				metadata.NewTable(s.metaRepo, projectID, d.id, t.id, tableMetadata),
+			); err != nil {
+				return err
+			}
+			tableDef, err := types.NewTableWithSchema(table, nil)
+			if err != nil {
+				return err
+			}
+			if err := s.contentRepo.CreateOrReplaceTable(ctx, tx, projectID, d.id, tableDef); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func newDirectoryTable(projectID, datasetID string, t *directoryTable) (*bigqueryv2.Table, map[string]interface{}, error) {
+	path, err := filepath.Abs(t.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &bigqueryv2.ExternalDataConfiguration{
+		SourceFormat: t.format,
+		SourceUris:   []string{fileURIPrefix + path},
+		Autodetect:   true,
+	}
+	if t.format == externalSourceFormatCSV {
+		config.CsvOptions = &bigqueryv2.CsvOptions{SkipLeadingRows: 1}
+	}
+	now := time.Now().Unix()
+	table := &bigqueryv2.Table{
+		Id:   fmt.Sprintf("%s:%s.%s", projectID, datasetID, t.id),
+		Kind: "bigquery#table",
+		Type: string(ExternalTableType),
+		TableReference: &bigqueryv2.TableReference{
+			ProjectId: projectID,
+			DatasetId: datasetID,
+			TableId:   t.id,
+		},
+		ExternalDataConfiguration: config,
+		CreationTime:              now,
+		LastModifiedTime:          uint64(now),
+	}
+	if serverErr := prepareExternalTable(table); serverErr != nil {
+		return nil, nil, fmt.Errorf("failed to load %s: %s", t.path, serverErr.Message)
+	}
+	encoded, err := json.Marshal(table)
+	if err != nil {
+		return nil, nil, err
+	}
+	var tableMetadata map[string]interface{}
+	if err := json.Unmarshal(encoded, &tableMetadata); err != nil {
+		return nil, nil, err
+	}
+	return table, tableMetadata, nil
+}
+
+// addSharedDataset makes the dataset read-only.
+func (s *Server) addSharedDataset(projectID, datasetID string) {
+	if s.sharedDatasets == nil {
+		s.sharedDatasets = map[string]struct{}{}
+	}
+	s.sharedDatasets[projectID+"."+datasetID] = struct{}{}
+}
+
+func (s *Server) isSharedDataset(projectID, datasetID string) bool {
+	_, exists := s.sharedDatasets[projectID+"."+datasetID]
+	return exists
+}
+
+func errSharedDataset(projectID, datasetID string) *ServerError {
+	return errAccessDenied(fmt.Sprintf(
+		"Access Denied: Dataset %s:%s: the shared dataset loaded from the datasets directory is read-only",
+		projectID, datasetID,
+	))
+}
+
+// sharedDatasetQueryError returns the error if the statement of query changes the shared dataset or the objects in it.
+// The unqualified names are resolved with the default dataset of the query.
+func (s *Server) sharedDatasetQueryError(projectID, datasetID, query string) error {
+	if len(s.sharedDatasets) == 0 {
+		return nil
+	}
+	object := contentdata.ParseWrittenObject(query)
+	if object == nil {
+		return nil
+	}
+	path := object.Path
+	if object.Schema {
+		// the name of the dataset is [project.]dataset.
+		path = append(path, "")
+	}
+	switch len(path) {
+	case 2:
+		datasetID = path[0]
+	case 3:
+		projectID, datasetID = path[0], path[1]
+	}
+	if s.isSharedDataset(projectID, datasetID) {
+		return errSharedDataset(projectID, datasetID)
+	}
+	return nil
+}
+
+// sharedDatasetJobError returns the error if the load job, the copy job or the query job writes the table of the shared dataset.
+func (s *Server) sharedDatasetJobError(config *bigqueryv2.JobConfiguration) error {
+	var dst *bigqueryv2.TableReference
+	switch {
+	case config.Load != nil:
+		dst = config.Load.DestinationTable
+	case config.Copy != nil:
+		dst = config.Copy.DestinationTable
+	case config.Query != nil:
+		dst = config.Query.DestinationTable
+	}
+	if dst != nil && s.isSharedDataset(dst.ProjectId, dst.DatasetId) {
+		return errSharedDataset(dst.ProjectId, dst.DatasetId)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultShutdownGracePeriod is the time Stop waits for the in-flight requests before cancelling them.
+const DefaultShutdownGracePeriod = 30 * time.Second
+
+// drainer tracks the in-flight requests of REST and gRPC APIs so that Stop can wait for them.
+type drainer struct {
+	mu       sync.Mutex
+	inFlight int
+	draining bool
+	idle     chan struct{}
+
+	// ctx is cancelled when the in-flight requests exceed the grace period.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newDrainer() *drainer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &drainer{
+		idle:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// enter registers the request. It returns false if the server is draining.
+func (d *drainer) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+func (d *drainer) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.draining && d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+// drain stops accepting new requests and returns the channel closed when all in-flight requests complete.
+func (d *drainer) drain() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.draining {
+		d.draining = true
+		if d.inFlight == 0 {
+			close(d.idle)
+		}
+	}
+	return d.idle
+}
+
+// requestContext returns the context of the request that is cancelled when the server stops draining.
+func (d *drainer) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(d.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+func errShuttingDown() *ServerError {
+	e := errBackendError("the server is shutting down")
+	e.Status = http.StatusServiceUnavailable
+	return e
+}
+
+func drainMiddleware(s *Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.drainer.enter() {
+				errorResponse(r.Context(), w, errShuttingDown())
+				return
+			}
+			defer s.drainer.leave()
+			ctx, cancel := s.drainer.requestContext(r.Context())
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+type drainingServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *drainingServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// newGRPCServer creates the gRPC server whose RPCs are drained by Stop.
+// The RPCs cancelled by Stop end with codes.Unavailable.
+func (s *Server) newGRPCServer() *grpc.Server {
+	return grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := s.readOnlyRPCError(info.FullMethod); err != nil {
+				return nil, err
+			}
+			if !s.drainer.enter() {
+				return nil, status.Error(codes.Unavailable, "the server is shutting down")
+			}
+			defer s.drainer.leave()
+			ctx, cancel := s.drainer.requestContext(withGRPCUser(ctx))
+			defer cancel()
+			start := time.Now()
+			res, err := handler(ctx, req)
+			s.logRPC(info.FullMethod, req, start, err)
+			if err != nil && s.drainer.ctx.Err() != nil {
+				return nil, status.Error(codes.Unavailable, "the server is shutting down")
+			}
+			return res, err
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.readOnlyRPCError(info.FullMethod); err != nil {
+				return err
+			}
+			if !s.drainer.enter() {
+				return status.Error(codes.Unavailable, "the server is shutting down")
+			}
+			defer s.drainer.leave()
+			ctx, cancel := s.drainer.requestContext(withGRPCUser(ss.Context()))
+			defer cancel()
+			start := time.Now()
+			err := handler(srv, &drainingServerStream{ServerStream: ss, ctx: ctx})
+			s.logRPC(info.FullMethod, nil, start, err)
+			if err != nil {
+				if s.drainer.ctx.Err() != nil {
+					return status.Error(codes.Unavailable, "the server is shutting down")
+				}
+				return err
+			}
+			return nil
+		}),
+	)
+}
+
+// SetShutdownGracePeriod sets the time Stop waits for the in-flight requests.
+// The requests still running after the period are cancelled and their transactions are rolled back.
+// Zero means that Stop waits until the context passed to Stop is done.
+func (s *Server) SetShutdownGracePeriod(d time.Duration) {
+	s.shutdownGracePeriod = d
+}
+
+// drain waits for the in-flight requests up to the grace period and cancels the rest.
+func (s *Server) drain(ctx context.Context) error {
+	idle := s.drainer.drain()
+	graceCtx := ctx
+	if s.shutdownGracePeriod > 0 {
+		var cancel context.CancelFunc
+		graceCtx, cancel = context.WithTimeout(ctx, s.shutdownGracePeriod)
+		defer cancel()
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-graceCtx.Done():
+	}
+	s.logger.Warn("cancel the in-flight requests exceeding the shutdown grace period")
+	s.drainer.cancel()
+	// the cancelled requests return soon after their queries are interrupted.
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
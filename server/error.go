@@ -1,8 +1,10 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
 
 	"github.com/goccy/go-json"
 	bigqueryv2 "google.golang.org/api/bigquery/v2"
@@ -14,9 +16,13 @@ import (
 type ServerError struct {
 	Status    int         `json:"-"`
 	Reason    ErrorReason `json:"reason"`
-	Location  string      `json:"location"`
-	DebugInfo string      `json:"debugInfo"`
+	Location  string      `json:"location,omitempty"`
+	DebugInfo string      `json:"debugInfo,omitempty"`
 	Message   string      `json:"message"`
+
+	// Errors are the additional errors reported with this error.
+	// They follow this error in the errors of the response and the status of the job.
+	Errors []*ServerError `json:"-"`
 }
 
 type ResponseError struct {
@@ -27,6 +33,7 @@ type ErrorFormat struct {
 	Errors  []*ServerError `json:"errors"`
 	Code    int            `json:"code"`
 	Message string         `json:"message"`
+	Status  string         `json:"status,omitempty"`
 }
 
 func (e *ServerError) ErrorProto() *bigqueryv2.ErrorProto {
@@ -38,17 +45,101 @@ func (e *ServerError) ErrorProto() *bigqueryv2.ErrorProto {
 	}
 }
 
+// ErrorProtos returns this error followed by the additional errors.
+// It is used as the errors of the status of the failed job.
+func (e *ServerError) ErrorProtos() []*bigqueryv2.ErrorProto {
+	protos := []*bigqueryv2.ErrorProto{e.ErrorProto()}
+	for _, err := range e.Errors {
+		protos = append(protos, err.ErrorProto())
+	}
+	return protos
+}
+
 func (e *ServerError) Response() []byte {
 	b, _ := json.Marshal(&ResponseError{
 		Error: &ErrorFormat{
-			Errors:  []*ServerError{e},
+			Errors:  append([]*ServerError{e}, e.Errors...),
 			Code:    e.Status,
 			Message: e.Message,
+			Status:  errorStatus(e.Status),
 		},
 	})
 	return b
 }
 
+// errorStatus returns the canonical status of the error response for the HTTP status code.
+func errorStatus(code int) string {
+	switch code {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusConflict:
+		return "ALREADY_EXISTS"
+	case http.StatusPreconditionFailed:
+		return "FAILED_PRECONDITION"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusInternalServerError:
+		return "INTERNAL"
+	case http.StatusNotImplemented:
+		return "UNIMPLEMENTED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	}
+	return ""
+}
+
+var (
+	analyzeErrorPattern  = regexp.MustCompile(`(?s)failed to analyze: (?:[A-Z_]+: )?(.*?)(?: \[at (\d+:\d+)\])?$`)
+	tableNotFoundPattern = regexp.MustCompile(`^Table not found: ([^\s;]+)`)
+)
+
+// queryError returns the error of the query job as BigQuery reports it.
+// The errors of the emulator like notFound, duplicate and accessDenied are reported as they are.
+// The compile errors of go-zetasqlite like "failed to analyze: INVALID_ARGUMENT: Unrecognized name: x [at 1:8]"
+// are invalidQuery reported as "Unrecognized name: x at [1:8]" with the query location,
+// and "Table not found: x" of them is notFound. The other errors are jobInternalError.
+// The joined errors are reported as the first error followed by the others as the additional errors.
+func queryError(err error) *ServerError {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var serverErr *ServerError
+		for _, err := range joined.Unwrap() {
+			if serverErr == nil {
+				serverErr = queryError(err)
+				continue
+			}
+			serverErr.Errors = append(serverErr.Errors, queryError(err))
+		}
+		if serverErr != nil {
+			return serverErr
+		}
+	}
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr
+	}
+	msg := err.Error()
+	matched := analyzeErrorPattern.FindStringSubmatch(msg)
+	if matched == nil {
+		return errJobInternalError(msg)
+	}
+	msg, position := matched[1], matched[2]
+	if table := tableNotFoundPattern.FindStringSubmatch(msg); table != nil {
+		return errNotFound(fmt.Sprintf("Not found: Table %s", table[1]))
+	}
+	if position != "" {
+		msg = fmt.Sprintf("%s at [%s]", msg, position)
+	}
+	serverErr = errInvalidQuery(msg)
+	serverErr.Location = "query"
+	return serverErr
+}
+
 func (e *ServerError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Reason, e.Message)
 }
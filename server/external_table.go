@@ -0,0 +1,989 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/segmentio/parquet-go"
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+	"github.com/goccy/bigquery-emulator/types"
+)
+
+const (
+	externalSourceFormatCSV     = "CSV"
+	externalSourceFormatJSON    = "NEWLINE_DELIMITED_JSON"
+	externalSourceFormatParquet = "PARQUET"
+	fileURIPrefix               = "file://"
+	// fileNameColumn is the pseudo-column that has the path of the source file of each row.
+	fileNameColumn = "_FILE_NAME"
+)
+
+var invalidColumnNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// prepareExternalTable validates the external data configuration of the table created by tables.insert
+// and sets the schema of the table. Without the schema, the schema is detected from the source files.
+// The emulator reads the external table only from the local files, the data is loaded by loadExternalTables
+// when the query reads the table.
+func prepareExternalTable(table *bigqueryv2.Table) *ServerError {
+	config := table.ExternalDataConfiguration
+	if serverErr := validateExternalSource(config); serverErr != nil {
+		return serverErr
+	}
+	files, err := externalSourceFiles(config)
+	if err != nil {
+		return errInvalid(err.Error())
+	}
+	if table.Schema == nil {
+		table.Schema = config.Schema
+	}
+	if table.Schema == nil || len(table.Schema.Fields) == 0 {
+		if !config.Autodetect {
+			return errInvalid("the schema of the external table must be specified or autodetect must be enabled")
+		}
+		schema, err := detectExternalSchema(config)
+		if err != nil {
+			return errInvalid(fmt.Sprintf("failed to detect the schema of the external table: %s", err))
+		}
+		table.Schema = schema
+	}
+	if config.HivePartitioningOptions == nil {
+		return nil
+	}
+	partitioning, err := newHivePartitioning(config.HivePartitioningOptions, files)
+	if err != nil {
+		return errInvalid(fmt.Sprintf("invalid hive partitioning: %s", err))
+	}
+	// the partition keys are the columns of the table after the columns of the source files.
+	fields := append([]*bigqueryv2.TableFieldSchema{}, table.Schema.Fields...)
+	for _, key := range partitioning.keys {
+		if schemaField(table.Schema, key.Name) == nil {
+			fields = append(fields, key)
+		}
+	}
+	table.Schema = &bigqueryv2.TableSchema{Fields: fields}
+	config.HivePartitioningOptions.Fields = partitioning.keyNames()
+	return nil
+}
+
+// validateExternalSource validates the source format and the source URIs of the external table.
+// The object table, which has the metadata of the files instead of their data, is not supported.
+func validateExternalSource(config *bigqueryv2.ExternalDataConfiguration) *ServerError {
+	if config.ObjectMetadata != "" {
+		return errNotImplemented("Unsupported feature: the object table is not supported by the emulator")
+	}
+	switch config.SourceFormat {
+	case externalSourceFormatCSV, externalSourceFormatJSON, externalSourceFormatParquet:
+	case "":
+		return errInvalid("sourceFormat of the external table is not specified")
+	default:
+		return errNotImplemented(fmt.Sprintf("Unsupported feature: the external table of %s is not supported by the emulator", config.SourceFormat))
+	}
+	if len(config.SourceUris) == 0 {
+		return errInvalid("sourceUris of the external table is not specified")
+	}
+	return nil
+}
+
+// maxExternalFileCacheRows is the maximum number of the rows cached by externalFileCache.
+const maxExternalFileCacheRows = 1000000
+
+// externalFileCache caches the rows parsed from the source files of the external tables.
+// The rows of a file are parsed again when the file is modified.
+// The least recently used files are evicted when the cached rows exceed maxExternalFileCacheRows,
+// and the file that has more rows than the limit is not cached.
+type externalFileCache struct {
+	mu      sync.Mutex
+	entries map[string]*externalFileEntry
+	rowsNum int
+}
+
+type externalFileEntry struct {
+	modTime time.Time
+	size    int64
+	// key is the encoded configuration and schema that the rows are parsed with.
+	key        string
+	rows       types.Data
+	badRecords int64
+	usedAt     time.Time
+}
+
+func newExternalFileCache() *externalFileCache {
+	return &externalFileCache{entries: map[string]*externalFileEntry{}}
+}
+
+// rows returns the rows of the file and the number of the bad records skipped in the file.
+func (c *externalFileCache) rows(file, key string, parse func([]byte) (types.Data, int64, error)) (types.Data, int64, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, exists := c.entries[file]; exists && entry.key == key && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		entry.usedAt = time.Now()
+		return entry.rows, entry.badRecords, nil
+	}
+	c.remove(file)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, badRecords, err := parse(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(rows) > maxExternalFileCacheRows {
+		return rows, badRecords, nil
+	}
+	for c.rowsNum+len(rows) > maxExternalFileCacheRows {
+		c.remove(c.leastRecentlyUsed())
+	}
+	c.entries[file] = &externalFileEntry{
+		modTime:    info.ModTime(),
+		size:       info.Size(),
+		key:        key,
+		rows:       rows,
+		badRecords: badRecords,
+		usedAt:     time.Now(),
+	}
+	c.rowsNum += len(rows)
+	return rows, badRecords, nil
+}
+
+func (c *externalFileCache) remove(file string) {
+	if entry, exists := c.entries[file]; exists {
+		c.rowsNum -= len(entry.rows)
+		delete(c.entries, file)
+	}
+}
+
+func (c *externalFileCache) leastRecentlyUsed() string {
+	var (
+		file   string
+		usedAt time.Time
+	)
+	for f, entry := range c.entries {
+		if file == "" || entry.usedAt.Before(usedAt) {
+			file, usedAt = f, entry.usedAt
+		}
+	}
+	return file
+}
+
+// externalSourceFiles returns the local files of the source URIs like file:///path/to/data.csv or /path/to/*.csv.
+func externalSourceFiles(config *bigqueryv2.ExternalDataConfiguration) ([]string, error) {
+	var files []string
+	for _, uri := range config.SourceUris {
+		if strings.Contains(uri, "://") && !strings.HasPrefix(uri, fileURIPrefix) {
+			return nil, fmt.Errorf("the source uri %s is not supported: the external table reads only the local files", uri)
+		}
+		matches, err := filepath.Glob(strings.TrimPrefix(uri, fileURIPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid source uri %s: %w", uri, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no file matches the source uri %s", uri)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// loadExternalTables replaces the content of the external tables referenced by query with the data of the source files,
+// so the query reads the files as they are when it runs. The content is replaced only when the version of the data
+// is changed from the loaded one, that is, the source files or the table are modified or the query reads the other files.
+// The _FILE_NAME pseudo-column is added to the content only when query refers to it, and the returned query
+// excludes it from SELECT * of the tables because the content is an ordinary table whose columns are all expanded.
+func (s *Server) loadExternalTables(ctx context.Context, tx *connection.Tx, project *metadata.Project, query string) (string, error) {
+	loaded := map[string]struct{}{}
+	withFileName := contentdata.ReferencesColumn(query, fileNameColumn)
+	rewritten := query
+	for _, path := range contentdata.TableReferences(query) {
+		dataset, err := s.referencedDataset(ctx, project, path)
+		if err != nil {
+			return "", err
+		}
+		if dataset == nil {
+			continue
+		}
+		tableID := path[1]
+		if len(path) >= 3 && path[0] == dataset.ProjectID && path[1] == dataset.ID {
+			tableID = path[2]
+		}
+		table := dataset.Table(tableID)
+		if table == nil {
+			continue
+		}
+		content, err := table.Content()
+		if err != nil {
+			return "", err
+		}
+		if content.Type != string(ExternalTableType) || content.ExternalDataConfiguration == nil {
+			continue
+		}
+		if withFileName {
+			rewritten = contentdata.ExceptStarColumn(rewritten, path, fileNameColumn)
+		}
+		key := fmt.Sprintf("%s.%s.%s", dataset.ProjectID, dataset.ID, tableID)
+		if _, exists := loaded[key]; exists {
+			continue
+		}
+		loaded[key] = struct{}{}
+		var filters map[string]string
+		if opt := content.ExternalDataConfiguration.HivePartitioningOptions; opt != nil {
+			if opt.RequirePartitionFilter && !contentdata.FiltersColumns(query, opt.Fields) {
+				return "", errInvalidQuery(fmt.Sprintf(
+					"Cannot query over table '%s' without a filter over column(s) '%s' that can be used for partition elimination",
+					key, strings.Join(opt.Fields, ", "),
+				))
+			}
+			filters = contentdata.PartitionFilters(query, path, opt.Fields)
+		}
+		version, err := externalDataVersion(content, withFileName, filters)
+		if err != nil {
+			return "", errInvalidQuery(fmt.Sprintf("failed to read the external table %s: %s", key, err))
+		}
+		loadedVersion, err := s.contentRepo.ExternalDataVersion(ctx, tx, dataset.ProjectID, dataset.ID, tableID)
+		if err != nil {
+			return "", err
+		}
+		if loadedVersion == version {
+			continue
+		}
+		data, err := s.readExternalData(content, withFileName, filters)
+		if err != nil {
+			return "", errInvalidQuery(fmt.Sprintf("failed to read the external table %s: %s", key, err))
+		}
+		if withFileName {
+			withFileNameContent := *content
+			withFileNameContent.Schema = &bigqueryv2.TableSchema{
+				Fields: append(
+					append([]*bigqueryv2.TableFieldSchema{}, content.Schema.Fields...),
+					&bigqueryv2.TableFieldSchema{Name: fileNameColumn, Type: "STRING"},
+				),
+			}
+			content = &withFileNameContent
+		}
+		tableDef, err := types.NewTableWithSchema(content, data)
+		if err != nil {
+			return "", errInvalidQuery(fmt.Sprintf("failed to read the external table %s: %s", key, err))
+		}
+		if err := s.contentRepo.CreateOrReplaceTable(ctx, tx, dataset.ProjectID, dataset.ID, tableDef); err != nil {
+			return "", err
+		}
+		if err := s.contentRepo.AddTableData(ctx, tx, dataset.ProjectID, dataset.ID, tableDef); err != nil {
+			return "", err
+		}
+		if err := s.contentRepo.SetExternalDataVersion(ctx, tx, dataset.ProjectID, dataset.ID, tableID, version); err != nil {
+			return "", err
+		}
+	}
+	return rewritten, nil
+}
+
+// externalDataVersion returns the version of the data of the external table read with withFileName and filters.
+// It is the digest of the table metadata and the paths, the modification times and the sizes of the source files.
+func externalDataVersion(table *bigqueryv2.Table, withFileName bool, filters map[string]string) (string, error) {
+	files, err := externalSourceFiles(table.ExternalDataConfiguration)
+	if err != nil {
+		return "", err
+	}
+	states := make([]interface{}, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		states = append(states, []interface{}{file, info.ModTime().UnixNano(), info.Size()})
+	}
+	b, err := json.Marshal([]interface{}{table, withFileName, filters, states})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// readExternalData reads the rows of the source files of the external table.
+// The rows that don't match the schema are skipped as the bad records up to maxBadRecords.
+// If the table is hive partitioned, the partition values are added to the rows from the paths of the files,
+// and the files whose partition values don't match filters are not read.
+func (s *Server) readExternalData(table *bigqueryv2.Table, withFileName bool, filters map[string]string) (types.Data, error) {
+	config := table.ExternalDataConfiguration
+	files, err := externalSourceFiles(config)
+	if err != nil {
+		return nil, err
+	}
+	schema := table.Schema
+	var partitioning *hivePartitioning
+	if config.HivePartitioningOptions != nil {
+		partitioning, err = newHivePartitioning(config.HivePartitioningOptions, files)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range partitioning.keys {
+			// the type of the key can be specified by the schema of the table.
+			if field := schemaField(schema, key.Name); field != nil {
+				key.Type = field.Type
+			}
+		}
+		schema = partitioning.dataSchema(schema)
+	}
+	key, err := json.Marshal([]interface{}{config, schema})
+	if err != nil {
+		return nil, err
+	}
+	var (
+		data       types.Data
+		badRecords int64
+	)
+	for _, file := range files {
+		var partitionValues map[string]interface{}
+		if partitioning != nil {
+			partitionValues, err = partitioning.values(file)
+			if err != nil {
+				return nil, err
+			}
+			if !partitioning.matches(partitionValues, filters) {
+				continue
+			}
+		}
+		rows, bad, err := s.externalFiles.rows(file, string(key), func(b []byte) (types.Data, int64, error) {
+			return parseExternalFile(b, config, schema)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		badRecords += bad
+		if badRecords > config.MaxBadRecords {
+			return nil, fmt.Errorf("too many bad records: %d records don't match the schema but maxBadRecords is %d", badRecords, config.MaxBadRecords)
+		}
+		for _, row := range rows {
+			if withFileName || partitioning != nil {
+				copied := make(map[string]interface{}, len(row)+len(partitionValues)+1)
+				for k, v := range row {
+					copied[k] = v
+				}
+				for k, v := range partitionValues {
+					copied[k] = v
+				}
+				if withFileName {
+					copied[fileNameColumn] = file
+				}
+				row = copied
+			}
+			data = append(data, row)
+		}
+	}
+	return data, nil
+}
+
+func parseExternalFile(b []byte, config *bigqueryv2.ExternalDataConfiguration, schema *bigqueryv2.TableSchema) (types.Data, int64, error) {
+	switch config.SourceFormat {
+	case externalSourceFormatCSV:
+		return readExternalCSV(b, config, schema)
+	case externalSourceFormatParquet:
+		return readExternalParquet(b, schema)
+	}
+	return readExternalJSON(b, config, schema)
+}
+
+func newExternalCSVReader(b []byte, config *bigqueryv2.ExternalDataConfiguration) *csv.Reader {
+	reader := csv.NewReader(bytes.NewReader(b))
+	if opt := config.CsvOptions; opt != nil {
+		if opt.FieldDelimiter != "" {
+			reader.Comma = []rune(opt.FieldDelimiter)[0]
+		}
+		reader.FieldsPerRecord = -1
+	}
+	return reader
+}
+
+func readExternalCSV(b []byte, config *bigqueryv2.ExternalDataConfiguration, schema *bigqueryv2.TableSchema) (types.Data, int64, error) {
+	records, err := newExternalCSVReader(b, config).ReadAll()
+	if err != nil {
+		return nil, 0, err
+	}
+	skip := int(csvSkipLeadingRows(config))
+	if config.Autodetect && skip == 0 && csvHasHeader(records) {
+		skip = 1
+	}
+	if skip > len(records) {
+		skip = len(records)
+	}
+	data, badRecords := csvRecordsData(records[skip:], config, schema)
+	return data, badRecords, nil
+}
+
+// csvRecordsData converts the CSV records to the rows of schema whose fields are in the order of the CSV columns.
+// It returns the rows and the number of the bad records that don't match the schema.
+func csvRecordsData(records [][]string, config *bigqueryv2.ExternalDataConfiguration, schema *bigqueryv2.TableSchema) (types.Data, int64) {
+	var nullMarker string
+	if config.CsvOptions != nil {
+		nullMarker = config.CsvOptions.NullMarker
+	}
+	allowJagged := config.CsvOptions != nil && config.CsvOptions.AllowJaggedRows
+	var (
+		data       = make(types.Data, 0, len(records))
+		badRecords int64
+	)
+	for _, record := range records {
+		if len(record) != len(schema.Fields) && !(allowJagged && len(record) < len(schema.Fields)) {
+			badRecords++
+			continue
+		}
+		row, err := csvRecordRow(record, schema, nullMarker)
+		if err != nil {
+			badRecords++
+			continue
+		}
+		data = append(data, row)
+	}
+	return data, badRecords
+}
+
+func csvRecordRow(record []string, schema *bigqueryv2.TableSchema, nullMarker string) (map[string]interface{}, error) {
+	row := map[string]interface{}{}
+	for idx, field := range schema.Fields {
+		if idx >= len(record) || record[idx] == "" || record[idx] == nullMarker {
+			row[field.Name] = nil
+			continue
+		}
+		v, err := externalScalarValue(record[idx], field)
+		if err != nil {
+			return nil, err
+		}
+		row[field.Name] = v
+	}
+	return row, nil
+}
+
+func readExternalJSON(b []byte, config *bigqueryv2.ExternalDataConfiguration, schema *bigqueryv2.TableSchema) (types.Data, int64, error) {
+	var (
+		data       types.Data
+		badRecords int64
+	)
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		row := map[string]interface{}{}
+		if err := decoder.Decode(&row); err != nil {
+			badRecords++
+			continue
+		}
+		converted, err := externalRow(row, schema, config.IgnoreUnknownValues)
+		if err != nil {
+			badRecords++
+			continue
+		}
+		data = append(data, converted)
+	}
+	return data, badRecords, nil
+}
+
+func readExternalParquet(b []byte, schema *bigqueryv2.TableSchema) (types.Data, int64, error) {
+	reader := parquet.NewReader(bytes.NewReader(b))
+	defer reader.Close()
+	var (
+		data       types.Data
+		badRecords int64
+	)
+	for i := 0; i < int(reader.NumRows()); i++ {
+		var rowData interface{}
+		if err := reader.Read(&rowData); err != nil {
+			return nil, 0, err
+		}
+		row, ok := rowData.(map[string]interface{})
+		if !ok {
+			badRecords++
+			continue
+		}
+		converted, err := externalRow(row, schema, true)
+		if err != nil {
+			badRecords++
+			continue
+		}
+		data = append(data, converted)
+	}
+	return data, badRecords, nil
+}
+
+// externalRow converts the values of the row to the types of the columns.
+// The row that has the unknown field is the bad record unless ignoreUnknownValues is enabled.
+func externalRow(row map[string]interface{}, schema *bigqueryv2.TableSchema, ignoreUnknownValues bool) (map[string]interface{}, error) {
+	if !ignoreUnknownValues {
+		for key := range row {
+			if schemaField(schema, key) == nil {
+				return nil, fmt.Errorf("unknown field %s", key)
+			}
+		}
+	}
+	converted := make(map[string]interface{}, len(schema.Fields))
+	for _, field := range schema.Fields {
+		v, err := externalValue(rowValue(row, field.Name), field)
+		if err != nil {
+			return nil, err
+		}
+		converted[field.Name] = v
+	}
+	return converted, nil
+}
+
+// rowValue returns the value of the column of the row. The names of the columns are case-insensitive,
+// so the value of the key that differs from the name only in case is returned if the row doesn't have the name.
+func rowValue(row map[string]interface{}, name string) interface{} {
+	if v, exists := row[name]; exists {
+		return v
+	}
+	for key, v := range row {
+		if strings.EqualFold(key, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// externalValue converts the decoded JSON value to the value of the column.
+func externalValue(v interface{}, field *bigqueryv2.TableFieldSchema) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if field.Mode == "REPEATED" {
+		values, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid value %v for the repeated field %s", v, field.Name)
+		}
+		elemField := &bigqueryv2.TableFieldSchema{Name: field.Name, Type: field.Type, Fields: field.Fields}
+		converted := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			elem, err := externalValue(value, elemField)
+			if err != nil {
+				return nil, err
+			}
+			converted = append(converted, elem)
+		}
+		return converted, nil
+	}
+	if field.Type == "RECORD" || field.Type == "STRUCT" {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid value %v for the record field %s", v, field.Name)
+		}
+		for _, f := range field.Fields {
+			value, err := externalValue(m[f.Name], f)
+			if err != nil {
+				return nil, err
+			}
+			m[f.Name] = value
+		}
+		return m, nil
+	}
+	switch value := v.(type) {
+	case json.Number:
+		return externalScalarValue(value.String(), field)
+	case string:
+		return externalScalarValue(value, field)
+	case []byte:
+		return externalScalarValue(string(value), field)
+	case int32:
+		return int64(value), nil
+	case float32:
+		return float64(value), nil
+	}
+	return v, nil
+}
+
+func externalScalarValue(v string, field *bigqueryv2.TableFieldSchema) (interface{}, error) {
+	switch field.Type {
+	case "INTEGER", "INT64":
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INT64 value %q for the field %s", v, field.Name)
+		}
+		return i, nil
+	case "FLOAT", "FLOAT64":
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FLOAT64 value %q for the field %s", v, field.Name)
+		}
+		return f, nil
+	case "BOOLEAN", "BOOL":
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BOOL value %q for the field %s", v, field.Name)
+		}
+		return b, nil
+	}
+	return v, nil
+}
+
+func csvSkipLeadingRows(config *bigqueryv2.ExternalDataConfiguration) int64 {
+	if config.CsvOptions == nil {
+		return 0
+	}
+	return config.CsvOptions.SkipLeadingRows
+}
+
+// detectExternalSchema detects the schema of the external table from its first source file.
+// The CSV columns are INTEGER, FLOAT, BOOLEAN or STRING, and the JSON fields can be RECORD or REPEATED too.
+func detectExternalSchema(config *bigqueryv2.ExternalDataConfiguration) (*bigqueryv2.TableSchema, error) {
+	files, err := externalSourceFiles(config)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		return nil, err
+	}
+	switch config.SourceFormat {
+	case externalSourceFormatCSV:
+		return detectCSVSchema(b, config)
+	case externalSourceFormatParquet:
+		return detectParquetSchema(b)
+	}
+	return detectJSONSchema(b)
+}
+
+// detectLoadSchema detects the schema of the table that the load job with autodetect creates from the loaded file
+// in the same way as the external tables.
+func detectLoadSchema(b []byte, load *bigqueryv2.JobConfigurationLoad) (*bigqueryv2.TableSchema, error) {
+	switch load.SourceFormat {
+	case externalSourceFormatCSV:
+		return detectCSVSchema(b, loadSourceConfig(load))
+	case externalSourceFormatJSON:
+		return detectJSONSchema(b)
+	case externalSourceFormatParquet:
+		return detectParquetSchema(b)
+	}
+	return nil, fmt.Errorf("autodetect of %s is not supported", load.SourceFormat)
+}
+
+// loadSourceConfig returns the external data configuration of the source options of the load job,
+// so the loaded data is parsed in the same way as the external tables.
+// The unknown values of the loaded rows are ignored.
+func loadSourceConfig(load *bigqueryv2.JobConfigurationLoad) *bigqueryv2.ExternalDataConfiguration {
+	return &bigqueryv2.ExternalDataConfiguration{
+		SourceFormat:        load.SourceFormat,
+		Autodetect:          load.Autodetect,
+		IgnoreUnknownValues: true,
+		MaxBadRecords:       load.MaxBadRecords,
+		CsvOptions: &bigqueryv2.CsvOptions{
+			FieldDelimiter:  load.FieldDelimiter,
+			SkipLeadingRows: load.SkipLeadingRows,
+			AllowJaggedRows: load.AllowJaggedRows,
+			NullMarker:      load.NullMarker,
+		},
+	}
+}
+
+func detectCSVSchema(b []byte, config *bigqueryv2.ExternalDataConfiguration) (*bigqueryv2.TableSchema, error) {
+	records, err := newExternalCSVReader(b, config).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var header []string
+	switch skip := int(csvSkipLeadingRows(config)); {
+	case skip > 0 && skip <= len(records):
+		header = records[skip-1]
+		records = records[skip:]
+	case skip == 0 && csvHasHeader(records):
+		header = records[0]
+		records = records[1:]
+	}
+	if len(header) == 0 && len(records) == 0 {
+		return nil, fmt.Errorf("the source file is empty")
+	}
+	columns := len(header)
+	for _, record := range records {
+		if len(record) > columns {
+			columns = len(record)
+		}
+	}
+	fields := make([]*bigqueryv2.TableFieldSchema, 0, columns)
+	for idx := 0; idx < columns; idx++ {
+		typ := detectCSVColumnType(records, idx)
+		name := fmt.Sprintf("%s_field_%d", csvFieldNamePrefix(typ), idx)
+		if idx < len(header) && header[idx] != "" {
+			name = invalidColumnNameChars.ReplaceAllString(header[idx], "_")
+		}
+		fields = append(fields, &bigqueryv2.TableFieldSchema{Name: name, Type: typ, Mode: "NULLABLE"})
+	}
+	return &bigqueryv2.TableSchema{Fields: fields}, nil
+}
+
+func csvFieldNamePrefix(typ string) string {
+	switch typ {
+	case "INTEGER":
+		return "int64"
+	case "FLOAT":
+		return "double"
+	case "BOOLEAN":
+		return "bool"
+	}
+	return "string"
+}
+
+// csvHasHeader reports whether the first record is the header.
+// As BigQuery does, the first record is the header if one of its values doesn't match the type detected from the other records.
+func csvHasHeader(records [][]string) bool {
+	if len(records) < 2 {
+		return false
+	}
+	for idx, v := range records[0] {
+		typ := detectCSVColumnType(records[1:], idx)
+		if typ != "STRING" && v != "" && detectScalarType(v) != typ && !(typ == "FLOAT" && detectScalarType(v) == "INTEGER") {
+			return true
+		}
+	}
+	return false
+}
+
+func detectCSVColumnType(records [][]string, idx int) string {
+	typ := ""
+	for _, record := range records {
+		if idx >= len(record) || record[idx] == "" {
+			continue
+		}
+		typ = mergeDetectedType(typ, detectScalarType(record[idx]))
+	}
+	if typ == "" {
+		return "STRING"
+	}
+	return typ
+}
+
+func detectScalarType(v string) string {
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return "INTEGER"
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return "FLOAT"
+	}
+	if strings.EqualFold(v, "true") || strings.EqualFold(v, "false") {
+		return "BOOLEAN"
+	}
+	return "STRING"
+}
+
+func mergeDetectedType(a, b string) string {
+	switch {
+	case a == "" || a == b:
+		return b
+	case b == "":
+		return a
+	case (a == "INTEGER" && b == "FLOAT") || (a == "FLOAT" && b == "INTEGER"):
+		return "FLOAT"
+	}
+	return "STRING"
+}
+
+// detectJSONSchema detects the schema from all the rows of the newline-delimited JSON like BigQuery:
+// the objects are RECORD, the arrays are REPEATED and the types of the rows are widened ( INTEGER and FLOAT are FLOAT,
+// the other mixed scalar types are STRING ). The fields are NULLABLE even if every row has them, as BigQuery does.
+// The field whose values are only null or the empty arrays is STRING because its type is unknown.
+func detectJSONSchema(b []byte) (*bigqueryv2.TableSchema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var fields []*bigqueryv2.TableFieldSchema
+	for line := 1; ; line++ {
+		row := map[string]interface{}{}
+		if err := decoder.Decode(&row); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		merged, err := mergeJSONFields(fields, row)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", line, err)
+		}
+		fields = merged
+	}
+	fields = resolveJSONFields(fields)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("the source file has no fields")
+	}
+	return &bigqueryv2.TableSchema{Fields: fields}, nil
+}
+
+// mergeJSONFields adds the fields of the JSON object to fields. The new fields are sorted by the name
+// because the order of the keys in the object is lost.
+func mergeJSONFields(fields []*bigqueryv2.TableFieldSchema, object map[string]interface{}) ([]*bigqueryv2.TableFieldSchema, error) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		detected, err := detectJSONField(key, object[key])
+		if err != nil {
+			return nil, err
+		}
+		fields, err = mergeJSONField(fields, detected)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// mergeJSONField merges detected into the field of the same name in fields, or adds it if fields doesn't have the name.
+// The unknown type of null or the empty array is the empty Type, which is replaced with the type of the other rows.
+func mergeJSONField(fields []*bigqueryv2.TableFieldSchema, detected *bigqueryv2.TableFieldSchema) ([]*bigqueryv2.TableFieldSchema, error) {
+	var field *bigqueryv2.TableFieldSchema
+	for _, f := range fields {
+		if f.Name == detected.Name {
+			field = f
+			break
+		}
+	}
+	switch {
+	case field == nil:
+		return append(fields, detected), nil
+	case detected.Type == "" && detected.Mode != "REPEATED":
+		// null matches any field.
+		return fields, nil
+	case field.Type == "" && field.Mode != "REPEATED":
+		*field = *detected
+		return fields, nil
+	case field.Mode != detected.Mode:
+		return nil, fmt.Errorf("the field %s has both the array and the non-array values", field.Name)
+	case detected.Type == "":
+		return fields, nil
+	case field.Type == "":
+		field.Type = detected.Type
+		field.Fields = detected.Fields
+		return fields, nil
+	case field.Type == "RECORD" && detected.Type == "RECORD":
+		for _, f := range detected.Fields {
+			merged, err := mergeJSONField(field.Fields, f)
+			if err != nil {
+				return nil, err
+			}
+			field.Fields = merged
+		}
+		return fields, nil
+	case field.Type == "RECORD" || detected.Type == "RECORD":
+		return nil, fmt.Errorf("the field %s has both the object and the non-object values", field.Name)
+	}
+	field.Type = mergeDetectedType(field.Type, detected.Type)
+	return fields, nil
+}
+
+// detectJSONField returns the field of the JSON value. The type of null and the empty array is the empty Type
+// until the other rows have the value.
+func detectJSONField(name string, v interface{}) (*bigqueryv2.TableFieldSchema, error) {
+	switch value := v.(type) {
+	case nil:
+		return &bigqueryv2.TableFieldSchema{Name: name, Mode: "NULLABLE"}, nil
+	case bool:
+		return &bigqueryv2.TableFieldSchema{Name: name, Type: "BOOLEAN", Mode: "NULLABLE"}, nil
+	case json.Number:
+		return &bigqueryv2.TableFieldSchema{Name: name, Type: detectScalarType(value.String()), Mode: "NULLABLE"}, nil
+	case map[string]interface{}:
+		fields, err := mergeJSONFields(nil, value)
+		if err != nil {
+			return nil, err
+		}
+		return &bigqueryv2.TableFieldSchema{Name: name, Type: "RECORD", Mode: "NULLABLE", Fields: fields}, nil
+	case []interface{}:
+		var elem []*bigqueryv2.TableFieldSchema
+		for _, e := range value {
+			if _, ok := e.([]interface{}); ok {
+				return nil, fmt.Errorf("the field %s has the nested array, which is not supported", name)
+			}
+			detected, err := detectJSONField(name, e)
+			if err != nil {
+				return nil, err
+			}
+			elem, err = mergeJSONField(elem, detected)
+			if err != nil {
+				return nil, err
+			}
+		}
+		field := &bigqueryv2.TableFieldSchema{Name: name}
+		if len(elem) != 0 {
+			field = elem[0]
+		}
+		field.Mode = "REPEATED"
+		return field, nil
+	}
+	return &bigqueryv2.TableFieldSchema{Name: name, Type: "STRING", Mode: "NULLABLE"}, nil
+}
+
+// resolveJSONFields makes the fields of the unknown type STRING, and removes the RECORD fields without the fields
+// because only the empty objects are found.
+func resolveJSONFields(fields []*bigqueryv2.TableFieldSchema) []*bigqueryv2.TableFieldSchema {
+	resolved := make([]*bigqueryv2.TableFieldSchema, 0, len(fields))
+	for _, field := range fields {
+		switch field.Type {
+		case "":
+			field.Type = "STRING"
+		case "RECORD":
+			field.Fields = resolveJSONFields(field.Fields)
+			if len(field.Fields) == 0 {
+				continue
+			}
+		}
+		resolved = append(resolved, field)
+	}
+	return resolved
+}
+
+func detectParquetSchema(b []byte) (*bigqueryv2.TableSchema, error) {
+	reader := parquet.NewReader(bytes.NewReader(b))
+	defer reader.Close()
+	fields := make([]*bigqueryv2.TableFieldSchema, 0, len(reader.Schema().Fields()))
+	for _, field := range reader.Schema().Fields() {
+		fields = append(fields, parquetField(field))
+	}
+	return &bigqueryv2.TableSchema{Fields: fields}, nil
+}
+
+func parquetField(field parquet.Field) *bigqueryv2.TableFieldSchema {
+	schema := &bigqueryv2.TableFieldSchema{Name: field.Name(), Mode: "NULLABLE"}
+	switch {
+	case field.Repeated():
+		schema.Mode = "REPEATED"
+	case field.Required():
+		schema.Mode = "REQUIRED"
+	}
+	if !field.Leaf() {
+		schema.Type = "RECORD"
+		for _, f := range field.Fields() {
+			schema.Fields = append(schema.Fields, parquetField(f))
+		}
+		return schema
+	}
+	switch field.Type().Kind() {
+	case parquet.Boolean:
+		schema.Type = "BOOLEAN"
+	case parquet.Int32, parquet.Int64:
+		schema.Type = "INTEGER"
+	case parquet.Float, parquet.Double:
+		schema.Type = "FLOAT"
+	default:
+		schema.Type = "STRING"
+	}
+	return schema
+}
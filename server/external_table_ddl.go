@@ -0,0 +1,355 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+)
+
+// execExternalTableStatement creates the external table by CREATE EXTERNAL TABLE and the BigLake table by CREATE TABLE
+// with WITH CONNECTION clause, or drops the external table by DROP EXTERNAL TABLE.
+// The connection of WITH CONNECTION must exist, and it is stored as the connectionId of the table.
+// The external table on the cloud storage like gs:// only has the metadata, so the query reading it fails,
+// and the object table fails with the notImplemented error.
+// The BigLake table is stored as the native table because the emulator has no cloud storage to write the data.
+func (s *Server) execExternalTableStatement(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, stmt *contentdata.ExternalTableStatement) error {
+	tableProject, dataset, table, err := s.findTableByPath(ctx, tx, project, datasetID, stmt.TablePath)
+	if err != nil {
+		return err
+	}
+	tableID := stmt.TablePath[len(stmt.TablePath)-1]
+	name := fmt.Sprintf("%s:%s.%s", tableProject.ID, dataset.ID, tableID)
+	var existing *bigqueryv2.Table
+	if table != nil {
+		existing, err = table.Content()
+		if err != nil {
+			return err
+		}
+	}
+	if stmt.Drop {
+		if table == nil {
+			if stmt.IfExists {
+				return nil
+			}
+			return errNotFound(fmt.Sprintf("Not found: Table %s", name))
+		}
+		if existing.Type != string(ExternalTableType) {
+			return fmt.Errorf("%s is not an external table", name)
+		}
+		if err := table.Delete(ctx, tx.Tx()); err != nil {
+			return err
+		}
+		return s.contentRepo.DeleteTables(ctx, tx, tableProject.ID, dataset.ID, []string{tableID})
+	}
+	exists := table != nil
+	if !exists {
+		var err error
+		exists, err = s.tableExists(ctx, tx, project.ID, datasetID, stmt.TablePath)
+		if err != nil {
+			return err
+		}
+	}
+	if exists {
+		if existing != nil && ddlObjectType(existing) != "TABLE" {
+			return errDuplicate(fmt.Sprintf("Already Exists: %s is a %s, not a table", name, strings.ToLower(ddlObjectType(existing))))
+		}
+		if stmt.IfNotExists {
+			return nil
+		}
+		if !stmt.OrReplace {
+			return errDuplicate(fmt.Sprintf("Already Exists: Table %s", name))
+		}
+	}
+	var connectionID string
+	if stmt.Connection != nil {
+		connectionID, err = s.tableConnectionID(ctx, tx, project.ID, stmt.Connection)
+		if err != nil {
+			return err
+		}
+	}
+	newTable, err := connectionTableFromStatement(tableProject.ID, dataset.ID, tableID, connectionID, stmt)
+	if err != nil {
+		return err
+	}
+	if stmt.External {
+		if err := prepareExternalTableDDL(newTable, stmt); err != nil {
+			return err
+		}
+	}
+	if exists {
+		if table != nil {
+			if err := table.Delete(ctx, tx.Tx()); err != nil {
+				return err
+			}
+		}
+		if err := s.contentRepo.DeleteTables(ctx, tx, tableProject.ID, dataset.ID, []string{tableID}); err != nil {
+			return err
+		}
+	}
+	if _, serverErr := createTableMetadata(ctx, tx, s, tableProject, dataset, newTable); serverErr != nil {
+		return serverErr
+	}
+	return s.contentRepo.CreateTable(ctx, tx, newTable)
+}
+
+// tableConnectionID returns the connection id like project.location.connection_id of WITH CONNECTION clause,
+// or the notFound error if the connection doesn't exist. The connection without the project is in projectID.
+func (s *Server) tableConnectionID(ctx context.Context, tx *connection.Tx, projectID string, ref *contentdata.ConnectionReference) (string, error) {
+	if ref.ProjectID != "" {
+		projectID = ref.ProjectID
+	}
+	name := fmt.Sprintf("%s.%s.%s", projectID, ref.Location, ref.ConnectionID)
+	conn, err := s.metaRepo.FindConnection(ctx, tx.Tx(), projectID, ref.Location, ref.ConnectionID)
+	if err != nil {
+		return "", err
+	}
+	if conn == nil {
+		return "", errNotFound(fmt.Sprintf("Not found: Connection %s", name))
+	}
+	return name, nil
+}
+
+// connectionTableFromStatement creates the table resource with the columns and the options of the statement.
+// The options that only affect reading the data from the cloud, like metadata_cache_mode, are accepted and ignored.
+func connectionTableFromStatement(projectID, datasetID, tableID, connectionID string, stmt *contentdata.ExternalTableStatement) (*bigqueryv2.Table, error) {
+	table := &bigqueryv2.Table{
+		TableReference: &bigqueryv2.TableReference{
+			ProjectId: projectID,
+			DatasetId: datasetID,
+			TableId:   tableID,
+		},
+	}
+	if len(stmt.Columns) != 0 {
+		fields, err := tableColumnFields(stmt.Columns)
+		if err != nil {
+			return nil, err
+		}
+		table.Schema = &bigqueryv2.TableSchema{Fields: fields}
+	}
+	if len(stmt.ClusterBy) != 0 {
+		table.Clustering = &bigqueryv2.Clustering{Fields: stmt.ClusterBy}
+	}
+	var (
+		config  = &bigqueryv2.ExternalDataConfiguration{ConnectionId: connectionID}
+		biglake = &bigqueryv2.BigLakeConfiguration{ConnectionId: connectionID}
+	)
+	for _, opt := range stmt.Options {
+		if opt.Value == nil {
+			continue
+		}
+		var err error
+		switch opt.Name {
+		case "description", "friendly_name", "labels", "expiration_timestamp", "kms_key_name":
+			err = setTableOption(table, opt)
+		case "format":
+			config.SourceFormat, err = stringOption(opt)
+			config.SourceFormat = strings.ToUpper(config.SourceFormat)
+			if config.SourceFormat == "JSON" {
+				config.SourceFormat = externalSourceFormatJSON
+			}
+		case "uris":
+			config.SourceUris, err = stringArrayOption(opt)
+		case "object_metadata":
+			config.ObjectMetadata, err = stringOption(opt)
+		case "compression":
+			config.Compression, err = stringOption(opt)
+		case "metadata_cache_mode":
+			config.MetadataCacheMode, err = stringOption(opt)
+		case "ignore_unknown_values":
+			config.IgnoreUnknownValues, err = boolOption(opt)
+		case "max_bad_records":
+			config.MaxBadRecords, err = intOption(opt)
+		case "skip_leading_rows", "field_delimiter", "null_marker", "quote", "encoding", "allow_jagged_rows", "allow_quoted_newlines":
+			if config.CsvOptions == nil {
+				config.CsvOptions = &bigqueryv2.CsvOptions{}
+			}
+			err = setCSVOption(config.CsvOptions, opt)
+		case "hive_partition_uri_prefix", "require_hive_partition_filter":
+			if config.HivePartitioningOptions == nil {
+				config.HivePartitioningOptions = &bigqueryv2.HivePartitioningOptions{}
+			}
+			if opt.Name == "hive_partition_uri_prefix" {
+				config.HivePartitioningOptions.SourceUriPrefix, err = stringOption(opt)
+			} else {
+				config.HivePartitioningOptions.RequirePartitionFilter, err = boolOption(opt)
+			}
+		case "file_format":
+			biglake.FileFormat, err = stringOption(opt)
+		case "table_format":
+			biglake.TableFormat, err = stringOption(opt)
+		case "storage_uri":
+			biglake.StorageUri, err = stringOption(opt)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !stmt.External {
+		if table.Schema == nil {
+			return nil, fmt.Errorf("CREATE TABLE WITH CONNECTION requires the column list")
+		}
+		table.BiglakeConfiguration = biglake
+		return table, nil
+	}
+	if stmt.HivePartitioned {
+		if config.HivePartitioningOptions == nil || config.HivePartitioningOptions.SourceUriPrefix == "" {
+			return nil, fmt.Errorf("hive_partition_uri_prefix option must be specified with WITH PARTITION COLUMNS")
+		}
+		config.HivePartitioningOptions.Mode = hivePartitioningModeAuto
+	} else if config.HivePartitioningOptions != nil {
+		return nil, fmt.Errorf("hive_partition_uri_prefix option requires WITH PARTITION COLUMNS")
+	}
+	table.ExternalDataConfiguration = config
+	return table, nil
+}
+
+// prepareExternalTableDDL validates the external table of CREATE EXTERNAL TABLE and sets its schema
+// like tables.insert does. The table on the cloud storage has only the metadata, so its columns must be listed.
+// The listed partition columns are the columns of the table with the listed types.
+func prepareExternalTableDDL(table *bigqueryv2.Table, stmt *contentdata.ExternalTableStatement) error {
+	config := table.ExternalDataConfiguration
+	if config.SourceFormat == "" && config.ObjectMetadata == "" {
+		return fmt.Errorf("format option must be specified in CREATE EXTERNAL TABLE")
+	}
+	if len(config.SourceUris) == 0 {
+		return fmt.Errorf("uris option must be specified in CREATE EXTERNAL TABLE")
+	}
+	partitionFields, err := tableColumnFields(stmt.PartitionColumns)
+	if err != nil {
+		return err
+	}
+	if !isLocalSourceURIs(config.SourceUris) {
+		if serverErr := validateExternalSource(config); serverErr != nil {
+			return serverErr
+		}
+		if table.Schema == nil {
+			return fmt.Errorf("the columns of the external table on %s must be listed because the emulator can't read the files to detect the schema", config.SourceUris[0])
+		}
+		if config.HivePartitioningOptions != nil {
+			for _, field := range partitionFields {
+				if schemaField(table.Schema, field.Name) == nil {
+					table.Schema.Fields = append(table.Schema.Fields, field)
+				}
+				config.HivePartitioningOptions.Fields = append(config.HivePartitioningOptions.Fields, field.Name)
+			}
+		}
+		return nil
+	}
+	config.Autodetect = table.Schema == nil
+	if serverErr := prepareExternalTable(table); serverErr != nil {
+		return serverErr
+	}
+	for _, field := range partitionFields {
+		key := schemaField(table.Schema, field.Name)
+		if key == nil {
+			return fmt.Errorf("partition column %s is not found in the paths of the files", field.Name)
+		}
+		key.Type = field.Type
+	}
+	return nil
+}
+
+// setCSVOption sets the CSV option of CREATE EXTERNAL TABLE.
+func setCSVOption(csvOptions *bigqueryv2.CsvOptions, opt *contentdata.Option) error {
+	var err error
+	switch opt.Name {
+	case "skip_leading_rows":
+		csvOptions.SkipLeadingRows, err = intOption(opt)
+	case "field_delimiter":
+		csvOptions.FieldDelimiter, err = stringOption(opt)
+	case "null_marker":
+		csvOptions.NullMarker, err = stringOption(opt)
+	case "quote":
+		var quote string
+		quote, err = stringOption(opt)
+		csvOptions.Quote = &quote
+	case "encoding":
+		csvOptions.Encoding, err = stringOption(opt)
+	case "allow_jagged_rows":
+		csvOptions.AllowJaggedRows, err = boolOption(opt)
+	case "allow_quoted_newlines":
+		csvOptions.AllowQuotedNewlines, err = boolOption(opt)
+	}
+	return err
+}
+
+// isLocalSourceURIs reports whether the source URIs are the local files that the emulator can read.
+func isLocalSourceURIs(uris []string) bool {
+	for _, uri := range uris {
+		if strings.Contains(uri, "://") && !strings.HasPrefix(uri, fileURIPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// tableColumnFields converts the columns of the column list into the fields of the table schema.
+func tableColumnFields(columns []*contentdata.TableColumn) ([]*bigqueryv2.TableFieldSchema, error) {
+	fields := make([]*bigqueryv2.TableFieldSchema, 0, len(columns))
+	for _, column := range columns {
+		dataType, err := contentdata.StandardSQLDataType(column.Type)
+		if err != nil {
+			return nil, fmt.Errorf("invalid type of column %s: %w", column.Name, err)
+		}
+		field := standardSQLTypeField(column.Name, dataType)
+		if column.NotNull {
+			if field.Mode == "REPEATED" {
+				return nil, fmt.Errorf("NOT NULL cannot be applied to ARRAY column %s", column.Name)
+			}
+			field.Mode = "REQUIRED"
+		}
+		for _, opt := range column.Options {
+			if opt.Name != "description" || opt.Value == nil {
+				continue
+			}
+			description, err := stringOption(opt)
+			if err != nil {
+				return nil, err
+			}
+			field.Description = description
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// standardSQLTypeField returns the field of the table schema in the standard SQL data type.
+func standardSQLTypeField(name string, dataType *bigqueryv2.StandardSqlDataType) *bigqueryv2.TableFieldSchema {
+	switch dataType.TypeKind {
+	case "ARRAY":
+		field := standardSQLTypeField(name, dataType.ArrayElementType)
+		field.Mode = "REPEATED"
+		return field
+	case "STRUCT":
+		field := &bigqueryv2.TableFieldSchema{Name: name, Type: "RECORD", Mode: "NULLABLE"}
+		if dataType.StructType != nil {
+			for _, f := range dataType.StructType.Fields {
+				field.Fields = append(field.Fields, standardSQLTypeField(f.Name, f.Type))
+			}
+		}
+		return field
+	case "RANGE":
+		return &bigqueryv2.TableFieldSchema{
+			Name:             name,
+			Type:             "RANGE",
+			Mode:             "NULLABLE",
+			RangeElementType: &bigqueryv2.TableFieldSchemaRangeElementType{Type: dataType.RangeElementType.TypeKind},
+		}
+	}
+	typ := dataType.TypeKind
+	switch typ {
+	case "INT64":
+		typ = "INTEGER"
+	case "FLOAT64":
+		typ = "FLOAT"
+	case "BOOL":
+		typ = "BOOLEAN"
+	}
+	return &bigqueryv2.TableFieldSchema{Name: name, Type: typ, Mode: "NULLABLE"}
+}
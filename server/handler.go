@@ -22,12 +22,14 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/goccy/go-json"
 	"github.com/goccy/go-zetasqlite"
+	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 	bigqueryv2 "google.golang.org/api/bigquery/v2"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 
 	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
 	"github.com/goccy/bigquery-emulator/internal/logger"
 	"github.com/goccy/bigquery-emulator/internal/metadata"
 	internaltypes "github.com/goccy/bigquery-emulator/internal/types"
@@ -376,80 +378,128 @@ func (h *uploadContentHandler) existsColumnNameInCSVHeader(col string, header []
 	return false
 }
 
-func (h *uploadContentHandler) normalizeColumnNameForJSONData(columnMap map[string]*types.Column, data map[string]interface{}) {
-	for k, v := range data {
-		if _, exists := columnMap[k]; exists {
-			continue
-		}
-		lowerKey := strings.ToLower(k)
-		var (
-			foundCount int
-			columnName string
-		)
-		for colName := range columnMap {
-			if lowerKey == strings.ToLower(colName) {
-				foundCount++
-				columnName = colName
-			}
-		}
-		if foundCount == 1 {
-			delete(data, k)
-			data[columnName] = v
-		}
-	}
-}
-
 func (h *uploadContentHandler) Handle(ctx context.Context, r *uploadContentRequest) error {
 	load := r.job.Content().Configuration.Load
 	tableRef := load.DestinationTable
+	tableID, decorator := splitPartitionDecorator(tableRef.TableId)
+	if err := validateSchemaUpdateOptions(load.SchemaUpdateOptions, load.WriteDisposition, decorator); err != nil {
+		return err
+	}
 	dataset := r.project.Dataset(tableRef.DatasetId)
-	table := dataset.Table(tableRef.TableId)
-	if table == nil {
+	table := dataset.Table(tableID)
+	created := table == nil
+	// csvHeader reports whether the first record of CSV is the header.
+	// Without autodetect, the first record is always the header.
+	csvHeader := true
+	if created {
 		if load.CreateDisposition == "CREATE_NEVER" {
-			return fmt.Errorf("`%s` is not found", tableRef.TableId)
+			return fmt.Errorf("`%s` is not found", tableID)
+		}
+		schema := load.Schema
+		if load.Autodetect && (schema == nil || len(schema.Fields) == 0) {
+			// autodetect is used only to create the table, the existing table keeps its schema like BigQuery.
+			b, err := io.ReadAll(r.reader)
+			if err != nil {
+				return err
+			}
+			schema, err = detectLoadSchema(b, load)
+			if err != nil {
+				return fmt.Errorf("failed to detect the schema: %w", err)
+			}
+			if load.SourceFormat == externalSourceFormatCSV && load.SkipLeadingRows == 0 {
+				records, err := newExternalCSVReader(b, loadSourceConfig(load)).ReadAll()
+				if err != nil {
+					return fmt.Errorf("failed to read csv: %w", err)
+				}
+				csvHeader = csvHasHeader(records)
+			}
+			r.reader = bytes.NewReader(b)
 		}
 		if _, err := (&tablesInsertHandler{}).Handle(ctx, &tablesInsertRequest{
 			server:  r.server,
 			project: r.project,
 			dataset: dataset,
 			table: &bigqueryv2.Table{
-				Schema:         load.Schema,
-				TableReference: tableRef,
+				Schema: schema,
+				TableReference: &bigqueryv2.TableReference{
+					ProjectId: tableRef.ProjectId,
+					DatasetId: tableRef.DatasetId,
+					TableId:   tableID,
+				},
 			},
 		}); err != nil {
 			return err
 		}
-		table = dataset.Table(tableRef.TableId)
+		table = dataset.Table(tableID)
+	}
+
+	conn, err := r.server.connMgr.Connection(ctx, tableRef.ProjectId, tableRef.DatasetId)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
 	}
+	defer tx.RollbackIfNotCommitted()
 
 	tableContent, err := table.Content()
 	if err != nil {
 		return err
 	}
+	if !created && len(load.SchemaUpdateOptions) != 0 && load.Schema != nil {
+		// the schema is updated in the transaction writing the rows, so the failed load doesn't change it.
+		tableContent, err = r.server.updateDestinationSchema(ctx, tx, r.project, dataset, table, tableContent, load.Schema, load.SchemaUpdateOptions)
+		if err != nil {
+			return err
+		}
+	}
+	switch load.WriteDisposition {
+	case writeTruncateDisposition:
+		if err := r.server.truncateDestination(ctx, tx, r.project, dataset, table, tableContent, decorator); err != nil {
+			return err
+		}
+	case writeEmptyDisposition:
+		hasData, err := r.server.contentRepo.HasTableData(ctx, tx, r.project.ID, dataset.ID, table.ID)
+		if err != nil {
+			return err
+		}
+		if hasData {
+			return errDuplicate(fmt.Sprintf("Already Exists: Table %s:%s.%s", r.project.ID, dataset.ID, table.ID))
+		}
+	}
 	columnToType := map[string]types.Type{}
 	for _, field := range tableContent.Schema.Fields {
 		columnToType[field.Name] = types.Type(field.Type)
 	}
 
-	sourceFormat := load.SourceFormat
+	// the loaded data is parsed in the same way as the external tables.
+	config := loadSourceConfig(load)
 	columns := []*types.Column{}
-	data := types.Data{}
-	switch sourceFormat {
-	case "CSV":
-		records, err := csv.NewReader(r.reader).ReadAll()
+	var (
+		data       types.Data
+		badRecords int64
+	)
+	switch load.SourceFormat {
+	case externalSourceFormatCSV:
+		b, err := io.ReadAll(r.reader)
+		if err != nil {
+			return err
+		}
+		records, err := newExternalCSVReader(b, config).ReadAll()
 		if err != nil {
 			return fmt.Errorf("failed to read csv: %w", err)
 		}
 		if len(records) == 0 {
 			return fmt.Errorf("failed to find csv header")
 		}
-		if len(records) == 1 {
-			return nil
+		if len(records) == 1 && csvHeader {
+			break
 		}
 		header := records[0]
-		var ignoreHeader bool
+		ignoreHeader := !csvHeader
 		for _, col := range header {
-			if _, exists := columnToType[col]; !exists {
+			if _, exists := columnToType[col]; !exists || ignoreHeader {
 				ignoreHeader = true
 				break
 			}
@@ -467,83 +517,41 @@ func (h *uploadContentHandler) Handle(ctx context.Context, r *uploadContentReque
 				})
 			}
 		}
-		for _, record := range records[1:] {
-			rowData := map[string]interface{}{}
-			if len(record) != len(columns) {
-				return fmt.Errorf("invalid column number: found broken row data: %v", record)
-			}
-			for i := 0; i < len(record); i++ {
-				colData := record[i]
-				if colData == "" {
-					rowData[columns[i].Name] = nil
-				} else {
-					rowData[columns[i].Name] = colData
-				}
-			}
-			data = append(data, rowData)
+		if csvHeader {
+			records = records[1:]
 		}
-	case "PARQUET":
+		// the fields of the schema are in the order of the CSV columns.
+		schema := &bigqueryv2.TableSchema{}
+		for _, col := range columns {
+			schema.Fields = append(schema.Fields, schemaField(tableContent.Schema, col.Name))
+		}
+		data, badRecords = csvRecordsData(records, config, schema)
+	case externalSourceFormatJSON, externalSourceFormatParquet:
 		b, err := io.ReadAll(r.reader)
 		if err != nil {
 			return err
 		}
-		reader := parquet.NewReader(bytes.NewReader(b))
-		defer reader.Close()
-
-		for _, f := range load.Schema.Fields {
-			columns = append(columns, &types.Column{
-				Name: f.Name,
-				Type: types.Type(f.Type),
-			})
-		}
-
-		for i := 0; i < int(reader.NumRows()); i++ {
-			var rowData interface{}
-			err := reader.Read(&rowData)
-			if err != nil {
-				return err
-			}
-
-			data = append(data, rowData.(map[string]interface{}))
-		}
-	case "NEWLINE_DELIMITED_JSON":
 		for _, f := range tableContent.Schema.Fields {
 			columns = append(columns, &types.Column{
 				Name: f.Name,
 				Type: types.Type(f.Type),
 			})
 		}
-		columnMap := map[string]*types.Column{}
-		for _, col := range columns {
-			columnMap[col.Name] = col
-		}
-		decoder := json.NewDecoder(r.reader)
-		decoder.UseNumber()
-		for decoder.More() {
-			d := make(map[string]interface{})
-			if err := decoder.Decode(&d); err != nil {
-				return err
-			}
-			h.normalizeColumnNameForJSONData(columnMap, d)
-			data = append(data, d)
+		data, badRecords, err = parseExternalFile(b, config, tableContent.Schema)
+		if err != nil {
+			return err
 		}
 	default:
-		return fmt.Errorf("not support sourceFormat: %s", sourceFormat)
+		return fmt.Errorf("not support sourceFormat: %s", load.SourceFormat)
+	}
+	if badRecords > load.MaxBadRecords {
+		return fmt.Errorf("too many bad records: %d records don't match the schema but maxBadRecords is %d", badRecords, load.MaxBadRecords)
 	}
 	tableDef := &types.Table{
-		ID:      tableRef.TableId,
+		ID:      tableID,
 		Columns: columns,
 		Data:    data,
 	}
-	conn, err := r.server.connMgr.Connection(ctx, tableRef.ProjectId, tableRef.DatasetId)
-	if err != nil {
-		return fmt.Errorf("failed to get connection: %w", err)
-	}
-	tx, err := conn.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.RollbackIfNotCommitted()
 	if err := r.server.contentRepo.AddTableData(ctx, tx, tableRef.ProjectId, tableRef.DatasetId, tableDef); err != nil {
 		return err
 	}
@@ -663,6 +671,7 @@ func (h *datasetsGetHandler) Handle(ctx context.Context, r *datasetsGetRequest)
 		ProjectId: r.project.ID,
 		DatasetId: r.dataset.ID,
 	}
+	newContent.Location = r.server.datasetLocation(r.dataset)
 	return &newContent, nil
 }
 
@@ -681,7 +690,9 @@ func (h *datasetsInsertHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 		dataset: &dataset,
 	})
 	if err != nil {
-		errorResponse(ctx, w, errInternalError(err.Error()))
+		serverErr := errInternalError(err.Error())
+		errors.As(err, &serverErr)
+		errorResponse(ctx, w, serverErr)
 		return
 	}
 	encodeResponse(ctx, w, res)
@@ -695,11 +706,20 @@ type datasetsInsertRequest struct {
 
 func (h *datasetsInsertHandler) Handle(ctx context.Context, r *datasetsInsertRequest) (*bigqueryv2.DatasetListDatasets, error) {
 	if r.dataset.DatasetReference == nil {
-		return nil, fmt.Errorf("DatasetReference is nil")
+		return nil, errInvalid("DatasetReference is nil")
 	}
 	datasetID := r.dataset.DatasetReference.DatasetId
 	if datasetID == "" {
-		return nil, fmt.Errorf("dataset id is empty")
+		return nil, errInvalid("dataset id is empty")
+	}
+	if r.dataset.Location == "" {
+		r.dataset.Location = r.server.defaultLocation
+	} else {
+		location, ok := normalizeLocation(r.dataset.Location)
+		if !ok {
+			return nil, errInvalid(fmt.Sprintf("Invalid dataset location: %s", r.dataset.Location))
+		}
+		r.dataset.Location = location
 	}
 	conn, err := r.server.connMgr.Connection(ctx, r.project.ID, datasetID)
 	if err != nil {
@@ -724,6 +744,9 @@ func (h *datasetsInsertHandler) Handle(ctx context.Context, r *datasetsInsertReq
 			nil,
 		),
 	); err != nil {
+		if errors.Is(err, metadata.ErrDuplicatedDataset) {
+			return nil, errDuplicate(fmt.Sprintf("Already Exists: Dataset %s:%s", r.project.ID, datasetID))
+		}
 		return nil, err
 	}
 	if err := tx.Commit(); err != nil {
@@ -777,7 +800,7 @@ func (h *datasetsListHandler) Handle(ctx context.Context, r *datasetsListRequest
 			Id:              dataset.ID,
 			Kind:            content.Kind,
 			Labels:          content.Labels,
-			Location:        content.Location,
+			Location:        r.server.datasetLocation(dataset),
 			ForceSendFields: content.ForceSendFields,
 			NullFields:      content.NullFields,
 		})
@@ -963,14 +986,21 @@ func (h *jobsGetQueryResultsHandler) ServeHTTP(w http.ResponseWriter, r *http.Re
 	server := serverFromContext(ctx)
 	project := projectFromContext(ctx)
 	job := jobFromContext(ctx)
+	maxResults, offset, pageErr := parseResultPageParams(r)
+	if pageErr != nil {
+		errorResponse(ctx, w, pageErr)
+		return
+	}
 	res, err := h.Handle(ctx, &jobsGetQueryResultsRequest{
 		server:            server,
 		project:           project,
 		job:               job,
 		useInt64Timestamp: isFormatOptionsUseInt64Timestamp(r),
+		maxResults:        maxResults,
+		offset:            offset,
 	})
 	if err != nil {
-		errorResponse(ctx, w, errJobInternalError(err.Error()))
+		errorResponse(ctx, w, queryError(err))
 		return
 	}
 	encodeResponse(ctx, w, res)
@@ -981,14 +1011,21 @@ type jobsGetQueryResultsRequest struct {
 	project           *metadata.Project
 	job               *metadata.Job
 	useInt64Timestamp bool
+	// maxResults is the page size, or negative if it isn't specified.
+	maxResults int64
+	// offset is the first row of the page given by pageToken or startIndex.
+	offset int64
 }
 
+// Handle returns the page of the rows stored in the job. The queries run synchronously,
+// so the job is always complete regardless of timeoutMs.
 func (h *jobsGetQueryResultsHandler) Handle(ctx context.Context, r *jobsGetQueryResultsRequest) (*internaltypes.GetQueryResultsResponse, error) {
 	response, err := r.job.Wait(ctx)
 	if err != nil {
 		return nil, err
 	}
-	rows := internaltypes.Format(response.Schema, response.Rows, r.useInt64Timestamp)
+	page, pageToken := resultPage(response.Rows, r.offset, r.maxResults)
+	rows := internaltypes.Format(response.Schema, page, r.useInt64Timestamp)
 	return &internaltypes.GetQueryResultsResponse{
 		JobReference: &bigqueryv2.JobReference{
 			ProjectId: r.project.ID,
@@ -996,11 +1033,62 @@ func (h *jobsGetQueryResultsHandler) Handle(ctx context.Context, r *jobsGetQuery
 		},
 		Schema:      response.Schema,
 		TotalRows:   response.TotalRows,
+		PageToken:   pageToken,
 		JobComplete: true,
 		Rows:        rows,
 	}, nil
 }
 
+// parseResultPageParams parses maxResults and the first row given by pageToken or startIndex of getQueryResults.
+// The page token is the offset of the first row of the page, and startIndex takes precedence over it.
+// maxResults is negative if it isn't specified.
+func parseResultPageParams(r *http.Request) (int64, int64, *ServerError) {
+	query := r.URL.Query()
+	maxResults := int64(-1)
+	if v := query.Get("maxResults"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, errInvalid(fmt.Sprintf("Invalid maxResults: %s", v))
+		}
+		maxResults = n
+	}
+	var offset int64
+	if v := query.Get("pageToken"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, errInvalid(fmt.Sprintf("Invalid page token: %s", v))
+		}
+		offset = n
+	}
+	if v := query.Get("startIndex"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, 0, errInvalid(fmt.Sprintf("Invalid startIndex: %s", v))
+		}
+		offset = int64(n)
+	}
+	return maxResults, offset, nil
+}
+
+// resultPage returns the rows of the page starting at offset with up to maxResults rows ( all the rows if it is negative ),
+// and the page token of the next page, or the empty string if no rows remain.
+// maxResults of 0 returns no rows and the page token of the first row, which the clients use to wait for the job.
+func resultPage(rows []*internaltypes.TableRow, offset, maxResults int64) ([]*internaltypes.TableRow, string) {
+	total := int64(len(rows))
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if maxResults >= 0 && offset+maxResults < total {
+		end = offset + maxResults
+	}
+	var pageToken string
+	if end < total {
+		pageToken = strconv.FormatInt(end, 10)
+	}
+	return rows[offset:end], pageToken
+}
+
 func (h *jobsInsertHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	server := serverFromContext(ctx)
@@ -1016,7 +1104,9 @@ func (h *jobsInsertHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		job:     &job,
 	})
 	if err != nil {
-		errorResponse(ctx, w, errJobInternalError(err.Error()))
+		serverErr := errJobInternalError(err.Error())
+		errors.As(err, &serverErr)
+		errorResponse(ctx, w, serverErr)
 		return
 	}
 	encodeResponse(ctx, w, res)
@@ -1374,6 +1464,9 @@ func (h *jobsInsertHandler) Handle(ctx context.Context, r *jobsInsertRequest) (*
 	if job.Configuration == nil {
 		return nil, fmt.Errorf("unspecified job configuration")
 	}
+	if err := r.server.readOnlyJobError(job); err != nil {
+		return nil, err
+	}
 	if job.Configuration.Query == nil {
 		if job.Configuration.Load != nil && len(job.Configuration.Load.SourceUris) != 0 {
 			// load from google cloud storage
@@ -1388,9 +1481,14 @@ func (h *jobsInsertHandler) Handle(ctx context.Context, r *jobsInsertRequest) (*
 				return nil, fmt.Errorf("failed to export to gcs: %w", err)
 			}
 			return job, nil
+		} else if job.Configuration.Copy != nil {
+			if _, err := r.server.copyLocation(ctx, r.project, job.JobReference.Location, job.Configuration.Copy); err != nil {
+				return nil, err
+			}
 		}
 		return nil, fmt.Errorf("unspecified job configuration query")
 	}
+	ctx = withCatalogDefinitions(ctx)
 	conn, err := r.server.connMgr.Connection(ctx, r.project.ID, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
@@ -1402,45 +1500,73 @@ func (h *jobsInsertHandler) Handle(ctx context.Context, r *jobsInsertRequest) (*
 	defer tx.RollbackIfNotCommitted()
 	hasDestinationTable := job.Configuration.Query.DestinationTable != nil
 	startTime := time.Now()
-	response, jobErr := r.server.contentRepo.Query(
+	var defaultDatasetID string
+	if job.Configuration.Query.DefaultDataset != nil {
+		defaultDatasetID = job.Configuration.Query.DefaultDataset.DatasetId
+	}
+	location, jobErr := r.server.queryLocation(
 		ctx,
-		tx,
-		r.project.ID,
-		"",
+		r.project,
+		defaultDatasetID,
+		job.JobReference.Location,
 		job.Configuration.Query.Query,
-		job.Configuration.Query.QueryParameters,
 	)
-	endTime := time.Now()
 	if job.JobReference.JobId == "" {
 		job.JobReference.JobId = randomID() // generate job id
 	}
+	if jobErr == nil {
+		jobErr = validateQueryDestination(job.Configuration.Query)
+	}
+	var (
+		response  *internaltypes.QueryResponse
+		planInput *queryPlanInput
+	)
+	var (
+		props        *connectionProperties
+		queryProject = r.project
+	)
+	if jobErr == nil {
+		props, jobErr = parseConnectionProperties(job.Configuration.Query.ConnectionProperties)
+	}
+	if jobErr == nil {
+		queryProject, jobErr = r.server.datasetProject(ctx, r.project, props, job.Configuration.Query.DefaultDataset)
+	}
+	if jobErr == nil && !job.Configuration.DryRun {
+		planInput = r.server.queryPlanInput(ctx, queryProject, "", job.Configuration.Query.Query)
+	}
+	if jobErr == nil {
+		queryCtx := withConnectionProperties(withQueryJobID(ctx, job.JobReference.JobId), props)
+		if !hasDestinationTable {
+			queryCtx = withResultLimit(queryCtx, r.server.resultLimit)
+		}
+		response, jobErr = r.server.execQuery(
+			queryCtx,
+			tx,
+			queryProject,
+			"",
+			job.Configuration.Query.Query,
+			job.Configuration.Query.QueryParameters,
+		)
+	}
+	endTime := time.Now()
+	r.server.logQuery(
+		ctx,
+		job.JobReference.JobId,
+		job.Configuration.Query.Query,
+		job.Configuration.Query.QueryParameters,
+		response,
+		endTime.Sub(startTime),
+		jobErr,
+	)
+	if location != "" {
+		job.JobReference.Location = location
+	}
 	if jobErr == nil {
 		if hasDestinationTable {
-			// insert results to destination table
-			tableRef := job.Configuration.Query.DestinationTable
-			tableDef, err := h.tableDefFromQueryResponse(tableRef.TableId, response)
-			if err != nil {
-				return nil, err
-			}
-			destinationDataset := r.project.Dataset(tableRef.DatasetId)
-			if destinationDataset == nil {
-				return nil, fmt.Errorf("failed to find destination dataset: %s", tableRef.DatasetId)
-			}
-			destinationTable := destinationDataset.Table(tableRef.TableId)
-			destinationTableExists := destinationTable != nil
-			if !destinationTableExists {
-				_, err := createTableMetadata(ctx, tx, r.server, r.project, destinationDataset, tableDef.ToBigqueryV2(r.project.ID, tableRef.DatasetId))
-				if err != nil {
-					return nil, fmt.Errorf("failed to create table: %w", err)
-				}
-				serverErr := r.server.contentRepo.CreateTable(ctx, tx, tableDef.ToBigqueryV2(r.project.ID, tableRef.DatasetId))
-				if serverErr != nil {
-					return nil, fmt.Errorf("failed to create table: %w", serverErr)
-				}
-			}
-			if err := r.server.contentRepo.AddTableData(ctx, tx, tableRef.ProjectId, tableRef.DatasetId, tableDef); err != nil {
-				return nil, fmt.Errorf("failed to add table data: %w", err)
-			}
+			// the failed write leaves the destination table as it was, and the statements of the query are kept.
+			jobErr = tx.RunStatement(ctx, func() error {
+				return h.writeQueryDestinationTable(ctx, tx, r, job.Configuration.Query, response)
+			})
 		} else if response.TotalRows > 0 {
 			if err := h.addQueryResultToDynamicDestinationTable(ctx, tx, r, response); err != nil {
 				return nil, fmt.Errorf("failed to add query result to dynamic destination table: %w", err)
@@ -1458,27 +1584,38 @@ func (h *jobsInsertHandler) Handle(ctx context.Context, r *jobsInsertRequest) (*
 	)
 	status := &bigqueryv2.JobStatus{State: "DONE"}
 	if jobErr != nil {
-		internalErr := errJobInternalError(jobErr.Error())
-		status.ErrorResult = internalErr.ErrorProto()
-		status.Errors = []*bigqueryv2.ErrorProto{internalErr.ErrorProto()}
+		serverErr := queryError(jobErr)
+		status.ErrorResult = serverErr.ErrorProto()
+		status.Errors = serverErr.ErrorProtos()
 	}
 	job.Status = status
-	var totalBytes int64
+	var (
+		totalBytes         int64
+		numDmlAffectedRows int64
+		dmlStats           *bigqueryv2.DmlStatistics
+	)
 	if response != nil {
 		totalBytes = response.TotalBytes
+		numDmlAffectedRows = response.NumDmlAffectedRows
+		dmlStats = response.DmlStats
 	}
 	job.Statistics = &bigqueryv2.JobStatistics{
 		Query: &bigqueryv2.JobStatistics2{
 			CacheHit:            false,
-			StatementType:       "SELECT",
+			StatementType:       statementType(job.Configuration.Query.Query),
 			TotalBytesBilled:    totalBytes,
 			TotalBytesProcessed: totalBytes,
+			NumDmlAffectedRows:  numDmlAffectedRows,
+			DmlStats:            dmlStats,
 		},
 		CreationTime:        startTime.Unix(),
 		StartTime:           startTime.Unix(),
 		EndTime:             endTime.Unix(),
 		TotalBytesProcessed: totalBytes,
 	}
+	if planInput != nil && jobErr == nil {
+		job.Statistics.Query.QueryPlan, job.Statistics.Query.Timeline = r.server.newQueryPlan(ctx, tx, queryProject, planInput, response, startTime, endTime)
+	}
 	if err := r.project.AddJob(
 		ctx,
 		tx.Tx(),
@@ -1542,7 +1679,12 @@ func addTableMetadata(ctx context.Context, server *Server, spec *zetasqlite.Tabl
 		if err != nil {
 			return err
 		}
-		fields = append(fields, types.TableFieldSchemaFromZetaSQLType(column.Name, zetasqlType))
+		field := types.TableFieldSchemaFromZetaSQLType(column.Name, zetasqlType)
+		if column.IsNotNull && field.Mode != string(types.RepeatedMode) {
+			// the column of NOT NULL is REQUIRED and the table of go-zetasqlite rejects NULL for it.
+			field.Mode = string(types.RequiredMode)
+		}
+		fields = append(fields, field)
 	}
 	conn, err := server.connMgr.Connection(ctx, projectID, datasetID)
 	if err != nil {
@@ -1553,14 +1695,23 @@ func addTableMetadata(ctx context.Context, server *Server, spec *zetasqlite.Tabl
 		return err
 	}
 	defer tx.RollbackIfNotCommitted()
-	if _, err := createTableMetadata(ctx, tx, server, project, dataset, &bigqueryv2.Table{
+	table := &bigqueryv2.Table{
 		TableReference: &bigqueryv2.TableReference{
 			ProjectId: projectID,
 			DatasetId: datasetID,
 			TableId:   tableID,
 		},
 		Schema: &bigqueryv2.TableSchema{Fields: fields},
-	}); err != nil {
+	}
+	viewQuery, collationStmt := catalogDefinitionsFromContext(ctx).take(strings.Join(spec.NamePath, "."))
+	if collationStmt != nil {
+		applyCollations(table, collationStmt)
+	}
+	if spec.IsView {
+		// the query of spec is the one translated by go-zetasqlite, so the view has the query of CREATE VIEW statement.
+		table.View = &bigqueryv2.ViewDefinition{Query: viewQuery}
+	}
+	if _, err := createTableMetadata(ctx, tx, server, project, dataset, table); err != nil {
 		return err
 	}
 	if err := tx.Commit(); err != nil {
@@ -1650,8 +1801,9 @@ func (h *jobsListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	server := serverFromContext(ctx)
 	project := projectFromContext(ctx)
 	res, err := h.Handle(ctx, &jobsListRequest{
-		server:  server,
-		project: project,
+		server:      server,
+		project:     project,
+		parentJobID: r.URL.Query().Get("parentJobId"),
 	})
 	if err != nil {
 		errorResponse(ctx, w, errJobInternalError(err.Error()))
@@ -1663,19 +1815,29 @@ func (h *jobsListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 type jobsListRequest struct {
 	server  *Server
 	project *metadata.Project
+	// parentJobID lists the child jobs of the script job. The top-level jobs are listed if it is empty.
+	parentJobID string
 }
 
 func (h *jobsListHandler) Handle(ctx context.Context, r *jobsListRequest) (*bigqueryv2.JobList, error) {
 	jobs := []*bigqueryv2.JobListJobs{}
 	for _, job := range r.project.Jobs() {
 		content := job.Content()
+		var parentJobID string
+		if content.Statistics != nil {
+			parentJobID = content.Statistics.ParentJobId
+		}
+		if parentJobID != r.parentJobID {
+			continue
+		}
 		jobs = append(jobs, &bigqueryv2.JobListJobs{
-			Id:           content.Id,
-			JobReference: content.JobReference,
-			Kind:         content.Kind,
-			Statistics:   content.Statistics,
-			Status:       content.Status,
-			UserEmail:    content.UserEmail,
+			Id:            content.Id,
+			Configuration: content.Configuration,
+			JobReference:  content.JobReference,
+			Kind:          content.Kind,
+			Statistics:    content.Statistics,
+			Status:        content.Status,
+			UserEmail:     content.UserEmail,
 		})
 	}
 	return &bigqueryv2.JobList{Jobs: jobs}, nil
@@ -1702,7 +1864,7 @@ func (h *jobsQueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		useInt64Timestamp: useInt64Timestamp,
 	})
 	if err != nil {
-		errorResponse(ctx, w, errJobInternalError(err.Error()))
+		errorResponse(ctx, w, queryError(err))
 		return
 	}
 	encodeResponse(ctx, w, res)
@@ -1716,10 +1878,22 @@ type jobsQueryRequest struct {
 }
 
 func (h *jobsQueryHandler) Handle(ctx context.Context, r *jobsQueryRequest) (*internaltypes.QueryResponse, error) {
+	if err := r.server.readOnlyQueryError(r.queryRequest.Query, r.queryRequest.DryRun); err != nil {
+		return nil, err
+	}
+	ctx = withCatalogDefinitions(ctx)
 	var datasetID string
 	if r.queryRequest.DefaultDataset != nil {
 		datasetID = r.queryRequest.DefaultDataset.DatasetId
 	}
+	props, err := parseConnectionProperties(r.queryRequest.ConnectionProperties)
+	if err != nil {
+		return nil, err
+	}
+	queryProject, err := r.server.datasetProject(ctx, r.project, props, r.queryRequest.DefaultDataset)
+	if err != nil {
+		return nil, err
+	}
 	conn, err := r.server.connMgr.Connection(ctx, r.project.ID, datasetID)
 	if err != nil {
 		return nil, err
@@ -1729,18 +1903,44 @@ func (h *jobsQueryHandler) Handle(ctx context.Context, r *jobsQueryRequest) (*in
 		return nil, err
 	}
 	defer tx.RollbackIfNotCommitted()
-	response, err := r.server.contentRepo.Query(
-		ctx,
+	startTime := time.Now()
+	location, err := r.server.queryLocation(ctx, queryProject, datasetID, r.queryRequest.Location, r.queryRequest.Query)
+	if err != nil {
+		return nil, err
+	}
+	jobID := r.queryRequest.RequestId
+	if jobID == "" {
+		jobID = randomID() // generate job id
+	}
+	var planInput *queryPlanInput
+	if !r.queryRequest.DryRun {
+		planInput = r.server.queryPlanInput(ctx, queryProject, datasetID, r.queryRequest.Query)
+	}
+	response, err := r.server.execQuery(
+		withConnectionProperties(withResultLimit(withQueryJobID(ctx, jobID), r.server.resultLimit), props),
 		tx,
-		r.project.ID,
+		queryProject,
 		datasetID,
 		r.queryRequest.Query,
 		r.queryRequest.QueryParameters,
 	)
+	endTime := time.Now()
+	r.server.logQuery(ctx, jobID, r.queryRequest.Query, r.queryRequest.QueryParameters, response, endTime.Sub(startTime), err)
 	if err != nil {
 		return nil, err
 	}
+	createJob := !h.isShortQuery(r, response)
 	if !r.queryRequest.DryRun {
+		if createJob && r.project.Job(jobID) == nil {
+			job := h.newJob(ctx, tx, r, queryProject, jobID, location, response, planInput, startTime, endTime)
+			if err := r.project.AddJob(
+				ctx,
+				tx.Tx(),
+				metadata.NewJob(r.server.metaRepo, r.project.ID, jobID, job, response, nil),
+			); err != nil {
+				return nil, fmt.Errorf("failed to add job: %w", err)
+			}
+		}
 		if err := tx.Commit(); err != nil {
 			return nil, err
 		}
@@ -1750,18 +1950,88 @@ func (h *jobsQueryHandler) Handle(ctx context.Context, r *jobsQueryRequest) (*in
 			}
 		}
 	}
-	jobID := r.queryRequest.RequestId
-	if jobID == "" {
-		jobID = randomID() // generate job id
-	}
-	response.Rows = internaltypes.Format(response.Schema, response.Rows, r.useInt64Timestamp)
+	if !createJob {
+		// the short query returns the results without creating a job.
+		response.Rows = internaltypes.Format(response.Schema, response.Rows, r.useInt64Timestamp)
+		response.QueryId = jobID
+		return response, nil
+	}
+	// the first page of the rows is returned inline and getQueryResults continues from pageToken.
+	// maxResults of 0 is indistinguishable from the omitted value, so it returns all the rows.
+	maxResults := r.queryRequest.MaxResults
+	if maxResults == 0 {
+		maxResults = -1
+	}
+	// the job keeps all the rows of the response, so the page is returned by the copy.
+	firstPage := *response
+	page, pageToken := resultPage(response.Rows, 0, maxResults)
+	firstPage.Rows = internaltypes.Format(response.Schema, page, r.useInt64Timestamp)
+	firstPage.PageToken = pageToken
+	response = &firstPage
 	response.JobReference = &bigqueryv2.JobReference{
 		ProjectId: r.project.ID,
 		JobId:     jobID,
+		Location:  location,
 	}
 	return response, nil
 }
 
+// isShortQuery reports whether the query can return the results without creating a job.
+// DML and DDL always create a job, and so does the query whose results exceed the requested page size.
+func (h *jobsQueryHandler) isShortQuery(r *jobsQueryRequest, response *internaltypes.QueryResponse) bool {
+	if r.queryRequest.JobCreationMode != "JOB_CREATION_OPTIONAL" {
+		return false
+	}
+	if response.ChangedCatalog.Changed() || !contentdata.IsQueryStatement(r.queryRequest.Query) {
+		return false
+	}
+	if r.queryRequest.MaxResults > 0 && response.TotalRows > uint64(r.queryRequest.MaxResults) {
+		return false
+	}
+	return true
+}
+
+func (h *jobsQueryHandler) newJob(ctx context.Context, tx *connection.Tx, r *jobsQueryRequest, queryProject *metadata.Project, jobID, location string, response *internaltypes.QueryResponse, planInput *queryPlanInput, startTime, endTime time.Time) *bigqueryv2.Job {
+	job := &bigqueryv2.Job{
+		Kind: "bigquery#job",
+		JobReference: &bigqueryv2.JobReference{
+			ProjectId: r.project.ID,
+			JobId:     jobID,
+			Location:  location,
+		},
+		Configuration: &bigqueryv2.JobConfiguration{
+			JobType: "QUERY",
+			Labels:  r.queryRequest.Labels,
+			Query: &bigqueryv2.JobConfigurationQuery{
+				Query:                r.queryRequest.Query,
+				QueryParameters:      r.queryRequest.QueryParameters,
+				DefaultDataset:       r.queryRequest.DefaultDataset,
+				UseLegacySql:         r.queryRequest.UseLegacySql,
+				ConnectionProperties: r.queryRequest.ConnectionProperties,
+				Priority:             "INTERACTIVE",
+			},
+		},
+		Status: &bigqueryv2.JobStatus{State: "DONE"},
+		Statistics: &bigqueryv2.JobStatistics{
+			Query: &bigqueryv2.JobStatistics2{
+				StatementType:       statementType(r.queryRequest.Query),
+				TotalBytesBilled:    response.TotalBytes,
+				TotalBytesProcessed: response.TotalBytes,
+				NumDmlAffectedRows:  response.NumDmlAffectedRows,
+				DmlStats:            response.DmlStats,
+			},
+			CreationTime:        startTime.Unix(),
+			StartTime:           startTime.Unix(),
+			EndTime:             endTime.Unix(),
+			TotalBytesProcessed: response.TotalBytes,
+		},
+	}
+	if planInput != nil {
+		job.Statistics.Query.QueryPlan, job.Statistics.Query.Timeline = r.server.newQueryPlan(ctx, tx, queryProject, planInput, response, startTime, endTime)
+	}
+	return job
+}
+
 func (h *modelsDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	server := serverFromContext(ctx)
@@ -1831,27 +2101,7 @@ type modelsGetRequest struct {
 }
 
 func (h *modelsGetHandler) Handle(ctx context.Context, r *modelsGetRequest) (*bigqueryv2.Model, error) {
-	return &bigqueryv2.Model{
-		BestTrialId:             0,
-		CreationTime:            0,
-		DefaultTrialId:          0,
-		Description:             "",
-		EncryptionConfiguration: nil,
-		Etag:                    "",
-		ExpirationTime:          0,
-		FeatureColumns:          nil,
-		FriendlyName:            "",
-		HparamSearchSpaces:      nil,
-		HparamTrials:            nil,
-		LabelColumns:            nil,
-		Labels:                  nil,
-		LastModifiedTime:        0,
-		Location:                "",
-		ModelReference:          nil,
-		ModelType:               "",
-		OptimalTrialIds:         nil,
-		TrainingRuns:            nil,
-	}, nil
+	return r.model.Content()
 }
 
 func (h *modelsListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -1880,8 +2130,11 @@ type modelsListRequest struct {
 func (h *modelsListHandler) Handle(ctx context.Context, r *modelsListRequest) (*bigqueryv2.ListModelsResponse, error) {
 	models := []*bigqueryv2.Model{}
 	for _, m := range r.dataset.Models() {
-		_ = m
-		models = append(models, &bigqueryv2.Model{})
+		content, err := m.Content()
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, content)
 	}
 	return &bigqueryv2.ListModelsResponse{
 		Models: models,
@@ -2031,7 +2284,7 @@ type routinesGetRequest struct {
 }
 
 func (h *routinesGetHandler) Handle(ctx context.Context, r *routinesGetRequest) (*bigqueryv2.Routine, error) {
-	return nil, fmt.Errorf("unsupported bigquery.routines.get")
+	return r.routine.Content()
 }
 
 func (h *routinesInsertHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -2074,7 +2327,14 @@ func (h *routinesInsertHandler) Handle(ctx context.Context, r *routinesInsertReq
 		return nil, err
 	}
 	defer tx.RollbackIfNotCommitted()
-	if err := r.server.contentRepo.AddRoutineByMetaData(ctx, tx, r.routine); err != nil {
+	if r.routine.RoutineType == string(contentdata.TableValuedFunctionType) {
+		if r.routine.RoutineReference == nil || r.routine.RoutineReference.RoutineId == "" {
+			return nil, fmt.Errorf("invalid routine: missing routineId")
+		}
+		if err := r.server.addTableFunction(ctx, tx, r.project, r.dataset, r.routine); err != nil {
+			return nil, err
+		}
+	} else if err := r.server.contentRepo.AddRoutineByMetaData(ctx, tx, r.routine); err != nil {
 		return nil, err
 	}
 	if err := tx.Commit(); err != nil {
@@ -2150,22 +2410,50 @@ func (h *routinesUpdateHandler) Handle(ctx context.Context, r *routinesUpdateReq
 func (h *rowAccessPoliciesGetIamPolicyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	server := serverFromContext(ctx)
+	table := tableFromContext(ctx)
 	res, err := h.Handle(ctx, &rowAccessPoliciesGetIamPolicyRequest{
-		server: server,
+		server:   server,
+		table:    table,
+		policyID: mux.Vars(r)["rowAccessPoliciesId"],
 	})
 	if err != nil {
-		errorResponse(ctx, w, errInternalError(err.Error()))
+		errorResponse(ctx, w, err)
 		return
 	}
 	encodeResponse(ctx, w, res)
 }
 
 type rowAccessPoliciesGetIamPolicyRequest struct {
-	server *Server
+	server   *Server
+	table    *metadata.Table
+	policyID string
 }
 
-func (h *rowAccessPoliciesGetIamPolicyHandler) Handle(ctx context.Context, r *rowAccessPoliciesGetIamPolicyRequest) (*bigqueryv2.Policy, error) {
-	return nil, fmt.Errorf("unsupported bigquery.rowAccessPolicies.getIamPolicy")
+// rowAccessPolicyViewerRole is the role that CREATE ROW ACCESS POLICY grants to the grantees.
+const rowAccessPolicyViewerRole = "roles/bigquery.filteredDataViewer"
+
+// Handle returns the grantees of the row access policy as the members of the filtered data viewer role.
+func (h *rowAccessPoliciesGetIamPolicyHandler) Handle(ctx context.Context, r *rowAccessPoliciesGetIamPolicyRequest) (*bigqueryv2.Policy, *ServerError) {
+	policies, err := r.table.RowAccessPolicies()
+	if err != nil {
+		return nil, errInternalError(err.Error())
+	}
+	for _, policy := range policies {
+		if policy.Name != r.policyID {
+			continue
+		}
+		res := &bigqueryv2.Policy{Version: 1, Bindings: []*bigqueryv2.Binding{}}
+		if len(policy.Grantees) != 0 {
+			res.Bindings = append(res.Bindings, &bigqueryv2.Binding{
+				Role:    rowAccessPolicyViewerRole,
+				Members: policy.Grantees,
+			})
+		}
+		return res, nil
+	}
+	return nil, errNotFound(fmt.Sprintf(
+		"Not found: Row access policy %s on table %s:%s.%s", r.policyID, r.table.ProjectID, r.table.DatasetID, r.table.ID,
+	))
 }
 
 func (h *rowAccessPoliciesListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -2195,7 +2483,27 @@ type rowAccessPoliciesListRequest struct {
 }
 
 func (h *rowAccessPoliciesListHandler) Handle(ctx context.Context, r *rowAccessPoliciesListRequest) (*bigqueryv2.ListRowAccessPoliciesResponse, error) {
-	return nil, fmt.Errorf("unsupported bigquery.rowAccessPolicies.list")
+	policies, err := r.table.RowAccessPolicies()
+	if err != nil {
+		return nil, err
+	}
+	res := []*bigqueryv2.RowAccessPolicy{}
+	for _, policy := range policies {
+		res = append(res, &bigqueryv2.RowAccessPolicy{
+			RowAccessPolicyReference: &bigqueryv2.RowAccessPolicyReference{
+				ProjectId: r.table.ProjectID,
+				DatasetId: r.table.DatasetID,
+				TableId:   r.table.ID,
+				PolicyId:  policy.Name,
+			},
+			FilterPredicate:  policy.FilterPredicate,
+			CreationTime:     time.UnixMilli(policy.CreationTime).UTC().Format(time.RFC3339Nano),
+			LastModifiedTime: time.UnixMilli(policy.LastModifiedTime).UTC().Format(time.RFC3339Nano),
+		})
+	}
+	return &bigqueryv2.ListRowAccessPoliciesResponse{
+		RowAccessPolicies: res,
+	}, nil
 }
 
 func (h *rowAccessPoliciesSetIamPolicyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -2371,7 +2679,9 @@ func (h *tabledataListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		useInt64Timestamp: isFormatOptionsUseInt64Timestamp(r),
 	})
 	if err != nil {
-		errorResponse(ctx, w, errInternalError(err.Error()))
+		serverErr := errInternalError(err.Error())
+		errors.As(err, &serverErr)
+		errorResponse(ctx, w, serverErr)
 		return
 	}
 	encodeResponse(ctx, w, res)
@@ -2386,6 +2696,9 @@ type tabledataListRequest struct {
 }
 
 func (h *tabledataListHandler) Handle(ctx context.Context, r *tabledataListRequest) (*internaltypes.TableDataList, error) {
+	if err := r.server.checkTableDataAccess(ctx, r.dataset, r.table.ID); err != nil {
+		return nil, err
+	}
 	conn, err := r.server.connMgr.Connection(ctx, r.project.ID, r.dataset.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
@@ -2573,9 +2886,15 @@ func createTableMetadata(ctx context.Context, tx *connection.Tx, server *Server,
 	table.Id = fmt.Sprintf("%s:%s.%s", project.ID, dataset.ID, table.TableReference.TableId)
 	table.CreationTime = now
 	table.LastModifiedTime = uint64(now)
-	table.Type = string(DefaultTableType) // TODO: need to handle other table types
-	if table.View != nil {
+	switch {
+	case table.View != nil:
 		table.Type = string(ViewTableType)
+	case table.MaterializedView != nil:
+		table.Type = string(MaterializedViewTableType)
+	case table.ExternalDataConfiguration != nil:
+		table.Type = string(ExternalTableType)
+	default:
+		table.Type = string(DefaultTableType)
 	}
 	table.Kind = "bigquery#table"
 	table.SelfLink = fmt.Sprintf(
@@ -2623,17 +2942,24 @@ func (h *tablesInsertHandler) Handle(ctx context.Context, r *tablesInsertRequest
 	}
 	defer tx.RollbackIfNotCommitted()
 
+	if r.table.View != nil && r.table.View.UseLegacySql {
+		return nil, errInvalid("Legacy SQL is not supported by the emulator: set useLegacySql of the view to false")
+	}
+	if r.table.ExternalDataConfiguration != nil {
+		if serverErr := prepareExternalTable(r.table); serverErr != nil {
+			return nil, serverErr
+		}
+	}
 	table, serverErr := createTableMetadata(ctx, tx, r.server, r.project, r.dataset, r.table)
 	if serverErr != nil {
 		return nil, serverErr
 	}
-	if r.table.Schema != nil {
-		if err := r.server.contentRepo.CreateTable(ctx, tx, r.table); err != nil {
-			return nil, errInternalError(err.Error())
-		}
-	}
-	if r.table.View != nil {
+	if r.table.View != nil || r.table.MaterializedView != nil {
 		if err := r.server.contentRepo.CreateView(ctx, tx, r.table); err != nil {
+			return nil, errInvalid(err.Error())
+		}
+	} else if r.table.Schema != nil {
+		if err := r.server.contentRepo.CreateTable(ctx, tx, r.table); err != nil {
 			return nil, errInternalError(err.Error())
 		}
 	}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	healthzEndpoint = "/healthz"
+	readyzEndpoint  = "/readyz"
+)
+
+// healthzHandler reports liveness. It only checks that the storage is still reachable.
+type healthzHandler struct {
+	server *Server
+}
+
+func (h *healthzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.server.db.PingContext(r.Context()); err != nil {
+		probeResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("storage is unreachable: %s", err))
+		return
+	}
+	probeResponse(w, http.StatusOK, "ok")
+}
+
+// readyzHandler reports readiness.
+// The server becomes ready when it starts serving and stops being ready when it starts shutting down.
+type readyzHandler struct {
+	server *Server
+}
+
+func (h *readyzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.server.ready.Load() {
+		probeResponse(w, http.StatusServiceUnavailable, "not ready")
+		return
+	}
+	if err := h.server.db.PingContext(r.Context()); err != nil {
+		probeResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("storage is unreachable: %s", err))
+		return
+	}
+	probeResponse(w, http.StatusOK, "ok")
+}
+
+func probeResponse(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, msg)
+}
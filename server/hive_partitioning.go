@@ -0,0 +1,239 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+)
+
+const (
+	hivePartitioningModeAuto    = "AUTO"
+	hivePartitioningModeStrings = "STRINGS"
+	hivePartitioningModeCustom  = "CUSTOM"
+)
+
+var (
+	customPartitionKeyPattern = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*):([a-zA-Z0-9]+)\}`)
+	partitionDatePattern      = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// hivePartitioning is the layout of the source files of the hive partitioned external table
+// like prefix/dt=2024-01-01/region=us/data.parquet.
+type hivePartitioning struct {
+	// prefix is the directory of the source files that the partition directories are under.
+	prefix string
+	keys   []*bigqueryv2.TableFieldSchema
+}
+
+// newHivePartitioning returns the layout of the files by the hive partitioning options.
+// In AUTO mode, the types of the keys are detected from the values: INTEGER, DATE or STRING.
+// In STRINGS mode, the keys are STRING. In CUSTOM mode, the keys and their types are given by sourceUriPrefix
+// like prefix/{dt:DATE}/{region:STRING}.
+func newHivePartitioning(opt *bigqueryv2.HivePartitioningOptions, files []string) (*hivePartitioning, error) {
+	if opt.SourceUriPrefix == "" {
+		return nil, fmt.Errorf("sourceUriPrefix of hivePartitioningOptions must be specified")
+	}
+	prefix := strings.TrimPrefix(opt.SourceUriPrefix, fileURIPrefix)
+	mode := opt.Mode
+	if mode == "" {
+		mode = hivePartitioningModeAuto
+	}
+	partitioning := &hivePartitioning{}
+	switch mode {
+	case hivePartitioningModeCustom:
+		if idx := strings.Index(prefix, "{"); idx >= 0 {
+			for _, match := range customPartitionKeyPattern.FindAllStringSubmatch(prefix[idx:], -1) {
+				typ, err := partitionKeyType(match[2])
+				if err != nil {
+					return nil, err
+				}
+				partitioning.keys = append(partitioning.keys, &bigqueryv2.TableFieldSchema{Name: match[1], Type: typ, Mode: "NULLABLE"})
+			}
+			prefix = prefix[:idx]
+		}
+		if len(partitioning.keys) == 0 {
+			return nil, fmt.Errorf("sourceUriPrefix of CUSTOM mode must have the partition keys like {dt:DATE}: %s", opt.SourceUriPrefix)
+		}
+	case hivePartitioningModeAuto, hivePartitioningModeStrings:
+	default:
+		return nil, fmt.Errorf("unexpected hive partitioning mode %s", opt.Mode)
+	}
+	partitioning.prefix = filepath.Clean(prefix)
+	if mode == hivePartitioningModeCustom {
+		for _, file := range files {
+			if _, err := partitioning.values(file); err != nil {
+				return nil, err
+			}
+		}
+		return partitioning, nil
+	}
+	for idx, file := range files {
+		pairs, err := partitioning.pairs(file)
+		if err != nil {
+			return nil, err
+		}
+		if idx == 0 {
+			for _, pair := range pairs {
+				partitioning.keys = append(partitioning.keys, &bigqueryv2.TableFieldSchema{Name: pair[0], Mode: "NULLABLE"})
+			}
+		}
+		if len(pairs) != len(partitioning.keys) {
+			return nil, fmt.Errorf("the partition keys of %s are different from the other files", file)
+		}
+		for i, pair := range pairs {
+			key := partitioning.keys[i]
+			if pair[0] != key.Name {
+				return nil, fmt.Errorf("the partition keys of %s are different from the other files", file)
+			}
+			typ := "STRING"
+			if mode == hivePartitioningModeAuto {
+				typ = detectPartitionValueType(pair[1])
+			}
+			if key.Type != "" && key.Type != typ {
+				typ = "STRING"
+			}
+			key.Type = typ
+		}
+	}
+	if len(partitioning.keys) == 0 {
+		return nil, fmt.Errorf("no partition keys are found under %s", opt.SourceUriPrefix)
+	}
+	return partitioning, nil
+}
+
+func partitionKeyType(typ string) (string, error) {
+	switch strings.ToUpper(typ) {
+	case "STRING":
+		return "STRING", nil
+	case "INTEGER", "INT64":
+		return "INTEGER", nil
+	case "DATE":
+		return "DATE", nil
+	}
+	return "", fmt.Errorf("unsupported type of the partition key: %s", typ)
+}
+
+func detectPartitionValueType(v string) string {
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return "INTEGER"
+	}
+	if partitionDatePattern.MatchString(v) {
+		return "DATE"
+	}
+	return "STRING"
+}
+
+// pairs returns the key and value pairs of the partition directories of file.
+func (p *hivePartitioning) pairs(file string) ([][2]string, error) {
+	rel, err := filepath.Rel(p.prefix, filepath.Clean(file))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("the source file %s is not under sourceUriPrefix %s", file, p.prefix)
+	}
+	dirs := strings.Split(filepath.ToSlash(filepath.Dir(rel)), "/")
+	var pairs [][2]string
+	for _, dir := range dirs {
+		key, value, found := strings.Cut(dir, "=")
+		if !found {
+			continue
+		}
+		unescaped, err := url.PathUnescape(value)
+		if err != nil {
+			unescaped = value
+		}
+		pairs = append(pairs, [2]string{key, unescaped})
+	}
+	return pairs, nil
+}
+
+// values returns the partition values of file. The value is nil for __HIVE_DEFAULT_PARTITION__.
+func (p *hivePartitioning) values(file string) (map[string]interface{}, error) {
+	pairs, err := p.pairs(file)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	for _, key := range p.keys {
+		var (
+			value string
+			found bool
+		)
+		for _, pair := range pairs {
+			if pair[0] == key.Name {
+				value, found = pair[1], true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("the source file %s doesn't have the partition key %s", file, key.Name)
+		}
+		if value == "__HIVE_DEFAULT_PARTITION__" {
+			values[key.Name] = nil
+			continue
+		}
+		v, err := externalScalarValue(value, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid partition value of %s: %w", file, err)
+		}
+		values[key.Name] = v
+	}
+	return values, nil
+}
+
+func (p *hivePartitioning) keyNames() []string {
+	names := make([]string, 0, len(p.keys))
+	for _, key := range p.keys {
+		names = append(names, key.Name)
+	}
+	return names
+}
+
+func (p *hivePartitioning) isKey(name string) bool {
+	for _, key := range p.keys {
+		if strings.EqualFold(key.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the partition values of the file can match the filters like dt = '2024-01-01'.
+func (p *hivePartitioning) matches(values map[string]interface{}, filters map[string]string) bool {
+	for _, key := range p.keys {
+		filter, exists := filters[key.Name]
+		if !exists {
+			continue
+		}
+		value := values[key.Name]
+		if value == nil {
+			return false
+		}
+		switch v := value.(type) {
+		case int64:
+			i, err := strconv.ParseInt(filter, 10, 64)
+			if err == nil && i != v {
+				return false
+			}
+		case string:
+			if v != filter {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// dataSchema returns the schema of the columns in the source files without the partition keys.
+func (p *hivePartitioning) dataSchema(schema *bigqueryv2.TableSchema) *bigqueryv2.TableSchema {
+	fields := make([]*bigqueryv2.TableFieldSchema, 0, len(schema.Fields))
+	for _, field := range schema.Fields {
+		if !p.isKey(field.Name) {
+			fields = append(fields, field)
+		}
+	}
+	return &bigqueryv2.TableSchema{Fields: fields}
+}
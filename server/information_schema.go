@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+)
+
+const (
+	informationSchemaName = "INFORMATION_SCHEMA"
+
+	nullPartitionID          = "__NULL__"
+	unpartitionedPartitionID = "__UNPARTITIONED__"
+
+	// longTermStorageAge is the age of the partition that moves to the long-term storage.
+	longTermStorageAge = 90 * 24 * time.Hour
+)
+
+// partitionIDFormats is the format of the partition id for each time partitioning type.
+var partitionIDFormats = map[string]string{
+	"HOUR":  "%Y%m%d%H",
+	"DAY":   "%Y%m%d",
+	"MONTH": "%Y%m",
+	"YEAR":  "%Y",
+}
+
+// partitionsViewColumns are the columns of INFORMATION_SCHEMA.PARTITIONS.
+var partitionsViewColumns = []struct {
+	name string
+	typ  string
+}{
+	{name: "TABLE_CATALOG", typ: "STRING"},
+	{name: "TABLE_SCHEMA", typ: "STRING"},
+	{name: "TABLE_NAME", typ: "STRING"},
+	{name: "PARTITION_ID", typ: "STRING"},
+	{name: "TOTAL_ROWS", typ: "INT64"},
+	{name: "TOTAL_LOGICAL_BYTES", typ: "INT64"},
+	{name: "TOTAL_BILLABLE_BYTES", typ: "INT64"},
+	{name: "LAST_MODIFIED_TIME", typ: "TIMESTAMP"},
+	{name: "STORAGE_TIER", typ: "STRING"},
+}
+
+type tablePartition struct {
+	// id is nil for the table that is not partitioned.
+	id    *string
+	rows  int64
+	bytes int64
+}
+
+// expandInformationSchema replaces the references to the INFORMATION_SCHEMA views evaluated by the emulator
+// with the subquery that returns the rows of the view.
+func (s *Server) expandInformationSchema(ctx context.Context, tx *connection.Tx, project *metadata.Project, defaultDatasetID, query string) (string, error) {
+	return contentdata.ReplaceTableReferences(query, func(path []string) (string, bool, error) {
+		qualifier, view, ok := informationSchemaView(path)
+		if !ok || !strings.EqualFold(view, "PARTITIONS") {
+			return "", false, nil
+		}
+		projectID, datasetID := project.ID, defaultDatasetID
+		switch len(qualifier) {
+		case 1:
+			datasetID = qualifier[0]
+		case 2:
+			projectID, datasetID = qualifier[0], qualifier[1]
+		}
+		if datasetID == "" {
+			return "", false, fmt.Errorf("INFORMATION_SCHEMA.PARTITIONS must be qualified with a dataset")
+		}
+		p := project
+		if projectID != project.ID {
+			found, err := s.metaRepo.FindProject(ctx, projectID)
+			if err != nil {
+				return "", false, err
+			}
+			p = found
+		}
+		var dataset *metadata.Dataset
+		if p != nil {
+			dataset = p.Dataset(datasetID)
+		}
+		if dataset == nil {
+			return "", false, errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", projectID, datasetID))
+		}
+		expr, err := s.partitionsView(ctx, tx, dataset)
+		if err != nil {
+			return "", false, err
+		}
+		return expr, true, nil
+	})
+}
+
+// informationSchemaView splits the path like [dataset INFORMATION_SCHEMA PARTITIONS] into the qualifier and the view name.
+func informationSchemaView(path []string) ([]string, string, bool) {
+	if len(path) < 2 || len(path) > 4 || !strings.EqualFold(path[len(path)-2], informationSchemaName) {
+		return nil, "", false
+	}
+	return path[:len(path)-2], path[len(path)-1], true
+}
+
+// partitionsView returns the subquery that returns the rows of INFORMATION_SCHEMA.PARTITIONS of the dataset.
+func (s *Server) partitionsView(ctx context.Context, tx *connection.Tx, dataset *metadata.Dataset) (string, error) {
+	header := make([]string, 0, len(partitionsViewColumns))
+	for _, column := range partitionsViewColumns {
+		header = append(header, fmt.Sprintf("CAST(NULL AS %s) AS %s", column.typ, column.name))
+	}
+	selects := []string{fmt.Sprintf("SELECT %s FROM UNNEST([1]) WHERE FALSE", strings.Join(header, ", "))}
+	for _, table := range dataset.Tables() {
+		content, err := table.Content()
+		if err != nil {
+			return "", err
+		}
+		if content.Type == string(ViewTableType) || content.Type == string(MaterializedViewTableType) {
+			continue
+		}
+		partitions, err := s.tablePartitions(ctx, tx, table, content)
+		if err != nil {
+			return "", err
+		}
+		lastModifiedTime := tableLastModifiedTime(content)
+		storageTier := "ACTIVE"
+		if time.Since(lastModifiedTime) > longTermStorageAge {
+			storageTier = "LONG_TERM"
+		}
+		for _, partition := range partitions {
+			partitionID := "CAST(NULL AS STRING)"
+			if partition.id != nil {
+				partitionID = contentdata.QuoteStringLiteral(*partition.id)
+			}
+			selects = append(selects, fmt.Sprintf(
+				"SELECT %s, %s, %s, %s, %d, %d, %d, TIMESTAMP_MILLIS(%d), %s",
+				contentdata.QuoteStringLiteral(table.ProjectID),
+				contentdata.QuoteStringLiteral(table.DatasetID),
+				contentdata.QuoteStringLiteral(table.ID),
+				partitionID,
+				partition.rows,
+				partition.bytes,
+				partition.bytes,
+				lastModifiedTime.UnixMilli(),
+				contentdata.QuoteStringLiteral(storageTier),
+			))
+		}
+	}
+	return fmt.Sprintf("(%s)", strings.Join(selects, " UNION ALL ")), nil
+}
+
+// tablePartitions counts the rows and the bytes of each partition of the table by grouping the rows by the partition id.
+// The table that is not partitioned has a single partition whose id is NULL.
+func (s *Server) tablePartitions(ctx context.Context, tx *connection.Tx, table *metadata.Table, content *bigqueryv2.Table) ([]*tablePartition, error) {
+	expr, partitioned, err := partitionIDExpr(content)
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.contentRepo.Query(
+		ctx,
+		tx,
+		table.ProjectID,
+		table.DatasetID,
+		fmt.Sprintf(
+			"SELECT %s, COUNT(*), IFNULL(SUM(%s), 0) FROM `%s.%s.%s` GROUP BY 1 ORDER BY 1",
+			expr, rowBytesExpr(content), table.ProjectID, table.DatasetID, table.ID,
+		),
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count rows of partitions: %w", err)
+	}
+	if !partitioned && len(response.Rows) == 0 {
+		return []*tablePartition{{}}, nil
+	}
+	partitions := make([]*tablePartition, 0, len(response.Rows))
+	for _, row := range response.Rows {
+		partition := &tablePartition{}
+		if partitioned {
+			id, _ := row.F[0].V.(string)
+			partition.id = &id
+		}
+		rows, _ := row.F[1].V.(string)
+		if partition.rows, err = strconv.ParseInt(rows, 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to count rows of partitions: %w", err)
+		}
+		bytes, _ := row.F[2].V.(string)
+		if partition.bytes, err = strconv.ParseInt(bytes, 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to count bytes of partitions: %w", err)
+		}
+		partitions = append(partitions, partition)
+	}
+	return partitions, nil
+}
+
+// rowBytesExpr returns the expression that computes the logical bytes of each row of the table
+// by the size of each data type. The size of RECORD, repeated, JSON and GEOGRAPHY columns is the length of their JSON.
+func rowBytesExpr(content *bigqueryv2.Table) string {
+	if content.Schema == nil || len(content.Schema.Fields) == 0 {
+		return "0"
+	}
+	sizes := make([]string, 0, len(content.Schema.Fields))
+	for _, field := range content.Schema.Fields {
+		column := fmt.Sprintf("`%s`", field.Name)
+		var size string
+		switch typ := strings.ToUpper(field.Type); {
+		case field.Mode == "REPEATED", typ == "RECORD", typ == "STRUCT", typ == "JSON", typ == "GEOGRAPHY":
+			size = fmt.Sprintf("BYTE_LENGTH(TO_JSON_STRING(%s))", column)
+		case typ == "STRING":
+			size = fmt.Sprintf("2 + BYTE_LENGTH(%s)", column)
+		case typ == "BYTES":
+			size = fmt.Sprintf("2 + LENGTH(%s)", column)
+		case typ == "BOOLEAN", typ == "BOOL":
+			size = "1"
+		case typ == "NUMERIC":
+			size = "16"
+		case typ == "BIGNUMERIC":
+			size = "32"
+		default:
+			size = "8"
+		}
+		sizes = append(sizes, fmt.Sprintf("IF(%s IS NULL, 0, %s)", column, size))
+	}
+	return strings.Join(sizes, " + ")
+}
+
+// partitionIDExpr returns the expression that computes the partition id of each row.
+// The emulator doesn't record when each row is ingested, so all rows of the ingestion-time partitioned table
+// belong to the partition of the last modified time of the table.
+func partitionIDExpr(content *bigqueryv2.Table) (string, bool, error) {
+	if rp := content.RangePartitioning; rp != nil && rp.Range != nil {
+		field := fmt.Sprintf("`%s`", rp.Field)
+		start, end, interval := rp.Range.Start, rp.Range.End, rp.Range.Interval
+		if interval <= 0 {
+			return "", false, fmt.Errorf("invalid range partitioning interval %d", interval)
+		}
+		return fmt.Sprintf(
+			"IF(%[1]s IS NULL, '%[2]s', IF(%[1]s < %[3]d OR %[1]s >= %[4]d, '%[5]s', CAST(%[3]d + DIV(%[1]s - %[3]d, %[6]d) * %[6]d AS STRING)))",
+			field, nullPartitionID, start, end, unpartitionedPartitionID, interval,
+		), true, nil
+	}
+	tp := content.TimePartitioning
+	if tp == nil {
+		return "CAST(NULL AS STRING)", false, nil
+	}
+	typ := strings.ToUpper(tp.Type)
+	if typ == "" {
+		typ = "DAY"
+	}
+	format, exists := partitionIDFormats[typ]
+	if !exists {
+		return "", false, fmt.Errorf("unsupported time partitioning type %s", tp.Type)
+	}
+	if tp.Field == "" {
+		return fmt.Sprintf(
+			"FORMAT_TIMESTAMP('%s', TIMESTAMP_MILLIS(%d), 'UTC')", format, tableLastModifiedTime(content).UnixMilli(),
+		), true, nil
+	}
+	field := fmt.Sprintf("`%s`", tp.Field)
+	var formatted string
+	switch partitionFieldType(content, tp.Field) {
+	case "DATE":
+		formatted = fmt.Sprintf("FORMAT_DATE('%s', %s)", format, field)
+	case "DATETIME":
+		formatted = fmt.Sprintf("FORMAT_DATETIME('%s', %s)", format, field)
+	default:
+		formatted = fmt.Sprintf("FORMAT_TIMESTAMP('%s', %s, 'UTC')", format, field)
+	}
+	return fmt.Sprintf("IF(%s IS NULL, '%s', %s)", field, nullPartitionID, formatted), true, nil
+}
+
+func partitionFieldType(content *bigqueryv2.Table, name string) string {
+	if content.Schema == nil {
+		return ""
+	}
+	for _, field := range content.Schema.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return strings.ToUpper(field.Type)
+		}
+	}
+	return ""
+}
+
+// tableLastModifiedTime returns the last modified time of the table.
+// The emulator records the time in seconds. The table loaded from the data source has no time, so the current time is used.
+func tableLastModifiedTime(content *bigqueryv2.Table) time.Time {
+	if content.LastModifiedTime == 0 {
+		return time.Now()
+	}
+	return time.Unix(int64(content.LastModifiedTime), 0)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+)
+
+// InitSQLOption is the option of InitSQLSource.
+type InitSQLOption struct {
+	// Timeout is the time limit of the whole SQL. 0 means no limit.
+	Timeout time.Duration
+	// ContinueOnError logs the failed statement and runs the next one instead of failing the source.
+	ContinueOnError bool
+}
+
+// InitSQLSource runs the SQL like CREATE VIEW or CREATE FUNCTION in projectID after the preceding sources are loaded,
+// so the SQL can refer to the seeded data. The SQL runs as one script through the same path as jobs.query,
+// so the variables of DECLARE are visible to the following statements, and the script is committed at the end.
+func InitSQLSource(projectID, query string, opt *InitSQLOption) Source {
+	if opt == nil {
+		opt = &InitSQLOption{}
+	}
+	return func(s *Server) error {
+		ctx := context.Background()
+		if opt.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opt.Timeout)
+			defer cancel()
+		}
+		stmts, err := contentdata.ParseScriptStatements(query)
+		if err != nil {
+			return fmt.Errorf("failed to parse init SQL: %w", err)
+		}
+		return s.execInitScript(ctx, projectID, query, stmts, func(idx int, err error) error {
+			// the timeout stops the SQL even if ContinueOnError is set, because the next statements fail too.
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("init SQL timed out after %s at statement %d: %w", opt.Timeout, idx+1, err)
+			}
+			err = fmt.Errorf("failed to run statement %d of init SQL: %w", idx+1, err)
+			if !opt.ContinueOnError {
+				return err
+			}
+			s.logger.Warn("ignored the error of init SQL", zap.Error(err))
+			return nil
+		})
+	}
+}
+
+func (s *Server) execInitScript(ctx context.Context, projectID, query string, stmts []*contentdata.ScriptStatement, onError func(int, error) error) error {
+	project, err := s.metaRepo.FindProject(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return fmt.Errorf("project %s is not found", projectID)
+	}
+	ctx = withCatalogDefinitions(ctx)
+	conn, err := s.connMgr.Connection(ctx, projectID, "")
+	if err != nil {
+		return err
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.RollbackIfNotCommitted()
+	jobID := randomID()
+	startTime := time.Now()
+	// the statements have no child job because the init SQL is not the job.
+	ctx, sc := s.newScript(ctx, tx, project, "", nil)
+	sc.onError = onError
+	response, err := sc.run(ctx, stmts)
+	s.logQuery(ctx, jobID, query, nil, response, time.Since(startTime), err)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if response.ChangedCatalog.Changed() {
+		return syncCatalog(ctx, s, response.ChangedCatalog)
+	}
+	return nil
+}
@@ -2,8 +2,14 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
 
 	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
 	"github.com/goccy/bigquery-emulator/types"
 )
 
@@ -26,34 +32,56 @@ func (s *Server) addProject(ctx context.Context, project *types.Project) error {
 		return err
 	}
 	defer tx.RollbackIfNotCommitted()
+	found, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), project.ID)
+	if err != nil {
+		return err
+	}
 	for _, dataset := range project.Datasets {
+		if dataset.Location != "" {
+			location, ok := normalizeLocation(dataset.Location)
+			if !ok {
+				return fmt.Errorf("invalid location %s of dataset %s", dataset.Location, dataset.ID)
+			}
+			dataset.Location = location
+		}
+		var existing *metadata.Dataset
+		if found != nil {
+			existing = found.Dataset(dataset.ID)
+		}
 		for _, table := range dataset.Tables {
 			table.SetupMetadata(project.ID, dataset.ID)
+			if existing != nil && existing.Table(table.ID) != nil {
+				// the table restored from the database file keeps its schema and rows.
+				continue
+			}
 			if err := s.addTableData(ctx, tx, project, dataset, table); err != nil {
 				return err
 			}
 		}
+		for _, routine := range dataset.Routines {
+			if existing != nil && existing.Routine(routine.ID) != nil {
+				continue
+			}
+			if err := s.addRoutineData(ctx, tx, project, dataset, routine); err != nil {
+				return err
+			}
+		}
 	}
 	p := s.metaRepo.ProjectFromData(project)
-	found, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), p.ID)
-	if err != nil {
-		return err
-	}
 	if found != nil {
-		if err := s.metaRepo.UpdateProject(ctx, tx.Tx(), p); err != nil {
+		// keep the datasets, tables, routines and jobs created at runtime ( e.g. restored from the database file ).
+		if err := s.mergeProject(ctx, tx, found, p); err != nil {
 			return err
 		}
 	} else {
 		if err := s.metaRepo.AddProjectIfNotExists(ctx, tx.Tx(), p); err != nil {
 			return err
 		}
-	}
-	for _, dataset := range p.Datasets() {
-		if err := dataset.Insert(ctx, tx.Tx()); err != nil {
-			return err
-		}
-		for _, table := range dataset.Tables() {
-			if err := table.Insert(ctx, tx.Tx()); err != nil {
+		for _, dataset := range p.Datasets() {
+			if err := dataset.Insert(ctx, tx.Tx()); err != nil {
+				return err
+			}
+			if err := s.insertDatasetContents(ctx, tx, dataset); err != nil {
 				return err
 			}
 		}
@@ -73,3 +101,85 @@ func (s *Server) addTableData(ctx context.Context, tx *connection.Tx, project *t
 	}
 	return nil
 }
+
+// addRoutineData creates the function of the routine whose metadata has definitionBody, so the queries can call it.
+// The routine type and the language are SCALAR_FUNCTION and SQL if the metadata doesn't have them.
+func (s *Server) addRoutineData(ctx context.Context, tx *connection.Tx, project *types.Project, dataset *types.Dataset, routine *types.Routine) error {
+	if routine.Metadata == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(routine.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata of routine %s: %w", routine.ID, err)
+	}
+	var content bigqueryv2.Routine
+	if err := json.Unmarshal(encoded, &content); err != nil {
+		return fmt.Errorf("invalid metadata of routine %s: %w", routine.ID, err)
+	}
+	if content.DefinitionBody == "" {
+		return nil
+	}
+	content.RoutineReference = &bigqueryv2.RoutineReference{
+		ProjectId: project.ID,
+		DatasetId: dataset.ID,
+		RoutineId: routine.ID,
+	}
+	if content.RoutineType == "" {
+		content.RoutineType = string(contentdata.ScalarFunctionType)
+	}
+	if content.Language == "" {
+		content.Language = string(contentdata.LanguageTypeSQL)
+	}
+	if err := s.contentRepo.AddRoutineByMetaData(ctx, tx, &content); err != nil {
+		return fmt.Errorf("failed to create routine %s: %w", routine.ID, err)
+	}
+	return nil
+}
+
+func (s *Server) insertDatasetContents(ctx context.Context, tx *connection.Tx, dataset *metadata.Dataset) error {
+	for _, table := range dataset.Tables() {
+		if err := table.Insert(ctx, tx.Tx()); err != nil {
+			return err
+		}
+	}
+	for _, routine := range dataset.Routines() {
+		if err := routine.Insert(ctx, tx.Tx()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeProject adds the datasets, tables and routines of src that dst doesn't have yet.
+// The ones dst already has are kept as they are, so the seed doesn't overwrite the catalog restored from the database file.
+func (s *Server) mergeProject(ctx context.Context, tx *connection.Tx, dst, src *metadata.Project) error {
+	for _, dataset := range src.Datasets() {
+		found := dst.Dataset(dataset.ID)
+		if found == nil {
+			if err := dst.AddDataset(ctx, tx.Tx(), dataset); err != nil {
+				return err
+			}
+			if err := s.insertDatasetContents(ctx, tx, dataset); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, table := range dataset.Tables() {
+			if found.Table(table.ID) != nil {
+				continue
+			}
+			if err := found.AddTable(ctx, tx.Tx(), table); err != nil {
+				return err
+			}
+		}
+		for _, routine := range dataset.Routines() {
+			if found.Routine(routine.ID) != nil {
+				continue
+			}
+			if err := found.AddRoutine(ctx, tx.Tx(), routine); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
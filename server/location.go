@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+)
+
+// DefaultLocation is the location of the datasets created without location.
+const DefaultLocation = "US"
+
+// regionQualifierPrefix is the prefix of the region qualifier like `region-us`.INFORMATION_SCHEMA.JOBS.
+const regionQualifierPrefix = "region-"
+
+// locations is the list of the BigQuery locations.
+// Multi-regions are written in upper case and regions are written in lower case.
+var locations = []string{
+	"US", "EU",
+	"us-central1", "us-east1", "us-east4", "us-east5", "us-south1", "us-west1", "us-west2", "us-west3", "us-west4",
+	"northamerica-northeast1", "northamerica-northeast2", "northamerica-south1",
+	"southamerica-east1", "southamerica-west1",
+	"europe-central2", "europe-north1", "europe-southwest1", "europe-west1", "europe-west2", "europe-west3",
+	"europe-west4", "europe-west6", "europe-west8", "europe-west9", "europe-west10", "europe-west12",
+	"asia-east1", "asia-east2", "asia-northeast1", "asia-northeast2", "asia-northeast3", "asia-south1",
+	"asia-south2", "asia-southeast1", "asia-southeast2",
+	"australia-southeast1", "australia-southeast2",
+	"me-central1", "me-central2", "me-west1",
+	"africa-south1",
+}
+
+// normalizeLocation returns the canonical name of location. Locations are case-insensitive.
+// The multi-region US and the region us-central1 are different locations.
+func normalizeLocation(location string) (string, bool) {
+	for _, loc := range locations {
+		if strings.EqualFold(loc, location) {
+			return loc, true
+		}
+	}
+	return "", false
+}
+
+// isJobInLocation reports whether the job runs in the location requested by the location parameter of jobs API.
+// The job is found in any location if the parameter is not specified.
+func isJobInLocation(job *metadata.Job, location string) bool {
+	if location == "" {
+		return true
+	}
+	content := job.Content()
+	if content == nil || content.JobReference == nil || content.JobReference.Location == "" {
+		return true
+	}
+	return strings.EqualFold(content.JobReference.Location, location)
+}
+
+// SetDefaultLocation sets the location of the datasets created without location.
+func (s *Server) SetDefaultLocation(location string) error {
+	loc, ok := normalizeLocation(location)
+	if !ok {
+		return fmt.Errorf("invalid location: %s", location)
+	}
+	s.defaultLocation = loc
+	return nil
+}
+
+// datasetLocation returns the location of the dataset.
+// The datasets loaded from the data source without location are in the default location.
+func (s *Server) datasetLocation(dataset *metadata.Dataset) string {
+	if content := dataset.Content(); content != nil && content.Location != "" {
+		if loc, ok := normalizeLocation(content.Location); ok {
+			return loc
+		}
+		return content.Location
+	}
+	return s.defaultLocation
+}
+
+// queryLocation returns the location where the query runs.
+// The query runs in the requested location if specified, otherwise in the location of the first referenced dataset.
+// The unqualified table names are resolved with the default dataset.
+// As BigQuery does, the datasets outside of the location are not found.
+func (s *Server) queryLocation(ctx context.Context, project *metadata.Project, defaultDatasetID, location, query string) (string, error) {
+	jobLocation, err := requestedLocation(location)
+	if err != nil {
+		return "", err
+	}
+	for _, path := range contentdata.TableNames(query) {
+		if region, ok := regionQualifier(path); ok {
+			loc, ok := normalizeLocation(region)
+			if !ok {
+				return "", errInvalidQuery(fmt.Sprintf("Invalid region qualifier: %s%s", regionQualifierPrefix, region))
+			}
+			if jobLocation == "" {
+				jobLocation = loc
+			} else if loc != jobLocation {
+				return "", errInvalidQuery(fmt.Sprintf(
+					"Cannot read in location: %s while the query runs in location: %s", loc, jobLocation,
+				))
+			}
+			continue
+		}
+		dataset, _, err := s.resolveTableName(ctx, project, defaultDatasetID, path)
+		if err != nil {
+			return "", err
+		}
+		if jobLocation, err = s.datasetInLocation(dataset, jobLocation); err != nil {
+			return "", err
+		}
+	}
+	if jobLocation == "" && defaultDatasetID != "" {
+		if dataset := project.Dataset(defaultDatasetID); dataset != nil {
+			jobLocation = s.datasetLocation(dataset)
+		}
+	}
+	if jobLocation == "" {
+		jobLocation = s.defaultLocation
+	}
+	return jobLocation, nil
+}
+
+// copyLocation returns the location where the copy job runs.
+// The source tables and the destination table must be in the same location as the job.
+func (s *Server) copyLocation(ctx context.Context, project *metadata.Project, location string, copyConfig *bigqueryv2.JobConfigurationTableCopy) (string, error) {
+	jobLocation, err := requestedLocation(location)
+	if err != nil {
+		return "", err
+	}
+	refs := copyConfig.SourceTables
+	if copyConfig.SourceTable != nil {
+		refs = append([]*bigqueryv2.TableReference{copyConfig.SourceTable}, refs...)
+	}
+	if copyConfig.DestinationTable != nil {
+		refs = append(refs, copyConfig.DestinationTable)
+	}
+	for _, ref := range refs {
+		projectID := ref.ProjectId
+		if projectID == "" {
+			projectID = project.ID
+		}
+		dataset, _, err := s.resolveTableName(ctx, project, "", []string{projectID, ref.DatasetId, ref.TableId})
+		if err != nil {
+			return "", err
+		}
+		if jobLocation, err = s.datasetInLocation(dataset, jobLocation); err != nil {
+			return "", err
+		}
+	}
+	if jobLocation == "" {
+		jobLocation = s.defaultLocation
+	}
+	return jobLocation, nil
+}
+
+// requestedLocation returns the canonical name of the location requested by the job or empty if not specified.
+func requestedLocation(location string) (string, error) {
+	if location == "" {
+		return "", nil
+	}
+	loc, ok := normalizeLocation(location)
+	if !ok {
+		return "", errInvalid(fmt.Sprintf("Invalid location: %s", location))
+	}
+	return loc, nil
+}
+
+// datasetInLocation returns the location of the job reading the dataset.
+// The job runs in the location of the dataset if its location is not decided yet.
+// The dataset outside of the location of the job is not found.
+func (s *Server) datasetInLocation(dataset *metadata.Dataset, jobLocation string) (string, error) {
+	if dataset == nil {
+		return jobLocation, nil
+	}
+	loc := s.datasetLocation(dataset)
+	if jobLocation == "" {
+		return loc, nil
+	}
+	if loc != jobLocation {
+		return "", errNotFound(fmt.Sprintf(
+			"Not found: Dataset %s:%s was not found in location %s", dataset.ProjectID, dataset.ID, jobLocation,
+		))
+	}
+	return jobLocation, nil
+}
+
+// referencedDataset returns the dataset of the table name path or nil if the path doesn't refer to a dataset.
+func (s *Server) referencedDataset(ctx context.Context, project *metadata.Project, path []string) (*metadata.Dataset, error) {
+	if len(path) >= 3 && path[0] != project.ID {
+		if dataset := project.Dataset(path[0]); dataset != nil {
+			// dataset.table.column
+			return dataset, nil
+		}
+		p, err := s.metaRepo.FindProject(ctx, path[0])
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, nil
+		}
+		return p.Dataset(path[1]), nil
+	}
+	if len(path) >= 3 {
+		return project.Dataset(path[1]), nil
+	}
+	return project.Dataset(path[0]), nil
+}
+
+// regionQualifier returns the region of the name path qualified like `region-us`.INFORMATION_SCHEMA.JOBS.
+func regionQualifier(path []string) (string, bool) {
+	for _, name := range path {
+		if len(name) > len(regionQualifierPrefix) && strings.EqualFold(name[:len(regionQualifierPrefix)], regionQualifierPrefix) {
+			return name[len(regionQualifierPrefix):], true
+		}
+	}
+	return "", false
+}
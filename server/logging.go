@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+	"google.golang.org/grpc/status"
+
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/logger"
+	internaltypes "github.com/goccy/bigquery-emulator/internal/types"
+)
+
+// maxLoggedBodySize is the maximum size of the request body written to the debug log.
+// The longer body is truncated.
+const maxLoggedBodySize = 4 * 1024
+
+// SetLogQueryRedaction sets whether the literal values of the SQL written to the log are replaced with `?`.
+// If it is enabled, the query parameters, the request bodies, the result rows and the error messages are not logged either.
+func (s *Server) SetLogQueryRedaction(enabled bool) {
+	s.logQueryRedaction = enabled
+	s.contentRepo.SetLogRedaction(enabled)
+}
+
+// SetLogOutput sets the paths the log is written to. The path is a file path, stdout or stderr ( default ).
+func (s *Server) SetLogOutput(paths ...string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("log output is not specified")
+	}
+	s.loggerConfig.OutputPaths = paths
+	logger, err := s.loggerConfig.Build()
+	if err != nil {
+		return err
+	}
+	s.logger = logger
+	return nil
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// debugLogMiddleware writes the method, the path, the request body, the status and the latency
+// of each API call to the debug log. The headers are not logged to keep the auth tokens out of the log.
+func debugLogMiddleware(s *Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.logger.Core().Enabled(zap.DebugLevel) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			fields := []zap.Field{
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+			}
+			if jobID, exists := jobIDFromParams(mux.Vars(r)); exists {
+				fields = append(fields, zap.String("jobId", jobID))
+			}
+			if body := s.loggedRequestBody(r); body != "" {
+				fields = append(fields, zap.String("body", body))
+			}
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+			next.ServeHTTP(recorder, r)
+			fields = append(fields,
+				zap.Int("status", recorder.status),
+				zap.Duration("latency", time.Since(start)),
+			)
+			logger.Logger(r.Context()).Debug("api call", fields...)
+		})
+	}
+}
+
+// loggedRequestBody returns the request body written to the debug log.
+// The body is read up to maxLoggedBodySize and the rest is left to the handler.
+// The binary payload ( e.g. the upload of Parquet ) is logged as its content type and size.
+func (s *Server) loggedRequestBody(r *http.Request) string {
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+	contentType := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "application/json") {
+		return fmt.Sprintf("<%s: %d bytes>", contentType, r.ContentLength)
+	}
+	if s.logQueryRedaction {
+		// the body has the SQL and the parameters.
+		return fmt.Sprintf("<redacted: %d bytes>", r.ContentLength)
+	}
+	prefix, err := io.ReadAll(io.LimitReader(r.Body, maxLoggedBodySize+1))
+	r.Body = &loggedBody{
+		Reader: io.MultiReader(bytes.NewReader(prefix), r.Body),
+		Closer: r.Body,
+	}
+	if err != nil {
+		return ""
+	}
+	if len(prefix) > maxLoggedBodySize {
+		return truncateLogValue(string(prefix))
+	}
+	if !utf8.Valid(prefix) {
+		return fmt.Sprintf("<binary: %d bytes>", len(prefix))
+	}
+	return string(prefix)
+}
+
+type loggedBody struct {
+	io.Reader
+	io.Closer
+}
+
+func truncateLogValue(v string) string {
+	if len(v) <= maxLoggedBodySize {
+		return v
+	}
+	return fmt.Sprintf("%s...(truncated)", strings.ToValidUTF8(v[:maxLoggedBodySize], ""))
+}
+
+// logQuery writes the SQL of the query job, the parameters, the number of the result rows and the latency
+// to the debug log.
+func (s *Server) logQuery(ctx context.Context, jobID, query string, params []*bigqueryv2.QueryParameter, response *internaltypes.QueryResponse, latency time.Duration, err error) {
+	fields := []zap.Field{zap.String("jobId", jobID)}
+	if s.logQueryRedaction {
+		fields = append(fields,
+			zap.String("sql", truncateLogValue(contentdata.RedactQuery(query))),
+			zap.Int("parameters", len(params)),
+		)
+	} else {
+		fields = append(fields,
+			zap.String("sql", truncateLogValue(query)),
+			zap.Any("parameters", params),
+		)
+	}
+	if response != nil {
+		fields = append(fields, zap.Uint64("rows", response.TotalRows))
+	}
+	fields = append(fields, zap.Duration("latency", latency))
+	if err != nil {
+		if s.logQueryRedaction {
+			// the error message may quote the values of the query and the rows.
+			fields = append(fields, zap.String("error", redactError(err)))
+		} else {
+			fields = append(fields, zap.Error(err))
+		}
+	}
+	logger.Logger(ctx).Debug("query", fields...)
+}
+
+// redactError returns the error written to the log in place of err when the redaction is enabled.
+// It keeps only the reason of ServerError.
+func redactError(err error) string {
+	var serverErr *ServerError
+	if errors.As(err, &serverErr) {
+		return fmt.Sprintf("%s: <redacted>", serverErr.Reason)
+	}
+	return "<redacted>"
+}
+
+// logRPC writes the method, the status code and the latency of the gRPC call to the debug log.
+// The request is logged only for the unary calls, the streamed rows are not logged.
+func (s *Server) logRPC(method string, req interface{}, start time.Time, err error) {
+	if !s.logger.Core().Enabled(zap.DebugLevel) {
+		return
+	}
+	fields := []zap.Field{zap.String("method", method)}
+	if req != nil && !s.logQueryRedaction {
+		fields = append(fields, zap.String("request", truncateLogValue(fmt.Sprint(req))))
+	}
+	fields = append(fields,
+		zap.String("code", status.Code(err).String()),
+		zap.Duration("latency", time.Since(start)),
+	)
+	s.logger.Debug("rpc call", fields...)
+}
@@ -174,7 +174,14 @@ func withProjectMiddleware() func(http.Handler) http.Handler {
 					return
 				}
 				if project == nil {
-					errorResponse(ctx, w, errNotFound(fmt.Sprintf("project %s is not found", projectID)))
+					project, err = server.createProjectOnRequest(ctx, projectID)
+					if err != nil {
+						errorResponse(ctx, w, errInternalError(err.Error()))
+						return
+					}
+				}
+				if project == nil {
+					errorResponse(ctx, w, errNotFound(fmt.Sprintf("Not found: Project %s", projectID)))
 					return
 				}
 				ctx = withProject(ctx, project)
@@ -197,7 +204,7 @@ func withDatasetMiddleware() func(http.Handler) http.Handler {
 				project := projectFromContext(ctx)
 				dataset := project.Dataset(datasetID)
 				if dataset == nil {
-					errorResponse(ctx, w, errNotFound(fmt.Sprintf("dataset %s is not found", datasetID)))
+					errorResponse(ctx, w, errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", project.ID, datasetID)))
 					return
 				}
 				ctx = withDataset(ctx, dataset)
@@ -219,8 +226,8 @@ func withJobMiddleware() func(http.Handler) http.Handler {
 			if exists {
 				project := projectFromContext(ctx)
 				job := project.Job(jobID)
-				if job == nil {
-					errorResponse(ctx, w, errNotFound(fmt.Sprintf("job %s is not found", jobID)))
+				if job == nil || !isJobInLocation(job, r.URL.Query().Get("location")) {
+					errorResponse(ctx, w, errNotFound(fmt.Sprintf("Not found: Job %s:%s", project.ID, jobID)))
 					return
 				}
 				ctx = withJob(ctx, job)
@@ -243,7 +250,7 @@ func withTableMiddleware() func(http.Handler) http.Handler {
 				dataset := datasetFromContext(ctx)
 				table := dataset.Table(tableID)
 				if table == nil {
-					errorResponse(ctx, w, errNotFound(fmt.Sprintf("table %s is not found", tableID)))
+					errorResponse(ctx, w, errNotFound(fmt.Sprintf("Not found: Table %s:%s.%s", dataset.ProjectID, dataset.ID, tableID)))
 					return
 				}
 				ctx = withTable(ctx, table)
@@ -266,7 +273,7 @@ func withModelMiddleware() func(http.Handler) http.Handler {
 				dataset := datasetFromContext(ctx)
 				model := dataset.Model(modelID)
 				if model == nil {
-					errorResponse(ctx, w, errNotFound(fmt.Sprintf("model %s is not found", modelID)))
+					errorResponse(ctx, w, errNotFound(fmt.Sprintf("Not found: Model %s:%s.%s", dataset.ProjectID, dataset.ID, modelID)))
 					return
 				}
 				ctx = withModel(ctx, model)
@@ -289,7 +296,7 @@ func withRoutineMiddleware() func(http.Handler) http.Handler {
 				dataset := datasetFromContext(ctx)
 				routine := dataset.Routine(routineID)
 				if routine == nil {
-					errorResponse(ctx, w, errNotFound(fmt.Sprintf("routine %s is not found", routineID)))
+					errorResponse(ctx, w, errNotFound(fmt.Sprintf("Not found: Routine %s:%s.%s", dataset.ProjectID, dataset.ID, routineID)))
 					return
 				}
 				ctx = withRoutine(ctx, routine)
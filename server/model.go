@@ -0,0 +1,351 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+)
+
+// modelTypes maps the model_type option of CREATE MODEL to the model type of the model resource.
+var modelTypes = map[string]string{
+	"linear_reg":                     "LINEAR_REGRESSION",
+	"logistic_reg":                   "LOGISTIC_REGRESSION",
+	"kmeans":                         "KMEANS",
+	"matrix_factorization":           "MATRIX_FACTORIZATION",
+	"pca":                            "PCA",
+	"autoencoder":                    "AUTOENCODER",
+	"automl_classifier":              "AUTOML_CLASSIFIER",
+	"automl_regressor":               "AUTOML_REGRESSOR",
+	"boosted_tree_classifier":        "BOOSTED_TREE_CLASSIFIER",
+	"boosted_tree_regressor":         "BOOSTED_TREE_REGRESSOR",
+	"random_forest_classifier":       "RANDOM_FOREST_CLASSIFIER",
+	"random_forest_regressor":        "RANDOM_FOREST_REGRESSOR",
+	"dnn_classifier":                 "DNN_CLASSIFIER",
+	"dnn_regressor":                  "DNN_REGRESSOR",
+	"dnn_linear_combined_classifier": "DNN_LINEAR_COMBINED_CLASSIFIER",
+	"dnn_linear_combined_regressor":  "DNN_LINEAR_COMBINED_REGRESSOR",
+	"arima_plus":                     "ARIMA_PLUS",
+	"arima_plus_xreg":                "ARIMA_PLUS_XREG",
+	"contribution_analysis":          "CONTRIBUTION_ANALYSIS",
+	"transform_only":                 "TRANSFORM_ONLY",
+	"tensorflow":                     "TENSORFLOW",
+	"tensorflow_lite":                "TENSORFLOW_LITE",
+	"onnx":                           "ONNX",
+	"xgboost":                        "XGBOOST",
+}
+
+// supervisedModelTypes are the model types trained with the label columns.
+var supervisedModelTypes = map[string]bool{
+	"LINEAR_REGRESSION":              true,
+	"LOGISTIC_REGRESSION":            true,
+	"AUTOML_CLASSIFIER":              true,
+	"AUTOML_REGRESSOR":               true,
+	"BOOSTED_TREE_CLASSIFIER":        true,
+	"BOOSTED_TREE_REGRESSOR":         true,
+	"RANDOM_FOREST_CLASSIFIER":       true,
+	"RANDOM_FOREST_REGRESSOR":        true,
+	"DNN_CLASSIFIER":                 true,
+	"DNN_REGRESSOR":                  true,
+	"DNN_LINEAR_COMBINED_CLASSIFIER": true,
+	"DNN_LINEAR_COMBINED_REGRESSOR":  true,
+}
+
+// execModelStatement evaluates CREATE MODEL, ALTER MODEL, DROP MODEL and EXPORT MODEL as the metadata operations.
+// The model is not trained, so CREATE MODEL only validates the training query and records its columns.
+// EXPORT MODEL fails with the notImplemented error because there is no trained model to export.
+func (s *Server) execModelStatement(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, stmt *contentdata.ModelStatement, params []*bigqueryv2.QueryParameter) error {
+	path := stmt.ModelPath
+	if len(path) == 3 && path[0] != project.ID {
+		p, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), path[0])
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return fmt.Errorf("project %s is not found", path[0])
+		}
+		project = p
+	}
+	modelID := path[len(path)-1]
+	if len(path) >= 2 {
+		datasetID = path[len(path)-2]
+	}
+	if datasetID == "" {
+		return fmt.Errorf("Model %q must be qualified with a dataset (e.g. dataset.model)", modelID)
+	}
+	dataset := project.Dataset(datasetID)
+	if dataset == nil {
+		return errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", project.ID, datasetID))
+	}
+	model := dataset.Model(modelID)
+	if stmt.Kind != contentdata.ModelCreate && model == nil {
+		if stmt.IfExists {
+			return nil
+		}
+		return errNotFound(fmt.Sprintf("Not found: Model %s:%s.%s", project.ID, datasetID, modelID))
+	}
+	switch stmt.Kind {
+	case contentdata.ModelAlter:
+		content, err := model.Content()
+		if err != nil {
+			return err
+		}
+		if err := alterModelOptions(content, stmt.Options); err != nil {
+			return err
+		}
+		content.LastModifiedTime = time.Now().UnixMilli()
+		return model.SetContent(ctx, tx.Tx(), content)
+	case contentdata.ModelDrop:
+		return dataset.DeleteModel(ctx, tx.Tx(), modelID)
+	case contentdata.ModelExport:
+		return errNotImplemented("Unsupported feature: EXPORT MODEL is not supported by the emulator")
+	}
+	if model != nil {
+		switch {
+		case stmt.IfNotExists:
+			return nil
+		case !stmt.OrReplace:
+			return errDuplicate(fmt.Sprintf("Already Exists: Model %s:%s.%s", project.ID, datasetID, modelID))
+		}
+	}
+	content, err := modelFromStatement(project.ID, datasetID, modelID, stmt)
+	if err != nil {
+		return err
+	}
+	if datasetContent := dataset.Content(); datasetContent != nil {
+		content.Location = datasetContent.Location
+	}
+	if stmt.Query != "" {
+		// the training query is validated by reading no rows of it.
+		response, err := s.execQuery(ctx, tx, project, datasetID, fmt.Sprintf("SELECT * FROM (%s) LIMIT 0", stmt.Query), params)
+		if err != nil {
+			return fmt.Errorf("invalid training query of model %s: %w", modelID, err)
+		}
+		if err := setModelColumns(content, stmt.Options, response.Schema.Fields); err != nil {
+			return err
+		}
+	}
+	if model != nil {
+		if err := dataset.DeleteModel(ctx, tx.Tx(), modelID); err != nil {
+			return err
+		}
+	}
+	newModel, err := metadata.NewModelWithContent(s.metaRepo, project.ID, datasetID, modelID, content)
+	if err != nil {
+		return err
+	}
+	if err := dataset.AddModel(ctx, tx.Tx(), newModel); err != nil {
+		if errors.Is(err, metadata.ErrDuplicatedModel) {
+			return errDuplicate(fmt.Sprintf("Already Exists: Model %s:%s.%s", project.ID, datasetID, modelID))
+		}
+		return err
+	}
+	return nil
+}
+
+// modelFromStatement creates the model resource with the options of CREATE MODEL statement.
+// The training options like l2_reg only affect the training, so they are accepted and ignored.
+func modelFromStatement(projectID, datasetID, modelID string, stmt *contentdata.ModelStatement) (*bigqueryv2.Model, error) {
+	now := time.Now().UnixMilli()
+	model := &bigqueryv2.Model{
+		ModelReference: &bigqueryv2.ModelReference{
+			ProjectId: projectID,
+			DatasetId: datasetID,
+			ModelId:   modelID,
+		},
+		CreationTime:     now,
+		LastModifiedTime: now,
+	}
+	for _, opt := range stmt.Options {
+		if opt.Value == nil {
+			continue
+		}
+		switch opt.Name {
+		case "model_type":
+			v, err := stringOption(opt)
+			if err != nil {
+				return nil, err
+			}
+			typ, ok := modelTypes[strings.ToLower(v)]
+			if !ok {
+				return nil, fmt.Errorf("Invalid model_type: %s", v)
+			}
+			model.ModelType = typ
+		case "description", "friendly_name", "labels", "expiration_timestamp", "kms_key_name":
+			if err := setModelOption(model, opt); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if model.ModelType == "" && !stmt.Remote {
+		return nil, fmt.Errorf("model_type option must be specified in CREATE MODEL")
+	}
+	if stmt.Query == "" && supervisedModelTypes[model.ModelType] {
+		return nil, fmt.Errorf("CREATE MODEL of %s requires the training query", model.ModelType)
+	}
+	return model, nil
+}
+
+// alterModelOptions applies the options of ALTER MODEL SET OPTIONS to model.
+func alterModelOptions(model *bigqueryv2.Model, options []*contentdata.Option) error {
+	for _, opt := range options {
+		switch opt.Name {
+		case "description", "friendly_name", "labels", "expiration_timestamp", "kms_key_name":
+			if err := setModelOption(model, opt); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("ALTER MODEL SET OPTIONS does not support the option %s", opt.Name)
+		}
+	}
+	return nil
+}
+
+// setModelOption sets the metadata option of the model. The NULL value clears the option.
+func setModelOption(model *bigqueryv2.Model, opt *contentdata.Option) error {
+	switch opt.Name {
+	case "description":
+		if opt.Value == nil {
+			model.Description = ""
+			return nil
+		}
+		v, err := stringOption(opt)
+		if err != nil {
+			return err
+		}
+		model.Description = v
+	case "friendly_name":
+		if opt.Value == nil {
+			model.FriendlyName = ""
+			return nil
+		}
+		v, err := stringOption(opt)
+		if err != nil {
+			return err
+		}
+		model.FriendlyName = v
+	case "labels":
+		if opt.Value == nil {
+			model.Labels = nil
+			return nil
+		}
+		labels, err := labelsOption(opt)
+		if err != nil {
+			return err
+		}
+		model.Labels = labels
+	case "expiration_timestamp":
+		if opt.Value == nil {
+			model.ExpirationTime = 0
+			return nil
+		}
+		v, err := stringOption(opt)
+		if err != nil {
+			return fmt.Errorf("expiration_timestamp option must be TIMESTAMP")
+		}
+		t, err := parseTimestampOption(v)
+		if err != nil {
+			return err
+		}
+		model.ExpirationTime = t.UnixMilli()
+	case "kms_key_name":
+		if opt.Value == nil {
+			model.EncryptionConfiguration = nil
+			return nil
+		}
+		v, err := stringOption(opt)
+		if err != nil {
+			return err
+		}
+		model.EncryptionConfiguration = &bigqueryv2.EncryptionConfiguration{KmsKeyName: v}
+	}
+	return nil
+}
+
+// parseTimestampOption parses the TIMESTAMP literal of the option like '2024-01-01 00:00:00 UTC'.
+func parseTimestampOption(v string) (time.Time, error) {
+	v = strings.TrimSuffix(strings.TrimSpace(v), " UTC")
+	for _, layout := range []string{
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999Z07",
+		"2006-01-02T15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02",
+	} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid TIMESTAMP value %q of expiration_timestamp option", v)
+}
+
+// setModelColumns records the columns of the training query as the label and the feature columns of the model.
+// The label columns of the supervised model are input_label_cols option, or the column named label.
+func setModelColumns(model *bigqueryv2.Model, options []*contentdata.Option, fields []*bigqueryv2.TableFieldSchema) error {
+	labels := map[string]bool{}
+	if supervisedModelTypes[model.ModelType] {
+		labelCols := []string{"label"}
+		for _, opt := range options {
+			if opt.Name != "input_label_cols" || opt.Value == nil {
+				continue
+			}
+			elems, ok := opt.Value.([]interface{})
+			if !ok {
+				return fmt.Errorf("input_label_cols option must be ARRAY<STRING>")
+			}
+			labelCols = labelCols[:0]
+			for _, elem := range elems {
+				col, ok := elem.(string)
+				if !ok {
+					return fmt.Errorf("input_label_cols option must be ARRAY<STRING>")
+				}
+				labelCols = append(labelCols, col)
+			}
+		}
+		for _, col := range labelCols {
+			field := schemaField(&bigqueryv2.TableSchema{Fields: fields}, col)
+			if field == nil {
+				return fmt.Errorf("Label column %s not found in the training data", col)
+			}
+			labels[strings.ToLower(field.Name)] = true
+		}
+	}
+	for _, field := range fields {
+		column := &bigqueryv2.StandardSqlField{
+			Name: field.Name,
+			Type: &bigqueryv2.StandardSqlDataType{TypeKind: standardSQLTypeKind(field)},
+		}
+		if labels[strings.ToLower(field.Name)] {
+			model.LabelColumns = append(model.LabelColumns, column)
+		} else {
+			model.FeatureColumns = append(model.FeatureColumns, column)
+		}
+	}
+	return nil
+}
+
+// standardSQLTypeKind returns the type kind of the field in the standard SQL data type.
+func standardSQLTypeKind(field *bigqueryv2.TableFieldSchema) string {
+	if field.Mode == "REPEATED" {
+		return "ARRAY"
+	}
+	switch field.Type {
+	case "INTEGER":
+		return "INT64"
+	case "FLOAT":
+		return "FLOAT64"
+	case "BOOLEAN":
+		return "BOOL"
+	case "RECORD":
+		return "STRUCT"
+	}
+	return field.Type
+}
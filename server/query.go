@@ -0,0 +1,731 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-zetasqlite"
+	"go.uber.org/zap"
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+	internaltypes "github.com/goccy/bigquery-emulator/internal/types"
+)
+
+// execQuery executes the query of jobs.insert and jobs.query.
+// The statements that go-zetasqlite doesn't support but that only change the metadata
+// ( e.g. CREATE SCHEMA ) are evaluated by the emulator, and the others are passed to go-zetasqlite
+// after the INFORMATION_SCHEMA views are expanded.
+// IF EXISTS and IF NOT EXISTS of the other DDL statements are evaluated by the emulator beforehand.
+// The script with the variables or the dynamic SQL is evaluated statement by statement by execScript.
+// The hints like `@{join_method=HASH_JOIN}` are accepted and ignored.
+// EXTERNAL_QUERY is validated but fails because the emulator has no external data source.
+// The time_zone connection property is the time zone of the functions of the current time like @@time_zone.
+// Each statement is rolled back alone if it fails, so the statements of the script before the failed one are kept
+// in the same way as BigQuery commits each statement of the script.
+func (s *Server) execQuery(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID, query string, params []*bigqueryv2.QueryParameter) (*internaltypes.QueryResponse, error) {
+	query, hints := contentdata.StripHints(query)
+	if len(hints) != 0 {
+		s.logger.Debug("ignored query hints", zap.Strings("hints", hints))
+	}
+	scriptStmts, err := contentdata.ParseScript(query)
+	if err != nil {
+		return nil, err
+	}
+	if scriptStmts != nil {
+		return s.execScript(ctx, tx, project, datasetID, scriptStmts, params)
+	}
+	var response *internaltypes.QueryResponse
+	if err := tx.RunStatement(ctx, func() error {
+		var err error
+		response, err = s.execStatement(ctx, tx, project, datasetID, query, params)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// execStatement executes the statement of the query that is not the script.
+func (s *Server) execStatement(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID, query string, params []*bigqueryv2.QueryParameter) (*internaltypes.QueryResponse, error) {
+	if timeZone := connectionPropertiesFromContext(ctx).timeZone; timeZone != "" && timeZone != defaultScriptTimeZone {
+		query = contentdata.ApplyTimeZone(query, timeZone)
+	}
+	if err := s.sharedDatasetQueryError(project.ID, datasetID, query); err != nil {
+		return nil, err
+	}
+	schemaStmt, err := contentdata.ParseSchemaStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	if schemaStmt != nil {
+		if err := s.execSchemaStatement(ctx, tx, project, schemaStmt); err != nil {
+			return nil, err
+		}
+		return emptyQueryResponse(), nil
+	}
+	searchIndexStmt, err := contentdata.ParseSearchIndexStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	if searchIndexStmt != nil {
+		if err := s.execSearchIndexStatement(ctx, tx, project, datasetID, searchIndexStmt); err != nil {
+			return nil, err
+		}
+		return emptyQueryResponse(), nil
+	}
+	rowAccessPolicyStmt, err := contentdata.ParseRowAccessPolicyStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	if rowAccessPolicyStmt != nil {
+		if err := s.execRowAccessPolicyStatement(ctx, tx, project, datasetID, rowAccessPolicyStmt); err != nil {
+			return nil, err
+		}
+		return emptyQueryResponse(), nil
+	}
+	modelStmt, err := contentdata.ParseModelStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	if modelStmt != nil {
+		if err := s.execModelStatement(ctx, tx, project, datasetID, modelStmt, params); err != nil {
+			return nil, err
+		}
+		return emptyQueryResponse(), nil
+	}
+	tableFunctionStmt, err := contentdata.ParseTableFunctionStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	if tableFunctionStmt != nil {
+		if err := s.execTableFunctionStatement(ctx, tx, project, datasetID, tableFunctionStmt); err != nil {
+			return nil, err
+		}
+		return emptyQueryResponse(), nil
+	}
+	externalTableStmt, err := contentdata.ParseExternalTableStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	if externalTableStmt != nil {
+		if err := s.execExternalTableStatement(ctx, tx, project, datasetID, externalTableStmt); err != nil {
+			return nil, err
+		}
+		return emptyQueryResponse(), nil
+	}
+	alterTableStmt, err := contentdata.ParseAlterTableStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	if alterTableStmt != nil {
+		if err := s.execAlterTableStatement(ctx, tx, project, datasetID, alterTableStmt); err != nil {
+			return nil, err
+		}
+		return emptyQueryResponse(), nil
+	}
+	ddlStmt, err := contentdata.ParseDDLStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	if ddlStmt != nil {
+		done, err := s.execDDLStatement(ctx, tx, project, datasetID, ddlStmt)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return emptyQueryResponse(), nil
+		}
+	}
+	if s.generativeAIResponse != "" {
+		query, err = s.stubGenerativeAIFunctions(ctx, tx, project, datasetID, query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if name := contentdata.MLFunctionName(query); name != "" {
+		msg := fmt.Sprintf("Unsupported feature: %s is not supported by the emulator", name)
+		if contentdata.IsGenerativeAIFunction(name) {
+			msg += ". Specify the canned response by --generative-ai-response to stub it"
+		}
+		return nil, errNotImplemented(msg)
+	}
+	if err := s.checkExternalQuery(ctx, tx, project, query); err != nil {
+		return nil, err
+	}
+	query, clause := contentdata.StripDifferentialPrivacy(query)
+	if clause != "" && !s.differentialPrivacyPassthrough {
+		return nil, errNotImplemented(fmt.Sprintf(
+			"Unsupported feature: SELECT WITH %s is not supported by the emulator. "+
+				"Enable the differential privacy passthrough to run the query as the ordinary aggregation",
+			clause,
+		))
+	}
+	if err := s.checkDatasetAccess(ctx, project, datasetID, query); err != nil {
+		return nil, err
+	}
+	query, err = s.expandTableFunctions(ctx, tx, project, datasetID, query)
+	if err != nil {
+		return nil, err
+	}
+	query, err = s.expandInformationSchema(ctx, tx, project, datasetID, query)
+	if err != nil {
+		return nil, err
+	}
+	query, err = s.loadExternalTables(ctx, tx, project, query)
+	if err != nil {
+		return nil, err
+	}
+	query, err = s.applyColumnCollations(ctx, project, datasetID, query)
+	if err != nil {
+		return nil, err
+	}
+	// the common table expressions are evaluated after the external tables are loaded and the collations are applied,
+	// so they read the same tables with the same comparisons as the statement.
+	query, params, drop, err := s.materializeWithClause(ctx, tx, project.ID, datasetID, query, params)
+	if err != nil {
+		return nil, err
+	}
+	defer drop()
+	response, err := s.contentRepo.QueryWithLimit(ctx, tx, project.ID, datasetID, query, params, resultLimitFromContext(ctx))
+	if errors.Is(err, contentdata.ErrResponseTooLarge) {
+		return nil, errResponseTooLarge(err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ddlStmt != nil {
+		catalogDefinitionsFromContext(ctx).add(project.ID, datasetID, ddlStmt)
+	}
+	return response, nil
+}
+
+// SetDifferentialPrivacyPassthrough sets whether the queries with SELECT WITH DIFFERENTIAL_PRIVACY ( or ANONYMIZATION )
+// run as the ordinary aggregations without noise and contribution bounds.
+// If it is disabled ( default ), the queries fail with the notImplemented error.
+func (s *Server) SetDifferentialPrivacyPassthrough(enabled bool) {
+	s.differentialPrivacyPassthrough = enabled
+}
+
+// SetGenerativeAIResponse makes ML.GENERATE_TEXT, AI.GENERATE_TEXT and AI.GENERATE return response
+// instead of the inference, where `{prompt}` in response is replaced with the prompt.
+// If it is empty ( default ), the queries calling them fail with the notImplemented error.
+func (s *Server) SetGenerativeAIResponse(response string) {
+	s.generativeAIResponse = response
+}
+
+// stubGenerativeAIFunctions replaces the generative AI functions of query with the stubs of the canned response.
+// The models of ML.GENERATE_TEXT and AI.GENERATE_TEXT must exist.
+func (s *Server) stubGenerativeAIFunctions(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID, query string) (string, error) {
+	stubbed, models, err := contentdata.RewriteGenerativeAIFunctions(query, s.generativeAIResponse)
+	if err != nil {
+		return "", errInvalidQuery(err.Error())
+	}
+	for _, path := range models {
+		modelProject, modelDatasetID, modelID := project, datasetID, path[len(path)-1]
+		if len(path) >= 2 {
+			modelDatasetID = path[len(path)-2]
+		}
+		if len(path) == 3 && path[0] != project.ID {
+			p, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), path[0])
+			if err != nil {
+				return "", err
+			}
+			if p == nil {
+				return "", errNotFound(fmt.Sprintf("Not found: Project %s", path[0]))
+			}
+			modelProject = p
+		}
+		dataset := modelProject.Dataset(modelDatasetID)
+		if dataset == nil {
+			return "", errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", modelProject.ID, modelDatasetID))
+		}
+		if dataset.Model(modelID) == nil {
+			return "", errNotFound(fmt.Sprintf("Not found: Model %s:%s.%s", modelProject.ID, modelDatasetID, modelID))
+		}
+	}
+	return stubbed, nil
+}
+
+// SetMaxResultRows sets the maximum number of rows that a query can return.
+// The query returning more rows fails with the responseTooLarge error unless its result is written to the destination table.
+// Zero ( default ) means no limit.
+func (s *Server) SetMaxResultRows(n int64) {
+	s.resultLimit.Rows = n
+}
+
+// SetMaxResultBytes sets the maximum size of the result that a query can return, like the 10MB response limit of BigQuery.
+// The query returning the larger result fails with the responseTooLarge error unless its result is written to the destination table.
+// Zero ( default ) means no limit.
+func (s *Server) SetMaxResultBytes(n int64) {
+	s.resultLimit.Bytes = n
+}
+
+// SetRandomSeed makes GENERATE_UUID and RAND deterministic for the reproducible tests.
+// The same query returns the same values for the same seed, while the values still differ from row to row.
+// The seeded functions are registered on the connection of each query, so the seeded queries run concurrently.
+func (s *Server) SetRandomSeed(seed int64) {
+	s.contentRepo.SetRandomSeed(seed)
+}
+
+// statementType returns the statement type reported in the job statistics.
+func statementType(query string) string {
+	if contentdata.IsScript(query) {
+		return "SCRIPT"
+	}
+	if typ := contentdata.DMLStatementType(query); typ != "" {
+		return typ
+	}
+	return "SELECT"
+}
+
+// emptyQueryResponse returns the result of the statement that returns no rows.
+func emptyQueryResponse() *internaltypes.QueryResponse {
+	return &internaltypes.QueryResponse{
+		Schema:      &bigqueryv2.TableSchema{Fields: []*bigqueryv2.TableFieldSchema{}},
+		JobComplete: true,
+		Rows:        []*internaltypes.TableRow{},
+		ChangedCatalog: &zetasqlite.ChangedCatalog{
+			Table:    &zetasqlite.ChangedTable{},
+			Function: &zetasqlite.ChangedFunction{},
+		},
+	}
+}
+
+func (s *Server) execSchemaStatement(ctx context.Context, tx *connection.Tx, project *metadata.Project, stmt *contentdata.SchemaStatement) error {
+	if stmt.ProjectID != "" && stmt.ProjectID != project.ID {
+		p, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), stmt.ProjectID)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return fmt.Errorf("project %s is not found", stmt.ProjectID)
+		}
+		project = p
+	}
+	dataset := project.Dataset(stmt.DatasetID)
+	if stmt.Drop {
+		if dataset == nil {
+			if stmt.IfExists {
+				return nil
+			}
+			return errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", project.ID, stmt.DatasetID))
+		}
+		return s.dropSchema(ctx, tx, project, dataset, stmt.Cascade)
+	}
+	content, err := datasetFromSchemaStatement(project.ID, stmt)
+	if err != nil {
+		return err
+	}
+	if content.Location == "" {
+		content.Location = s.defaultLocation
+	}
+	if dataset != nil {
+		switch {
+		case stmt.IfNotExists:
+			return nil
+		case stmt.OrReplace:
+			if err := s.dropSchema(ctx, tx, project, dataset, true); err != nil {
+				return err
+			}
+		default:
+			return errDuplicate(fmt.Sprintf("Already Exists: Dataset %s:%s", project.ID, stmt.DatasetID))
+		}
+	}
+	return project.AddDataset(
+		ctx,
+		tx.Tx(),
+		metadata.NewDataset(s.metaRepo, project.ID, stmt.DatasetID, content, nil, nil, nil),
+	)
+}
+
+func (s *Server) dropSchema(ctx context.Context, tx *connection.Tx, project *metadata.Project, dataset *metadata.Dataset, cascade bool) error {
+	tables := dataset.Tables()
+	if len(tables) != 0 && !cascade {
+		return fmt.Errorf("Dataset %s:%s is still in use. Use DROP SCHEMA ... CASCADE to drop the dataset with its tables", project.ID, dataset.ID)
+	}
+	if err := project.DeleteDataset(ctx, tx.Tx(), dataset.ID); err != nil {
+		return fmt.Errorf("failed to delete dataset: %w", err)
+	}
+	for _, table := range tables {
+		if err := table.Delete(ctx, tx.Tx()); err != nil {
+			return err
+		}
+	}
+	if len(tables) != 0 {
+		if err := s.contentRepo.DeleteTables(ctx, tx, project.ID, dataset.ID, dataset.TableIDs()); err != nil {
+			return fmt.Errorf("failed to delete tables: %w", err)
+		}
+	}
+	return nil
+}
+
+// execSearchIndexStatement stores ( or removes ) the search index in the table metadata.
+// SEARCH scans the table without the index, so the index only needs to be validated.
+func (s *Server) execSearchIndexStatement(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, stmt *contentdata.SearchIndexStatement) error {
+	table, err := s.statementTable(ctx, tx, project, datasetID, stmt.TablePath)
+	if err != nil {
+		return err
+	}
+	current, err := table.SearchIndex()
+	if err != nil {
+		return err
+	}
+	if stmt.Drop {
+		if current == nil || current.Name != stmt.Name {
+			if stmt.IfExists {
+				return nil
+			}
+			return errNotFound(fmt.Sprintf("Not found: Search index %s on table %s:%s.%s", stmt.Name, table.ProjectID, table.DatasetID, table.ID))
+		}
+		return table.SetSearchIndex(ctx, tx.Tx(), nil)
+	}
+	if current != nil {
+		if stmt.IfNotExists && current.Name == stmt.Name {
+			return nil
+		}
+		return errDuplicate(fmt.Sprintf("Already Exists: Table %s:%s.%s already has the search index %s", table.ProjectID, table.DatasetID, table.ID, current.Name))
+	}
+	content, err := table.Content()
+	if err != nil {
+		return err
+	}
+	index, err := searchIndexFromStatement(stmt, content.Schema)
+	if err != nil {
+		return err
+	}
+	return table.SetSearchIndex(ctx, tx.Tx(), index)
+}
+
+// execRowAccessPolicyStatement stores ( or removes ) the row access policies in the table metadata.
+// The filter predicate is validated against the table, but the rows are not filtered by the policies.
+func (s *Server) execRowAccessPolicyStatement(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, stmt *contentdata.RowAccessPolicyStatement) error {
+	table, err := s.statementTable(ctx, tx, project, datasetID, stmt.TablePath)
+	if err != nil {
+		return err
+	}
+	policies, err := table.RowAccessPolicies()
+	if err != nil {
+		return err
+	}
+	if stmt.DropAll {
+		if len(policies) == 0 {
+			return nil
+		}
+		return table.SetRowAccessPolicies(ctx, tx.Tx(), nil)
+	}
+	idx := -1
+	for i, policy := range policies {
+		if policy.Name == stmt.Name {
+			idx = i
+			break
+		}
+	}
+	if stmt.Drop {
+		if idx < 0 {
+			if stmt.IfExists {
+				return nil
+			}
+			return errNotFound(fmt.Sprintf("Not found: Row access policy %s on table %s:%s.%s", stmt.Name, table.ProjectID, table.DatasetID, table.ID))
+		}
+		return table.SetRowAccessPolicies(ctx, tx.Tx(), append(policies[:idx], policies[idx+1:]...))
+	}
+	if idx >= 0 && !stmt.OrReplace {
+		if stmt.IfNotExists {
+			return nil
+		}
+		return errDuplicate(fmt.Sprintf("Already Exists: Row access policy %s on table %s:%s.%s", stmt.Name, table.ProjectID, table.DatasetID, table.ID))
+	}
+	validation := fmt.Sprintf(
+		"SELECT 1 FROM `%s.%s.%s` WHERE (%s) LIMIT 0", table.ProjectID, table.DatasetID, table.ID, stmt.FilterPredicate,
+	)
+	if _, err := s.contentRepo.Query(ctx, tx, table.ProjectID, table.DatasetID, validation, nil); err != nil {
+		return fmt.Errorf("invalid filter predicate of row access policy %s: %w", stmt.Name, err)
+	}
+	now := time.Now().UnixMilli()
+	policy := &metadata.RowAccessPolicy{
+		Name:             stmt.Name,
+		FilterPredicate:  stmt.FilterPredicate,
+		Grantees:         stmt.Grantees,
+		CreationTime:     now,
+		LastModifiedTime: now,
+	}
+	if idx >= 0 {
+		policy.CreationTime = policies[idx].CreationTime
+		policies[idx] = policy
+	} else {
+		policies = append(policies, policy)
+	}
+	return table.SetRowAccessPolicies(ctx, tx.Tx(), policies)
+}
+
+// statementTable returns the table of the path like `dataset.table` in the statement such as CREATE SEARCH INDEX.
+// The path without the project or the dataset refers to the project and the default dataset of the query.
+func (s *Server) statementTable(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, path []string) (*metadata.Table, error) {
+	if len(path) == 3 && path[0] != project.ID {
+		p, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), path[0])
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, fmt.Errorf("project %s is not found", path[0])
+		}
+		project = p
+	}
+	tableID := path[len(path)-1]
+	if len(path) >= 2 {
+		datasetID = path[len(path)-2]
+	}
+	if datasetID == "" {
+		return nil, fmt.Errorf("Table %q must be qualified with a dataset (e.g. dataset.table)", tableID)
+	}
+	dataset := project.Dataset(datasetID)
+	if dataset == nil {
+		return nil, errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", project.ID, datasetID))
+	}
+	table := dataset.Table(tableID)
+	if table == nil {
+		return nil, errNotFound(fmt.Sprintf("Not found: Table %s:%s.%s", project.ID, datasetID, tableID))
+	}
+	return table, nil
+}
+
+// searchIndexFromStatement creates the search index with the columns and the options of CREATE SEARCH INDEX statement.
+func searchIndexFromStatement(stmt *contentdata.SearchIndexStatement, schema *bigqueryv2.TableSchema) (*metadata.SearchIndex, error) {
+	index := &metadata.SearchIndex{
+		Name:         stmt.Name,
+		Analyzer:     "LOG_ANALYZER",
+		CreationTime: time.Now().UnixMilli(),
+	}
+	if !stmt.AllColumns {
+		for _, column := range stmt.Columns {
+			field := schemaField(schema, column)
+			if field == nil {
+				return nil, fmt.Errorf("Column %s is not found in the table", column)
+			}
+			index.Columns = append(index.Columns, field.Name)
+		}
+	}
+	for _, opt := range stmt.Options {
+		if opt.Value == nil {
+			continue
+		}
+		switch opt.Name {
+		case "analyzer":
+			v, err := stringOption(opt)
+			if err != nil {
+				return nil, err
+			}
+			analyzer := strings.ToUpper(v)
+			switch analyzer {
+			case "LOG_ANALYZER", "NO_OP_ANALYZER", "PATTERN_ANALYZER":
+			default:
+				return nil, fmt.Errorf("invalid analyzer %q. analyzer must be 'LOG_ANALYZER', 'NO_OP_ANALYZER' or 'PATTERN_ANALYZER'", v)
+			}
+			index.Analyzer = analyzer
+		case "analyzer_options":
+			v, err := stringOption(opt)
+			if err != nil {
+				return nil, err
+			}
+			index.AnalyzerOptions = v
+		case "data_types":
+			elems, ok := opt.Value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("data_types option must be ARRAY<STRING>")
+			}
+			for _, elem := range elems {
+				typ, ok := elem.(string)
+				if !ok {
+					return nil, fmt.Errorf("data_types option must be ARRAY<STRING>")
+				}
+				index.DataTypes = append(index.DataTypes, strings.ToUpper(typ))
+			}
+		case "default_index_column_granularity":
+		default:
+			return nil, fmt.Errorf("unsupported search index option: %s", opt.Name)
+		}
+	}
+	return index, nil
+}
+
+// schemaField finds the top level field of schema by the case insensitive name.
+func schemaField(schema *bigqueryv2.TableSchema, name string) *bigqueryv2.TableFieldSchema {
+	if schema == nil {
+		return nil
+	}
+	for _, field := range schema.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return field
+		}
+	}
+	return nil
+}
+
+// datasetFromSchemaStatement creates the dataset resource with the options of CREATE SCHEMA statement.
+func datasetFromSchemaStatement(projectID string, stmt *contentdata.SchemaStatement) (*bigqueryv2.Dataset, error) {
+	dataset := &bigqueryv2.Dataset{
+		Kind: "bigquery#dataset",
+		Id:   fmt.Sprintf("%s:%s", projectID, stmt.DatasetID),
+		DatasetReference: &bigqueryv2.DatasetReference{
+			ProjectId: projectID,
+			DatasetId: stmt.DatasetID,
+		},
+		DefaultCollation: stmt.DefaultCollation,
+	}
+	for _, opt := range stmt.Options {
+		if opt.Value == nil {
+			continue
+		}
+		switch opt.Name {
+		case "location":
+			v, err := stringOption(opt)
+			if err != nil {
+				return nil, err
+			}
+			location, ok := normalizeLocation(v)
+			if !ok {
+				return nil, errInvalid(fmt.Sprintf("Invalid dataset location: %s", v))
+			}
+			dataset.Location = location
+		case "description":
+			v, err := stringOption(opt)
+			if err != nil {
+				return nil, err
+			}
+			dataset.Description = v
+		case "friendly_name":
+			v, err := stringOption(opt)
+			if err != nil {
+				return nil, err
+			}
+			dataset.FriendlyName = v
+		case "default_collation":
+			v, err := stringOption(opt)
+			if err != nil {
+				return nil, err
+			}
+			dataset.DefaultCollation = v
+		case "default_table_expiration_days":
+			ms, err := daysOptionToMillis(opt)
+			if err != nil {
+				return nil, err
+			}
+			dataset.DefaultTableExpirationMs = ms
+		case "default_partition_expiration_days":
+			ms, err := daysOptionToMillis(opt)
+			if err != nil {
+				return nil, err
+			}
+			dataset.DefaultPartitionExpirationMs = ms
+		case "max_time_travel_hours":
+			v, ok := opt.Value.(float64)
+			if !ok || v < 48 || v > 168 || int64(v)%24 != 0 {
+				return nil, fmt.Errorf("max_time_travel_hours must be a multiple of 24 between 48 and 168")
+			}
+			dataset.MaxTimeTravelHours = int64(v)
+		case "is_case_insensitive":
+			v, ok := opt.Value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("is_case_insensitive option must be BOOL")
+			}
+			dataset.IsCaseInsensitive = v
+		case "labels":
+			labels, err := labelsOption(opt)
+			if err != nil {
+				return nil, err
+			}
+			dataset.Labels = labels
+		default:
+			return nil, fmt.Errorf("unsupported schema option: %s", opt.Name)
+		}
+	}
+	return dataset, nil
+}
+
+func stringOption(opt *contentdata.Option) (string, error) {
+	v, ok := opt.Value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s option must be STRING", opt.Name)
+	}
+	return v, nil
+}
+
+func stringArrayOption(opt *contentdata.Option) ([]string, error) {
+	elems, ok := opt.Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s option must be ARRAY<STRING>", opt.Name)
+	}
+	values := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		v, ok := elem.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s option must be ARRAY<STRING>", opt.Name)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func boolOption(opt *contentdata.Option) (bool, error) {
+	v, ok := opt.Value.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s option must be BOOL", opt.Name)
+	}
+	return v, nil
+}
+
+func intOption(opt *contentdata.Option) (int64, error) {
+	v, ok := opt.Value.(float64)
+	if !ok || v != float64(int64(v)) {
+		return 0, fmt.Errorf("%s option must be INT64", opt.Name)
+	}
+	return int64(v), nil
+}
+
+func daysOptionToMillis(opt *contentdata.Option) (int64, error) {
+	days, ok := opt.Value.(float64)
+	if !ok || days <= 0 {
+		return 0, fmt.Errorf("%s option must be a positive FLOAT64", opt.Name)
+	}
+	return int64(days * 24 * 60 * 60 * 1000), nil
+}
+
+// labelsOption converts the labels option like [("key", "value")] into the map.
+func labelsOption(opt *contentdata.Option) (map[string]string, error) {
+	elems, ok := opt.Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("labels option must be ARRAY<STRUCT<STRING, STRING>>")
+	}
+	labels := map[string]string{}
+	for _, elem := range elems {
+		kv, ok := elem.([]interface{})
+		if !ok || len(kv) != 2 {
+			return nil, fmt.Errorf("labels option must be ARRAY<STRUCT<STRING, STRING>>")
+		}
+		key, ok := kv[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("label key must be STRING")
+		}
+		var value string
+		switch v := kv[1].(type) {
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("label value must be STRING")
+		}
+		if key == "" || strings.ToLower(key) != key {
+			return nil, fmt.Errorf("invalid label key %q: keys must be non-empty and lowercase", key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
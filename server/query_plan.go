@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+	internaltypes "github.com/goccy/bigquery-emulator/internal/types"
+)
+
+// queryPlanInput is the clauses of the statement and the tables it reads, resolved by the metadata before the statement runs.
+type queryPlanInput struct {
+	clauses *contentdata.QueryPlanClauses
+	tables  []string
+	// counted are the tables whose rows are the records read by the statement. The views and the external tables
+	// are not counted because their rows are computed while the query runs.
+	counted []string
+	// target is the counted table changed by the DML statement.
+	target string
+}
+
+// queryPlanInput returns the input of the query plan of query. The unqualified table names are resolved with datasetID.
+// It returns nil for the statement that has no query plan, such as a script or DDL.
+// The script has no query plan, and each statement of the script gives the query plan to its child job.
+func (s *Server) queryPlanInput(ctx context.Context, project *metadata.Project, datasetID, query string) *queryPlanInput {
+	clauses := contentdata.ParseQueryPlanClauses(query)
+	if clauses == nil {
+		return nil
+	}
+	input := &queryPlanInput{clauses: clauses}
+	targetKey := ""
+	if len(clauses.TargetPath) != 0 {
+		if dataset, tableID, err := s.resolveTableName(ctx, project, datasetID, clauses.TargetPath); err == nil && dataset != nil {
+			targetKey = fmt.Sprintf("%s.%s.%s", dataset.ProjectID, dataset.ID, tableID)
+		}
+	}
+	seen := map[string]struct{}{}
+	for _, path := range contentdata.TableNames(query) {
+		dataset, tableID, err := s.resolveTableName(ctx, project, datasetID, path)
+		if err != nil || dataset == nil {
+			continue
+		}
+		table := dataset.Table(tableID)
+		if table == nil {
+			continue
+		}
+		key := fmt.Sprintf("%s.%s.%s", dataset.ProjectID, dataset.ID, tableID)
+		if _, exists := seen[key]; exists {
+			continue
+		}
+		seen[key] = struct{}{}
+		input.tables = append(input.tables, key)
+		content, err := table.Content()
+		if err != nil || content.Type != string(DefaultTableType) {
+			continue
+		}
+		input.counted = append(input.counted, key)
+		if key == targetKey {
+			input.target = key
+		}
+	}
+	return input
+}
+
+// recordsRead returns the number of the rows the counted tables had before the statement ran.
+// The rows are counted by one query after the statement only when the query plan is made,
+// and the rows of the DML target are restored by the numbers of the rows the statement inserted and deleted.
+// It returns 0 if the rows can't be counted.
+func (s *Server) recordsRead(ctx context.Context, tx *connection.Tx, project *metadata.Project, input *queryPlanInput, response *internaltypes.QueryResponse) int64 {
+	if len(input.counted) == 0 {
+		return 0
+	}
+	counts := make([]string, 0, len(input.counted))
+	for _, key := range input.counted {
+		counts = append(counts, fmt.Sprintf("(SELECT COUNT(*) FROM `%s`)", key))
+	}
+	counted, err := s.contentRepo.Query(ctx, tx, project.ID, "", "SELECT "+strings.Join(counts, " + "), nil)
+	if err != nil || len(counted.Rows) != 1 || len(counted.Rows[0].F) != 1 {
+		return 0
+	}
+	v, ok := counted.Rows[0].F[0].V.(string)
+	if !ok {
+		return 0
+	}
+	rows, _ := strconv.ParseInt(v, 10, 64)
+	if input.target != "" && response.DmlStats != nil {
+		rows += response.DmlStats.DeletedRowCount - response.DmlStats.InsertedRowCount
+	}
+	return rows
+}
+
+// newQueryPlan returns the simplified query plan and the timeline of the statement.
+// The stages are derived from the clauses of the statement rather than the plan of the execution:
+// Input reads and filters the tables, Join+ joins them, Aggregate evaluates GROUP BY, DISTINCT or the aggregate functions,
+// and Output evaluates the analytic functions, ORDER BY and LIMIT and writes the result.
+// The number of the records is given to the records read by Input and the records written by Output.
+func (s *Server) newQueryPlan(ctx context.Context, tx *connection.Tx, project *metadata.Project, input *queryPlanInput, response *internaltypes.QueryResponse, startTime, endTime time.Time) ([]*bigqueryv2.ExplainQueryStage, []*bigqueryv2.QueryTimelineSample) {
+	clauses := input.clauses
+	var stages []*bigqueryv2.ExplainQueryStage
+	addStage := func(name string, steps ...*bigqueryv2.ExplainQueryStep) *bigqueryv2.ExplainQueryStage {
+		id := int64(len(stages))
+		stage := &bigqueryv2.ExplainQueryStage{
+			Id:                      id,
+			Name:                    fmt.Sprintf("S%02d: %s", id, name),
+			Status:                  "COMPLETE",
+			ParallelInputs:          1,
+			CompletedParallelInputs: 1,
+			StartMs:                 startTime.UnixMilli(),
+			EndMs:                   endTime.UnixMilli(),
+			SlotMs:                  endTime.Sub(startTime).Milliseconds(),
+		}
+		if id > 0 {
+			stage.InputStages = []int64{id - 1}
+			steps = append([]*bigqueryv2.ExplainQueryStep{
+				{Kind: "READ", Substeps: []string{fmt.Sprintf("FROM __stage%02d_output", id-1)}},
+			}, steps...)
+		}
+		stage.Steps = steps
+		stages = append(stages, stage)
+		return stage
+	}
+	nextOutput := func() *bigqueryv2.ExplainQueryStep {
+		return &bigqueryv2.ExplainQueryStep{Kind: "WRITE", Substeps: []string{fmt.Sprintf("TO __stage%02d_output", len(stages))}}
+	}
+
+	var inputSteps []*bigqueryv2.ExplainQueryStep
+	if len(input.tables) != 0 {
+		read := &bigqueryv2.ExplainQueryStep{Kind: "READ"}
+		for _, table := range input.tables {
+			read.Substeps = append(read.Substeps, "FROM "+table)
+		}
+		inputSteps = append(inputSteps, read)
+	}
+	if clauses.Filter != "" {
+		inputSteps = append(inputSteps, &bigqueryv2.ExplainQueryStep{Kind: "FILTER", Substeps: []string{clauses.Filter}})
+	}
+	inputStage := addStage("Input", append(inputSteps, nextOutput())...)
+	inputStage.RecordsRead = s.recordsRead(ctx, tx, project, input, response)
+	if len(clauses.Joins) != 0 {
+		addStage("Join+", &bigqueryv2.ExplainQueryStep{Kind: "JOIN", Substeps: clauses.Joins}, nextOutput())
+	}
+	if len(clauses.Aggregates) != 0 {
+		addStage("Aggregate", &bigqueryv2.ExplainQueryStep{Kind: "AGGREGATE", Substeps: clauses.Aggregates}, nextOutput())
+	}
+	var outputSteps []*bigqueryv2.ExplainQueryStep
+	if clauses.Analytic {
+		outputSteps = append(outputSteps, &bigqueryv2.ExplainQueryStep{Kind: "ANALYTIC_FUNCTION"})
+	}
+	if clauses.OrderBy != "" {
+		outputSteps = append(outputSteps, &bigqueryv2.ExplainQueryStep{Kind: "SORT", Substeps: []string{clauses.OrderBy}})
+	}
+	if clauses.Limit != "" {
+		outputSteps = append(outputSteps, &bigqueryv2.ExplainQueryStep{Kind: "LIMIT", Substeps: []string{clauses.Limit}})
+	}
+	written := int64(response.TotalRows)
+	write := nextOutput()
+	if clauses.Target != "" {
+		written = response.NumDmlAffectedRows
+		write.Substeps = []string{"TO " + clauses.Target}
+	}
+	outputStage := addStage("Output", append(outputSteps, write)...)
+	outputStage.RecordsWritten = written
+
+	elapsed := endTime.Sub(startTime).Milliseconds()
+	return stages, []*bigqueryv2.QueryTimelineSample{
+		{
+			ElapsedMs:      elapsed,
+			CompletedUnits: int64(len(stages)),
+			TotalSlotMs:    elapsed,
+		},
+	}
+}
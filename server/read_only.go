@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+)
+
+// SetReadOnly sets whether the server rejects the requests that change the data or the metadata
+// with the accessDenied error ( PermissionDenied for the Storage Write API ).
+// The queries, the Storage Read API and the dry-run jobs keep working.
+// The queries with DML, DDL ( including CREATE TEMP TABLE of the session ), CALL or EXECUTE IMMEDIATE are rejected,
+// but CREATE TEMP FUNCTION is allowed because the function lives only while the query runs.
+// The sources given to Load are loaded regardless of the mode.
+func (s *Server) SetReadOnly(enabled bool) {
+	s.readOnly = enabled
+}
+
+func readOnlyMiddleware(s *Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.readOnly && isMutatingRequest(r) {
+				errorResponse(r.Context(), w, errReadOnly(fmt.Sprintf("%s %s", r.Method, r.URL.Path)))
+				return
+			}
+			if len(s.sharedDatasets) != 0 && isMutatingRequest(r) {
+				params := mux.Vars(r)
+				projectID, _ := projectIDFromParams(params)
+				if datasetID, exists := datasetIDFromParams(params); exists && s.isSharedDataset(projectID, datasetID) {
+					errorResponse(r.Context(), w, errSharedDataset(projectID, datasetID))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isMutatingRequest reports whether the REST request changes the resources.
+// jobs.insert and jobs.query are checked by readOnlyJobError and readOnlyQueryError with their configuration.
+func isMutatingRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/upload/") {
+		return true
+	}
+	for _, suffix := range []string{"/jobs", "/queries", "/cancel", ":getIamPolicy", ":testIamPermissions"} {
+		if strings.HasSuffix(path, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// readOnlyJobError returns the error if the server is read-only and the job changes the data,
+// or the job writes the table of the shared dataset.
+func (s *Server) readOnlyJobError(job *bigqueryv2.Job) error {
+	if job.Configuration == nil || job.Configuration.DryRun {
+		return nil
+	}
+	config := job.Configuration
+	if err := s.sharedDatasetJobError(config); err != nil {
+		return err
+	}
+	if !s.readOnly {
+		return nil
+	}
+	switch {
+	case config.Load != nil:
+		return errReadOnly("load job")
+	case config.Copy != nil:
+		return errReadOnly("copy job")
+	case config.Query != nil:
+		if config.Query.DestinationTable != nil {
+			return errReadOnly("query job with the destination table")
+		}
+		return s.readOnlyQueryError(config.Query.Query, false)
+	}
+	return nil
+}
+
+// readOnlyQueryError returns the error if the server is read-only and the query changes the data.
+// The dry-run query is allowed because its changes are rolled back.
+func (s *Server) readOnlyQueryError(query string, dryRun bool) error {
+	if !s.readOnly || dryRun {
+		return nil
+	}
+	if typ := contentdata.MutatingStatementType(query); typ != "" {
+		return errReadOnly(fmt.Sprintf("%s statement", typ))
+	}
+	return nil
+}
+
+// readOnlyRPCError returns the error if the server is read-only and the RPC is of the Storage Write API.
+func (s *Server) readOnlyRPCError(fullMethod string) error {
+	if !s.readOnly || !strings.HasPrefix(fullMethod, "/google.cloud.bigquery.storage.v1.BigQueryWrite/") {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "Access Denied: %s is not allowed because the server is read-only", fullMethod)
+}
+
+func errReadOnly(operation string) *ServerError {
+	return errAccessDenied(fmt.Sprintf("Access Denied: %s is not allowed because the server is read-only", operation))
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+	"github.com/goccy/bigquery-emulator/types"
+)
+
+const (
+	allowFieldAdditionOption   = "ALLOW_FIELD_ADDITION"
+	allowFieldRelaxationOption = "ALLOW_FIELD_RELAXATION"
+)
+
+// splitPartitionDecorator splits the table id like `table$20240101` into the table id and the partition decorator.
+func splitPartitionDecorator(tableID string) (string, string) {
+	if idx := strings.Index(tableID, "$"); idx >= 0 {
+		return tableID[:idx], tableID[idx+1:]
+	}
+	return tableID, ""
+}
+
+// validateSchemaUpdateOptions validates schemaUpdateOptions of the load job or the query job.
+// BigQuery accepts them only with WRITE_APPEND, or with WRITE_TRUNCATE of the partition decorator.
+func validateSchemaUpdateOptions(options []string, writeDisposition, decorator string) error {
+	if len(options) == 0 {
+		return nil
+	}
+	for _, option := range options {
+		switch option {
+		case allowFieldAdditionOption, allowFieldRelaxationOption:
+		default:
+			return errInvalid(fmt.Sprintf("Invalid value for schemaUpdateOptions: %s", option))
+		}
+	}
+	if writeDisposition == writeAppendDisposition || (writeDisposition == writeTruncateDisposition && decorator != "") {
+		return nil
+	}
+	return errInvalid("Schema update options should only be specified with WRITE_APPEND disposition, or with WRITE_TRUNCATE disposition on a table partition.")
+}
+
+func hasSchemaUpdateOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// updatedSchema returns the schema of the destination table updated by the source schema with schemaUpdateOptions.
+// ALLOW_FIELD_ADDITION appends the source fields that the table doesn't have as NULLABLE or REPEATED fields,
+// and ALLOW_FIELD_RELAXATION makes the REQUIRED fields of the table NULLABLE
+// if the source field is NULLABLE or the source doesn't have the field.
+// The fields of RECORD are compared by the type, and the type changes are errors regardless of the options.
+// The second value reports whether any field is added or relaxed.
+func updatedSchema(tableName string, tableSchema, sourceSchema *bigqueryv2.TableSchema, options []string) (*bigqueryv2.TableSchema, bool, error) {
+	addition := hasSchemaUpdateOption(options, allowFieldAdditionOption)
+	relaxation := hasSchemaUpdateOption(options, allowFieldRelaxationOption)
+	schema := &bigqueryv2.TableSchema{}
+	tableFields := map[string]*bigqueryv2.TableFieldSchema{}
+	if tableSchema != nil {
+		for _, field := range tableSchema.Fields {
+			copied := *field
+			schema.Fields = append(schema.Fields, &copied)
+			tableFields[strings.ToLower(field.Name)] = &copied
+		}
+	}
+	if sourceSchema == nil {
+		sourceSchema = &bigqueryv2.TableSchema{}
+	}
+	sourceFields := map[string]*bigqueryv2.TableFieldSchema{}
+	var changed bool
+	for _, field := range sourceSchema.Fields {
+		sourceFields[strings.ToLower(field.Name)] = field
+		tableField, exists := tableFields[strings.ToLower(field.Name)]
+		if !exists {
+			if !addition {
+				return nil, false, errInvalid(fmt.Sprintf("Provided Schema does not match Table %s. Cannot add fields (field: %s)", tableName, field.Name))
+			}
+			if fieldMode(field) == string(types.RequiredMode) {
+				return nil, false, errInvalid(fmt.Sprintf("Provided Schema does not match Table %s. Cannot add required fields to an existing schema. (field: %s)", tableName, field.Name))
+			}
+			copied := *field
+			schema.Fields = append(schema.Fields, &copied)
+			changed = true
+			continue
+		}
+		if types.Type(tableField.Type).ZetaSQLTypeKind() != types.Type(field.Type).ZetaSQLTypeKind() {
+			return nil, false, errInvalid(fmt.Sprintf(
+				"Provided Schema does not match Table %s. Field %s has changed type from %s to %s",
+				tableName, tableField.Name, tableField.Type, field.Type,
+			))
+		}
+		tableMode, mode := fieldMode(tableField), fieldMode(field)
+		switch {
+		case tableMode == mode:
+		case tableMode == string(types.RequiredMode) && mode == string(types.NullableMode) && relaxation:
+			tableField.Mode = string(types.NullableMode)
+			changed = true
+		case tableMode == string(types.NullableMode) && mode == string(types.RequiredMode):
+			// the REQUIRED values are written to the NULLABLE field.
+		default:
+			return nil, false, errInvalid(fmt.Sprintf(
+				"Provided Schema does not match Table %s. Field %s has changed mode from %s to %s",
+				tableName, tableField.Name, tableMode, mode,
+			))
+		}
+	}
+	if relaxation {
+		for _, field := range schema.Fields {
+			if _, exists := sourceFields[strings.ToLower(field.Name)]; !exists && fieldMode(field) == string(types.RequiredMode) {
+				field.Mode = string(types.NullableMode)
+				changed = true
+			}
+		}
+	}
+	return schema, changed, nil
+}
+
+// updateDestinationSchema applies schemaUpdateOptions of the job writing sourceSchema to the destination table
+// in the transaction of the write, and returns the updated table.
+// The rows are copied into the table of the new schema if fields are added or relaxed,
+// so the relaxed columns accept NULL.
+func (s *Server) updateDestinationSchema(ctx context.Context, tx *connection.Tx, project *metadata.Project, dataset *metadata.Dataset, table *metadata.Table, content *bigqueryv2.Table, sourceSchema *bigqueryv2.TableSchema, options []string) (*bigqueryv2.Table, error) {
+	tableName := fmt.Sprintf("%s:%s.%s", project.ID, dataset.ID, table.ID)
+	schema, changed, err := updatedSchema(tableName, content.Schema, sourceSchema, options)
+	if err != nil {
+		return nil, err
+	}
+	content.Schema = schema
+	if content.TableReference == nil {
+		content.TableReference = &bigqueryv2.TableReference{ProjectId: project.ID, DatasetId: dataset.ID, TableId: table.ID}
+	}
+	if err := table.UpdateContent(ctx, tx.Tx(), content); err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := s.contentRepo.RecreateTable(ctx, tx, content); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// truncateDestination deletes the rows of the destination table for WRITE_TRUNCATE.
+// The partition decorator deletes only the rows of the partition of the time-unit column partitioning.
+func (s *Server) truncateDestination(ctx context.Context, tx *connection.Tx, project *metadata.Project, dataset *metadata.Dataset, table *metadata.Table, content *bigqueryv2.Table, decorator string) error {
+	if decorator == "" {
+		return s.contentRepo.DeleteTableData(ctx, tx, project.ID, dataset.ID, table.ID)
+	}
+	partitioning := content.TimePartitioning
+	if partitioning == nil || partitioning.Field == "" {
+		return errInvalid(fmt.Sprintf(
+			"Partition decorator %s is supported only for the tables partitioned by the time-unit column: %s:%s.%s",
+			decorator, project.ID, dataset.ID, table.ID,
+		))
+	}
+	format := map[string]string{"DAY": "%Y%m%d", "HOUR": "%Y%m%d%H", "MONTH": "%Y%m", "YEAR": "%Y"}[strings.ToUpper(partitioning.Type)]
+	if format == "" || len(decorator) != len(strings.NewReplacer("%Y", "YYYY", "%m", "MM", "%d", "DD", "%H", "HH").Replace(format)) ||
+		strings.Trim(decorator, "0123456789") != "" {
+		return errInvalid(fmt.Sprintf("Invalid partition decorator %s of %s partitioning", decorator, partitioning.Type))
+	}
+	query := fmt.Sprintf(
+		"DELETE FROM `%s.%s.%s` WHERE FORMAT_TIMESTAMP('%s', TIMESTAMP(`%s`)) = '%s'",
+		project.ID, dataset.ID, table.ID, format, partitioning.Field, decorator,
+	)
+	if _, err := s.contentRepo.Query(ctx, tx, project.ID, dataset.ID, query, nil); err != nil {
+		return fmt.Errorf("failed to delete the partition %s of %s: %w", decorator, table.ID, err)
+	}
+	return nil
+}
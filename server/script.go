@@ -0,0 +1,595 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-zetasqlite"
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+	internaltypes "github.com/goccy/bigquery-emulator/internal/types"
+)
+
+// scriptVariable is the variable declared by DECLARE.
+// value is the SQL expression of the current value, so the variable is bound into the statements as is.
+type scriptVariable struct {
+	typ   string
+	value string
+}
+
+// script is the state of the script evaluated by the emulator.
+type script struct {
+	server    *Server
+	tx        *connection.Tx
+	project   *metadata.Project
+	datasetID string
+	params    []*bigqueryv2.QueryParameter
+	variables map[string]*scriptVariable
+	changed   *zetasqlite.ChangedCatalog
+
+	// the states of the system variables.
+	jobID        string
+	creationTime time.Time
+	timeZone     string
+	queryLabel   string
+	// lastJobID is the id of the child job of the previous statement.
+	lastJobID string
+	// rowCount is the number of the rows modified by the previous statement. It is nil if the statement is not DML.
+	rowCount     *int64
+	numChildJobs int64
+
+	// onError is called with the index and the error of the failed statement.
+	// The script runs the next statement if it returns nil. The error stops the script if onError is nil.
+	onError func(idx int, err error) error
+}
+
+// execScript evaluates the statements of the script in order.
+// The result of the script is the result of the last statement that is not DECLARE, SET or EXECUTE IMMEDIATE ... INTO.
+func (s *Server) execScript(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, stmts []*contentdata.ScriptStatement, params []*bigqueryv2.QueryParameter) (*internaltypes.QueryResponse, error) {
+	ctx, sc := s.newScript(ctx, tx, project, datasetID, params)
+	return sc.run(ctx, stmts)
+}
+
+// newScript returns the state of the script and the context that the statements of the script run with.
+func (s *Server) newScript(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, params []*bigqueryv2.QueryParameter) (context.Context, *script) {
+	props := connectionPropertiesFromContext(ctx)
+	// the statements of the script use @@time_zone, which is initialized by the time_zone connection property.
+	ctx = withConnectionProperties(ctx, nil)
+	sc := &script{
+		server:    s,
+		tx:        tx,
+		project:   project,
+		datasetID: datasetID,
+		params:    params,
+		variables: map[string]*scriptVariable{},
+		changed: &zetasqlite.ChangedCatalog{
+			Table:    &zetasqlite.ChangedTable{},
+			Function: &zetasqlite.ChangedFunction{},
+		},
+		jobID:        queryJobIDFromContext(ctx),
+		creationTime: time.Now().UTC(),
+		timeZone:     defaultScriptTimeZone,
+		queryLabel:   props.queryLabel,
+	}
+	if props.timeZone != "" {
+		sc.timeZone = props.timeZone
+	}
+	return ctx, sc
+}
+
+func (sc *script) run(ctx context.Context, stmts []*contentdata.ScriptStatement) (*internaltypes.QueryResponse, error) {
+	response := emptyQueryResponse()
+	for idx, stmt := range stmts {
+		result, err := sc.exec(ctx, stmt)
+		if err != nil {
+			if sc.onError == nil {
+				return nil, err
+			}
+			if err := sc.onError(idx, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		sc.rowCount = nil
+		if result != nil {
+			response = result
+			if result.DmlStats != nil {
+				rowCount := result.NumDmlAffectedRows
+				sc.rowCount = &rowCount
+			}
+		}
+	}
+	response.ChangedCatalog = sc.changed
+	return response, nil
+}
+
+// exec evaluates the statement. It returns nil if the statement has no result.
+func (sc *script) exec(ctx context.Context, stmt *contentdata.ScriptStatement) (*internaltypes.QueryResponse, error) {
+	switch stmt.Kind {
+	case contentdata.ScriptDeclare:
+		return nil, sc.declare(ctx, stmt)
+	case contentdata.ScriptSet:
+		return nil, sc.set(ctx, stmt)
+	case contentdata.ScriptExecuteImmediate:
+		return sc.executeImmediate(ctx, stmt)
+	}
+	return sc.query(ctx, sc.bind(ctx, stmt.Query, sc.values()), sc.params)
+}
+
+// defaultScriptTimeZone is the initial value of @@time_zone.
+const defaultScriptTimeZone = "UTC"
+
+// setSystemVariable sets the writable system variable: @@time_zone, @@dataset_id, @@dataset_project_id or @@query_label.
+// The other system variables like @@row_count are read-only.
+func (sc *script) setSystemVariable(ctx context.Context, name, expr string) error {
+	if _, exists := sc.systemVariables()[strings.ToLower(name)]; !exists {
+		return fmt.Errorf("Unrecognized system variable %s", name)
+	}
+	switch strings.ToLower(name) {
+	case "@@time_zone", "@@dataset_id", "@@dataset_project_id", "@@query_label":
+	default:
+		return fmt.Errorf("System variable %s is read-only", name)
+	}
+	v, err := sc.evalCell(ctx, expr)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate the value of %s: %w", name, err)
+	}
+	value, ok := v.V.(string)
+	if !ok || v.field.Type != "STRING" {
+		return fmt.Errorf("%s requires the value of STRING but got %v", name, v.V)
+	}
+	switch strings.ToLower(name) {
+	case "@@time_zone":
+		if _, err := time.LoadLocation(value); err != nil {
+			return fmt.Errorf("Invalid time zone: %s", value)
+		}
+		sc.timeZone = value
+	case "@@dataset_id":
+		sc.datasetID = value
+	case "@@dataset_project_id":
+		if value != sc.project.ID {
+			return fmt.Errorf("setting @@dataset_project_id to the other project %s is not supported by the emulator", value)
+		}
+	case "@@query_label":
+		if _, err := parseQueryLabel(value); err != nil {
+			return err
+		}
+		sc.queryLabel = value
+	}
+	return nil
+}
+
+// parseQueryLabel parses @@query_label of the comma separated `key:value` pairs into the labels of the child jobs.
+// The keys and the values follow the requirements of the labels of BigQuery.
+func parseQueryLabel(label string) (map[string]string, error) {
+	labels := map[string]string{}
+	if label == "" {
+		return labels, nil
+	}
+	for _, pair := range strings.Split(label, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || !labelKeyPattern.MatchString(key) || !labelValuePattern.MatchString(value) {
+			return nil, fmt.Errorf("Invalid query label %q: the label must be the comma separated key:value pairs of the lowercase letters, digits, underscores and dashes", label)
+		}
+		if _, exists := labels[key]; exists {
+			return nil, fmt.Errorf("Invalid query label %q: duplicate key %s", label, key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^\p{Ll}[\p{Ll}\p{Lo}\p{N}_-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[\p{Ll}\p{Lo}\p{N}_-]{0,63}$`)
+)
+
+// systemVariables returns the SQL expressions of the system variables keyed by the lowercase name with @@.
+// The statistics of the script like @@script.bytes_processed are always 0 because the emulator doesn't measure them.
+func (sc *script) systemVariables() map[string]string {
+	nullableString := func(v string) string {
+		if v == "" {
+			return "CAST(NULL AS STRING)"
+		}
+		return contentdata.QuoteStringLiteral(v)
+	}
+	rowCount := "CAST(NULL AS INT64)"
+	if sc.rowCount != nil {
+		rowCount = strconv.FormatInt(*sc.rowCount, 10)
+	}
+	return map[string]string{
+		"@@current_job_id":         nullableString(sc.jobID),
+		"@@dataset_id":             nullableString(sc.datasetID),
+		"@@dataset_project_id":     contentdata.QuoteStringLiteral(sc.project.ID),
+		"@@last_job_id":            nullableString(sc.lastJobID),
+		"@@project_id":             contentdata.QuoteStringLiteral(sc.project.ID),
+		"@@query_label":            nullableString(sc.queryLabel),
+		"@@row_count":              rowCount,
+		"@@script.bytes_billed":    "0",
+		"@@script.bytes_processed": "0",
+		"@@script.creation_time":   fmt.Sprintf("TIMESTAMP '%s'", sc.creationTime.Format("2006-01-02 15:04:05.999999+00")),
+		"@@script.job_id":          nullableString(sc.jobID),
+		"@@script.num_child_jobs":  strconv.FormatInt(sc.numChildJobs, 10),
+		"@@script.slot_ms":         "0",
+		"@@session_id":             "CAST(NULL AS STRING)",
+		"@@time_zone":              contentdata.QuoteStringLiteral(sc.timeZone),
+	}
+}
+
+// bind binds the variables into query and applies @@time_zone to the functions of the current time.
+// The columns of the tables read by query take precedence over the variables of the same name.
+func (sc *script) bind(ctx context.Context, query string, values map[string]string) string {
+	query = contentdata.BindVariables(query, values, sc.columnNames(ctx, query))
+	if sc.timeZone != defaultScriptTimeZone {
+		query = contentdata.ApplyTimeZone(query, sc.timeZone)
+	}
+	return query
+}
+
+// columnNames returns the lowercase names of the columns of the tables referenced by query.
+// The unqualified table names are resolved with the default dataset of the script.
+// The tables created by the script are not in the metadata until the script ends, so their columns are read from the catalog.
+func (sc *script) columnNames(ctx context.Context, query string) map[string]struct{} {
+	columns := map[string]struct{}{}
+	for _, path := range contentdata.TableNames(query) {
+		fields := sc.tableFields(ctx, path)
+		if fields == nil {
+			response, err := sc.server.contentRepo.Query(
+				ctx, sc.tx, sc.project.ID, sc.datasetID,
+				fmt.Sprintf("SELECT * FROM `%s` LIMIT 0", strings.Join(path, ".")), nil,
+			)
+			if err != nil || response.Schema == nil {
+				continue
+			}
+			fields = response.Schema.Fields
+		}
+		for _, field := range fields {
+			columns[strings.ToLower(field.Name)] = struct{}{}
+		}
+	}
+	return columns
+}
+
+// tableFields returns the fields of the table of the name path in the metadata, or nil if the table is not found.
+func (sc *script) tableFields(ctx context.Context, path []string) []*bigqueryv2.TableFieldSchema {
+	dataset, tableID := sc.project.Dataset(sc.datasetID), path[0]
+	if len(path) > 1 {
+		d, err := sc.server.referencedDataset(ctx, sc.project, path)
+		if err != nil || d == nil {
+			return nil
+		}
+		dataset, tableID = d, path[1]
+		if len(path) >= 3 && path[0] == d.ProjectID && path[1] == d.ID {
+			tableID = path[2]
+		}
+	}
+	if dataset == nil {
+		return nil
+	}
+	table := dataset.Table(tableID)
+	if table == nil {
+		return nil
+	}
+	content, err := table.Content()
+	if err != nil || content.Schema == nil {
+		return nil
+	}
+	return content.Schema.Fields
+}
+
+func (sc *script) declare(ctx context.Context, stmt *contentdata.ScriptStatement) error {
+	for _, name := range stmt.Variables {
+		if _, exists := sc.variables[strings.ToLower(name)]; exists {
+			return fmt.Errorf("Variable %s is already declared", name)
+		}
+	}
+	// the parser ensures that DECLARE without the default value has the type.
+	value := "NULL"
+	if stmt.Expr != "" {
+		v, err := sc.eval(ctx, stmt.Expr)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate the default value of %s: %w", strings.Join(stmt.Variables, ", "), err)
+		}
+		value = v
+	}
+	if stmt.Type != "" {
+		value = fmt.Sprintf("CAST(%s AS %s)", value, stmt.Type)
+	}
+	for _, name := range stmt.Variables {
+		sc.variables[strings.ToLower(name)] = &scriptVariable{typ: stmt.Type, value: value}
+	}
+	return nil
+}
+
+func (sc *script) set(ctx context.Context, stmt *contentdata.ScriptStatement) error {
+	if strings.HasPrefix(stmt.Variables[0], "@@") {
+		return sc.setSystemVariable(ctx, stmt.Variables[0], stmt.Expr)
+	}
+	if len(stmt.Variables) > 1 {
+		return sc.setVariables(ctx, stmt)
+	}
+	value, err := sc.eval(ctx, stmt.Expr)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate the value of %s: %w", stmt.Variables[0], err)
+	}
+	return sc.assign(stmt.Variables[0], value)
+}
+
+// setVariables assigns the fields of the value of `SET (name[, ...]) = value` to the variables in order.
+// The value is the tuple of the expressions, the subquery returning the columns or a STRUCT, or the STRUCT expression.
+// The subquery without rows assigns NULL to the variables, and the one with multiple rows is the error.
+func (sc *script) setVariables(ctx context.Context, stmt *contentdata.ScriptStatement) error {
+	for _, name := range stmt.Variables {
+		if _, exists := sc.variables[strings.ToLower(name)]; !exists {
+			return fmt.Errorf("Unrecognized name: %s", name)
+		}
+	}
+	query := stmt.Expr
+	switch {
+	case len(stmt.Values) != 0:
+		query = fmt.Sprintf("SELECT %s", strings.Join(stmt.Values, ", "))
+	case !stmt.ExprIsQuery:
+		query = fmt.Sprintf("SELECT %s", stmt.Expr)
+	}
+	names := strings.Join(stmt.Variables, ", ")
+	response, err := sc.server.contentRepo.Query(ctx, sc.tx, sc.project.ID, sc.datasetID, sc.bind(ctx, query, sc.values()), sc.params)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate the value of %s: %w", names, err)
+	}
+	if len(response.Rows) > 1 {
+		return fmt.Errorf("Scalar subquery produced more than one element")
+	}
+	fields := response.Schema.Fields
+	var cells []*internaltypes.TableCell
+	if len(response.Rows) == 1 {
+		cells = response.Rows[0].F
+	}
+	if len(fields) == 1 && (fields[0].Type == "RECORD" || fields[0].Type == "STRUCT") && fields[0].Mode != "REPEATED" {
+		// the fields of the STRUCT are assigned to the variables.
+		var row internaltypes.TableRow
+		if len(cells) == 1 && cells[0].V != nil {
+			row, _ = cells[0].V.(internaltypes.TableRow)
+		}
+		fields, cells = fields[0].Fields, row.F
+	}
+	if len(fields) != len(stmt.Variables) {
+		return fmt.Errorf("SET of %s requires %d values but got %d", names, len(stmt.Variables), len(fields))
+	}
+	values := make([]string, 0, len(fields))
+	for idx, field := range fields {
+		value := "NULL"
+		if cells != nil {
+			v, err := cellLiteral(field, cells[idx])
+			if err != nil {
+				return err
+			}
+			value = v
+		}
+		values = append(values, value)
+	}
+	for idx, name := range stmt.Variables {
+		if err := sc.assign(name, values[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sc *script) assign(name, value string) error {
+	v, exists := sc.variables[strings.ToLower(name)]
+	if !exists {
+		return fmt.Errorf("Unrecognized name: %s", name)
+	}
+	if v.typ != "" {
+		value = fmt.Sprintf("CAST(%s AS %s)", value, v.typ)
+	}
+	v.value = value
+	return nil
+}
+
+// executeImmediate evaluates the SQL string and runs it as the statement of the script.
+// The arguments of USING clause are bound into the query parameters of the SQL as the literals,
+// so the values can't change the structure of the SQL.
+func (sc *script) executeImmediate(ctx context.Context, stmt *contentdata.ScriptStatement) (*internaltypes.QueryResponse, error) {
+	sqlValue, err := sc.evalCell(ctx, stmt.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate the SQL of EXECUTE IMMEDIATE: %w", err)
+	}
+	sql, ok := sqlValue.V.(string)
+	if !ok || sqlValue.field.Type != "STRING" {
+		return nil, fmt.Errorf("EXECUTE IMMEDIATE requires the SQL of STRING but got %v", sqlValue.V)
+	}
+	if contentdata.IsScript(sql) {
+		return nil, fmt.Errorf("EXECUTE IMMEDIATE doesn't support the script: %s", sql)
+	}
+	var (
+		positional []string
+		named      = map[string]string{}
+	)
+	for _, arg := range stmt.Using {
+		value, err := sc.eval(ctx, arg.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate the argument of USING clause: %w", err)
+		}
+		if arg.Name == "" {
+			positional = append(positional, value)
+		} else {
+			named[strings.ToLower(arg.Name)] = value
+		}
+	}
+	if len(positional) != 0 && len(named) != 0 {
+		return nil, fmt.Errorf("USING clause cannot have both positional and named arguments")
+	}
+	bound, err := contentdata.BindParameters(sql, positional, named)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind the arguments to the SQL of EXECUTE IMMEDIATE %q: %w", sql, err)
+	}
+	// the dynamic SQL can't refer to the variables of the script but can refer to the system variables.
+	response, err := sc.query(ctx, sc.bind(ctx, bound, sc.systemVariables()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the SQL of EXECUTE IMMEDIATE %q: %w", sql, err)
+	}
+	if len(stmt.Variables) == 0 {
+		return response, nil
+	}
+	if len(response.Schema.Fields) != len(stmt.Variables) {
+		return nil, fmt.Errorf(
+			"EXECUTE IMMEDIATE ... INTO has %d variables but the SQL returns %d columns",
+			len(stmt.Variables), len(response.Schema.Fields),
+		)
+	}
+	if len(response.Rows) > 1 {
+		return nil, fmt.Errorf("EXECUTE IMMEDIATE ... INTO requires the SQL to return at most one row but got %d rows", len(response.Rows))
+	}
+	for idx, name := range stmt.Variables {
+		cell := &internaltypes.TableCell{}
+		if len(response.Rows) == 1 {
+			cell = response.Rows[0].F[idx]
+		}
+		value, err := cellLiteral(response.Schema.Fields[idx], cell)
+		if err != nil {
+			return nil, err
+		}
+		if err := sc.assign(name, value); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// query runs the statement of the script and collects the changes of the catalog.
+// Each statement is recorded as the child job of the script job, which @@last_job_id refers to.
+func (sc *script) query(ctx context.Context, query string, params []*bigqueryv2.QueryParameter) (*internaltypes.QueryResponse, error) {
+	sc.numChildJobs++
+	startTime := time.Now()
+	response, err := sc.server.execQuery(ctx, sc.tx, sc.project, sc.datasetID, query, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := sc.addChildJob(ctx, query, response, startTime, time.Now()); err != nil {
+		return nil, err
+	}
+	if cat := response.ChangedCatalog; cat != nil {
+		if cat.Table != nil {
+			sc.changed.Table.Added = append(sc.changed.Table.Added, cat.Table.Added...)
+			sc.changed.Table.Updated = append(sc.changed.Table.Updated, cat.Table.Updated...)
+			sc.changed.Table.Deleted = append(sc.changed.Table.Deleted, cat.Table.Deleted...)
+		}
+		if cat.Function != nil {
+			sc.changed.Function.Added = append(sc.changed.Function.Added, cat.Function.Added...)
+			sc.changed.Function.Deleted = append(sc.changed.Function.Deleted, cat.Function.Deleted...)
+		}
+	}
+	return response, nil
+}
+
+// addChildJob adds the job of the statement with the labels of @@query_label and the query plan as the child job of the script job.
+// The script run without the job id has no child job.
+func (sc *script) addChildJob(ctx context.Context, query string, response *internaltypes.QueryResponse, startTime, endTime time.Time) error {
+	if sc.jobID == "" {
+		return nil
+	}
+	labels, err := parseQueryLabel(sc.queryLabel)
+	if err != nil {
+		return err
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+	jobID := fmt.Sprintf("script_job_%s_%d", sc.jobID, sc.numChildJobs-1)
+	var defaultDataset *bigqueryv2.DatasetReference
+	if sc.datasetID != "" {
+		defaultDataset = &bigqueryv2.DatasetReference{ProjectId: sc.project.ID, DatasetId: sc.datasetID}
+	}
+	job := &bigqueryv2.Job{
+		Kind: "bigquery#job",
+		Id:   fmt.Sprintf("%s:%s", sc.project.ID, jobID),
+		JobReference: &bigqueryv2.JobReference{
+			ProjectId: sc.project.ID,
+			JobId:     jobID,
+		},
+		Configuration: &bigqueryv2.JobConfiguration{
+			JobType: "QUERY",
+			Labels:  labels,
+			Query: &bigqueryv2.JobConfigurationQuery{
+				Query:          query,
+				DefaultDataset: defaultDataset,
+				Priority:       "INTERACTIVE",
+			},
+		},
+		Status: &bigqueryv2.JobStatus{State: "DONE"},
+		Statistics: &bigqueryv2.JobStatistics{
+			ParentJobId: sc.jobID,
+			Query: &bigqueryv2.JobStatistics2{
+				StatementType:       statementType(query),
+				TotalBytesBilled:    response.TotalBytes,
+				TotalBytesProcessed: response.TotalBytes,
+				NumDmlAffectedRows:  response.NumDmlAffectedRows,
+				DmlStats:            response.DmlStats,
+			},
+			CreationTime:        startTime.Unix(),
+			StartTime:           startTime.Unix(),
+			EndTime:             endTime.Unix(),
+			TotalBytesProcessed: response.TotalBytes,
+		},
+	}
+	if input := sc.server.queryPlanInput(ctx, sc.project, sc.datasetID, query); input != nil {
+		job.Statistics.Query.QueryPlan, job.Statistics.Query.Timeline = sc.server.newQueryPlan(ctx, sc.tx, sc.project, input, response, startTime, endTime)
+	}
+	if err := sc.project.AddJob(ctx, sc.tx.Tx(), metadata.NewJob(sc.server.metaRepo, sc.project.ID, jobID, job, nil, nil)); err != nil {
+		return fmt.Errorf("failed to add the child job %s: %w", jobID, err)
+	}
+	sc.lastJobID = jobID
+	return nil
+}
+
+// values returns the SQL expressions of the variables and the system variables keyed by the lowercase name.
+func (sc *script) values() map[string]string {
+	values := sc.systemVariables()
+	for name, v := range sc.variables {
+		values[name] = v.value
+	}
+	return values
+}
+
+type scriptValue struct {
+	*internaltypes.TableCell
+	field *bigqueryv2.TableFieldSchema
+}
+
+// evalCell evaluates the scalar expression with the variables and the query parameters of the script.
+func (sc *script) evalCell(ctx context.Context, expr string) (*scriptValue, error) {
+	query := fmt.Sprintf("SELECT %s", sc.bind(ctx, expr, sc.values()))
+	response, err := sc.server.contentRepo.Query(ctx, sc.tx, sc.project.ID, sc.datasetID, query, sc.params)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Schema.Fields) != 1 || len(response.Rows) != 1 {
+		return nil, fmt.Errorf("%s is not the scalar expression", expr)
+	}
+	return &scriptValue{TableCell: response.Rows[0].F[0], field: response.Schema.Fields[0]}, nil
+}
+
+// eval evaluates the scalar expression and returns the value as the SQL expression.
+func (sc *script) eval(ctx context.Context, expr string) (string, error) {
+	v, err := sc.evalCell(ctx, expr)
+	if err != nil {
+		return "", err
+	}
+	return cellLiteral(v.field, v.TableCell)
+}
+
+// cellLiteral converts the scalar value of the query result into the SQL expression of the value.
+func cellLiteral(field *bigqueryv2.TableFieldSchema, cell *internaltypes.TableCell) (string, error) {
+	if field.Mode == "REPEATED" || field.Type == "RECORD" || field.Type == "STRUCT" {
+		return "", fmt.Errorf("the script variable only supports the scalar value but %s is %s", field.Name, field.Type)
+	}
+	return valueLiteral(field, cell)
+}
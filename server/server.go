@@ -8,6 +8,8 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -32,10 +34,51 @@ type Server struct {
 	fileCleanup  func() error
 	httpServer   *http.Server
 	grpcServer   *grpc.Server
+	// listenerMu guards the listeners and the servers set by Listen and Serve
+	// from HTTPAddr, GRPCAddr and Stop called by the other goroutines.
+	listenerMu sync.Mutex
+	// httpListener and grpcListener are the listeners bound by Listen.
+	httpListener net.Listener
+	grpcListener net.Listener
+	ready        atomic.Bool
+	// defaultLocation is the location of the datasets created without location.
+	defaultLocation     string
+	drainer             *drainer
+	shutdownGracePeriod time.Duration
+	// differentialPrivacyPassthrough runs the differential privacy queries as the ordinary aggregations.
+	differentialPrivacyPassthrough bool
+	// generativeAIResponse is the canned response of the generative AI functions. They aren't supported if it is empty.
+	generativeAIResponse string
+	// grpcReflection registers the gRPC server reflection service.
+	grpcReflection bool
+	// maxWriteStreams is the limit of the write streams of the Storage Write API that are not finalized.
+	maxWriteStreams int
+	// logQueryRedaction redacts the literal values of the SQL written to the log.
+	logQueryRedaction bool
+	// resultLimit is the limit of the rows and the bytes returned by a query.
+	resultLimit contentdata.ResultLimit
+	// readOnly rejects the requests that change the data or the metadata.
+	readOnly bool
+	// externalFiles caches the rows of the source files of the external tables.
+	externalFiles *externalFileCache
+	// autoCreateProject creates the unknown project on the first request referencing it.
+	autoCreateProject bool
+	// autoCreateDataset is the dataset created in the project created on the request.
+	autoCreateDataset string
+	// datasetAccessEnforcement checks the queries with the access entries of the datasets.
+	datasetAccessEnforcement bool
+	// sharedDatasets are the read-only datasets of the other projects loaded by DirectorySource, keyed by project.dataset.
+	sharedDatasets map[string]struct{}
 }
 
 func New(storage Storage) (*Server, error) {
-	server := &Server{storage: storage}
+	server := &Server{
+		storage:             storage,
+		defaultLocation:     DefaultLocation,
+		drainer:             newDrainer(),
+		shutdownGracePeriod: DefaultShutdownGracePeriod,
+		externalFiles:       newExternalFileCache(),
+	}
 	if storage == TempStorage {
 		f, err := os.CreateTemp("", "")
 		if err != nil {
@@ -82,20 +125,31 @@ func New(storage Storage) (*Server, error) {
 	r.Handle(newDiscoveryAPIEndpoint, newDiscoveryHandler(server)).Methods("GET")
 	r.Handle(uploadAPIEndpoint, &uploadHandler{}).Methods("POST")
 	r.Handle(uploadAPIEndpoint, &uploadContentHandler{}).Methods("PUT")
+	registerConnectionHandlers(r)
 	r.PathPrefix("/").Handler(&defaultHandler{})
+	r.Use(drainMiddleware(server))
 	r.Use(sequentialAccessMiddleware())
 	r.Use(recoveryMiddleware(server))
 	r.Use(loggerMiddleware(server))
 	r.Use(accessLogMiddleware())
 	r.Use(decompressMiddleware())
+	r.Use(debugLogMiddleware(server))
 	r.Use(withServerMiddleware(server))
+	r.Use(withUserMiddleware())
+	r.Use(readOnlyMiddleware(server))
 	r.Use(withProjectMiddleware())
 	r.Use(withDatasetMiddleware())
 	r.Use(withJobMiddleware())
 	r.Use(withTableMiddleware())
 	r.Use(withModelMiddleware())
 	r.Use(withRoutineMiddleware())
-	server.Handler = r
+
+	// the probes bypass the middlewares, so they answer while a long request holds the sequential access.
+	root := mux.NewRouter()
+	root.Handle(healthzEndpoint, &healthzHandler{server: server}).Methods("GET")
+	root.Handle(readyzEndpoint, &readyzHandler{server: server}).Methods("GET")
+	root.PathPrefix("/").Handler(r)
+	server.Handler = root
 	return server, nil
 }
 
@@ -209,27 +263,76 @@ func (s *Server) Load(sources ...Source) error {
 	return nil
 }
 
+// Listen binds the listeners of the REST server to httpAddr and the gRPC server to grpcAddr without serving the requests.
+// The port 0 of the address binds the port chosen by the OS, and HTTPAddr and GRPCAddr return the bound addresses.
+// It makes the parallel test suites run many servers without the port collisions.
+func (s *Server) Listen(httpAddr, grpcAddr string) error {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	return s.listen(httpAddr, grpcAddr)
+}
+
+// listen binds the listeners. The caller must hold listenerMu.
+func (s *Server) listen(httpAddr, grpcAddr string) error {
+	httpListener, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		return err
+	}
+	grpcListener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		_ = httpListener.Close()
+		return err
+	}
+	s.httpListener = httpListener
+	s.grpcListener = grpcListener
+	return nil
+}
+
+// HTTPAddr returns the address that the REST server listens at, or empty string before Listen or Serve.
+func (s *Server) HTTPAddr() string {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	if s.httpListener == nil {
+		return ""
+	}
+	return s.httpListener.Addr().String()
+}
+
+// GRPCAddr returns the address that the gRPC server listens at, or empty string before Listen or Serve.
+func (s *Server) GRPCAddr() string {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	if s.grpcListener == nil {
+		return ""
+	}
+	return s.grpcListener.Addr().String()
+}
+
+// Serve serves the REST server at httpAddr and the gRPC server at grpcAddr.
+// The addresses are ignored if the listeners are already bound by Listen.
 func (s *Server) Serve(ctx context.Context, httpAddr, grpcAddr string) error {
+	s.listenerMu.Lock()
+	if s.httpListener == nil {
+		if err := s.listen(httpAddr, grpcAddr); err != nil {
+			s.listenerMu.Unlock()
+			return err
+		}
+	}
+	httpListener, grpcListener := s.httpListener, s.grpcListener
 	httpServer := &http.Server{
 		Handler:      s.Handler,
-		Addr:         httpAddr,
+		Addr:         httpListener.Addr().String(),
 		WriteTimeout: 5 * time.Minute,
 		ReadTimeout:  15 * time.Second,
 	}
 	s.httpServer = httpServer
 
-	grpcServer := grpc.NewServer()
+	grpcServer := s.newGRPCServer()
 	registerStorageServer(grpcServer, s)
 	s.grpcServer = grpcServer
+	s.listenerMu.Unlock()
 
-	httpListener, err := net.Listen("tcp", httpAddr)
-	if err != nil {
-		return err
-	}
-	grpcListener, err := net.Listen("tcp", grpcAddr)
-	if err != nil {
-		return err
-	}
+	s.ready.Store(true)
 
 	var eg errgroup.Group
 	eg.Go(func() error { return grpcServer.Serve(grpcListener) })
@@ -237,14 +340,29 @@ func (s *Server) Serve(ctx context.Context, httpAddr, grpcAddr string) error {
 	return eg.Wait()
 }
 
+// Stop stops accepting new requests, waits for the in-flight requests up to the shutdown grace period
+// and closes the database after they complete or are cancelled.
 func (s *Server) Stop(ctx context.Context) error {
+	s.ready.Store(false)
 	defer s.Close()
 
-	if s.grpcServer != nil {
-		s.grpcServer.GracefulStop()
+	drainErr := s.drain(ctx)
+	s.listenerMu.Lock()
+	grpcServer, httpServer := s.grpcServer, s.httpServer
+	s.listenerMu.Unlock()
+	if grpcServer != nil {
+		if drainErr != nil {
+			grpcServer.Stop()
+		} else {
+			grpcServer.GracefulStop()
+		}
 	}
-	if s.httpServer != nil {
-		return s.httpServer.Shutdown(ctx)
+	if httpServer != nil {
+		if drainErr != nil {
+			_ = httpServer.Close()
+		} else if err := httpServer.Shutdown(ctx); err != nil {
+			return err
+		}
 	}
-	return nil
+	return drainErr
 }
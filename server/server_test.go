@@ -4,18 +4,26 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"database/sql"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
 	"cloud.google.com/go/storage"
 	"github.com/fsouza/fake-gcs-server/fakestorage"
 	"github.com/goccy/bigquery-emulator/server"
@@ -211,6 +219,51 @@ func findDatasets(t *testing.T, ctx context.Context, client *bigquery.Client) []
 	return datasets
 }
 
+// newTestServer returns the emulator loaded from the sources. The emulator is stopped when the test finishes.
+func newTestServer(t *testing.T, sources ...server.Source) *server.Server {
+	t.Helper()
+	bqServer, err := server.New(server.TempStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bqServer.Load(sources...); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bqServer.Stop(context.Background()) })
+	return bqServer
+}
+
+// startTestServer serves the emulator by the test server. The test server is closed when the test finishes.
+func startTestServer(t *testing.T, bqServer *server.Server) *server.TestServer {
+	t.Helper()
+	testServer := bqServer.TestServer()
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+// newTestClient returns the client of the project served by the test server. The client is closed when the test finishes.
+func newTestClient(t *testing.T, testServer *server.TestServer, projectID string) *bigquery.Client {
+	t.Helper()
+	client, err := bigquery.NewClient(
+		context.Background(),
+		projectID,
+		option.WithEndpoint(testServer.URL),
+		option.WithoutAuthentication(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newTestDataClient returns the client of the test project of the emulator loaded from testdata/data.yaml.
+func newTestDataClient(t *testing.T) *bigquery.Client {
+	t.Helper()
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	return newTestClient(t, startTestServer(t, bqServer), "test")
+}
+
 func TestJob(t *testing.T) {
 	ctx := context.Background()
 
@@ -484,6 +537,232 @@ func TestQueryWithDestination(t *testing.T) {
 	}
 }
 
+func TestQueryDestinationDispositions(t *testing.T) {
+	const (
+		projectName = "test"
+		datasetName = "dataset1"
+	)
+
+	ctx := context.Background()
+
+	project := types.NewProject(projectName, types.NewDataset(datasetName))
+	bqServer := newTestServer(t, server.StructSource(project))
+	client := newTestClient(t, startTestServer(t, bqServer), projectName)
+
+	runQuery := func(query string, config func(*bigquery.QueryConfig)) error {
+		q := client.Query(query)
+		config(&q.QueryConfig)
+		job, err := q.Run(ctx)
+		if err != nil {
+			return err
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	}
+	readRows := func(t *testing.T, query string) [][]bigquery.Value {
+		t.Helper()
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+	dst := client.Dataset(datasetName).Table("materialized")
+	for _, test := range []struct {
+		name     string
+		query    string
+		create   bigquery.TableCreateDisposition
+		write    bigquery.TableWriteDisposition
+		expected [][]bigquery.Value
+		err      string
+	}{
+		{
+			name:   "create never",
+			query:  "SELECT 1 AS id, 'a' AS name",
+			create: bigquery.CreateNever,
+			err:    "Not found: Table test:dataset1.materialized",
+		},
+		{
+			name:     "create if needed",
+			query:    "SELECT 1 AS id, 'a' AS name",
+			create:   bigquery.CreateIfNeeded,
+			expected: [][]bigquery.Value{{int64(1), "a"}},
+		},
+		{
+			name:  "write empty",
+			query: "SELECT 2 AS id, 'b' AS name",
+			write: bigquery.WriteEmpty,
+			err:   "Already Exists: Table test:dataset1.materialized",
+		},
+		{
+			name:     "write append",
+			query:    "SELECT 2 AS id, 'b' AS name",
+			write:    bigquery.WriteAppend,
+			expected: [][]bigquery.Value{{int64(1), "a"}, {int64(2), "b"}},
+		},
+		{
+			name:     "write truncate",
+			query:    "SELECT 3 AS id, 'c' AS name",
+			write:    bigquery.WriteTruncate,
+			expected: [][]bigquery.Value{{int64(3), "c"}},
+		},
+		{
+			name:  "schema mismatch",
+			query: "SELECT 'x' AS id",
+			write: bigquery.WriteAppend,
+			err:   "Provided Schema does not match Table test:dataset1.materialized. Field id has changed type from INTEGER to STRING",
+		},
+		{
+			name:  "unknown field",
+			query: "SELECT 4 AS id, 'd' AS name, 1.5 AS score",
+			write: bigquery.WriteAppend,
+			err:   "Cannot add fields (field: score)",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := runQuery(test.query, func(config *bigquery.QueryConfig) {
+				config.Dst = dst
+				config.CreateDisposition = test.create
+				config.WriteDisposition = test.write
+			})
+			if test.err != "" {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected error to contain %q but got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows := readRows(t, "SELECT id, name FROM dataset1.materialized ORDER BY id")
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("partitioned table", func(t *testing.T) {
+		partitioned := client.Dataset(datasetName).Table("partitioned")
+		if err := runQuery("SELECT DATE '2024-01-02' AS dt, 1 AS id", func(config *bigquery.QueryConfig) {
+			config.Dst = partitioned
+			config.TimePartitioning = &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "dt"}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		md, err := partitioned.Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.TimePartitioning == nil || md.TimePartitioning.Field != "dt" {
+			t.Fatalf("failed to get time partitioning: %+v", md.TimePartitioning)
+		}
+		err = runQuery("SELECT DATE '2024-01-03' AS dt, 2 AS id", func(config *bigquery.QueryConfig) {
+			config.Dst = partitioned
+			config.WriteDisposition = bigquery.WriteAppend
+			config.TimePartitioning = &bigquery.TimePartitioning{Type: bigquery.MonthPartitioningType, Field: "dt"}
+		})
+		if err == nil || !strings.Contains(err.Error(), "Incompatible table partitioning specification") {
+			t.Fatalf("expected partitioning error but got %v", err)
+		}
+		err = runQuery("SELECT 1 AS id", func(config *bigquery.QueryConfig) {
+			config.Dst = client.Dataset(datasetName).Table("missing_partition_column")
+			config.TimePartitioning = &bigquery.TimePartitioning{Field: "dt"}
+		})
+		if err == nil || !strings.Contains(err.Error(), "The field specified for partitioning cannot be found in the schema") {
+			t.Fatalf("expected partitioning error but got %v", err)
+		}
+	})
+}
+
+func TestMaxResultRows(t *testing.T) {
+	const (
+		projectName = "test"
+		datasetName = "dataset1"
+	)
+
+	ctx := context.Background()
+
+	project := types.NewProject(projectName, types.NewDataset(datasetName))
+	bqServer := newTestServer(t, server.StructSource(project))
+	bqServer.SetMaxResultRows(2)
+	client := newTestClient(t, startTestServer(t, bqServer), projectName)
+
+	t.Run("exceeding the limit", func(t *testing.T) {
+		_, err := client.Query("SELECT x FROM UNNEST([1, 2, 3]) AS x").Read(ctx)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "Response too large to return") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("within the limit", func(t *testing.T) {
+		it, err := client.Query("SELECT x FROM UNNEST([1, 2, 3]) AS x ORDER BY x LIMIT 2").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		if diff := cmp.Diff([][]bigquery.Value{{int64(1)}, {int64(2)}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("destination table", func(t *testing.T) {
+		query := client.Query("SELECT x FROM UNNEST([1, 2, 3]) AS x")
+		query.QueryConfig.Dst = client.Dataset(datasetName).Table("large_result")
+		query.QueryConfig.AllowLargeResults = true
+		job, err := query.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := status.Err(); err != nil {
+			t.Fatal(err)
+		}
+		it, err := client.Query("SELECT COUNT(*) FROM dataset1.large_result").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]bigquery.Value{int64(3)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
 type TableSchema struct {
 	Int      int
 	Str      string
@@ -2653,3 +2932,9397 @@ func TestInformationSchema(t *testing.T) {
 	})
 
 }
+
+func TestHealthCheck(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer, err := server.New(server.TempStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probeServer := httptest.NewServer(bqServer.Handler)
+	defer probeServer.Close()
+
+	probe := func(t *testing.T, path string, expected int) {
+		t.Helper()
+		res, err := http.Get(probeServer.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != expected {
+			body, _ := io.ReadAll(res.Body)
+			t.Fatalf("%s: expected status %d but got %d: %s", path, expected, res.StatusCode, body)
+		}
+	}
+
+	// not ready while seeding data
+	if err := bqServer.Load(server.StructSource(types.NewProject("test"))); err != nil {
+		t.Fatal(err)
+	}
+	probe(t, "/healthz", http.StatusOK)
+	probe(t, "/readyz", http.StatusServiceUnavailable)
+
+	testServer := bqServer.TestServer()
+	defer testServer.Close()
+	probe(t, "/healthz", http.StatusOK)
+	probe(t, "/readyz", http.StatusOK)
+
+	if err := bqServer.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	probe(t, "/healthz", http.StatusServiceUnavailable)
+	probe(t, "/readyz", http.StatusServiceUnavailable)
+}
+
+func TestGroupByAllAndOrderByAll(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name: "group by all",
+			query: `
+SELECT category, 'fixed' AS tag, SUM(amount) AS total, COUNT(*) AS cnt
+FROM UNNEST([
+  STRUCT('a' AS category, 1 AS amount),
+  STRUCT('b' AS category, 2 AS amount),
+  STRUCT('a' AS category, 3 AS amount)
+])
+GROUP BY ALL
+ORDER BY category`,
+			expected: [][]bigquery.Value{
+				{"a", "fixed", int64(4), int64(2)},
+				{"b", "fixed", int64(2), int64(1)},
+			},
+		},
+		{
+			name:     "group by all without grouping keys",
+			query:    "SELECT SUM(x) AS total FROM UNNEST([1, 2, 3]) AS x GROUP BY ALL",
+			expected: [][]bigquery.Value{{int64(6)}},
+		},
+		{
+			name: "order by all",
+			query: `
+SELECT category, amount
+FROM UNNEST([
+  STRUCT('b' AS category, 1 AS amount),
+  STRUCT('a' AS category, 2 AS amount),
+  STRUCT('a' AS category, 1 AS amount)
+])
+ORDER BY ALL DESC`,
+			expected: [][]bigquery.Value{
+				{"b", int64(1)},
+				{"a", int64(2)},
+				{"a", int64(1)},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := client.Query("SELECT * FROM dataset1.table_a ORDER BY ALL").Read(ctx); err == nil {
+		t.Fatal("expected error for ORDER BY ALL with SELECT *")
+	}
+}
+
+func TestBucketFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	it, err := client.Query(`
+SELECT
+  TIMESTAMP_BUCKET(TIMESTAMP '2023-05-01 10:37:12+00', INTERVAL 15 MINUTE),
+  TIMESTAMP_BUCKET(TIMESTAMP '2023-05-01 10:37:12+00', INTERVAL 15 MINUTE, TIMESTAMP '2023-05-01 00:05:00+00'),
+  TIMESTAMP_BUCKET(TIMESTAMP '1949-12-31 23:59:59+00', INTERVAL 1 DAY),
+  DATETIME_BUCKET(DATETIME '2023-05-01 10:37:12', INTERVAL 1 DAY, DATETIME '2000-01-01 06:00:00'),
+  DATE_BUCKET(DATE '2023-05-17', INTERVAL 1 DAY),
+  DATE_BUCKET(DATE '2023-05-17', INTERVAL 7 DAY, DATE '2023-05-01'),
+  DATE_BUCKET(DATE '2023-05-17', INTERVAL 1 MONTH, DATE '2000-01-20')
+`).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row []bigquery.Value
+	if err := it.Next(&row); err != nil {
+		t.Fatal(err)
+	}
+	expected := []bigquery.Value{
+		time.Date(2023, 5, 1, 10, 30, 0, 0, time.UTC),
+		time.Date(2023, 5, 1, 10, 35, 0, 0, time.UTC),
+		time.Date(1949, 12, 31, 0, 0, 0, 0, time.UTC),
+		civil.DateTime{Date: civil.Date{Year: 2023, Month: 5, Day: 1}, Time: civil.Time{Hour: 6}},
+		civil.Date{Year: 2023, Month: 5, Day: 17},
+		civil.Date{Year: 2023, Month: 5, Day: 15},
+		civil.Date{Year: 2023, Month: 4, Day: 20},
+	}
+	if diff := cmp.Diff(expected, row); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+
+	if _, err := client.Query("SELECT TIMESTAMP_BUCKET(CURRENT_TIMESTAMP(), INTERVAL 0 MINUTE)").Read(ctx); err == nil {
+		t.Fatal("expected error for non positive bucket width")
+	}
+}
+
+func TestDateDiffFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		expr     string
+		expected int64
+	}{
+		{expr: "DATE_DIFF(DATE '2010-07-07', DATE '2008-12-25', DAY)", expected: 559},
+		{expr: "DATE_DIFF(DATE '2017-10-14', DATE '2017-10-15', DAY)", expected: -1},
+		{expr: "DATE_DIFF(DATE '2017-12-30', DATE '2014-12-30', YEAR)", expected: 3},
+		{expr: "DATE_DIFF(DATE '2017-12-30', DATE '2014-12-30', ISOYEAR)", expected: 2},
+		{expr: "DATE_DIFF(DATE '2017-12-18', DATE '2017-12-17', WEEK)", expected: 0},
+		{expr: "DATE_DIFF(DATE '2017-12-18', DATE '2017-12-17', WEEK(MONDAY))", expected: 1},
+		{expr: "DATE_DIFF(DATE '2017-12-18', DATE '2017-12-17', ISOWEEK)", expected: 1},
+		{expr: "DATE_DIFF(DATE '2017-12-17', DATE '2017-12-18', WEEK(MONDAY))", expected: -1},
+		{expr: "DATE_DIFF(DATE '2024-01-07', DATE '2024-01-06', WEEK(SATURDAY))", expected: 0},
+		{expr: "DATE_DIFF(DATE '2021-01-04', DATE '2020-12-27', ISOWEEK)", expected: 2},
+		{expr: "DATE_DIFF(DATE '1970-01-05', DATE '1969-12-28', WEEK)", expected: 1},
+		{expr: "DATE_DIFF(DATE '2024-03-01', DATE '2024-02-29', MONTH)", expected: 1},
+		{expr: "DATE_DIFF(DATE '2024-01-31', DATE '2023-12-31', QUARTER)", expected: 1},
+		{expr: "DATE_DIFF(DATE '2023-12-31', DATE '2023-10-01', QUARTER)", expected: 0},
+		{expr: "DATE_DIFF(DATE '2023-01-01', DATE '2024-12-31', QUARTER)", expected: -7},
+		{expr: "DATETIME_DIFF(DATETIME '2010-07-07 10:20:00', DATETIME '2008-12-25 15:30:00', DAY)", expected: 559},
+		{expr: "DATETIME_DIFF(DATETIME '2017-12-30 00:00:00', DATETIME '2014-12-30 00:00:00', ISOYEAR)", expected: 2},
+		{expr: "DATETIME_DIFF(DATETIME '2017-12-18 00:00:00', DATETIME '2017-12-16 23:00:00', WEEK(MONDAY))", expected: 1},
+		{expr: "DATETIME_DIFF(DATETIME '2017-12-17 01:00:00', DATETIME '2017-12-16 23:00:00', WEEK)", expected: 1},
+		{expr: "DATETIME_DIFF(DATETIME '2020-04-01 00:00:00', DATETIME '2020-03-31 23:59:59', QUARTER)", expected: 1},
+		{expr: "DATETIME_DIFF(DATETIME '2020-01-01 05:00:00', DATETIME '2020-01-01 02:00:00', HOUR)", expected: 3},
+		{expr: "TIMESTAMP_DIFF(TIMESTAMP '2010-07-07 10:20:00+00', TIMESTAMP '2008-12-25 15:30:00+00', HOUR)", expected: 13410},
+		{expr: "TIMESTAMP_DIFF(TIMESTAMP '2001-02-01 01:00:00', TIMESTAMP '2001-02-01 00:00:01', HOUR)", expected: 0},
+		{expr: "TIMESTAMP_DIFF(TIMESTAMP '2018-08-14', TIMESTAMP '2018-10-14', DAY)", expected: -61},
+		{expr: "TIMESTAMP_DIFF(TIMESTAMP '2020-01-01 00:00:00+00', TIMESTAMP '2020-01-02 12:00:00+00', DAY)", expected: -1},
+		{expr: "TIMESTAMP_DIFF(TIMESTAMP('2021-03-15', 'America/Los_Angeles'), TIMESTAMP('2021-03-14', 'America/Los_Angeles'), HOUR)", expected: 23},
+		{expr: "TIMESTAMP_DIFF(TIMESTAMP('2021-03-15', 'America/Los_Angeles'), TIMESTAMP('2021-03-14', 'America/Los_Angeles'), DAY)", expected: 0},
+	} {
+		test := test
+		t.Run(test.expr, func(t *testing.T) {
+			it, err := client.Query("SELECT " + test.expr).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]bigquery.Value{test.expected}, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	ctx := context.Background()
+
+	const (
+		projectName = "test"
+	)
+
+	path := filepath.Join(t.TempDir(), "emulator.db")
+	storage, err := server.FileStorage(path, &server.FileStorageOption{
+		JournalMode: server.JournalModeWAL,
+		Synchronous: server.SynchronousNormal,
+		BusyTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startServer := func(t *testing.T) (*server.Server, *bigquery.Client, func()) {
+		bqServer, err := server.New(storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bqServer.SetProject(projectName); err != nil {
+			t.Fatal(err)
+		}
+		testServer := bqServer.TestServer()
+		client, err := bigquery.NewClient(
+			ctx,
+			projectName,
+			option.WithEndpoint(testServer.URL),
+			option.WithoutAuthentication(),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bqServer, client, func() {
+			client.Close()
+			testServer.Close()
+			bqServer.Stop(ctx)
+		}
+	}
+
+	bqServer, client, stop := startServer(t)
+	if err := bqServer.Load(server.StructSource(types.NewProject(projectName, types.NewDataset("dataset1")))); err != nil {
+		t.Fatal(err)
+	}
+	job, err := client.Query("CREATE TABLE dataset1.persisted AS SELECT 1 AS id UNION ALL SELECT 2").Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Err(); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+
+	db, err := sql.Open("zetasqlite_sqlite3", fmt.Sprintf("file:%s", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var journalMode string
+	if err := db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		t.Fatal(err)
+	}
+	if journalMode != "wal" {
+		t.Fatalf("expected wal journal mode but got %s", journalMode)
+	}
+
+	_, client, stop = startServer(t)
+	defer stop()
+	it, err := client.Query("SELECT SUM(id) FROM dataset1.persisted").Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row []bigquery.Value
+	if err := it.Next(&row); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]bigquery.Value{int64(3)}, row); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+
+	if _, err := server.FileStorage(path, &server.FileStorageOption{
+		JournalMode: server.JournalModeWAL,
+		ReadOnly:    true,
+	}); err == nil {
+		t.Fatal("expected error for changing journal mode of read-only database")
+	}
+	readOnly, err := server.FileStorage(path, &server.FileStorageOption{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roDB, err := sql.Open("zetasqlite_sqlite3", string(readOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer roDB.Close()
+	if _, err := roDB.ExecContext(ctx, "CREATE TABLE should_fail (id INTEGER)"); err == nil {
+		t.Fatal("expected error for writing to read-only database")
+	}
+}
+
+func TestRestoreCatalogFromFileStorage(t *testing.T) {
+	ctx := context.Background()
+
+	const (
+		projectName = "test"
+	)
+
+	storage, err := server.FileStorage(filepath.Join(t.TempDir(), "emulator.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startServer := func(t *testing.T) (*bigquery.Client, func()) {
+		bqServer, err := server.New(storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bqServer.SetProject(projectName); err != nil {
+			t.Fatal(err)
+		}
+		// the seed is loaded on every start like the standalone server does.
+		dataset := types.NewDataset(
+			"dataset1",
+			types.NewTable(
+				"seed_table",
+				[]*types.Column{types.NewColumn("id", types.INT64)},
+				types.Data{{"id": 1}},
+			),
+		)
+		dataset.Routines = []*types.Routine{
+			{
+				ID: "add_one",
+				Metadata: map[string]interface{}{
+					"arguments": []interface{}{
+						map[string]interface{}{"name": "x", "dataType": map[string]interface{}{"typeKind": "INT64"}},
+					},
+					"definitionBody": "x + 1",
+				},
+			},
+		}
+		if err := bqServer.Load(server.StructSource(types.NewProject(projectName, dataset))); err != nil {
+			t.Fatal(err)
+		}
+		testServer := bqServer.TestServer()
+		client, err := bigquery.NewClient(
+			ctx,
+			projectName,
+			option.WithEndpoint(testServer.URL),
+			option.WithoutAuthentication(),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client, func() {
+			client.Close()
+			testServer.Close()
+			bqServer.Stop(ctx)
+		}
+	}
+	runQuery := func(t *testing.T, client *bigquery.Client, query string) {
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := status.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, stop := startServer(t)
+	if err := client.Dataset("dataset2").Create(ctx, &bigquery.DatasetMetadata{Description: "created at runtime"}); err != nil {
+		t.Fatal(err)
+	}
+	runQuery(t, client, "CREATE TABLE dataset1.runtime_table AS SELECT 1 AS id, 'alice' AS name")
+	runQuery(t, client, "CREATE TABLE dataset2.runtime_table2 (id INT64)")
+	runQuery(t, client, "CREATE VIEW dataset1.runtime_view AS SELECT name FROM dataset1.runtime_table")
+	runQuery(t, client, "INSERT dataset1.seed_table (id) VALUES (2)")
+	runQuery(t, client, "CREATE FUNCTION dataset1.add_two(x INT64) AS (x + 2)")
+	stop()
+
+	client, stop = startServer(t)
+	defer stop()
+
+	dataset, err := client.Dataset("dataset2").Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataset.Description != "created at runtime" {
+		t.Fatalf("failed to restore dataset description: %q", dataset.Description)
+	}
+	for _, table := range []*bigquery.Table{
+		client.Dataset("dataset1").Table("runtime_table"),
+		client.Dataset("dataset2").Table("runtime_table2"),
+		client.Dataset("dataset1").Table("runtime_view"),
+	} {
+		if _, err := table.Metadata(ctx); err != nil {
+			t.Fatalf("failed to restore %s: %v", table.TableID, err)
+		}
+	}
+	it, err := client.Query("SELECT name FROM dataset1.runtime_view").Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row []bigquery.Value
+	if err := it.Next(&row); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]bigquery.Value{"alice"}, row); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+
+	// the seed doesn't overwrite the rows of the table and the routines restored from the database file.
+	it, err = client.Query("SELECT dataset1.add_one(id), dataset1.add_two(id) FROM dataset1.seed_table ORDER BY id").Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]bigquery.Value
+	for {
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			if err == iterator.Done {
+				break
+			}
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if diff := cmp.Diff([][]bigquery.Value{{int64(2), int64(3)}, {int64(3), int64(4)}}, rows); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestFloatSpecialValues(t *testing.T) {
+	const (
+		projectName = "test"
+		datasetName = "dataset1"
+	)
+
+	ctx := context.Background()
+
+	project := types.NewProject(
+		projectName,
+		types.NewDataset(
+			datasetName,
+			types.NewTable(
+				"floats",
+				[]*types.Column{
+					types.NewColumn("f", types.FLOAT64),
+					types.NewColumn("fs", types.FLOAT64, types.ColumnMode(types.RepeatedMode)),
+				},
+				nil,
+			),
+		),
+	)
+	bqServer := newTestServer(t, server.StructSource(project))
+	testServer := startTestServer(t, bqServer)
+	client := newTestClient(t, testServer, projectName)
+
+	request := func(t *testing.T, method, path string, body interface{}, v interface{}) {
+		t.Helper()
+		var reader io.Reader
+		if body != nil {
+			b, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader = bytes.NewReader(b)
+		}
+		req, err := http.NewRequest(method, testServer.URL+path, reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", res.StatusCode, string(b))
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cellValues := func(row *bigqueryv2.TableRow) []interface{} {
+		values := make([]interface{}, 0, len(row.F))
+		for _, cell := range row.F {
+			values = append(values, cell.V)
+		}
+		return values
+	}
+
+	t.Run("query result", func(t *testing.T) {
+		var res bigqueryv2.QueryResponse
+		request(t, "POST", "/projects/test/queries", &bigqueryv2.QueryRequest{
+			Query: "SELECT IEEE_DIVIDE(0, 0), IEEE_DIVIDE(1, 0), IEEE_DIVIDE(-1, 0), [IEEE_DIVIDE(1, 0), 1.5], STRUCT(IEEE_DIVIDE(-1, 0) AS f)",
+		}, &res)
+		if len(res.Rows) != 1 {
+			t.Fatalf("expected 1 row but got %d", len(res.Rows))
+		}
+		expected := []interface{}{
+			"NaN",
+			"Infinity",
+			"-Infinity",
+			[]interface{}{map[string]interface{}{"v": "Infinity"}, map[string]interface{}{"v": "1.5"}},
+			map[string]interface{}{"f": []interface{}{map[string]interface{}{"v": "-Infinity"}}},
+		}
+		if diff := cmp.Diff(expected, cellValues(res.Rows[0])); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("insertAll and tabledata.list", func(t *testing.T) {
+		var insertRes bigqueryv2.TableDataInsertAllResponse
+		request(t, "POST", "/projects/test/datasets/dataset1/tables/floats/insertAll", &bigqueryv2.TableDataInsertAllRequest{
+			Rows: []*bigqueryv2.TableDataInsertAllRequestRows{
+				{Json: map[string]bigqueryv2.JsonValue{"f": "NaN", "fs": []interface{}{"Infinity", "-Infinity", "0.5"}}},
+			},
+		}, &insertRes)
+		if len(insertRes.InsertErrors) != 0 {
+			t.Fatalf("unexpected insert errors: %+v", insertRes.InsertErrors)
+		}
+
+		var list bigqueryv2.TableDataList
+		request(t, "GET", "/projects/test/datasets/dataset1/tables/floats/data", nil, &list)
+		if len(list.Rows) != 1 {
+			t.Fatalf("expected 1 row but got %d", len(list.Rows))
+		}
+		expected := []interface{}{
+			"NaN",
+			[]interface{}{
+				map[string]interface{}{"v": "Infinity"},
+				map[string]interface{}{"v": "-Infinity"},
+				map[string]interface{}{"v": "0.5"},
+			},
+		}
+		if diff := cmp.Diff(expected, cellValues(list.Rows[0])); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+
+		it, err := client.Query("SELECT IS_NAN(f), fs FROM dataset1.floats").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]bigquery.Value{true, []bigquery.Value{math.Inf(1), math.Inf(-1), 0.5}}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("query parameters", func(t *testing.T) {
+		query := client.Query("SELECT IS_NAN(@nan), @inf, @neg_inf, IS_INF(@inf), @neg_zero = 0")
+		query.Parameters = []bigquery.QueryParameter{
+			{Name: "nan", Value: math.NaN()},
+			{Name: "inf", Value: math.Inf(1)},
+			{Name: "neg_inf", Value: math.Inf(-1)},
+			{Name: "neg_zero", Value: math.Copysign(0, -1)},
+		}
+		it, err := query.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]bigquery.Value{true, math.Inf(1), math.Inf(-1), true, true}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	bqServer.SetReadOnly(true)
+	client := newTestClient(t, startTestServer(t, bqServer), "test")
+
+	table := client.Dataset("dataset1").Table("table_a")
+	t.Run("reads", func(t *testing.T) {
+		if _, err := table.Metadata(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := table.Read(ctx).Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		it, err := client.Query(`CREATE TEMP FUNCTION twice(x INT64) AS (x * 2);
+SELECT twice(COUNT(*)) FROM dataset1.table_a`).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]bigquery.Value{int64(4)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("dry run dml", func(t *testing.T) {
+		query := client.Query("DELETE FROM dataset1.table_a WHERE TRUE")
+		query.DryRun = true
+		if _, err := query.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for _, test := range []struct {
+		name  string
+		write func() error
+	}{
+		{
+			name: "create dataset",
+			write: func() error {
+				return client.Dataset("dataset2").Create(ctx, nil)
+			},
+		},
+		{
+			name: "create table",
+			write: func() error {
+				return client.Dataset("dataset1").Table("table_c").Create(ctx, &bigquery.TableMetadata{
+					Schema: bigquery.Schema{{Name: "id", Type: bigquery.IntegerFieldType}},
+				})
+			},
+		},
+		{
+			name: "delete table",
+			write: func() error {
+				return table.Delete(ctx)
+			},
+		},
+		{
+			name: "insert all",
+			write: func() error {
+				return client.Dataset("dataset1").Table("table_b").Inserter().Put(ctx, []*bigquery.ValuesSaver{
+					{
+						Schema: bigquery.Schema{{Name: "num", Type: bigquery.IntegerFieldType}},
+						Row:    []bigquery.Value{int64(1)},
+					},
+				})
+			},
+		},
+		{
+			name: "dml",
+			write: func() error {
+				_, err := client.Query("DELETE FROM dataset1.table_a WHERE TRUE").Read(ctx)
+				return err
+			},
+		},
+		{
+			name: "dml in script block",
+			write: func() error {
+				_, err := client.Query("IF TRUE THEN DELETE FROM dataset1.table_a WHERE TRUE; END IF").Read(ctx)
+				return err
+			},
+		},
+		{
+			name: "dml after multiple common table expressions",
+			write: func() error {
+				_, err := client.Query(`WITH a AS (SELECT 1 AS id), b AS (SELECT id FROM a)
+DELETE FROM dataset1.table_a WHERE id IN (SELECT id FROM b)`).Read(ctx)
+				return err
+			},
+		},
+		{
+			name: "temp table",
+			write: func() error {
+				_, err := client.Query("CREATE TEMP TABLE tmp AS SELECT 1 AS x; SELECT * FROM tmp").Read(ctx)
+				return err
+			},
+		},
+		{
+			name: "destination table",
+			write: func() error {
+				query := client.Query("SELECT 1 AS x")
+				query.Dst = client.Dataset("dataset1").Table("result")
+				_, err := query.Run(ctx)
+				return err
+			},
+		},
+		{
+			name: "load",
+			write: func() error {
+				source := bigquery.NewReaderSource(strings.NewReader("1,alice\n"))
+				_, err := client.Dataset("dataset1").Table("table_a").LoaderFrom(source).Run(ctx)
+				return err
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.write()
+			if err == nil {
+				t.Fatal("expected access denied error")
+			}
+			gerr, ok := err.(*googleapi.Error)
+			if !ok {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			if gerr.Code != http.StatusForbidden {
+				t.Errorf("unexpected status code %d: %v", gerr.Code, gerr)
+			}
+			if !strings.Contains(gerr.Message, "because the server is read-only") {
+				t.Errorf("unexpected message: %s", gerr.Message)
+			}
+		})
+	}
+
+	it, err := client.Query("SELECT COUNT(*) FROM dataset1.table_a").Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row []bigquery.Value
+	if err := it.Next(&row); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]bigquery.Value{int64(2)}, row); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestRandomSeed(t *testing.T) {
+	ctx := context.Background()
+
+	const (
+		projectName = "test"
+		query       = "SELECT GENERATE_UUID() AS id, RAND() AS r FROM UNNEST(GENERATE_ARRAY(1, 100)) AS x ORDER BY x"
+	)
+	uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	run := func(t *testing.T, seed *int64, query string) [][]bigquery.Value {
+		bqServer := newTestServer(t, server.StructSource(types.NewProject(projectName)))
+		if seed != nil {
+			bqServer.SetRandomSeed(*seed)
+		}
+		client := newTestClient(t, startTestServer(t, bqServer), projectName)
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		ids := map[string]struct{}{}
+		for _, row := range rows {
+			id, ok := row[0].(string)
+			if !ok || !uuidPattern.MatchString(id) {
+				t.Fatalf("unexpected uuid %v", row[0])
+			}
+			ids[id] = struct{}{}
+			if len(row) > 1 {
+				if r, ok := row[1].(float64); !ok || r < 0 || r >= 1 {
+					t.Fatalf("unexpected random value %v", row[1])
+				}
+			}
+		}
+		if len(ids) != len(rows) {
+			t.Fatalf("expected unique uuids but got %d unique values of %d rows", len(ids), len(rows))
+		}
+		return rows
+	}
+
+	t.Run("without seed", func(t *testing.T) {
+		if diff := cmp.Diff(run(t, nil, query), run(t, nil, query)); diff == "" {
+			t.Fatal("expected the different values without seed")
+		}
+	})
+	t.Run("with seed", func(t *testing.T) {
+		seed := int64(42)
+		first := run(t, &seed, query)
+		if diff := cmp.Diff(first, run(t, &seed, query)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		other := int64(43)
+		if diff := cmp.Diff(first, run(t, &other, query)); diff == "" {
+			t.Fatal("expected the different values for the different seed")
+		}
+		if rows := run(t, &seed, "SELECT GENERATE_UUID() AS id"); rows[0][0] == first[0][0] {
+			t.Fatalf("expected the different values for the different query but got %v", rows[0][0])
+		}
+	})
+}
+
+func TestJobCreationOptional(t *testing.T) {
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	testServer := startTestServer(t, bqServer)
+
+	query := func(t *testing.T, req *bigqueryv2.QueryRequest) *bigqueryv2.QueryResponse {
+		t.Helper()
+		b, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(fmt.Sprintf("%s/projects/test/queries", testServer.URL), "application/json", bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", res.StatusCode, string(body))
+		}
+		var v bigqueryv2.QueryResponse
+		if err := json.Unmarshal(body, &v); err != nil {
+			t.Fatal(err)
+		}
+		return &v
+	}
+	jobExists := func(t *testing.T, jobID string) bool {
+		t.Helper()
+		res, err := http.Get(fmt.Sprintf("%s/projects/test/jobs/%s", testServer.URL, jobID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	}
+
+	t.Run("inline", func(t *testing.T) {
+		res := query(t, &bigqueryv2.QueryRequest{
+			Query:           "SELECT id FROM dataset1.table_a ORDER BY id",
+			JobCreationMode: "JOB_CREATION_OPTIONAL",
+		})
+		if res.JobReference != nil {
+			t.Fatalf("unexpected job reference: %+v", res.JobReference)
+		}
+		if res.QueryId == "" {
+			t.Fatal("failed to get query id")
+		}
+		if len(res.Rows) != 2 {
+			t.Fatalf("expected 2 rows but got %d", len(res.Rows))
+		}
+		if jobExists(t, res.QueryId) {
+			t.Fatal("unexpected job is created for the short query")
+		}
+	})
+	for _, test := range []struct {
+		name string
+		req  *bigqueryv2.QueryRequest
+	}{
+		{
+			name: "job creation required",
+			req:  &bigqueryv2.QueryRequest{Query: "SELECT 1"},
+		},
+		{
+			name: "dml",
+			req: &bigqueryv2.QueryRequest{
+				Query:           "UPDATE dataset1.table_a SET name = 'carol' WHERE id = 2",
+				JobCreationMode: "JOB_CREATION_OPTIONAL",
+			},
+		},
+		{
+			name: "results exceed page size",
+			req: &bigqueryv2.QueryRequest{
+				Query:           "SELECT id FROM dataset1.table_a",
+				JobCreationMode: "JOB_CREATION_OPTIONAL",
+				MaxResults:      1,
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			res := query(t, test.req)
+			if res.JobReference == nil || res.JobReference.JobId == "" {
+				t.Fatal("failed to get job reference")
+			}
+			if res.QueryId != "" {
+				t.Fatalf("unexpected query id: %s", res.QueryId)
+			}
+			if !jobExists(t, res.JobReference.JobId) {
+				t.Fatalf("failed to find job %s", res.JobReference.JobId)
+			}
+		})
+	}
+}
+
+func TestCaseInsensitiveCollation(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name:     "equality",
+			query:    "SELECT id FROM dataset1.table_a WHERE COLLATE(name, 'und:ci') = 'ALICE'",
+			expected: [][]bigquery.Value{{int64(1)}},
+		},
+		{
+			name:     "binary equality",
+			query:    "SELECT id FROM dataset1.table_a WHERE COLLATE(name, '') = 'ALICE'",
+			expected: nil,
+		},
+		{
+			name:     "ordering",
+			query:    "SELECT name FROM UNNEST(['b', 'A', 'C']) AS name ORDER BY COLLATE(name, 'und:ci')",
+			expected: [][]bigquery.Value{{"A"}, {"b"}, {"C"}},
+		},
+		{
+			name:     "grouping",
+			query:    "SELECT COUNT(*) AS cnt FROM UNNEST(['a', 'A', 'b']) AS name GROUP BY COLLATE(name, 'und:ci') ORDER BY cnt",
+			expected: [][]bigquery.Value{{int64(1)}, {int64(2)}},
+		},
+		{
+			name:     "unicode equality",
+			query:    `SELECT COLLATE('Stra\u00dfe', 'und:ci') = 'STRASSE', COLLATE('e\u0301', 'und:ci') = '\u00c9', COLLATE('e', 'und:ci') = '\u00e9'`,
+			expected: [][]bigquery.Value{{true, true, false}},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := client.Query("SELECT COLLATE('a', 'und:ci') = COLLATE('A', 'binary')").Read(ctx); err == nil {
+		t.Fatal("expected collation conflict error")
+	}
+
+	for _, query := range []string{
+		"CREATE TABLE dataset1.ci_names (id INT64, name STRING COLLATE 'und:ci', code STRING)",
+		"INSERT INTO dataset1.ci_names (id, name, code) VALUES (1, 'alice', 'a'), (2, 'ALICE', 'A'), (3, 'bob', 'b'), (4, 'Carol', 'c')",
+	} {
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := job.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	md, err := client.Dataset("dataset1").Table("ci_names").Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Schema[1].Collation != "und:ci" || md.Schema[2].Collation != "" {
+		t.Fatalf("unexpected collations: %q, %q", md.Schema[1].Collation, md.Schema[2].Collation)
+	}
+	// the collation of the failed statement isn't applied to the table of the same name created later.
+	if _, err := client.Query("CREATE TABLE dataset1.ci_failed (name STRING COLLATE 'und:ci', name STRING)").Read(ctx); err == nil {
+		t.Fatal("expected duplicate column error")
+	}
+	job, err := client.Query("CREATE TABLE dataset1.ci_failed (name STRING)").Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := job.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	failedMD, err := client.Dataset("dataset1").Table("ci_failed").Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failedMD.Schema[0].Collation != "" {
+		t.Fatalf("unexpected collation: %q", failedMD.Schema[0].Collation)
+	}
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name:     "column equality",
+			query:    "SELECT id FROM dataset1.ci_names WHERE name = 'Alice' ORDER BY id",
+			expected: [][]bigquery.Value{{int64(1)}, {int64(2)}},
+		},
+		{
+			name:     "binary column equality",
+			query:    "SELECT id FROM dataset1.ci_names WHERE code = 'a'",
+			expected: [][]bigquery.Value{{int64(1)}},
+		},
+		{
+			name:     "column ordering",
+			query:    "SELECT id FROM dataset1.ci_names WHERE id > 2 ORDER BY name",
+			expected: [][]bigquery.Value{{int64(3)}, {int64(4)}},
+		},
+		{
+			name:     "column grouping",
+			query:    "SELECT COUNT(*) AS cnt FROM dataset1.ci_names GROUP BY name ORDER BY cnt",
+			expected: [][]bigquery.Value{{int64(1)}, {int64(1)}, {int64(2)}},
+		},
+		{
+			name:     "column distinct",
+			query:    "SELECT COUNT(*) FROM (SELECT DISTINCT name FROM dataset1.ci_names)",
+			expected: [][]bigquery.Value{{int64(3)}},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNormalizeFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:     "composed and decomposed",
+			query:    `SELECT NORMALIZE('e\u0301') = '\u00e9', NORMALIZE('\u00e9', NFD) = 'e\u0301', CHAR_LENGTH(NORMALIZE('\u00e9', NFD)), 'e\u0301' = '\u00e9'`,
+			expected: []bigquery.Value{true, true, int64(2), false},
+		},
+		{
+			name:     "compatibility forms",
+			query:    `SELECT NORMALIZE('\ufb01', NFKC), NORMALIZE('\ufb01', NFC), NORMALIZE('x\u00b2', NFKD)`,
+			expected: []bigquery.Value{"fi", "\ufb01", "x2"},
+		},
+		{
+			name: "casefold",
+			query: `
+SELECT
+  NORMALIZE_AND_CASEFOLD('Stra\u00dfe') = NORMALIZE_AND_CASEFOLD('STRASSE'),
+  NORMALIZE_AND_CASEFOLD('\u03a3\u038a\u03a3\u03a5\u03a6\u039f\u03a3') = NORMALIZE_AND_CASEFOLD('\u03c3\u03af\u03c3\u03c5\u03c6\u03bf\u03c2'),
+  NORMALIZE_AND_CASEFOLD('\u00c9', NFD) = 'e\u0301',
+  NORMALIZE_AND_CASEFOLD('\u00c9') = NORMALIZE_AND_CASEFOLD('E'),
+  NORMALIZE_AND_CASEFOLD('\u3392', NFKC),
+  NORMALIZE_AND_CASEFOLD('\ufb01', NFKD),
+  NORMALIZE_AND_CASEFOLD(CAST(NULL AS STRING))`,
+			expected: []bigquery.Value{true, true, true, false, "mhz", "fi", nil},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAggregateFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:     "any_value",
+			query:    "SELECT ANY_VALUE(x) FROM UNNEST([CAST(NULL AS INT64), 3, 3]) AS x",
+			expected: []bigquery.Value{int64(3)},
+		},
+		{
+			name: "any_value having",
+			query: `
+SELECT
+  ANY_VALUE(name HAVING MAX score),
+  ANY_VALUE(name HAVING MIN score)
+FROM UNNEST([
+  STRUCT('a' AS name, 2 AS score),
+  STRUCT('b' AS name, 5 AS score),
+  STRUCT('c' AS name, 1 AS score),
+  STRUCT('d' AS name, NULL AS score)
+])`,
+			expected: []bigquery.Value{"b", "c"},
+		},
+		{
+			name:     "logical_and",
+			query:    "SELECT LOGICAL_AND(x > 1), LOGICAL_AND(x > 0) FROM UNNEST([1, 2, NULL]) AS x",
+			expected: []bigquery.Value{false, true},
+		},
+		{
+			name:     "logical_and over empty input",
+			query:    "SELECT LOGICAL_AND(x), LOGICAL_OR(x) FROM UNNEST([CAST(NULL AS BOOL)]) AS x",
+			expected: []bigquery.Value{nil, nil},
+		},
+		{
+			name:     "logical_or",
+			query:    "SELECT LOGICAL_OR(x), LOGICAL_OR(NOT x) FROM UNNEST([false, false, NULL]) AS x",
+			expected: []bigquery.Value{false, true},
+		},
+		{
+			name:     "countif",
+			query:    "SELECT COUNTIF(x > 1), COUNTIF(x > 10) FROM UNNEST([1, 2, 3, NULL]) AS x",
+			expected: []bigquery.Value{int64(2), int64(0)},
+		},
+		{
+			name:     "bit_and/bit_or/bit_xor",
+			query:    "SELECT BIT_AND(x), BIT_OR(x), BIT_XOR(x), BIT_XOR(DISTINCT x) FROM UNNEST([12, 10, 10, 1, NULL]) AS x",
+			expected: []bigquery.Value{int64(0), int64(15), int64(13), int64(7)},
+		},
+		{
+			name:     "bit_or with negative value",
+			query:    "SELECT BIT_OR(x), BIT_XOR(x) FROM UNNEST([-1, 5]) AS x",
+			expected: []bigquery.Value{int64(-1), int64(-6)},
+		},
+		{
+			name:     "bit aggregates over empty input",
+			query:    "SELECT BIT_AND(x), BIT_OR(x), BIT_XOR(x) FROM UNNEST([CAST(NULL AS INT64)]) AS x",
+			expected: []bigquery.Value{nil, nil, nil},
+		},
+		{
+			name: "count distinct composite values",
+			query: `
+WITH t AS (
+  SELECT * FROM UNNEST([
+    STRUCT('x' AS a, 1 AS b),
+    STRUCT('x' AS a, 1 AS b),
+    STRUCT('x' AS a, 2 AS b),
+    STRUCT('y' AS a, NULL AS b),
+    STRUCT('y' AS a, NULL AS b),
+    STRUCT(NULL AS a, NULL AS b)
+  ])
+)
+SELECT
+  COUNT(DISTINCT (a, b)),
+  COUNT(DISTINCT a),
+  COUNT(DISTINCT STRUCT(a AS x, b AS y)),
+  COUNT(DISTINCT CONCAT(a, '-', CAST(b AS STRING))),
+  COUNT(DISTINCT IF(a = 'y', NULL, STRUCT(a, b)))
+FROM t`,
+			expected: []bigquery.Value{int64(4), int64(2), int64(4), int64(2), int64(3)},
+		},
+		{
+			name:     "count distinct over null values",
+			query:    "SELECT COUNT(DISTINCT x), COUNT(x), COUNT(*) FROM UNNEST([CAST(NULL AS STRING), NULL]) AS x",
+			expected: []bigquery.Value{int64(0), int64(0), int64(2)},
+		},
+		{
+			name: "count distinct at higher cardinality",
+			query: `
+SELECT
+  COUNT(DISTINCT MOD(x, 5000)),
+  COUNT(DISTINCT (MOD(x, 100), MOD(x, 7))),
+  COUNT(DISTINCT CAST(x AS STRING)),
+  (SELECT COUNT(*) FROM (SELECT DISTINCT MOD(y, 3333) FROM UNNEST(GENERATE_ARRAY(1, 20000)) AS y))
+FROM UNNEST(GENERATE_ARRAY(1, 20000)) AS x`,
+			expected: []bigquery.Value{int64(5000), int64(700), int64(20000), int64(3333)},
+		},
+		{
+			name: "count distinct grouped by keys",
+			query: `
+WITH t AS (
+  SELECT MOD(x, 3) AS k, MOD(x, 1000) AS v, IF(MOD(x, 10) = 0, NULL, STRUCT(MOD(x, 50) AS a, 'x' AS b)) AS s
+  FROM UNNEST(GENERATE_ARRAY(1, 30000)) AS x
+)
+SELECT k, COUNT(DISTINCT v) AS cnt FROM t WHERE k > 0 GROUP BY k HAVING COUNT(DISTINCT v) > 0 ORDER BY cnt DESC, k LIMIT 1`,
+			expected: []bigquery.Value{int64(1), int64(1000)},
+		},
+		{
+			name: "count distinct struct values with filter",
+			query: `
+WITH t AS (
+  SELECT IF(MOD(x, 10) = 0, NULL, STRUCT(MOD(x, 50) AS a, 'x' AS b)) AS s
+  FROM UNNEST(GENERATE_ARRAY(1, 30000)) AS x
+)
+SELECT COUNT(DISTINCT s) FROM t`,
+			expected: []bigquery.Value{int64(45)},
+		},
+		{
+			name: "string_agg",
+			query: `
+SELECT
+  STRING_AGG(x ORDER BY x),
+  STRING_AGG(x, ' | ' ORDER BY x),
+  STRING_AGG(DISTINCT x, '' ORDER BY x DESC),
+  STRING_AGG(x, '-' ORDER BY x LIMIT 2)
+FROM UNNEST(['b', 'a', NULL, 'c', 'a']) AS x`,
+			expected: []bigquery.Value{"a,a,b,c", "a | a | b | c", "cba", "a-a"},
+		},
+		{
+			name:     "string_agg over empty input",
+			query:    "SELECT STRING_AGG(x), STRING_AGG(x, '') FROM UNNEST([CAST(NULL AS STRING)]) AS x",
+			expected: []bigquery.Value{nil, nil},
+		},
+		{
+			name: "array_concat_agg",
+			query: `
+SELECT
+  ARRAY_CONCAT_AGG(x ORDER BY ARRAY_LENGTH(x)),
+  ARRAY_CONCAT_AGG(x ORDER BY ARRAY_LENGTH(x) LIMIT 2)
+FROM UNNEST([
+  STRUCT([1, 2, 3] AS x),
+  STRUCT(CAST(NULL AS ARRAY<INT64>) AS x),
+  STRUCT([4] AS x),
+  STRUCT([5, 6] AS x)
+])`,
+			expected: []bigquery.Value{
+				[]bigquery.Value{int64(4), int64(5), int64(6), int64(1), int64(2), int64(3)},
+				[]bigquery.Value{int64(4), int64(5), int64(6)},
+			},
+		},
+		{
+			name: "array_concat_agg over empty input",
+			query: `
+SELECT
+  (SELECT ARRAY_CONCAT_AGG(x) IS NULL FROM (SELECT CAST(NULL AS ARRAY<INT64>) AS x)),
+  (SELECT ARRAY_CONCAT_AGG(x) IS NULL FROM (SELECT [1] AS x) WHERE FALSE)`,
+			expected: []bigquery.Value{true, true},
+		},
+		{
+			name: "approx_top_sum",
+			query: `
+SELECT
+  APPROX_TOP_SUM(x, w, 2),
+  APPROX_TOP_SUM(x, w, 10)
+FROM UNNEST([
+  STRUCT('apple' AS x, 3 AS w),
+  STRUCT('pear' AS x, 2 AS w),
+  STRUCT('apple' AS x, 0 AS w),
+  STRUCT('banana' AS x, 5 AS w),
+  STRUCT('kiwi' AS x, NULL AS w)
+])`,
+			expected: []bigquery.Value{
+				[]bigquery.Value{
+					[]bigquery.Value{"banana", int64(5)},
+					[]bigquery.Value{"apple", int64(3)},
+				},
+				[]bigquery.Value{
+					[]bigquery.Value{"banana", int64(5)},
+					[]bigquery.Value{"apple", int64(3)},
+					[]bigquery.Value{"pear", int64(2)},
+					[]bigquery.Value{"kiwi", nil},
+				},
+			},
+		},
+		{
+			name: "array_agg order by",
+			query: `
+SELECT
+  ARRAY_AGG(IFNULL(x, '-') ORDER BY k),
+  ARRAY_AGG(IFNULL(x, '-') ORDER BY k DESC NULLS FIRST LIMIT 3),
+  ARRAY_AGG(x IGNORE NULLS ORDER BY k DESC)
+FROM UNNEST([
+  STRUCT('a' AS x, 2 AS k),
+  STRUCT('b' AS x, NULL AS k),
+  STRUCT('c' AS x, 1 AS k),
+  STRUCT('d' AS x, 2 AS k),
+  STRUCT(NULL AS x, NULL AS k),
+  STRUCT('e' AS x, 1 AS k)
+])`,
+			expected: []bigquery.Value{
+				[]bigquery.Value{"b", "-", "c", "e", "a", "d"},
+				[]bigquery.Value{"b", "-", "a"},
+				[]bigquery.Value{"a", "d", "c", "e", "b"},
+			},
+		},
+		{
+			name: "ordered array subquery",
+			query: `
+SELECT
+  ARRAY(SELECT x FROM UNNEST([3, 1, 4, 1, 5, 9, 2, 6]) AS x ORDER BY x DESC),
+  ARRAY(SELECT x FROM UNNEST([3, 1, 4, 1, 5, 9, 2, 6]) AS x ORDER BY x LIMIT 3),
+  ARRAY(SELECT AS STRUCT x, o FROM UNNEST(['c', 'a', 'b']) AS x WITH OFFSET AS o ORDER BY x)`,
+			expected: []bigquery.Value{
+				[]bigquery.Value{int64(9), int64(6), int64(5), int64(4), int64(3), int64(2), int64(1), int64(1)},
+				[]bigquery.Value{int64(1), int64(1), int64(2)},
+				[]bigquery.Value{
+					[]bigquery.Value{"a", int64(1)},
+					[]bigquery.Value{"b", int64(2)},
+					[]bigquery.Value{"c", int64(0)},
+				},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRegexpFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:     "regexp_extract with capturing group",
+			query:    `SELECT REGEXP_EXTRACT('foo@example.com', r'^([a-z]+)@'), REGEXP_SUBSTR('foo@example.com', r'@(\w+)')`,
+			expected: []bigquery.Value{"foo", "example"},
+		},
+		{
+			name:     "regexp_extract with position and occurrence",
+			query:    `SELECT REGEXP_EXTRACT('áb1 áb2 áb3', r'b\d', 2, 2), REGEXP_EXTRACT('abc', 'x')`,
+			expected: []bigquery.Value{"b2", nil},
+		},
+		{
+			name:     "regexp_extract_all",
+			query:    `SELECT REGEXP_EXTRACT_ALL('a1b22c333', r'\d+'), REGEXP_EXTRACT_ALL('key=1,k=2', r'(\w+)=')`,
+			expected: []bigquery.Value{[]bigquery.Value{"1", "22", "333"}, []bigquery.Value{"key", "k"}},
+		},
+		{
+			name:     "regexp_instr",
+			query:    `SELECT REGEXP_INSTR('ab@cd-ef', '@[^-]*'), REGEXP_INSTR('a-b-c-d', '-', 3), REGEXP_INSTR('a-b-c-d', '-', 1, 3), REGEXP_INSTR('a-b-c-d', '-(c)', 1, 1, 1), REGEXP_INSTR('abc', 'x')`,
+			expected: []bigquery.Value{int64(3), int64(4), int64(6), int64(6), int64(0)},
+		},
+		{
+			name:     "regexp_replace with backreference",
+			query:    `SELECT REGEXP_REPLACE('alice@example', r'(\w+)@(\w+)', r'\2:\1'), REGEXP_REPLACE('abc', 'b', r'[\0]'), REGEXP_REPLACE('10', r'(\d+)', '$\\1')`,
+			expected: []bigquery.Value{"example:alice", "a[b]c", "$10"},
+		},
+		{
+			name:     "unicode character class",
+			query:    `SELECT REGEXP_CONTAINS('日本語', r'\p{Han}'), REGEXP_EXTRACT_ALL('Ωmega αβ', r'\p{Greek}+')`,
+			expected: []bigquery.Value{true, []bigquery.Value{"Ω", "αβ"}},
+		},
+		{
+			name:     "split",
+			query:    `SELECT SPLIT('a,b,,c', ',')`,
+			expected: []bigquery.Value{[]bigquery.Value{"a", "b", "", "c"}},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, query := range []string{
+		"SELECT REGEXP_CONTAINS('abc', '(')",
+		"SELECT REGEXP_EXTRACT('abc', '(a)(b)')",
+	} {
+		if _, err := client.Query(query).Read(ctx); err == nil {
+			t.Fatalf("expected error for %s", query)
+		}
+	}
+}
+
+func TestSchemaDDL(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	exec := func(query string) error {
+		_, err := client.Query(query).Read(ctx)
+		return err
+	}
+
+	if err := exec(`CREATE SCHEMA new_dataset OPTIONS(
+  location = 'US',
+  default_table_expiration_days = 7,
+  description = 'created by DDL',
+  labels = [('env', 'test'), ('team', 'data')]
+)`); err != nil {
+		t.Fatal(err)
+	}
+	md, err := client.Dataset("new_dataset").Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Location != "US" {
+		t.Errorf("unexpected location: %s", md.Location)
+	}
+	if md.DefaultTableExpiration != 7*24*time.Hour {
+		t.Errorf("unexpected default table expiration: %s", md.DefaultTableExpiration)
+	}
+	if md.Description != "created by DDL" {
+		t.Errorf("unexpected description: %s", md.Description)
+	}
+	if diff := cmp.Diff(map[string]string{"env": "test", "team": "data"}, md.Labels); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+
+	if err := exec("CREATE SCHEMA new_dataset"); err == nil {
+		t.Fatal("expected error for the existing schema")
+	}
+	if err := exec("CREATE SCHEMA IF NOT EXISTS new_dataset OPTIONS(description = 'ignored')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("CREATE SCHEMA invalid_location OPTIONS(location = 'moon-central1')"); err == nil {
+		t.Fatal("expected error for the invalid location")
+	}
+
+	if err := exec("CREATE TABLE new_dataset.t (id INT64)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("DROP SCHEMA new_dataset"); err == nil {
+		t.Fatal("expected error for dropping the schema that has tables")
+	}
+	if err := exec("DROP SCHEMA new_dataset CASCADE"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Dataset("new_dataset").Metadata(ctx); err == nil {
+		t.Fatal("expected the dataset to be deleted")
+	}
+	if err := exec("DROP SCHEMA IF EXISTS new_dataset"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDDLExistenceGuards(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	exec := func(query string) error {
+		_, err := client.Query(query).Read(ctx)
+		return err
+	}
+	queryInt := func(query string) int64 {
+		t.Helper()
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		return row[0].(int64)
+	}
+
+	const migration = `
+CREATE SCHEMA IF NOT EXISTS migration;
+CREATE TABLE IF NOT EXISTS migration.items (id INT64, name STRING);
+CREATE VIEW IF NOT EXISTS migration.item_names AS SELECT name FROM migration.items;
+CREATE MATERIALIZED VIEW IF NOT EXISTS migration.item_count AS SELECT COUNT(*) AS n FROM migration.items;
+CREATE FUNCTION IF NOT EXISTS migration.twice(x INT64) AS (x * 2);
+CREATE TABLE FUNCTION IF NOT EXISTS migration.items_before(max_id INT64) AS SELECT * FROM migration.items WHERE id < max_id;
+DROP PROCEDURE IF EXISTS migration.cleanup;
+DROP TABLE IF EXISTS migration.legacy_table;
+DROP VIEW IF EXISTS migration.legacy_view;
+DROP MATERIALIZED VIEW IF EXISTS migration.legacy_materialized_view;
+DROP FUNCTION IF EXISTS migration.legacy_function;
+DROP TABLE FUNCTION IF EXISTS migration.legacy_table_function;
+DROP SCHEMA IF EXISTS legacy_dataset CASCADE`
+
+	if err := exec(migration); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("INSERT INTO migration.items (id, name) VALUES (1, 'alice'), (2, 'bob')"); err != nil {
+		t.Fatal(err)
+	}
+	// the second run must not overwrite the objects.
+	if err := exec(migration); err != nil {
+		t.Fatal(err)
+	}
+	if n := queryInt("SELECT COUNT(*) FROM migration.item_names"); n != 2 {
+		t.Fatalf("failed to keep the rows of the table: %d", n)
+	}
+	if n := queryInt("SELECT migration.twice(n) FROM migration.item_count"); n != 4 {
+		t.Fatalf("unexpected result of the function and the materialized view: %d", n)
+	}
+	if n := queryInt("SELECT COUNT(*) FROM migration.items_before(2)"); n != 1 {
+		t.Fatalf("unexpected result of the table function: %d", n)
+	}
+	for tableID, want := range map[string]bigquery.TableType{
+		"items":      bigquery.RegularTable,
+		"item_names": bigquery.ViewTable,
+		"item_count": bigquery.MaterializedView,
+	} {
+		md, err := client.Dataset("migration").Table(tableID).Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.Type != want {
+			t.Errorf("unexpected type of %s: %s", tableID, md.Type)
+		}
+	}
+
+	t.Run("or replace with if not exists", func(t *testing.T) {
+		for _, query := range []string{
+			"CREATE OR REPLACE TABLE IF NOT EXISTS migration.items (id INT64)",
+			"CREATE OR REPLACE VIEW IF NOT EXISTS migration.item_names AS SELECT 1 AS id",
+			"CREATE OR REPLACE MATERIALIZED VIEW IF NOT EXISTS migration.item_count AS SELECT 1 AS n",
+			"CREATE OR REPLACE FUNCTION IF NOT EXISTS migration.twice(x INT64) AS (x)",
+			"CREATE OR REPLACE PROCEDURE IF NOT EXISTS migration.cleanup() BEGIN SELECT 1; END",
+			"CREATE OR REPLACE TABLE FUNCTION IF NOT EXISTS migration.items_before(max_id INT64) AS SELECT 1 AS id",
+			"CREATE OR REPLACE SCHEMA IF NOT EXISTS migration",
+		} {
+			err := exec(query)
+			if err == nil {
+				t.Fatalf("expected error for %s", query)
+			}
+			if !strings.Contains(err.Error(), "cannot be used with IF NOT EXISTS") {
+				t.Fatalf("unexpected error for %s: %v", query, err)
+			}
+		}
+	})
+	t.Run("type mismatch", func(t *testing.T) {
+		for _, query := range []string{
+			"CREATE VIEW IF NOT EXISTS migration.items AS SELECT 1 AS id",
+			"CREATE TABLE IF NOT EXISTS migration.item_names (id INT64)",
+			"CREATE MATERIALIZED VIEW IF NOT EXISTS migration.item_names AS SELECT 1 AS n",
+			"DROP VIEW IF EXISTS migration.items",
+			"DROP TABLE IF EXISTS migration.item_count",
+		} {
+			if err := exec(query); err == nil {
+				t.Fatalf("expected error for %s", query)
+			}
+		}
+		if n := queryInt("SELECT COUNT(*) FROM migration.items"); n != 2 {
+			t.Fatalf("failed to keep the rows of the table: %d", n)
+		}
+	})
+	t.Run("drop", func(t *testing.T) {
+		const cleanup = `
+DROP TABLE FUNCTION IF EXISTS migration.items_before;
+DROP FUNCTION IF EXISTS migration.twice;
+DROP MATERIALIZED VIEW IF EXISTS migration.item_count;
+DROP VIEW IF EXISTS migration.item_names;
+DROP TABLE IF EXISTS migration.items;
+DROP PROCEDURE IF EXISTS migration.cleanup;
+DROP SCHEMA IF EXISTS migration`
+		for i := 0; i < 2; i++ {
+			if err := exec(cleanup); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := client.Dataset("migration").Metadata(ctx); err == nil {
+			t.Fatal("expected the dataset to be deleted")
+		}
+	})
+}
+
+func TestCreateTableLikeAndCopy(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	exec := func(query string) error {
+		_, err := client.Query(query).Read(ctx)
+		return err
+	}
+	rowCount := func(table string) int64 {
+		t.Helper()
+		it, err := client.Query(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		return row[0].(int64)
+	}
+
+	if err := client.Dataset("dataset1").Table("source").Create(ctx, &bigquery.TableMetadata{
+		Description: "source table",
+		Schema: bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType, Description: "the id of the item"},
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "dt", Type: bigquery.DateFieldType},
+		},
+		TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "dt"},
+		Clustering:       &bigquery.Clustering{Fields: []string{"name"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("INSERT INTO dataset1.source (id, name, dt) VALUES (1, 'alice', '2024-01-01'), (2, 'bob', '2024-01-02')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("CREATE VIEW dataset1.source_view AS SELECT id FROM dataset1.source"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("like", func(t *testing.T) {
+		if err := exec("CREATE TABLE dataset1.liked LIKE dataset1.source"); err != nil {
+			t.Fatal(err)
+		}
+		md, err := client.Dataset("dataset1").Table("liked").Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(md.Schema) != 3 || md.Schema[0].Description != "the id of the item" {
+			t.Fatalf("failed to copy the schema: %+v", md.Schema)
+		}
+		if md.Description != "source table" {
+			t.Fatalf("failed to copy the description: %q", md.Description)
+		}
+		if md.TimePartitioning == nil || md.TimePartitioning.Field != "dt" {
+			t.Fatalf("failed to copy the partitioning: %+v", md.TimePartitioning)
+		}
+		if md.Clustering == nil || len(md.Clustering.Fields) != 1 || md.Clustering.Fields[0] != "name" {
+			t.Fatalf("failed to copy the clustering: %+v", md.Clustering)
+		}
+		if n := rowCount("dataset1.liked"); n != 0 {
+			t.Fatalf("LIKE must not copy the rows: %d", n)
+		}
+	})
+	t.Run("copy across datasets", func(t *testing.T) {
+		if err := exec("CREATE SCHEMA other"); err != nil {
+			t.Fatal(err)
+		}
+		if err := exec("CREATE TABLE other.copied COPY dataset1.source OPTIONS(description = 'copied table')"); err != nil {
+			t.Fatal(err)
+		}
+		if n := rowCount("other.copied"); n != 2 {
+			t.Fatalf("failed to copy the rows: %d", n)
+		}
+		md, err := client.Dataset("other").Table("copied").Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.Description != "copied table" {
+			t.Fatalf("failed to override the description by the options: %q", md.Description)
+		}
+		if md.Schema[0].Description != "the id of the item" {
+			t.Fatalf("failed to keep the column description: %+v", md.Schema)
+		}
+	})
+	t.Run("existing table", func(t *testing.T) {
+		if err := exec("CREATE TABLE other.copied LIKE dataset1.source"); err == nil {
+			t.Fatal("expected the error for the existing table")
+		}
+		if err := exec("CREATE TABLE IF NOT EXISTS other.copied LIKE dataset1.source"); err != nil {
+			t.Fatal(err)
+		}
+		if n := rowCount("other.copied"); n != 2 {
+			t.Fatalf("IF NOT EXISTS must keep the existing table: %d", n)
+		}
+		if err := exec("CREATE OR REPLACE TABLE other.copied LIKE dataset1.source"); err != nil {
+			t.Fatal(err)
+		}
+		if n := rowCount("other.copied"); n != 0 {
+			t.Fatalf("OR REPLACE must replace the existing table: %d", n)
+		}
+	})
+	t.Run("view", func(t *testing.T) {
+		for _, query := range []string{
+			"CREATE TABLE dataset1.from_view LIKE dataset1.source_view",
+			"CREATE TABLE dataset1.from_view COPY dataset1.source_view",
+		} {
+			if err := exec(query); err == nil {
+				t.Fatalf("expected error for %s", query)
+			}
+		}
+	})
+	t.Run("missing source", func(t *testing.T) {
+		err := exec("CREATE TABLE dataset1.from_missing LIKE dataset1.missing")
+		if err == nil || !strings.Contains(err.Error(), "Not found: Table") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestAlterTable(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	exec := func(query string) error {
+		_, err := client.Query(query).Read(ctx)
+		return err
+	}
+
+	if err := exec("CREATE TABLE dataset1.items (id INT64, name STRING)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("INSERT INTO dataset1.items (id, name) VALUES (1, 'alice'), (2, 'bob')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("CREATE TABLE dataset1.taken (id INT64)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("CREATE VIEW dataset1.items_view AS SELECT id FROM dataset1.items"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("set options", func(t *testing.T) {
+		if err := exec(`ALTER TABLE dataset1.items SET OPTIONS(description = 'the items', labels = [("env", "test")], expiration_timestamp = TIMESTAMP '2099-01-01 00:00:00 UTC')`); err != nil {
+			t.Fatal(err)
+		}
+		md, err := client.Dataset("dataset1").Table("items").Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.Description != "the items" {
+			t.Fatalf("failed to set the description: %q", md.Description)
+		}
+		if md.Labels["env"] != "test" {
+			t.Fatalf("failed to set the labels: %v", md.Labels)
+		}
+		if !md.ExpirationTime.Equal(time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("failed to set the expiration time: %v", md.ExpirationTime)
+		}
+		if len(md.Schema) != 2 {
+			t.Fatalf("SET OPTIONS must keep the schema: %+v", md.Schema)
+		}
+	})
+	t.Run("rename onto the existing table", func(t *testing.T) {
+		err := exec("ALTER TABLE dataset1.items RENAME TO taken")
+		if err == nil || !strings.Contains(err.Error(), "Already Exists") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("rename", func(t *testing.T) {
+		if err := exec("ALTER TABLE dataset1.items RENAME TO renamed"); err != nil {
+			t.Fatal(err)
+		}
+		md, err := client.Dataset("dataset1").Table("renamed").Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.Description != "the items" {
+			t.Fatalf("failed to keep the options: %q", md.Description)
+		}
+		if _, err := client.Dataset("dataset1").Table("items").Metadata(ctx); err == nil {
+			t.Fatal("expected the old name to be not found")
+		}
+		it, err := client.Query("SELECT COUNT(*) FROM dataset1.renamed").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if row[0].(int64) != 2 {
+			t.Fatalf("failed to move the rows: %v", row[0])
+		}
+		if err := exec("SELECT * FROM dataset1.items_view"); err == nil {
+			t.Fatal("expected the view of the old name to fail")
+		}
+	})
+	t.Run("if exists", func(t *testing.T) {
+		if err := exec("ALTER TABLE IF EXISTS dataset1.missing SET OPTIONS(description = 'missing')"); err != nil {
+			t.Fatal(err)
+		}
+		err := exec("ALTER TABLE dataset1.missing SET OPTIONS(description = 'missing')")
+		if err == nil || !strings.Contains(err.Error(), "Not found: Table") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("view", func(t *testing.T) {
+		if err := exec("ALTER TABLE dataset1.items_view SET OPTIONS(description = 'view')"); err == nil {
+			t.Fatal("expected the error for the view")
+		}
+	})
+}
+
+func TestLocation(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, ds := range []struct {
+		id       string
+		location string
+	}{
+		{id: "eu_dataset1", location: "EU"},
+		{id: "eu_dataset2", location: "eu"},
+		{id: "tokyo_dataset", location: "asia-northeast1"},
+	} {
+		if err := client.Dataset(ds.id).Create(ctx, &bigquery.DatasetMetadata{Location: ds.location}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Query(fmt.Sprintf("CREATE TABLE %s.t AS SELECT 1 AS id", ds.id)).Read(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Dataset("invalid_location").Create(ctx, &bigquery.DatasetMetadata{Location: "moon"}); err == nil {
+		t.Fatal("expected error for the invalid location")
+	}
+
+	md, err := client.Dataset("dataset1").Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Location != server.DefaultLocation {
+		t.Fatalf("expected the default location but got %s", md.Location)
+	}
+	md, err = client.Dataset("eu_dataset2").Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Location != "EU" {
+		t.Fatalf("expected EU but got %s", md.Location)
+	}
+
+	t.Run("same location", func(t *testing.T) {
+		job, err := client.Query("SELECT a.id FROM eu_dataset1.t AS a JOIN eu_dataset2.t AS b ON a.id = b.id").Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Location() != "EU" {
+			t.Fatalf("expected the job in EU but got %s", job.Location())
+		}
+		it, err := job.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]bigquery.Value{int64(1)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("cross location", func(t *testing.T) {
+		_, err := client.Query("SELECT a.id FROM eu_dataset1.t AS a JOIN tokyo_dataset.t AS b ON a.id = b.id").Read(ctx)
+		if err == nil {
+			t.Fatal("expected error for the cross location query")
+		}
+		if !strings.Contains(err.Error(), "Not found: Dataset test:tokyo_dataset was not found in location EU") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("job location", func(t *testing.T) {
+		query := client.Query("SELECT id FROM eu_dataset1.t")
+		query.Location = "US"
+		if _, err := query.Read(ctx); err == nil {
+			t.Fatal("expected error for the dataset outside of the job location")
+		}
+	})
+	t.Run("default dataset", func(t *testing.T) {
+		query := client.Query("SELECT a.id FROM t AS a JOIN tokyo_dataset.t AS b ON a.id = b.id")
+		query.DefaultDatasetID = "eu_dataset1"
+		_, err := query.Read(ctx)
+		if err == nil {
+			t.Fatal("expected error for the cross location query")
+		}
+		if !strings.Contains(err.Error(), "Not found: Dataset test:tokyo_dataset was not found in location EU") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("copy across locations", func(t *testing.T) {
+		_, err := client.Query("CREATE TABLE eu_dataset1.copied COPY tokyo_dataset.t").Read(ctx)
+		if err == nil {
+			t.Fatal("expected error for the cross location copy")
+		}
+		if !strings.Contains(err.Error(), "Not found: Dataset test:tokyo_dataset was not found in location EU") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		copier := client.Dataset("eu_dataset2").Table("copied").CopierFrom(client.Dataset("tokyo_dataset").Table("t"))
+		if _, err := copier.Run(ctx); err == nil {
+			t.Fatal("expected error for the cross location copy job")
+		} else if !strings.Contains(err.Error(), "Not found: Dataset test:eu_dataset2 was not found in location asia-northeast1") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestInformationSchemaPartitions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	dataset := client.Dataset("dataset1")
+	if err := dataset.Table("day_partitioned").Create(ctx, &bigquery.TableMetadata{
+		Schema: bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType},
+			{Name: "dt", Type: bigquery.DateFieldType},
+		},
+		TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "dt"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataset.Table("range_partitioned").Create(ctx, &bigquery.TableMetadata{
+		Schema: bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType},
+		},
+		RangePartitioning: &bigquery.RangePartitioning{
+			Field: "id",
+			Range: &bigquery.RangePartitioningRange{Start: 0, End: 100, Interval: 10},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		"INSERT INTO dataset1.day_partitioned (id, dt) VALUES (1, '2024-01-01'), (2, '2024-01-01'), (3, '2024-01-02'), (4, NULL)",
+		"INSERT INTO dataset1.range_partitioned (id) VALUES (1), (5), (15), (150), (NULL)",
+	} {
+		if _, err := client.Query(query).Read(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it, err := client.Query(`
+SELECT table_name, partition_id, total_rows, total_logical_bytes > 0, storage_tier
+FROM dataset1.INFORMATION_SCHEMA.PARTITIONS
+WHERE table_name IN ('day_partitioned', 'range_partitioned')
+ORDER BY table_name, partition_id`).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]bigquery.Value
+	for {
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			if err == iterator.Done {
+				break
+			}
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	expected := [][]bigquery.Value{
+		{"day_partitioned", "20240101", int64(2), true, "ACTIVE"},
+		{"day_partitioned", "20240102", int64(1), true, "ACTIVE"},
+		{"day_partitioned", "__NULL__", int64(1), true, "ACTIVE"},
+		{"range_partitioned", "0", int64(2), true, "ACTIVE"},
+		{"range_partitioned", "10", int64(1), true, "ACTIVE"},
+		{"range_partitioned", "__NULL__", int64(1), false, "ACTIVE"},
+		{"range_partitioned", "__UNPARTITIONED__", int64(1), true, "ACTIVE"},
+	}
+	if diff := cmp.Diff(expected, rows); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+
+	it, err = client.Query(`
+SELECT COUNT(*), SUM(total_rows)
+FROM dataset1.INFORMATION_SCHEMA.PARTITIONS
+WHERE table_name = 'table_a'`).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row []bigquery.Value
+	if err := it.Next(&row); err != nil {
+		t.Fatal(err)
+	}
+	if row[0] != int64(1) {
+		t.Fatalf("expected a single partition for the table that is not partitioned but got %v", row[0])
+	}
+}
+
+func TestGracefulStop(t *testing.T) {
+	ctx := context.Background()
+
+	const (
+		projectName = "test"
+	)
+
+	path := filepath.Join(t.TempDir(), "emulator.db")
+	storage, err := server.FileStorage(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bqServer, err := server.New(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bqServer.SetProject(projectName); err != nil {
+		t.Fatal(err)
+	}
+	bqServer.SetShutdownGracePeriod(100 * time.Millisecond)
+	testServer := bqServer.TestServer()
+	defer testServer.Close()
+
+	client, err := bigquery.NewClient(
+		ctx,
+		projectName,
+		option.WithEndpoint(testServer.URL),
+		option.WithoutAuthentication(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	type result struct {
+		count bigquery.Value
+		err   error
+	}
+	// the client retries the failed requests, so bound the time of the query.
+	queryCtx, cancelQuery := context.WithTimeout(ctx, 30*time.Second)
+	defer cancelQuery()
+	done := make(chan result, 1)
+	go func() {
+		it, err := client.Query(
+			"SELECT COUNT(*) FROM UNNEST(GENERATE_ARRAY(1, 3000)) AS a, UNNEST(GENERATE_ARRAY(1, 3000)) AS b",
+		).Read(queryCtx)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			done <- result{err: err}
+			return
+		}
+		done <- result{count: row[0]}
+	}()
+	// wait for the query to start.
+	time.Sleep(500 * time.Millisecond)
+
+	stopCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if err := bqServer.Stop(stopCtx); err != nil {
+		t.Fatalf("failed to stop gracefully: %v", err)
+	}
+	select {
+	case res := <-done:
+		// the query either completes within the grace period or is cancelled.
+		if res.err == nil && res.count != int64(3000*3000) {
+			t.Fatalf("unexpected result: %v", res.count)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("the query is still running after the server stopped")
+	}
+	afterStopCtx, cancelAfterStop := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelAfterStop()
+	if _, err := client.Query("SELECT 1").Read(afterStopCtx); err == nil {
+		t.Fatal("expected error for the request after the server stopped")
+	}
+
+	// the database file must not be left locked.
+	restarted, err := server.New(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Stop(ctx)
+	if err := restarted.SetProject(projectName); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInsertSelectAndValues(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	exec := func(query string) (*bigquery.QueryStatistics, error) {
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := status.Err(); err != nil {
+			return nil, err
+		}
+		return status.Statistics.Details.(*bigquery.QueryStatistics), nil
+	}
+
+	if _, err := exec(`CREATE TABLE dataset1.insert_target (
+  id INT64,
+  amount NUMERIC,
+  ratio FLOAT64,
+  tags ARRAY<STRING>,
+  info STRUCT<name STRING, score INT64>
+)`); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("multi-row values", func(t *testing.T) {
+		stats, err := exec(`INSERT INTO dataset1.insert_target (id, amount, ratio, tags, info) VALUES
+  (1, 10, 1, ['a', 'b'], STRUCT('alice', 1)),
+  (2, 2.5, 2, [], STRUCT('bob', 2))`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.StatementType != "INSERT" {
+			t.Errorf("unexpected statement type: %s", stats.StatementType)
+		}
+		if stats.NumDMLAffectedRows != 2 {
+			t.Errorf("unexpected affected rows: %d", stats.NumDMLAffectedRows)
+		}
+		if stats.DMLStats == nil || stats.DMLStats.InsertedRowCount != 2 {
+			t.Errorf("unexpected dml stats: %+v", stats.DMLStats)
+		}
+	})
+	t.Run("select into subset of columns", func(t *testing.T) {
+		stats, err := exec(`INSERT INTO dataset1.insert_target (id, amount)
+SELECT id + 10, id FROM UNNEST([1, 2, 3]) AS id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.DMLStats == nil || stats.DMLStats.InsertedRowCount != 3 {
+			t.Errorf("unexpected dml stats: %+v", stats.DMLStats)
+		}
+	})
+	t.Run("column count mismatch", func(t *testing.T) {
+		if _, err := exec("INSERT INTO dataset1.insert_target (id, amount) VALUES (1, 2, 3)"); err == nil {
+			t.Fatal("expected column count mismatch error")
+		}
+		if _, err := exec("INSERT INTO dataset1.insert_target (id, amount) SELECT 1"); err == nil {
+			t.Fatal("expected column count mismatch error")
+		}
+	})
+
+	it, err := client.Query(`SELECT id, CAST(amount AS STRING), ratio, tags, info.name
+FROM dataset1.insert_target ORDER BY id`).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]bigquery.Value
+	for {
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			if err == iterator.Done {
+				break
+			}
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	expected := [][]bigquery.Value{
+		{int64(1), "10", float64(1), []bigquery.Value{"a", "b"}, "alice"},
+		{int64(2), "2.5", float64(2), []bigquery.Value{}, "bob"},
+		{int64(11), "1", nil, []bigquery.Value{}, nil},
+		{int64(12), "2", nil, []bigquery.Value{}, nil},
+		{int64(13), "3", nil, []bigquery.Value{}, nil},
+	}
+	if diff := cmp.Diff(expected, rows); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestQueryPlan(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	exec := func(query string) (*bigquery.QueryStatistics, error) {
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := status.Err(); err != nil {
+			return nil, err
+		}
+		return status.Statistics.Details.(*bigquery.QueryStatistics), nil
+	}
+
+	type stage struct {
+		Name           string
+		Steps          []string
+		RecordsRead    int64
+		RecordsWritten int64
+	}
+	plan := func(stats *bigquery.QueryStatistics) []stage {
+		var stages []stage
+		for _, s := range stats.QueryPlan {
+			var steps []string
+			for _, step := range s.Steps {
+				steps = append(steps, step.Kind)
+			}
+			stages = append(stages, stage{
+				Name:           s.Name,
+				Steps:          steps,
+				RecordsRead:    s.RecordsRead,
+				RecordsWritten: s.RecordsWritten,
+			})
+		}
+		return stages
+	}
+
+	t.Run("group by", func(t *testing.T) {
+		stats, err := exec("SELECT name, COUNT(*) AS n FROM dataset1.table_a WHERE id > 0 GROUP BY name ORDER BY name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []stage{
+			{Name: "S00: Input", Steps: []string{"READ", "FILTER", "WRITE"}, RecordsRead: 2},
+			{Name: "S01: Aggregate", Steps: []string{"READ", "AGGREGATE", "WRITE"}},
+			{Name: "S02: Output", Steps: []string{"READ", "SORT", "WRITE"}, RecordsWritten: 2},
+		}
+		if diff := cmp.Diff(expected, plan(stats)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(
+			[]string{"GROUP BY name", "COUNT(*)"},
+			stats.QueryPlan[1].Steps[1].Substeps,
+		); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if len(stats.Timeline) != 1 || stats.Timeline[0].CompletedUnits != 3 {
+			t.Errorf("unexpected timeline: %+v", stats.Timeline)
+		}
+	})
+	t.Run("join", func(t *testing.T) {
+		stats, err := exec("SELECT a.name FROM dataset1.table_a AS a JOIN dataset1.table_a AS b ON a.id = b.id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []stage{
+			{Name: "S00: Input", Steps: []string{"READ", "WRITE"}, RecordsRead: 2},
+			{Name: "S01: Join+", Steps: []string{"READ", "JOIN", "WRITE"}},
+			{Name: "S02: Output", Steps: []string{"READ", "WRITE"}, RecordsWritten: 2},
+		}
+		if diff := cmp.Diff(expected, plan(stats)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("dml", func(t *testing.T) {
+		stats, err := exec("UPDATE dataset1.table_a SET name = 'carol' WHERE id = 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []stage{
+			{Name: "S00: Input", Steps: []string{"READ", "FILTER", "WRITE"}, RecordsRead: 2},
+			{Name: "S01: Output", Steps: []string{"READ", "WRITE"}, RecordsWritten: 1},
+		}
+		if diff := cmp.Diff(expected, plan(stats)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"TO dataset1.table_a"}, stats.QueryPlan[1].Steps[1].Substeps); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("script", func(t *testing.T) {
+		job, err := client.Query("DECLARE x INT64 DEFAULT 1; SELECT name FROM dataset1.table_a WHERE id >= x").Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := status.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if stats := status.Statistics.Details.(*bigquery.QueryStatistics); len(stats.QueryPlan) != 0 {
+			t.Errorf("unexpected query plan of the script: %+v", stats.QueryPlan)
+		}
+		children := job.Children(ctx)
+		var plans [][]stage
+		for {
+			child, err := children.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats, ok := child.LastStatus().Statistics.Details.(*bigquery.QueryStatistics); ok && len(stats.QueryPlan) != 0 {
+				plans = append(plans, plan(stats))
+			}
+		}
+		expected := [][]stage{{
+			{Name: "S00: Input", Steps: []string{"READ", "FILTER", "WRITE"}, RecordsRead: 2},
+			{Name: "S01: Output", Steps: []string{"READ", "WRITE"}, RecordsWritten: 2},
+		}}
+		if diff := cmp.Diff(expected, plans); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestDMLThenReturn(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(
+		t,
+		server.StructSource(
+			types.NewProject(
+				"test",
+				types.NewDataset("dataset1"),
+			),
+		),
+	)
+	client := newTestClient(t, startTestServer(t, bqServer), "test")
+
+	run := func(query string) ([][]bigquery.Value, *bigquery.QueryStatistics, error) {
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := status.Err(); err != nil {
+			return nil, nil, err
+		}
+		it, err := job.Read(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		rows := [][]bigquery.Value{}
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				return nil, nil, err
+			}
+			rows = append(rows, row)
+		}
+		return rows, status.Statistics.Details.(*bigquery.QueryStatistics), nil
+	}
+
+	for _, query := range []string{
+		"CREATE TABLE dataset1.target (id INT64, name STRING, score FLOAT64)",
+		"CREATE TABLE dataset1.source (id INT64, name STRING)",
+		"INSERT INTO dataset1.source (id, name) VALUES (2, 'bob2'), (3, 'delete'), (4, 'dave')",
+		"CREATE TABLE dataset1.bqemulator_returned (note STRING)",
+		"INSERT INTO dataset1.bqemulator_returned (note) VALUES ('user table')",
+	} {
+		if _, _, err := run(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("insert", func(t *testing.T) {
+		rows, stats, err := run("INSERT INTO dataset1.target (id, name) VALUES (1, 'alice'), (2, 'bob') THEN RETURN *")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([][]bigquery.Value{{int64(1), "alice", nil}, {int64(2), "bob", nil}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if stats.DMLStats == nil || stats.DMLStats.InsertedRowCount != 2 {
+			t.Errorf("unexpected dml stats: %+v", stats.DMLStats)
+		}
+	})
+	t.Run("insert select with action", func(t *testing.T) {
+		rows, _, err := run(`INSERT dataset1.target (id, score) SELECT 3, 1
+THEN RETURN WITH ACTION AS op id, score * 2 AS doubled`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([][]bigquery.Value{{int64(3), float64(2), "INSERT"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("update", func(t *testing.T) {
+		rows, stats, err := run("UPDATE dataset1.target AS t SET t.name = UPPER(name) WHERE id <= 2 THEN RETURN WITH ACTION id, name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([][]bigquery.Value{{int64(1), "ALICE", "UPDATE"}, {int64(2), "BOB", "UPDATE"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if stats.DMLStats == nil || stats.DMLStats.UpdatedRowCount != 2 {
+			t.Errorf("unexpected dml stats: %+v", stats.DMLStats)
+		}
+	})
+	t.Run("delete", func(t *testing.T) {
+		rows, stats, err := run("DELETE FROM dataset1.target WHERE id = 1 THEN RETURN name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([][]bigquery.Value{{"ALICE"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if stats.DMLStats == nil || stats.DMLStats.DeletedRowCount != 1 {
+			t.Errorf("unexpected dml stats: %+v", stats.DMLStats)
+		}
+	})
+	t.Run("merge statistics", func(t *testing.T) {
+		// target has 2 and 3, source has 2, 3 and 4.
+		_, stats, err := run(`MERGE dataset1.target T USING dataset1.source S ON T.id = S.id
+WHEN MATCHED AND S.name = 'delete' THEN DELETE
+WHEN MATCHED THEN UPDATE SET name = S.name
+WHEN NOT MATCHED THEN INSERT (id, name) VALUES (S.id, S.name)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.DMLStats == nil {
+			t.Fatal("expected dml stats")
+		}
+		if diff := cmp.Diff(
+			bigquery.DMLStatistics{InsertedRowCount: 1, UpdatedRowCount: 1, DeletedRowCount: 1},
+			*stats.DMLStats,
+		); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		rows, _, err := run("SELECT id, name FROM dataset1.target ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([][]bigquery.Value{{int64(2), "bob2"}, {int64(4), "dave"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("update with volatile value", func(t *testing.T) {
+		rows, _, err := run("UPDATE dataset1.target SET name = GENERATE_UUID() WHERE id = 2 THEN RETURN name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		written, _, err := run("SELECT name FROM dataset1.target WHERE id = 2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(written, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("merge then return", func(t *testing.T) {
+		// target has 2 and 4, source has 2, 3 and 4.
+		rows, stats, err := run(`MERGE dataset1.target T USING dataset1.source S ON T.id = S.id
+WHEN MATCHED AND S.id = 2 THEN DELETE
+WHEN MATCHED THEN UPDATE SET name = 'DAVE'
+WHEN NOT MATCHED THEN INSERT (id, name) VALUES (S.id, S.name)
+THEN RETURN WITH ACTION T.id, LENGTH(name) AS length`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i][0].(int64) < rows[j][0].(int64) })
+		if diff := cmp.Diff(
+			// the name of 2 is the UUID written by the previous UPDATE.
+			[][]bigquery.Value{{int64(2), int64(36), "DELETE"}, {int64(3), int64(6), "INSERT"}, {int64(4), int64(4), "UPDATE"}},
+			rows,
+		); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if stats.DMLStats == nil || stats.DMLStats.DeletedRowCount != 1 || stats.DMLStats.UpdatedRowCount != 1 || stats.DMLStats.InsertedRowCount != 1 {
+			t.Errorf("unexpected dml stats: %+v", stats.DMLStats)
+		}
+	})
+	t.Run("keeps the table of the dataset", func(t *testing.T) {
+		rows, _, err := run("SELECT note FROM dataset1.bqemulator_returned")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([][]bigquery.Value{{"user table"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestJSONFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:     "json_object",
+			query:    "SELECT TO_JSON_STRING(JSON_OBJECT('b', 1, 'a', 'x', 'b', 2)), TO_JSON_STRING(JSON_OBJECT())",
+			expected: []bigquery.Value{`{"a":"x","b":1}`, `{}`},
+		},
+		{
+			name:     "json_object with key expressions",
+			query:    "SELECT TO_JSON_STRING(JSON_OBJECT(k, v, 'a', 0)) FROM UNNEST([STRUCT('b' AS k, 1 AS v)])",
+			expected: []bigquery.Value{`{"a":0,"b":1}`},
+		},
+		{
+			name:     "json_object with arrays",
+			query:    "SELECT TO_JSON_STRING(JSON_OBJECT(['b', 'a', 'b'], [1, 2, 3]))",
+			expected: []bigquery.Value{`{"a":2,"b":1}`},
+		},
+		{
+			name:     "json_array",
+			query:    "SELECT TO_JSON_STRING(JSON_ARRAY(1, 'a', NULL, [1, 2], JSON_OBJECT('k', true))), TO_JSON_STRING(JSON_ARRAY())",
+			expected: []bigquery.Value{`[1,"a",null,[1,2],{"k":true}]`, `[]`},
+		},
+		{
+			name:     "to_json",
+			query:    "SELECT TO_JSON_STRING(TO_JSON(STRUCT(1 AS id, ['x'] AS tags)))",
+			expected: []bigquery.Value{`{"id":1,"tags":["x"]}`},
+		},
+		{
+			name:     "parse_json keeps int64",
+			query:    `SELECT TO_JSON_STRING(PARSE_JSON('{"n":9007199254740993}'))`,
+			expected: []bigquery.Value{`{"n":9007199254740993}`},
+		},
+		{
+			name:     "parse_json rounds wide number",
+			query:    `SELECT TO_JSON_STRING(PARSE_JSON('{"n":123456789012345678901234567890}', wide_number_mode=>'round'))`,
+			expected: []bigquery.Value{`{"n":1.2345678901234568e+29}`},
+		},
+		{
+			name:     "json_set",
+			query:    `SELECT TO_JSON_STRING(JSON_SET(JSON '{"a":{"b":1},"n":9007199254740993}', '$.a.b', 2, '$.c[2]', 'x'))`,
+			expected: []bigquery.Value{`{"a":{"b":2},"c":[null,null,"x"],"n":9007199254740993}`},
+		},
+		{
+			name:     "json_set without create_if_missing",
+			query:    `SELECT TO_JSON_STRING(JSON_SET(JSON '{"a":null}', '$.a', JSON '[1]', '$.b', 1, create_if_missing => FALSE))`,
+			expected: []bigquery.Value{`{"a":[1]}`},
+		},
+		{
+			name:     "json_remove",
+			query:    `SELECT TO_JSON_STRING(JSON_REMOVE(JSON '{"a":[1,2,3],"b":{"c":1}}', '$.a[1]', '$.b.c', '$.x'))`,
+			expected: []bigquery.Value{`{"a":[1,3],"b":{}}`},
+		},
+		{
+			name:     "json_strip_nulls",
+			query:    `SELECT TO_JSON_STRING(JSON_STRIP_NULLS(JSON '{"a":null,"b":[null,1],"c":{"d":null}}')), TO_JSON_STRING(JSON_STRIP_NULLS(JSON '{"a":null,"b":[null,1],"c":{"d":null}}', include_arrays => FALSE, remove_empty => TRUE))`,
+			expected: []bigquery.Value{`{"b":[1],"c":{}}`, `{"b":[null,1]}`},
+		},
+		{
+			name:     "json_strip_nulls with path",
+			query:    `SELECT TO_JSON_STRING(JSON_STRIP_NULLS(JSON '{"a":null,"b":{"c":null,"d":1}}', '$.b'))`,
+			expected: []bigquery.Value{`{"a":null,"b":{"d":1}}`},
+		},
+		{
+			name:     "to_json stringify_wide_numbers",
+			query:    "SELECT TO_JSON_STRING(TO_JSON(STRUCT(9007199254740993 AS a, 1 AS b), stringify_wide_numbers => TRUE))",
+			expected: []bigquery.Value{`{"a":"9007199254740993","b":1}`},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, query := range []string{
+		"SELECT JSON_OBJECT('a')",
+		"SELECT JSON_OBJECT(NULL, 1)",
+		"SELECT JSON_OBJECT(['a', 'b'], [1])",
+		`SELECT PARSE_JSON('{"n":123456789012345678901234567890}')`,
+		`SELECT JSON_SET(JSON '{"a":1}', '$.b')`,
+		`SELECT JSON_REMOVE(JSON '{"a":1}', '$')`,
+	} {
+		if _, err := client.Query(query).Read(ctx); err == nil {
+			t.Errorf("expected error for %s", query)
+		}
+	}
+}
+
+func TestDifferentialPrivacy(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	client := newTestClient(t, startTestServer(t, bqServer), "test")
+
+	const (
+		differentialPrivacyQuery = `
+SELECT WITH DIFFERENTIAL_PRIVACY OPTIONS(epsilon = 10, delta = .01, privacy_unit_column = id)
+  item,
+  AVG(quantity, contribution_bounds_per_group => (0, 100)) AS average_quantity,
+  COUNT(*, contribution_bounds_per_group => (0, 1)) AS count
+FROM UNNEST([
+  STRUCT(1 AS id, 'apple' AS item, 10 AS quantity),
+  STRUCT(2 AS id, 'apple' AS item, 20 AS quantity),
+  STRUCT(3 AS id, 'pear' AS item, 5 AS quantity)
+])
+GROUP BY item
+ORDER BY item`
+		anonymizationQuery = `
+SELECT WITH ANONYMIZATION OPTIONS(epsilon = 10, delta = .01, max_groups_contributed = 2)
+  ANON_COUNT(*),
+  ANON_SUM(x CLAMPED BETWEEN 0 AND 100)
+FROM UNNEST([1, 2, 3]) AS x`
+	)
+
+	t.Run("unsupported by default", func(t *testing.T) {
+		for _, query := range []string{differentialPrivacyQuery, anonymizationQuery} {
+			_, err := client.Query(query).Read(ctx)
+			if err == nil {
+				t.Fatal("expected unsupported feature error")
+			}
+			gerr, ok := err.(*googleapi.Error)
+			if !ok {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			if gerr.Code != http.StatusNotImplemented {
+				t.Errorf("unexpected status code %d: %v", gerr.Code, gerr)
+			}
+			if !strings.Contains(gerr.Message, "Unsupported feature") {
+				t.Errorf("unexpected message: %s", gerr.Message)
+			}
+		}
+	})
+
+	bqServer.SetDifferentialPrivacyPassthrough(true)
+
+	t.Run("passthrough", func(t *testing.T) {
+		for _, test := range []struct {
+			query    string
+			expected [][]bigquery.Value
+		}{
+			{
+				query: differentialPrivacyQuery,
+				expected: [][]bigquery.Value{
+					{"apple", float64(15), int64(2)},
+					{"pear", float64(5), int64(1)},
+				},
+			},
+			{
+				query:    anonymizationQuery,
+				expected: [][]bigquery.Value{{int64(3), int64(6)}},
+			},
+		} {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		}
+	})
+}
+
+func TestArraySubscript(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:     "offset and ordinal",
+			query:    "SELECT arr[OFFSET(0)], arr[ORDINAL(1)], arr[OFFSET(2)], arr[ORDINAL(3)] FROM UNNEST([STRUCT(['a', 'b', 'c'] AS arr)])",
+			expected: []bigquery.Value{"a", "a", "c", "c"},
+		},
+		{
+			name:     "safe accessors out of bounds",
+			query:    "SELECT arr[SAFE_OFFSET(3)], arr[SAFE_OFFSET(-1)], arr[SAFE_ORDINAL(0)], arr[SAFE_ORDINAL(4)], arr[SAFE_ORDINAL(3)] FROM UNNEST([STRUCT(['a', 'b', 'c'] AS arr)])",
+			expected: []bigquery.Value{nil, nil, nil, nil, "c"},
+		},
+		{
+			name:     "subscript without accessor",
+			query:    "SELECT [10, 20, 30][1], arr[2 - 1] FROM UNNEST([STRUCT([1, 2] AS arr)])",
+			expected: []bigquery.Value{int64(20), int64(2)},
+		},
+		{
+			name:     "json subscript",
+			query:    "SELECT j[1], j[0]['a'], TO_JSON_STRING(JSON_QUERY(j, '$')[2 - 1]) FROM UNNEST([JSON '[{\"a\": 1}, 2]']) AS j",
+			expected: []bigquery.Value{"2", "1", "2"},
+		},
+		{
+			name:     "nested array",
+			query:    "SELECT s.items[OFFSET(1)].vals[ORDINAL(2)] FROM UNNEST([STRUCT([STRUCT([1, 2] AS vals), STRUCT([3, 4] AS vals)] AS items)]) AS s",
+			expected: []bigquery.Value{int64(4)},
+		},
+		{
+			name:     "null and empty array",
+			query:    "SELECT CAST(NULL AS ARRAY<INT64>)[OFFSET(0)], CAST(NULL AS ARRAY<INT64>)[ORDINAL(5)], ARRAY<INT64>[][SAFE_OFFSET(0)]",
+			expected: []bigquery.Value{nil, nil, nil},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{query: "SELECT ['a', 'b'][OFFSET(2)]", expected: "Array index 2 is out of bounds (overflow)"},
+		{query: "SELECT ['a', 'b'][OFFSET(-1)]", expected: "Array index -1 is out of bounds (underflow)"},
+		{query: "SELECT ['a', 'b'][ORDINAL(0)]", expected: "Array index 0 is out of bounds (underflow)"},
+		{query: "SELECT ['a', 'b'][ORDINAL(3)]", expected: "Array index 3 is out of bounds (overflow)"},
+		{query: "SELECT ARRAY<INT64>[][OFFSET(0)]", expected: "Array index 0 is out of bounds (overflow)"},
+	} {
+		_, err := client.Query(test.query).Read(ctx)
+		if err == nil {
+			t.Errorf("expected error for %s", test.query)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("unexpected error for %s: %v", test.query, err)
+		}
+	}
+}
+
+func TestSafePrefix(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:     "function subscript and cast",
+			query:    "SELECT SAFE.PARSE_NUMERIC('abc'), 1 + [1, 2][SAFE_OFFSET(3)], SAFE_CAST('x' AS INT64), COALESCE([1, 2][SAFE_ORDINAL(0)], SAFE_CAST('7' AS INT64))",
+			expected: []bigquery.Value{nil, nil, nil, int64(7)},
+		},
+		{
+			name:     "rewritten functions",
+			query:    "SELECT SAFE.JSON_OBJECT(['a', 'b'], [1]), SAFE.REGEXP_INSTR('abcb', 'b', 3), SAFE.DATE_DIFF(DATE '2024-01-02', SAFE.PARSE_DATE('%Y', 'x'), DAY)",
+			expected: []bigquery.Value{nil, int64(4), nil},
+		},
+		{
+			name:     "nested safe calls",
+			query:    "SELECT SAFE.SUBSTR(SAFE.REGEXP_EXTRACT('abc', 'b', 2), 1), SAFE.LENGTH(SAFE.JSON_OBJECT(['a'], [1, 2]))",
+			expected: []bigquery.Value{"b", nil},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{query: "SELECT SAFE.SUM(x) FROM UNNEST([1, 2]) AS x", expected: "Function SUM does not support SAFE error mode"},
+		{query: "SELECT SAFE.ROW_NUMBER() OVER () FROM UNNEST([1, 2])", expected: "Function ROW_NUMBER does not support SAFE error mode"},
+	} {
+		_, err := client.Query(test.query).Read(ctx)
+		if err == nil {
+			t.Errorf("expected error for %s", test.query)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("unexpected error for %s: %v", test.query, err)
+		}
+	}
+}
+
+func TestTimeZoneConversions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name: "datetime to timestamp around daylight saving time",
+			query: `SELECT
+  CAST(TIMESTAMP('2024-03-10 01:30:00', 'America/Los_Angeles') AS STRING),
+  CAST(TIMESTAMP('2024-03-10 02:30:00', 'America/Los_Angeles') AS STRING),
+  CAST(TIMESTAMP(DATETIME '2024-11-03 01:30:00', 'America/Los_Angeles') AS STRING),
+  CAST(TIMESTAMP('2024-03-10 02:30:00') AS STRING)`,
+			expected: []bigquery.Value{"2024-03-10 09:30:00+00", "2024-03-10 10:30:00+00", "2024-11-03 08:30:00+00", "2024-03-10 02:30:00+00"},
+		},
+		{
+			name: "timestamp to datetime around daylight saving time",
+			query: `SELECT
+  CAST(DATETIME(TIMESTAMP '2024-03-10 09:30:00+00', 'America/Los_Angeles') AS STRING),
+  CAST(DATETIME(TIMESTAMP '2024-03-10 10:30:00+00', 'America/Los_Angeles') AS STRING),
+  EXTRACT(HOUR FROM TIMESTAMP '2024-03-10 10:30:00+00' AT TIME ZONE 'America/Los_Angeles')`,
+			expected: []bigquery.Value{"2024-03-10 01:30:00", "2024-03-10 03:30:00", int64(3)},
+		},
+		{
+			name: "timestamp to string",
+			query: `SELECT
+  STRING(TIMESTAMP '2024-03-10 09:30:00+00', 'America/Los_Angeles'),
+  STRING(TIMESTAMP '2024-03-10 10:30:00+00', 'America/Los_Angeles'),
+  STRING(TIMESTAMP '2008-12-25 15:30:00+00', 'Asia/Kolkata'),
+  STRING(TIMESTAMP '2008-12-25 15:30:00+00')`,
+			expected: []bigquery.Value{"2024-03-10 01:30:00-08", "2024-03-10 03:30:00-07", "2008-12-25 21:00:00+05:30", "2008-12-25 15:30:00+00"},
+		},
+		{
+			name: "fixed offsets",
+			query: `SELECT
+  CAST(DATETIME(TIMESTAMP '2024-01-01 00:00:00+00', 'UTC+3') AS STRING),
+  CAST(DATETIME(TIMESTAMP '2024-01-01 00:00:00+00', 'Etc/GMT+10') AS STRING),
+  CAST(TIMESTAMP('2024-01-01 00:00:00', '+05:30') AS STRING)`,
+			expected: []bigquery.Value{"2024-01-01 03:00:00", "2023-12-31 14:00:00", "2023-12-31 18:30:00+00"},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{query: "SELECT TIMESTAMP('2024-01-01 00:00:00', 'PST')", expected: "Invalid time zone: PST"},
+		{query: "SELECT STRING(CURRENT_TIMESTAMP(), '+15')", expected: "Invalid time zone: +15"},
+	} {
+		_, err := client.Query(test.query).Read(ctx)
+		if err == nil {
+			t.Errorf("expected error for %s", test.query)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("unexpected error for %s: %v", test.query, err)
+		}
+	}
+}
+
+func TestContainsSubstr(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name: "string column",
+			query: `
+SELECT message FROM UNNEST(['Disk FULL on host-1', 'all good', NULL]) AS message
+WHERE CONTAINS_SUBSTR(message, 'full')`,
+			expected: [][]bigquery.Value{{"Disk FULL on host-1"}},
+		},
+		{
+			name: "row search",
+			query: `
+WITH logs AS (
+  SELECT 1 AS id, 'info' AS severity, STRUCT('api' AS service, ['startup'] AS tags) AS detail UNION ALL
+  SELECT 2, 'error', STRUCT('db', ['Timeout', 'retry']) UNION ALL
+  SELECT 3, 'warning', STRUCT('api', ['slow'])
+)
+SELECT id FROM logs AS t WHERE CONTAINS_SUBSTR(t, 'TIMEOUT') OR CONTAINS_SUBSTR(t, 'warn') ORDER BY id`,
+			expected: [][]bigquery.Value{{int64(2)}, {int64(3)}},
+		},
+		{
+			name: "struct",
+			query: `
+SELECT
+  CONTAINS_SUBSTR((23, 35, 41), '35'),
+  CONTAINS_SUBSTR(('abc', ['def', 'ghi', 'jkl'], 'mno'), 'jk'),
+  CONTAINS_SUBSTR((23, NULL, 41), '41'),
+  CONTAINS_SUBSTR((23, NULL, 41), '35'),
+  CONTAINS_SUBSTR(STRUCT('lunch' AS meal), 'meal')`,
+			expected: [][]bigquery.Value{{true, true, true, nil, false}},
+		},
+		{
+			name: "canonical string form",
+			query: `
+SELECT
+  CONTAINS_SUBSTR(TIMESTAMP '2008-12-25 15:30:00+00', '2008-12-25 15:30:00+00'),
+  CONTAINS_SUBSTR(DATE '2008-12-25', '12-25'),
+  CONTAINS_SUBSTR(1.5, '.5'),
+  CONTAINS_SUBSTR(123, '23'),
+  CONTAINS_SUBSTR('Ⅸ century', 'ix')`,
+			expected: [][]bigquery.Value{{true, true, true, true, true}},
+		},
+		{
+			name: "json scope",
+			query: `
+SELECT
+  CONTAINS_SUBSTR(JSON '{"lunch":"soup"}', 'lunch'),
+  CONTAINS_SUBSTR(JSON '{"lunch":"soup"}', 'lunch', json_scope => 'JSON_KEYS'),
+  CONTAINS_SUBSTR(JSON '{"lunch":"soup"}', 'soup', json_scope => 'JSON_KEYS_AND_VALUES')`,
+			expected: [][]bigquery.Value{{false, true, true}},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	exec := func(query string) error {
+		_, err := client.Query(query).Read(ctx)
+		return err
+	}
+
+	t.Run("search index", func(t *testing.T) {
+		if err := exec("CREATE SEARCH INDEX name_index ON dataset1.table_a(name) OPTIONS(analyzer = 'LOG_ANALYZER')"); err != nil {
+			t.Fatal(err)
+		}
+		if err := exec("CREATE SEARCH INDEX name_index ON dataset1.table_a(ALL COLUMNS)"); err == nil {
+			t.Fatal("expected error for the table that already has the search index")
+		}
+		if err := exec("CREATE SEARCH INDEX IF NOT EXISTS name_index ON dataset1.table_a(ALL COLUMNS)"); err != nil {
+			t.Fatal(err)
+		}
+		if err := exec("CREATE SEARCH INDEX unknown_index ON dataset1.table_b(unknown)"); err == nil {
+			t.Fatal("expected error for the unknown column")
+		}
+		if err := exec("DROP SEARCH INDEX name_index ON dataset1.table_a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := exec("DROP SEARCH INDEX name_index ON dataset1.table_a"); err == nil {
+			t.Fatal("expected error for the dropped search index")
+		}
+		if err := exec("DROP SEARCH INDEX IF EXISTS name_index ON dataset1.table_a"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name: "terms",
+			query: `
+SELECT
+  SEARCH('Error: disk FULL on host-1', 'DISK'),
+  SEARCH('Error: disk FULL on host-1', 'full host'),
+  SEARCH('Error: disk FULL on host-1', 'dis'),
+  SEARCH('Error: disk FULL on host-1', 'disk memory'),
+  SEARCH(['info', 'disk full'], 'full'),
+  SEARCH(CAST(NULL AS STRING), 'disk')`,
+			expected: [][]bigquery.Value{{true, true, false, false, true, false}},
+		},
+		{
+			name: "backticks",
+			query: `
+SELECT
+  SEARCH('foo.bar baz', '` + "`foo.bar`" + `'),
+  SEARCH('bar foo', '` + "`foo.bar`" + `')`,
+			expected: [][]bigquery.Value{{true, false}},
+		},
+		{
+			name: "analyzer",
+			query: `
+SELECT
+  SEARCH('Hello World', 'Hello World', analyzer => 'NO_OP_ANALYZER'),
+  SEARCH('Hello World', 'hello', analyzer => 'NO_OP_ANALYZER'),
+  SEARCH('a-b c', 'b', analyzer_options => '{"delimiters": [" "]}'),
+  SEARCH('a-b c', 'a-b', analyzer_options => '{"delimiters": [" "]}')`,
+			expected: [][]bigquery.Value{{true, false, false, true}},
+		},
+		{
+			name: "json",
+			query: `
+SELECT
+  SEARCH(JSON '{"name": "Alice Smith", "tags": ["admin"]}', 'smith'),
+  SEARCH(JSON '{"name": "Alice Smith", "tags": ["admin"]}', 'tags'),
+  SEARCH(JSON '{"name": "Alice Smith", "tags": ["admin"]}', 'tags', json_scope => 'JSON_KEYS')`,
+			expected: [][]bigquery.Value{{true, false, true}},
+		},
+		{
+			name:     "table",
+			query:    "SELECT id FROM dataset1.table_a AS t WHERE SEARCH(t, 'ALICE')",
+			expected: [][]bigquery.Value{{int64(1)}},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExecuteImmediate(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	readRows := func(t *testing.T, query string) [][]bigquery.Value {
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	t.Run("dynamic insert and select into", func(t *testing.T) {
+		rows := readRows(t, `
+DECLARE table_name STRING DEFAULT 'dataset1.dynamic_table';
+DECLARE row_count INT64;
+DECLARE max_name STRING;
+EXECUTE IMMEDIATE CONCAT('CREATE TABLE ', table_name, ' (id INT64, name STRING)');
+EXECUTE IMMEDIATE CONCAT('INSERT INTO ', table_name, ' (id, name) VALUES (?, ?)') USING 1, "O'Brien";
+EXECUTE IMMEDIATE CONCAT('INSERT INTO ', table_name, ' (id, name) VALUES (@id, @name)')
+  USING 2 AS id, "x'); DROP TABLE dataset1.table_a; --" AS name;
+EXECUTE IMMEDIATE CONCAT('SELECT COUNT(*), MAX(name) FROM ', table_name, ' WHERE id > @min_id')
+  INTO row_count, max_name USING 0 AS min_id;
+SELECT row_count, max_name`)
+		expected := [][]bigquery.Value{{int64(2), "x'); DROP TABLE dataset1.table_a; --"}}
+		if diff := cmp.Diff(expected, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		rows = readRows(t, "SELECT id, name FROM dataset1.dynamic_table ORDER BY id")
+		expected = [][]bigquery.Value{{int64(1), "O'Brien"}, {int64(2), "x'); DROP TABLE dataset1.table_a; --"}}
+		if diff := cmp.Diff(expected, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		rows = readRows(t, "SELECT COUNT(*) FROM dataset1.table_a")
+		if diff := cmp.Diff([][]bigquery.Value{{int64(2)}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("variables", func(t *testing.T) {
+		rows := readRows(t, `
+DECLARE x, y INT64 DEFAULT 1;
+SET y = x + 10;
+EXECUTE IMMEDIATE 'SELECT ? * 2' INTO x USING y;
+SELECT x, y`)
+		if diff := cmp.Diff([][]bigquery.Value{{int64(22), int64(11)}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("variables named like the columns and the tables", func(t *testing.T) {
+		rows := readRows(t, `
+DECLARE id, total INT64 DEFAULT 1;
+DECLARE variable_names STRING DEFAULT 'x';
+CREATE TABLE dataset1.variable_names (total INT64, name STRING);
+INSERT INTO dataset1.variable_names (total, name) VALUES (id + 10, variable_names);
+UPDATE dataset1.variable_names SET total = total + id WHERE name = variable_names;
+SELECT v.name, total, next_id FROM dataset1.variable_names AS v, (SELECT id + 1 AS next_id)`)
+		expected := [][]bigquery.Value{{"x", int64(12), int64(2)}}
+		if diff := cmp.Diff(expected, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		rows = readRows(t, `
+DECLARE name STRING DEFAULT 'alice';
+SELECT name FROM dataset1.table_a WHERE id = 2`)
+		if diff := cmp.Diff([][]bigquery.Value{{"bob"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("error in dynamic sql", func(t *testing.T) {
+		_, err := client.Query("EXECUTE IMMEDIATE 'SELECT * FROM dataset1.unknown_table'").Read(ctx)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "EXECUTE IMMEDIATE") {
+			t.Errorf("expected the error to have the context of EXECUTE IMMEDIATE but got %s", err)
+		}
+	})
+}
+
+func TestScriptSystemVariables(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	readRows := func(t *testing.T, query string) [][]bigquery.Value {
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	t.Run("row count", func(t *testing.T) {
+		rows := readRows(t, `
+DECLARE updated, noop INT64;
+CREATE TABLE dataset1.counters (id INT64, n INT64);
+INSERT INTO dataset1.counters (id, n) VALUES (1, 0), (2, 0), (3, 0);
+UPDATE dataset1.counters SET n = n + 1 WHERE id <= 2;
+SET updated = @@row_count;
+UPDATE dataset1.counters SET n = n + 1 WHERE id > 10;
+SET noop = @@row_count;
+SELECT COUNT(*) FROM dataset1.counters;
+SELECT updated, noop, @@row_count`)
+		expected := [][]bigquery.Value{{int64(2), int64(0), nil}}
+		if diff := cmp.Diff(expected, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("time zone", func(t *testing.T) {
+		rows := readRows(t, `
+SET @@time_zone = 'America/New_York';
+SELECT
+  @@time_zone,
+  ABS(DATETIME_DIFF(CURRENT_DATETIME(), CURRENT_DATETIME('America/New_York'), MINUTE)) <= 1,
+  DATETIME_DIFF(CURRENT_DATETIME('UTC'), CURRENT_DATETIME(), HOUR) IN (4, 5)`)
+		expected := [][]bigquery.Value{{"America/New_York", true, true}}
+		if diff := cmp.Diff(expected, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("script metadata", func(t *testing.T) {
+		rows := readRows(t, `
+SELECT
+  @@project_id,
+  @@dataset_project_id,
+  @@script.creation_time <= CURRENT_TIMESTAMP(),
+  @@script.job_id IS NOT NULL,
+  @@time_zone`)
+		expected := [][]bigquery.Value{{"test", "test", true, true, "UTC"}}
+		if diff := cmp.Diff(expected, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	for _, test := range []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{
+			name:  "read-only variable",
+			query: "SET @@row_count = 1",
+			err:   "read-only",
+		},
+		{
+			name:  "invalid time zone",
+			query: "SET @@time_zone = 'Mars/Olympus_Mons'",
+			err:   "Invalid time zone",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := client.Query(test.query).Read(ctx)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error to contain %q but got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestWithClauseMaterialization(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		params   []bigquery.QueryParameter
+		expected []bigquery.Value
+	}{
+		{
+			name: "rand referenced twice",
+			query: `
+WITH r AS (
+  SELECT x, RAND() AS v FROM UNNEST(GENERATE_ARRAY(1, 10)) AS x
+)
+SELECT
+  (SELECT COUNT(*) FROM r AS r1 JOIN r AS r2 USING (x) WHERE r1.v = r2.v),
+  (SELECT TO_JSON_STRING(ARRAY_AGG(v ORDER BY x)) FROM r) = (SELECT TO_JSON_STRING(ARRAY_AGG(v ORDER BY x)) FROM r)`,
+			expected: []bigquery.Value{int64(10), true},
+		},
+		{
+			name: "nested common table expressions",
+			query: `
+WITH
+  a AS (SELECT GENERATE_UUID() AS id FROM UNNEST([1, 2, 3])),
+  b AS (SELECT id FROM a)
+SELECT COUNT(DISTINCT id), (SELECT COUNT(*) FROM b JOIN a USING (id)) FROM b`,
+			expected: []bigquery.Value{int64(3), int64(3)},
+		},
+		{
+			name: "materialized values keep the types",
+			query: `
+WITH r AS (
+  SELECT
+    STRUCT(1 AS i, [DATE '2020-01-01'] AS d) AS s,
+    TIMESTAMP '2020-01-01 00:00:00.123456+00' AS t,
+    b'\x01' AS by,
+    NUMERIC '1.5' AS n,
+    CAST(NULL AS STRING) AS null_value,
+    RAND() AS v
+)
+SELECT
+  r1.s.i,
+  CAST(r1.s.d[OFFSET(0)] AS STRING),
+  UNIX_MICROS(r1.t),
+  TO_HEX(r1.by),
+  CAST(r1.n AS STRING),
+  r1.null_value,
+  r1.v = r2.v
+FROM r AS r1, r AS r2`,
+			expected: []bigquery.Value{int64(1), "2020-01-01", int64(1577836800123456), "01", "1.5", nil, true},
+		},
+		{
+			name: "positional parameters",
+			query: `
+WITH r AS (SELECT x, RAND() AS v FROM UNNEST(GENERATE_ARRAY(1, ?)) AS x)
+SELECT COUNT(*) FROM r AS r1 JOIN r AS r2 USING (x) WHERE r1.v = r2.v AND r1.x > ?`,
+			params:   []bigquery.QueryParameter{{Value: 10}, {Value: 2}},
+			expected: []bigquery.Value{int64(8)},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			q := client.Query(test.query)
+			q.Parameters = test.params
+			it, err := q.Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGroupByStruct(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name: "group by struct column",
+			query: `
+WITH t AS (
+  SELECT STRUCT(1 AS a, 'x' AS b) AS s UNION ALL
+  SELECT STRUCT(1 AS a, 'x' AS b) UNION ALL
+  SELECT STRUCT(2 AS a, CAST(NULL AS STRING) AS b) UNION ALL
+  SELECT STRUCT(2 AS a, CAST(NULL AS STRING) AS b)
+)
+SELECT s, COUNT(*) AS n FROM t GROUP BY s ORDER BY s.a`,
+			expected: [][]bigquery.Value{
+				{[]bigquery.Value{int64(1), "x"}, int64(2)},
+				{[]bigquery.Value{int64(2), nil}, int64(2)},
+			},
+		},
+		{
+			name: "group by nested struct expression by ordinal",
+			query: `
+WITH t AS (SELECT x FROM UNNEST([1, 2, 3, 4]) AS x)
+SELECT STRUCT(MOD(x, 2) AS m, STRUCT(x > 10 AS big) AS nested) AS k, SUM(x) FROM t GROUP BY 1 ORDER BY k.m`,
+			expected: [][]bigquery.Value{
+				{[]bigquery.Value{int64(0), []bigquery.Value{false}}, int64(6)},
+				{[]bigquery.Value{int64(1), []bigquery.Value{false}}, int64(4)},
+			},
+		},
+		{
+			name: "select distinct struct",
+			query: `
+WITH t AS (
+  SELECT 1 AS id, STRUCT('a' AS v) AS s UNION ALL
+  SELECT 1, STRUCT('a' AS v) UNION ALL
+  SELECT 2, STRUCT('b' AS v)
+)
+SELECT DISTINCT id, s FROM t ORDER BY id`,
+			expected: [][]bigquery.Value{
+				{int64(1), []bigquery.Value{"a"}},
+				{int64(2), []bigquery.Value{"b"}},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "SELECT s, COUNT(*) FROM (SELECT STRUCT(JSON '1' AS j) AS s) GROUP BY s",
+			expected: "Grouping by expressions of type JSON is not allowed",
+		},
+		{
+			query:    "SELECT DISTINCT s FROM (SELECT STRUCT([1, 2] AS arr) AS s)",
+			expected: "cannot be used in SELECT DISTINCT",
+		},
+	} {
+		_, err := client.Query(test.query).Read(ctx)
+		if err == nil {
+			t.Errorf("expected error for %s", test.query)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("unexpected error for %s: %v", test.query, err)
+		}
+	}
+}
+
+func TestConditionalFunctionTypes(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name         string
+		query        string
+		expectedType bigquery.FieldType
+		expected     bigquery.Value
+	}{
+		{
+			name:         "coalesce int64 and float64",
+			query:        "SELECT COALESCE(x, 0.5) FROM UNNEST([CAST(NULL AS INT64), 2]) AS x WITH OFFSET o ORDER BY o DESC LIMIT 1",
+			expectedType: bigquery.FloatFieldType,
+			expected:     float64(2),
+		},
+		{
+			name:         "coalesce null int64 and float64",
+			query:        "SELECT COALESCE(CAST(NULL AS INT64), 0.5)",
+			expectedType: bigquery.FloatFieldType,
+			expected:     0.5,
+		},
+		{
+			name:         "ifnull int64 and numeric",
+			query:        "SELECT IFNULL(x, NUMERIC '1.5') FROM UNNEST([CAST(NULL AS INT64)]) AS x",
+			expectedType: bigquery.NumericFieldType,
+			expected:     big.NewRat(3, 2),
+		},
+		{
+			name:         "if numeric and float64",
+			query:        "SELECT IF(x > 0, NUMERIC '1.5', 2.5) FROM UNNEST([1]) AS x",
+			expectedType: bigquery.FloatFieldType,
+			expected:     1.5,
+		},
+		{
+			name:         "if int64 and numeric",
+			query:        "SELECT IF(x > 1, NUMERIC '1.5', x) FROM UNNEST([1]) AS x",
+			expectedType: bigquery.NumericFieldType,
+			expected:     big.NewRat(1, 1),
+		},
+		{
+			name:         "if null condition takes the false branch",
+			query:        "SELECT IF(CAST(NULL AS BOOL), 1, 2.5)",
+			expectedType: bigquery.FloatFieldType,
+			expected:     2.5,
+		},
+		{
+			name:         "nullif int64 and float64",
+			query:        "SELECT NULLIF(x, 1.0) FROM UNNEST([1]) AS x",
+			expectedType: bigquery.FloatFieldType,
+			expected:     nil,
+		},
+		{
+			name:         "nullif keeps the first value",
+			query:        "SELECT NULLIF(x, 1.5) FROM UNNEST([1]) AS x",
+			expectedType: bigquery.FloatFieldType,
+			expected:     float64(1),
+		},
+		{
+			name:         "case with int64, numeric and float64 branches",
+			query:        "SELECT CASE x WHEN 1 THEN x WHEN 2 THEN NUMERIC '2.5' ELSE 3.5 END FROM UNNEST([1]) AS x",
+			expectedType: bigquery.FloatFieldType,
+			expected:     float64(1),
+		},
+		{
+			name:         "case with value coerced to the supertype",
+			query:        "SELECT CASE x WHEN 1.0 THEN 'one' ELSE 'other' END FROM UNNEST([1]) AS x",
+			expectedType: bigquery.StringFieldType,
+			expected:     "one",
+		},
+		{
+			name:         "all null branches",
+			query:        "SELECT IF(TRUE, NULL, NULL)",
+			expectedType: bigquery.IntegerFieldType,
+			expected:     nil,
+		},
+		{
+			name:         "coalesce all null",
+			query:        "SELECT COALESCE(NULL, NULL)",
+			expectedType: bigquery.IntegerFieldType,
+			expected:     nil,
+		},
+		{
+			name:         "case with null branch",
+			query:        "SELECT CASE WHEN FALSE THEN NULL ELSE 'a' END",
+			expectedType: bigquery.StringFieldType,
+			expected:     "a",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if len(it.Schema) != 1 {
+				t.Fatalf("failed to get schema: %v", it.Schema)
+			}
+			if it.Schema[0].Type != test.expectedType {
+				t.Errorf("unexpected type: expected %s but got %s", test.expectedType, it.Schema[0].Type)
+			}
+			if diff := cmp.Diff(test.expected, row[0], cmp.Comparer(func(x, y *big.Rat) bool {
+				return x.Cmp(y) == 0
+			})); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, query := range []string{
+		"SELECT COALESCE('a', b'a')",
+		"SELECT IF(TRUE, 'a', b'a')",
+		"SELECT IFNULL('a', b'a')",
+		"SELECT NULLIF('a', b'a')",
+		"SELECT CASE WHEN TRUE THEN 'a' ELSE b'a' END",
+	} {
+		_, err := client.Query(query).Read(ctx)
+		if err == nil {
+			t.Errorf("expected error for %s", query)
+			continue
+		}
+		if !strings.Contains(err.Error(), "No matching signature") {
+			t.Errorf("unexpected error for %s: %v", query, err)
+		}
+	}
+}
+
+func TestNumericArithmetic(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	decimal := func(s string) *big.Rat {
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			t.Fatalf("invalid decimal %s", s)
+		}
+		return r
+	}
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:     "parse literals",
+			query:    "SELECT PARSE_NUMERIC(' - 12.34 '), PARSE_NUMERIC('12.34e-1-'), PARSE_NUMERIC('1.0000000005'), PARSE_BIGNUMERIC('1.2e+2')",
+			expected: []bigquery.Value{decimal("-12.34"), decimal("-1.234"), decimal("1.000000001"), decimal("120")},
+		},
+		{
+			name:     "parse column",
+			query:    "SELECT ARRAY_AGG(PARSE_NUMERIC(s) ORDER BY s) FROM UNNEST([' 1.5 ', '2.25-']) AS s",
+			expected: []bigquery.Value{[]bigquery.Value{decimal("1.5"), decimal("-2.25")}},
+		},
+		{
+			name:     "sum without precision loss",
+			query:    "SELECT SUM(NUMERIC '0.1'), SUM(BIGNUMERIC '0.000000000000000000000000000001') FROM UNNEST(GENERATE_ARRAY(1, 1000))",
+			expected: []bigquery.Value{decimal("100"), decimal("0.000000000000000000000000001")},
+		},
+		{
+			name:     "division",
+			query:    "SELECT NUMERIC '1' / NUMERIC '3', NUMERIC '2' / 3, NUMERIC '0.1' * 3 - NUMERIC '0.3'",
+			expected: []bigquery.Value{decimal("0.333333333"), decimal("0.666666667"), decimal("0")},
+		},
+		{
+			name:     "division scale",
+			query:    "SELECT NUMERIC '1' / 3 * 3, SAFE_DIVIDE(NUMERIC '2', 3) * 3, NUMERIC '0.000000001' * NUMERIC '0.5', BIGNUMERIC '1' / 3 * 3",
+			expected: []bigquery.Value{decimal("0.999999999"), decimal("2.000000001"), decimal("0.000000001"), decimal("0.99999999999999999999999999999999999999")},
+		},
+		{
+			name:     "division scale of columns",
+			query:    "SELECT n / d * d FROM UNNEST([STRUCT(NUMERIC '10' AS n, 7 AS d)])",
+			expected: []bigquery.Value{decimal("10.000000003")},
+		},
+		{
+			name:     "average",
+			query:    "SELECT AVG(n), AVG(DISTINCT n) FROM UNNEST([NUMERIC '1', NUMERIC '2', NUMERIC '2']) AS n",
+			expected: []bigquery.Value{decimal("1.666666667"), decimal("1.5")},
+		},
+		{
+			name: "rounding modes",
+			query: "SELECT ROUND(NUMERIC '2.5'), ROUND(NUMERIC '2.5', 0, 'ROUND_HALF_EVEN'), ROUND(NUMERIC '-2.5', 0, 'ROUND_HALF_AWAY_FROM_ZERO'), " +
+				"ROUND(NUMERIC '1.245', 2, 'ROUND_HALF_EVEN'), ROUND(NUMERIC '1.2451', 2, 'ROUND_HALF_EVEN'), ROUND(NUMERIC '250', -2, 'ROUND_HALF_EVEN')",
+			expected: []bigquery.Value{decimal("3"), decimal("2"), decimal("-3"), decimal("1.24"), decimal("1.25"), decimal("200")},
+		},
+		{
+			name:     "trunc ceil floor",
+			query:    "SELECT TRUNC(NUMERIC '-123.456', 1), TRUNC(NUMERIC '123.456', -1), CEIL(NUMERIC '-1.5'), FLOOR(NUMERIC '-1.5'), FLOOR(BIGNUMERIC '1.00000000000000000000000000000000000001')",
+			expected: []bigquery.Value{decimal("-123.4"), decimal("120"), decimal("-1"), decimal("-2"), decimal("1")},
+		},
+		{
+			name:     "rounding precision",
+			query:    "SELECT ROUND(NUMERIC '12345678901234567890.123456785', 8), ROUND(BIGNUMERIC '0.12345678901234567890123456789012345675', 37, 'ROUND_HALF_EVEN')",
+			expected: []bigquery.Value{decimal("12345678901234567890.12345679"), decimal("0.1234567890123456789012345678901234568")},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row, cmp.Comparer(func(x, y *big.Rat) bool {
+				return x.Cmp(y) == 0
+			})); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		query string
+		err   string
+	}{
+		{query: "SELECT PARSE_NUMERIC('1/3')", err: "Invalid NUMERIC value"},
+		{query: "SELECT PARSE_NUMERIC('1e30')", err: "numeric overflow"},
+		{query: "SELECT SUM(n) FROM UNNEST([NUMERIC '99999999999999999999999999999', NUMERIC '1']) AS n", err: "numeric overflow"},
+		{query: "SELECT ROUND(NUMERIC '99999999999999999999999999999.5')", err: "numeric overflow"},
+	} {
+		_, err := client.Query(test.query).Read(ctx)
+		if err == nil {
+			t.Errorf("expected error for %s", test.query)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error to contain %q for %s but got %v", test.err, test.query, err)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:     "flags and width",
+			query:    "SELECT FORMAT('%05.2f', 1.5), FORMAT('%+d', 7), FORMAT('%-5s|', 'ab'), FORMAT('%5s|', 'ab'), FORMAT('%#x', 255)",
+			expected: []bigquery.Value{"01.50", "+7", "ab   |", "   ab|", "0xff"},
+		},
+		{
+			name:     "positional and star",
+			query:    "SELECT FORMAT('%2$s %1$s', 'world', 'hello'), FORMAT('%*.*f', 8, 3, 3.14159), FORMAT('100%%')",
+			expected: []bigquery.Value{"hello world", "   3.142", "100%"},
+		},
+		{
+			name:     "column arguments",
+			query:    "SELECT FORMAT('%s=%d', name, n) FROM UNNEST([STRUCT('a' AS name, 1 AS n)])",
+			expected: []bigquery.Value{"a=1"},
+		},
+		{
+			name:     "printable",
+			query:    "SELECT FORMAT('%t', STRUCT(1 AS a, [1.0, 2.5] AS b)), FORMAT('%t', CAST(NULL AS STRING)), FORMAT('%t', DATETIME '2024-01-02 03:04:05'), FORMAT('%t', 2.0)",
+			expected: []bigquery.Value{"(1, [1.0, 2.5])", "NULL", "2024-01-02 03:04:05", "2.0"},
+		},
+		{
+			name:     "literal",
+			query:    `SELECT FORMAT('%T', 'abc'), FORMAT('%T', DATE '2024-01-02'), FORMAT('%T', NUMERIC '1.5'), FORMAT('%T', CAST('inf' AS FLOAT64))`,
+			expected: []bigquery.Value{`"abc"`, `DATE "2024-01-02"`, `NUMERIC "1.5"`, `CAST("inf" AS FLOAT64)`},
+		},
+		{
+			name:     "special float values",
+			query:    "SELECT FORMAT('%f', CAST('nan' AS FLOAT64)), FORMAT('%F', CAST('-inf' AS FLOAT64))",
+			expected: []bigquery.Value{"nan", "-INF"},
+		},
+		{
+			name:     "null argument",
+			query:    "SELECT FORMAT('%d', CAST(NULL AS INT64))",
+			expected: []bigquery.Value{nil},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:     "int64 operators",
+			query:    "SELECT 12 & 10, 12 | 10, 12 ^ 10, ~12, 1 << 4, 256 >> 4",
+			expected: []bigquery.Value{int64(8), int64(14), int64(6), int64(-13), int64(16), int64(16)},
+		},
+		{
+			name:     "int64 shift out of range",
+			query:    "SELECT 1 << 64, -1 >> 64, 1 << 63",
+			expected: []bigquery.Value{int64(0), int64(0), int64(-9223372036854775808)},
+		},
+		{
+			name:     "int64 logical right shift",
+			query:    "SELECT -1 >> 60, -16 >> 2",
+			expected: []bigquery.Value{int64(15), int64(4611686018427387900)},
+		},
+		{
+			name:     "int64 precedence",
+			query:    "SELECT 1 | 2 & 3 ^ 4, 1 + 1 << 2, x & 6 FROM UNNEST([7]) AS x",
+			expected: []bigquery.Value{int64(7), int64(8), int64(6)},
+		},
+		{
+			name:     "bytes operators",
+			query:    "SELECT b'\\x0c\\xf0' & b'\\x0a\\x0f', b'\\x0c\\xf0' | b'\\x0a\\x0f', b'\\x0c\\xf0' ^ b'\\x0a\\x0f', ~b'\\x0c\\xf0'",
+			expected: []bigquery.Value{[]byte{0x08, 0x00}, []byte{0x0e, 0xff}, []byte{0x06, 0xff}, []byte{0xf3, 0x0f}},
+		},
+		{
+			name:     "bytes shifts",
+			query:    "SELECT b'\\x01\\x81' << 1, b'\\x01\\x81' >> 1, b'\\x01\\x02' << 8, b'\\x01\\x02' >> 20",
+			expected: []bigquery.Value{[]byte{0x03, 0x02}, []byte{0x00, 0xc0}, []byte{0x02, 0x00}, []byte{0x00, 0x00}},
+		},
+		{
+			name:     "bytes null",
+			query:    "SELECT CAST(NULL AS BYTES) & b'\\x01', ~CAST(NULL AS BYTES), b'\\x01' << CAST(NULL AS INT64)",
+			expected: []bigquery.Value{nil, nil, nil},
+		},
+		{
+			name:     "bit count",
+			query:    "SELECT BIT_COUNT(-1), BIT_COUNT(12), BIT_COUNT(b'\\xff\\x01'), BIT_COUNT(b'\\x0c\\xf0' ^ b'\\x0a\\x0f')",
+			expected: []bigquery.Value{int64(64), int64(2), int64(9), int64(10)},
+		},
+		{
+			name:     "safe negate",
+			query:    "SELECT SAFE_NEGATE(5), SAFE_NEGATE(-9223372036854775808), SAFE_NEGATE(1.5), SAFE_NEGATE(NUMERIC '2.5')",
+			expected: []bigquery.Value{int64(-5), nil, float64(-1.5), big.NewRat(-5, 2)},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row, cmp.Comparer(func(x, y *big.Rat) bool {
+				return x.Cmp(y) == 0
+			})); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		name          string
+		query         string
+		expectedError string
+	}{
+		{
+			name:          "int64 negative shift",
+			query:         "SELECT 1 << -1",
+			expectedError: "Bitwise shift by negative offset.",
+		},
+		{
+			name:          "bytes negative shift",
+			query:         "SELECT b'\\x01' >> -1",
+			expectedError: "Bitwise shift by negative offset.",
+		},
+		{
+			name:          "bytes unequal length",
+			query:         "SELECT b'\\x01' & b'\\x01\\x02'",
+			expectedError: "Got 1 bytes on the left hand side and 2 bytes on the right hand side.",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := client.Query(test.query).Read(ctx)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), test.expectedError) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNavigationAndNumberingFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name: "ranking with ties",
+			query: `SELECT x, RANK() OVER (ORDER BY x), DENSE_RANK() OVER (ORDER BY x), PERCENT_RANK() OVER (ORDER BY x), CUME_DIST() OVER (ORDER BY x)
+FROM UNNEST([10, 20, 20, 30]) AS x ORDER BY x`,
+			expected: [][]bigquery.Value{
+				{int64(10), int64(1), int64(1), float64(0), 0.25},
+				{int64(20), int64(2), int64(2), 1.0 / 3, 0.75},
+				{int64(20), int64(2), int64(2), 1.0 / 3, 0.75},
+				{int64(30), int64(4), int64(3), float64(1), float64(1)},
+			},
+		},
+		{
+			name: "ranking by partition and descending keys",
+			query: `SELECT p, s, RANK() OVER (PARTITION BY p ORDER BY s DESC), DENSE_RANK() OVER (PARTITION BY p ORDER BY s DESC, n)
+FROM UNNEST([STRUCT('a' AS p, 'x' AS s, 1 AS n), STRUCT('a', 'y', 2), STRUCT('a', 'y', 2), STRUCT('a', 'y', 3), STRUCT('b', 'x', 1)])
+ORDER BY p, s DESC, n`,
+			expected: [][]bigquery.Value{
+				{"a", "y", int64(1), int64(1)},
+				{"a", "y", int64(1), int64(1)},
+				{"a", "y", int64(1), int64(2)},
+				{"a", "x", int64(4), int64(3)},
+				{"b", "x", int64(1), int64(1)},
+			},
+		},
+		{
+			name:  "null keys",
+			query: "SELECT x, RANK() OVER (ORDER BY x DESC), RANK() OVER (ORDER BY x ASC NULLS LAST) FROM UNNEST([1, NULL, 2]) AS x ORDER BY x",
+			expected: [][]bigquery.Value{
+				{nil, int64(3), int64(3)},
+				{int64(1), int64(2), int64(1)},
+				{int64(2), int64(1), int64(2)},
+			},
+		},
+		{
+			name:  "ntile",
+			query: "SELECT x, NTILE(3) OVER (ORDER BY x), NTILE(10) OVER (ORDER BY x) FROM UNNEST([1, 2, 3, 4, 5, 6, 7]) AS x ORDER BY x",
+			expected: [][]bigquery.Value{
+				{int64(1), int64(1), int64(1)},
+				{int64(2), int64(1), int64(2)},
+				{int64(3), int64(1), int64(3)},
+				{int64(4), int64(2), int64(4)},
+				{int64(5), int64(2), int64(5)},
+				{int64(6), int64(3), int64(6)},
+				{int64(7), int64(3), int64(7)},
+			},
+		},
+		{
+			name: "lead and lag",
+			query: `SELECT x, LEAD(v) OVER (ORDER BY x), LEAD(v, 1, -1) OVER (ORDER BY x), LAG(v, 2, -1) OVER (ORDER BY x)
+FROM UNNEST([STRUCT(1 AS x, 10 AS v), STRUCT(2, NULL), STRUCT(3, 30)]) ORDER BY x`,
+			expected: [][]bigquery.Value{
+				{int64(1), nil, nil, int64(-1)},
+				{int64(2), int64(30), int64(30), int64(-1)},
+				{int64(3), nil, int64(-1), int64(10)},
+			},
+		},
+		{
+			name: "lead and lag ignore nulls",
+			query: `SELECT x, LEAD(v IGNORE NULLS) OVER (ORDER BY x), LAG(v, 1, 0 IGNORE NULLS) OVER (ORDER BY x), LEAD(v RESPECT NULLS) OVER (ORDER BY x)
+FROM UNNEST([STRUCT(1 AS x, 10 AS v), STRUCT(2, NULL), STRUCT(3, 30)]) ORDER BY x`,
+			expected: [][]bigquery.Value{
+				{int64(1), int64(30), int64(0), nil},
+				{int64(2), int64(30), int64(10), int64(30)},
+				{int64(3), nil, int64(10), nil},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCorrelatedArraySubqueries(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, query := range []string{
+		"CREATE TABLE dataset1.orders (id INT64, factor INT64, nums ARRAY<INT64>, items ARRAY<STRUCT<sku STRING, qty INT64, tags ARRAY<STRING>>>)",
+		`INSERT INTO dataset1.orders (id, factor, nums, items) VALUES
+  (1, 10, [3, 1, 2], [STRUCT('a' AS sku, 2 AS qty, ['x', 'y'] AS tags), STRUCT('b', 0, ARRAY<STRING>[])]),
+  (2, 1, [], []),
+  (3, 2, NULL, NULL)`,
+	} {
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := job.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name:  "map with outer column",
+			query: "SELECT id, ARRAY(SELECT n * t.factor FROM UNNEST(t.nums) AS n WITH OFFSET AS o ORDER BY o) FROM dataset1.orders AS t ORDER BY id",
+			expected: [][]bigquery.Value{
+				{int64(1), []bigquery.Value{int64(30), int64(10), int64(20)}},
+				{int64(2), nil},
+				{int64(3), nil},
+			},
+		},
+		{
+			name:  "filter with outer column",
+			query: "SELECT id, ARRAY(SELECT n FROM UNNEST(t.nums) AS n WITH OFFSET AS o WHERE n * t.factor > 10 ORDER BY o) FROM dataset1.orders AS t ORDER BY id",
+			expected: [][]bigquery.Value{
+				{int64(1), []bigquery.Value{int64(3), int64(2)}},
+				{int64(2), nil},
+				{int64(3), nil},
+			},
+		},
+		{
+			name:  "empty and null arrays",
+			query: "SELECT id, ARRAY_LENGTH(ARRAY(SELECT n FROM UNNEST(t.nums) AS n)), ARRAY(SELECT n FROM UNNEST(t.nums) AS n) IS NULL FROM dataset1.orders AS t ORDER BY id",
+			expected: [][]bigquery.Value{
+				{int64(1), int64(3), false},
+				{int64(2), int64(0), false},
+				{int64(3), int64(0), false},
+			},
+		},
+		{
+			name: "nested array of structs",
+			query: `SELECT id, ARRAY(
+  SELECT AS STRUCT i.sku, i.qty * t.factor AS total, ARRAY(SELECT UPPER(tag) FROM UNNEST(i.tags) AS tag WITH OFFSET AS p ORDER BY p) AS tags
+  FROM UNNEST(t.items) AS i WITH OFFSET AS o ORDER BY o
+) FROM dataset1.orders AS t WHERE id = 1`,
+			expected: [][]bigquery.Value{
+				{int64(1), []bigquery.Value{
+					[]bigquery.Value{"a", int64(20), []bigquery.Value{"X", "Y"}},
+					[]bigquery.Value{"b", int64(0), nil},
+				}},
+			},
+		},
+		{
+			name:  "offset order",
+			query: "SELECT ARRAY(SELECT FORMAT('%d:%s', o, i.sku) FROM UNNEST(t.items) AS i WITH OFFSET AS o ORDER BY o DESC) FROM dataset1.orders AS t WHERE id = 1",
+			expected: [][]bigquery.Value{
+				{[]bigquery.Value{"1:b", "0:a"}},
+			},
+		},
+		{
+			name:  "loaded table",
+			query: "SELECT id, ARRAY(SELECT s.key FROM UNNEST(t.structarr) AS s) FROM dataset1.table_a AS t ORDER BY id",
+			expected: [][]bigquery.Value{
+				{int64(1), []bigquery.Value{"profile"}},
+				{int64(2), []bigquery.Value{"profile"}},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestErrorReasons(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name    string
+		request func() error
+		code    int
+		reason  string
+		message string
+	}{
+		{
+			name: "missing dataset",
+			request: func() error {
+				_, err := client.Dataset("missing").Metadata(ctx)
+				return err
+			},
+			code:    http.StatusNotFound,
+			reason:  "notFound",
+			message: "Not found: Dataset test:missing",
+		},
+		{
+			name: "missing table",
+			request: func() error {
+				_, err := client.Dataset("dataset1").Table("missing").Metadata(ctx)
+				return err
+			},
+			code:    http.StatusNotFound,
+			reason:  "notFound",
+			message: "Not found: Table test:dataset1.missing",
+		},
+		{
+			name: "duplicate dataset",
+			request: func() error {
+				return client.Dataset("dataset1").Create(ctx, nil)
+			},
+			code:    http.StatusConflict,
+			reason:  "duplicate",
+			message: "Already Exists: Dataset test:dataset1",
+		},
+		{
+			name: "invalid dataset location",
+			request: func() error {
+				return client.Dataset("dataset2").Create(ctx, &bigquery.DatasetMetadata{Location: "moon"})
+			},
+			code:    http.StatusBadRequest,
+			reason:  "invalid",
+			message: "Invalid dataset location",
+		},
+		{
+			name: "syntax error",
+			request: func() error {
+				_, err := client.Query("SELECT 1 FROM").Read(ctx)
+				return err
+			},
+			code:    http.StatusBadRequest,
+			reason:  "invalidQuery",
+			message: "at [1:",
+		},
+		{
+			name: "unrecognized name",
+			request: func() error {
+				_, err := client.Query("SELECT missing FROM dataset1.table_a").Read(ctx)
+				return err
+			},
+			code:    http.StatusBadRequest,
+			reason:  "invalidQuery",
+			message: "Unrecognized name: missing at [1:8]",
+		},
+		{
+			name: "query missing table",
+			request: func() error {
+				_, err := client.Query("SELECT * FROM dataset1.missing").Read(ctx)
+				return err
+			},
+			code:    http.StatusNotFound,
+			reason:  "notFound",
+			message: "Not found: Table",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.request()
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			gerr, ok := err.(*googleapi.Error)
+			if !ok {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			if gerr.Code != test.code {
+				t.Errorf("unexpected status code %d: %v", gerr.Code, gerr)
+			}
+			if len(gerr.Errors) == 0 {
+				t.Fatalf("errors are not found: %v", gerr)
+			}
+			if gerr.Errors[0].Reason != test.reason {
+				t.Errorf("unexpected reason %s: %v", gerr.Errors[0].Reason, gerr)
+			}
+			if !strings.Contains(gerr.Message, test.message) {
+				t.Errorf("unexpected message: %s", gerr.Message)
+			}
+		})
+	}
+
+	t.Run("failed job status", func(t *testing.T) {
+		job, err := client.Query("SELECT missing FROM dataset1.table_a").Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		jobErr, ok := status.Err().(*bigquery.Error)
+		if !ok {
+			t.Fatalf("unexpected error type %T: %v", status.Err(), status.Err())
+		}
+		if diff := cmp.Diff(&bigquery.Error{
+			Location: "query",
+			Message:  "Unrecognized name: missing at [1:8]",
+			Reason:   "invalidQuery",
+		}, jobErr); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if len(status.Errors) != 1 {
+			t.Fatalf("unexpected errors: %v", status.Errors)
+		}
+	})
+}
+
+func TestValueTables(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name:     "array of structs",
+			query:    "SELECT ARRAY(SELECT AS STRUCT x, x * 2 AS y FROM UNNEST([1, 2]) AS x ORDER BY x)",
+			expected: [][]bigquery.Value{{[]bigquery.Value{[]bigquery.Value{int64(1), int64(2)}, []bigquery.Value{int64(2), int64(4)}}}},
+		},
+		{
+			name:     "select as struct",
+			query:    "SELECT AS STRUCT 1 AS a, 'x' AS b",
+			expected: [][]bigquery.Value{{int64(1), "x"}},
+		},
+		{
+			name:     "select as value",
+			query:    "SELECT AS VALUE STRUCT(x AS a, x + 1 AS b) FROM UNNEST([1, 3]) AS x ORDER BY x",
+			expected: [][]bigquery.Value{{int64(1), int64(2)}, {int64(3), int64(4)}},
+		},
+		{
+			name:     "value table in set operation",
+			query:    "SELECT AS VALUE STRUCT(1 AS a) UNION ALL SELECT AS VALUE STRUCT(2 AS a)",
+			expected: [][]bigquery.Value{{int64(1)}, {int64(2)}},
+		},
+		{
+			name:     "in subquery",
+			query:    "SELECT x FROM UNNEST([1, 2, 3]) AS x WHERE x IN (SELECT AS VALUE y FROM UNNEST([2, 3]) AS y) ORDER BY x",
+			expected: [][]bigquery.Value{{int64(2)}, {int64(3)}},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPivot(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	const produce = `(
+  SELECT 'Kale' AS product, 51 AS sales, 'Q1' AS quarter UNION ALL
+  SELECT 'Kale', 23, 'Q2' UNION ALL
+  SELECT 'Kale', 45, 'Q1' UNION ALL
+  SELECT 'Apple', 77, 'Q1' UNION ALL
+  SELECT 'Apple', 10, 'Q2'
+)`
+
+	t.Run("multiple aggregates", func(t *testing.T) {
+		it, err := client.Query(
+			"SELECT * FROM " + produce + " PIVOT(SUM(sales) AS total, COUNT(sales) AS num FOR quarter IN ('Q1', 'Q2' AS second)) ORDER BY product",
+		).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		var columns []string
+		for _, field := range it.Schema {
+			columns = append(columns, field.Name)
+		}
+		if diff := cmp.Diff([]string{"product", "total_Q1", "num_Q1", "total_second", "num_second"}, columns); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		expected := [][]bigquery.Value{
+			{"Apple", int64(77), int64(1), int64(10), int64(1)},
+			{"Kale", int64(96), int64(2), int64(23), int64(1)},
+		}
+		if diff := cmp.Diff(expected, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("value names", func(t *testing.T) {
+		it, err := client.Query(`
+SELECT * FROM (
+  SELECT 'a' AS k, 1 AS q, 10 AS v UNION ALL
+  SELECT 'a', NULL, 5 UNION ALL
+  SELECT 'b', 2, 7
+) PIVOT(SUM(v) FOR q IN (1, 2 AS two, NULL)) ORDER BY k`,
+		).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		var columns []string
+		for _, field := range it.Schema {
+			columns = append(columns, field.Name)
+		}
+		if diff := cmp.Diff([]string{"k", "_1", "two", "NULL"}, columns); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		expected := [][]bigquery.Value{
+			{"a", int64(10), nil, int64(5)},
+			{"b", nil, int64(7), nil},
+		}
+		if diff := cmp.Diff(expected, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	for _, test := range []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{
+			name:  "value without name",
+			query: "SELECT * FROM " + produce + " PIVOT(SUM(sales) FOR quarter IN (DATE '2020-01-01'))",
+			err:   "PIVOT value DATE '2020-01-01' requires an alias",
+		},
+		{
+			name:  "subquery",
+			query: "SELECT * FROM " + produce + " PIVOT(SUM(sales) FOR quarter IN (SELECT DISTINCT quarter FROM " + produce + "))",
+			err:   "IN subquery is not supported in PIVOT",
+		},
+		{
+			name:  "column reference",
+			query: "SELECT * FROM " + produce + " PIVOT(SUM(sales) FOR quarter IN (product, 'Q2'))",
+			err:   "PIVOT values must be constants but got product",
+		},
+		{
+			name:  "duplicate value alias",
+			query: "SELECT * FROM " + produce + " PIVOT(SUM(sales) FOR quarter IN ('Q1', 'Q2' AS Q1))",
+			err:   "Duplicate column name Q1 in the output of PIVOT",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := client.Query(test.query).Read(ctx)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error to contain %q but got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		columns  []string
+		expected [][]bigquery.Value
+	}{
+		{
+			name:     "intersect distinct",
+			query:    "SELECT x FROM UNNEST([1, 2, 2, 3, NULL]) AS x INTERSECT DISTINCT SELECT x FROM UNNEST([2, 3, 4, NULL]) AS x ORDER BY x",
+			columns:  []string{"x"},
+			expected: [][]bigquery.Value{{nil}, {int64(2)}, {int64(3)}},
+		},
+		{
+			name:     "except distinct",
+			query:    "SELECT x FROM UNNEST([1, 1, 2, NULL]) AS x EXCEPT DISTINCT SELECT x FROM UNNEST([2, NULL]) AS x",
+			columns:  []string{"x"},
+			expected: [][]bigquery.Value{{int64(1)}},
+		},
+		{
+			name:     "type unification",
+			query:    "SELECT 1 AS x UNION ALL SELECT 2.5 ORDER BY x",
+			columns:  []string{"x"},
+			expected: [][]bigquery.Value{{float64(1)}, {2.5}},
+		},
+		{
+			name:     "union all by name",
+			query:    "WITH t AS (SELECT 1 AS a, 'x' AS b) SELECT * FROM t UNION ALL BY NAME SELECT 'y' AS b, 2 AS a ORDER BY a",
+			columns:  []string{"a", "b"},
+			expected: [][]bigquery.Value{{int64(1), "x"}, {int64(2), "y"}},
+		},
+		{
+			name:     "full union all by name",
+			query:    "SELECT 1 AS a, 2 AS b FULL UNION ALL BY NAME SELECT 3 AS c, 4 AS a ORDER BY a",
+			columns:  []string{"a", "b", "c"},
+			expected: [][]bigquery.Value{{int64(1), int64(2), nil}, {int64(4), nil, int64(3)}},
+		},
+		{
+			name:     "union distinct corresponding",
+			query:    "SELECT 1 AS a, 2 AS b UNION DISTINCT CORRESPONDING SELECT 2 AS b, 3 AS c",
+			columns:  []string{"b"},
+			expected: [][]bigquery.Value{{int64(2)}},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			var columns []string
+			for _, field := range it.Schema {
+				columns = append(columns, field.Name)
+			}
+			if diff := cmp.Diff(test.columns, columns); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{
+			name:  "intersect all",
+			query: "SELECT 1 INTERSECT ALL SELECT 1",
+			err:   "INTERSECT ALL is not supported",
+		},
+		{
+			name:  "except all",
+			query: "SELECT 1 EXCEPT ALL SELECT 1",
+			err:   "EXCEPT ALL is not supported",
+		},
+		{
+			name:  "mismatched columns",
+			query: "SELECT 1 AS a, 2 AS b UNION ALL BY NAME SELECT 1 AS a",
+			err:   "Column b is missing in input 2 of UNION ALL BY NAME",
+		},
+		{
+			name:  "mixed set operations",
+			query: "SELECT 1 AS a UNION ALL SELECT 2 AS a UNION ALL BY NAME SELECT 3 AS a",
+			err:   "Different set operations UNION ALL and UNION ALL BY NAME can't be combined without parentheses",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := client.Query(test.query).Read(ctx)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error to contain %q but got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestModelStatements(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	exec := func(query string) error {
+		_, err := client.Query(query).Read(ctx)
+		return err
+	}
+
+	it, err := client.Query(`
+UPDATE dataset1.table_a SET name = 'carol' WHERE id = 2;
+CREATE MODEL dataset1.m
+OPTIONS(
+  model_type = 'linear_reg',
+  input_label_cols = ['label'],
+  l2_reg = 0.1,
+  max_iterations = HPARAM_RANGE(1, 5),
+  description = 'created by script'
+) AS SELECT id, skillNum AS label FROM dataset1.table_a;
+ALTER MODEL dataset1.m SET OPTIONS(labels = [('env', 'test')]);
+SELECT name FROM dataset1.table_a WHERE id = 2`).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row []bigquery.Value
+	if err := it.Next(&row); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]bigquery.Value{"carol"}, row); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+
+	md, err := client.Dataset("dataset1").Model("m").Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Type != "LINEAR_REGRESSION" {
+		t.Errorf("unexpected model type: %s", md.Type)
+	}
+	if md.Description != "created by script" {
+		t.Errorf("unexpected description: %s", md.Description)
+	}
+	if diff := cmp.Diff(map[string]string{"env": "test"}, md.Labels); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+	featureColumns, err := md.RawFeatureColumns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labelColumns, err := md.RawLabelColumns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*bigquery.StandardSQLField{
+		{Name: "id", Type: &bigquery.StandardSQLDataType{TypeKind: "INT64"}},
+	}, featureColumns); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]*bigquery.StandardSQLField{
+		{Name: "label", Type: &bigquery.StandardSQLDataType{TypeKind: "NUMERIC"}},
+	}, labelColumns); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+
+	if err := exec("CREATE MODEL dataset1.m OPTIONS(model_type = 'kmeans') AS SELECT id FROM dataset1.table_a"); err == nil {
+		t.Fatal("expected error for the existing model")
+	}
+	if err := exec("CREATE MODEL IF NOT EXISTS dataset1.m OPTIONS(model_type = 'kmeans') AS SELECT id FROM dataset1.table_a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec("CREATE MODEL dataset1.invalid OPTIONS(model_type = 'linear_reg') AS SELECT id FROM dataset1.table_a"); err == nil {
+		t.Fatal("expected error for the training data without the label column")
+	}
+	if err := exec("CREATE MODEL dataset1.invalid OPTIONS(model_type = 'kmeans') AS SELECT unknown FROM dataset1.table_a"); err == nil {
+		t.Fatal("expected error for the invalid training query")
+	}
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{query: "SELECT * FROM ML.EVALUATE(MODEL dataset1.m)", expected: "ML.EVALUATE is not supported"},
+		{query: "EXPORT MODEL dataset1.m OPTIONS(URI = 'gs://bucket/path')", expected: "EXPORT MODEL is not supported"},
+	} {
+		err := exec(test.query)
+		if err == nil {
+			t.Errorf("expected error for %s", test.query)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("unexpected error for %s: %v", test.query, err)
+		}
+	}
+
+	if err := exec("DROP MODEL dataset1.m"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Dataset("dataset1").Model("m").Metadata(ctx); err == nil {
+		t.Fatal("expected the model to be deleted")
+	}
+	if err := exec("DROP MODEL dataset1.m"); err == nil {
+		t.Fatal("expected error for the dropped model")
+	}
+	if err := exec("DROP MODEL IF EXISTS dataset1.m"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTableFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, query := range []string{
+		"CREATE TABLE FUNCTION dataset1.names_after(min_id INT64) AS (SELECT id, name FROM dataset1.table_a WHERE id > min_id)",
+		"CREATE TABLE FUNCTION dataset1.ids(t TABLE<id INT64>, offset_by ANY TYPE) RETURNS TABLE<id INT64> AS SELECT id + offset_by AS id FROM t",
+		"CREATE TABLE FUNCTION dataset1.loop(x INT64) AS SELECT * FROM dataset1.loop(x)",
+	} {
+		if _, err := client.Query(query).Read(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	routine, err := client.Dataset("dataset1").Routine("names_after").Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routine.Type != "TABLE_VALUED_FUNCTION" {
+		t.Fatalf("unexpected routine type %s", routine.Type)
+	}
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name:     "scalar argument",
+			query:    "SELECT name FROM dataset1.names_after(1)",
+			expected: [][]bigquery.Value{{"bob"}},
+		},
+		{
+			name:     "qualified by the function name",
+			query:    "SELECT names_after.id FROM dataset1.names_after(0) ORDER BY names_after.id",
+			expected: [][]bigquery.Value{{int64(1)}, {int64(2)}},
+		},
+		{
+			name:     "table argument",
+			query:    "SELECT id FROM dataset1.ids(TABLE dataset1.table_a, 10) ORDER BY id",
+			expected: [][]bigquery.Value{{int64(11)}, {int64(12)}},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		query string
+		err   string
+	}{
+		{query: "SELECT * FROM dataset1.loop(1)", err: "called recursively"},
+		{query: "SELECT * FROM dataset1.names_after()", err: "No matching signature"},
+		{query: "CREATE TABLE FUNCTION dataset1.names_after(min_id INT64) AS SELECT 1", err: "Already Exists"},
+	} {
+		_, err := client.Query(test.query).Read(ctx)
+		if err == nil {
+			t.Errorf("expected error for %s", test.query)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error to contain %q for %s but got %v", test.err, test.query, err)
+		}
+	}
+
+	if _, err := client.Query("DROP TABLE FUNCTION dataset1.names_after").Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Query("SELECT * FROM dataset1.names_after(1)").Read(ctx); err == nil {
+		t.Fatal("expected error for the dropped table function")
+	}
+}
+
+func TestStructEquality(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name: "struct equality in where",
+			query: `
+WITH t AS (
+  SELECT 1 AS id, STRUCT(1 AS a, 'x' AS b) AS s UNION ALL
+  SELECT 2, STRUCT(1 AS a, 'y' AS b) UNION ALL
+  SELECT 3, STRUCT(1 AS a, CAST(NULL AS STRING) AS b)
+)
+SELECT id FROM t WHERE s = STRUCT(1 AS a, 'x' AS b) ORDER BY id`,
+			expected: [][]bigquery.Value{
+				{int64(1)},
+			},
+		},
+		{
+			name: "struct inequality with null field",
+			query: `
+WITH t AS (
+  SELECT 1 AS id, STRUCT(1 AS a, 'x' AS b) AS s UNION ALL
+  SELECT 2, STRUCT(1 AS a, 'y' AS b) UNION ALL
+  SELECT 3, STRUCT(1 AS a, CAST(NULL AS STRING) AS b)
+)
+SELECT id, s != (1, 'x') FROM t ORDER BY id`,
+			expected: [][]bigquery.Value{
+				{int64(1), false},
+				{int64(2), true},
+				{int64(3), nil},
+			},
+		},
+		{
+			name: "nested struct equality",
+			query: `
+WITH t AS (
+  SELECT 1 AS id, STRUCT(1 AS a, STRUCT('x' AS c) AS n) AS s UNION ALL
+  SELECT 2, STRUCT(1 AS a, STRUCT('y' AS c) AS n)
+)
+SELECT id FROM t WHERE s = STRUCT(1, STRUCT('y'))`,
+			expected: [][]bigquery.Value{
+				{int64(2)},
+			},
+		},
+		{
+			name: "join on struct key",
+			query: `
+WITH l AS (
+  SELECT STRUCT(1 AS a, 'x' AS b) AS k, 'left1' AS v UNION ALL
+  SELECT STRUCT(2 AS a, CAST(NULL AS STRING) AS b), 'left2'
+), r AS (
+  SELECT STRUCT(1 AS a, 'x' AS b) AS k, 'right1' AS v UNION ALL
+  SELECT STRUCT(2 AS a, CAST(NULL AS STRING) AS b), 'right2'
+)
+SELECT l.v, r.v FROM l JOIN r ON l.k = r.k`,
+			expected: [][]bigquery.Value{
+				{"left1", "right1"},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{
+			query:    "SELECT [1, 2] = [1, 2]",
+			expected: "not defined",
+		},
+		{
+			query:    "SELECT STRUCT(1 AS a) < STRUCT(2 AS a)",
+			expected: "not defined",
+		},
+	} {
+		_, err := client.Query(test.query).Read(ctx)
+		if err == nil {
+			t.Errorf("expected error for %s", test.query)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("unexpected error for %s: %v", test.query, err)
+		}
+	}
+}
+
+func TestDebugLog(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	logPath := filepath.Join(t.TempDir(), "emulator.log")
+	if err := bqServer.SetLogOutput(logPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := bqServer.SetLogFormat(server.LogFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	if err := bqServer.SetLogLevel(server.LogLevelDebug); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, startTestServer(t, bqServer), "test")
+
+	readQueryLogs := func(t *testing.T) []map[string]interface{} {
+		t.Helper()
+		content, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var logs []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("failed to decode log line %q: %v", line, err)
+			}
+			if entry["M"] == "query" && strings.Contains(fmt.Sprint(entry["sql"]), "debug_log") {
+				logs = append(logs, entry)
+			}
+		}
+		return logs
+	}
+	queryLogs := func(t *testing.T, query string) []map[string]interface{} {
+		t.Helper()
+		if _, err := client.Query(query).Read(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return readQueryLogs(t)
+	}
+
+	t.Run("query", func(t *testing.T) {
+		logs := queryLogs(t, "SELECT 'secret' AS debug_log")
+		if len(logs) != 1 {
+			t.Fatalf("expected one query log but got %d", len(logs))
+		}
+		entry := logs[0]
+		if entry["sql"] != "SELECT 'secret' AS debug_log" {
+			t.Errorf("unexpected sql %v", entry["sql"])
+		}
+		if entry["jobId"] == "" {
+			t.Errorf("job id is not logged")
+		}
+		if entry["rows"] != float64(1) {
+			t.Errorf("unexpected rows %v", entry["rows"])
+		}
+		if _, exists := entry["latency"]; !exists {
+			t.Errorf("latency is not logged")
+		}
+	})
+
+	t.Run("redacted query", func(t *testing.T) {
+		bqServer.SetLogQueryRedaction(true)
+		defer bqServer.SetLogQueryRedaction(false)
+
+		logs := queryLogs(t, "SELECT 'secret' AS debug_log, 42 AS n")
+		if len(logs) != 2 {
+			t.Fatalf("expected two query logs but got %d", len(logs))
+		}
+		if sql := logs[1]["sql"]; sql != "SELECT ? AS debug_log, ? AS n" {
+			t.Errorf("unexpected sql %v", sql)
+		}
+	})
+
+	t.Run("redacted error", func(t *testing.T) {
+		bqServer.SetLogQueryRedaction(true)
+		defer bqServer.SetLogQueryRedaction(false)
+
+		if _, err := client.Query("SELECT ERROR('secret') AS debug_log").Read(ctx); err == nil {
+			t.Fatal("expected error")
+		}
+		logs := readQueryLogs(t)
+		if len(logs) != 3 {
+			t.Fatalf("expected three query logs but got %d", len(logs))
+		}
+		errMsg := fmt.Sprint(logs[2]["error"])
+		if !strings.Contains(errMsg, "<redacted>") || strings.Contains(errMsg, "secret") {
+			t.Errorf("unexpected error %v", errMsg)
+		}
+	})
+}
+
+func TestCreateTableDefinitions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "users.csv")
+	if err := os.WriteFile(csvPath, []byte("id,name\n1,alice\n2,bob\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jsonPath := filepath.Join(dir, "events.json")
+	if err := os.WriteFile(jsonPath, []byte(`{"id": 1, "tags": ["a", "b"]}`+"\n"+`{"id": 2, "tags": []}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dataset := client.Dataset("dataset1")
+	for name, meta := range map[string]*bigquery.TableMetadata{
+		"view_a": {
+			ViewQuery: "SELECT id, name FROM dataset1.table_a WHERE id = 1",
+		},
+		"materialized_view_a": {
+			MaterializedView: &bigquery.MaterializedViewDefinition{
+				Query: "SELECT COUNT(*) AS n FROM dataset1.table_a",
+			},
+		},
+		"external_csv": {
+			ExternalDataConfig: &bigquery.ExternalDataConfig{
+				SourceFormat: bigquery.CSV,
+				SourceURIs:   []string{csvPath},
+				AutoDetect:   true,
+			},
+		},
+		"external_json": {
+			ExternalDataConfig: &bigquery.ExternalDataConfig{
+				SourceFormat: bigquery.JSON,
+				SourceURIs:   []string{"file://" + jsonPath},
+				Schema: bigquery.Schema{
+					{Name: "id", Type: bigquery.IntegerFieldType},
+					{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+				},
+			},
+		},
+	} {
+		if err := dataset.Table(name).Create(ctx, meta); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+
+	for name, expected := range map[string]bigquery.TableType{
+		"view_a":              bigquery.ViewTable,
+		"materialized_view_a": bigquery.MaterializedView,
+		"external_csv":        bigquery.ExternalTable,
+		"external_json":       bigquery.ExternalTable,
+	} {
+		meta, err := dataset.Table(name).Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Type != expected {
+			t.Errorf("unexpected type of %s: %s", name, meta.Type)
+		}
+	}
+
+	query := func(t *testing.T, q string) [][]bigquery.Value {
+		t.Helper()
+		it, err := client.Query(q).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	t.Run("view", func(t *testing.T) {
+		rows := query(t, "SELECT id, name FROM dataset1.view_a")
+		if diff := cmp.Diff([][]bigquery.Value{{int64(1), "alice"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("external csv table", func(t *testing.T) {
+		rows := query(t, "SELECT id, name FROM dataset1.external_csv ORDER BY id")
+		if diff := cmp.Diff([][]bigquery.Value{{int64(1), "alice"}, {int64(2), "bob"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if err := os.WriteFile(csvPath, []byte("id,name\n1,alice\n2,bob\n3,carol\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		rows = query(t, "SELECT COUNT(*) FROM dataset1.external_csv")
+		if diff := cmp.Diff([][]bigquery.Value{{int64(3)}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("external json table", func(t *testing.T) {
+		rows := query(t, "SELECT id, ARRAY_LENGTH(tags) FROM dataset1.external_json ORDER BY id")
+		if diff := cmp.Diff([][]bigquery.Value{{int64(1), int64(2)}, {int64(2), int64(0)}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	for name, meta := range map[string]*bigquery.TableMetadata{
+		"legacy_view": {
+			ViewQuery:    "SELECT 1",
+			UseLegacySQL: true,
+		},
+		"external_gcs": {
+			ExternalDataConfig: &bigquery.ExternalDataConfig{
+				SourceFormat: bigquery.CSV,
+				SourceURIs:   []string{"gs://bucket/data.csv"},
+				AutoDetect:   true,
+			},
+		},
+	} {
+		if err := dataset.Table(name).Create(ctx, meta); err == nil {
+			t.Errorf("expected error for %s", name)
+		}
+	}
+}
+
+func TestExternalTables(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"scores.csv":    "name,score\nalice,10\nbob,not a number\ncarol,30\n",
+		"events-1.json": `{"id": 1, "kind": "click"}` + "\n",
+		"events-2.json": `{"id": 2, "kind": "view"}` + "\n" + `{"id": 3, "kind": "click"}` + "\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataset := client.Dataset("dataset1")
+	if err := dataset.Table("scores").Create(ctx, &bigquery.TableMetadata{
+		ExternalDataConfig: &bigquery.ExternalDataConfig{
+			SourceFormat:  bigquery.CSV,
+			SourceURIs:    []string{filepath.Join(dir, "scores.csv")},
+			MaxBadRecords: 1,
+			Options:       &bigquery.CSVOptions{SkipLeadingRows: 1},
+			Schema: bigquery.Schema{
+				{Name: "name", Type: bigquery.StringFieldType},
+				{Name: "score", Type: bigquery.IntegerFieldType},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataset.Table("events").Create(ctx, &bigquery.TableMetadata{
+		ExternalDataConfig: &bigquery.ExternalDataConfig{
+			SourceFormat: bigquery.JSON,
+			SourceURIs:   []string{filepath.Join(dir, "events-*.json")},
+			AutoDetect:   true,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name:  "csv with a bad record",
+			query: "SELECT name, score FROM dataset1.scores ORDER BY name",
+			expected: [][]bigquery.Value{
+				{"alice", int64(10)},
+				{"carol", int64(30)},
+			},
+		},
+		{
+			name:  "wildcard json files",
+			query: "SELECT * FROM dataset1.events ORDER BY id",
+			expected: [][]bigquery.Value{
+				{int64(1), "click"},
+				{int64(2), "view"},
+				{int64(3), "click"},
+			},
+		},
+		{
+			name:  "file name pseudo-column",
+			query: "SELECT _FILE_NAME AS file, COUNT(*) FROM dataset1.events GROUP BY file ORDER BY file",
+			expected: [][]bigquery.Value{
+				{filepath.Join(dir, "events-1.json"), int64(1)},
+				{filepath.Join(dir, "events-2.json"), int64(2)},
+			},
+		},
+		{
+			name:  "file name pseudo-column is not expanded by star",
+			query: "SELECT *, e._FILE_NAME FROM dataset1.events AS e WHERE id = 1",
+			expected: [][]bigquery.Value{
+				{int64(1), "click", filepath.Join(dir, "events-1.json")},
+			},
+		},
+		{
+			name:     "file name in the string literal",
+			query:    "SELECT *, '_FILE_NAME' FROM dataset1.events WHERE id = 1",
+			expected: [][]bigquery.Value{{int64(1), "click", "_FILE_NAME"}},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("too many bad records", func(t *testing.T) {
+		content := "name,score\nalice,x\nbob,y\n"
+		if err := os.WriteFile(filepath.Join(dir, "scores.csv"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := client.Query("SELECT * FROM dataset1.scores").Read(ctx)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "too many bad records") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("reload after the files change", func(t *testing.T) {
+		count := func() int64 {
+			t.Helper()
+			it, err := client.Query("SELECT COUNT(*) FROM dataset1.events").Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			return row[0].(int64)
+		}
+		if got := count(); got != 3 {
+			t.Fatalf("expected 3 rows but got %d", got)
+		}
+		if got := count(); got != 3 {
+			t.Fatalf("expected 3 rows but got %d", got)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "events-3.json"), []byte(`{"id": 4, "kind": "view"}`+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got := count(); got != 4 {
+			t.Fatalf("expected 4 rows after adding the file but got %d", got)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "events-1.json"), []byte(`{"id": 1, "kind": "click"}`+"\n"+`{"id": 5, "kind": "click"}`+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got := count(); got != 5 {
+			t.Fatalf("expected 5 rows after modifying the file but got %d", got)
+		}
+	})
+	t.Run("load job parses the rows like the external table", func(t *testing.T) {
+		source := bigquery.NewReaderSource(strings.NewReader("name|score\nalice|1\nbob|x\ncarol|3\n"))
+		source.SourceFormat = bigquery.CSV
+		source.FieldDelimiter = "|"
+		source.MaxBadRecords = 1
+		source.Schema = bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "score", Type: bigquery.IntegerFieldType},
+		}
+		job, err := dataset.Table("loaded_scores").LoaderFrom(source).Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := status.Err(); err != nil {
+			t.Fatal(err)
+		}
+		it, err := client.Query("SELECT name, score FROM dataset1.loaded_scores ORDER BY name").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		if diff := cmp.Diff([][]bigquery.Value{{"alice", int64(1)}, {"carol", int64(3)}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestHivePartitionedExternalTable(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		filepath.Join("dt=2023-01-01", "region=us", "part.json"): `{"id": 1}` + "\n" + `{"id": 2}` + "\n",
+		filepath.Join("dt=2023-01-01", "region=eu", "part.json"): `{"id": 3}` + "\n",
+		filepath.Join("dt=2023-01-02", "region=us", "part.json"): `{"id": 4}` + "\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataset := client.Dataset("dataset1")
+	for _, table := range []struct {
+		name          string
+		mode          bigquery.HivePartitioningMode
+		prefix        string
+		requireFilter bool
+	}{
+		{name: "events", mode: bigquery.AutoHivePartitioningMode, prefix: dir},
+		{name: "custom_events", mode: bigquery.CustomHivePartitioningMode, prefix: dir + "/{dt:STRING}/{region:STRING}"},
+		{name: "required_events", mode: bigquery.AutoHivePartitioningMode, prefix: dir, requireFilter: true},
+	} {
+		if err := dataset.Table(table.name).Create(ctx, &bigquery.TableMetadata{
+			ExternalDataConfig: &bigquery.ExternalDataConfig{
+				SourceFormat: bigquery.JSON,
+				SourceURIs:   []string{filepath.Join(dir, "*", "*", "part.json")},
+				Schema: bigquery.Schema{
+					{Name: "id", Type: bigquery.IntegerFieldType},
+				},
+				HivePartitioningOptions: &bigquery.HivePartitioningOptions{
+					Mode:                   table.mode,
+					SourceURIPrefix:        table.prefix,
+					RequirePartitionFilter: table.requireFilter,
+				},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name:  "partition columns",
+			query: "SELECT id, dt, region FROM dataset1.events ORDER BY id",
+			expected: [][]bigquery.Value{
+				{int64(1), civil.Date{Year: 2023, Month: 1, Day: 1}, "us"},
+				{int64(2), civil.Date{Year: 2023, Month: 1, Day: 1}, "us"},
+				{int64(3), civil.Date{Year: 2023, Month: 1, Day: 1}, "eu"},
+				{int64(4), civil.Date{Year: 2023, Month: 1, Day: 2}, "us"},
+			},
+		},
+		{
+			name:  "partition filter",
+			query: "SELECT id FROM dataset1.events WHERE dt = '2023-01-01' AND region = 'us' ORDER BY id",
+			expected: [][]bigquery.Value{
+				{int64(1)},
+				{int64(2)},
+			},
+		},
+		{
+			name:  "custom partition keys",
+			query: "SELECT dt, COUNT(*) FROM dataset1.custom_events GROUP BY dt ORDER BY dt",
+			expected: [][]bigquery.Value{
+				{"2023-01-01", int64(3)},
+				{"2023-01-02", int64(1)},
+			},
+		},
+		{
+			name:  "required partition filter",
+			query: "SELECT id FROM dataset1.required_events WHERE region = 'eu'",
+			expected: [][]bigquery.Value{
+				{int64(3)},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("without required partition filter", func(t *testing.T) {
+		_, err := client.Query("SELECT * FROM dataset1.required_events").Read(ctx)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "without a filter over column(s)") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestDirectorySource(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		filepath.Join("sales", "customers.jsonl"): `{"id": 1, "name": "alice"}` + "\n" + `{"id": 2, "name": "bob"}` + "\n",
+		filepath.Join("sales", "orders.csv"):      "customer_id,word\n1,hello\n2,world\n1,world\n",
+		filepath.Join("shared", "dictionary", "words.json"): `{"word": "hello", "length": 5}` + "\n" +
+			`{"word": "world", "length": 5}` + "\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bqServer := newTestServer(
+		t,
+		server.StructSource(types.NewProject("test")),
+		server.DirectorySource("test", dir),
+	)
+	client := newTestClient(t, startTestServer(t, bqServer), "test")
+
+	md, err := client.Dataset("sales").Table("orders").Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Type != bigquery.ExternalTable {
+		t.Errorf("unexpected table type: %s", md.Type)
+	}
+
+	it, err := client.Query(`
+SELECT c.name, o.word, w.length
+FROM sales.orders AS o
+JOIN sales.customers AS c ON c.id = o.customer_id
+JOIN shared.dictionary.words AS w ON w.word = o.word
+ORDER BY c.name, o.word`).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]bigquery.Value
+	for {
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			if err == iterator.Done {
+				break
+			}
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	expected := [][]bigquery.Value{
+		{"alice", "hello", int64(5)},
+		{"alice", "world", int64(5)},
+		{"bob", "world", int64(5)},
+	}
+	if diff := cmp.Diff(expected, rows); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+
+	t.Run("shared dataset is read-only", func(t *testing.T) {
+		for _, query := range []string{
+			"INSERT shared.dictionary.words (word, length) VALUES ('bye', 3)",
+			"DELETE FROM shared.dictionary.words WHERE TRUE",
+			"ALTER TABLE shared.dictionary.words SET OPTIONS (description = 'words')",
+			"DROP TABLE shared.dictionary.words",
+			"CREATE TABLE shared.dictionary.letters (letter STRING)",
+			"DROP SCHEMA shared.dictionary CASCADE",
+		} {
+			_, err := client.Query(query).Read(ctx)
+			if err == nil {
+				t.Errorf("expected error for %s", query)
+				continue
+			}
+			if !strings.Contains(err.Error(), "is read-only") {
+				t.Errorf("unexpected error for %s: %v", query, err)
+			}
+		}
+		dataset := client.DatasetInProject("shared", "dictionary")
+		if err := dataset.Table("words").Delete(ctx); err == nil {
+			t.Error("expected error for deleting the table")
+		}
+		if err := dataset.DeleteWithContents(ctx); err == nil {
+			t.Error("expected error for deleting the dataset")
+		}
+		if _, err := dataset.Table("words").Metadata(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// the datasets of the project are writable.
+		if _, err := client.Query("CREATE TABLE sales.notes (id INT64)").Read(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("conflicting table names", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dir, "sales"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"orders.csv", "orders.json"} {
+			if err := os.WriteFile(filepath.Join(dir, "sales", name), []byte("{}\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := bqServer.Load(server.DirectorySource("test", dir))
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "table test.sales.orders is defined by both") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestDistinctOrderByLimitOffset(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	if _, err := client.Query(`
+CREATE TABLE dataset1.visits AS
+SELECT * FROM UNNEST([
+  STRUCT('carol' AS name, 'Tokyo' AS city),
+  ('alice', 'Osaka'), ('bob', 'tokyo'), ('alice', 'Tokyo'), ('dave', 'Kyoto'),
+  ('bob', 'Osaka'), ('erin', 'Nagoya'), ('carol', 'Kyoto'), ('frank', 'Tokyo')
+])`).Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	readColumn := func(t *testing.T, query string, params ...bigquery.QueryParameter) []bigquery.Value {
+		t.Helper()
+		q := client.Query(query)
+		q.Parameters = params
+		it, err := q.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := []bigquery.Value{}
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			values = append(values, row[0])
+		}
+		return values
+	}
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		params   []bigquery.QueryParameter
+		expected []bigquery.Value
+	}{
+		{
+			name:     "first page",
+			query:    "SELECT DISTINCT name FROM dataset1.visits ORDER BY name LIMIT 2",
+			expected: []bigquery.Value{"alice", "bob"},
+		},
+		{
+			name:     "second page",
+			query:    "SELECT DISTINCT name FROM dataset1.visits ORDER BY name LIMIT 2 OFFSET 2",
+			expected: []bigquery.Value{"carol", "dave"},
+		},
+		{
+			name:     "last page",
+			query:    "SELECT DISTINCT name FROM dataset1.visits ORDER BY name DESC LIMIT 4 OFFSET 4",
+			expected: []bigquery.Value{"bob", "alice"},
+		},
+		{
+			name:     "offset beyond the result",
+			query:    "SELECT DISTINCT name FROM dataset1.visits ORDER BY name LIMIT 2 OFFSET 10",
+			expected: []bigquery.Value{},
+		},
+		{
+			name:     "limit 0",
+			query:    "SELECT DISTINCT name FROM dataset1.visits ORDER BY name LIMIT 0",
+			expected: []bigquery.Value{},
+		},
+		{
+			name:     "distinct expression",
+			query:    "SELECT DISTINCT LOWER(city) AS city FROM dataset1.visits ORDER BY city LIMIT 2 OFFSET 1",
+			expected: []bigquery.Value{"nagoya", "osaka"},
+		},
+		{
+			name:  "parameters",
+			query: "SELECT DISTINCT name FROM dataset1.visits ORDER BY name LIMIT @size OFFSET @offset",
+			params: []bigquery.QueryParameter{
+				{Name: "size", Value: 3},
+				{Name: "offset", Value: 3},
+			},
+			expected: []bigquery.Value{"dave", "erin", "frank"},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			got := readColumn(t, test.query, test.params...)
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("pages cover the distinct rows", func(t *testing.T) {
+		all := readColumn(t, "SELECT DISTINCT name, city FROM dataset1.visits ORDER BY name, city")
+		paged := []bigquery.Value{}
+		for offset := 0; offset < len(all)+2; offset += 2 {
+			paged = append(paged, readColumn(t, fmt.Sprintf("SELECT DISTINCT name, city FROM dataset1.visits ORDER BY name, city LIMIT 2 OFFSET %d", offset))...)
+		}
+		if diff := cmp.Diff(all, paged); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("create table as select keeps the limit", func(t *testing.T) {
+		if _, err := client.Query(`
+CREATE TABLE dataset1.first_visitors AS
+SELECT DISTINCT name FROM dataset1.visits ORDER BY name LIMIT 2 OFFSET 1`).Read(ctx); err != nil {
+			t.Fatal(err)
+		}
+		got := readColumn(t, "SELECT name FROM dataset1.first_visitors ORDER BY name")
+		if diff := cmp.Diff([]bigquery.Value{"bob", "carol"}, got); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("view keeps the limit", func(t *testing.T) {
+		if _, err := client.Query(`
+CREATE VIEW dataset1.last_visitors AS
+SELECT DISTINCT name FROM dataset1.visits ORDER BY name DESC LIMIT 2`).Read(ctx); err != nil {
+			t.Fatal(err)
+		}
+		got := readColumn(t, "SELECT name FROM dataset1.last_visitors ORDER BY name")
+		if diff := cmp.Diff([]bigquery.Value{"erin", "frank"}, got); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestStringSimilarityFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:     "edit distance",
+			query:    `SELECT EDIT_DISTANCE('a', 'b'), EDIT_DISTANCE('aa', 'b'), EDIT_DISTANCE('kitten', 'sitting'), EDIT_DISTANCE('abc', 'abc'), EDIT_DISTANCE('', 'abc')`,
+			expected: []bigquery.Value{int64(1), int64(2), int64(3), int64(0), int64(3)},
+		},
+		{
+			name:     "max distance",
+			query:    `SELECT EDIT_DISTANCE('kitten', 'sitting', max_distance => 2), EDIT_DISTANCE('kitten', 'sitting', max_distance => 5), EDIT_DISTANCE('abc', 'xyz', max_distance => 0)`,
+			expected: []bigquery.Value{int64(2), int64(3), int64(0)},
+		},
+		{
+			name:     "characters and bytes",
+			query:    `SELECT EDIT_DISTANCE('résumé', 'resume'), EDIT_DISTANCE(CAST('résumé' AS BYTES), CAST('resume' AS BYTES)), EDIT_DISTANCE('東京', '京都')`,
+			expected: []bigquery.Value{int64(2), int64(4), int64(2)},
+		},
+		{
+			name:     "null",
+			query:    `SELECT EDIT_DISTANCE(CAST(NULL AS STRING), 'a'), EDIT_DISTANCE('a', CAST(NULL AS STRING), max_distance => 1)`,
+			expected: []bigquery.Value{nil, nil},
+		},
+		{
+			name:     "in the query of the table",
+			query:    `SELECT ARRAY_AGG(name ORDER BY name) FROM UNNEST(['apple', 'apply', 'ample', 'maple']) AS name WHERE EDIT_DISTANCE(name, 'appel') <= 2`,
+			expected: []bigquery.Value{[]bigquery.Value{"apple", "apply"}},
+		},
+		{
+			name:     "soundex",
+			query:    `SELECT SOUNDEX('Ashcraft'), SOUNDEX('Robert'), SOUNDEX('Rupert'), SOUNDEX('apple'), SOUNDEX('Hello world!'), SOUNDEX('#1'), SOUNDEX(CAST(NULL AS STRING))`,
+			expected: []bigquery.Value{"A261", "R163", "R163", "a140", "H464", "", nil},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+	t.Run("negative max distance", func(t *testing.T) {
+		if _, err := client.Query(`SELECT EDIT_DISTANCE('a', 'b', max_distance => -1)`).Read(ctx); err == nil {
+			t.Fatal("expected the error for the negative max_distance")
+		}
+	})
+}
+
+func TestConfigFile(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data", "initial.yaml"), []byte(`
+projects:
+- id: test
+  datasets:
+    - id: initial
+      tables:
+        - id: items
+          columns:
+            - name: id
+              type: INTEGER
+          data:
+            - id: 1
+            - id: 2
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(`
+project: test
+dataset: default_dataset
+location: EU
+dataFromYAML:
+  - data/initial.yaml
+server:
+  port: 19050
+  logLevel: info
+  database: bigquery.db
+  shutdownGracePeriod: 10s
+  maxResultRows: 100
+  databaseBusyTimeout: 2s
+  grpcReflection: true
+  enforceDatasetAccess: true
+  initSQL: init.sql
+  initTimeout: 0s
+projects:
+  - id: test
+    datasets:
+      - id: analytics
+        location: asia-northeast1
+        defaultTableExpirationMs: 86400000
+        labels:
+          team: data
+        routines:
+          - id: add_one
+            metadata:
+              arguments:
+                - name: x
+                  dataType: {typeKind: INT64}
+              definitionBody: x + 1
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := server.LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 19050 || cfg.Server.LogLevel != server.LogLevelInfo || time.Duration(cfg.Server.ShutdownGracePeriod) != 10*time.Second || cfg.Server.MaxResultRows != 100 {
+		t.Fatalf("unexpected server settings: %+v", cfg.Server)
+	}
+	if cfg.Server.Database != filepath.Join(dir, "bigquery.db") {
+		t.Fatalf("failed to resolve the database path: %s", cfg.Server.Database)
+	}
+	if time.Duration(cfg.Server.DatabaseBusyTimeout) != 2*time.Second || !cfg.Server.GRPCReflection || !cfg.Server.EnforceDatasetAccess {
+		t.Fatalf("unexpected server settings: %+v", cfg.Server)
+	}
+	if cfg.Server.InitSQL != filepath.Join(dir, "init.sql") {
+		t.Fatalf("failed to resolve the init SQL path: %s", cfg.Server.InitSQL)
+	}
+	if cfg.Server.InitTimeout == nil || *cfg.Server.InitTimeout != 0 {
+		t.Fatalf("expected the init timeout without limit but got %v", cfg.Server.InitTimeout)
+	}
+	if diff := cmp.Diff([]string{filepath.Join(dir, "data", "initial.yaml")}, cfg.DataFromYAML); diff != "" {
+		t.Fatalf("failed to resolve the data paths (-want +got):\n%s", diff)
+	}
+
+	bqServer := newTestServer(t, server.ConfigSource(cfg))
+	client := newTestClient(t, startTestServer(t, bqServer), "test")
+
+	t.Run("datasets", func(t *testing.T) {
+		md, err := client.Dataset("analytics").Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.Location != "asia-northeast1" {
+			t.Fatalf("unexpected location: %s", md.Location)
+		}
+		if md.DefaultTableExpiration != 24*time.Hour {
+			t.Fatalf("unexpected default table expiration: %v", md.DefaultTableExpiration)
+		}
+		if diff := cmp.Diff(map[string]string{"team": "data"}, md.Labels); diff != "" {
+			t.Fatalf("unexpected labels (-want +got):\n%s", diff)
+		}
+		md, err = client.Dataset("default_dataset").Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.Location != "EU" {
+			t.Fatalf("expected the default location of the config file but got %s", md.Location)
+		}
+	})
+	t.Run("data", func(t *testing.T) {
+		it, err := client.Query("SELECT COUNT(*) FROM initial.items").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if row[0] != int64(2) {
+			t.Fatalf("unexpected count: %v", row[0])
+		}
+	})
+	t.Run("routine", func(t *testing.T) {
+		it, err := client.Query("SELECT analytics.add_one(41)").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if row[0] != int64(42) {
+			t.Fatalf("unexpected result: %v", row[0])
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, test := range []struct {
+			name    string
+			content string
+			errMsg  string
+		}{
+			{name: "unknown key", content: "project: test\nunknown: 1\n", errMsg: "unknown"},
+			{name: "location", content: "project: test\nlocation: moon\n", errMsg: "invalid location: moon"},
+			{name: "log level", content: "server:\n  logLevel: verbose\n", errMsg: "invalid log level: verbose"},
+			{name: "duration", content: "server:\n  shutdownGracePeriod: soon\n", errMsg: "invalid duration"},
+			{name: "unknown server key", content: "server:\n  unknown: true\n", errMsg: "unknown"},
+			{name: "dataset without project", content: "dataset: ds\n", errMsg: "requires project"},
+		} {
+			path := filepath.Join(dir, test.name+".yaml")
+			if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := server.LoadConfig(path)
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		}
+	})
+}
+
+func TestUnixTimeConversions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name:  "epoch to timestamp",
+			query: `SELECT TIMESTAMP_SECONDS(1230219000), TIMESTAMP_MILLIS(1230219000123), TIMESTAMP_MICROS(1230219000000005)`,
+			expected: []bigquery.Value{
+				time.Date(2008, 12, 25, 15, 30, 0, 0, time.UTC),
+				time.Date(2008, 12, 25, 15, 30, 0, 123000000, time.UTC),
+				time.Date(2008, 12, 25, 15, 30, 0, 5000, time.UTC),
+			},
+		},
+		{
+			name:  "negative epoch",
+			query: `SELECT TIMESTAMP_SECONDS(-1), TIMESTAMP_MILLIS(-1500), TIMESTAMP_MICROS(-1)`,
+			expected: []bigquery.Value{
+				time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC),
+				time.Date(1969, 12, 31, 23, 59, 58, 500000000, time.UTC),
+				time.Date(1969, 12, 31, 23, 59, 59, 999999000, time.UTC),
+			},
+		},
+		{
+			name:  "range limits",
+			query: `SELECT TIMESTAMP_SECONDS(-62135596800), TIMESTAMP_MICROS(253402300799999999), TIMESTAMP_SECONDS(10413792000)`,
+			expected: []bigquery.Value{
+				time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(9999, 12, 31, 23, 59, 59, 999999000, time.UTC),
+				time.Date(2300, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "timestamp to epoch",
+			query:    `SELECT UNIX_SECONDS(TIMESTAMP '2008-12-25 15:30:00.9+00'), UNIX_MILLIS(TIMESTAMP '2008-12-25 15:30:00.123456+00'), UNIX_MICROS(TIMESTAMP '2008-12-25 15:30:00.000005+00')`,
+			expected: []bigquery.Value{int64(1230219000), int64(1230219000123), int64(1230219000000005)},
+		},
+		{
+			name:     "timestamp before 1970 to epoch",
+			query:    `SELECT UNIX_SECONDS(TIMESTAMP '1969-12-31 23:59:59.5+00'), UNIX_MILLIS(TIMESTAMP '1969-12-31 23:59:59.9985+00'), UNIX_MICROS(TIMESTAMP '0001-01-01 00:00:00+00')`,
+			expected: []bigquery.Value{int64(-1), int64(-2), int64(-62135596800000000)},
+		},
+		{
+			name:     "round trip",
+			query:    `SELECT UNIX_MICROS(TIMESTAMP_MICROS(-123456789)), UNIX_MILLIS(TIMESTAMP_MILLIS(253402300799999)), UNIX_SECONDS(TIMESTAMP_SECONDS(-62135596800))`,
+			expected: []bigquery.Value{int64(-123456789), int64(253402300799999), int64(-62135596800)},
+		},
+		{
+			name:  "unix date",
+			query: `SELECT DATE_FROM_UNIX_DATE(14238), DATE_FROM_UNIX_DATE(-1), DATE_FROM_UNIX_DATE(2932896), UNIX_DATE(DATE '2008-12-25'), UNIX_DATE(DATE '0001-01-01')`,
+			expected: []bigquery.Value{
+				civil.Date{Year: 2008, Month: 12, Day: 25},
+				civil.Date{Year: 1969, Month: 12, Day: 31},
+				civil.Date{Year: 9999, Month: 12, Day: 31},
+				int64(14238),
+				int64(-719162),
+			},
+		},
+		{
+			name:     "null",
+			query:    `SELECT TIMESTAMP_SECONDS(NULL), DATE_FROM_UNIX_DATE(NULL), UNIX_MICROS(NULL)`,
+			expected: []bigquery.Value{nil, nil, nil},
+		},
+		{
+			name:     "safe",
+			query:    `SELECT SAFE.TIMESTAMP_SECONDS(253402300800), SAFE.TIMESTAMP_MILLIS(9223372036854775807), SAFE.DATE_FROM_UNIX_DATE(-719163)`,
+			expected: []bigquery.Value{nil, nil, nil},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+	for _, query := range []string{
+		`SELECT TIMESTAMP_SECONDS(253402300800)`,
+		`SELECT TIMESTAMP_MILLIS(9223372036854775807)`,
+		`SELECT TIMESTAMP_MICROS(-62135596800000001)`,
+		`SELECT DATE_FROM_UNIX_DATE(2932897)`,
+	} {
+		query := query
+		t.Run(query, func(t *testing.T) {
+			_, err := client.Query(query).Read(ctx)
+			if err == nil || !strings.Contains(err.Error(), "out of allowed range") {
+				t.Fatalf("expected the range error but got %v", err)
+			}
+		})
+	}
+}
+
+func TestAutoCreateProject(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t)
+	bqServer.SetAutoCreateProject(true)
+	bqServer.SetAutoCreateDataset("dataset1")
+	testServer := startTestServer(t, bqServer)
+
+	newClient := func(t *testing.T, projectID string) *bigquery.Client {
+		client, err := bigquery.NewClient(
+			ctx,
+			projectID,
+			option.WithEndpoint(testServer.URL),
+			option.WithoutAuthentication(),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+
+	t.Run("create on the first request", func(t *testing.T) {
+		client := newClient(t, "auto")
+		md, err := client.Dataset("dataset1").Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.Location != server.DefaultLocation {
+			t.Fatalf("unexpected location: %s", md.Location)
+		}
+		if err := client.Dataset("dataset1").Table("t").Create(ctx, &bigquery.TableMetadata{
+			Schema: bigquery.Schema{{Name: "id", Type: bigquery.IntegerFieldType}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		it, err := client.Query("SELECT COUNT(*) FROM dataset1.t").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if row[0] != int64(0) {
+			t.Fatalf("unexpected count: %v", row[0])
+		}
+	})
+	t.Run("load into the created project", func(t *testing.T) {
+		if err := bqServer.Load(server.StructSource(types.NewProject("auto", types.NewDataset("loaded")))); err != nil {
+			t.Fatal(err)
+		}
+		client := newClient(t, "auto")
+		for _, datasetID := range []string{"dataset1", "loaded"} {
+			if _, err := client.Dataset(datasetID).Metadata(ctx); err != nil {
+				t.Fatalf("dataset %s: %v", datasetID, err)
+			}
+		}
+		if _, err := client.Dataset("dataset1").Table("t").Metadata(ctx); err != nil {
+			t.Fatalf("the table created in the auto-created project is lost: %v", err)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		bqServer.SetAutoCreateProject(false)
+		defer bqServer.SetAutoCreateProject(true)
+
+		client := newClient(t, "strict")
+		_, err := client.Dataset("dataset1").Metadata(ctx)
+		gerr, ok := err.(*googleapi.Error)
+		if !ok {
+			t.Fatalf("unexpected error type %T: %v", err, err)
+		}
+		if gerr.Code != http.StatusNotFound || !strings.Contains(gerr.Message, "Not found: Project strict") {
+			t.Fatalf("unexpected error: %v", gerr)
+		}
+	})
+}
+
+type userTransport struct {
+	user string
+}
+
+func (t *userTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set(server.UserHeader, t.user)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestDatasetAccess(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t)
+	if err := bqServer.SetProject("test"); err != nil {
+		t.Fatal(err)
+	}
+	bqServer.SetDatasetAccessEnforcement(true)
+	testServer := startTestServer(t, bqServer)
+
+	newClient := func(t *testing.T, user string) *bigquery.Client {
+		client, err := bigquery.NewClient(
+			ctx,
+			"test",
+			option.WithEndpoint(testServer.URL),
+			option.WithHTTPClient(&http.Client{Transport: &userTransport{user: user}}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	const (
+		owner = "owner@example.com"
+		alice = "alice@example.com"
+		carol = "carol@example.com"
+		bob   = "bob@other.example.com"
+	)
+	ownerClient := newClient(t, owner)
+	ownerEntry := &bigquery.AccessEntry{Role: bigquery.OwnerRole, EntityType: bigquery.UserEmailEntity, Entity: owner}
+	for _, ds := range []struct {
+		id     string
+		access []*bigquery.AccessEntry
+	}{
+		{
+			id: "private",
+			access: []*bigquery.AccessEntry{
+				ownerEntry,
+				{EntityType: bigquery.ViewEntity, View: ownerClient.Dataset("shared").Table("v")},
+				{EntityType: bigquery.DatasetEntity, Dataset: &bigquery.DatasetAccessEntry{
+					Dataset: ownerClient.Dataset("reports"), TargetTypes: []string{"VIEWS"},
+				}},
+				{EntityType: bigquery.RoutineEntity, Routine: ownerClient.Dataset("fns").Routine("secret_ids")},
+			},
+		},
+		{
+			id: "shared",
+			access: []*bigquery.AccessEntry{
+				ownerEntry,
+				{Role: bigquery.ReaderRole, EntityType: bigquery.UserEmailEntity, Entity: alice},
+			},
+		},
+		{
+			id: "reports",
+			access: []*bigquery.AccessEntry{
+				ownerEntry,
+				{Role: bigquery.ReaderRole, EntityType: bigquery.DomainEntity, Entity: "example.com"},
+			},
+		},
+		{
+			id: "fns",
+			access: []*bigquery.AccessEntry{
+				ownerEntry,
+				{Role: bigquery.ReaderRole, EntityType: bigquery.UserEmailEntity, Entity: alice},
+			},
+		},
+	} {
+		if err := ownerClient.Dataset(ds.id).Create(ctx, &bigquery.DatasetMetadata{Access: ds.access}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ownerClient.Dataset("private").Table("secrets").Create(ctx, &bigquery.TableMetadata{
+		Schema: bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType},
+			{Name: "secret", Type: bigquery.StringFieldType},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		"INSERT INTO private.secrets (id, secret) VALUES (1, 'a'), (2, 'b')",
+		"CREATE TABLE FUNCTION fns.secret_ids() AS SELECT id FROM test.private.secrets",
+	} {
+		job, err := ownerClient.Query(query).Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := status.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, view := range []struct {
+		datasetID string
+		tableID   string
+	}{
+		{datasetID: "shared", tableID: "v"},
+		{datasetID: "reports", tableID: "r"},
+		{datasetID: "shared", tableID: "unauthorized"},
+	} {
+		if err := ownerClient.Dataset(view.datasetID).Table(view.tableID).Create(ctx, &bigquery.TableMetadata{
+			ViewQuery: "SELECT id FROM test.private.secrets",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	countRows := func(client *bigquery.Client, query string) (int, error) {
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			return 0, err
+		}
+		var n int
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					return n, nil
+				}
+				return 0, err
+			}
+			n++
+		}
+	}
+	for _, test := range []struct {
+		name  string
+		user  string
+		query string
+	}{
+		{name: "owner reads the table directly", user: owner, query: "SELECT * FROM private.secrets"},
+		{name: "authorized view", user: alice, query: "SELECT id FROM shared.v"},
+		{name: "authorized dataset", user: carol, query: "SELECT id FROM reports.r"},
+		{name: "authorized routine", user: alice, query: "SELECT id FROM fns.secret_ids()"},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			n, err := countRows(newClient(t, test.user), test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 2 {
+				t.Fatalf("expected 2 rows but got %d", n)
+			}
+		})
+	}
+	for _, test := range []struct {
+		name   string
+		user   string
+		query  string
+		errMsg string
+	}{
+		{
+			name:   "direct read",
+			user:   alice,
+			query:  "SELECT * FROM private.secrets",
+			errMsg: "Access Denied: Table test:private.secrets",
+		},
+		{
+			name:   "view that is not authorized",
+			user:   alice,
+			query:  "SELECT id FROM shared.unauthorized",
+			errMsg: "Access Denied: Table test:private.secrets",
+		},
+		{
+			name:   "user without access to the view",
+			user:   bob,
+			query:  "SELECT id FROM shared.v",
+			errMsg: "Access Denied: Table test:shared.v",
+		},
+		{
+			name:   "user without the header",
+			user:   "",
+			query:  "SELECT id FROM shared.v",
+			errMsg: "Access Denied: Table test:shared.v",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := countRows(newClient(t, test.user), test.query)
+			if err == nil {
+				t.Fatal("expected the access denied error")
+			}
+			if !strings.Contains(err.Error(), test.errMsg) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	t.Run("view created by CREATE VIEW", func(t *testing.T) {
+		if _, err := countRows(ownerClient, "CREATE VIEW shared.ddl_view AS SELECT id FROM test.private.secrets"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := countRows(newClient(t, alice), "SELECT id FROM shared.ddl_view")
+		if err == nil || !strings.Contains(err.Error(), "Access Denied: Table test:private.secrets") {
+			t.Fatalf("expected the access denied error but got %v", err)
+		}
+	})
+
+	t.Run("unqualified name of the default dataset", func(t *testing.T) {
+		q := newClient(t, alice).Query("SELECT * FROM secrets")
+		q.DefaultDatasetID = "private"
+		_, err := q.Read(ctx)
+		if err == nil || !strings.Contains(err.Error(), "Access Denied: Table test:private.secrets") {
+			t.Fatalf("expected the access denied error but got %v", err)
+		}
+	})
+
+	t.Run("tabledata.list", func(t *testing.T) {
+		readTable := func(client *bigquery.Client) error {
+			it := client.Dataset("private").Table("secrets").Read(ctx)
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						return nil
+					}
+					return err
+				}
+			}
+		}
+		if err := readTable(ownerClient); err != nil {
+			t.Fatal(err)
+		}
+		err := readTable(newClient(t, alice))
+		if err == nil || !strings.Contains(err.Error(), "Access Denied: Table test:private.secrets") {
+			t.Fatalf("expected the access denied error but got %v", err)
+		}
+	})
+}
+
+func TestDMLRollbackOnError(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	runQuery := func(query string) error {
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			return err
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	}
+	for _, query := range []string{
+		"CREATE TABLE dataset1.balances (id INT64, name STRING, amount NUMERIC)",
+		"INSERT INTO dataset1.balances (id, name, amount) VALUES (1, 'a', 10), (2, 'b', 99999999999999999999999999999), (3, 'c', 30)",
+		"CREATE TABLE dataset1.changes (id INT64, amount NUMERIC)",
+		"INSERT INTO dataset1.changes (id, amount) VALUES (1, 1), (1, 2), (4, 40)",
+	} {
+		if err := runQuery(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	maxInteger, _ := new(big.Rat).SetString("99999999999999999999999999999")
+	original := [][]bigquery.Value{
+		{int64(1), "a", big.NewRat(10, 1)},
+		{int64(2), "b", maxInteger},
+		{int64(3), "c", big.NewRat(30, 1)},
+	}
+	ratComparer := cmp.Comparer(func(x, y *big.Rat) bool {
+		return x.Cmp(y) == 0
+	})
+	readBalances := func(t *testing.T) [][]bigquery.Value {
+		t.Helper()
+		it, err := client.Query("SELECT id, name, amount FROM dataset1.balances ORDER BY id").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	for _, test := range []struct {
+		name    string
+		query   string
+		message string
+	}{
+		{
+			name: "merge matching multiple source rows",
+			query: `MERGE dataset1.balances AS t USING dataset1.changes AS s ON t.id = s.id
+WHEN MATCHED THEN UPDATE SET amount = t.amount + s.amount
+WHEN NOT MATCHED THEN INSERT (id, name, amount) VALUES (s.id, 'd', s.amount)`,
+			message: "UPDATE/MERGE must match at most one source row for each target row",
+		},
+		{
+			name:    "update overflowing numeric",
+			query:   "UPDATE dataset1.balances SET amount = amount * 10 WHERE true",
+			message: "numeric overflow",
+		},
+		{
+			name:    "insert overflowing numeric",
+			query:   "INSERT INTO dataset1.balances (id, name, amount) SELECT id + 10, name, amount * NUMERIC '1e28' FROM dataset1.balances",
+			message: "numeric overflow",
+		},
+		{
+			name:    "update failing on a row",
+			query:   "UPDATE dataset1.balances SET name = IF(id = 3, ERROR('failed on row 3'), 'updated') WHERE true",
+			message: "failed on row 3",
+		},
+		{
+			name:    "insert failing on a row",
+			query:   "INSERT INTO dataset1.balances (id, name, amount) SELECT id + 10, name, IF(id = 3, ERROR('failed on row 3'), amount) FROM dataset1.balances",
+			message: "failed on row 3",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Run("jobs.insert", func(t *testing.T) {
+				err := runQuery(test.query)
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if !strings.Contains(err.Error(), test.message) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if diff := cmp.Diff(original, readBalances(t), ratComparer); diff != "" {
+					t.Errorf("(-want +got):\n%s", diff)
+				}
+			})
+			t.Run("jobs.query", func(t *testing.T) {
+				_, err := client.Query(test.query).Read(ctx)
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if !strings.Contains(err.Error(), test.message) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if diff := cmp.Diff(original, readBalances(t), ratComparer); diff != "" {
+					t.Errorf("(-want +got):\n%s", diff)
+				}
+			})
+		})
+	}
+
+	t.Run("merge matching one source row", func(t *testing.T) {
+		if err := runQuery(`MERGE dataset1.balances AS t
+USING (SELECT id, SUM(amount) AS amount FROM dataset1.changes GROUP BY id) AS s ON t.id = s.id
+WHEN MATCHED THEN UPDATE SET amount = t.amount + s.amount
+WHEN NOT MATCHED THEN INSERT (id, name, amount) VALUES (s.id, 'd', s.amount)`); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([][]bigquery.Value{
+			{int64(1), "a", big.NewRat(13, 1)},
+			original[1],
+			original[2],
+			{int64(4), "d", big.NewRat(40, 1)},
+		}, readBalances(t), ratComparer); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("script keeps the statements before the failed one", func(t *testing.T) {
+		before := readBalances(t)
+		job, err := client.Query(`INSERT INTO dataset1.balances (id, name, amount) VALUES (5, 'e', 50);
+UPDATE dataset1.balances SET amount = amount * 10 WHERE true`).Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := status.Err(); err == nil || !strings.Contains(err.Error(), "numeric overflow") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(
+			append(before, []bigquery.Value{int64(5), "e", big.NewRat(50, 1)}),
+			readBalances(t),
+			ratComparer,
+		); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if _, err := client.JobFromID(ctx, fmt.Sprintf("script_job_%s_0", job.ID())); err != nil {
+			t.Errorf("failed to get the child job of the committed statement: %v", err)
+		}
+	})
+}
+
+func TestTimestampStringConversions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+	}{
+		{
+			name: "iso8601 with offsets",
+			query: `SELECT
+  CAST('2023-01-01T12:00:00.123456+09:00' AS TIMESTAMP),
+  TIMESTAMP('2023-01-01T12:00:00Z'),
+  TIMESTAMP('2023-01-01 12:00:00.5-8:00'),
+  TIMESTAMP('2023-01-01 12:00:00-03:30'),
+  CAST('2023-01-01T12:00:00.123+05:30' AS TIMESTAMP)`,
+			expected: []bigquery.Value{
+				time.Date(2023, 1, 1, 3, 0, 0, 123456000, time.UTC),
+				time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 20, 0, 0, 500000000, time.UTC),
+				time.Date(2023, 1, 1, 15, 30, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 6, 30, 0, 123000000, time.UTC),
+			},
+		},
+		{
+			name: "without offset",
+			query: `SELECT
+  CAST('2023-01-01' AS TIMESTAMP),
+  CAST('2023-1-2 3:04:05' AS TIMESTAMP),
+  TIMESTAMP('2023-01-01T12:00:00.000001'),
+  TIMESTAMP(DATE '2023-01-01'),
+  CAST(DATETIME '2023-01-01 12:00:00' AS TIMESTAMP)`,
+			expected: []bigquery.Value{
+				time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+				time.Date(2023, 1, 1, 12, 0, 0, 1000, time.UTC),
+				time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "time zone names",
+			query: `SELECT
+  TIMESTAMP('2023-01-01 12:00:00 America/Los_Angeles'),
+  TIMESTAMP('2023-01-01 12:00:00', 'Asia/Tokyo'),
+  TIMESTAMP('2023-01-01 12:00:00 UTC')`,
+			expected: []bigquery.Value{
+				time.Date(2023, 1, 1, 20, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "parse timestamp with time zone",
+			query: `SELECT
+  PARSE_TIMESTAMP('%Y-%m-%dT%H:%M:%E*S%Ez', '2023-01-01T12:00:00.123456+09:00'),
+  PARSE_TIMESTAMP('%Y-%m-%d %H:%M:%S%z', '2023-01-01 12:00:00-0800'),
+  PARSE_TIMESTAMP('%Y-%m-%d %H:%M:%S %Z', '2023-01-01 12:00:00 Asia/Tokyo'),
+  PARSE_TIMESTAMP('%Y-%m-%d %H:%M:%S', '2023-01-01 12:00:00', 'Asia/Tokyo')`,
+			expected: []bigquery.Value{
+				time.Date(2023, 1, 1, 3, 0, 0, 123456000, time.UTC),
+				time.Date(2023, 1, 1, 20, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 3, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "columns",
+			query: `WITH t AS (SELECT '2023-01-01T12:00:00-8:00' AS s, DATE '2023-01-02' AS d, '2023-1-2 3:04:05' AS l)
+SELECT CAST(s AS TIMESTAMP), CAST(d AS TIMESTAMP), TIMESTAMP(l, 'Asia/Tokyo') FROM t`,
+			expected: []bigquery.Value{
+				time.Date(2023, 1, 1, 20, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+				time.Date(2023, 1, 1, 18, 4, 5, 0, time.UTC),
+			},
+		},
+		{
+			name: "invalid strings with safe cast",
+			query: `SELECT
+  SAFE_CAST('2023-01-01 12:00:00.1234567' AS TIMESTAMP),
+  SAFE_CAST('2023-02-30' AS TIMESTAMP),
+  SAFE.TIMESTAMP('2023-01-01 25:00:00'),
+  SAFE_CAST(CAST(NULL AS STRING) AS TIMESTAMP)`,
+			expected: []bigquery.Value{nil, nil, nil, nil},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{query: "SELECT CAST('2023-01-01 12:00:00.1234567' AS TIMESTAMP)", expected: "Invalid timestamp: '2023-01-01 12:00:00.1234567'"},
+		{query: "SELECT TIMESTAMP('2023-01-01 12:00:00+15:00')", expected: "Invalid timestamp: '2023-01-01 12:00:00+15:00'"},
+		{query: "SELECT PARSE_TIMESTAMP('%Y-%m-%d %H:%M:%S%Ez', '2023-01-01 12:00:00')", expected: `Failed to parse input string "2023-01-01 12:00:00"`},
+	} {
+		_, err := client.Query(test.query).Read(ctx)
+		if err == nil {
+			t.Errorf("expected error for %s", test.query)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("unexpected error for %s: %v", test.query, err)
+		}
+	}
+}
+
+func TestConnectionProperties(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(
+		t,
+		server.StructSource(
+			types.NewProject(
+				"test",
+				types.NewDataset(
+					"dataset1",
+					types.NewTable(
+						"items",
+						[]*types.Column{types.NewColumn("name", types.STRING)},
+						types.Data{{"name": "test item"}},
+					),
+				),
+			),
+			types.NewProject(
+				"other",
+				types.NewDataset(
+					"dataset1",
+					types.NewTable(
+						"items",
+						[]*types.Column{types.NewColumn("name", types.STRING)},
+						types.Data{{"name": "other item"}},
+					),
+				),
+			),
+		),
+	)
+	client := newTestClient(t, startTestServer(t, bqServer), "test")
+
+	readRow := func(t *testing.T, query string, props ...*bigquery.ConnectionProperty) []bigquery.Value {
+		t.Helper()
+		q := client.Query(query)
+		q.ConnectionProperties = props
+		it, err := q.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		return row
+	}
+	tokyo := &bigquery.ConnectionProperty{Key: "time_zone", Value: "Asia/Tokyo"}
+
+	t.Run("time_zone", func(t *testing.T) {
+		row := readRow(t, "SELECT DATETIME_DIFF(CURRENT_DATETIME(), CURRENT_DATETIME('UTC'), HOUR), DATETIME_DIFF(CURRENT_DATETIME(), CURRENT_DATETIME('Asia/Tokyo'), HOUR)", tokyo)
+		if diff := cmp.Diff([]bigquery.Value{int64(9), int64(0)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("time_zone of script", func(t *testing.T) {
+		row := readRow(t, "DECLARE x INT64; SELECT @@time_zone, DATETIME_DIFF(CURRENT_DATETIME(), CURRENT_DATETIME('UTC'), HOUR)", tokyo)
+		if diff := cmp.Diff([]bigquery.Value{"Asia/Tokyo", int64(9)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		row = readRow(t, "SET @@time_zone = 'UTC'; SELECT @@time_zone, DATETIME_DIFF(CURRENT_DATETIME(), CURRENT_DATETIME('UTC'), HOUR)", tokyo)
+		if diff := cmp.Diff([]bigquery.Value{"UTC", int64(0)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("dataset_project_id", func(t *testing.T) {
+		row := readRow(t, "SELECT name FROM dataset1.items", &bigquery.ConnectionProperty{Key: "dataset_project_id", Value: "other"})
+		if diff := cmp.Diff([]bigquery.Value{"other item"}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		row = readRow(t, "SELECT name FROM dataset1.items")
+		if diff := cmp.Diff([]bigquery.Value{"test item"}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("query job", func(t *testing.T) {
+		q := client.Query("SELECT name FROM dataset1.items")
+		q.ConnectionProperties = []*bigquery.ConnectionProperty{
+			{Key: "dataset_project_id", Value: "other"},
+			{Key: "service_account", Value: "runner@test.iam.gserviceaccount.com"},
+		}
+		job, err := q.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := job.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]bigquery.Value{"other item"}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if job.ProjectID() != "test" {
+			t.Errorf("unexpected project of the job: %s", job.ProjectID())
+		}
+		config, err := job.Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(q.ConnectionProperties, config.(*bigquery.QueryConfig).ConnectionProperties); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	for _, test := range []struct {
+		name     string
+		prop     *bigquery.ConnectionProperty
+		expected string
+	}{
+		{name: "invalid time zone", prop: &bigquery.ConnectionProperty{Key: "time_zone", Value: "Mars/Olympus_Mons"}, expected: "Invalid time zone: Mars/Olympus_Mons"},
+		{name: "unknown project", prop: &bigquery.ConnectionProperty{Key: "dataset_project_id", Value: "missing"}, expected: "Not found: Project missing"},
+		{name: "unknown key", prop: &bigquery.ConnectionProperty{Key: "unknown", Value: "x"}, expected: "Invalid connection property: unknown"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			q := client.Query("SELECT 1")
+			q.ConnectionProperties = []*bigquery.ConnectionProperty{test.prop}
+			_, err := q.Read(ctx)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestQueryHints(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name:     "statement hint",
+			query:    "@{optimizer_version=1} SELECT id FROM dataset1.table_a ORDER BY id",
+			expected: [][]bigquery.Value{{int64(1)}, {int64(2)}},
+		},
+		{
+			name: "join and table hints",
+			query: `SELECT a.id, b.name
+FROM dataset1.table_a @{force_index=_base_table} AS a
+JOIN @{join_method=HASH_JOIN, join_type=hash_join} dataset1.table_a AS b ON a.id = b.id
+ORDER BY a.id`,
+			expected: [][]bigquery.Value{{int64(1), "alice"}, {int64(2), "bob"}},
+		},
+		{
+			name: "hints of subquery and unknown keys",
+			query: `SELECT COUNT(*) FROM (
+  SELECT @{unknown_hint='value'} id FROM dataset1.table_a @{another.hint=TRUE} WHERE name != '@{not a hint}'
+) AS t @{x=1} @{y=2}`,
+			expected: [][]bigquery.Value{{int64(2)}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNullsOrdering(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		order    string
+		expected string
+	}{
+		{name: "default ascending", order: "x", expected: "null,1,2"},
+		{name: "ascending", order: "x ASC", expected: "null,1,2"},
+		{name: "ascending nulls first", order: "x ASC NULLS FIRST", expected: "null,1,2"},
+		{name: "ascending nulls last", order: "x ASC NULLS LAST", expected: "1,2,null"},
+		{name: "nulls last without direction", order: "x NULLS LAST", expected: "1,2,null"},
+		{name: "descending", order: "x DESC", expected: "2,1,null"},
+		{name: "descending nulls first", order: "x DESC NULLS FIRST", expected: "null,2,1"},
+		{name: "descending nulls last", order: "x DESC NULLS LAST", expected: "2,1,null"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, query := range []string{
+				fmt.Sprintf(
+					"SELECT ARRAY_TO_STRING(ARRAY(SELECT IFNULL(CAST(x AS STRING), 'null') FROM UNNEST([2, NULL, 1]) AS x ORDER BY %s), ',')",
+					test.order,
+				),
+				fmt.Sprintf(
+					"SELECT STRING_AGG(IFNULL(CAST(x AS STRING), 'null'), ',' ORDER BY %s) FROM UNNEST([2, NULL, 1]) AS x",
+					test.order,
+				),
+				fmt.Sprintf(
+					"SELECT STRING_AGG(v, ',' ORDER BY n) FROM (SELECT IFNULL(CAST(x AS STRING), 'null') AS v, ROW_NUMBER() OVER (ORDER BY %s) AS n FROM UNNEST([2, NULL, 1]) AS x)",
+					test.order,
+				),
+				fmt.Sprintf(
+					"SELECT v FROM (SELECT STRING_AGG(IFNULL(CAST(x AS STRING), 'null'), ',') OVER (ORDER BY %s ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW) AS v, ROW_NUMBER() OVER (ORDER BY %[1]s) AS n FROM UNNEST([2, NULL, 1]) AS x) ORDER BY n DESC LIMIT 1",
+					test.order,
+				),
+				fmt.Sprintf(
+					"SELECT STRING_AGG(v, ',' ORDER BY n) FROM (SELECT IFNULL(CAST(x AS STRING), 'null') AS v, ROW_NUMBER() OVER w AS n FROM UNNEST([2, NULL, 1]) AS x WINDOW w AS (ORDER BY %s))",
+					test.order,
+				),
+			} {
+				it, err := client.Query(query).Read(ctx)
+				if err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					t.Fatalf("%s: %v", query, err)
+				}
+				if diff := cmp.Diff([]bigquery.Value{test.expected}, row); diff != "" {
+					t.Errorf("%s: (-want +got):\n%s", query, diff)
+				}
+			}
+		})
+	}
+	t.Run("collate", func(t *testing.T) {
+		query := `SELECT
+  STRING_AGG(IFNULL(s, 'null'), ',' ORDER BY s COLLATE 'und:ci' ASC NULLS LAST),
+  STRING_AGG(IFNULL(s, 'null'), ',' ORDER BY s COLLATE 'und:ci' DESC NULLS FIRST)
+FROM UNNEST(['b', NULL, 'A']) AS s`
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]bigquery.Value{"A,b,null", "null,b,A"}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestSchemaUpdateOptions(t *testing.T) {
+	const (
+		projectName = "test"
+		datasetName = "dataset1"
+		tableName   = "table_a"
+	)
+
+	ctx := context.Background()
+
+	project := types.NewProject(projectName, types.NewDataset(datasetName))
+	bqServer := newTestServer(t, server.StructSource(project))
+
+	client := newTestClient(t, startTestServer(t, bqServer), projectName)
+
+	table := client.Dataset(datasetName).Table(tableName)
+	load := func(data string, schema bigquery.Schema, options ...string) error {
+		source := bigquery.NewReaderSource(strings.NewReader(data))
+		source.SourceFormat = bigquery.JSON
+		source.Schema = schema
+		loader := table.LoaderFrom(source)
+		loader.WriteDisposition = bigquery.WriteAppend
+		loader.SchemaUpdateOptions = options
+		job, err := loader.Run(ctx)
+		if err != nil {
+			return err
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	}
+	assertSchema := func(t *testing.T, expected bigquery.Schema) {
+		t.Helper()
+		md, err := table.Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(expected, md.Schema); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	}
+
+	if err := load(`{"id": 1, "name": "alice"}`, bigquery.Schema{
+		{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+		{Name: "name", Type: bigquery.StringFieldType},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("new field without options", func(t *testing.T) {
+		if err := load(`{"id": 2, "name": "bob", "age": 20}`, bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "age", Type: bigquery.IntegerFieldType},
+		}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("add field", func(t *testing.T) {
+		if err := load(`{"id": 2, "name": "bob", "age": 20}`, bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "age", Type: bigquery.IntegerFieldType},
+		}, "ALLOW_FIELD_ADDITION"); err != nil {
+			t.Fatal(err)
+		}
+		assertSchema(t, bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "age", Type: bigquery.IntegerFieldType},
+		})
+	})
+	t.Run("type change", func(t *testing.T) {
+		if err := load(`{"id": 3, "name": "carol", "age": "30"}`, bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "age", Type: bigquery.StringFieldType},
+		}, "ALLOW_FIELD_ADDITION", "ALLOW_FIELD_RELAXATION"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("relax field", func(t *testing.T) {
+		if err := load(`{"id": null, "name": "dave", "age": 40}`, bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType},
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "age", Type: bigquery.IntegerFieldType},
+		}, "ALLOW_FIELD_RELAXATION"); err != nil {
+			t.Fatal(err)
+		}
+		assertSchema(t, bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType},
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "age", Type: bigquery.IntegerFieldType},
+		})
+	})
+	t.Run("query with new field", func(t *testing.T) {
+		query := client.Query("SELECT 5 AS id, 'eve' AS name, TRUE AS active")
+		query.Dst = table
+		query.WriteDisposition = bigquery.WriteAppend
+		query.SchemaUpdateOptions = []string{"ALLOW_FIELD_ADDITION"}
+		job, err := query.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := status.Err(); err != nil {
+			t.Fatal(err)
+		}
+		assertSchema(t, bigquery.Schema{
+			{Name: "id", Type: bigquery.IntegerFieldType},
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "age", Type: bigquery.IntegerFieldType},
+			{Name: "active", Type: bigquery.BooleanFieldType},
+		})
+	})
+	t.Run("options with WRITE_TRUNCATE", func(t *testing.T) {
+		query := client.Query("SELECT 6 AS id")
+		query.Dst = table
+		query.WriteDisposition = bigquery.WriteTruncate
+		query.SchemaUpdateOptions = []string{"ALLOW_FIELD_RELAXATION"}
+		job, err := query.Run(ctx)
+		if err == nil {
+			status, waitErr := job.Wait(ctx)
+			if waitErr == nil {
+				err = status.Err()
+			} else {
+				err = waitErr
+			}
+		}
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	it, err := client.Query(fmt.Sprintf("SELECT id, name, age, active FROM %s.%s ORDER BY name", datasetName, tableName)).Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]bigquery.Value
+	for {
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			if err == iterator.Done {
+				break
+			}
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if diff := cmp.Diff([][]bigquery.Value{
+		{int64(1), "alice", nil, nil},
+		{int64(2), "bob", int64(20), nil},
+		{nil, "dave", int64(40), nil},
+		{int64(5), "eve", nil, true},
+	}, rows); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestScriptChildJobs(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	job, err := client.Query(`
+DECLARE first_job_id STRING;
+SET first_job_id = @@last_job_id;
+SET @@query_label = 'team:data,env:test';
+SELECT id FROM dataset1.table_a;
+SELECT first_job_id IS NULL, @@last_job_id`).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := job.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row []bigquery.Value
+	if err := it.Next(&row); err != nil {
+		t.Fatal(err)
+	}
+	if len(row) != 2 || row[0] != true {
+		t.Fatalf("expected @@last_job_id to be NULL before the first statement but got %v", row)
+	}
+	lastJobID, ok := row[1].(string)
+	if !ok || lastJobID == "" {
+		t.Fatalf("expected @@last_job_id of the previous statement but got %v", row[1])
+	}
+
+	children := job.Children(ctx)
+	var found bool
+	for {
+		child, err := children.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := child.LastStatus(); status.Statistics == nil || status.Statistics.ParentJobID != job.ID() {
+			t.Errorf("expected the parent job %s of %s", job.ID(), child.ID())
+		}
+		if child.ID() != lastJobID {
+			continue
+		}
+		found = true
+		config, err := child.Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		queryConfig, ok := config.(*bigquery.QueryConfig)
+		if !ok {
+			t.Fatalf("expected the query job but got %T", config)
+		}
+		if diff := cmp.Diff("SELECT id FROM dataset1.table_a", strings.TrimSpace(queryConfig.Q)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff(map[string]string{"team": "data", "env": "test"}, queryConfig.Labels); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	}
+	if !found {
+		t.Fatalf("child job %s is not found", lastJobID)
+	}
+
+	t.Run("invalid query label", func(t *testing.T) {
+		_, err := client.Query("SET @@query_label = 'Team Name:data'; SELECT 1").Read(ctx)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "Invalid query label") {
+			t.Errorf("expected invalid query label error but got %v", err)
+		}
+	})
+}
+
+func TestArrayLimits(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name          string
+		query         string
+		expectedError string
+		expectedRow   []bigquery.Value
+	}{
+		{
+			name:          "generate array of billions of elements",
+			query:         "SELECT ARRAY_LENGTH(GENERATE_ARRAY(1, 10000000000))",
+			expectedError: "Cannot generate arrays with more than 16000000 elements",
+		},
+		{
+			name:          "descending generate array",
+			query:         "SELECT ARRAY_LENGTH(GENERATE_ARRAY(10000000000, 1, -1))",
+			expectedError: "Cannot generate arrays with more than 16000000 elements",
+		},
+		{
+			name:        "generate array with large step",
+			query:       "SELECT ARRAY_LENGTH(GENERATE_ARRAY(0, 10000000000, 1000000000))",
+			expectedRow: []bigquery.Value{int64(11)},
+		},
+		{
+			name:          "generate timestamp array",
+			query:         "SELECT ARRAY_LENGTH(GENERATE_TIMESTAMP_ARRAY('2000-01-01', '2020-01-01', INTERVAL 1 SECOND))",
+			expectedError: "Cannot generate arrays with more than 16000000 elements",
+		},
+		{
+			name:          "nested array",
+			query:         "SELECT ARRAY_LENGTH(ARRAY_CONCAT([1, 2], GENERATE_ARRAY(1, 5000000000)))",
+			expectedError: "Cannot generate arrays with more than 16000000 elements",
+		},
+		{
+			name:        "safe generate array",
+			query:       "SELECT SAFE.GENERATE_ARRAY(1, 10000000000) IS NULL",
+			expectedRow: []bigquery.Value{true},
+		},
+		{
+			name:        "small arrays",
+			query:       "SELECT ARRAY_LENGTH(ARRAY_CONCAT(GENERATE_ARRAY(1, 3), [4])), (SELECT ARRAY_AGG(x ORDER BY x DESC) FROM UNNEST([1, 2]) AS x)",
+			expectedRow: []bigquery.Value{int64(4), []bigquery.Value{int64(2), int64(1)}},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if test.expectedError != "" {
+				if err == nil {
+					var row []bigquery.Value
+					err = it.Next(&row)
+				}
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if !strings.Contains(err.Error(), test.expectedError) {
+					t.Fatalf("expected %q but got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expectedRow, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTemporalFormat(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	testServer := startTestServer(t, bqServer)
+	client := newTestClient(t, testServer, "test")
+
+	request := func(t *testing.T, method, path string, body interface{}, v interface{}) {
+		t.Helper()
+		var reader io.Reader
+		if body != nil {
+			b, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader = bytes.NewReader(b)
+		}
+		req, err := http.NewRequest(method, testServer.URL+path, reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", res.StatusCode, string(b))
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cellValues := func(row *bigqueryv2.TableRow) []interface{} {
+		values := make([]interface{}, 0, len(row.F))
+		for _, cell := range row.F {
+			values = append(values, cell.V)
+		}
+		return values
+	}
+
+	t.Run("query result", func(t *testing.T) {
+		var res bigqueryv2.QueryResponse
+		request(t, "POST", "/projects/test/queries", &bigqueryv2.QueryRequest{
+			Query: `
+SELECT
+  DATE '2024-01-02',
+  TIME '00:00:00',
+  TIME '03:04:05.5',
+  DATETIME '2024-01-02 00:00:00',
+  DATETIME '2024-01-02 03:04:05.123456',
+  TIMESTAMP '2024-01-02 03:04:05.000001+09:00',
+  [DATETIME '2024-01-02 03:04:05.5', NULL],
+  STRUCT(TIME '01:02:03.25' AS t, DATETIME '2024-01-02 03:04:05' AS dt)`,
+		}, &res)
+		if len(res.Rows) != 1 {
+			t.Fatalf("expected 1 row but got %d", len(res.Rows))
+		}
+		expected := []interface{}{
+			"2024-01-02",
+			"00:00:00",
+			"03:04:05.500000",
+			"2024-01-02T00:00:00",
+			"2024-01-02T03:04:05.123456",
+			"1704132245.000001",
+			[]interface{}{map[string]interface{}{"v": "2024-01-02T03:04:05.500000"}, map[string]interface{}{"v": nil}},
+			map[string]interface{}{"f": []interface{}{
+				map[string]interface{}{"v": "01:02:03.250000"},
+				map[string]interface{}{"v": "2024-01-02T03:04:05"},
+			}},
+		}
+		if diff := cmp.Diff(expected, cellValues(res.Rows[0])); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("insertAll and tabledata.list", func(t *testing.T) {
+		if _, err := client.Query("CREATE TABLE dataset1.temporals (d DATE, t TIME, dt DATETIME, ts TIMESTAMP, dts ARRAY<DATETIME>)").Read(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var insertRes bigqueryv2.TableDataInsertAllResponse
+		request(t, "POST", "/projects/test/datasets/dataset1/tables/temporals/insertAll", &bigqueryv2.TableDataInsertAllRequest{
+			Rows: []*bigqueryv2.TableDataInsertAllRequestRows{
+				{Json: map[string]bigqueryv2.JsonValue{
+					"d":   "2024-01-02",
+					"t":   "03:04:05",
+					"dt":  "2024-01-02 03:04:05.5",
+					"ts":  "2024-01-02 03:04:05.25",
+					"dts": []interface{}{"2024-01-02T00:00:00", "2024-01-02T00:00:00.000001"},
+				}},
+			},
+		}, &insertRes)
+		if len(insertRes.InsertErrors) != 0 {
+			t.Fatalf("unexpected insert errors: %+v", insertRes.InsertErrors)
+		}
+
+		var list bigqueryv2.TableDataList
+		request(t, "GET", "/projects/test/datasets/dataset1/tables/temporals/data", nil, &list)
+		if len(list.Rows) != 1 {
+			t.Fatalf("expected 1 row but got %d", len(list.Rows))
+		}
+		expected := []interface{}{
+			"2024-01-02",
+			"03:04:05",
+			"2024-01-02T03:04:05.500000",
+			"1704164645.250000",
+			[]interface{}{
+				map[string]interface{}{"v": "2024-01-02T00:00:00"},
+				map[string]interface{}{"v": "2024-01-02T00:00:00.000001"},
+			},
+		}
+		if diff := cmp.Diff(expected, cellValues(list.Rows[0])); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+
+		it, err := client.Query("SELECT dt, t FROM dataset1.temporals").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		expectedRow := []bigquery.Value{
+			civil.DateTime{Date: civil.Date{Year: 2024, Month: 1, Day: 2}, Time: civil.Time{Hour: 3, Minute: 4, Second: 5, Nanosecond: 500000000}},
+			civil.Time{Hour: 3, Minute: 4, Second: 5},
+		}
+		if diff := cmp.Diff(expectedRow, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestScriptMultipleAssignment(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	readRow := func(t *testing.T, query string) []bigquery.Value {
+		t.Helper()
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		return row
+	}
+
+	t.Run("defaulted declarations", func(t *testing.T) {
+		row := readRow(t, `
+DECLARE x INT64 DEFAULT 10;
+DECLARE y, z INT64 DEFAULT x * 2;
+DECLARE name DEFAULT (SELECT MAX(name) FROM dataset1.table_a);
+SET x = 1;
+SELECT x, y, z, name`)
+		if diff := cmp.Diff([]bigquery.Value{int64(1), int64(20), int64(20), "bob"}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("subquery", func(t *testing.T) {
+		row := readRow(t, `
+DECLARE a INT64;
+DECLARE b STRING;
+SET (a, b) = (SELECT id, name FROM dataset1.table_a WHERE id = 1);
+SELECT a, b`)
+		if diff := cmp.Diff([]bigquery.Value{int64(1), "alice"}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("tuple and struct", func(t *testing.T) {
+		row := readRow(t, `
+DECLARE a, b INT64;
+DECLARE c STRING;
+SET (a, b) = (1 + 2, 4);
+SET (b, c) = STRUCT(a * 10 AS n, 'x' AS s);
+SELECT a, b, c`)
+		if diff := cmp.Diff([]bigquery.Value{int64(3), int64(30), "x"}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("zero rows", func(t *testing.T) {
+		row := readRow(t, `
+DECLARE a INT64 DEFAULT 1;
+DECLARE b STRING DEFAULT 'x';
+SET (a, b) = (SELECT id, name FROM dataset1.table_a WHERE id < 0);
+SELECT a IS NULL, b IS NULL`)
+		if diff := cmp.Diff([]bigquery.Value{true, true}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+
+	for _, test := range []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{
+			name:  "multiple rows",
+			query: "DECLARE a INT64; DECLARE b STRING; SET (a, b) = (SELECT id, name FROM dataset1.table_a); SELECT a",
+			err:   "Scalar subquery produced more than one element",
+		},
+		{
+			name:  "forward reference",
+			query: "DECLARE a INT64 DEFAULT b; DECLARE b INT64 DEFAULT 1; SELECT a",
+			err:   "b",
+		},
+		{
+			name:  "number of values",
+			query: "DECLARE a, b INT64; SET (a, b) = (SELECT 1, 2, 3); SELECT a",
+			err:   "requires 2 values but got 3",
+		},
+		{
+			name:  "undeclared variable",
+			query: "DECLARE a INT64; SET (a, b) = (1, 2); SELECT a",
+			err:   "Unrecognized name: b",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := client.Query(test.query).Read(ctx)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error to contain %q but got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestConnectionsAndExternalQuery(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	testServer := startTestServer(t, bqServer)
+	client := newTestClient(t, testServer, "test")
+
+	request := func(t *testing.T, method, path string, body interface{}) (int, map[string]interface{}) {
+		t.Helper()
+		var reader io.Reader
+		if body != nil {
+			b, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader = bytes.NewReader(b)
+		}
+		req, err := http.NewRequest(method, testServer.URL+path, reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var v map[string]interface{}
+		if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, v
+	}
+
+	const connectionsPath = "/v1/projects/test/locations/US/connections"
+	status, created := request(t, "POST", connectionsPath+"?connectionId=my_conn", map[string]interface{}{
+		"friendlyName": "postgres",
+		"cloudSql": map[string]interface{}{
+			"instanceId": "test:us-central1:instance",
+			"database":   "db",
+			"type":       "POSTGRES",
+			"credential": map[string]interface{}{"username": "user", "password": "secret"},
+		},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status %d: %v", status, created)
+	}
+	if created["name"] != "projects/test/locations/us/connections/my_conn" || created["hasCredential"] != true {
+		t.Fatalf("unexpected connection %v", created)
+	}
+	if cloudSQL, _ := created["cloudSql"].(map[string]interface{}); cloudSQL == nil || cloudSQL["credential"] != nil || cloudSQL["database"] != "db" {
+		t.Fatalf("unexpected cloudSql %v", created["cloudSql"])
+	}
+
+	t.Run("duplicate", func(t *testing.T) {
+		status, res := request(t, "POST", connectionsPath+"?connectionId=my_conn", map[string]interface{}{})
+		if status != http.StatusConflict {
+			t.Fatalf("expected conflict but got %d: %v", status, res)
+		}
+	})
+
+	t.Run("get, list and patch", func(t *testing.T) {
+		status, got := request(t, "GET", connectionsPath+"/my_conn", nil)
+		if status != http.StatusOK || got["friendlyName"] != "postgres" {
+			t.Fatalf("unexpected connection %d: %v", status, got)
+		}
+		status, patched := request(t, "PATCH", connectionsPath+"/my_conn?updateMask=friendlyName", map[string]interface{}{
+			"friendlyName": "renamed",
+			"description":  "ignored",
+		})
+		if status != http.StatusOK || patched["friendlyName"] != "renamed" || patched["description"] != nil {
+			t.Fatalf("unexpected connection %d: %v", status, patched)
+		}
+		status, list := request(t, "GET", connectionsPath, nil)
+		if status != http.StatusOK {
+			t.Fatalf("unexpected status %d: %v", status, list)
+		}
+		connections, _ := list["connections"].([]interface{})
+		if len(connections) != 1 {
+			t.Fatalf("expected 1 connection but got %v", list)
+		}
+	})
+
+	for _, test := range []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{
+			name:  "unconfigured source",
+			query: "SELECT * FROM EXTERNAL_QUERY('us.my_conn', 'SELECT id FROM users')",
+			err:   "EXTERNAL_QUERY to the connection test.us.my_conn is not supported by the emulator",
+		},
+		{
+			name:  "script",
+			query: "DECLARE x INT64 DEFAULT 1; SELECT * FROM EXTERNAL_QUERY('test.us.my_conn', 'SELECT 1')",
+			err:   "is not supported by the emulator",
+		},
+		{
+			name:  "connection not found",
+			query: "SELECT * FROM EXTERNAL_QUERY('us.unknown', 'SELECT 1')",
+			err:   "Not found: Connection test.us.unknown",
+		},
+		{
+			name:  "malformed connection id",
+			query: "SELECT * FROM EXTERNAL_QUERY('my_conn', 'SELECT 1')",
+			err:   "Invalid connection id",
+		},
+		{
+			name:  "unbalanced external sql",
+			query: "SELECT * FROM EXTERNAL_QUERY('us.my_conn', 'SELECT (1')",
+			err:   "the parentheses are not balanced",
+		},
+		{
+			name:  "not select",
+			query: "SELECT * FROM EXTERNAL_QUERY('us.my_conn', 'DELETE FROM users')",
+			err:   "only SELECT statements are supported",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := client.Query(test.query).Read(ctx)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error to contain %q but got %v", test.err, err)
+			}
+		})
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		if status, res := request(t, "DELETE", connectionsPath+"/my_conn", nil); status != http.StatusOK {
+			t.Fatalf("unexpected status %d: %v", status, res)
+		}
+		if status, res := request(t, "GET", connectionsPath+"/my_conn", nil); status != http.StatusNotFound {
+			t.Fatalf("expected not found but got %d: %v", status, res)
+		}
+	})
+}
+
+func TestGenerativeAIStub(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	client := newTestClient(t, startTestServer(t, bqServer), "test")
+
+	readRows := func(t *testing.T, query string) [][]bigquery.Value {
+		t.Helper()
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	if _, err := client.Query("CREATE MODEL dataset1.gemini OPTIONS(model_type = 'kmeans') AS SELECT id FROM dataset1.table_a").Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	const generateText = `
+SELECT id, ml_generate_text_llm_result, ml_generate_text_status
+FROM ML.GENERATE_TEXT(
+  MODEL dataset1.gemini,
+  (SELECT id, IF(id = 1, NULL, CONCAT('hello ', name)) AS prompt FROM dataset1.table_a WHERE id <= 2),
+  STRUCT(0.2 AS temperature, 64 AS max_output_tokens, TRUE AS flatten_json_output)
+)
+ORDER BY id`
+
+	t.Run("disabled", func(t *testing.T) {
+		_, err := client.Query(generateText).Read(ctx)
+		if err == nil {
+			t.Fatal("expected error without the canned response")
+		}
+		if !strings.Contains(err.Error(), "ML.GENERATE_TEXT is not supported") || !strings.Contains(err.Error(), "--generative-ai-response") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	bqServer.SetGenerativeAIResponse("echo: {prompt}")
+	defer bqServer.SetGenerativeAIResponse("")
+
+	t.Run("ML.GENERATE_TEXT flattened", func(t *testing.T) {
+		if diff := cmp.Diff([][]bigquery.Value{
+			{int64(1), nil, "The prompt is NULL"},
+			{int64(2), "echo: hello bob", ""},
+		}, readRows(t, generateText)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("ML.GENERATE_TEXT json", func(t *testing.T) {
+		rows := readRows(t, `
+SELECT JSON_VALUE(ml_generate_text_result, '$.candidates[0].content.parts[0].text')
+FROM ML.GENERATE_TEXT(MODEL dataset1.gemini, (SELECT 'hi' AS prompt))`)
+		if diff := cmp.Diff([][]bigquery.Value{{"echo: hi"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("AI.GENERATE", func(t *testing.T) {
+		rows := readRows(t, "SELECT AI.GENERATE(('Describe ', name), connection_id => 'us.gemini').result FROM dataset1.table_a WHERE id = 2")
+		if diff := cmp.Diff([][]bigquery.Value{{"echo: Describe bob"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	for _, test := range []struct {
+		name  string
+		query string
+	}{
+		{
+			name:  "temperature out of range",
+			query: "SELECT * FROM ML.GENERATE_TEXT(MODEL dataset1.gemini, (SELECT 'hi' AS prompt), STRUCT(3 AS temperature))",
+		},
+		{
+			name:  "unknown option",
+			query: "SELECT * FROM ML.GENERATE_TEXT(MODEL dataset1.gemini, (SELECT 'hi' AS prompt), STRUCT(1 AS unknown))",
+		},
+		{
+			name:  "unknown model",
+			query: "SELECT * FROM ML.GENERATE_TEXT(MODEL dataset1.unknown, (SELECT 'hi' AS prompt))",
+		},
+		{
+			name:  "unknown argument",
+			query: "SELECT AI.GENERATE('hi', unknown => 1)",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := client.Query(test.query).Read(ctx); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestWithClauseDML(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	exec := func(t *testing.T, query string, params ...bigquery.QueryParameter) {
+		t.Helper()
+		q := client.Query(query)
+		q.Parameters = params
+		job, err := q.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := status.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the table of the same name as the tables materializing the common table expressions is kept.
+	exec(t, "CREATE TABLE dataset1.bqemulator_with_0 AS SELECT 'user table' AS note")
+	exec(t, `
+WITH src AS (SELECT 2 AS id, 'bobby' AS name), ids AS (SELECT id FROM src)
+UPDATE dataset1.table_a t SET name = src.name FROM src WHERE t.id = src.id AND t.id IN (SELECT id FROM ids)`)
+	exec(t, `
+WITH src AS (SELECT 1 AS id, 'ALICE' AS name UNION ALL SELECT 3, 'carol')
+MERGE dataset1.table_a t
+USING src
+ON t.id = src.id
+WHEN MATCHED THEN UPDATE SET name = (SELECT s.name FROM src s WHERE s.id = t.id)
+WHEN NOT MATCHED THEN INSERT (id, name) VALUES (src.id, src.name)`)
+	exec(t, `
+WITH s AS (SELECT MAX(id) + 1 AS id FROM dataset1.table_a)
+INSERT INTO dataset1.table_a (id, name) SELECT id, 'dave' FROM s`)
+	exec(t, `
+WITH src AS (SELECT ? AS id, ? AS name)
+UPDATE dataset1.table_a t SET name = src.name FROM src WHERE t.id = src.id AND t.id < ?`,
+		bigquery.QueryParameter{Value: 4},
+		bigquery.QueryParameter{Value: "DAVE"},
+		bigquery.QueryParameter{Value: 10},
+	)
+
+	it, err := client.Query("SELECT id, name FROM dataset1.table_a ORDER BY id").Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]bigquery.Value
+	for {
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			if err == iterator.Done {
+				break
+			}
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if diff := cmp.Diff([][]bigquery.Value{
+		{int64(1), "ALICE"},
+		{int64(2), "bobby"},
+		{int64(3), "carol"},
+		{int64(4), "DAVE"},
+	}, rows); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+
+	it, err = client.Query("SELECT note FROM dataset1.bqemulator_with_0").Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var note []bigquery.Value
+	if err := it.Next(&note); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]bigquery.Value{"user table"}, note); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+}
+
+func TestQueryResultsPaging(t *testing.T) {
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	testServer := startTestServer(t, bqServer)
+
+	decode := func(t *testing.T, res *http.Response, v interface{}) {
+		t.Helper()
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", res.StatusCode, string(body))
+		}
+		if err := json.Unmarshal(body, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	query := func(t *testing.T, req *bigqueryv2.QueryRequest) *bigqueryv2.QueryResponse {
+		t.Helper()
+		b, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(fmt.Sprintf("%s/projects/test/queries", testServer.URL), "application/json", bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v bigqueryv2.QueryResponse
+		decode(t, res, &v)
+		return &v
+	}
+	getQueryResults := func(t *testing.T, jobID, params string) *bigqueryv2.GetQueryResultsResponse {
+		t.Helper()
+		res, err := http.Get(fmt.Sprintf("%s/projects/test/queries/%s?%s", testServer.URL, jobID, params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v bigqueryv2.GetQueryResultsResponse
+		decode(t, res, &v)
+		return &v
+	}
+	values := func(rows []*bigqueryv2.TableRow) []interface{} {
+		var v []interface{}
+		for _, row := range rows {
+			v = append(v, row.F[0].V)
+		}
+		return v
+	}
+
+	const numbers = "SELECT n FROM UNNEST(GENERATE_ARRAY(1, 5)) AS n ORDER BY n"
+
+	t.Run("pages", func(t *testing.T) {
+		res := query(t, &bigqueryv2.QueryRequest{Query: numbers, MaxResults: 2, TimeoutMs: 1})
+		if !res.JobComplete || res.TotalRows != 5 || res.PageToken == "" {
+			t.Fatalf("unexpected first page: complete=%v totalRows=%d pageToken=%q", res.JobComplete, res.TotalRows, res.PageToken)
+		}
+		got := values(res.Rows)
+		pageToken := res.PageToken
+		for pageToken != "" {
+			page := getQueryResults(t, res.JobReference.JobId, "maxResults=2&pageToken="+pageToken)
+			if !page.JobComplete || page.TotalRows != 5 {
+				t.Fatalf("unexpected page: complete=%v totalRows=%d", page.JobComplete, page.TotalRows)
+			}
+			got = append(got, values(page.Rows)...)
+			pageToken = page.PageToken
+		}
+		if diff := cmp.Diff([]interface{}{"1", "2", "3", "4", "5"}, got); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+
+		all := getQueryResults(t, res.JobReference.JobId, "")
+		if diff := cmp.Diff([]interface{}{"1", "2", "3", "4", "5"}, values(all.Rows)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if all.PageToken != "" {
+			t.Errorf("unexpected page token: %q", all.PageToken)
+		}
+		fromIndex := getQueryResults(t, res.JobReference.JobId, "startIndex=3")
+		if diff := cmp.Diff([]interface{}{"4", "5"}, values(fromIndex.Rows)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("zero maxResults", func(t *testing.T) {
+		res := query(t, &bigqueryv2.QueryRequest{Query: numbers})
+		if len(res.Rows) != 5 || res.PageToken != "" {
+			t.Fatalf("unexpected rows %d and page token %q", len(res.Rows), res.PageToken)
+		}
+		page := getQueryResults(t, res.JobReference.JobId, "maxResults=0")
+		if len(page.Rows) != 0 || page.PageToken != "0" || page.TotalRows != 5 || !page.JobComplete {
+			t.Fatalf("unexpected page: rows=%d pageToken=%q totalRows=%d", len(page.Rows), page.PageToken, page.TotalRows)
+		}
+	})
+	t.Run("smaller than page", func(t *testing.T) {
+		res := query(t, &bigqueryv2.QueryRequest{Query: numbers, MaxResults: 10})
+		if len(res.Rows) != 5 || res.PageToken != "" {
+			t.Fatalf("unexpected rows %d and page token %q", len(res.Rows), res.PageToken)
+		}
+	})
+	t.Run("invalid page token", func(t *testing.T) {
+		res := query(t, &bigqueryv2.QueryRequest{Query: numbers, MaxResults: 2})
+		r, err := http.Get(fmt.Sprintf("%s/projects/test/queries/%s?pageToken=invalid", testServer.URL, res.JobReference.JobId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		if r.StatusCode != http.StatusBadRequest {
+			t.Fatalf("unexpected status %d", r.StatusCode)
+		}
+	})
+}
+
+func TestCompositeQueryParameters(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	readRows := func(t *testing.T, q *bigquery.Query) [][]bigquery.Value {
+		t.Helper()
+		it, err := q.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	type item struct {
+		ID    int64              `bigquery:"id"`
+		Name  string             `bigquery:"name"`
+		Score bigquery.NullInt64 `bigquery:"score"`
+		Tags  []string           `bigquery:"tags"`
+	}
+	type owner struct {
+		Name string `bigquery:"name"`
+	}
+	type filter struct {
+		Owner owner   `bigquery:"owner"`
+		IDs   []int64 `bigquery:"ids"`
+		Items []item  `bigquery:"items"`
+	}
+
+	t.Run("unnest array of struct", func(t *testing.T) {
+		q := client.Query("SELECT i.id, i.name, i.score, ARRAY_LENGTH(i.tags) FROM UNNEST(@items) AS i ORDER BY i.id")
+		q.Parameters = []bigquery.QueryParameter{
+			{Name: "items", Value: []item{
+				{ID: 2, Name: "b", Score: bigquery.NullInt64{Int64: 10, Valid: true}, Tags: []string{}},
+				{ID: 1, Name: "a", Tags: []string{"x", "y"}},
+			}},
+		}
+		if diff := cmp.Diff([][]bigquery.Value{
+			{int64(1), "a", nil, int64(2)},
+			{int64(2), "b", int64(10), int64(0)},
+		}, readRows(t, q)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("nested field access", func(t *testing.T) {
+		q := client.Query(`
+SELECT @filter.owner.name, ARRAY_LENGTH(@filter.ids), (SELECT SUM(i.id) FROM UNNEST(@filter.items) AS i), ARRAY_LENGTH(@empty)
+FROM dataset1.table_a WHERE id IN UNNEST(@filter.ids) AND name = @filter.owner.name`)
+		q.Parameters = []bigquery.QueryParameter{
+			{Name: "filter", Value: filter{
+				Owner: owner{Name: "alice"},
+				IDs:   []int64{1, 3},
+				Items: []item{{ID: 4, Tags: []string{}}, {ID: 5, Tags: []string{}}},
+			}},
+			{Name: "empty", Value: []int64{}},
+		}
+		if diff := cmp.Diff([][]bigquery.Value{
+			{"alice", int64(2), int64(9), int64(0)},
+		}, readRows(t, q)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("positional", func(t *testing.T) {
+		q := client.Query("SELECT ?, (SELECT COUNT(*) FROM UNNEST(?)), ?")
+		q.Parameters = []bigquery.QueryParameter{
+			{Value: int64(1)},
+			{Value: []owner{{Name: "a"}, {Name: "b"}}},
+			{Value: "last"},
+		}
+		if diff := cmp.Diff([][]bigquery.Value{
+			{int64(1), int64(2), "last"},
+		}, readRows(t, q)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestTruncFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		expr     string
+		expected string
+	}{
+		{expr: "DATE_TRUNC(DATE '2008-12-25', MONTH)", expected: "2008-12-01"},
+		{expr: "DATE_TRUNC(DATE '2009-07-01', QUARTER)", expected: "2009-07-01"},
+		{expr: "DATE_TRUNC(DATE '2008-12-25', QUARTER)", expected: "2008-10-01"},
+		{expr: "DATE_TRUNC(DATE '2017-11-05', WEEK(MONDAY))", expected: "2017-10-30"},
+		{expr: "DATE_TRUNC(DATE '2017-11-05', WEEK(SUNDAY))", expected: "2017-11-05"},
+		{expr: "DATE_TRUNC(DATE '2021-01-01', WEEK)", expected: "2020-12-27"},
+		{expr: "DATE_TRUNC(DATE '2021-01-01', ISOWEEK)", expected: "2020-12-28"},
+		{expr: "DATE_TRUNC(DATE '2021-01-01', YEAR)", expected: "2021-01-01"},
+		{expr: "DATE_TRUNC(DATE '2021-01-01', ISOYEAR)", expected: "2019-12-30"},
+		{expr: "DATE_TRUNC(DATE '2015-06-15', ISOYEAR)", expected: "2014-12-29"},
+		{expr: "DATE_TRUNC(DATE_TRUNC(DATE '2021-03-17', MONTH), WEEK(SATURDAY))", expected: "2021-02-27"},
+		{expr: "DATETIME_TRUNC(DATETIME '2008-12-25 15:30:00', DAY)", expected: "2008-12-25 00:00:00.000000"},
+		{expr: "DATETIME_TRUNC(DATETIME '2017-11-05 00:00:00', WEEK(MONDAY))", expected: "2017-10-30 00:00:00.000000"},
+		{expr: "DATETIME_TRUNC(DATETIME '2015-06-15 00:00:00', ISOYEAR)", expected: "2014-12-29 00:00:00.000000"},
+		{expr: "DATETIME_TRUNC(DATETIME '2008-12-25 15:30:45.123456', SECOND)", expected: "2008-12-25 15:30:45.000000"},
+		{expr: "DATETIME_TRUNC(DATETIME '2008-12-25 15:30:45.123456', MILLISECOND)", expected: "2008-12-25 15:30:45.123000"},
+		{expr: "TIME_TRUNC(TIME '15:30:45.678', SECOND)", expected: "15:30:45.000000"},
+		{expr: "TIME_TRUNC(TIME '15:30:45.678', HOUR)", expected: "15:00:00.000000"},
+		{expr: "TIMESTAMP_TRUNC(TIMESTAMP '2008-12-25 15:30:00+00', DAY)", expected: "2008-12-25 00:00:00.000000"},
+		{expr: "TIMESTAMP_TRUNC(TIMESTAMP '2008-12-25 15:30:00+00', DAY, 'America/Los_Angeles')", expected: "2008-12-25 08:00:00.000000"},
+		{expr: "TIMESTAMP_TRUNC(TIMESTAMP '2015-06-15 00:00:00+00', ISOYEAR)", expected: "2014-12-29 00:00:00.000000"},
+		{expr: "TIMESTAMP_TRUNC(TIMESTAMP '2024-03-10 12:00:00+00', DAY, 'America/New_York')", expected: "2024-03-10 05:00:00.000000"},
+		{expr: "TIMESTAMP_TRUNC(TIMESTAMP '2024-03-10 07:30:00+00', HOUR, 'America/New_York')", expected: "2024-03-10 07:00:00.000000"},
+		{expr: "TIMESTAMP_TRUNC(TIMESTAMP '2024-03-12 12:00:00+00', WEEK, 'America/New_York')", expected: "2024-03-10 05:00:00.000000"},
+		{expr: "TIMESTAMP_TRUNC(TIMESTAMP '2024-01-01 10:45:00+00', HOUR, 'Asia/Kolkata')", expected: "2024-01-01 10:30:00.000000"},
+		{expr: "TIMESTAMP_TRUNC(TIMESTAMP '2024-01-01 10:45:30.5+00', MINUTE)", expected: "2024-01-01 10:45:00.000000"},
+	} {
+		t.Run(test.expr, func(t *testing.T) {
+			query := fmt.Sprintf("SELECT CAST(%s AS STRING)", test.expr)
+			switch {
+			case strings.HasPrefix(test.expr, "DATETIME_TRUNC"):
+				query = fmt.Sprintf("SELECT FORMAT_DATETIME('%%Y-%%m-%%d %%H:%%M:%%E6S', %s)", test.expr)
+			case strings.HasPrefix(test.expr, "TIME_TRUNC"):
+				query = fmt.Sprintf("SELECT FORMAT_TIME('%%H:%%M:%%E6S', %s)", test.expr)
+			case strings.HasPrefix(test.expr, "TIMESTAMP_TRUNC"):
+				query = fmt.Sprintf("SELECT FORMAT_TIMESTAMP('%%Y-%%m-%%d %%H:%%M:%%E6S', %s, 'UTC')", test.expr)
+			}
+			it, err := client.Query(query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]bigquery.Value{test.expected}, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGenerateArray(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		expr          string
+		expected      []bigquery.Value
+		expectedError string
+	}{
+		{expr: "GENERATE_ARRAY(0, 10, 3)", expected: []bigquery.Value{"0", "3", "6", "9"}},
+		{expr: "GENERATE_ARRAY(1, 3)", expected: []bigquery.Value{"1", "2", "3"}},
+		{expr: "GENERATE_ARRAY(10, 0, -3)", expected: []bigquery.Value{"10", "7", "4", "1"}},
+		{expr: "GENERATE_ARRAY(4, 4, -10)", expected: []bigquery.Value{"4"}},
+		{expr: "GENERATE_ARRAY(10, 0, 3)", expected: []bigquery.Value{}},
+		{expr: "GENERATE_ARRAY(0, 10, -3)", expected: []bigquery.Value{}},
+		{expr: "GENERATE_ARRAY(NUMERIC '0', NUMERIC '1', NUMERIC '0.25')", expected: []bigquery.Value{"0", "0.25", "0.5", "0.75", "1"}},
+		{expr: "GENERATE_ARRAY(NUMERIC '1', 0, NUMERIC '-0.3')", expected: []bigquery.Value{"1", "0.7", "0.4", "0.1"}},
+		{expr: "GENERATE_ARRAY(BIGNUMERIC '0', 1, BIGNUMERIC '0.5')", expected: []bigquery.Value{"0", "0.5", "1"}},
+		{expr: "GENERATE_ARRAY(0, 2, 0.5)", expected: []bigquery.Value{"0", "0.5", "1", "1.5", "2"}},
+		{expr: "GENERATE_ARRAY(1, 2.5)", expected: []bigquery.Value{"1", "2"}},
+		{expr: "[GENERATE_ARRAY(0, 1, 0.1)[OFFSET(10)] = 1, ARRAY_LENGTH(GENERATE_ARRAY(0, 0.3, 0.1)) = 3]", expected: []bigquery.Value{"true", "true"}},
+		{expr: "GENERATE_ARRAY(1, NULL, 1)", expected: []bigquery.Value{}},
+		{expr: "GENERATE_ARRAY(1, 5, 0)", expectedError: "Sequence step cannot be 0."},
+		{expr: "GENERATE_ARRAY(1, 1, 0)", expectedError: "Sequence step cannot be 0."},
+		{expr: "SAFE.GENERATE_ARRAY(1, 5, 0)", expected: []bigquery.Value{}},
+	} {
+		t.Run(test.expr, func(t *testing.T) {
+			query := fmt.Sprintf("SELECT ARRAY(SELECT CAST(v AS STRING) FROM UNNEST(%s) AS v WITH OFFSET AS o ORDER BY o)", test.expr)
+			it, err := client.Query(query).Read(ctx)
+			if test.expectedError != "" {
+				if err == nil {
+					var row []bigquery.Value
+					err = it.Next(&row)
+				}
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if !strings.Contains(err.Error(), test.expectedError) {
+					t.Fatalf("expected %q but got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]bigquery.Value{test.expected}, row, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAggregatePushdown(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	logPath := filepath.Join(t.TempDir(), "emulator.log")
+	if err := bqServer.SetLogOutput(logPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := bqServer.SetLogFormat(server.LogFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	if err := bqServer.SetLogLevel(server.LogLevelDebug); err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, startTestServer(t, bqServer), "test")
+
+	for _, query := range []string{
+		"CREATE TABLE dataset1.events (id INT64, flag BOOL, name STRING)",
+		"INSERT dataset1.events (id, flag, name) SELECT x, MOD(x, 2) = 0, IF(MOD(x, 3) = 0, NULL, CAST(x AS STRING)) FROM UNNEST(GENERATE_ARRAY(1, 1000)) AS x",
+		"CREATE VIEW dataset1.events_view AS SELECT * FROM dataset1.events WHERE id > 500",
+	} {
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := job.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pushdownLogs := func(t *testing.T) int {
+		t.Helper()
+		content, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("failed to decode log line %q: %v", line, err)
+			}
+			if entry["M"] == "aggregate pushdown" {
+				count++
+			}
+		}
+		return count
+	}
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected []bigquery.Value
+		pushed   bool
+	}{
+		{
+			name:     "count all rows",
+			query:    "SELECT COUNT(*) FROM dataset1.events",
+			expected: []bigquery.Value{int64(1000)},
+			pushed:   true,
+		},
+		{
+			name:     "filtered count",
+			query:    "SELECT COUNT(*) AS n FROM dataset1.events WHERE id > 100 AND flag = TRUE",
+			expected: []bigquery.Value{int64(450)},
+			pushed:   true,
+		},
+		{
+			name:     "qualified filters",
+			query:    "SELECT COUNT(*) FROM `test.dataset1.events` AS e WHERE e.id <= 10 AND e.name IS NOT NULL",
+			expected: []bigquery.Value{int64(7)},
+			pushed:   true,
+		},
+		{
+			name:     "no rows",
+			query:    "SELECT COUNT(*) FROM dataset1.events WHERE id < -1",
+			expected: []bigquery.Value{int64(0)},
+			pushed:   true,
+		},
+		{
+			name:     "other aggregates",
+			query:    "SELECT COUNT(*), MIN(id), MAX(id) FROM dataset1.events WHERE id <= 10",
+			expected: []bigquery.Value{int64(10), int64(1), int64(10)},
+		},
+		{
+			name:     "view",
+			query:    "SELECT COUNT(*) FROM dataset1.events_view",
+			expected: []bigquery.Value{int64(500)},
+			pushed:   true,
+		},
+		{
+			name:     "string filter",
+			query:    "SELECT COUNT(*) FROM dataset1.events WHERE name = '10'",
+			expected: []bigquery.Value{int64(1)},
+		},
+		{
+			name:     "expression filter",
+			query:    "SELECT COUNT(*) FROM dataset1.events WHERE MOD(id, 10) = 0",
+			expected: []bigquery.Value{int64(100)},
+		},
+		{
+			name: "rows not committed yet",
+			query: `DECLARE next_id INT64 DEFAULT 1001;
+INSERT dataset1.events (id) VALUES (next_id);
+SELECT COUNT(*) FROM dataset1.events`,
+			expected: []bigquery.Value{int64(1001)},
+			pushed:   true,
+		},
+		{
+			name: "row count after delete",
+			query: `DECLARE last_id INT64 DEFAULT 1001;
+DELETE FROM dataset1.events WHERE id = last_id;
+SELECT COUNT(*) FROM dataset1.events`,
+			expected: []bigquery.Value{int64(1000)},
+			pushed:   true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			before := pushdownLogs(t)
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+			if pushed := pushdownLogs(t) > before; pushed != test.pushed {
+				t.Errorf("expected pushdown %t but got %t", test.pushed, pushed)
+			}
+		})
+	}
+}
+
+func TestRowAccessPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	testServer := startTestServer(t, bqServer)
+	client := newTestClient(t, testServer, "test")
+
+	run := func(t *testing.T, query string) error {
+		t.Helper()
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			return err
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	}
+	policiesURL := fmt.Sprintf("%s/projects/test/datasets/dataset1/tables/table_a/rowAccessPolicies", testServer.URL)
+	listPolicies := func(t *testing.T) map[string]string {
+		t.Helper()
+		res, err := http.Get(policiesURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", res.StatusCode)
+		}
+		var list bigqueryv2.ListRowAccessPoliciesResponse
+		if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+		predicates := map[string]string{}
+		for _, policy := range list.RowAccessPolicies {
+			if policy.RowAccessPolicyReference.TableId != "table_a" || policy.CreationTime == "" {
+				t.Errorf("unexpected policy %+v", policy)
+			}
+			predicates[policy.RowAccessPolicyReference.PolicyId] = policy.FilterPredicate
+		}
+		return predicates
+	}
+	granteesOf := func(t *testing.T, policyID string) (int, []string) {
+		t.Helper()
+		res, err := http.Post(fmt.Sprintf("%s/%s:getIamPolicy", policiesURL, policyID), "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return res.StatusCode, nil
+		}
+		var policy bigqueryv2.Policy
+		if err := json.NewDecoder(res.Body).Decode(&policy); err != nil {
+			t.Fatal(err)
+		}
+		var members []string
+		for _, binding := range policy.Bindings {
+			if binding.Role != "roles/bigquery.filteredDataViewer" {
+				t.Errorf("unexpected role %s", binding.Role)
+			}
+			members = append(members, binding.Members...)
+		}
+		return res.StatusCode, members
+	}
+
+	t.Run("drop all without policies", func(t *testing.T) {
+		if err := run(t, "DROP ALL ROW ACCESS POLICIES ON dataset1.table_a"); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(map[string]string{}, listPolicies(t)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("create", func(t *testing.T) {
+		for _, query := range []string{
+			`CREATE ROW ACCESS POLICY alice_only ON dataset1.table_a GRANT TO ("user:alice@example.com") FILTER USING (name = 'alice')`,
+			`CREATE ROW ACCESS POLICY first_id ON test.dataset1.table_a GRANT TO ("user:bob@example.com", "group:admins@example.com") FILTER USING (id = 1)`,
+			"CREATE ROW ACCESS POLICY everyone ON `test.dataset1.table_a` FILTER USING (TRUE)",
+			`CREATE OR REPLACE ROW ACCESS POLICY first_id ON dataset1.table_a GRANT TO ("user:bob@example.com") FILTER USING (id <= 1)`,
+			`CREATE ROW ACCESS POLICY IF NOT EXISTS everyone ON dataset1.table_a FILTER USING (FALSE)`,
+		} {
+			if err := run(t, query); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+		}
+		expected := map[string]string{"alice_only": "name = 'alice'", "first_id": "id <= 1", "everyone": "TRUE"}
+		if diff := cmp.Diff(expected, listPolicies(t)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		for policyID, expected := range map[string][]string{
+			"first_id":   {"user:bob@example.com"},
+			"alice_only": {"user:alice@example.com"},
+			"everyone":   nil,
+		} {
+			if _, members := granteesOf(t, policyID); !cmp.Equal(expected, members) {
+				t.Errorf("unexpected grantees of %s: %v", policyID, members)
+			}
+		}
+		if status, _ := granteesOf(t, "unknown"); status != http.StatusNotFound {
+			t.Errorf("expected not found but got %d", status)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for query, expected := range map[string]string{
+			`CREATE ROW ACCESS POLICY alice_only ON dataset1.table_a FILTER USING (TRUE)`:      "Already Exists",
+			`CREATE ROW ACCESS POLICY unknown_column ON dataset1.table_a FILTER USING (x = 1)`: "invalid filter predicate",
+			`CREATE ROW ACCESS POLICY no_table ON dataset1.unknown FILTER USING (TRUE)`:        "Not found: Table",
+			`DROP ROW ACCESS POLICY unknown ON dataset1.table_a`:                               "Not found: Row access policy",
+		} {
+			err := run(t, query)
+			if err == nil {
+				t.Fatalf("%s: expected error", query)
+			}
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: expected %q but got %v", query, expected, err)
+			}
+		}
+	})
+	t.Run("drop", func(t *testing.T) {
+		for _, query := range []string{
+			"DROP ROW ACCESS POLICY alice_only ON dataset1.table_a",
+			"DROP ROW ACCESS POLICY IF EXISTS alice_only ON dataset1.table_a",
+		} {
+			if err := run(t, query); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+		}
+		if diff := cmp.Diff(map[string]string{"first_id": "id <= 1", "everyone": "TRUE"}, listPolicies(t)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if err := run(t, "DROP ALL ROW ACCESS POLICIES ON dataset1.table_a"); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(map[string]string{}, listPolicies(t)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestStringFunctions(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		expr          string
+		expected      bigquery.Value
+		expectedError string
+	}{
+		{expr: "SUBSTR('apple', 2)", expected: "pple"},
+		{expr: "SUBSTR('apple', 2, 2)", expected: "pp"},
+		{expr: "SUBSTR('apple', -2)", expected: "le"},
+		{expr: "SUBSTR('apple', -7, 3)", expected: "app"},
+		{expr: "SUBSTR('apple', 0, 2)", expected: "ap"},
+		{expr: "SUBSTR('apple', 10)", expected: ""},
+		{expr: "SUBSTR('apple', 2, 0)", expected: ""},
+		{expr: "SUBSTRING('日本語テキスト', 2, 3)", expected: "本語テ"},
+		{expr: "SUBSTR('日本語テキスト', -3)", expected: "キスト"},
+		{expr: "SUBSTR(b'apple', -3, 2)", expected: []byte("pl")},
+		{expr: "SUBSTR(b'\\xe6\\x97\\xa5\\xe6\\x9c\\xac', 2, 2)", expected: []byte{0x97, 0xa5}},
+		{expr: "SUBSTR('apple', NULL)", expected: nil},
+		{expr: "SUBSTR('apple', 1, -1)", expectedError: "SUBSTR: length cannot be negative"},
+		{expr: "LEFT('日本語テキスト', 2)", expected: "日本"},
+		{expr: "LEFT(b'apple', 0)", expected: []byte{}},
+		{expr: "LEFT(b'\\xe6\\x97\\xa5', 2)", expected: []byte{0xe6, 0x97}},
+		{expr: "RIGHT('日本語テキスト', 2)", expected: "スト"},
+		{expr: "RIGHT(b'apple', 10)", expected: []byte("apple")},
+		{expr: "LPAD('abc', 8, 'xy')", expected: "xyxyxabc"},
+		{expr: "LPAD('日本', 5, '語')", expected: "語語語日本"},
+		{expr: "LPAD('abc', 2, 'xy')", expected: "ab"},
+		{expr: "LPAD('abc', 0)", expected: ""},
+		{expr: "LPAD('abc', 5)", expected: "  abc"},
+		{expr: "LPAD(b'abc', 4, b'xyz')", expected: []byte("xabc")},
+		{expr: "LPAD(b'abc', 9, b'xy')", expected: []byte("xyxyxyabc")},
+		{expr: "LPAD('abc', -1, 'x')", expectedError: "LPAD: return_length cannot be negative"},
+		{expr: "LPAD('abc', 5, '')", expectedError: "LPAD: pattern cannot be empty"},
+		{expr: "RPAD('abc', 8, 'xy')", expected: "abcxyxyx"},
+		{expr: "RPAD('日本', 3, '語テ')", expected: "日本語"},
+		{expr: "RPAD('abc', 1)", expected: "a"},
+		{expr: "RPAD(b'abc', 4, b'xyz')", expected: []byte("abcx")},
+		{expr: "RPAD(b'abc', 0, b'x')", expected: []byte{}},
+		{expr: "RPAD('abc', NULL, 'x')", expected: nil},
+		{expr: "RPAD(b'abc', 5, b'')", expectedError: "RPAD: pattern cannot be empty"},
+		{expr: "REPEAT('日本', 2)", expected: "日本日本"},
+		{expr: "REPEAT(b'ab', 3)", expected: []byte("ababab")},
+		{expr: "REPEAT('abc', 0)", expected: ""},
+		{expr: "REPEAT('abc', -1)", expectedError: "REPEAT: repetitions cannot be negative"},
+		{expr: "REVERSE('日本語')", expected: "語本日"},
+		{expr: "REVERSE(b'\\x01\\x02\\x03')", expected: []byte{0x03, 0x02, 0x01}},
+		{expr: "REVERSE('')", expected: ""},
+		{expr: "SUBSTR(LPAD(CAST(42 AS STRING), 6, '0'), -4, 3)", expected: "004"},
+	} {
+		t.Run(test.expr, func(t *testing.T) {
+			it, err := client.Query(fmt.Sprintf("SELECT %s", test.expr)).Read(ctx)
+			if test.expectedError != "" {
+				if err == nil {
+					var row []bigquery.Value
+					err = it.Next(&row)
+				}
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if !strings.Contains(err.Error(), test.expectedError) {
+					t.Fatalf("expected %q but got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]bigquery.Value{test.expected}, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCastNumberFormat(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		expr          string
+		expected      bigquery.Value
+		expectedError string
+	}{
+		{expr: "CAST(123.4 AS STRING FORMAT '999.99')", expected: " 123.40"},
+		{expr: "CAST(12 AS STRING FORMAT '999')", expected: "  12"},
+		{expr: "CAST(-12 AS STRING FORMAT '999')", expected: " -12"},
+		{expr: "CAST(0 AS STRING FORMAT '999')", expected: "   0"},
+		{expr: "CAST(12 AS STRING FORMAT '0000')", expected: " 0012"},
+		{expr: "CAST(NUMERIC '1234567.891' AS STRING FORMAT '$9,999,999.99')", expected: " $1,234,567.89"},
+		{expr: "CAST(12.5 AS STRING FORMAT '$9,999')", expected: "    $13"},
+		{expr: "CAST(NUMERIC '2.675' AS STRING FORMAT '9.99')", expected: " 2.68"},
+		{expr: "CAST(NUMERIC '-0.001' AS STRING FORMAT '9.99')", expected: "  .00"},
+		{expr: "CAST(NUMERIC '-12.345' AS STRING FORMAT 'S99.99')", expected: "-12.35"},
+		{expr: "CAST(1.5 AS STRING FORMAT 'S99.99')", expected: " +1.50"},
+		{expr: "CAST(-12 AS STRING FORMAT '999MI')", expected: " 12-"},
+		{expr: "CAST(12 AS STRING FORMAT '999MI')", expected: " 12 "},
+		{expr: "CAST(-12 AS STRING FORMAT '999PR')", expected: " <12>"},
+		{expr: "CAST(12 AS STRING FORMAT '999PR')", expected: "  12 "},
+		{expr: "CAST(-12 AS STRING FORMAT 'C999')", expected: " -USD12"},
+		{expr: "CAST(1234 AS STRING FORMAT '99.9')", expected: "#####"},
+		{expr: "CAST(123456 AS STRING FORMAT '9.99EEEE')", expected: " 1.23E+05"},
+		{expr: "CAST(99999 AS STRING FORMAT '9.99EEEE')", expected: " 1.00E+05"},
+		{expr: "CAST(NUMERIC '-0.00123' AS STRING FORMAT '9.9EEEE')", expected: "-1.2E-03"},
+		{expr: "CAST(NUMERIC '123.456' AS STRING FORMAT '999V99')", expected: " 12346"},
+		{expr: "CAST(NUMERIC '0.23' AS STRING FORMAT 'B99.999')", expected: "       "},
+		{expr: "CAST(NUMERIC '12.5' AS STRING FORMAT 'FM999.999')", expected: "12.5"},
+		{expr: "CAST(12 AS STRING FORMAT 'FM0000')", expected: "0012"},
+		{expr: "CAST(255 AS STRING FORMAT 'XXXX')", expected: "   FF"},
+		{expr: "CAST(255 AS STRING FORMAT 'FM0xxx')", expected: "00ff"},
+		{expr: "CAST(NUMERIC '12.50' AS STRING FORMAT 'TM')", expected: "12.5"},
+		{expr: "CAST(1234 AS STRING FORMAT 'TME')", expected: "1.234E+03"},
+		{expr: "CAST(NULL AS STRING FORMAT '999')", expected: nil},
+		{expr: "CAST(1.5 AS STRING FORMAT 'XX')", expectedError: "Invalid value for the numeric format 'XX'"},
+		{expr: "SAFE_CAST(1.5 AS STRING FORMAT 'XX')", expected: nil},
+		{expr: "CAST(CAST(' $1,234,567.89' AS NUMERIC FORMAT '$9,999,999.99') AS STRING)", expected: "1234567.89"},
+		{expr: "CAST(CAST(' <12>' AS INT64 FORMAT '999PR') AS STRING)", expected: "-12"},
+		{expr: "CAST(CAST(' 12-' AS INT64 FORMAT '999MI') AS STRING)", expected: "-12"},
+		{expr: "CAST(CAST(' 1.23E+05' AS FLOAT64 FORMAT '9.99EEEE') AS STRING)", expected: "123000"},
+		{expr: "CAST(CAST(' 12346' AS NUMERIC FORMAT '999V99') AS STRING)", expected: "123.46"},
+		{expr: "CAST(CAST('   FF' AS INT64 FORMAT 'XXXX') AS STRING)", expected: "255"},
+		{
+			expr:     "CAST(CAST(CAST(NUMERIC '-1234.5' AS STRING FORMAT 'S9,999.99') AS NUMERIC FORMAT 'S9,999.99') AS STRING)",
+			expected: "-1234.5",
+		},
+		{expr: "CAST('abc' AS NUMERIC FORMAT '999')", expectedError: "Invalid number: 'abc'"},
+		{expr: "SAFE_CAST('abc' AS NUMERIC FORMAT '999')", expected: nil},
+		{expr: "CAST('12' AS INT64 FORMAT '9Q')", expectedError: "invalid numeric format"},
+	} {
+		t.Run(test.expr, func(t *testing.T) {
+			it, err := client.Query(fmt.Sprintf("SELECT %s", test.expr)).Read(ctx)
+			if test.expectedError != "" {
+				if err == nil {
+					var row []bigquery.Value
+					err = it.Next(&row)
+				}
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if !strings.Contains(err.Error(), test.expectedError) {
+					t.Fatalf("expected %q but got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]bigquery.Value{test.expected}, row); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithClauseScope(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	exec := func(t *testing.T, query string) {
+		t.Helper()
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := status.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queryRow := func(t *testing.T, query string) []bigquery.Value {
+		t.Helper()
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		return row
+	}
+
+	exec(t, "CREATE TABLE dataset1.t AS SELECT 1 AS x")
+	exec(t, "CREATE VIEW dataset1.cte_view AS WITH t AS (SELECT 10 AS x, 'view' AS label) SELECT x, label FROM t")
+	exec(t, "CREATE VIEW dataset1.table_view AS WITH src AS (SELECT x FROM dataset1.t) SELECT x FROM src")
+
+	t.Run("view", func(t *testing.T) {
+		row := queryRow(t, `
+WITH t AS (SELECT 99 AS x)
+SELECT (SELECT x FROM dataset1.cte_view), (SELECT label FROM dataset1.cte_view), (SELECT x FROM t), (SELECT x FROM dataset1.table_view)`)
+		if diff := cmp.Diff([]bigquery.Value{int64(10), "view", int64(99), int64(1)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("view with outer cte of the table name", func(t *testing.T) {
+		row := queryRow(t, "WITH src AS (SELECT 5 AS x) SELECT v.x, src.x FROM dataset1.table_view AS v, src")
+		if diff := cmp.Diff([]bigquery.Value{int64(1), int64(5)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("subquery", func(t *testing.T) {
+		row := queryRow(t, `
+WITH t AS (SELECT 1 AS a)
+SELECT
+  (SELECT CONCAT(b, CAST(c AS STRING)) FROM (WITH t AS (SELECT 'x' AS b, 2 AS c) SELECT * FROM t)),
+  (SELECT t.a FROM t),
+  (SELECT COUNT(*) FROM (WITH u AS (SELECT a FROM t) SELECT * FROM u, t))`)
+		if diff := cmp.Diff([]bigquery.Value{"x2", int64(1), int64(1)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("cte shadowing the table", func(t *testing.T) {
+		row := queryRow(t, `
+SELECT
+  (SELECT x FROM (WITH t AS (SELECT 7 AS x) SELECT x FROM t)),
+  (SELECT x FROM dataset1.t)`)
+		if diff := cmp.Diff([]bigquery.Value{int64(7), int64(1)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("dml", func(t *testing.T) {
+		exec(t, "CREATE TABLE dataset1.cte_dst (x INT64)")
+		exec(t, `
+WITH t AS (SELECT 1 AS x)
+INSERT INTO dataset1.cte_dst (x)
+SELECT x FROM t UNION ALL SELECT x FROM (WITH t AS (SELECT 2 AS x) SELECT x FROM t)`)
+		row := queryRow(t, "SELECT ARRAY_AGG(x ORDER BY x) FROM dataset1.cte_dst")
+		if diff := cmp.Diff([]bigquery.Value{[]bigquery.Value{int64(1), int64(2)}}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestInitSQL(t *testing.T) {
+	ctx := context.Background()
+
+	const projectName = "test"
+
+	t.Run("view over seeded data", func(t *testing.T) {
+		bqServer := newTestServer(
+			t,
+			server.YAMLSource(filepath.Join("testdata", "data.yaml")),
+			server.InitSQLSource(projectName, `
+DECLARE min_id INT64 DEFAULT 1;
+EXECUTE IMMEDIATE FORMAT('CREATE VIEW dataset1.init_view AS SELECT id, name FROM dataset1.table_a WHERE id > %d', min_id);
+CREATE FUNCTION dataset1.init_double(x INT64) AS (x * 2);
+`, &server.InitSQLOption{Timeout: time.Minute}),
+		)
+		client := newTestClient(t, startTestServer(t, bqServer), projectName)
+
+		it, err := client.Query("SELECT dataset1.init_double(id), name FROM dataset1.init_view ORDER BY id").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		if diff := cmp.Diff([][]bigquery.Value{{int64(4), "bob"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if _, err := client.Dataset("dataset1").Table("init_view").Metadata(ctx); err != nil {
+			t.Fatalf("failed to get the view created by init SQL: %v", err)
+		}
+	})
+	t.Run("error aborts startup", func(t *testing.T) {
+		bqServer, err := server.New(server.TempStorage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bqServer.Close()
+		err = bqServer.Load(
+			server.YAMLSource(filepath.Join("testdata", "data.yaml")),
+			server.InitSQLSource(projectName, `
+CREATE VIEW dataset1.init_view AS SELECT id FROM dataset1.table_a;
+SELECT * FROM dataset1.unknown_table;
+`, nil),
+		)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "statement 2") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("continue on error", func(t *testing.T) {
+		bqServer := newTestServer(
+			t,
+			server.YAMLSource(filepath.Join("testdata", "data.yaml")),
+			server.InitSQLSource(projectName, `
+SELECT * FROM dataset1.unknown_table;
+CREATE TABLE dataset1.init_table AS SELECT 1 AS x;
+`, &server.InitSQLOption{ContinueOnError: true}),
+		)
+		client := newTestClient(t, startTestServer(t, bqServer), projectName)
+
+		if _, err := client.Dataset("dataset1").Table("init_table").Metadata(ctx); err != nil {
+			t.Fatalf("failed to get the table created after the failed statement: %v", err)
+		}
+	})
+	t.Run("unknown project", func(t *testing.T) {
+		bqServer, err := server.New(server.TempStorage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bqServer.Close()
+		if err := bqServer.Load(server.InitSQLSource("unknown", "SELECT 1", nil)); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestLoadAutodetect(t *testing.T) {
+	const (
+		projectName = "test"
+		datasetName = "dataset1"
+	)
+
+	ctx := context.Background()
+
+	project := types.NewProject(projectName, types.NewDataset(datasetName))
+	bqServer := newTestServer(t, server.StructSource(project))
+	client := newTestClient(t, startTestServer(t, bqServer), projectName)
+
+	dataset := client.Dataset(datasetName)
+	load := func(tableName string, format bigquery.DataFormat, data string) error {
+		source := bigquery.NewReaderSource(strings.NewReader(data))
+		source.SourceFormat = format
+		source.AutoDetect = true
+		job, err := dataset.Table(tableName).LoaderFrom(source).Run(ctx)
+		if err != nil {
+			return err
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	}
+	assertSchema := func(t *testing.T, tableName string, expected bigquery.Schema) {
+		t.Helper()
+		md, err := dataset.Table(tableName).Metadata(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(expected, md.Schema); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	}
+	queryRows := func(t *testing.T, query string) [][]bigquery.Value {
+		t.Helper()
+		it, err := client.Query(query).Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]bigquery.Value
+		for {
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				if err == iterator.Done {
+					break
+				}
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	t.Run("nested json", func(t *testing.T) {
+		data := `{"id": 1, "name": "alice", "address": {"city": "Tokyo", "geo": {"lat": 35.6, "lng": 139}}, "tags": ["a", "b"], "orders": [{"sku": "x", "qty": 1}], "score": 1}
+{"id": 2, "name": null, "address": {"city": "Osaka", "zip": "530"}, "tags": [], "orders": [{"sku": "y", "qty": 2.5}], "score": 1.5, "notes": []}
+`
+		if err := load("nested", bigquery.JSON, data); err != nil {
+			t.Fatal(err)
+		}
+		assertSchema(t, "nested", bigquery.Schema{
+			{Name: "address", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+				{Name: "city", Type: bigquery.StringFieldType},
+				{Name: "geo", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+					{Name: "lat", Type: bigquery.FloatFieldType},
+					{Name: "lng", Type: bigquery.IntegerFieldType},
+				}},
+				{Name: "zip", Type: bigquery.StringFieldType},
+			}},
+			{Name: "id", Type: bigquery.IntegerFieldType},
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "orders", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+				{Name: "qty", Type: bigquery.FloatFieldType},
+				{Name: "sku", Type: bigquery.StringFieldType},
+			}},
+			{Name: "score", Type: bigquery.FloatFieldType},
+			{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+			{Name: "notes", Type: bigquery.StringFieldType, Repeated: true},
+		})
+		rows := queryRows(t, `
+SELECT id, address.city, address.geo.lng, ARRAY_LENGTH(tags), (SELECT SUM(qty) FROM UNNEST(orders))
+FROM dataset1.nested ORDER BY id`)
+		expected := [][]bigquery.Value{
+			{int64(1), "Tokyo", int64(139), int64(2), float64(1)},
+			{int64(2), "Osaka", nil, int64(0), 2.5},
+		}
+		if diff := cmp.Diff(expected, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("object and scalar conflict", func(t *testing.T) {
+		if err := load("conflict_record", bigquery.JSON, "{\"a\": {\"b\": 1}}\n{\"a\": 2}\n"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("array and scalar conflict", func(t *testing.T) {
+		if err := load("conflict_array", bigquery.JSON, "{\"a\": [1]}\n{\"a\": 2}\n"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("nested array", func(t *testing.T) {
+		if err := load("nested_array", bigquery.JSON, "{\"a\": [[1]]}\n"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+	t.Run("csv without header", func(t *testing.T) {
+		if err := load("csv_no_header", bigquery.CSV, "1,alice\n2,bob\n"); err != nil {
+			t.Fatal(err)
+		}
+		assertSchema(t, "csv_no_header", bigquery.Schema{
+			{Name: "int64_field_0", Type: bigquery.IntegerFieldType},
+			{Name: "string_field_1", Type: bigquery.StringFieldType},
+		})
+		rows := queryRows(t, "SELECT * FROM dataset1.csv_no_header ORDER BY int64_field_0")
+		if diff := cmp.Diff([][]bigquery.Value{{int64(1), "alice"}, {int64(2), "bob"}}, rows); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestQualifyNamedWindow(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	job, err := client.Query(`
+CREATE TABLE dataset1.events AS
+SELECT * FROM UNNEST([
+  STRUCT(1 AS id, 'a' AS k, 10 AS v), (2, 'a', 20), (3, 'b', 5), (4, 'b', 5), (5, 'c', 7)
+])`).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected [][]bigquery.Value
+	}{
+		{
+			name: "unprojected window function with named window",
+			query: `
+SELECT * FROM dataset1.events
+QUALIFY ROW_NUMBER() OVER w = 1
+WINDOW w AS (PARTITION BY k ORDER BY v DESC, id)
+ORDER BY id`,
+			expected: [][]bigquery.Value{
+				{int64(2), "a", int64(20)},
+				{int64(3), "b", int64(5)},
+				{int64(5), "c", int64(7)},
+			},
+		},
+		{
+			name: "multiple predicates with window defined from another window",
+			query: `
+SELECT id FROM dataset1.events
+WHERE v > 0
+QUALIFY ROW_NUMBER() OVER w = 1 AND COUNT(*) OVER (by_k) > 1
+WINDOW by_k AS (PARTITION BY k), w AS (by_k ORDER BY id)
+ORDER BY id`,
+			expected: [][]bigquery.Value{
+				{int64(1)},
+				{int64(3)},
+			},
+		},
+		{
+			name: "select distinct",
+			query: `
+SELECT DISTINCT v FROM dataset1.events
+QUALIFY COUNT(*) OVER w > 1
+WINDOW w AS (PARTITION BY v)`,
+			expected: [][]bigquery.Value{
+				{int64(5)},
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows [][]bigquery.Value
+			for {
+				var row []bigquery.Value
+				if err := it.Next(&row); err != nil {
+					if err == iterator.Done {
+						break
+					}
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if diff := cmp.Diff(test.expected, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFieldModes(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+
+	const (
+		projectName = "test"
+	)
+	testServer := startTestServer(t, bqServer)
+	client := newTestClient(t, testServer, projectName)
+
+	run := func(query string) error {
+		job, err := client.Query(query).Run(ctx)
+		if err != nil {
+			return err
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	}
+	get := func(t *testing.T, path string, v interface{}) {
+		t.Helper()
+		res, err := http.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", res.StatusCode, string(body))
+		}
+		if err := json.Unmarshal(body, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// modes returns the modes of the fields by the paths of the names joined by the dot.
+	var modes func(prefix string, fields []*bigqueryv2.TableFieldSchema, m map[string]string) map[string]string
+	modes = func(prefix string, fields []*bigqueryv2.TableFieldSchema, m map[string]string) map[string]string {
+		for _, field := range fields {
+			m[prefix+field.Name] = field.Mode
+			modes(prefix+field.Name+".", field.Fields, m)
+		}
+		return m
+	}
+	queryModes := func(t *testing.T, query string) (map[string]string, []*bigqueryv2.TableRow) {
+		t.Helper()
+		b, err := json.Marshal(&bigqueryv2.QueryRequest{Query: query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(fmt.Sprintf("%s/projects/%s/queries", testServer.URL, projectName), "application/json", bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", res.StatusCode, string(body))
+		}
+		var v bigqueryv2.QueryResponse
+		if err := json.Unmarshal(body, &v); err != nil {
+			t.Fatal(err)
+		}
+		return modes("", v.Schema.Fields, map[string]string{}), v.Rows
+	}
+
+	if err := run(`
+CREATE TABLE dataset1.users (id INT64 NOT NULL, name STRING, address STRUCT<city STRING, zips ARRAY<STRING>>);
+CREATE TABLE dataset1.orders (id INT64 NOT NULL, user_id INT64 NOT NULL);
+INSERT dataset1.users (id, name, address) VALUES (1, 'alice', STRUCT('tokyo', ['100', '101'])), (2, 'bob', NULL);
+INSERT dataset1.orders (id, user_id) VALUES (10, 1)`); err != nil {
+		t.Fatal(err)
+	}
+	required := client.Dataset("dataset1").Table("required_ids")
+	if err := required.Create(ctx, &bigquery.TableMetadata{Schema: bigquery.Schema{
+		{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+		{Name: "name", Type: bigquery.StringFieldType},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("table schema of NOT NULL columns", func(t *testing.T) {
+		var table bigqueryv2.Table
+		get(t, fmt.Sprintf("/projects/%s/datasets/dataset1/tables/users", projectName), &table)
+		if diff := cmp.Diff(map[string]string{
+			"id":           "REQUIRED",
+			"name":         "NULLABLE",
+			"address":      "NULLABLE",
+			"address.city": "NULLABLE",
+			"address.zips": "REPEATED",
+		}, modes("", table.Schema.Fields, map[string]string{})); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("query schema", func(t *testing.T) {
+		got, rows := queryModes(t, `
+SELECT [1, 2] AS arr, STRUCT(1 AS a, ['x'] AS b) AS rec, [STRUCT(['y'] AS c)] AS recs, 'x' AS s, address
+FROM dataset1.users WHERE id = 1`)
+		if diff := cmp.Diff(map[string]string{
+			"arr":          "REPEATED",
+			"rec":          "NULLABLE",
+			"rec.a":        "NULLABLE",
+			"rec.b":        "REPEATED",
+			"recs":         "REPEATED",
+			"recs.c":       "REPEATED",
+			"s":            "NULLABLE",
+			"address":      "NULLABLE",
+			"address.city": "NULLABLE",
+			"address.zips": "REPEATED",
+		}, got); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if len(rows) != 1 {
+			t.Fatalf("unexpected rows: %d", len(rows))
+		}
+		if _, ok := rows[0].F[0].V.([]interface{}); !ok {
+			t.Errorf("expected the REPEATED value as array but got %T", rows[0].F[0].V)
+		}
+	})
+	t.Run("REQUIRED column in outer join", func(t *testing.T) {
+		got, rows := queryModes(t, `
+SELECT u.id AS user_id, o.id AS order_id
+FROM dataset1.users AS u LEFT JOIN dataset1.orders AS o ON o.user_id = u.id
+ORDER BY u.id`)
+		if diff := cmp.Diff(map[string]string{
+			"user_id":  "NULLABLE",
+			"order_id": "NULLABLE",
+		}, got); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		if len(rows) != 2 || rows[1].F[1].V != nil {
+			t.Errorf("expected NULL order of the user without orders: %v", rows)
+		}
+	})
+	t.Run("REQUIRED null rejection", func(t *testing.T) {
+		for _, query := range []string{
+			"INSERT dataset1.users (id, name) VALUES (NULL, 'carol')",
+			"INSERT dataset1.users (name) VALUES ('carol')",
+			"UPDATE dataset1.orders SET user_id = NULL WHERE id = 10",
+			"INSERT dataset1.required_ids (id, name) VALUES (NULL, 'carol')",
+		} {
+			err := run(query)
+			if err == nil {
+				t.Fatalf("expected error: %s", query)
+			}
+			if !strings.Contains(err.Error(), "cannot be null") {
+				t.Errorf("unexpected error of %s: %v", query, err)
+			}
+		}
+		err := required.Inserter().Put(ctx, &bigquery.ValuesSaver{
+			Schema: bigquery.Schema{
+				{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+				{Name: "name", Type: bigquery.StringFieldType},
+			},
+			Row: []bigquery.Value{nil, "carol"},
+		})
+		if err == nil || !strings.Contains(err.Error(), "Required field id cannot be null") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		it, err := client.Query("SELECT COUNT(*) FROM dataset1.users").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if row[0] != int64(2) {
+			t.Errorf("expected the rejected rows not to be inserted: %v", row[0])
+		}
+	})
+}
+
+func TestDateArithmetic(t *testing.T) {
+	ctx := context.Background()
+
+	client := newTestDataClient(t)
+
+	for _, test := range []struct {
+		name     string
+		query    string
+		expected bigquery.Value
+	}{
+		{
+			name:     "DATE_ADD month end",
+			query:    "SELECT DATE_ADD(DATE '2024-01-31', INTERVAL 1 MONTH)",
+			expected: civil.Date{Year: 2024, Month: 2, Day: 29},
+		},
+		{
+			name:     "DATE_SUB month end",
+			query:    "SELECT DATE_SUB(DATE '2024-03-31', INTERVAL 1 MONTH)",
+			expected: civil.Date{Year: 2024, Month: 2, Day: 29},
+		},
+		{
+			name:     "DATE_ADD quarter",
+			query:    "SELECT DATE_ADD(DATE '2024-01-15', INTERVAL 2 QUARTER)",
+			expected: civil.Date{Year: 2024, Month: 7, Day: 15},
+		},
+		{
+			name:     "DATE_SUB quarter",
+			query:    "SELECT DATE_SUB(DATE '2024-01-15', INTERVAL 1 QUARTER)",
+			expected: civil.Date{Year: 2023, Month: 10, Day: 15},
+		},
+		{
+			name:     "DATE_ADD week",
+			query:    "SELECT DATE_ADD(DATE '2024-01-01', INTERVAL 2 WEEK)",
+			expected: civil.Date{Year: 2024, Month: 1, Day: 15},
+		},
+		{
+			name:     "DATE_SUB leap year",
+			query:    "SELECT DATE_SUB(DATE '2024-02-29', INTERVAL 1 YEAR)",
+			expected: civil.Date{Year: 2023, Month: 2, Day: 28},
+		},
+		{
+			name:     "DATETIME_ADD month end",
+			query:    "SELECT DATETIME_ADD(DATETIME '2024-01-31 10:00:00', INTERVAL 1 MONTH)",
+			expected: civil.DateTime{Date: civil.Date{Year: 2024, Month: 2, Day: 29}, Time: civil.Time{Hour: 10}},
+		},
+		{
+			name:     "DATETIME_SUB minute",
+			query:    "SELECT DATETIME_SUB(DATETIME '2024-01-01 00:00:00', INTERVAL 90 MINUTE)",
+			expected: civil.DateTime{Date: civil.Date{Year: 2023, Month: 12, Day: 31}, Time: civil.Time{Hour: 22, Minute: 30}},
+		},
+		{
+			name:     "DATETIME_SUB quarter",
+			query:    "SELECT DATETIME_SUB(DATETIME '2024-05-31 10:00:00', INTERVAL 1 QUARTER)",
+			expected: civil.DateTime{Date: civil.Date{Year: 2024, Month: 2, Day: 29}, Time: civil.Time{Hour: 10}},
+		},
+		{
+			name:     "TIMESTAMP_ADD hour",
+			query:    "SELECT TIMESTAMP_ADD(TIMESTAMP '2024-01-01 00:00:00+00', INTERVAL 36 HOUR)",
+			expected: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "TIMESTAMP_SUB millisecond",
+			query:    "SELECT TIMESTAMP_SUB(TIMESTAMP '2024-01-01 00:00:00+00', INTERVAL 1500 MILLISECOND)",
+			expected: time.Date(2023, 12, 31, 23, 59, 58, 500000000, time.UTC),
+		},
+		{
+			name:     "DATE plus INTERVAL month end",
+			query:    "SELECT DATE '2024-01-31' + INTERVAL 1 MONTH",
+			expected: civil.DateTime{Date: civil.Date{Year: 2024, Month: 2, Day: 29}},
+		},
+		{
+			name:     "TIMESTAMP minus INTERVAL year",
+			query:    "SELECT TIMESTAMP '2024-02-29 10:00:00+00' - INTERVAL 1 YEAR",
+			expected: time.Date(2023, 2, 28, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "DATETIME plus INTERVAL week",
+			query:    "SELECT DATETIME '2024-01-01 10:00:00' + INTERVAL 1 WEEK",
+			expected: civil.DateTime{Date: civil.Date{Year: 2024, Month: 1, Day: 8}, Time: civil.Time{Hour: 10}},
+		},
+		{
+			name:     "DATE plus days",
+			query:    "SELECT DATE '2024-02-28' + 2",
+			expected: civil.Date{Year: 2024, Month: 3, Day: 1},
+		},
+		{
+			name:     "TIMESTAMP minus TIMESTAMP",
+			query:    "SELECT CAST(TIMESTAMP '2024-01-02 12:00:00+00' - TIMESTAMP '2024-01-01 00:00:00+00' AS STRING)",
+			expected: "0-0 0 36:0:0",
+		},
+		{
+			name:     "DATE minus DATE",
+			query:    "SELECT CAST(DATE '2024-03-01' - DATE '2024-02-01' AS STRING)",
+			expected: "0-0 29 0:0:0",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			it, err := client.Query(test.query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.expected, row[0]); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{
+			name:  "sub-day part of DATE_ADD",
+			query: "SELECT DATE_ADD(DATE '2024-01-01', INTERVAL 1 HOUR)",
+			err:   "DATE_ADD does not support the HOUR date part",
+		},
+		{
+			name:  "sub-day part of DATE_SUB",
+			query: "SELECT DATE_SUB(DATE '2024-01-01', INTERVAL 30 MINUTE)",
+			err:   "DATE_SUB does not support the MINUTE date part",
+		},
+		{
+			name:  "month part of TIMESTAMP_ADD",
+			query: "SELECT TIMESTAMP_ADD(TIMESTAMP '2024-01-01 00:00:00+00', INTERVAL 1 MONTH)",
+			err:   "TIMESTAMP_ADD does not support the MONTH date part",
+		},
+		{
+			name:  "TIMESTAMP plus integer",
+			query: "SELECT TIMESTAMP '2024-01-01 00:00:00+00' + 1",
+			err:   "No matching signature for operator +",
+		},
+		{
+			name:  "DATETIME minus integer",
+			query: "SELECT DATETIME '2024-01-01 00:00:00' - 1",
+			err:   "No matching signature for operator -",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := client.Query(test.query).Read(ctx)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q but got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestExternalTableDDL(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	testServer := startTestServer(t, bqServer)
+	client := newTestClient(t, testServer, "test")
+
+	res, err := http.Post(
+		testServer.URL+"/v1/projects/test/locations/US/connections?connectionId=lake",
+		"application/json",
+		strings.NewReader(`{"friendlyName": "lake", "cloudResource": {}}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("failed to create connection: %d", res.StatusCode)
+	}
+
+	dir := t.TempDir()
+	scoresPath := filepath.Join(dir, "scores.csv")
+	if err := os.WriteFile(scoresPath, []byte("name,score\nalice,10\nbob,20\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	getTable := func(t *testing.T, tableID string) (int, *bigqueryv2.Table) {
+		t.Helper()
+		res, err := http.Get(fmt.Sprintf("%s/projects/test/datasets/dataset1/tables/%s", testServer.URL, tableID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var table bigqueryv2.Table
+		if err := json.NewDecoder(res.Body).Decode(&table); err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, &table
+	}
+
+	script := fmt.Sprintf(`
+CREATE TABLE dataset1.native_orders (id INT64, amount FLOAT64);
+CREATE EXTERNAL TABLE dataset1.lake_events (id INT64 NOT NULL, kind STRING OPTIONS(description = 'event kind'))
+  WITH CONNECTION `+"`test.us.lake`"+`
+  OPTIONS (format = 'PARQUET', uris = ['gs://bucket/events/*.parquet'], max_staleness = INTERVAL 4 HOUR, metadata_cache_mode = 'AUTOMATIC');
+CREATE EXTERNAL TABLE dataset1.local_scores WITH CONNECTION us.lake
+  OPTIONS (format = 'CSV', uris = ['%s'], skip_leading_rows = 1, description = 'scores');
+CREATE TABLE dataset1.iceberg_orders (id INT64, amount NUMERIC) WITH CONNECTION us.lake
+  OPTIONS (file_format = 'PARQUET', table_format = 'ICEBERG', storage_uri = 'gs://bucket/orders');
+INSERT INTO dataset1.native_orders VALUES (1, 2.5);
+INSERT INTO dataset1.iceberg_orders VALUES (1, 10)`, scoresPath)
+	job, err := client.Query(script).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := status.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("external table on cloud storage", func(t *testing.T) {
+		status, table := getTable(t, "lake_events")
+		if status != http.StatusOK {
+			t.Fatalf("unexpected status %d", status)
+		}
+		if table.Type != "EXTERNAL" {
+			t.Fatalf("expected EXTERNAL table but got %s", table.Type)
+		}
+		config := table.ExternalDataConfiguration
+		if config == nil || config.ConnectionId != "test.us.lake" || config.SourceFormat != "PARQUET" || config.MetadataCacheMode != "AUTOMATIC" {
+			t.Fatalf("unexpected external data configuration %+v", config)
+		}
+		if diff := cmp.Diff([]string{"gs://bucket/events/*.parquet"}, config.SourceUris); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		var fields []string
+		for _, field := range table.Schema.Fields {
+			fields = append(fields, fmt.Sprintf("%s %s %s %s", field.Name, field.Type, field.Mode, field.Description))
+		}
+		if diff := cmp.Diff([]string{"id INTEGER REQUIRED ", "kind STRING NULLABLE event kind"}, fields); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		_, err := client.Query("SELECT * FROM dataset1.lake_events").Read(ctx)
+		if err == nil || !strings.Contains(err.Error(), "the external table reads only the local files") {
+			t.Fatalf("expected the error of the cloud storage but got %v", err)
+		}
+	})
+
+	t.Run("external table on local file", func(t *testing.T) {
+		status, table := getTable(t, "local_scores")
+		if status != http.StatusOK {
+			t.Fatalf("unexpected status %d", status)
+		}
+		if table.ExternalDataConfiguration == nil || table.ExternalDataConfiguration.ConnectionId != "test.us.lake" || table.Description != "scores" {
+			t.Fatalf("unexpected table %+v", table)
+		}
+		it, err := client.Query("SELECT COUNT(*) FROM dataset1.local_scores").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if row[0] != int64(2) {
+			t.Fatalf("expected 2 rows but got %v", row[0])
+		}
+	})
+
+	t.Run("biglake table", func(t *testing.T) {
+		status, table := getTable(t, "iceberg_orders")
+		if status != http.StatusOK {
+			t.Fatalf("unexpected status %d", status)
+		}
+		if table.Type != "TABLE" {
+			t.Fatalf("expected TABLE but got %s", table.Type)
+		}
+		biglake := table.BiglakeConfiguration
+		if biglake == nil || biglake.ConnectionId != "test.us.lake" || biglake.TableFormat != "ICEBERG" || biglake.StorageUri != "gs://bucket/orders" {
+			t.Fatalf("unexpected biglake configuration %+v", biglake)
+		}
+		for _, query := range []string{
+			"SELECT COUNT(*) FROM dataset1.iceberg_orders",
+			"SELECT COUNT(*) FROM dataset1.native_orders",
+		} {
+			it, err := client.Query(query).Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var row []bigquery.Value
+			if err := it.Next(&row); err != nil {
+				t.Fatal(err)
+			}
+			if row[0] != int64(1) {
+				t.Fatalf("expected 1 row of %s but got %v", query, row[0])
+			}
+		}
+	})
+
+	for _, test := range []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{
+			name:  "missing connection",
+			query: "CREATE EXTERNAL TABLE dataset1.missing WITH CONNECTION us.missing OPTIONS (format = 'CSV', uris = ['gs://bucket/a.csv'])",
+			err:   "Not found: Connection test.us.missing",
+		},
+		{
+			name:  "connection name is omitted",
+			query: "CREATE EXTERNAL TABLE dataset1.missing WITH CONNECTION OPTIONS (format = 'CSV', uris = ['gs://bucket/a.csv'])",
+			err:   "expected connection name after WITH CONNECTION",
+		},
+		{
+			name:  "object table",
+			query: "CREATE EXTERNAL TABLE dataset1.objects WITH CONNECTION us.lake OPTIONS (object_metadata = 'SIMPLE', uris = ['gs://bucket/images/*'])",
+			err:   "the object table is not supported by the emulator",
+		},
+		{
+			name:  "columns of cloud storage",
+			query: "CREATE EXTERNAL TABLE dataset1.no_columns WITH CONNECTION us.lake OPTIONS (format = 'CSV', uris = ['gs://bucket/a.csv'])",
+			err:   "must be listed",
+		},
+		{
+			name:  "duplicate",
+			query: "CREATE EXTERNAL TABLE dataset1.native_orders (id INT64) OPTIONS (format = 'CSV', uris = ['gs://bucket/a.csv'])",
+			err:   "Already Exists: Table test:dataset1.native_orders",
+		},
+		{
+			name:  "drop native table",
+			query: "DROP EXTERNAL TABLE dataset1.native_orders",
+			err:   "is not an external table",
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, err := client.Query(test.query).Read(ctx)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q but got %v", test.err, err)
+			}
+		})
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		for _, query := range []string{
+			"DROP EXTERNAL TABLE dataset1.lake_events",
+			"DROP EXTERNAL TABLE IF EXISTS dataset1.lake_events",
+		} {
+			if _, err := client.Query(query).Read(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if status, _ := getTable(t, "lake_events"); status != http.StatusNotFound {
+			t.Fatalf("expected the dropped table to be not found but got %d", status)
+		}
+	})
+}
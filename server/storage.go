@@ -1,8 +1,89 @@
 package server
 
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
 type Storage string
 
 const (
 	MemoryStorage Storage = "file::memory:?cache=shared"
 	TempStorage   Storage = "tmp"
 )
+
+// JournalMode is the SQLite journal mode used for the file-backed storage.
+type JournalMode string
+
+const (
+	JournalModeDelete   JournalMode = "DELETE"
+	JournalModeTruncate JournalMode = "TRUNCATE"
+	JournalModePersist  JournalMode = "PERSIST"
+	JournalModeMemory   JournalMode = "MEMORY"
+	JournalModeWAL      JournalMode = "WAL"
+	JournalModeOff      JournalMode = "OFF"
+)
+
+// SynchronousMode is the SQLite synchronous level used for the file-backed storage.
+type SynchronousMode string
+
+const (
+	SynchronousOff    SynchronousMode = "OFF"
+	SynchronousNormal SynchronousMode = "NORMAL"
+	SynchronousFull   SynchronousMode = "FULL"
+	SynchronousExtra  SynchronousMode = "EXTRA"
+)
+
+// FileStorageOption is the option for the file-backed storage.
+// The zero value keeps the SQLite defaults.
+type FileStorageOption struct {
+	JournalMode JournalMode
+	Synchronous SynchronousMode
+	// BusyTimeout is the time to wait for the lock of the database file held by other connections.
+	BusyTimeout time.Duration
+	// ReadOnly opens the existing database file without write access.
+	ReadOnly bool
+}
+
+// FileStorage creates Storage that persists data to the file at path.
+func FileStorage(path string, opt *FileStorageOption) (Storage, error) {
+	params := []string{"cache=shared"}
+	if opt == nil {
+		opt = &FileStorageOption{}
+	}
+	if opt.JournalMode != "" {
+		mode := JournalMode(strings.ToUpper(string(opt.JournalMode)))
+		switch mode {
+		case JournalModeDelete, JournalModeTruncate, JournalModePersist, JournalModeMemory, JournalModeWAL, JournalModeOff:
+		default:
+			return "", fmt.Errorf("unexpected journal mode %s", opt.JournalMode)
+		}
+		if opt.ReadOnly {
+			// the journal mode cannot be changed without write access.
+			// the read-only database is opened with the journal mode recorded in the file.
+			return "", fmt.Errorf("journal mode cannot be set for the read-only database")
+		}
+		params = append(params, "_journal_mode="+string(mode))
+	}
+	if opt.Synchronous != "" {
+		mode := SynchronousMode(strings.ToUpper(string(opt.Synchronous)))
+		switch mode {
+		case SynchronousOff, SynchronousNormal, SynchronousFull, SynchronousExtra:
+		default:
+			return "", fmt.Errorf("unexpected synchronous mode %s", opt.Synchronous)
+		}
+		params = append(params, "_synchronous="+string(mode))
+	}
+	if opt.BusyTimeout < 0 {
+		return "", fmt.Errorf("busy timeout must not be negative: %s", opt.BusyTimeout)
+	}
+	if opt.BusyTimeout > 0 {
+		params = append(params, fmt.Sprintf("_busy_timeout=%d", opt.BusyTimeout.Milliseconds()))
+	}
+	if opt.ReadOnly {
+		params = append(params, "mode=ro")
+	}
+	return Storage(fmt.Sprintf("file:%s?%s", (&url.URL{Path: path}).EscapedPath(), strings.Join(params, "&"))), nil
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// storageErrorDomain is the domain of the ErrorInfo detail of the Storage API errors.
+const storageErrorDomain = "bigquerystorage.googleapis.com"
+
+// storageErrorReason is the reason of the ErrorInfo detail of the Storage API errors.
+type storageErrorReason string
+
+const (
+	storageErrorReasonTableNotFound      storageErrorReason = "TABLE_NOT_FOUND"
+	storageErrorReasonStreamNotFound     storageErrorReason = "STREAM_NOT_FOUND"
+	storageErrorReasonInvalidPath        storageErrorReason = "INVALID_RESOURCE_NAME"
+	storageErrorReasonInvalidArgument    storageErrorReason = "INVALID_ARGUMENT"
+	storageErrorReasonStreamFinalized    storageErrorReason = "STREAM_FINALIZED"
+	storageErrorReasonOffsetAlreadyExist storageErrorReason = "OFFSET_ALREADY_EXISTS"
+	storageErrorReasonOffsetOutOfRange   storageErrorReason = "OFFSET_OUT_OF_RANGE"
+	storageErrorReasonTooManyStreams     storageErrorReason = "TOO_MANY_STREAMS"
+	storageErrorReasonPermissionDenied   storageErrorReason = "IAM_PERMISSION_DENIED"
+)
+
+// storageError returns the gRPC status error of the Storage API with the ErrorInfo detail.
+// The entity is the resource name the error is about, and is reported as the metadata of the detail.
+// Clients retry the errors by the code, so it must be the one BigQuery returns for the condition.
+func storageError(code codes.Code, reason storageErrorReason, entity string, format string, args ...interface{}) error {
+	st := grpcstatus.New(code, fmt.Sprintf(format, args...))
+	info := &errdetails.ErrorInfo{
+		Reason: string(reason),
+		Domain: storageErrorDomain,
+	}
+	if entity != "" {
+		info.Metadata = map[string]string{"entity": entity}
+	}
+	withDetails, err := st.WithDetails(info)
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// storageErrorFrom returns err as is if it already has the gRPC status,
+// otherwise it returns the error with the code.
+func storageErrorFrom(code codes.Code, err error) error {
+	if _, ok := grpcstatus.FromError(err); ok {
+		return err
+	}
+	return grpcstatus.Error(code, err.Error())
+}
+
+// storageErrorStatus returns the status of the error reported in the AppendRowsResponse.
+func storageErrorStatus(err error) *spb.Status {
+	if st, ok := grpcstatus.FromError(err); ok {
+		return st.Proto()
+	}
+	return grpcstatus.New(codes.Internal, err.Error()).Proto()
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -17,9 +18,9 @@ import (
 	"github.com/goccy/go-json"
 	goavro "github.com/linkedin/goavro/v2"
 	bigqueryv2 "google.golang.org/api/bigquery/v2"
-	"google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
@@ -34,6 +35,9 @@ import (
 	"github.com/goccy/bigquery-emulator/types"
 )
 
+// readSessionLifetime is the time the read session can be read after it is created.
+const readSessionLifetime = time.Hour
+
 var (
 	_ storagepb.BigQueryReadServer  = &storageReadServer{}
 	_ storagepb.BigQueryWriteServer = &storageWriteServer{}
@@ -46,15 +50,24 @@ type storageReadServer struct {
 }
 
 type readStreamStatus struct {
-	projectID     string
-	datasetID     string
-	tableID       string
-	outputColumns []string
-	condition     string
-	dataFormat    storagepb.DataFormat
-	avroSchema    *types.AVROSchema
-	arrowSchema   *arrow.Schema
-	schemaText    string
+	projectID string
+	datasetID string
+	tableID   string
+	// columns are the select list that projects the selected fields of the read options.
+	columns     []string
+	condition   string
+	sessionName string
+	// rows are the snapshot of the rows read by the session taken when the session is created.
+	// The streams of the session share them, so the writes to the table during the read are not visible.
+	rows []*internaltypes.TableRow
+	// offset and rowCount are the range of the rows read by the stream.
+	offset      int64
+	rowCount    int64
+	expireTime  time.Time
+	dataFormat  storagepb.DataFormat
+	avroSchema  *types.AVROSchema
+	arrowSchema *arrow.Schema
+	schemaText  string
 }
 
 type AVROSchema struct {
@@ -78,47 +91,59 @@ func (s *storageReadServer) CreateReadSession(ctx context.Context, req *storagep
 	}
 	tableMetadata, err := getTableMetadata(ctx, s.server, projectID, datasetID, tableID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get table metadata: %w", err)
+		return nil, storageErrorFrom(codes.Internal, err)
 	}
-	streams := make([]*storagepb.ReadStream, 0, req.MaxStreamCount)
-	streamID := randomID()
-	streamName := fmt.Sprintf("%s/streams/%s", sessionName, streamID)
-	if req.MaxStreamCount > 1 {
-		return nil, fmt.Errorf("currently supported only one stream")
+	if err := s.checkReadAccess(ctx, projectID, datasetID, tableID); err != nil {
+		return nil, err
 	}
-	for i := int32(0); i < req.MaxStreamCount; i++ {
-		streams = append(streams, &storagepb.ReadStream{
-			Name: streamName,
-		})
+	now := time.Now()
+	tableModifiers := req.ReadSession.GetTableModifiers()
+	if snapshotTime := tableModifiers.GetSnapshotTime(); snapshotTime != nil {
+		if err := validateSnapshotTime(snapshotTime, tableMetadata, now); err != nil {
+			return nil, err
+		}
+	} else {
+		tableModifiers = &storagepb.ReadSession_TableModifiers{SnapshotTime: timestamppb.New(now)}
 	}
+	expireTime := now.Add(readSessionLifetime)
 	readSession := &storagepb.ReadSession{
 		Name:                       sessionName,
-		ExpireTime:                 timestamppb.New(time.Now().Add(1 * time.Hour)),
-		Streams:                    streams,
+		ExpireTime:                 timestamppb.New(expireTime),
 		EstimatedTotalBytesScanned: 0,
 		DataFormat:                 req.ReadSession.DataFormat,
 		Table:                      req.ReadSession.Table,
 		ReadOptions:                req.ReadSession.ReadOptions,
-		TableModifiers:             req.ReadSession.TableModifiers,
+		TableModifiers:             tableModifiers,
 		TraceId:                    req.ReadSession.TraceId,
 	}
-	outputColumns := req.ReadSession.ReadOptions.SelectedFields
-	condition := req.ReadSession.ReadOptions.RowRestriction
-	outputColumnMap := map[string]struct{}{}
-	for _, outputColumn := range outputColumns {
-		outputColumnMap[outputColumn] = struct{}{}
+	fields, columns, err := projectReadSessionFields(
+		tableMetadata.Schema.Fields,
+		req.ReadSession.GetReadOptions().GetSelectedFields(),
+	)
+	if err != nil {
+		return nil, err
 	}
+	projectedTable := *tableMetadata
+	projectedTable.Schema = &bigqueryv2.TableSchema{Fields: fields}
 	status := &readStreamStatus{
-		projectID:     projectID,
-		datasetID:     datasetID,
-		tableID:       tableID,
-		outputColumns: outputColumns,
-		condition:     condition,
-		dataFormat:    readSession.DataFormat,
+		projectID:  projectID,
+		datasetID:  datasetID,
+		tableID:    tableID,
+		columns:    columns,
+		condition:  req.ReadSession.GetReadOptions().GetRowRestriction(),
+		dataFormat: readSession.DataFormat,
+		expireTime: expireTime,
+	}
+	rows, err := s.snapshotRows(ctx, status)
+	if err != nil {
+		return nil, err
 	}
+	status.rows = rows
+	totalRows := int64(len(rows))
+	readSession.EstimatedRowCount = totalRows
 	switch readSession.DataFormat {
 	case storagepb.DataFormat_AVRO:
-		schema, err := s.getAVROSchema(tableMetadata, outputColumnMap)
+		schema, err := s.getAVROSchema(&projectedTable)
 		if err != nil {
 			return nil, err
 		}
@@ -126,7 +151,7 @@ func (s *storageReadServer) CreateReadSession(ctx context.Context, req *storagep
 		status.avroSchema = schema.Schema
 		status.schemaText = schema.Text
 	case storagepb.DataFormat_ARROW:
-		schema, err := s.getARROWSchema(tableMetadata, outputColumnMap)
+		schema, err := s.getARROWSchema(&projectedTable)
 		if err != nil {
 			return nil, err
 		}
@@ -134,28 +159,125 @@ func (s *storageReadServer) CreateReadSession(ctx context.Context, req *storagep
 		status.arrowSchema = schema.Schema
 		status.schemaText = schema.Text
 	default:
-		return nil, fmt.Errorf("unexpected data format %s", readSession.DataFormat)
+		return nil, storageError(
+			codes.InvalidArgument, storageErrorReasonInvalidArgument, req.ReadSession.Table,
+			"unexpected data format %s", readSession.DataFormat,
+		)
+	}
+	streamCount := int64(req.MaxStreamCount)
+	if streamCount <= 0 {
+		// the server decides the number of streams if MaxStreamCount is not specified.
+		streamCount = 1
+	}
+	if streamCount > totalRows {
+		// every stream has at least one row, so the empty table has no streams as BigQuery does.
+		streamCount = totalRows
 	}
 	s.mu.Lock()
-	s.streamMap[streamName] = status
-	s.mu.Unlock()
+	defer s.mu.Unlock()
+	s.removeExpiredStreams(now)
+	for i := int64(0); i < streamCount; i++ {
+		start := totalRows * i / streamCount
+		end := totalRows * (i + 1) / streamCount
+		readSession.Streams = append(readSession.Streams, s.addStream(sessionName, status, start, end-start))
+	}
 	return readSession, nil
 }
 
+// checkReadAccess returns the PermissionDenied error if the user of ctx can't read the table by the dataset access.
+func (s *storageReadServer) checkReadAccess(ctx context.Context, projectID, datasetID, tableID string) error {
+	project, err := s.server.metaRepo.FindProject(ctx, projectID)
+	if err != nil {
+		return storageErrorFrom(codes.Internal, err)
+	}
+	dataset := project.Dataset(datasetID)
+	if err := s.server.checkTableDataAccess(ctx, dataset, tableID); err != nil {
+		msg := err.Error()
+		var serverErr *ServerError
+		if errors.As(err, &serverErr) {
+			msg = serverErr.Message
+		}
+		return storageError(
+			codes.PermissionDenied, storageErrorReasonPermissionDenied,
+			fmt.Sprintf("projects/%s/datasets/%s/tables/%s", projectID, datasetID, tableID), "%s", msg,
+		)
+	}
+	return nil
+}
+
+// removeExpiredStreams releases the snapshots of the expired sessions.
+// The caller must hold the lock of the stream map.
+func (s *storageReadServer) removeExpiredStreams(now time.Time) {
+	for name, status := range s.streamMap {
+		if now.After(status.expireTime) {
+			delete(s.streamMap, name)
+		}
+	}
+}
+
+// snapshotTimeTolerance is how long before the session is created the snapshot time can be.
+// It covers the snapshot time the client takes as the current time before sending the request.
+const snapshotTimeTolerance = time.Second
+
+// validateSnapshotTime checks the snapshot time of the table modifiers.
+// The emulator doesn't keep the history of the tables, so the snapshot time in the past is not supported
+// except for the time within snapshotTimeTolerance, which reads the rows at the time the session is created.
+func validateSnapshotTime(snapshotTime *timestamppb.Timestamp, tableMetadata *bigqueryv2.Table, now time.Time) error {
+	if err := snapshotTime.CheckValid(); err != nil {
+		return storageError(codes.InvalidArgument, storageErrorReasonInvalidArgument, "", "invalid snapshot time: %s", err)
+	}
+	t := snapshotTime.AsTime()
+	if t.After(now) {
+		return storageError(codes.InvalidArgument, storageErrorReasonInvalidArgument, "", "snapshot time %s is in the future", t.Format(time.RFC3339Nano))
+	}
+	if tableMetadata.CreationTime != 0 && t.Before(time.Unix(tableMetadata.CreationTime, 0)) {
+		return storageError(
+			codes.InvalidArgument, storageErrorReasonInvalidArgument, "",
+			"table %s does not exist at snapshot time %s",
+			tableMetadata.TableReference.TableId, t.Format(time.RFC3339Nano),
+		)
+	}
+	if t.Before(now.Add(-snapshotTimeTolerance)) {
+		return storageError(
+			codes.Unimplemented, storageErrorReasonInvalidArgument, "",
+			"snapshot time %s in the past is not supported: the emulator doesn't keep the history of the table %s",
+			t.Format(time.RFC3339Nano), tableMetadata.TableReference.TableId,
+		)
+	}
+	return nil
+}
+
+// addStream registers the stream that reads rowCount rows from offset of the rows read by the session.
+// The caller must hold the lock of the stream map.
+func (s *storageReadServer) addStream(sessionName string, status *readStreamStatus, offset, rowCount int64) *storagepb.ReadStream {
+	streamName := fmt.Sprintf("%s/streams/%s", sessionName, randomID())
+	streamStatus := *status
+	streamStatus.sessionName = sessionName
+	streamStatus.offset = offset
+	streamStatus.rowCount = rowCount
+	s.streamMap[streamName] = &streamStatus
+	return &storagepb.ReadStream{Name: streamName}
+}
+
 func (s *storageReadServer) ReadRows(req *storagepb.ReadRowsRequest, stream storagepb.BigQueryRead_ReadRowsServer) error {
 	s.mu.RLock()
 	status := s.streamMap[req.ReadStream]
 	s.mu.RUnlock()
 
-	if status == nil {
-		return fmt.Errorf("failed to find stream status from %s", req.ReadStream)
+	if status == nil || time.Now().After(status.expireTime) {
+		// the stream of the expired session is not found as BigQuery deletes the session.
+		return storageError(codes.NotFound, storageErrorReasonStreamNotFound, req.ReadStream, "failed to find stream status from %s", req.ReadStream)
 	}
-	ctx := context.Background()
-	ctx = logger.WithLogger(ctx, s.server.logger)
-
-	response, err := s.query(ctx, status)
-	if err != nil {
-		return err
+	if req.Offset < 0 || req.Offset > status.rowCount {
+		return storageError(
+			codes.OutOfRange, storageErrorReasonOffsetOutOfRange, req.ReadStream,
+			"offset %d is out of the range of the stream %s", req.Offset, req.ReadStream,
+		)
+	}
+	rows := status.rows[status.offset+req.Offset : status.offset+status.rowCount]
+	response := &internaltypes.QueryResponse{
+		Rows:      rows,
+		TotalRows: uint64(len(rows)),
 	}
 	switch status.dataFormat {
 	case storagepb.DataFormat_AVRO:
@@ -170,26 +292,51 @@ func (s *storageReadServer) ReadRows(req *storagepb.ReadRowsRequest, stream stor
 	return nil
 }
 
+// SplitReadStream splits the rows of the stream at the fraction into the primary and the remainder streams.
+// The original stream can still be read. If the stream has too few rows to split, the response has no streams.
 func (s *storageReadServer) SplitReadStream(ctx context.Context, req *storagepb.SplitReadStreamRequest) (*storagepb.SplitReadStreamResponse, error) {
-	return nil, fmt.Errorf("unimplemented split read stream")
+	if req.Fraction < 0 || req.Fraction >= 1 {
+		return nil, storageError(codes.InvalidArgument, storageErrorReasonInvalidArgument, req.Name, "fraction must be in the range [0, 1) but got %v", req.Fraction)
+	}
+	fraction := req.Fraction
+	if fraction == 0 {
+		fraction = 0.5
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.streamMap[req.Name]
+	if status == nil || time.Now().After(status.expireTime) {
+		return nil, storageError(codes.NotFound, storageErrorReasonStreamNotFound, req.Name, "failed to find stream status from %s", req.Name)
+	}
+	primaryRows := int64(float64(status.rowCount) * fraction)
+	if primaryRows <= 0 || primaryRows >= status.rowCount {
+		return &storagepb.SplitReadStreamResponse{}, nil
+	}
+	return &storagepb.SplitReadStreamResponse{
+		PrimaryStream:   s.addStream(status.sessionName, status, status.offset, primaryRows),
+		RemainderStream: s.addStream(status.sessionName, status, status.offset+primaryRows, status.rowCount-primaryRows),
+	}, nil
 }
 
 func (s *storageReadServer) buildQuery(status *readStreamStatus) string {
-	var columns string
-	if len(status.outputColumns) != 0 {
-		outputColumns := make([]string, len(status.outputColumns))
-		for idx, outputColumn := range status.outputColumns {
-			outputColumns[idx] = fmt.Sprintf("`%s`", outputColumn)
-		}
-		columns = strings.Join(outputColumns, ",")
-	} else {
-		columns = "*"
-	}
 	var condition string
 	if status.condition != "" {
 		condition = fmt.Sprintf("WHERE %s", status.condition)
 	}
-	return fmt.Sprintf("SELECT %s FROM `%s` %s", columns, status.tableID, condition)
+	return fmt.Sprintf("SELECT %s FROM `%s` %s", strings.Join(status.columns, ","), status.tableID, condition)
+}
+
+// snapshotRows reads the rows of the session to serve them from the streams.
+// The invalid row restriction fails CreateReadSession with INVALID_ARGUMENT as BigQuery does.
+func (s *storageReadServer) snapshotRows(ctx context.Context, status *readStreamStatus) ([]*internaltypes.TableRow, error) {
+	response, err := s.query(ctx, status)
+	if err != nil {
+		if status.condition != "" {
+			return nil, storageError(codes.InvalidArgument, storageErrorReasonInvalidArgument, status.tableID, "invalid row restriction %q: %s", status.condition, err)
+		}
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return response.Rows, nil
 }
 
 func (s *storageReadServer) query(ctx context.Context, status *readStreamStatus) (*internaltypes.QueryResponse, error) {
@@ -214,17 +361,8 @@ func (s *storageReadServer) query(ctx context.Context, status *readStreamStatus)
 	)
 }
 
-func (s *storageReadServer) getAVROSchema(tableMetadata *bigqueryv2.Table, outputColumnMap map[string]struct{}) (*AVROSchema, error) {
+func (s *storageReadServer) getAVROSchema(tableMetadata *bigqueryv2.Table) (*AVROSchema, error) {
 	avroSchema := types.TableToAVRO(tableMetadata)
-	if len(outputColumnMap) != 0 {
-		filteredFields := make([]*types.AVROFieldSchema, 0, len(avroSchema.Fields))
-		for _, field := range avroSchema.Fields {
-			if _, exists := outputColumnMap[field.Name]; exists {
-				filteredFields = append(filteredFields, field)
-			}
-		}
-		avroSchema.Fields = filteredFields
-	}
 	schema, err := json.Marshal(avroSchema)
 	if err != nil {
 		return nil, err
@@ -276,20 +414,11 @@ func (s *storageReadServer) sendAVRORows(status *readStreamStatus, response *int
 	return nil
 }
 
-func (s *storageReadServer) getARROWSchema(tableMetadata *bigqueryv2.Table, outputColumnMap map[string]struct{}) (*ARROWSchema, error) {
+func (s *storageReadServer) getARROWSchema(tableMetadata *bigqueryv2.Table) (*ARROWSchema, error) {
 	arrowSchema, err := types.TableToARROW(tableMetadata)
 	if err != nil {
 		return nil, err
 	}
-	if len(outputColumnMap) != 0 {
-		filteredFields := make([]arrow.Field, 0, len(arrowSchema.Fields()))
-		for _, field := range arrowSchema.Fields() {
-			if _, exists := outputColumnMap[field.Name]; exists {
-				filteredFields = append(filteredFields, field)
-			}
-		}
-		arrowSchema = arrow.NewSchema(filteredFields, nil)
-	}
 	schemaText := arrowSchema.String()
 	schema, err := s.getSerializedARROWSchema(arrowSchema)
 	if err != nil {
@@ -380,7 +509,9 @@ type writeStreamStatus struct {
 	tableID       string
 	tableMetadata *bigqueryv2.Table
 	rows          types.Data
-	finalized     bool
+	// appendedRows is the number of the rows appended to the stream, that is the offset of the next append.
+	appendedRows int64
+	finalized    bool
 }
 
 func (s *storageWriteServer) CreateWriteStream(ctx context.Context, req *storagepb.CreateWriteStreamRequest) (*storagepb.WriteStream, error) {
@@ -388,9 +519,15 @@ func (s *storageWriteServer) CreateWriteStream(ctx context.Context, req *storage
 	if err != nil {
 		return nil, err
 	}
+	if s.server.isSharedDataset(projectID, datasetID) {
+		return nil, storageError(
+			codes.PermissionDenied, storageErrorReasonPermissionDenied, req.Parent,
+			"the shared dataset %s:%s loaded from the datasets directory is read-only", projectID, datasetID,
+		)
+	}
 	tableMetadata, err := getTableMetadata(ctx, s.server, projectID, datasetID, tableID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get table metadata: %w", err)
+		return nil, storageErrorFrom(codes.Internal, err)
 	}
 	streamID := randomID()
 	streamName := fmt.Sprintf("%s/streams/%s", req.Parent, streamID)
@@ -400,6 +537,15 @@ func (s *storageWriteServer) CreateWriteStream(ctx context.Context, req *storage
 	if streamType == storagepb.WriteStream_COMMITTED {
 		commitTime = createTime
 	}
+	s.mu.RLock()
+	activeStreams := s.activeStreamCount()
+	s.mu.RUnlock()
+	if maxStreams := s.server.maxWriteStreams; maxStreams > 0 && activeStreams >= maxStreams {
+		return nil, storageError(
+			codes.ResourceExhausted, storageErrorReasonTooManyStreams, req.Parent,
+			"Exceeds quota limit of %d active write streams", maxStreams,
+		)
+	}
 	schema := types.TableToProto(tableMetadata)
 	stream := &storagepb.WriteStream{
 		Name:        streamName,
@@ -422,6 +568,18 @@ func (s *storageWriteServer) CreateWriteStream(ctx context.Context, req *storage
 	return stream, nil
 }
 
+// activeStreamCount returns the number of the write streams that are not finalized yet.
+// The caller must hold the lock of the stream map.
+func (s *storageWriteServer) activeStreamCount() int {
+	var count int
+	for _, status := range s.streamMap {
+		if !status.finalized {
+			count++
+		}
+	}
+	return count
+}
+
 func (s *storageWriteServer) AppendRows(stream storagepb.BigQueryWrite_AppendRowsServer) error {
 	req, err := stream.Recv()
 	if err == io.EOF {
@@ -434,7 +592,9 @@ func (s *storageWriteServer) AppendRows(stream storagepb.BigQueryWrite_AppendRow
 	if err != nil {
 		return err
 	}
-	if err := s.appendRows(req, msgDesc, stream); err != nil {
+	// the following requests of the connection may omit the stream name of the first request.
+	streamName := req.GetWriteStream()
+	if err := s.appendRows(req, streamName, msgDesc, stream); err != nil {
 		return fmt.Errorf("failed to append rows: %w", err)
 	}
 	for {
@@ -445,7 +605,10 @@ func (s *storageWriteServer) AppendRows(stream storagepb.BigQueryWrite_AppendRow
 		if err != nil {
 			return err
 		}
-		if err := s.appendRows(req, msgDesc, stream); err != nil {
+		if req.GetWriteStream() != "" {
+			streamName = req.GetWriteStream()
+		}
+		if err := s.appendRows(req, streamName, msgDesc, stream); err != nil {
 			return fmt.Errorf("failed to append rows: %w", err)
 		}
 	}
@@ -462,13 +625,15 @@ func (s *storageWriteServer) getMessageDescriptor(req *storagepb.AppendRowsReque
 	}
 	fd, err := protodesc.NewFile(fdProto, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create file descriptor: %w", err)
+		return nil, storageError(
+			codes.InvalidArgument, storageErrorReasonInvalidArgument, req.GetWriteStream(),
+			"invalid writer schema: %s", err,
+		)
 	}
 	return fd.Messages().ByName(protoreflect.Name(descProto.GetName())), nil
 }
 
-func (s *storageWriteServer) appendRows(req *storagepb.AppendRowsRequest, msgDesc protoreflect.MessageDescriptor, stream storagepb.BigQueryWrite_AppendRowsServer) error {
-	streamName := req.GetWriteStream()
+func (s *storageWriteServer) appendRows(req *storagepb.AppendRowsRequest, streamName string, msgDesc protoreflect.MessageDescriptor, stream storagepb.BigQueryWrite_AppendRowsServer) error {
 	s.mu.RLock()
 	var status *writeStreamStatus
 	if streamName == "" {
@@ -477,23 +642,44 @@ func (s *storageWriteServer) appendRows(req *storagepb.AppendRowsRequest, msgDes
 			break
 		}
 	} else {
-		s, exists := s.streamMap[streamName]
+		st, exists := s.streamMap[streamName]
 		if !exists {
-			return fmt.Errorf("failed to get stream from %s", streamName)
+			s.mu.RUnlock()
+			return storageError(codes.NotFound, storageErrorReasonStreamNotFound, streamName, "failed to get stream from %s", streamName)
 		}
-		status = s
+		status = st
 	}
 	s.mu.RUnlock()
+	if status == nil {
+		return storageError(codes.NotFound, storageErrorReasonStreamNotFound, streamName, "failed to find the stream to append rows")
+	}
 	if status.finalized {
-		return fmt.Errorf("stream is already finalized")
+		return storageError(
+			codes.FailedPrecondition, storageErrorReasonStreamFinalized, status.stream.Name,
+			"stream %s is already finalized", status.stream.Name,
+		)
 	}
-	offset := int64(0)
+	offset := status.appendedRows
 	if req.GetOffset() != nil {
+		// the rows are appended exactly at the end of the stream, so the retried request is detected as BigQuery does.
 		offset = req.GetOffset().Value
+		if offset < status.appendedRows {
+			return storageError(
+				codes.AlreadyExists, storageErrorReasonOffsetAlreadyExist, status.stream.Name,
+				"offset %d is already appended to the stream %s whose current offset is %d", offset, status.stream.Name, status.appendedRows,
+			)
+		}
+		if offset > status.appendedRows {
+			return storageError(
+				codes.OutOfRange, storageErrorReasonOffsetOutOfRange, status.stream.Name,
+				"offset %d is beyond the end of the stream %s whose current offset is %d", offset, status.stream.Name, status.appendedRows,
+			)
+		}
 	}
 	rows := req.GetProtoRows().GetRows().GetSerializedRows()
 	data, err := s.decodeData(msgDesc, rows)
 	if err != nil {
+		err = storageError(codes.InvalidArgument, storageErrorReasonInvalidArgument, status.stream.Name, "invalid rows: %s", err)
 		s.sendErrorMessage(stream, streamName, err)
 		return err
 	}
@@ -523,7 +709,8 @@ func (s *storageWriteServer) appendRows(req *storagepb.AppendRowsRequest, msgDes
 	} else {
 		status.rows = append(status.rows, data...)
 	}
-	return s.sendResult(stream, streamName, offset+int64(len(rows)))
+	status.appendedRows = offset + int64(len(rows))
+	return s.sendResult(stream, streamName, status.appendedRows)
 
 }
 
@@ -542,10 +729,7 @@ func (s *storageWriteServer) sendErrorMessage(stream storagepb.BigQueryWrite_App
 	return stream.Send(&storagepb.AppendRowsResponse{
 		WriteStream: streamName,
 		Response: &storagepb.AppendRowsResponse_Error{
-			Error: &status.Status{
-				Code:    int32(codes.Internal),
-				Message: err.Error(),
-			},
+			Error: storageErrorStatus(err),
 		},
 	})
 }
@@ -682,9 +866,9 @@ func (s *storageWriteServer) GetWriteStream(ctx context.Context, req *storagepb.
 	if !exists {
 		stream, err := s.createDefaultStream(ctx, req)
 		if err != nil {
-			return nil, fmt.Errorf("failed to find stream from %s", req.Name)
+			return nil, err
 		}
-		return stream, err
+		return stream, nil
 	}
 	return status.stream, nil
 }
@@ -694,7 +878,7 @@ func (s *storageWriteServer) FinalizeWriteStream(ctx context.Context, req *stora
 	defer s.mu.RUnlock()
 	status, exists := s.streamMap[req.GetName()]
 	if !exists {
-		return nil, fmt.Errorf("failed to get stream from %s", req.GetName())
+		return nil, storageError(codes.NotFound, storageErrorReasonStreamNotFound, req.GetName(), "failed to get stream from %s", req.GetName())
 	}
 	status.finalized = true
 	return &storagepb.FinalizeWriteStreamResponse{
@@ -756,9 +940,15 @@ func (s *storageWriteServer) FlushRows(ctx context.Context, req *storagepb.Flush
 	status, exists := s.streamMap[streamName]
 	s.mu.RUnlock()
 	if !exists {
-		return nil, fmt.Errorf("failed to find stream from %s", streamName)
+		return nil, storageError(codes.NotFound, storageErrorReasonStreamNotFound, streamName, "failed to find stream from %s", streamName)
+	}
+	offset := req.GetOffset().GetValue()
+	if offset < 0 || offset >= int64(len(status.rows)) {
+		return nil, storageError(
+			codes.OutOfRange, storageErrorReasonOffsetOutOfRange, streamName,
+			"offset %d is out of the range of the buffered rows of the stream %s", offset, streamName,
+		)
 	}
-	offset := req.GetOffset().Value
 	conn, err := s.server.connMgr.Connection(ctx, status.projectID, status.datasetID)
 	if err != nil {
 		return nil, err
@@ -792,11 +982,14 @@ func (s *storageWriteServer) createDefaultStream(ctx context.Context, req *stora
 	suffix := "_default"
 	streams := "/streams/"
 	if !strings.HasSuffix(streamId, suffix) {
-		return nil, fmt.Errorf("unexpected stream id: %s, expected '%s' suffix", streamId, suffix)
+		return nil, storageError(codes.NotFound, storageErrorReasonStreamNotFound, streamId, "failed to find stream from %s", streamId)
 	}
 	index := strings.LastIndex(streamId, streams)
 	if index == -1 {
-		return nil, fmt.Errorf("unexpected stream id: %s, expected containg '%s'", streamId, streams)
+		return nil, storageError(
+			codes.InvalidArgument, storageErrorReasonInvalidPath, streamId,
+			"unexpected stream id: %s, expected containg '%s'", streamId, streams,
+		)
 	}
 	streamPart := streamId[:index]
 	writeStreamReq := &storagepb.CreateWriteStreamRequest{
@@ -834,7 +1027,7 @@ func (s *storageWriteServer) createDefaultStream(ctx context.Context, req *stora
 func getIDsFromPath(path string) (string, string, string, error) {
 	paths := strings.Split(path, "/")
 	if len(paths)%2 != 0 {
-		return "", "", "", fmt.Errorf("unexpected table path: %s", path)
+		return "", "", "", storageError(codes.InvalidArgument, storageErrorReasonInvalidPath, path, "unexpected table path: %s", path)
 	}
 	var (
 		projectID string
@@ -852,13 +1045,13 @@ func getIDsFromPath(path string) (string, string, string, error) {
 		}
 	}
 	if projectID == "" {
-		return "", "", "", fmt.Errorf("unspecified project id")
+		return "", "", "", storageError(codes.InvalidArgument, storageErrorReasonInvalidPath, path, "unspecified project id in %s", path)
 	}
 	if datasetID == "" {
-		return "", "", "", fmt.Errorf("unspecified dataset id")
+		return "", "", "", storageError(codes.InvalidArgument, storageErrorReasonInvalidPath, path, "unspecified dataset id in %s", path)
 	}
 	if tableID == "" {
-		return "", "", "", fmt.Errorf("unspecified table id")
+		return "", "", "", storageError(codes.InvalidArgument, storageErrorReasonInvalidPath, path, "unspecified table id in %s", path)
 	}
 	return projectID, datasetID, tableID, nil
 }
@@ -868,13 +1061,17 @@ func getTableMetadata(ctx context.Context, server *Server, projectID, datasetID,
 	if err != nil {
 		return nil, err
 	}
+	tablePath := fmt.Sprintf("projects/%s/datasets/%s/tables/%s", projectID, datasetID, tableID)
+	if project == nil {
+		return nil, storageError(codes.NotFound, storageErrorReasonTableNotFound, tablePath, "Not found: Project %s", projectID)
+	}
 	dataset := project.Dataset(datasetID)
 	if dataset == nil {
-		return nil, fmt.Errorf("dataset %s is not found in project %s", datasetID, projectID)
+		return nil, storageError(codes.NotFound, storageErrorReasonTableNotFound, tablePath, "Not found: Dataset %s:%s", projectID, datasetID)
 	}
 	table := dataset.Table(tableID)
 	if table == nil {
-		return nil, fmt.Errorf("table %s is not found in dataset %s", tableID, datasetID)
+		return nil, storageError(codes.NotFound, storageErrorReasonTableNotFound, tablePath, "Not found: Table %s:%s.%s", projectID, datasetID, tableID)
 	}
 	return new(tablesGetHandler).Handle(ctx, &tablesGetRequest{
 		server:  server,
@@ -899,4 +1096,21 @@ func registerStorageServer(grpcServer *grpc.Server, srv *Server) {
 			streamMap: map[string]*writeStreamStatus{},
 		},
 	)
+	if srv.grpcReflection {
+		reflection.Register(grpcServer)
+	}
+}
+
+// SetMaxWriteStreams sets the maximum number of the write streams of the Storage Write API that are not finalized.
+// CreateWriteStream fails with RESOURCE_EXHAUSTED if it exceeds the limit, like the quota of BigQuery.
+// Zero ( default ) means no limit.
+func (s *Server) SetMaxWriteStreams(n int) {
+	s.maxWriteStreams = n
+}
+
+// SetGRPCReflection sets whether the gRPC server exposes the server reflection service.
+// It lets the tools like grpcurl discover the BigQueryRead and BigQueryWrite services without the proto files.
+// It is disabled by default, and must be set before the server starts.
+func (s *Server) SetGRPCReflection(enabled bool) {
+	s.grpcReflection = enabled
 }
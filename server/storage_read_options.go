@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fieldSelection is the tree of the selected fields of the read session.
+type fieldSelection struct {
+	// all is true if the field is selected with all of its subfields.
+	all bool
+	// path is the selected field path as specified in the read options.
+	path     string
+	children map[string]*fieldSelection
+}
+
+func newFieldSelection(selectedFields []string) *fieldSelection {
+	root := &fieldSelection{children: map[string]*fieldSelection{}}
+	for _, selectedField := range selectedFields {
+		node := root
+		parts := strings.Split(selectedField, ".")
+		for idx, part := range parts {
+			if node.all {
+				break
+			}
+			key := strings.ToLower(part)
+			child, exists := node.children[key]
+			if !exists {
+				child = &fieldSelection{
+					path:     strings.Join(parts[:idx+1], "."),
+					children: map[string]*fieldSelection{},
+				}
+				node.children[key] = child
+			}
+			node = child
+		}
+		node.all = true
+		node.children = nil
+	}
+	return root
+}
+
+// projectReadSessionFields returns the fields of the table projected by the selected fields of the read options
+// and the expressions that read them from the table.
+// The selected field is the column name or the path to the nested field like `record.field`.
+// As BigQuery does, the projected fields keep the order of the table schema and the record keeps only the selected subfields.
+func projectReadSessionFields(fields []*bigqueryv2.TableFieldSchema, selectedFields []string) ([]*bigqueryv2.TableFieldSchema, []string, error) {
+	if len(selectedFields) == 0 {
+		return projectFields(fields, &fieldSelection{all: true}, "")
+	}
+	for _, selectedField := range selectedFields {
+		if selectedField == "" {
+			return nil, nil, status.Error(codes.InvalidArgument, "selected field must not be empty")
+		}
+	}
+	return projectFields(fields, newFieldSelection(selectedFields), "")
+}
+
+func projectFields(fields []*bigqueryv2.TableFieldSchema, selection *fieldSelection, base string) ([]*bigqueryv2.TableFieldSchema, []string, error) {
+	var (
+		projected []*bigqueryv2.TableFieldSchema
+		exprs     []string
+		matched   = map[string]struct{}{}
+	)
+	for _, field := range fields {
+		key := strings.ToLower(field.Name)
+		child := selection
+		if !selection.all {
+			child = selection.children[key]
+			if child == nil {
+				continue
+			}
+			matched[key] = struct{}{}
+		}
+		expr := fmt.Sprintf("`%s`", field.Name)
+		if base != "" {
+			expr = fmt.Sprintf("%s.%s", base, expr)
+		}
+		if child.all {
+			projected = append(projected, field)
+			exprs = append(exprs, expr)
+			continue
+		}
+		if field.Type != "RECORD" && field.Type != "STRUCT" {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "field %s is not a record and has no subfields", child.path)
+		}
+		if field.Mode == "REPEATED" {
+			subFields, subExprs, err := projectFields(field.Fields, child, "`_elem`")
+			if err != nil {
+				return nil, nil, err
+			}
+			expr = fmt.Sprintf("ARRAY(SELECT AS STRUCT %s FROM UNNEST(%s) AS `_elem`)", aliasedColumns(subFields, subExprs), expr)
+			projected = append(projected, projectedRecordField(field, subFields))
+			exprs = append(exprs, expr)
+			continue
+		}
+		subFields, subExprs, err := projectFields(field.Fields, child, expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		// keep the NULL record as NULL instead of the record whose fields are all NULL.
+		expr = fmt.Sprintf("IF(%[1]s IS NULL, NULL, STRUCT(%[2]s))", expr, aliasedColumns(subFields, subExprs))
+		projected = append(projected, projectedRecordField(field, subFields))
+		exprs = append(exprs, expr)
+	}
+	if !selection.all && len(matched) != len(selection.children) {
+		for key, child := range selection.children {
+			if _, exists := matched[key]; !exists {
+				return nil, nil, status.Errorf(codes.InvalidArgument, "field %s not found in the table schema", child.path)
+			}
+		}
+	}
+	return projected, exprs, nil
+}
+
+func projectedRecordField(field *bigqueryv2.TableFieldSchema, subFields []*bigqueryv2.TableFieldSchema) *bigqueryv2.TableFieldSchema {
+	projected := *field
+	projected.Fields = subFields
+	return &projected
+}
+
+// aliasedColumns returns the select list that names each expression by the field.
+func aliasedColumns(fields []*bigqueryv2.TableFieldSchema, exprs []string) string {
+	columns := make([]string, len(fields))
+	for idx, field := range fields {
+		columns[idx] = fmt.Sprintf("%s AS `%s`", exprs[idx], field.Name)
+	}
+	return strings.Join(columns, ", ")
+}
@@ -8,6 +8,8 @@ import (
 	"io"
 	"math/rand"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -23,6 +25,7 @@ import (
 	"github.com/apache/arrow/go/v10/arrow/memory"
 	"github.com/goccy/bigquery-emulator/server"
 	"github.com/goccy/go-json"
+	"github.com/google/go-cmp/cmp"
 	gax "github.com/googleapis/gax-go/v2"
 	goavro "github.com/linkedin/goavro/v2"
 	"google.golang.org/api/iterator"
@@ -30,8 +33,12 @@ import (
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/goccy/bigquery-emulator/types"
 )
@@ -221,6 +228,417 @@ func TestStorageReadARROW(t *testing.T) {
 	wg.Wait()
 }
 
+func TestStorageReadOptions(t *testing.T) {
+	const (
+		project = "test"
+		dataset = "dataset1"
+		table   = "table_a"
+	)
+	ctx := context.Background()
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	testServer := startTestServer(t, bqServer)
+	opts, err := testServer.GRPCClientOptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bqReadClient, err := bqStorage.NewBigQueryReadClient(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bqReadClient.Close()
+
+	createReadSession := func(readOptions *storagepb.ReadSession_TableReadOptions) (*storagepb.ReadSession, error) {
+		return bqReadClient.CreateReadSession(ctx, &storagepb.CreateReadSessionRequest{
+			Parent: fmt.Sprintf("projects/%s", project),
+			ReadSession: &storagepb.ReadSession{
+				Table:       fmt.Sprintf("projects/%s/datasets/%s/tables/%s", project, dataset, table),
+				DataFormat:  storagepb.DataFormat_AVRO,
+				ReadOptions: readOptions,
+			},
+			MaxStreamCount: 1,
+		}, rpcOpts)
+	}
+
+	for _, test := range []struct {
+		name         string
+		selected     []string
+		restriction  string
+		expectedRows []interface{}
+	}{
+		{
+			name:        "restriction on the column not selected",
+			selected:    []string{"name"},
+			restriction: "skillNum > 4",
+			expectedRows: []interface{}{
+				map[string]interface{}{"name": "bob"},
+			},
+		},
+		{
+			name:        "projection keeps the order of the table",
+			selected:    []string{"name", "id"},
+			restriction: "id <= 2",
+			expectedRows: []interface{}{
+				map[string]interface{}{"id": int64(1), "name": "alice"},
+				map[string]interface{}{"id": int64(2), "name": "bob"},
+			},
+		},
+		{
+			name:        "nested field",
+			selected:    []string{"id", "structarr.key"},
+			restriction: "name = 'alice'",
+			expectedRows: []interface{}{
+				map[string]interface{}{
+					"id": int64(1),
+					"structarr": []interface{}{
+						map[string]interface{}{"key": map[string]interface{}{"string": "profile"}},
+					},
+				},
+			},
+		},
+		{
+			name:         "no matching rows",
+			selected:     []string{"id"},
+			restriction:  "id > 10",
+			expectedRows: nil,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			session, err := createReadSession(&storagepb.ReadSession_TableReadOptions{
+				SelectedFields: test.selected,
+				RowRestriction: test.restriction,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, err := goavro.NewCodec(session.GetAvroSchema().GetSchema())
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream, err := bqReadClient.ReadRows(ctx, &storagepb.ReadRowsRequest{
+				ReadStream: session.GetStreams()[0].Name,
+			}, rpcOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rows []interface{}
+			for {
+				res, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				undecoded := res.GetAvroRows().GetSerializedBinaryRows()
+				for len(undecoded) > 0 {
+					row, remaining, err := codec.NativeFromBinary(undecoded)
+					if err != nil {
+						t.Fatal(err)
+					}
+					rows = append(rows, row)
+					undecoded = remaining
+				}
+			}
+			if diff := cmp.Diff(test.expectedRows, rows); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, test := range []struct {
+		name        string
+		selected    []string
+		restriction string
+	}{
+		{name: "invalid restriction syntax", restriction: "id = "},
+		{name: "unknown column in restriction", restriction: "unknown = 1"},
+		{name: "unknown selected field", selected: []string{"id", "unknown"}},
+		{name: "subfield of the scalar field", selected: []string{"name.first"}},
+		{name: "unknown subfield", selected: []string{"structarr.unknown"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := createReadSession(&storagepb.ReadSession_TableReadOptions{
+				SelectedFields: test.selected,
+				RowRestriction: test.restriction,
+			})
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("expected InvalidArgument error but got %v", err)
+			}
+		})
+	}
+}
+
+func TestStorageReadStreams(t *testing.T) {
+	const (
+		project = "test"
+		dataset = "dataset1"
+	)
+	ctx := context.Background()
+	data := make(types.Data, 0, 10)
+	for id := 1; id <= 10; id++ {
+		data = append(data, map[string]interface{}{"id": id})
+	}
+	bqServer := newTestServer(
+		t,
+		server.StructSource(
+			types.NewProject(
+				project,
+				types.NewDataset(
+					dataset,
+					types.NewTable("table_a", []*types.Column{types.NewColumn("id", types.INTEGER)}, data),
+					types.NewTable("empty", []*types.Column{types.NewColumn("id", types.INTEGER)}, nil),
+				),
+			),
+		),
+	)
+	testServer := startTestServer(t, bqServer)
+	opts, err := testServer.GRPCClientOptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bqReadClient, err := bqStorage.NewBigQueryReadClient(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bqReadClient.Close()
+
+	createReadSession := func(t *testing.T, table string, maxStreamCount int32) *storagepb.ReadSession {
+		session, err := bqReadClient.CreateReadSession(ctx, &storagepb.CreateReadSessionRequest{
+			Parent: fmt.Sprintf("projects/%s", project),
+			ReadSession: &storagepb.ReadSession{
+				Table:      fmt.Sprintf("projects/%s/datasets/%s/tables/%s", project, dataset, table),
+				DataFormat: storagepb.DataFormat_AVRO,
+			},
+			MaxStreamCount: maxStreamCount,
+		}, rpcOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+	readIDs := func(t *testing.T, session *storagepb.ReadSession, streams ...*storagepb.ReadStream) []int64 {
+		codec, err := goavro.NewCodec(session.GetAvroSchema().GetSchema())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, readStream := range streams {
+			stream, err := bqReadClient.ReadRows(ctx, &storagepb.ReadRowsRequest{ReadStream: readStream.Name}, rpcOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for {
+				res, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				undecoded := res.GetAvroRows().GetSerializedBinaryRows()
+				for len(undecoded) > 0 {
+					row, remaining, err := codec.NativeFromBinary(undecoded)
+					if err != nil {
+						t.Fatal(err)
+					}
+					id := row.(map[string]interface{})["id"].(map[string]interface{})["long"].(int64)
+					ids = append(ids, id)
+					undecoded = remaining
+				}
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+	allIDs := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	for _, test := range []struct {
+		name            string
+		table           string
+		maxStreamCount  int32
+		expectedStreams int
+		expectedIDs     []int64
+	}{
+		{name: "default stream count", table: "table_a", maxStreamCount: 0, expectedStreams: 1, expectedIDs: allIDs},
+		{name: "multiple streams", table: "table_a", maxStreamCount: 3, expectedStreams: 3, expectedIDs: allIDs},
+		{name: "more streams than rows", table: "table_a", maxStreamCount: 20, expectedStreams: 10, expectedIDs: allIDs},
+		{name: "empty table", table: "empty", maxStreamCount: 3, expectedStreams: 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			session := createReadSession(t, test.table, test.maxStreamCount)
+			if len(session.GetStreams()) != test.expectedStreams {
+				t.Fatalf("expected %d streams but got %d", test.expectedStreams, len(session.GetStreams()))
+			}
+			if diff := cmp.Diff(test.expectedIDs, readIDs(t, session, session.GetStreams()...)); diff != "" {
+				t.Errorf("(-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("split stream", func(t *testing.T) {
+		session := createReadSession(t, "table_a", 1)
+		original := session.GetStreams()[0]
+		split, err := bqReadClient.SplitReadStream(ctx, &storagepb.SplitReadStreamRequest{
+			Name:     original.Name,
+			Fraction: 0.3,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		primaryIDs := readIDs(t, session, split.GetPrimaryStream())
+		remainderIDs := readIDs(t, session, split.GetRemainderStream())
+		if len(primaryIDs) != 3 || len(remainderIDs) != 7 {
+			t.Fatalf("unexpected split %v and %v", primaryIDs, remainderIDs)
+		}
+		if diff := cmp.Diff(allIDs, readIDs(t, session, split.GetPrimaryStream(), split.GetRemainderStream())); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		// the original stream still reads all of its rows.
+		if diff := cmp.Diff(allIDs, readIDs(t, session, original)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("split single row stream", func(t *testing.T) {
+		session := createReadSession(t, "table_a", 10)
+		split, err := bqReadClient.SplitReadStream(ctx, &storagepb.SplitReadStreamRequest{
+			Name:     session.GetStreams()[0].Name,
+			Fraction: 0.5,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if split.GetPrimaryStream() != nil || split.GetRemainderStream() != nil {
+			t.Fatalf("expected no streams but got %v", split)
+		}
+	})
+}
+
+func TestStorageReadSnapshot(t *testing.T) {
+	const (
+		project = "test"
+		dataset = "dataset1"
+		table   = "table_a"
+	)
+	ctx := context.Background()
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	testServer := startTestServer(t, bqServer)
+	client := newTestClient(t, testServer, project)
+	opts, err := testServer.GRPCClientOptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bqReadClient, err := bqStorage.NewBigQueryReadClient(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bqReadClient.Close()
+
+	createReadSession := func(tableModifiers *storagepb.ReadSession_TableModifiers) (*storagepb.ReadSession, error) {
+		return bqReadClient.CreateReadSession(ctx, &storagepb.CreateReadSessionRequest{
+			Parent: fmt.Sprintf("projects/%s", project),
+			ReadSession: &storagepb.ReadSession{
+				Table:          fmt.Sprintf("projects/%s/datasets/%s/tables/%s", project, dataset, table),
+				DataFormat:     storagepb.DataFormat_AVRO,
+				ReadOptions:    &storagepb.ReadSession_TableReadOptions{SelectedFields: []string{"id"}},
+				TableModifiers: tableModifiers,
+			},
+			MaxStreamCount: 2,
+		}, rpcOpts)
+	}
+	readIDs := func(t *testing.T, session *storagepb.ReadSession) []int64 {
+		codec, err := goavro.NewCodec(session.GetAvroSchema().GetSchema())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, readStream := range session.GetStreams() {
+			stream, err := bqReadClient.ReadRows(ctx, &storagepb.ReadRowsRequest{ReadStream: readStream.Name}, rpcOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for {
+				res, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				undecoded := res.GetAvroRows().GetSerializedBinaryRows()
+				for len(undecoded) > 0 {
+					row, remaining, err := codec.NativeFromBinary(undecoded)
+					if err != nil {
+						t.Fatal(err)
+					}
+					ids = append(ids, row.(map[string]interface{})["id"].(int64))
+					undecoded = remaining
+				}
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+
+	session, err := createReadSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.GetTableModifiers().GetSnapshotTime() == nil {
+		t.Fatal("expected the snapshot time of the session")
+	}
+	job, err := client.Query("INSERT INTO dataset1.table_a (id, name) VALUES (3, 'carol'), (4, 'dave')").Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobStatus, err := job.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jobStatus.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the session created before the insertion doesn't read the inserted rows.
+	if diff := cmp.Diff([]int64{1, 2}, readIDs(t, session)); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+	after, err := createReadSession(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int64{1, 2, 3, 4}, readIDs(t, after)); diff != "" {
+		t.Errorf("(-want +got):\n%s", diff)
+	}
+
+	t.Run("snapshot time in the future", func(t *testing.T) {
+		_, err := createReadSession(&storagepb.ReadSession_TableModifiers{
+			SnapshotTime: timestamppb.New(time.Now().Add(time.Hour)),
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("expected InvalidArgument error but got %v", err)
+		}
+	})
+	t.Run("snapshot time in the past", func(t *testing.T) {
+		_, err := createReadSession(&storagepb.ReadSession_TableModifiers{
+			SnapshotTime: timestamppb.New(time.Now().Add(-time.Hour)),
+		})
+		if status.Code(err) != codes.Unimplemented {
+			t.Fatalf("expected Unimplemented error but got %v", err)
+		}
+	})
+	t.Run("snapshot time of now", func(t *testing.T) {
+		session, err := createReadSession(&storagepb.ReadSession_TableModifiers{
+			SnapshotTime: timestamppb.New(time.Now()),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]int64{1, 2, 3, 4}, readIDs(t, session)); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+}
+
 func processStream(t *testing.T, ctx context.Context, client *bqStorage.BigQueryReadClient, st string, ch chan<- *storagepb.ReadRowsResponse) error {
 	var offset int64
 
@@ -640,6 +1058,274 @@ func TestStorageWrite(t *testing.T) {
 	}
 }
 
+func TestStorageWriteReadOnly(t *testing.T) {
+	const (
+		projectID = "test"
+		datasetID = "dataset1"
+		tableID   = "table_a"
+	)
+
+	ctx := context.Background()
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	bqServer.SetReadOnly(true)
+	testServer := startTestServer(t, bqServer)
+	opts, err := testServer.GRPCClientOptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readClient, err := bqStorage.NewBigQueryReadClient(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readClient.Close()
+	if _, err := readClient.CreateReadSession(ctx, &storagepb.CreateReadSessionRequest{
+		Parent: fmt.Sprintf("projects/%s", projectID),
+		ReadSession: &storagepb.ReadSession{
+			Table:      fmt.Sprintf("projects/%s/datasets/%s/tables/%s", projectID, datasetID, tableID),
+			DataFormat: storagepb.DataFormat_AVRO,
+		},
+		MaxStreamCount: 1,
+	}, rpcOpts); err != nil {
+		t.Fatalf("CreateReadSession: %v", err)
+	}
+
+	writeClient, err := managedwriter.NewClient(ctx, projectID, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writeClient.Close()
+	_, err = writeClient.CreateWriteStream(ctx, &storagepb.CreateWriteStreamRequest{
+		Parent: fmt.Sprintf("projects/%s/datasets/%s/tables/%s", projectID, datasetID, tableID),
+		WriteStream: &storagepb.WriteStream{
+			Type: storagepb.WriteStream_COMMITTED,
+		},
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied but got %v", err)
+	}
+}
+
+func TestGRPCReflection(t *testing.T) {
+	listServices := func(t *testing.T, reflectionEnabled bool) ([]string, error) {
+		ctx := context.Background()
+		bqServer := newTestServer(t)
+		bqServer.SetGRPCReflection(reflectionEnabled)
+		testServer := startTestServer(t, bqServer)
+		conn, err := testServer.GRPCDial(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		}); err != nil {
+			return nil, err
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		var services []string
+		for _, service := range res.GetListServicesResponse().GetService() {
+			services = append(services, service.GetName())
+		}
+		sort.Strings(services)
+		return services, nil
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		services, err := listServices(t, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{
+			"google.cloud.bigquery.storage.v1.BigQueryRead",
+			"google.cloud.bigquery.storage.v1.BigQueryWrite",
+			"grpc.reflection.v1.ServerReflection",
+			"grpc.reflection.v1alpha.ServerReflection",
+		}
+		if diff := cmp.Diff(expected, services); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		_, err := listServices(t, false)
+		if status.Code(err) != codes.Unimplemented {
+			t.Fatalf("expected Unimplemented error but got %v", err)
+		}
+	})
+}
+
+func TestStorageErrorCodes(t *testing.T) {
+	const (
+		projectID = "test"
+		datasetID = "dataset1"
+		tableID   = "table_a"
+	)
+
+	ctx := context.Background()
+	bqServer := newTestServer(t, server.YAMLSource(filepath.Join("testdata", "data.yaml")))
+	testServer := startTestServer(t, bqServer)
+	opts, err := testServer.GRPCClientOptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readClient, err := bqStorage.NewBigQueryReadClient(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readClient.Close()
+	writeClient, err := bqStorage.NewBigQueryWriteClient(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writeClient.Close()
+
+	tablePath := fmt.Sprintf("projects/%s/datasets/%s/tables/%s", projectID, datasetID, tableID)
+	assertCode := func(t *testing.T, err error, code codes.Code, reason string) {
+		t.Helper()
+		st, ok := status.FromError(err)
+		if !ok || st.Code() != code {
+			t.Fatalf("expected %s error but got %v", code, err)
+		}
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok {
+				if info.GetReason() != reason {
+					t.Fatalf("expected the reason %s but got %s", reason, info.GetReason())
+				}
+				return
+			}
+		}
+		t.Fatalf("expected the ErrorInfo detail in %v", err)
+	}
+	createReadSession := func(table, rowRestriction string) (*storagepb.ReadSession, error) {
+		return readClient.CreateReadSession(ctx, &storagepb.CreateReadSessionRequest{
+			Parent: fmt.Sprintf("projects/%s", projectID),
+			ReadSession: &storagepb.ReadSession{
+				Table:       table,
+				DataFormat:  storagepb.DataFormat_AVRO,
+				ReadOptions: &storagepb.ReadSession_TableReadOptions{RowRestriction: rowRestriction},
+			},
+			MaxStreamCount: 1,
+		}, rpcOpts)
+	}
+	createWriteStream := func() (*storagepb.WriteStream, error) {
+		return writeClient.CreateWriteStream(ctx, &storagepb.CreateWriteStreamRequest{
+			Parent:      tablePath,
+			WriteStream: &storagepb.WriteStream{Type: storagepb.WriteStream_PENDING},
+		})
+	}
+	descriptorProto, err := adapt.NormalizeDescriptor((&exampleproto.SampleData{}).ProtoReflect().Descriptor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRows := func(streamName string, offset int64) (*storagepb.AppendRowsResponse, error) {
+		rows, err := generateExampleMessages(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := writeClient.AppendRows(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Send(&storagepb.AppendRowsRequest{
+			WriteStream: streamName,
+			Offset:      wrapperspb.Int64(offset),
+			Rows: &storagepb.AppendRowsRequest_ProtoRows{
+				ProtoRows: &storagepb.AppendRowsRequest_ProtoData{
+					WriterSchema: &storagepb.ProtoSchema{ProtoDescriptor: descriptorProto},
+					Rows:         &storagepb.ProtoRows{SerializedRows: rows},
+				},
+			},
+		}); err != nil {
+			return nil, err
+		}
+		if err := stream.CloseSend(); err != nil {
+			return nil, err
+		}
+		return stream.Recv()
+	}
+
+	t.Run("read missing table", func(t *testing.T) {
+		_, err := createReadSession(fmt.Sprintf("projects/%s/datasets/%s/tables/unknown", projectID, datasetID), "")
+		assertCode(t, err, codes.NotFound, "TABLE_NOT_FOUND")
+	})
+	t.Run("read invalid table path", func(t *testing.T) {
+		_, err := createReadSession(fmt.Sprintf("projects/%s/datasets/%s", projectID, datasetID), "")
+		assertCode(t, err, codes.InvalidArgument, "INVALID_RESOURCE_NAME")
+	})
+	t.Run("read invalid row restriction", func(t *testing.T) {
+		_, err := createReadSession(tablePath, "unknown_column = 1")
+		assertCode(t, err, codes.InvalidArgument, "INVALID_ARGUMENT")
+	})
+	t.Run("read deleted session", func(t *testing.T) {
+		session, err := createReadSession(tablePath, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := readClient.ReadRows(ctx, &storagepb.ReadRowsRequest{
+			ReadStream: fmt.Sprintf("%s/streams/unknown", session.GetName()),
+		}, rpcOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = stream.Recv()
+		assertCode(t, err, codes.NotFound, "STREAM_NOT_FOUND")
+	})
+	t.Run("write missing table", func(t *testing.T) {
+		_, err := writeClient.CreateWriteStream(ctx, &storagepb.CreateWriteStreamRequest{
+			Parent:      fmt.Sprintf("projects/%s/datasets/%s/tables/unknown", projectID, datasetID),
+			WriteStream: &storagepb.WriteStream{Type: storagepb.WriteStream_PENDING},
+		})
+		assertCode(t, err, codes.NotFound, "TABLE_NOT_FOUND")
+	})
+	t.Run("append to missing stream", func(t *testing.T) {
+		_, err := appendRows(fmt.Sprintf("%s/streams/unknown", tablePath), 0)
+		assertCode(t, err, codes.NotFound, "STREAM_NOT_FOUND")
+	})
+	t.Run("finalize missing stream", func(t *testing.T) {
+		_, err := writeClient.FinalizeWriteStream(ctx, &storagepb.FinalizeWriteStreamRequest{
+			Name: fmt.Sprintf("%s/streams/unknown", tablePath),
+		})
+		assertCode(t, err, codes.NotFound, "STREAM_NOT_FOUND")
+	})
+	t.Run("append offsets and finalized stream", func(t *testing.T) {
+		writeStream, err := createWriteStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := appendRows(writeStream.GetName(), 0); err != nil {
+			t.Fatal(err)
+		}
+		_, err = appendRows(writeStream.GetName(), 0)
+		assertCode(t, err, codes.AlreadyExists, "OFFSET_ALREADY_EXISTS")
+		_, err = appendRows(writeStream.GetName(), 2)
+		assertCode(t, err, codes.OutOfRange, "OFFSET_OUT_OF_RANGE")
+		if _, err := writeClient.FinalizeWriteStream(ctx, &storagepb.FinalizeWriteStreamRequest{
+			Name: writeStream.GetName(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		_, err = appendRows(writeStream.GetName(), 1)
+		assertCode(t, err, codes.FailedPrecondition, "STREAM_FINALIZED")
+	})
+	t.Run("too many write streams", func(t *testing.T) {
+		bqServer.SetMaxWriteStreams(1)
+		defer bqServer.SetMaxWriteStreams(0)
+		if _, err := createWriteStream(); err != nil {
+			t.Fatal(err)
+		}
+		_, err := createWriteStream()
+		assertCode(t, err, codes.ResourceExhausted, "TOO_MANY_STREAMS")
+	})
+}
+
 func countRows(t *testing.T, iter *bigquery.RowIterator) int {
 	var resultRowCount int
 	for {
@@ -721,3 +1407,220 @@ func generateExampleMessages(numMessages int) ([][]byte, error) {
 	}
 	return msgs, nil
 }
+
+func TestEphemeralPorts(t *testing.T) {
+	ctx := context.Background()
+
+	newServer := func(t *testing.T) *server.Server {
+		bqServer, err := server.New(server.TempStorage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bqServer.Load(server.StructSource(types.NewProject("test"))); err != nil {
+			t.Fatal(err)
+		}
+		if err := bqServer.Listen("127.0.0.1:0", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- bqServer.Serve(ctx, "", "")
+		}()
+		t.Cleanup(func() {
+			if err := bqServer.Stop(ctx); err != nil {
+				t.Error(err)
+			}
+			<-done
+		})
+		return bqServer
+	}
+	servers := []*server.Server{newServer(t), newServer(t)}
+	addrs := map[string]struct{}{}
+	for _, bqServer := range servers {
+		for _, addr := range []string{bqServer.HTTPAddr(), bqServer.GRPCAddr()} {
+			if strings.HasSuffix(addr, ":0") || addr == "" {
+				t.Fatalf("expected the bound address but got %q", addr)
+			}
+			if _, exists := addrs[addr]; exists {
+				t.Fatalf("address %s is used by multiple servers", addr)
+			}
+			addrs[addr] = struct{}{}
+		}
+	}
+	for _, bqServer := range servers {
+		client, err := bigquery.NewClient(
+			ctx,
+			"test",
+			option.WithEndpoint("http://"+bqServer.HTTPAddr()),
+			option.WithoutAuthentication(),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := client.Query("SELECT 1").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff([]bigquery.Value{int64(1)}, row); diff != "" {
+			t.Errorf("(-want +got):\n%s", diff)
+		}
+		client.Close()
+
+		conn, err := grpc.DialContext(ctx, bqServer.GRPCAddr(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		readClient, err := bqStorage.NewBigQueryReadClient(ctx, option.WithGRPCConn(conn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = readClient.CreateReadSession(ctx, &storagepb.CreateReadSessionRequest{
+			Parent: "projects/test",
+			ReadSession: &storagepb.ReadSession{
+				Table:      "projects/test/datasets/unknown/tables/unknown",
+				DataFormat: storagepb.DataFormat_AVRO,
+			},
+		})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("expected NotFound error from the gRPC server but got %v", err)
+		}
+		readClient.Close()
+	}
+}
+
+func TestAddrWhileServing(t *testing.T) {
+	ctx := context.Background()
+
+	bqServer, err := server.New(server.TempStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bqServer.Load(server.StructSource(types.NewProject("test"))); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		// Serve binds the listeners while the addresses are read below.
+		done <- bqServer.Serve(ctx, "127.0.0.1:0", "127.0.0.1:0")
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for bqServer.HTTPAddr() == "" || bqServer.GRPCAddr() == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("the listeners are not bound")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := bqServer.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+func TestInProcessServer(t *testing.T) {
+	ctx := context.Background()
+
+	newServer := func(t *testing.T) (*server.Server, *server.InProcessServer) {
+		t.Helper()
+		bqServer, err := server.New(server.TempStorage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bqServer.Load(server.YAMLSource(filepath.Join("testdata", "data.yaml"))); err != nil {
+			t.Fatal(err)
+		}
+		return bqServer, bqServer.InProcessServer()
+	}
+	bqServer1, inProcess1 := newServer(t)
+	bqServer2, inProcess2 := newServer(t)
+	defer bqServer2.Stop(ctx)
+
+	client1, err := bigquery.NewClient(ctx, "test", inProcess1.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client1.Close()
+	client2, err := bigquery.NewClient(ctx, "test", inProcess2.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+
+	// the servers run concurrently and don't share the data.
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, c := range []*bigquery.Client{client1, client2} {
+		wg.Add(1)
+		go func(c *bigquery.Client) {
+			defer wg.Done()
+			_, err := c.Query("CREATE TABLE dataset1.created AS SELECT id FROM dataset1.table_a").Read(ctx)
+			errs <- err
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client1.Query("INSERT INTO dataset1.created (id) VALUES (10)").Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	count := func(t *testing.T, c *bigquery.Client) int64 {
+		t.Helper()
+		it, err := c.Query("SELECT COUNT(*) FROM dataset1.created").Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			t.Fatal(err)
+		}
+		return row[0].(int64)
+	}
+	if n := count(t, client1); n != 3 {
+		t.Fatalf("unexpected rows of the first server: %d", n)
+	}
+	if n := count(t, client2); n != 2 {
+		t.Fatalf("unexpected rows of the second server: %d", n)
+	}
+
+	// the gRPC API reads the table created by the REST API.
+	opts, err := inProcess1.GRPCClientOptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readClient, err := bqStorage.NewBigQueryReadClient(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readClient.Close()
+	session, err := readClient.CreateReadSession(ctx, &storagepb.CreateReadSessionRequest{
+		Parent: "projects/test",
+		ReadSession: &storagepb.ReadSession{
+			Table:      "projects/test/datasets/dataset1/tables/created",
+			DataFormat: storagepb.DataFormat_ARROW,
+		},
+		MaxStreamCount: 1,
+	}, rpcOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.GetStreams()) == 0 {
+		t.Fatal("no streams in the session")
+	}
+
+	if err := bqServer1.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the client retries the unavailable server until the deadline.
+	stoppedCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if _, err := client1.Query("SELECT 1").Read(stoppedCtx); err == nil {
+		t.Fatal("expected error after the server stopped")
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	bigqueryv2 "google.golang.org/api/bigquery/v2"
+
+	"github.com/goccy/bigquery-emulator/internal/connection"
+	"github.com/goccy/bigquery-emulator/internal/contentdata"
+	"github.com/goccy/bigquery-emulator/internal/metadata"
+)
+
+const (
+	// anyTypeArgumentKind is the argument kind of the templated argument declared as ANY TYPE.
+	anyTypeArgumentKind = "ANY_TYPE"
+	// fixedTableArgumentKind is the argument kind of the table argument declared as TABLE<...>.
+	// Its columns are stored as the fields of the STRUCT data type.
+	fixedTableArgumentKind = "FIXED_TABLE"
+)
+
+// execTableFunctionStatement evaluates CREATE TABLE FUNCTION and DROP TABLE FUNCTION.
+// The function is stored as the routine of TABLE_VALUED_FUNCTION, and its calls are expanded by expandTableFunctions.
+func (s *Server) execTableFunctionStatement(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID string, stmt *contentdata.TableFunctionStatement) error {
+	path := stmt.FunctionPath
+	if len(path) == 3 && path[0] != project.ID {
+		p, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), path[0])
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			return fmt.Errorf("project %s is not found", path[0])
+		}
+		project = p
+	}
+	routineID := path[len(path)-1]
+	if len(path) >= 2 {
+		datasetID = path[len(path)-2]
+	}
+	if datasetID == "" {
+		return fmt.Errorf("Table function %q must be qualified with a dataset (e.g. dataset.function)", routineID)
+	}
+	dataset := project.Dataset(datasetID)
+	if dataset == nil {
+		return errNotFound(fmt.Sprintf("Not found: Dataset %s:%s", project.ID, datasetID))
+	}
+	routine := dataset.Routine(routineID)
+	if stmt.Drop {
+		if routine == nil {
+			if stmt.IfExists {
+				return nil
+			}
+			return errNotFound(fmt.Sprintf("Not found: Function %s:%s.%s", project.ID, datasetID, routineID))
+		}
+		return dataset.DeleteRoutine(ctx, tx.Tx(), routineID)
+	}
+	if routine != nil {
+		switch {
+		case stmt.IfNotExists:
+			return nil
+		case !stmt.OrReplace:
+			return errDuplicate(fmt.Sprintf("Already Exists: Function %s:%s.%s", project.ID, datasetID, routineID))
+		}
+	}
+	content, err := tableFunctionRoutine(project.ID, datasetID, routineID, stmt)
+	if err != nil {
+		return err
+	}
+	if routine != nil {
+		if err := dataset.DeleteRoutine(ctx, tx.Tx(), routineID); err != nil {
+			return err
+		}
+	}
+	return s.addTableFunction(ctx, tx, project, dataset, content)
+}
+
+// addTableFunction stores the routine of TABLE_VALUED_FUNCTION in the metadata of dataset.
+func (s *Server) addTableFunction(ctx context.Context, tx *connection.Tx, project *metadata.Project, dataset *metadata.Dataset, content *bigqueryv2.Routine) error {
+	routineID := content.RoutineReference.RoutineId
+	if content.DefinitionBody == "" {
+		return fmt.Errorf("invalid body: missing function body")
+	}
+	newRoutine, err := metadata.NewRoutineWithContent(s.metaRepo, project.ID, dataset.ID, routineID, content)
+	if err != nil {
+		return err
+	}
+	if err := dataset.AddRoutine(ctx, tx.Tx(), newRoutine); err != nil {
+		if errors.Is(err, metadata.ErrDuplicatedRoutine) {
+			return errDuplicate(fmt.Sprintf("Already Exists: Function %s:%s.%s", project.ID, dataset.ID, routineID))
+		}
+		return err
+	}
+	return nil
+}
+
+// tableFunctionRoutine creates the routine resource of CREATE TABLE FUNCTION statement.
+func tableFunctionRoutine(projectID, datasetID, routineID string, stmt *contentdata.TableFunctionStatement) (*bigqueryv2.Routine, error) {
+	now := time.Now().UnixMilli()
+	routine := &bigqueryv2.Routine{
+		RoutineReference: &bigqueryv2.RoutineReference{
+			ProjectId: projectID,
+			DatasetId: datasetID,
+			RoutineId: routineID,
+		},
+		RoutineType:      string(contentdata.TableValuedFunctionType),
+		Language:         string(contentdata.LanguageTypeSQL),
+		DefinitionBody:   stmt.Query,
+		CreationTime:     now,
+		LastModifiedTime: now,
+	}
+	for _, arg := range stmt.Arguments {
+		routineArg := &bigqueryv2.Argument{Name: arg.Name}
+		switch {
+		case arg.IsAnyType():
+			routineArg.ArgumentKind = anyTypeArgumentKind
+		case arg.IsTable():
+			routineArg.ArgumentKind = fixedTableArgumentKind
+			// TABLE<x INT64> is parsed as STRUCT<x INT64>.
+			dataType, err := contentdata.StandardSQLDataType("STRUCT" + strings.TrimSpace(arg.Type)[len("TABLE"):])
+			if err != nil {
+				return nil, fmt.Errorf("invalid type of argument %s: %w", arg.Name, err)
+			}
+			routineArg.DataType = dataType
+		default:
+			dataType, err := contentdata.StandardSQLDataType(arg.Type)
+			if err != nil {
+				return nil, fmt.Errorf("invalid type of argument %s: %w", arg.Name, err)
+			}
+			routineArg.DataType = dataType
+		}
+		routine.Arguments = append(routine.Arguments, routineArg)
+	}
+	if len(stmt.Returns) != 0 {
+		tableType := &bigqueryv2.StandardSqlTableType{}
+		for _, column := range stmt.Returns {
+			dataType, err := contentdata.StandardSQLDataType(column.Type)
+			if err != nil {
+				return nil, fmt.Errorf("invalid type of column %s: %w", column.Name, err)
+			}
+			tableType.Columns = append(tableType.Columns, &bigqueryv2.StandardSqlField{Name: column.Name, Type: dataType})
+		}
+		routine.ReturnTableType = tableType
+	}
+	return routine, nil
+}
+
+// expandTableFunctions expands the calls of the table functions stored in the metadata into the subqueries.
+// The name of the function is resolved in the same way as the table name.
+func (s *Server) expandTableFunctions(ctx context.Context, tx *connection.Tx, project *metadata.Project, datasetID, query string) (string, error) {
+	return contentdata.ExpandTableFunctions(query, func(path []string) (*contentdata.TableFunction, error) {
+		if len(path) > 3 {
+			return nil, nil
+		}
+		p := project
+		if len(path) == 3 && path[0] != project.ID {
+			found, err := s.metaRepo.FindProjectWithConn(ctx, tx.Tx(), path[0])
+			if err != nil {
+				return nil, err
+			}
+			if found == nil {
+				return nil, nil
+			}
+			p = found
+		}
+		dsID := datasetID
+		if len(path) >= 2 {
+			dsID = path[len(path)-2]
+		}
+		dataset := p.Dataset(dsID)
+		if dataset == nil {
+			return nil, nil
+		}
+		routine := dataset.Routine(path[len(path)-1])
+		if routine == nil {
+			return nil, nil
+		}
+		content, err := routine.Content()
+		if err != nil {
+			return nil, err
+		}
+		if content.RoutineType != string(contentdata.TableValuedFunctionType) {
+			return nil, nil
+		}
+		return tableFunctionFromRoutine(p.ID, dataset.ID, content), nil
+	})
+}
+
+// tableFunctionFromRoutine returns the definition of the table function stored as the routine.
+func tableFunctionFromRoutine(projectID, datasetID string, routine *bigqueryv2.Routine) *contentdata.TableFunction {
+	fn := &contentdata.TableFunction{
+		Name:  fmt.Sprintf("%s.%s.%s", projectID, datasetID, routine.RoutineReference.RoutineId),
+		Query: routine.DefinitionBody,
+	}
+	for _, arg := range routine.Arguments {
+		var typ string
+		switch arg.ArgumentKind {
+		case anyTypeArgumentKind:
+			typ = "ANY TYPE"
+		case fixedTableArgumentKind:
+			typ = "TABLE" + strings.TrimPrefix(contentdata.StandardSQLTypeName(arg.DataType), "STRUCT")
+		default:
+			typ = contentdata.StandardSQLTypeName(arg.DataType)
+		}
+		fn.Arguments = append(fn.Arguments, &contentdata.TableFunctionArgument{Name: arg.Name, Type: typ})
+	}
+	if routine.ReturnTableType != nil {
+		for _, column := range routine.ReturnTableType.Columns {
+			fn.Returns = append(fn.Returns, &contentdata.TableFunctionArgument{
+				Name: column.Name,
+				Type: contentdata.StandardSQLTypeName(column.Type),
+			})
+		}
+	}
+	return fn
+}
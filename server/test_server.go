@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"net"
+	"net/http"
 	"net/http/httptest"
 
 	"google.golang.org/api/option"
@@ -17,40 +18,116 @@ type TestServer struct {
 }
 
 func (s *TestServer) GRPCDial(ctx context.Context) (*grpc.ClientConn, error) {
-	return grpc.DialContext(
-		ctx,
-		s.URL,
-		s.DialerOption,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
+	return grpcDial(ctx, s.URL, s.DialerOption)
 }
 
 func (s *TestServer) GRPCClientOptions(ctx context.Context) ([]option.ClientOption, error) {
-	conn, err := s.GRPCDial(ctx)
-	if err != nil {
-		return nil, err
-	}
-	return []option.ClientOption{
-		option.WithGRPCConn(conn),
-		option.WithEndpoint(s.URL),
-		option.WithoutAuthentication(),
-	}, nil
+	return grpcClientOptions(ctx, s.URL, s.DialerOption)
 }
 
 func (s *Server) TestServer() *TestServer {
 	server := httptest.NewServer(s.Handler)
 	s.httpServer = server.Config
+	testServer := &TestServer{Server: server}
+	testServer.DialerOption = s.serveGRPCInMemory()
+	s.ready.Store(true)
+	return testServer
+}
+
+// inProcessURL is the endpoint of InProcessServer. The host is never resolved.
+const inProcessURL = "http://bigquery-emulator.in-process"
+
+// InProcessServer serves the REST API and the gRPC API of the server in memory without binding any port.
+// The REST requests of Client are passed to the handler of the server directly,
+// and the gRPC connections are made over the in-memory listener.
+// Both APIs share the storage of the server, and the servers created by the different Server don't share anything.
+type InProcessServer struct {
+	// URL is the endpoint of the REST API for Client.
+	URL string
+	// Client sends the requests to the handler of the server in process.
+	Client       *http.Client
+	DialerOption grpc.DialOption
+}
+
+// InProcessServer starts serving the server in process. Stop of the server waits for the in-flight requests
+// and stops the gRPC server, and the requests after Stop fail.
+func (s *Server) InProcessServer() *InProcessServer {
+	s.httpServer = &http.Server{Addr: inProcessURL, Handler: s.Handler}
+	server := &InProcessServer{
+		URL:          inProcessURL,
+		Client:       &http.Client{Transport: &handlerTransport{handler: s.Handler}},
+		DialerOption: s.serveGRPCInMemory(),
+	}
+	s.ready.Store(true)
+	return server
+}
+
+// ClientOptions returns the options of the REST clients like bigquery.NewClient to call the server in process.
+func (s *InProcessServer) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithHTTPClient(s.Client),
+		option.WithEndpoint(s.URL),
+	}
+}
 
+func (s *InProcessServer) GRPCDial(ctx context.Context) (*grpc.ClientConn, error) {
+	return grpcDial(ctx, s.URL, s.DialerOption)
+}
+
+func (s *InProcessServer) GRPCClientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	return grpcClientOptions(ctx, s.URL, s.DialerOption)
+}
+
+// handlerTransport is the http.RoundTripper calling the handler in process.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	r.Host = r.URL.Host
+	r.RequestURI = r.URL.RequestURI()
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, r)
+	res := recorder.Result()
+	res.Request = req
+	return res, nil
+}
+
+// serveGRPCInMemory serves the gRPC server on the in-memory listener and returns the option dialing it.
+func (s *Server) serveGRPCInMemory() grpc.DialOption {
 	grpcListener := bufconn.Listen(1024 * 1024)
-	grpcServer := grpc.NewServer()
+	grpcServer := s.newGRPCServer()
 	registerStorageServer(grpcServer, s)
 	s.grpcServer = grpcServer
 	go func() {
 		_ = grpcServer.Serve(grpcListener)
 	}()
-	testServer := &TestServer{Server: server}
-	testServer.DialerOption = grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
-		return grpcListener.Dial()
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return grpcListener.DialContext(ctx)
 	})
-	return testServer
+}
+
+func grpcDial(ctx context.Context, target string, dialer grpc.DialOption) (*grpc.ClientConn, error) {
+	return grpc.DialContext(
+		ctx,
+		target,
+		dialer,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+}
+
+func grpcClientOptions(ctx context.Context, target string, dialer grpc.DialOption) ([]option.ClientOption, error) {
+	conn, err := grpcDial(ctx, target, dialer)
+	if err != nil {
+		return nil, err
+	}
+	return []option.ClientOption{
+		option.WithGRPCConn(conn),
+		option.WithEndpoint(target),
+		option.WithoutAuthentication(),
+	}, nil
 }
@@ -3,6 +3,7 @@ package types
 import (
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,10 +20,14 @@ type Project struct {
 }
 
 type Dataset struct {
-	ID       string     `yaml:"id" validate:"required"`
-	Tables   []*Table   `yaml:"tables"`
-	Models   []*Model   `yaml:"models"`
-	Routines []*Routine `yaml:"routines"`
+	ID       string            `yaml:"id" validate:"required"`
+	Location string            `yaml:"location"`
+	Labels   map[string]string `yaml:"labels"`
+	// DefaultTableExpirationMs is the default lifetime of the tables created in the dataset in milliseconds.
+	DefaultTableExpirationMs int64      `yaml:"defaultTableExpirationMs" validate:"gte=0"`
+	Tables                   []*Table   `yaml:"tables"`
+	Models                   []*Model   `yaml:"models"`
+	Routines                 []*Routine `yaml:"routines"`
 }
 
 type Table struct {
@@ -324,6 +329,9 @@ func (t Type) FieldType() FieldType {
 	return ""
 }
 
+// TableFieldSchemaFromZetaSQLType returns the field of the value of type t.
+// ARRAY is the REPEATED field and the others are NULLABLE, because the values of the query result can be NULL
+// even if they come from REQUIRED columns, like the columns of the outer join.
 func TableFieldSchemaFromZetaSQLType(name string, t types.Type) *bigqueryv2.TableFieldSchema {
 	kind := t.Kind()
 	typ := string(TypeFromKind(int(kind)).FieldType())
@@ -349,11 +357,13 @@ func TableFieldSchemaFromZetaSQLType(name string, t types.Type) *bigqueryv2.Tabl
 			Name:   name,
 			Type:   typ,
 			Fields: fields,
+			Mode:   string(NullableMode),
 		}
 	}
 	return &bigqueryv2.TableFieldSchema{
 		Name: name,
 		Type: typ,
+		Mode: string(NullableMode),
 	}
 }
 
@@ -551,6 +561,7 @@ func normalizeData(v interface{}, field *bigqueryv2.TableFieldSchema) (interface
 		values := make([]interface{}, 0, rv.Len())
 		for i := 0; i < rv.Len(); i++ {
 			value, err := normalizeData(rv.Index(i).Interface(), &bigqueryv2.TableFieldSchema{
+				Type:   field.Type,
 				Fields: field.Fields,
 			})
 			if err != nil {
@@ -588,5 +599,13 @@ func normalizeData(v interface{}, field *bigqueryv2.TableFieldSchema) (interface
 		}
 		return fields, nil
 	}
+	if s, ok := v.(string); ok && Type(field.Type).FieldType() == FieldFloat {
+		// FLOAT64 values are encoded as the strings like "1.5", "NaN", "Infinity" and "-Infinity" in JSON.
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FLOAT64 value %q for the field %s", s, field.Name)
+		}
+		return f, nil
+	}
 	return v, nil
 }